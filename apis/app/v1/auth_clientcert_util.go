@@ -0,0 +1,63 @@
+/*
+
+Copyright 2020,2021 Avi Zimmerman
+
+This file is part of kvdi.
+
+kvdi is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+kvdi is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package v1
+
+import "encoding/base64"
+
+// IsUsingClientCertAuth returns true if the cluster is using the client certificate
+// authentication driver.
+func (c *VDICluster) IsUsingClientCertAuth() bool {
+	if c.Spec.Auth != nil {
+		return c.Spec.Auth.ClientCertAuth != nil && c.Spec.Auth.ClientCertAuth.CACertificates != ""
+	}
+	return false
+}
+
+// GetClientCertCACertificates returns the PEM encoded certificate authorities client
+// certificates must be issued by. The value is base64 decoded and returned to the caller.
+func (c *VDICluster) GetClientCertCACertificates() ([]byte, error) {
+	if c.Spec.Auth != nil && c.Spec.Auth.ClientCertAuth != nil {
+		if c.Spec.Auth.ClientCertAuth.CACertificates != "" {
+			return base64.StdEncoding.DecodeString(c.Spec.Auth.ClientCertAuth.CACertificates)
+		}
+	}
+	return nil, nil
+}
+
+// GetClientCertUsernameSource returns where in a client certificate the username is read
+// from. It defaults to the subject common name.
+func (c *VDICluster) GetClientCertUsernameSource() ClientCertUsernameSource {
+	if c.Spec.Auth != nil && c.Spec.Auth.ClientCertAuth != nil {
+		if c.Spec.Auth.ClientCertAuth.UsernameFrom != "" {
+			return c.Spec.Auth.ClientCertAuth.UsernameFrom
+		}
+	}
+	return ClientCertUsernameFromCommonName
+}
+
+// GetClientCertAdminGroups returns the organizational units that will map to administrator access.
+func (c *VDICluster) GetClientCertAdminGroups() []string {
+	if c.Spec.Auth != nil && c.Spec.Auth.ClientCertAuth != nil {
+		return c.Spec.Auth.ClientCertAuth.AdminGroups
+	}
+	return []string{}
+}
@@ -22,6 +22,15 @@ package v1
 import (
 	"encoding/base64"
 	"strings"
+	"time"
+)
+
+const (
+	defaultLDAPGroupSearchFilter     = "(member=%s)"
+	defaultLDAPMaxGroupDepth         = 10
+	defaultLDAPMaxConnections        = 10
+	defaultLDAPConnectionIdleTimeout = time.Duration(5) * time.Minute
+	defaultLDAPUserCacheTTL          = time.Duration(1) * time.Minute
 )
 
 // IsUsingLDAPAuth returns true if the cluster is using the ldap authentication
@@ -159,3 +168,75 @@ func (c *VDICluster) GetLDAPDoUserStatusCheck() bool {
 	}
 	return false
 }
+
+// GetLDAPGroupSearchBase returns the base DN to use when searching for the groups of a user.
+// When empty, groups are read from the user attribute returned by GetLDAPUserGroupsAttribute.
+func (c *VDICluster) GetLDAPGroupSearchBase() string {
+	if c.Spec.Auth != nil && c.Spec.Auth.LDAPAuth != nil {
+		return c.Spec.Auth.LDAPAuth.GroupSearchBase
+	}
+	return ""
+}
+
+// GetLDAPGroupSearchFilter returns the filter to use when searching for the groups of a user.
+func (c *VDICluster) GetLDAPGroupSearchFilter() string {
+	if c.Spec.Auth != nil && c.Spec.Auth.LDAPAuth != nil {
+		if c.Spec.Auth.LDAPAuth.GroupSearchFilter != "" {
+			return c.Spec.Auth.LDAPAuth.GroupSearchFilter
+		}
+	}
+	return defaultLDAPGroupSearchFilter
+}
+
+// GetLDAPNestedGroups returns how groups that are members of other groups should be resolved.
+func (c *VDICluster) GetLDAPNestedGroups() LDAPNestedGroups {
+	if c.Spec.Auth != nil && c.Spec.Auth.LDAPAuth != nil {
+		if c.Spec.Auth.LDAPAuth.NestedGroups != "" {
+			return c.Spec.Auth.LDAPAuth.NestedGroups
+		}
+	}
+	return LDAPNestedGroupsNone
+}
+
+// GetLDAPMaxGroupDepth returns the maximum levels of parent groups to follow when resolving
+// nested groups recursively.
+func (c *VDICluster) GetLDAPMaxGroupDepth() int {
+	if c.Spec.Auth != nil && c.Spec.Auth.LDAPAuth != nil {
+		if c.Spec.Auth.LDAPAuth.MaxGroupDepth > 0 {
+			return c.Spec.Auth.LDAPAuth.MaxGroupDepth
+		}
+	}
+	return defaultLDAPMaxGroupDepth
+}
+
+// GetLDAPMaxConnections returns the maximum number of connections to keep open to the LDAP server.
+func (c *VDICluster) GetLDAPMaxConnections() int {
+	if c.Spec.Auth != nil && c.Spec.Auth.LDAPAuth != nil {
+		if c.Spec.Auth.LDAPAuth.MaxConnections > 0 {
+			return c.Spec.Auth.LDAPAuth.MaxConnections
+		}
+	}
+	return defaultLDAPMaxConnections
+}
+
+// GetLDAPConnectionIdleTimeout returns how long a pooled LDAP connection can sit unused before
+// it is closed. If the duration cannot be parsed, the default is returned.
+func (c *VDICluster) GetLDAPConnectionIdleTimeout() time.Duration {
+	if c.Spec.Auth != nil && c.Spec.Auth.LDAPAuth != nil && c.Spec.Auth.LDAPAuth.ConnectionIdleTimeout != "" {
+		if duration, err := time.ParseDuration(c.Spec.Auth.LDAPAuth.ConnectionIdleTimeout); err == nil && duration > 0 {
+			return duration
+		}
+	}
+	return defaultLDAPConnectionIdleTimeout
+}
+
+// GetLDAPUserCacheTTL returns how long the list of LDAP users is cached. A zero duration
+// disables the cache. If the duration cannot be parsed, the default is returned.
+func (c *VDICluster) GetLDAPUserCacheTTL() time.Duration {
+	if c.Spec.Auth != nil && c.Spec.Auth.LDAPAuth != nil && c.Spec.Auth.LDAPAuth.UserCacheTTL != "" {
+		if duration, err := time.ParseDuration(c.Spec.Auth.LDAPAuth.UserCacheTTL); err == nil && duration >= 0 {
+			return duration
+		}
+	}
+	return defaultLDAPUserCacheTTL
+}
@@ -0,0 +1,44 @@
+/*
+
+Copyright 2020,2021 Avi Zimmerman
+
+This file is part of kvdi.
+
+kvdi is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+kvdi is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package v1
+
+import "time"
+
+// GetPasswordPolicy returns the rules applied to the passwords of local users. An
+// empty policy is returned when none is configured.
+func (c *VDICluster) GetPasswordPolicy() *PasswordPolicy {
+	if c.Spec.Auth != nil && c.Spec.Auth.LocalAuth != nil && c.Spec.Auth.LocalAuth.PasswordPolicy != nil {
+		return c.Spec.Auth.LocalAuth.PasswordPolicy
+	}
+	return &PasswordPolicy{}
+}
+
+// GetMaxAge returns how long a password is valid for. Zero means passwords do not
+// expire, which is also returned if the duration cannot be parsed.
+func (p *PasswordPolicy) GetMaxAge() time.Duration {
+	if p.MaxAge != "" {
+		if duration, err := time.ParseDuration(p.MaxAge); err == nil && duration > 0 {
+			return duration
+		}
+	}
+	return 0
+}
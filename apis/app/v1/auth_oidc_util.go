@@ -121,6 +121,15 @@ func (c *VDICluster) GetOIDCRedirectURL() string {
 	return ""
 }
 
+// GetOIDCPostLogoutRedirectURL returns where the OpenID provider should send users after
+// they log out.
+func (c *VDICluster) GetOIDCPostLogoutRedirectURL() string {
+	if c.Spec.Auth != nil && c.Spec.Auth.OIDCAuth != nil {
+		return c.Spec.Auth.OIDCAuth.PostLogoutRedirectURL
+	}
+	return ""
+}
+
 // AllowNonGroupedReadOnly returns true if non-grouped users from the OpenID provider should
 // be allowed read-only access to kVDI.
 func (c *VDICluster) AllowNonGroupedReadOnly() bool {
@@ -130,8 +139,8 @@ func (c *VDICluster) AllowNonGroupedReadOnly() bool {
 	return false
 }
 
-// PreserveOIDCTokens returns whether OIDC tokens should be preserved and stored in the kvdi claims
-// for the user.
+// PreserveOIDCTokens returns whether OIDC tokens should be preserved for the user. The access token
+// is stored in the kvdi claims and the refresh token in the secrets backend.
 func (c *VDICluster) PreserveOIDCTokens() bool {
 	if c.Spec.Auth != nil && c.Spec.Auth.OIDCAuth != nil {
 		return c.Spec.Auth.OIDCAuth.PreserveTokens
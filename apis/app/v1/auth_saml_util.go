@@ -0,0 +1,144 @@
+/*
+
+Copyright 2020,2021 Avi Zimmerman
+
+This file is part of kvdi.
+
+kvdi is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+kvdi is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package v1
+
+import (
+	"encoding/base64"
+	"strings"
+)
+
+// IsUsingSAMLAuth returns true if the cluster is using the saml authentication
+// driver.
+func (c *VDICluster) IsUsingSAMLAuth() bool {
+	if c.Spec.Auth != nil {
+		if c.Spec.Auth.SAMLAuth != nil && !c.Spec.Auth.SAMLAuth.IsUndefined() {
+			return true
+		}
+	}
+	return false
+}
+
+// GetSAMLACSURL returns the assertion consumer service URL for the SAML service provider.
+func (c *VDICluster) GetSAMLACSURL() string {
+	if c.Spec.Auth != nil && c.Spec.Auth.SAMLAuth != nil {
+		return c.Spec.Auth.SAMLAuth.ACSURL
+	}
+	return ""
+}
+
+// GetSAMLEntityID returns the entity ID of the SAML service provider. It defaults to
+// the URL of the service provider metadata.
+func (c *VDICluster) GetSAMLEntityID() string {
+	if c.Spec.Auth != nil && c.Spec.Auth.SAMLAuth != nil {
+		if c.Spec.Auth.SAMLAuth.EntityID != "" {
+			return c.Spec.Auth.SAMLAuth.EntityID
+		}
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(c.GetSAMLACSURL(), "/"), "/acs") + "/metadata"
+}
+
+// GetSAMLIDPSSOURL returns the single sign-on URL of the SAML identity provider.
+func (c *VDICluster) GetSAMLIDPSSOURL() string {
+	if c.Spec.Auth != nil && c.Spec.Auth.SAMLAuth != nil {
+		return c.Spec.Auth.SAMLAuth.IDPSSOURL
+	}
+	return ""
+}
+
+// GetSAMLIDPEntityID returns the expected entity ID of the SAML identity provider.
+// An empty string means any issuer signed by the identity provider certificate is accepted.
+func (c *VDICluster) GetSAMLIDPEntityID() string {
+	if c.Spec.Auth != nil && c.Spec.Auth.SAMLAuth != nil {
+		return c.Spec.Auth.SAMLAuth.IDPEntityID
+	}
+	return ""
+}
+
+// GetSAMLIDPCertificate returns the PEM encoded signing certificate(s) of the SAML
+// identity provider. The value is base64 decoded and returned to the caller.
+func (c *VDICluster) GetSAMLIDPCertificate() ([]byte, error) {
+	if c.Spec.Auth != nil && c.Spec.Auth.SAMLAuth != nil {
+		if c.Spec.Auth.SAMLAuth.IDPCertificate != "" {
+			return base64.StdEncoding.DecodeString(c.Spec.Auth.SAMLAuth.IDPCertificate)
+		}
+	}
+	return nil, nil
+}
+
+// GetSAMLSPCertificateKey returns the key in the secret where the service provider
+// certificate can be retrieved.
+func (c *VDICluster) GetSAMLSPCertificateKey() string {
+	if c.Spec.Auth != nil && c.Spec.Auth.SAMLAuth != nil {
+		if c.Spec.Auth.SAMLAuth.SPCertificateKey != "" {
+			return c.Spec.Auth.SAMLAuth.SPCertificateKey
+		}
+	}
+	return "saml-sp-cert"
+}
+
+// GetSAMLSPPrivateKeyKey returns the key in the secret where the service provider
+// private key can be retrieved.
+func (c *VDICluster) GetSAMLSPPrivateKeyKey() string {
+	if c.Spec.Auth != nil && c.Spec.Auth.SAMLAuth != nil {
+		if c.Spec.Auth.SAMLAuth.SPPrivateKeyKey != "" {
+			return c.Spec.Auth.SAMLAuth.SPPrivateKeyKey
+		}
+	}
+	return "saml-sp-key"
+}
+
+// GetSAMLUsernameAttribute returns the assertion attribute to use as the username. An
+// empty string means the NameID of the subject is used.
+func (c *VDICluster) GetSAMLUsernameAttribute() string {
+	if c.Spec.Auth != nil && c.Spec.Auth.SAMLAuth != nil {
+		return c.Spec.Auth.SAMLAuth.UsernameAttribute
+	}
+	return ""
+}
+
+// GetSAMLGroupsAttribute returns the assertion attribute to use for matching a user's
+// groups to VDI roles.
+func (c *VDICluster) GetSAMLGroupsAttribute() string {
+	if c.Spec.Auth != nil && c.Spec.Auth.SAMLAuth != nil {
+		if c.Spec.Auth.SAMLAuth.GroupsAttribute != "" {
+			return c.Spec.Auth.SAMLAuth.GroupsAttribute
+		}
+	}
+	return "groups"
+}
+
+// GetSAMLAdminGroups returns the values in the groups attribute that will map to administrator access.
+func (c *VDICluster) GetSAMLAdminGroups() []string {
+	if c.Spec.Auth != nil && c.Spec.Auth.SAMLAuth != nil {
+		return c.Spec.Auth.SAMLAuth.AdminGroups
+	}
+	return []string{}
+}
+
+// SAMLAllowNonGroupedReadOnly returns true if users without groups in their SAML
+// assertion should be allowed read-only access to kVDI.
+func (c *VDICluster) SAMLAllowNonGroupedReadOnly() bool {
+	if c.Spec.Auth != nil && c.Spec.Auth.SAMLAuth != nil {
+		return c.Spec.Auth.SAMLAuth.AllowNonGroupedReadOnly
+	}
+	return false
+}
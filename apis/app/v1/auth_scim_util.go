@@ -0,0 +1,52 @@
+/*
+
+Copyright 2020,2021 Avi Zimmerman
+
+This file is part of kvdi.
+
+kvdi is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+kvdi is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package v1
+
+// IsSCIMEnabled returns true if users and groups can be provisioned over SCIM.
+func (c *VDICluster) IsSCIMEnabled() bool {
+	return c.Spec.Auth != nil && c.Spec.Auth.SCIM != nil && c.Spec.Auth.SCIM.Enabled
+}
+
+// GetSCIMMethods returns the enabled authentication methods, other than local auth,
+// that users provisioned over SCIM log in with. When none are configured, the first
+// enabled oidc or saml method is used, or the primary method when neither is enabled.
+func (c *VDICluster) GetSCIMMethods() []AuthMethod {
+	enabled := c.GetAuthMethods()
+	if c.Spec.Auth != nil && c.Spec.Auth.SCIM != nil && len(c.Spec.Auth.SCIM.Methods) > 0 {
+		methods := make([]AuthMethod, 0, len(c.Spec.Auth.SCIM.Methods))
+		for _, method := range c.Spec.Auth.SCIM.Methods {
+			if method != AuthMethodLocal && authMethodsContain(enabled, method) && !authMethodsContain(methods, method) {
+				methods = append(methods, method)
+			}
+		}
+		return methods
+	}
+	for _, method := range enabled {
+		if method == AuthMethodOIDC || method == AuthMethodSAML {
+			return []AuthMethod{method}
+		}
+	}
+	if enabled[0] != AuthMethodLocal {
+		return []AuthMethod{enabled[0]}
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+/*
+
+Copyright 2020,2021 Avi Zimmerman
+
+This file is part of kvdi.
+
+kvdi is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+kvdi is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package v1
+
+import (
+	"net/url"
+	"strings"
+)
+
+// GetWebAuthnRPID returns the WebAuthn relying party ID. It defaults to the host of the
+// first allowed origin, and is empty when WebAuthn is not configured.
+func (c *VDICluster) GetWebAuthnRPID() string {
+	if c.Spec.Auth == nil || c.Spec.Auth.WebAuthn == nil {
+		return ""
+	}
+	if c.Spec.Auth.WebAuthn.RPID != "" {
+		return c.Spec.Auth.WebAuthn.RPID
+	}
+	if len(c.Spec.Auth.WebAuthn.Origins) > 0 {
+		if u, err := url.Parse(c.Spec.Auth.WebAuthn.Origins[0]); err == nil {
+			return u.Hostname()
+		}
+	}
+	return ""
+}
+
+// GetWebAuthnOrigins returns the origins WebAuthn ceremonies are accepted from. They
+// default to the relying party ID served over https.
+func (c *VDICluster) GetWebAuthnOrigins() []string {
+	if c.Spec.Auth == nil || c.Spec.Auth.WebAuthn == nil {
+		return nil
+	}
+	if len(c.Spec.Auth.WebAuthn.Origins) > 0 {
+		origins := make([]string, len(c.Spec.Auth.WebAuthn.Origins))
+		for i, origin := range c.Spec.Auth.WebAuthn.Origins {
+			origins[i] = strings.TrimSuffix(origin, "/")
+		}
+		return origins
+	}
+	if rpID := c.GetWebAuthnRPID(); rpID != "" {
+		return []string{"https://" + rpID}
+	}
+	return nil
+}
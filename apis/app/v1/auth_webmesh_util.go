@@ -0,0 +1,133 @@
+/*
+
+Copyright 2020,2021 Avi Zimmerman
+
+This file is part of kvdi.
+
+kvdi is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+kvdi is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package v1
+
+import (
+	"encoding/base64"
+	"net/url"
+	"time"
+)
+
+// Defaults for webmesh authentication
+const (
+	defaultWebmeshJWKSCacheTTL   = time.Hour
+	defaultWebmeshRequestTimeout = time.Duration(10) * time.Second
+)
+
+// GetWebmeshMetadataURL returns the URL of the webmesh metadata server.
+func (c *VDICluster) GetWebmeshMetadataURL() string {
+	if c.Spec.Auth != nil && c.Spec.Auth.WebmeshAuth != nil {
+		return c.Spec.Auth.WebmeshAuth.MetadataURL
+	}
+	return ""
+}
+
+// GetWebmeshJWKSURL returns the URL of the key set used to verify webmesh ID tokens.
+// It defaults to `id-tokens/jwks.json` under the metadata URL.
+func (c *VDICluster) GetWebmeshJWKSURL() (string, error) {
+	if c.Spec.Auth != nil && c.Spec.Auth.WebmeshAuth != nil && c.Spec.Auth.WebmeshAuth.JWKSURL != "" {
+		return c.Spec.Auth.WebmeshAuth.JWKSURL, nil
+	}
+	return url.JoinPath(c.GetWebmeshMetadataURL(), "id-tokens", "jwks.json")
+}
+
+// GetWebmeshValidateURL returns the URL of the metadata server endpoint that validates
+// ID tokens.
+func (c *VDICluster) GetWebmeshValidateURL() (string, error) {
+	return url.JoinPath(c.GetWebmeshMetadataURL(), "id-tokens", "validate")
+}
+
+// GetWebmeshJWKSCacheTTL returns how long a fetched webmesh key set is cached. If the
+// duration cannot be parsed, the default is returned.
+func (c *VDICluster) GetWebmeshJWKSCacheTTL() time.Duration {
+	if c.Spec.Auth != nil && c.Spec.Auth.WebmeshAuth != nil && c.Spec.Auth.WebmeshAuth.JWKSCacheTTL != "" {
+		if duration, err := time.ParseDuration(c.Spec.Auth.WebmeshAuth.JWKSCacheTTL); err == nil && duration >= 0 {
+			return duration
+		}
+	}
+	return defaultWebmeshJWKSCacheTTL
+}
+
+// GetWebmeshDiscoveryURL returns the URL of the discovery document the issuer of webmesh
+// ID tokens is read from when it is not configured.
+func (c *VDICluster) GetWebmeshDiscoveryURL() (string, error) {
+	return url.JoinPath(c.GetWebmeshMetadataURL(), "id-tokens", ".well-known", "openid-configuration")
+}
+
+// GetWebmeshIssuer returns the issuer webmesh ID tokens must be issued by, or a blank
+// string if it should be discovered.
+func (c *VDICluster) GetWebmeshIssuer() string {
+	if c.Spec.Auth != nil && c.Spec.Auth.WebmeshAuth != nil {
+		return c.Spec.Auth.WebmeshAuth.Issuer
+	}
+	return ""
+}
+
+// GetWebmeshAudiences returns the audiences accepted in webmesh ID tokens. They default
+// to the client ID.
+func (c *VDICluster) GetWebmeshAudiences() []string {
+	if c.Spec.Auth == nil || c.Spec.Auth.WebmeshAuth == nil {
+		return nil
+	}
+	if len(c.Spec.Auth.WebmeshAuth.Audiences) > 0 {
+		return c.Spec.Auth.WebmeshAuth.Audiences
+	}
+	if c.Spec.Auth.WebmeshAuth.ClientID != "" {
+		return []string{c.Spec.Auth.WebmeshAuth.ClientID}
+	}
+	return nil
+}
+
+// GetWebmeshRequestTimeout returns the timeout for requests to the webmesh metadata
+// server. If the duration cannot be parsed, the default is returned.
+func (c *VDICluster) GetWebmeshRequestTimeout() time.Duration {
+	if c.Spec.Auth != nil && c.Spec.Auth.WebmeshAuth != nil && c.Spec.Auth.WebmeshAuth.RequestTimeout != "" {
+		if duration, err := time.ParseDuration(c.Spec.Auth.WebmeshAuth.RequestTimeout); err == nil && duration > 0 {
+			return duration
+		}
+	}
+	return defaultWebmeshRequestTimeout
+}
+
+// GetWebmeshInsecureSkipVerify returns whether or not to verify the TLS certificate of the
+// webmesh metadata server.
+func (c *VDICluster) GetWebmeshInsecureSkipVerify() bool {
+	if c.Spec.Auth != nil && c.Spec.Auth.WebmeshAuth != nil {
+		return c.Spec.Auth.WebmeshAuth.TLSInsecureSkipVerify
+	}
+	return false
+}
+
+// GetWebmeshCA returns the CA certificate to use when verifying the webmesh metadata server
+// certificate. The value is base64 decoded and returned to the caller.
+func (c *VDICluster) GetWebmeshCA() ([]byte, error) {
+	if c.Spec.Auth != nil && c.Spec.Auth.WebmeshAuth != nil && c.Spec.Auth.WebmeshAuth.TLSCACert != "" {
+		return base64.StdEncoding.DecodeString(c.Spec.Auth.WebmeshAuth.TLSCACert)
+	}
+	return nil, nil
+}
+
+// AllowWebmeshRemoteValidation returns true if webmesh ID tokens that cannot be verified
+// locally may be validated by the metadata server.
+func (c *VDICluster) AllowWebmeshRemoteValidation() bool {
+	return c.Spec.Auth != nil && c.Spec.Auth.WebmeshAuth != nil && c.Spec.Auth.WebmeshAuth.AllowRemoteValidation
+}
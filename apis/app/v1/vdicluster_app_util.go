@@ -21,6 +21,7 @@ package v1
 
 import (
 	"fmt"
+	"net"
 
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/types"
@@ -107,6 +108,21 @@ func (c *VDICluster) EnableCORS() bool {
 	return false
 }
 
+// GetTrustedProxies returns the networks of the reverse proxies whose forwarded headers
+// are trusted for client addresses. Invalid CIDRs are ignored.
+func (c *VDICluster) GetTrustedProxies() []*net.IPNet {
+	if c.Spec.App == nil {
+		return nil
+	}
+	nets := make([]*net.IPNet, 0, len(c.Spec.App.TrustedProxies))
+	for _, cidr := range c.Spec.App.TrustedProxies {
+		if _, ipnet, err := net.ParseCIDR(cidr); err == nil {
+			nets = append(nets, ipnet)
+		}
+	}
+	return nets
+}
+
 // AuditLogEnabled returns true if auditing events should be logged to stdout.
 func (c *VDICluster) AuditLogEnabled() bool {
 	if c.Spec.App != nil {
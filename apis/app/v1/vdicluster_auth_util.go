@@ -48,13 +48,82 @@ func (c *VDICluster) AnonymousAllowed() bool {
 }
 
 // IsUsingLocalAuth returns true if the cluster is using the local authentication
-// driver. This function and the API should be refactored to just return true
-// if no other options are defined.
+// driver.
 func (c *VDICluster) IsUsingLocalAuth() bool {
-	if c.Spec.Auth != nil {
-		return c.Spec.Auth.LocalAuth != nil && !c.IsUsingLDAPAuth() && !c.IsUsingOIDCAuth()
+	return c.IsAuthMethodEnabled(AuthMethodLocal)
+}
+
+// GetAuthMethods returns the authentication methods enabled for this cluster. When
+// providers are listed in the configuration, those that are configured are returned
+// in the order given. Otherwise, a single method is returned.
+func (c *VDICluster) GetAuthMethods() []AuthMethod {
+	if c.Spec.Auth != nil && len(c.Spec.Auth.Providers) > 0 {
+		methods := make([]AuthMethod, 0, len(c.Spec.Auth.Providers))
+		for _, method := range c.Spec.Auth.Providers {
+			if c.isAuthMethodConfigured(method) && !authMethodsContain(methods, method) {
+				methods = append(methods, method)
+			}
+		}
+		if len(methods) > 0 {
+			return methods
+		}
 	}
-	return true
+	switch {
+	case c.IsUsingLDAPAuth():
+		return []AuthMethod{AuthMethodLDAP}
+	case c.IsUsingOIDCAuth():
+		return []AuthMethod{AuthMethodOIDC}
+	case c.IsUsingSAMLAuth():
+		return []AuthMethod{AuthMethodSAML}
+	case c.IsUsingWebmeshAuth():
+		return []AuthMethod{AuthMethodWebmesh}
+	case c.IsUsingClientCertAuth():
+		return []AuthMethod{AuthMethodClientCert}
+	case c.IsUsingKubernetesAuth():
+		return []AuthMethod{AuthMethodKubernetes}
+	}
+	return []AuthMethod{AuthMethodLocal}
+}
+
+// IsAuthMethodEnabled returns true if the given authentication method is enabled
+// for this cluster.
+func (c *VDICluster) IsAuthMethodEnabled(method AuthMethod) bool {
+	return authMethodsContain(c.GetAuthMethods(), method)
+}
+
+// IsUsingMultipleAuthMethods returns true if more than one authentication method
+// is enabled for this cluster.
+func (c *VDICluster) IsUsingMultipleAuthMethods() bool {
+	return len(c.GetAuthMethods()) > 1
+}
+
+func (c *VDICluster) isAuthMethodConfigured(method AuthMethod) bool {
+	switch method {
+	case AuthMethodLocal:
+		return true
+	case AuthMethodLDAP:
+		return c.IsUsingLDAPAuth()
+	case AuthMethodOIDC:
+		return c.IsUsingOIDCAuth()
+	case AuthMethodSAML:
+		return c.IsUsingSAMLAuth()
+	case AuthMethodWebmesh:
+		return c.IsUsingWebmeshAuth()
+	case AuthMethodClientCert:
+		return c.IsUsingClientCertAuth()
+	case AuthMethodKubernetes:
+		return c.IsUsingKubernetesAuth()
+	}
+	return false
+}
+
+func authMethodsContain(methods []AuthMethod, method AuthMethod) bool {
+	for _, m := range methods {
+		if m == method {
+			return true
+		}
+	}
+	return false
 }
 
 // IsUsingWebmeshAuth returns true if the cluster is using the webmesh authentication
@@ -66,6 +135,23 @@ func (c *VDICluster) IsUsingWebmeshAuth() bool {
 	return false
 }
 
+// IsUsingKubernetesAuth returns true if the cluster is using the kubernetes ServiceAccount
+// authentication driver.
+func (c *VDICluster) IsUsingKubernetesAuth() bool {
+	if c.Spec.Auth != nil {
+		return c.Spec.Auth.KubernetesAuth != nil
+	}
+	return false
+}
+
+// GetKubernetesAuthAudiences returns the audiences ServiceAccount tokens must be issued for.
+func (c *VDICluster) GetKubernetesAuthAudiences() []string {
+	if c.Spec.Auth != nil && c.Spec.Auth.KubernetesAuth != nil {
+		return c.Spec.Auth.KubernetesAuth.Audiences
+	}
+	return nil
+}
+
 // AuthIsUsingSecretEngine returns true if the secrets for the configured auth
 // backend are using the built-in secrets engine and not a separate kubernetes
 // secret.
@@ -81,6 +167,11 @@ func (c *VDICluster) AuthIsUsingSecretEngine() bool {
 				return false
 			}
 		}
+		if c.Spec.Auth.SAMLAuth != nil {
+			if c.Spec.Auth.SAMLAuth.SPCredentialsSecret != "" {
+				return false
+			}
+		}
 	}
 	return true
 }
@@ -96,6 +187,9 @@ func (c *VDICluster) GetAuthK8sSecret() string {
 		if c.Spec.Auth.OIDCAuth != nil && c.Spec.Auth.OIDCAuth.ClientCredentialsSecret != "" {
 			return c.Spec.Auth.OIDCAuth.ClientCredentialsSecret
 		}
+		if c.Spec.Auth.SAMLAuth != nil && c.Spec.Auth.SAMLAuth.SPCredentialsSecret != "" {
+			return c.Spec.Auth.SAMLAuth.SPCredentialsSecret
+		}
 	}
 	return c.GetAppSecretsName()
 }
@@ -113,17 +207,147 @@ func (c *VDICluster) GetTokenDuration() time.Duration {
 	return v1.DefaultSessionLength
 }
 
+// defaultMaxAPITokenTTL is the longest personal API tokens are valid for by default.
+const defaultMaxAPITokenTTL = time.Duration(2160) * time.Hour
+
+// GetMaxAPITokenTTL returns the longest a personal API token can be valid for. If the
+// duration cannot be parsed or is not positive, the default is returned.
+func (c *VDICluster) GetMaxAPITokenTTL() time.Duration {
+	if c.Spec.Auth != nil && c.Spec.Auth.MaxAPITokenTTL != "" {
+		if duration, err := time.ParseDuration(c.Spec.Auth.MaxAPITokenTTL); err == nil && duration > 0 {
+			return duration
+		}
+	}
+	return defaultMaxAPITokenTTL
+}
+
+// Defaults for access token signing
+const (
+	defaultTokenSigningAlgorithm = TokenSigningRS256
+	defaultTokenRotationInterval = time.Duration(720) * time.Hour
+	defaultTokenRotationOverlap  = time.Hour
+)
+
+// GetTokenSigningAlgorithm returns the algorithm used to sign access tokens.
+func (c *VDICluster) GetTokenSigningAlgorithm() TokenSigningAlgorithm {
+	if c.Spec.Auth != nil && c.Spec.Auth.TokenSigning != nil && c.Spec.Auth.TokenSigning.Algorithm != "" {
+		return c.Spec.Auth.TokenSigning.Algorithm
+	}
+	return defaultTokenSigningAlgorithm
+}
+
+// GetTokenRotationInterval returns how often a new token signing key is generated.
+// Intervals that are not positive fall back to the default, since they would generate
+// a new key for every token.
+func (c *VDICluster) GetTokenRotationInterval() time.Duration {
+	if c.Spec.Auth != nil && c.Spec.Auth.TokenSigning != nil && c.Spec.Auth.TokenSigning.RotationInterval != "" {
+		if duration, err := time.ParseDuration(c.Spec.Auth.TokenSigning.RotationInterval); err == nil && duration > 0 {
+			return duration
+		}
+	}
+	return defaultTokenRotationInterval
+}
+
+// GetTokenRotationOverlap returns how long a replaced token signing key is still accepted.
+// It is never shorter than the token duration, so that tokens signed with the key do not
+// stop working before they expire.
+func (c *VDICluster) GetTokenRotationOverlap() time.Duration {
+	overlap := defaultTokenRotationOverlap
+	if c.Spec.Auth != nil && c.Spec.Auth.TokenSigning != nil && c.Spec.Auth.TokenSigning.RotationOverlap != "" {
+		if duration, err := time.ParseDuration(c.Spec.Auth.TokenSigning.RotationOverlap); err == nil {
+			overlap = duration
+		}
+	}
+	if tokenDuration := c.GetTokenDuration(); overlap < tokenDuration {
+		return tokenDuration
+	}
+	return overlap
+}
+
+// Defaults for login lockout
+const (
+	defaultLockoutMaxUserFailures   = 5
+	defaultLockoutMaxSourceFailures = 20
+	defaultLockoutWindow            = time.Duration(15) * time.Minute
+	defaultLockoutCooldown          = time.Duration(15) * time.Minute
+)
+
+// LoginLockoutEnabled returns true if failed logins should be throttled.
+func (c *VDICluster) LoginLockoutEnabled() bool {
+	if c.Spec.Auth != nil && c.Spec.Auth.Lockout != nil {
+		return !c.Spec.Auth.Lockout.Disabled
+	}
+	return true
+}
+
+// GetLockoutMaxUserFailures returns the number of failed logins allowed for a username
+// within the lockout window.
+func (c *VDICluster) GetLockoutMaxUserFailures() int {
+	if c.Spec.Auth != nil && c.Spec.Auth.Lockout != nil && c.Spec.Auth.Lockout.MaxUserFailures > 0 {
+		return c.Spec.Auth.Lockout.MaxUserFailures
+	}
+	return defaultLockoutMaxUserFailures
+}
+
+// GetLockoutMaxSourceFailures returns the number of failed logins allowed from a source
+// address within the lockout window. Zero means source addresses are not limited, which
+// is the default when no trusted proxies are configured. Behind a proxy that is not
+// trusted every client appears to come from the proxy's address, and limiting it would
+// lock out all logins.
+func (c *VDICluster) GetLockoutMaxSourceFailures() int {
+	if c.Spec.Auth != nil && c.Spec.Auth.Lockout != nil && c.Spec.Auth.Lockout.MaxSourceFailures > 0 {
+		return c.Spec.Auth.Lockout.MaxSourceFailures
+	}
+	if len(c.GetTrustedProxies()) == 0 {
+		return 0
+	}
+	return defaultLockoutMaxSourceFailures
+}
+
+// GetLockoutWindow returns the sliding window failed logins are counted in. If the duration
+// cannot be parsed, the default is returned.
+func (c *VDICluster) GetLockoutWindow() time.Duration {
+	if c.Spec.Auth != nil && c.Spec.Auth.Lockout != nil && c.Spec.Auth.Lockout.Window != "" {
+		if duration, err := time.ParseDuration(c.Spec.Auth.Lockout.Window); err == nil && duration > 0 {
+			return duration
+		}
+	}
+	return defaultLockoutWindow
+}
+
+// GetLockoutCooldown returns how long a locked username or source address stays locked.
+// If the duration cannot be parsed, the default is returned.
+func (c *VDICluster) GetLockoutCooldown() time.Duration {
+	if c.Spec.Auth != nil && c.Spec.Auth.Lockout != nil && c.Spec.Auth.Lockout.Cooldown != "" {
+		if duration, err := time.ParseDuration(c.Spec.Auth.Lockout.Cooldown); err == nil && duration > 0 {
+			return duration
+		}
+	}
+	return defaultLockoutCooldown
+}
+
 // GetAdminRole returns an admin role for this VDICluster.
 func (c *VDICluster) GetAdminRole() *rbacv1.VDIRole {
 	var annotations map[string]string
-	if c.IsUsingLDAPAuth() {
-		annotations = map[string]string{
-			v1.LDAPGroupRoleAnnotation: strings.Join(c.GetLDAPAdminGroups(), v1.AuthGroupSeparator),
+	for _, method := range c.GetAuthMethods() {
+		var key string
+		var groups []string
+		switch method {
+		case AuthMethodLDAP:
+			key, groups = v1.LDAPGroupRoleAnnotation, c.GetLDAPAdminGroups()
+		case AuthMethodOIDC:
+			key, groups = v1.OIDCGroupRoleAnnotation, c.GetOIDCAdminGroups()
+		case AuthMethodSAML:
+			key, groups = v1.SAMLGroupRoleAnnotation, c.GetSAMLAdminGroups()
+		case AuthMethodClientCert:
+			key, groups = v1.ClientCertGroupRoleAnnotation, c.GetClientCertAdminGroups()
+		default:
+			continue
 		}
-	} else if c.IsUsingOIDCAuth() {
-		annotations = map[string]string{
-			v1.OIDCGroupRoleAnnotation: strings.Join(c.GetOIDCAdminGroups(), v1.AuthGroupSeparator),
+		if annotations == nil {
+			annotations = make(map[string]string)
 		}
+		annotations[key] = strings.Join(groups, v1.AuthGroupSeparator)
 	}
 	return &rbacv1.VDIRole{
 		ObjectMeta: metav1.ObjectMeta{
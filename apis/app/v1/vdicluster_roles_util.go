@@ -46,6 +46,25 @@ func (c *VDICluster) GetRoles(cl client.Client) ([]*rbacv1.VDIRole, error) {
 	return roleList.Trim(), nil
 }
 
+// GetRoleBindings returns a list of all the VDIRoleBindings that apply to this cluster instance.
+func (c *VDICluster) GetRoleBindings(cl client.Client) ([]*rbacv1.VDIRoleBinding, error) {
+	bindingList := &rbacv1.VDIRoleBindingList{}
+	err := cl.List(
+		context.TODO(),
+		bindingList,
+		client.InNamespace(metav1.NamespaceAll),
+		client.MatchingLabels{v1.RoleClusterRefLabel: c.GetName()},
+	)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]*rbacv1.VDIRoleBinding, len(bindingList.Items))
+	for i := range bindingList.Items {
+		out[i] = &bindingList.Items[i]
+	}
+	return out, nil
+}
+
 // GetLaunchTemplatesRole returns a launch-templates role for a cluster. A role like this
 // is created for every cluster for convenience. It is the default role applied to anonymous
 // users, and for non-grouped OIDC users.
@@ -110,6 +110,13 @@ type AppConfig struct {
 	CORSEnabled bool `json:"corsEnabled,omitempty"`
 	// Whether to log auditing events to stdout
 	AuditLog bool `json:"auditLog,omitempty"`
+	// CIDRs of reverse proxies in front of the app instances. The client address of a
+	// request is only taken from its `X-Forwarded-For` or `X-Real-IP` headers when the
+	// request comes from one of these networks. When empty, the headers are ignored and
+	// the address of the connected peer is used. Previous versions always honored the
+	// headers, so deployments behind an ingress or proxy must set this when upgrading
+	// for audit logs, login lockouts, and rule conditions to keep seeing client addresses.
+	TrustedProxies []string `json:"trustedProxies,omitempty"`
 	// The number of app replicas to run
 	Replicas int32 `json:"replicas,omitempty"`
 	// ServiceName is the name of the service to create for the app instance.
@@ -179,24 +186,81 @@ type AuthConfig struct {
 	AllowAnonymous bool `json:"allowAnonymous,omitempty"`
 	// A secret where a generated admin password will be stored
 	AdminSecret string `json:"adminSecret,omitempty"`
-	// How long issued access tokens should be valid for. When using OIDC auth
-	// you may want to set this to a higher value (e.g. 8-10h) since the refresh token
-	// flow will not be able to lookup a user's grants from the provider. Defaults to `15m`.
+	// How long issued access tokens should be valid for. When using OIDC auth without
+	// `preserveTokens` you may want to set this to a higher value (e.g. 8-10h) since the refresh
+	// token flow will not be able to lookup a user's grants from the provider. Defaults to `15m`.
 	TokenDuration string `json:"tokenDuration,omitempty"`
+	// The longest personal API tokens can be valid for. Tokens created without a ttl
+	// expire after this long. Defaults to `2160h` (90 days).
+	MaxAPITokenTTL string `json:"maxAPITokenTTL,omitempty"`
+	// Configurations for the keys used to sign access tokens. The public keys are published
+	// at `/api/.well-known/jwks.json` so that other services can verify tokens issued by kVDI.
+	TokenSigning *TokenSigningConfig `json:"tokenSigning,omitempty"`
 	// The rules to apply to the default role created for this cluster. These are the rules applied to
 	// anonymous users (if allowed) and non-grouped OIDC users. They can also be used for convenience
 	// when getting started. The defaults only allow for launching templates in the `appNamespace`.
 	DefaultRoleRules []v1.Rule `json:"defaultRoleRules,omitempty"`
-	// Use local auth (secret-backed) authentication
+	// Use local auth (VDIUser-backed) authentication
 	LocalAuth *LocalAuthConfig `json:"localAuth,omitempty"`
 	// Use LDAP for authentication.
 	LDAPAuth *LDAPConfig `json:"ldapAuth,omitempty"`
 	// Use OIDC for authentication
 	OIDCAuth *OIDCConfig `json:"oidcAuth,omitempty"`
+	// Use a SAML 2.0 identity provider for authentication
+	SAMLAuth *SAMLConfig `json:"samlAuth,omitempty"`
 	// Use Webmesh for authentication
 	WebmeshAuth *WebmeshConfig `json:"webmeshAuth,omitempty"`
+	// Use X.509 client certificates presented to the app server for authentication.
+	ClientCertAuth *ClientCertConfig `json:"clientCertAuth,omitempty"`
+	// Use Kubernetes ServiceAccount tokens for authentication. This is intended for
+	// in-cluster automation and is usually listed in `providers` alongside another method.
+	KubernetesAuth *KubernetesConfig `json:"kubernetesAuth,omitempty"`
+	// The authentication methods to enable at the same time. When more than one
+	// configured method is listed, users choose one with the `method` field of their
+	// login request and user names are prefixed with the method they belong to
+	// (e.g. `ldap.bob`). The first method is used when a request does not specify one.
+	// When empty, only a single method is used, preferring ldap, oidc, saml, webmesh,
+	// clientcert, kubernetes, and then local auth. Methods using a kubernetes secret for their
+	// credentials must all use the same secret.
+	Providers []AuthMethod `json:"providers,omitempty"`
+	// Configurations for protecting logins against brute-force attempts. Failed logins are
+	// counted per username and per source address, and either is locked out once too many
+	// failures happen within the window. Enabled with the defaults when omitted.
+	Lockout *LockoutConfig `json:"lockout,omitempty"`
+	// Configurations for provisioning users and groups from an identity provider over SCIM 2.0.
+	SCIM *SCIMConfig `json:"scim,omitempty"`
+	// Configurations for WebAuthn (security key and passkey) MFA. WebAuthn is unavailable
+	// until a relying party ID or origins are set.
+	WebAuthn *WebAuthnConfig `json:"webAuthn,omitempty"`
 }
 
+// WebAuthnConfig represents the relying party kVDI presents to WebAuthn authenticators.
+// Credentials are bound to the relying party ID, so changing it invalidates all
+// registered credentials.
+type WebAuthnConfig struct {
+	// The relying party ID credentials are scoped to. This is the domain users reach
+	// kVDI on, or a parent of it. Defaults to the host of the first origin.
+	RPID string `json:"rpID,omitempty"`
+	// The origins (e.g. `https://kvdi.example.com`) WebAuthn ceremonies are accepted from.
+	// Defaults to `https://` followed by the `rpID`.
+	Origins []string `json:"origins,omitempty"`
+}
+
+// AuthMethod is the name of an authentication provider.
+// +kubebuilder:validation:Enum=local;ldap;oidc;saml;webmesh;clientcert;kubernetes
+type AuthMethod string
+
+// Valid authentication methods
+const (
+	AuthMethodLocal      AuthMethod = "local"
+	AuthMethodLDAP       AuthMethod = "ldap"
+	AuthMethodOIDC       AuthMethod = "oidc"
+	AuthMethodSAML       AuthMethod = "saml"
+	AuthMethodWebmesh    AuthMethod = "webmesh"
+	AuthMethodClientCert AuthMethod = "clientcert"
+	AuthMethodKubernetes AuthMethod = "kubernetes"
+)
+
 // SecretsConfig configurese the backend for secrets management.
 type SecretsConfig struct {
 	// Use a kubernetes secret for storing sensitive values. If no other coniguration is provided
@@ -207,8 +271,93 @@ type SecretsConfig struct {
 	Vault *VaultConfig `json:"vault,omitempty"`
 }
 
-// LocalAuthConfig represents a local, 'passwd'-like authentication driver.
-type LocalAuthConfig struct{}
+// TokenSigningAlgorithm is the algorithm used to sign access tokens.
+// +kubebuilder:validation:Enum=RS256;EdDSA
+type TokenSigningAlgorithm string
+
+// Valid token signing algorithms
+const (
+	TokenSigningRS256 TokenSigningAlgorithm = "RS256"
+	TokenSigningEdDSA TokenSigningAlgorithm = "EdDSA"
+)
+
+// TokenSigningConfig represents the configurations for signing access tokens. A new key
+// is generated on every rotation, and the previous one is still accepted and published
+// during the overlap so that tokens it signed stay valid until they expire.
+type TokenSigningConfig struct {
+	// The algorithm used to sign access tokens. Changing it rotates the signing key.
+	// Defaults to `RS256`.
+	Algorithm TokenSigningAlgorithm `json:"algorithm,omitempty"`
+	// How often a new signing key is generated. Values that are not positive are ignored.
+	// Defaults to `720h`.
+	RotationInterval string `json:"rotationInterval,omitempty"`
+	// How long a key is still accepted and published after it was replaced. It is never
+	// shorter than the `tokenDuration`. Defaults to `1h`.
+	RotationOverlap string `json:"rotationOverlap,omitempty"`
+}
+
+// SCIMConfig represents the configurations for provisioning users and groups over SCIM 2.0.
+// Provisioned users are stored as VDIUsers and their groups are bound to VDIRoles with the
+// `kvdi.io/scim-groups` annotation.
+type SCIMConfig struct {
+	// Set to true to serve the SCIM endpoints at `/scim/v2`. Clients authenticate with
+	// the bearer token stored under `scimToken` in the secrets backend, which is generated
+	// when it does not exist.
+	Enabled bool `json:"enabled,omitempty"`
+	// The authentication methods that users provisioned over SCIM log in with. The roles,
+	// groups, and deactivation of provisioned users only apply to users of these methods,
+	// so that users of other methods with the same name are not affected. Defaults to the
+	// first enabled oidc or saml method, or the primary method when neither is enabled.
+	// Local users are always the provisioned VDIUsers themselves.
+	Methods []AuthMethod `json:"methods,omitempty"`
+}
+
+// LockoutConfig represents the configurations for throttling failed login attempts.
+type LockoutConfig struct {
+	// Set to true to disable login throttling and account lockout.
+	Disabled bool `json:"disabled,omitempty"`
+	// The number of failed logins allowed for a single username within the window before
+	// the account is locked. Defaults to 5.
+	MaxUserFailures int `json:"maxUserFailures,omitempty"`
+	// The number of failed logins allowed from a single source address within the window
+	// before the address is locked. Defaults to 20 when `app.trustedProxies` is set, and
+	// otherwise source addresses are not limited. Without trusted proxies, every client
+	// behind an ingress or load balancer that rewrites addresses shares the same source,
+	// so only set this when clients connect to the app directly.
+	MaxSourceFailures int `json:"maxSourceFailures,omitempty"`
+	// The sliding window in which failed logins are counted. Defaults to `15m`.
+	Window string `json:"window,omitempty"`
+	// How long a locked username or source address stays locked. Administrators can unlock
+	// users before this expires. Defaults to `15m`.
+	Cooldown string `json:"cooldown,omitempty"`
+}
+
+// LocalAuthConfig represents a local authentication driver backed by VDIUser resources.
+type LocalAuthConfig struct {
+	// Rules that the passwords of local users must follow. When omitted, any non-empty
+	// password is accepted and passwords do not expire.
+	PasswordPolicy *PasswordPolicy `json:"passwordPolicy,omitempty"`
+}
+
+// PasswordPolicy represents the rules applied to the passwords of local users.
+type PasswordPolicy struct {
+	// The minimum length of passwords.
+	MinLength int `json:"minLength,omitempty"`
+	// Require passwords to contain an uppercase letter.
+	RequireUppercase bool `json:"requireUppercase,omitempty"`
+	// Require passwords to contain a lowercase letter.
+	RequireLowercase bool `json:"requireLowercase,omitempty"`
+	// Require passwords to contain a digit.
+	RequireDigit bool `json:"requireDigit,omitempty"`
+	// Require passwords to contain a character that is not a letter or digit.
+	RequireSymbol bool `json:"requireSymbol,omitempty"`
+	// The number of a user's most recent passwords, including their current one,
+	// that they may not reuse.
+	HistorySize int `json:"historySize,omitempty"`
+	// How long a password is valid for (e.g. `2160h`). Users with older passwords
+	// must change them at their next login before their session is authorized.
+	MaxAge string `json:"maxAge,omitempty"`
+}
 
 // WebmeshConfig represents configurations for using a webmesh cluster as the
 // authentication backend.
@@ -216,6 +365,72 @@ type WebmeshConfig struct {
 	// MetadataURL is the URL to the webmesh metadata endpoint. This is used for
 	// validating the JWT token.
 	MetadataURL string `json:"metadataURL,omitempty"`
+	// The URL of the JSON Web Key Set used to verify ID tokens locally. Defaults to
+	// `id-tokens/jwks.json` under the metadata URL.
+	JWKSURL string `json:"jwksURL,omitempty"`
+	// How long a fetched key set is cached before it is fetched again. Keys are also
+	// refetched when a token is signed by a key that is not in the cache. Defaults to `1h`.
+	JWKSCacheTTL string `json:"jwksCacheTTL,omitempty"`
+	// The issuer that ID tokens must be issued by. When unset, it is discovered from
+	// `id-tokens/.well-known/openid-configuration` under the metadata URL, and tokens are
+	// rejected while it cannot be.
+	Issuer string `json:"issuer,omitempty"`
+	// The client ID kVDI is registered with at the metadata server. ID tokens must be
+	// issued for it when `audiences` is unset.
+	ClientID string `json:"clientID,omitempty"`
+	// The audiences accepted in ID tokens. A token must be issued for at least one of
+	// them. Defaults to the `clientID`. Tokens are rejected when neither is set.
+	Audiences []string `json:"audiences,omitempty"`
+	// The timeout for requests to the metadata server. Defaults to `10s`.
+	RequestTimeout string `json:"requestTimeout,omitempty"`
+	// Set to true to skip TLS verification of the metadata server.
+	TLSInsecureSkipVerify bool `json:"tlsInsecureSkipVerify,omitempty"`
+	// The base64 encoded CA certificate to use when verifying the TLS certificate of
+	// the metadata server.
+	TLSCACert string `json:"tlsCACert,omitempty"`
+	// Set to true to validate tokens with the metadata server's `id-tokens/validate`
+	// endpoint when they cannot be verified locally, because the key set could not be
+	// fetched or does not contain the signing key. Tokens with an invalid signature or
+	// claims are always rejected.
+	AllowRemoteValidation bool `json:"allowRemoteValidation,omitempty"`
+}
+
+// ClientCertConfig represents configurations for authenticating users with X.509 client
+// certificates. When enabled, the app server requests a certificate from clients, so TLS
+// must be terminated by the app server itself and not by an ingress in front of it.
+type ClientCertConfig struct {
+	// The base64 encoded PEM bundle of the certificate authorities that client certificates
+	// must be issued by. Intermediate certificates may be included in the bundle.
+	CACertificates string `json:"caCertificates,omitempty"`
+	// Where in the certificate to read the username from. `commonName` uses the subject
+	// common name, while `email`, `dns`, and `uri` use the first subject alternative name
+	// of that type. Defaults to `commonName`.
+	UsernameFrom ClientCertUsernameSource `json:"usernameFrom,omitempty"`
+	// Organizational units or organizations in certificate subjects that are allowed
+	// administrator access to the cluster. Kubernetes admins will still have the ability to change rbac
+	// configurations via the CRDs.
+	AdminGroups []string `json:"adminGroups,omitempty"`
+}
+
+// ClientCertUsernameSource is the part of a client certificate a username is read from.
+// +kubebuilder:validation:Enum=commonName;email;dns;uri
+type ClientCertUsernameSource string
+
+// Valid client certificate username sources
+const (
+	ClientCertUsernameFromCommonName ClientCertUsernameSource = "commonName"
+	ClientCertUsernameFromEmail      ClientCertUsernameSource = "email"
+	ClientCertUsernameFromDNS        ClientCertUsernameSource = "dns"
+	ClientCertUsernameFromURI        ClientCertUsernameSource = "uri"
+)
+
+// KubernetesConfig represents configurations for authenticating Kubernetes ServiceAccounts
+// with their tokens. Tokens are validated with the TokenReview API and ServiceAccounts
+// or their groups are bound to VDIRoles with the `kvdi.io/kubernetes-subjects` annotation.
+type KubernetesConfig struct {
+	// The audiences tokens must be issued for. When empty, the audience of the
+	// Kubernetes API server is expected.
+	Audiences []string `json:"audiences,omitempty"`
 }
 
 // LDAPConfig represents the configurations for using LDAP as the authentication
@@ -261,8 +476,42 @@ type LDAPConfig struct {
 	// When set to true, the authentication provider will query the user's attributes for the `userStatusAttribute`
 	// and make sure it matches the value in `userStatusEnabledValue` before attemtping to bind.
 	DoStatusCheck bool `json:"doStatusCheck,omitempty"`
+	// The base scope to search for groups in. When set, the groups of a user are found by
+	// searching for group entries with the `groupSearchFilter` instead of reading the
+	// `userGroupsAttribute` of the user.
+	GroupSearchBase string `json:"groupSearchBase,omitempty"`
+	// The filter to use when searching for the groups of a user. `%s` is replaced with the
+	// DN of the user, or of a group when resolving nested groups. Defaults to `(member=%s)`.
+	// Only takes effect if `groupSearchBase` is set.
+	GroupSearchFilter string `json:"groupSearchFilter,omitempty"`
+	// How to resolve groups that are members of other groups. `none` only uses the groups a
+	// user is a direct member of. `recursive` follows the parents of each group up to
+	// `maxGroupDepth` levels. `inChain` uses Active Directory's LDAP_MATCHING_RULE_IN_CHAIN
+	// to resolve all of a user's groups in a single search. Defaults to `none`.
+	NestedGroups LDAPNestedGroups `json:"nestedGroups,omitempty"`
+	// The maximum levels of parent groups to follow when `nestedGroups` is `recursive`.
+	// Defaults to 10.
+	MaxGroupDepth int `json:"maxGroupDepth,omitempty"`
+	// The maximum number of connections to keep open to the LDAP server. Defaults to 10.
+	MaxConnections int `json:"maxConnections,omitempty"`
+	// How long a pooled connection can sit unused before it is closed. Defaults to `5m`.
+	ConnectionIdleTimeout string `json:"connectionIdleTimeout,omitempty"`
+	// How long the list of users served by the API is cached. Defaults to `1m`. Set to
+	// `0s` to disable caching.
+	UserCacheTTL string `json:"userCacheTTL,omitempty"`
 }
 
+// LDAPNestedGroups is how groups that are members of other groups are resolved.
+// +kubebuilder:validation:Enum=none;recursive;inChain
+type LDAPNestedGroups string
+
+// Valid nested group resolution modes
+const (
+	LDAPNestedGroupsNone      LDAPNestedGroups = "none"
+	LDAPNestedGroupsRecursive LDAPNestedGroups = "recursive"
+	LDAPNestedGroupsInChain   LDAPNestedGroups = "inChain"
+)
+
 // IsUndefined returns true if the given LDAPConfig object is not actually configured.
 // It checks that required values are present.
 func (l *LDAPConfig) IsUndefined() bool { return l.URL == "" }
@@ -288,6 +537,10 @@ type OIDCConfig struct {
 	// path where kvdi is hosted followed by `/api/login`. For example, if `kvdi` is
 	// hosted at https://kvdi.local, then this value should be set `https://kvdi.local/api/login`.
 	RedirectURL string `json:"redirectURL,omitempty"`
+	// The URL the OIDC provider should send users back to after they log out of kvdi.
+	// It must be registered with the provider as a post-logout redirect URI. For example,
+	// `https://kvdi.local/#/login`. When unset, the provider decides where users land.
+	PostLogoutRedirectURL string `json:"postLogoutRedirectURL,omitempty"`
 	// The scopes to request with the authentication request. Defaults to
 	// `["openid", "email", "profile", "groups"]`.
 	Scopes []string `json:"scopes,omitempty"`
@@ -312,9 +565,11 @@ type OIDCConfig struct {
 	//
 	//   - `{{ .Session.Data.access_token }}`
 	//   - `{{ .Session.Data.token_type }}`
-	//   - `{{ .Session.Data.refresh_token }}`
 	//   - `{{ .Session.Data.expiry }}`
 	//
+	// The refresh token is kept in the secrets backend instead, and is used to renew kvdi sessions
+	// and re-derive the user's groups without sending them back through the OIDC provider.
+	//
 	// **NOTE:** This should be considered an insecure option and only turned on taking into account
 	// the inherent risks. If the access token used for authorizing actions against the kvdi API gets compromised,
 	// it would be relatively easy for the attacker to extract this information from the token and use it for
@@ -330,6 +585,59 @@ type OIDCConfig struct {
 // It checks that required values are present.
 func (o *OIDCConfig) IsUndefined() bool { return o.IssuerURL == "" || o.RedirectURL == "" }
 
+// SAMLConfig represents configurations for using a SAML 2.0 identity provider for
+// authentication. kVDI acts as the service provider, sending signed AuthnRequests
+// over the HTTP-Redirect binding and receiving assertions over the HTTP-POST binding.
+type SAMLConfig struct {
+	// The entity ID kVDI identifies itself with to the identity provider. Defaults to
+	// the URL of the service provider metadata, which is the `acsURL` with the trailing
+	// `/acs` replaced by `/metadata`.
+	EntityID string `json:"entityID,omitempty"`
+	// The assertion consumer service URL configured in the identity provider. This should
+	// be the full path where kvdi is hosted followed by `/api/saml/acs`. For example, if
+	// `kvdi` is hosted at https://kvdi.local, then this value should be set to
+	// `https://kvdi.local/api/saml/acs`.
+	ACSURL string `json:"acsURL,omitempty"`
+	// The URL of the identity provider's single sign-on service supporting the
+	// HTTP-Redirect binding.
+	IDPSSOURL string `json:"idpSSOURL,omitempty"`
+	// The entity ID of the identity provider. When set, the issuer of responses and
+	// assertions must match this value.
+	IDPEntityID string `json:"idpEntityID,omitempty"`
+	// The base64 encoded PEM certificate used by the identity provider to sign responses.
+	// Multiple certificates may be concatenated to support key rollover.
+	IDPCertificate string `json:"idpCertificate,omitempty"`
+	// When using the built-in secrets backend, the key to where the PEM encoded service
+	// provider certificate is stored. A self-signed certificate is generated when it does
+	// not exist. When configuring `spCredentialsSecret`, set this to the key in that
+	// secret. Defaults to `saml-sp-cert`.
+	SPCertificateKey string `json:"spCertificateKey,omitempty"`
+	// Similar to `spCertificateKey`, but for the location of the PEM encoded private key
+	// used to sign AuthnRequests. Defaults to `saml-sp-key`.
+	SPPrivateKeyKey string `json:"spPrivateKeyKey,omitempty"`
+	// When creating your own kubernetes secret with the `spCertificateKey` and `spPrivateKeyKey`,
+	// set this to the name of the created secret. It must be in the same namespace
+	// as the manager and app instances.
+	SPCredentialsSecret string `json:"spCredentialsSecret,omitempty"`
+	// The assertion attribute to use as the username. Defaults to the `NameID` of the
+	// assertion subject.
+	UsernameAttribute string `json:"usernameAttribute,omitempty"`
+	// The assertion attribute containing the user's groups. These are bound to VDIRoles
+	// through the `kvdi.io/saml-groups` annotation. Defaults to `groups`.
+	GroupsAttribute string `json:"groupsAttribute,omitempty"`
+	// Groups that are allowed administrator access to the cluster. Kubernetes
+	// admins will still have the ability to change rbac configurations via the CRDs.
+	AdminGroups []string `json:"adminGroups,omitempty"`
+	// Set to true to allow users without any groups in their assertion read-only access.
+	AllowNonGroupedReadOnly bool `json:"allowNonGroupedReadOnly,omitempty"`
+}
+
+// IsUndefined returns true if the given SAMLConfig object is not actually configured.
+// It checks that required values are present.
+func (s *SAMLConfig) IsUndefined() bool {
+	return s.ACSURL == "" || s.IDPSSOURL == "" || s.IDPCertificate == ""
+}
+
 // K8SSecretConfig uses a Kubernetes secret to store and retrieve sensitive values.
 type K8SSecretConfig struct {
 	// The name of the secret backing the values. Default is `<cluster-name>-app-secrets`.
@@ -0,0 +1,101 @@
+/*
+
+Copyright 2020,2021 Avi Zimmerman
+
+This file is part of kvdi.
+
+kvdi is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+kvdi is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package v1
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	v1 "github.com/kvdi/kvdi/apis/meta/v1"
+	rbacv1 "github.com/kvdi/kvdi/apis/rbac/v1"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// GetLocalUsers returns a list of all the VDIUsers that belong to this cluster instance.
+func (c *VDICluster) GetLocalUsers(cl client.Client) ([]*rbacv1.VDIUser, error) {
+	userList := &rbacv1.VDIUserList{}
+	err := cl.List(
+		context.TODO(),
+		userList,
+		client.InNamespace(metav1.NamespaceAll),
+		client.MatchingLabels{v1.RoleClusterRefLabel: c.GetName()},
+	)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]*rbacv1.VDIUser, len(userList.Items))
+	for i := range userList.Items {
+		out[i] = &userList.Items[i]
+	}
+	return out, nil
+}
+
+// GetSCIMUsers returns the VDIUsers of this cluster that were provisioned over SCIM.
+func (c *VDICluster) GetSCIMUsers(cl client.Client) ([]*rbacv1.VDIUser, error) {
+	userList := &rbacv1.VDIUserList{}
+	err := cl.List(
+		context.TODO(),
+		userList,
+		client.InNamespace(metav1.NamespaceAll),
+		client.MatchingLabels{
+			v1.RoleClusterRefLabel:  c.GetName(),
+			v1.SCIMProvisionedLabel: "true",
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]*rbacv1.VDIUser, len(userList.Items))
+	for i := range userList.Items {
+		out[i] = &userList.Items[i]
+	}
+	return out, nil
+}
+
+// GetLocalUserObjectName returns the name to use for the VDIUser and password secret of
+// a new user. VDIUsers are cluster-scoped, so the name is prefixed with the name of the
+// VDICluster. Usernames that are not valid object names are cleaned up and suffixed
+// with a hash of the username so they can't collide.
+func (c *VDICluster) GetLocalUserObjectName(username string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(username) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '-' || r == '.' {
+			b.WriteRune(r)
+			continue
+		}
+		b.WriteRune('-')
+	}
+	name := strings.Trim(b.String(), "-.")
+	if name != username || len(name) > 200 {
+		sum := sha256.Sum256([]byte(username))
+		if len(name) > 200 {
+			name = name[:200]
+		}
+		name = strings.Trim(fmt.Sprintf("%s-%s", name, hex.EncodeToString(sum[:4])), "-")
+	}
+	return fmt.Sprintf("%s-%s", c.GetName(), name)
+}
@@ -48,6 +48,11 @@ func (in *AppConfig) DeepCopyInto(out *AppConfig) {
 		**out = **in
 	}
 	in.Resources.DeepCopyInto(&out.Resources)
+	if in.TrustedProxies != nil {
+		in, out := &in.TrustedProxies, &out.TrustedProxies
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AppConfig.
@@ -73,7 +78,7 @@ func (in *AuthConfig) DeepCopyInto(out *AuthConfig) {
 	if in.LocalAuth != nil {
 		in, out := &in.LocalAuth, &out.LocalAuth
 		*out = new(LocalAuthConfig)
-		**out = **in
+		(*in).DeepCopyInto(*out)
 	}
 	if in.LDAPAuth != nil {
 		in, out := &in.LDAPAuth, &out.LDAPAuth
@@ -85,11 +90,51 @@ func (in *AuthConfig) DeepCopyInto(out *AuthConfig) {
 		*out = new(OIDCConfig)
 		(*in).DeepCopyInto(*out)
 	}
+	if in.SAMLAuth != nil {
+		in, out := &in.SAMLAuth, &out.SAMLAuth
+		*out = new(SAMLConfig)
+		(*in).DeepCopyInto(*out)
+	}
 	if in.WebmeshAuth != nil {
 		in, out := &in.WebmeshAuth, &out.WebmeshAuth
 		*out = new(WebmeshConfig)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.ClientCertAuth != nil {
+		in, out := &in.ClientCertAuth, &out.ClientCertAuth
+		*out = new(ClientCertConfig)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.KubernetesAuth != nil {
+		in, out := &in.KubernetesAuth, &out.KubernetesAuth
+		*out = new(KubernetesConfig)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Providers != nil {
+		in, out := &in.Providers, &out.Providers
+		*out = make([]AuthMethod, len(*in))
+		copy(*out, *in)
+	}
+	if in.TokenSigning != nil {
+		in, out := &in.TokenSigning, &out.TokenSigning
+		*out = new(TokenSigningConfig)
+		**out = **in
+	}
+	if in.Lockout != nil {
+		in, out := &in.Lockout, &out.Lockout
+		*out = new(LockoutConfig)
 		**out = **in
 	}
+	if in.SCIM != nil {
+		in, out := &in.SCIM, &out.SCIM
+		*out = new(SCIMConfig)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.WebAuthn != nil {
+		in, out := &in.WebAuthn, &out.WebAuthn
+		*out = new(WebAuthnConfig)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AuthConfig.
@@ -102,6 +147,26 @@ func (in *AuthConfig) DeepCopy() *AuthConfig {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ClientCertConfig) DeepCopyInto(out *ClientCertConfig) {
+	*out = *in
+	if in.AdminGroups != nil {
+		in, out := &in.AdminGroups, &out.AdminGroups
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ClientCertConfig.
+func (in *ClientCertConfig) DeepCopy() *ClientCertConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(ClientCertConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *DesktopsConfig) DeepCopyInto(out *DesktopsConfig) {
 	*out = *in
@@ -147,6 +212,26 @@ func (in *K8SSecretConfig) DeepCopy() *K8SSecretConfig {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KubernetesConfig) DeepCopyInto(out *KubernetesConfig) {
+	*out = *in
+	if in.Audiences != nil {
+		in, out := &in.Audiences, &out.Audiences
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KubernetesConfig.
+func (in *KubernetesConfig) DeepCopy() *KubernetesConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(KubernetesConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *LDAPConfig) DeepCopyInto(out *LDAPConfig) {
 	*out = *in
@@ -170,6 +255,11 @@ func (in *LDAPConfig) DeepCopy() *LDAPConfig {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *LocalAuthConfig) DeepCopyInto(out *LocalAuthConfig) {
 	*out = *in
+	if in.PasswordPolicy != nil {
+		in, out := &in.PasswordPolicy, &out.PasswordPolicy
+		*out = new(PasswordPolicy)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new LocalAuthConfig.
@@ -182,6 +272,21 @@ func (in *LocalAuthConfig) DeepCopy() *LocalAuthConfig {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *LockoutConfig) DeepCopyInto(out *LockoutConfig) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new LockoutConfig.
+func (in *LockoutConfig) DeepCopy() *LockoutConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(LockoutConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *MetricsConfig) DeepCopyInto(out *MetricsConfig) {
 	*out = *in
@@ -237,6 +342,21 @@ func (in *OIDCConfig) DeepCopy() *OIDCConfig {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PasswordPolicy) DeepCopyInto(out *PasswordPolicy) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PasswordPolicy.
+func (in *PasswordPolicy) DeepCopy() *PasswordPolicy {
+	if in == nil {
+		return nil
+	}
+	out := new(PasswordPolicy)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *PrometheusConfig) DeepCopyInto(out *PrometheusConfig) {
 	*out = *in
@@ -253,6 +373,46 @@ func (in *PrometheusConfig) DeepCopy() *PrometheusConfig {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SAMLConfig) DeepCopyInto(out *SAMLConfig) {
+	*out = *in
+	if in.AdminGroups != nil {
+		in, out := &in.AdminGroups, &out.AdminGroups
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SAMLConfig.
+func (in *SAMLConfig) DeepCopy() *SAMLConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(SAMLConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SCIMConfig) DeepCopyInto(out *SCIMConfig) {
+	*out = *in
+	if in.Methods != nil {
+		in, out := &in.Methods, &out.Methods
+		*out = make([]AuthMethod, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SCIMConfig.
+func (in *SCIMConfig) DeepCopy() *SCIMConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(SCIMConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *SecretsConfig) DeepCopyInto(out *SecretsConfig) {
 	*out = *in
@@ -315,6 +475,21 @@ func (in *TLSConfig) DeepCopy() *TLSConfig {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TokenSigningConfig) DeepCopyInto(out *TokenSigningConfig) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TokenSigningConfig.
+func (in *TokenSigningConfig) DeepCopy() *TokenSigningConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(TokenSigningConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *UserdataSelector) DeepCopyInto(out *UserdataSelector) {
 	*out = *in
@@ -494,9 +669,34 @@ func (in *VaultConfig) DeepCopy() *VaultConfig {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WebAuthnConfig) DeepCopyInto(out *WebAuthnConfig) {
+	*out = *in
+	if in.Origins != nil {
+		in, out := &in.Origins, &out.Origins
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WebAuthnConfig.
+func (in *WebAuthnConfig) DeepCopy() *WebAuthnConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(WebAuthnConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *WebmeshConfig) DeepCopyInto(out *WebmeshConfig) {
 	*out = *in
+	if in.Audiences != nil {
+		in, out := &in.Audiences, &out.Audiences
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WebmeshConfig.
@@ -25,6 +25,8 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
+
+	rbacv1 "github.com/kvdi/kvdi/apis/rbac/v1"
 )
 
 // DesktopInit represents the init system that the desktop container uses.
@@ -114,6 +116,13 @@ type ProxyConfig struct {
 	// desktop sessions booted from this template. When using a `qemu` configuration with
 	// SPICE, file upload is enabled by default.
 	AllowFileTransfer bool `json:"allowFileTransfer,omitempty"`
+	// Restrictions to place on file transfers when they are allowed. When omitted, all
+	// files may be transferred in both directions.
+	FileTransferPolicy *FileTransferPolicy `json:"fileTransferPolicy,omitempty"`
+	// DenyAudioCapture disables microphone capture for desktops booted from this
+	// template. The kvdi-proxy will not create a recording device and audio streams
+	// will only carry playback.
+	DenyAudioCapture bool `json:"denyAudioCapture,omitempty"`
 	// The address the display server listens on inside the image. This defaults to the
 	// UNIX socket `/var/run/kvdi/display.sock`. The kvdi-proxy sidecar will forward
 	// websockify requests validated by mTLS to this socket. Must be in the format of
@@ -128,6 +137,48 @@ type ProxyConfig struct {
 	PulseServer string `json:"pulseServer,omitempty"`
 	// Resource restraints to place on the proxy sidecar.
 	Resources corev1.ResourceRequirements `json:"resources,omitempty"`
+	// Bandwidth limits to apply to display and audio streams for desktops booted from
+	// this template. When the user's roles also define limits, the most restrictive
+	// values are used.
+	BandwidthLimits *rbacv1.BandwidthLimits `json:"bandwidthLimits,omitempty"`
+}
+
+// FileTransferMode represents the directions in which files may be transferred.
+// +kubebuilder:validation:Enum=Both;UploadOnly;DownloadOnly
+type FileTransferMode string
+
+const (
+	// FileTransferBoth allows files to be uploaded to and downloaded from desktops.
+	FileTransferBoth FileTransferMode = "Both"
+	// FileTransferUploadOnly only allows files to be uploaded to desktops.
+	FileTransferUploadOnly FileTransferMode = "UploadOnly"
+	// FileTransferDownloadOnly only allows files to be downloaded from desktops.
+	FileTransferDownloadOnly FileTransferMode = "DownloadOnly"
+)
+
+// FileTransferPolicy represents restrictions on the files that can be transferred to
+// and from desktop sessions.
+type FileTransferPolicy struct {
+	// The directions in which files may be transferred. Defaults to `Both`.
+	Mode FileTransferMode `json:"mode,omitempty"`
+	// The maximum size in bytes of a single transferred file. Directories are
+	// measured by the size of the archive they are downloaded as.
+	MaxFileSize int64 `json:"maxFileSize,omitempty"`
+	// File extensions that may be transferred, e.g. `.pdf`. When defined, files
+	// with any other extension are rejected.
+	AllowedExtensions []string `json:"allowedExtensions,omitempty"`
+	// File extensions that may not be transferred. Takes precedence over
+	// `allowedExtensions`.
+	DeniedExtensions []string `json:"deniedExtensions,omitempty"`
+	// MIME types that may be transferred, e.g. `image/png` or `text/*`. The type is
+	// detected from the contents of the file. When defined, files of any other type
+	// are rejected.
+	AllowedMIMETypes []string `json:"allowedMIMETypes,omitempty"`
+	// MIME types that may not be transferred. Takes precedence over `allowedMIMETypes`.
+	DeniedMIMETypes []string `json:"deniedMIMETypes,omitempty"`
+	// The total number of bytes a single user may transfer to and from desktops
+	// booted from this template each day (UTC). This is only enforced by the API.
+	DailyUserQuota int64 `json:"dailyUserQuota,omitempty"`
 }
 
 // DockerInDockerConfig is a configuration for mounting a DinD sidecar with desktops
@@ -0,0 +1,116 @@
+/*
+
+Copyright 2020,2021 Avi Zimmerman
+
+This file is part of kvdi.
+
+kvdi is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+kvdi is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package v1
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// GetFileTransferPolicy returns the restrictions to place on file transfers for desktops
+// booted from the template. Nil means no restrictions.
+func (t *Template) GetFileTransferPolicy() *FileTransferPolicy {
+	if t.Spec.ProxyConfig != nil {
+		return t.Spec.ProxyConfig.FileTransferPolicy
+	}
+	return nil
+}
+
+// FileUploadEnabled returns true if files may be uploaded to desktops booted from
+// the template.
+func (t *Template) FileUploadEnabled() bool {
+	return t.FileTransferEnabled() && t.GetFileTransferPolicy().AllowsUpload()
+}
+
+// FileDownloadEnabled returns true if files may be downloaded from desktops booted
+// from the template.
+func (t *Template) FileDownloadEnabled() bool {
+	return t.FileTransferEnabled() && t.GetFileTransferPolicy().AllowsDownload()
+}
+
+// AllowsUpload returns true if this policy allows uploading files.
+func (f *FileTransferPolicy) AllowsUpload() bool {
+	return f == nil || f.Mode != FileTransferDownloadOnly
+}
+
+// AllowsDownload returns true if this policy allows downloading files.
+func (f *FileTransferPolicy) AllowsDownload() bool {
+	return f == nil || f.Mode != FileTransferUploadOnly
+}
+
+// CheckFile returns an error if a file with the given name, detected MIME type,
+// and size is not allowed to be transferred by this policy.
+func (f *FileTransferPolicy) CheckFile(name, mimeType string, size int64) error {
+	if f == nil {
+		return nil
+	}
+	if f.MaxFileSize > 0 && size > f.MaxFileSize {
+		return fmt.Errorf("%s exceeds the maximum file size of %d bytes", name, f.MaxFileSize)
+	}
+	ext := strings.ToLower(filepath.Ext(name))
+	if matchesExtension(f.DeniedExtensions, ext) {
+		return fmt.Errorf("files with the extension '%s' may not be transferred", ext)
+	}
+	if len(f.AllowedExtensions) > 0 && !matchesExtension(f.AllowedExtensions, ext) {
+		return fmt.Errorf("files with the extension '%s' may not be transferred", ext)
+	}
+	// Strip any parameters from the type, e.g. "text/plain; charset=utf-8"
+	mimeType = strings.ToLower(strings.TrimSpace(strings.Split(mimeType, ";")[0]))
+	if matchesMIMEType(f.DeniedMIMETypes, mimeType) {
+		return fmt.Errorf("files of type '%s' may not be transferred", mimeType)
+	}
+	if len(f.AllowedMIMETypes) > 0 && !matchesMIMEType(f.AllowedMIMETypes, mimeType) {
+		return fmt.Errorf("files of type '%s' may not be transferred", mimeType)
+	}
+	return nil
+}
+
+// matchesExtension returns true if the given lowercase extension is in the list. Entries
+// in the list may be given with or without the leading dot.
+func matchesExtension(exts []string, ext string) bool {
+	for _, e := range exts {
+		e = strings.ToLower(e)
+		if !strings.HasPrefix(e, ".") {
+			e = "." + e
+		}
+		if e == ext {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesMIMEType returns true if the given lowercase MIME type is in the list. Entries
+// in the list may use a wildcard subtype, e.g. `image/*`.
+func matchesMIMEType(types []string, mimeType string) bool {
+	for _, t := range types {
+		t = strings.ToLower(t)
+		if t == mimeType || t == "*/*" {
+			return true
+		}
+		if strings.HasSuffix(t, "/*") && strings.HasPrefix(mimeType, strings.TrimSuffix(t, "*")) {
+			return true
+		}
+	}
+	return false
+}
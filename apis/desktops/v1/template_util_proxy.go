@@ -22,6 +22,7 @@
 package v1
 
 import (
+	"encoding/json"
 	"fmt"
 	"path"
 	"path/filepath"
@@ -31,6 +32,7 @@ import (
 	corev1 "k8s.io/api/core/v1"
 
 	v1 "github.com/kvdi/kvdi/apis/meta/v1"
+	rbacv1 "github.com/kvdi/kvdi/apis/rbac/v1"
 	"github.com/kvdi/kvdi/pkg/version"
 )
 
@@ -43,6 +45,24 @@ func (t *Template) FileTransferEnabled() bool {
 	return false
 }
 
+// GetBandwidthLimits returns the bandwidth limits to apply to display and audio streams
+// for desktops booted from the template. Nil means no limits.
+func (t *Template) GetBandwidthLimits() *rbacv1.BandwidthLimits {
+	if t.Spec.ProxyConfig != nil {
+		return t.Spec.ProxyConfig.BandwidthLimits
+	}
+	return nil
+}
+
+// AudioCaptureAllowed returns true if desktops booted from this template may
+// capture audio from the user's microphone.
+func (t *Template) AudioCaptureAllowed() bool {
+	if t.Spec.ProxyConfig != nil {
+		return !t.Spec.ProxyConfig.DenyAudioCapture
+	}
+	return true
+}
+
 // GetPulseServer returns the pulse server to give to the proxy for handling audio streams.
 func (t *Template) GetPulseServer() string {
 	if t.Spec.ProxyConfig != nil && t.Spec.ProxyConfig.PulseServer != "" {
@@ -179,5 +199,15 @@ func (t *Template) GetDesktopProxyContainer() corev1.Container {
 		Resources:    t.GetProxyResources(),
 	}
 
+	if policy := t.GetFileTransferPolicy(); t.FileTransferEnabled() && policy != nil {
+		// The policy is made up of plain fields, so marshaling can't fail
+		out, _ := json.Marshal(policy)
+		c.Args = append(c.Args, "--file-transfer-policy", string(out))
+	}
+
+	if !t.AudioCaptureAllowed() {
+		c.Args = append(c.Args, "--deny-audio-capture")
+	}
+
 	return c
 }
@@ -27,6 +27,7 @@
 package v1
 
 import (
+	rbacv1 "github.com/kvdi/kvdi/apis/rbac/v1"
 	corev1 "k8s.io/api/core/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
@@ -111,10 +112,55 @@ func (in *DockerInDockerConfig) DeepCopy() *DockerInDockerConfig {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *FileTransferPolicy) DeepCopyInto(out *FileTransferPolicy) {
+	*out = *in
+	if in.AllowedExtensions != nil {
+		in, out := &in.AllowedExtensions, &out.AllowedExtensions
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.DeniedExtensions != nil {
+		in, out := &in.DeniedExtensions, &out.DeniedExtensions
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.AllowedMIMETypes != nil {
+		in, out := &in.AllowedMIMETypes, &out.AllowedMIMETypes
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.DeniedMIMETypes != nil {
+		in, out := &in.DeniedMIMETypes, &out.DeniedMIMETypes
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new FileTransferPolicy.
+func (in *FileTransferPolicy) DeepCopy() *FileTransferPolicy {
+	if in == nil {
+		return nil
+	}
+	out := new(FileTransferPolicy)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ProxyConfig) DeepCopyInto(out *ProxyConfig) {
 	*out = *in
+	if in.FileTransferPolicy != nil {
+		in, out := &in.FileTransferPolicy, &out.FileTransferPolicy
+		*out = new(FileTransferPolicy)
+		(*in).DeepCopyInto(*out)
+	}
 	in.Resources.DeepCopyInto(&out.Resources)
+	if in.BandwidthLimits != nil {
+		in, out := &in.BandwidthLimits, &out.BandwidthLimits
+		*out = new(rbacv1.BandwidthLimits)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ProxyConfig.
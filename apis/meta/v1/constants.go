@@ -27,8 +27,12 @@ import (
 )
 
 const (
-	// RoleClusterRefLabel marks for which cluster a role belongs
+	// RoleClusterRefLabel marks for which cluster a role or user belongs
 	RoleClusterRefLabel = "kvdi.io/cluster-ref"
+	// SCIMProvisionedLabel marks VDIUsers that were provisioned over SCIM. Only these
+	// users can be managed over SCIM or have their roles applied to users of other
+	// authentication methods.
+	SCIMProvisionedLabel = "kvdi.io/scim-provisioned"
 	// CreationSpecAnnotation contains the serialized creation spec of a resource
 	// to be compared against desired state.
 	CreationSpecAnnotation = "kvdi.io/creation-spec"
@@ -40,10 +44,27 @@ const (
 	// to groups provided in claims from an OIDC provider. A semicolon separated list can
 	// bind a role to multiple groups.
 	OIDCGroupRoleAnnotation = "kvdi.io/oidc-groups"
+	// SAMLGroupRoleAnnotation is the annotation applied to VDIRoles to "bind" them
+	// to groups provided in the attributes of a SAML assertion. A semicolon separated
+	// list can bind a role to multiple groups.
+	SAMLGroupRoleAnnotation = "kvdi.io/saml-groups"
 	// WebmeshGroupRoleAnnotation is the annotation applied to VDIRoles to "bind" them
 	// to groups provided in claims from a Webmesh provider. A semicolon separated list can
 	// bind a role to multiple groups.
 	WebmeshGroupRoleAnnotation = "kvdi.io/webmesh-groups"
+	// ClientCertGroupRoleAnnotation is the annotation applied to VDIRoles to "bind" them
+	// to the organizational units and organizations in the subject of client certificates.
+	// A semicolon separated list can bind a role to multiple groups.
+	ClientCertGroupRoleAnnotation = "kvdi.io/clientcert-groups"
+	// SCIMGroupRoleAnnotation is the annotation applied to VDIRoles to "bind" them
+	// to groups provisioned over SCIM. A semicolon separated list can bind a role to
+	// multiple groups.
+	SCIMGroupRoleAnnotation = "kvdi.io/scim-groups"
+	// KubernetesSubjectRoleAnnotation is the annotation applied to VDIRoles to "bind" them
+	// to Kubernetes ServiceAccounts (e.g. `system:serviceaccount:ci:runner`) or their groups
+	// (e.g. `system:serviceaccounts:ci`). A semicolon separated list can bind a role to
+	// multiple subjects.
+	KubernetesSubjectRoleAnnotation = "kvdi.io/kubernetes-subjects"
 	// AuthGroupSeparator is the separator used when parsing lists of groups from a string.
 	AuthGroupSeparator = ";"
 	// VDIClusterLabel is the label attached to resources to reference their parents VDI cluster
@@ -63,12 +84,53 @@ const (
 	ClientCertificateMountPath = "/etc/kvdi/tls/client"
 	// SecretAssetsMountPath is a mount path for assets backed by secrets
 	SecretAssetsMountPath = "/etc/kvdi/secrets"
-	// JWTSecretKey is where our JWT secret is stored in the secrets backend.
-	JWTSecretKey = "jwtSecret"
+	// SCIMTokenSecretKey is where the bearer token SCIM clients authenticate with is stored
+	// in the secrets backend.
+	SCIMTokenSecretKey = "scimToken"
+	// SCIMGroupsSecretKey is where the groups provisioned over SCIM are stored in the secrets
+	// backend.
+	SCIMGroupsSecretKey = "scimGroups"
+	// JWTSigningKeysSecretKey is where the keys used to sign access tokens are stored in the
+	// secrets backend.
+	JWTSigningKeysSecretKey = "jwtSigningKeys"
 	// OTPUsersSecretKey is where a mapping of users to their OTP secrets is held in the secrets backend.
 	OTPUsersSecretKey = "otpUsers"
-	// RefreshTokensSecretKey is where a mapping of refresh tokens to users is kept in the secrets backend.
+	// WebAuthnCredentialsSecretKey is where a mapping of users to their WebAuthn credentials is held
+	// in the secrets backend.
+	WebAuthnCredentialsSecretKey = "webauthnCredentials"
+	// WebAuthnChallengesSecretKey is where pending WebAuthn ceremony challenges are held in the
+	// secrets backend.
+	WebAuthnChallengesSecretKey = "webauthnChallenges"
+	// MFARecoveryCodesSecretKey is where a mapping of users to their hashed MFA recovery codes is held
+	// in the secrets backend.
+	MFARecoveryCodesSecretKey = "mfaRecoveryCodes"
+	// MFAResetsSecretKey is where a mapping of users whose MFA was reset by an administrator, and must
+	// enroll again, is held in the secrets backend.
+	MFAResetsSecretKey = "mfaResets"
+	// LoginFailuresSecretKey is where a mapping of usernames and source addresses to their recent
+	// failed logins and lockouts is held in the secrets backend.
+	LoginFailuresSecretKey = "loginFailures"
+	// OIDCSessionsSecretKey is where a mapping of OIDC logins to the tokens issued for them by the
+	// identity provider is kept in the secrets backend.
+	OIDCSessionsSecretKey = "oidcSessions"
+	// OIDCPKCESecretKey is where a mapping of the states of OIDC flows in progress to their PKCE
+	// code verifiers is kept in the secrets backend.
+	OIDCPKCESecretKey = "oidcPKCEVerifiers"
+	// SAMLAssertionIDsSecretKey is where the IDs of SAML assertions that have been consumed are
+	// kept in the secrets backend until they expire.
+	SAMLAssertionIDsSecretKey = "samlAssertionIDs"
+	// RefreshTokensSecretKey is where a mapping of refresh tokens to the logins they were issued
+	// for is kept in the secrets backend.
 	RefreshTokensSecretKey = "refreshTokens"
+	// RevokedLoginsSecretKey is where a mapping of revoked logins and users, whose access tokens
+	// must no longer be accepted, is kept in the secrets backend.
+	RevokedLoginsSecretKey = "revokedLogins"
+	// APITokensSecretKey is where a mapping of personal API token IDs to their hashed records is kept
+	// in the secrets backend.
+	APITokensSecretKey = "apiTokens"
+	// FileTransferUsageSecretKey is where a mapping of users and templates to their daily file transfer
+	// volume is kept in the secrets backend.
+	FileTransferUsageSecretKey = "fileTransferUsage"
 	// WebPort is the port that web services will listen on internally
 	WebPort = 8443
 	// PublicTLSWebPort is the port for the app service
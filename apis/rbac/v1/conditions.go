@@ -0,0 +1,256 @@
+/*
+
+Copyright 2020,2021 Avi Zimmerman
+
+This file is part of kvdi.
+
+kvdi is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+kvdi is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package v1
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Weekday represents a day of the week in a time window
+// +kubebuilder:validation:Enum=Mon;Tue;Wed;Thu;Fri;Sat;Sun
+type Weekday string
+
+// Weekday options
+const (
+	Monday    Weekday = "Mon"
+	Tuesday   Weekday = "Tue"
+	Wednesday Weekday = "Wed"
+	Thursday  Weekday = "Thu"
+	Friday    Weekday = "Fri"
+	Saturday  Weekday = "Sat"
+	Sunday    Weekday = "Sun"
+)
+
+// weekdays maps Weekdays to their time.Weekday.
+var weekdays = map[Weekday]time.Weekday{
+	Monday:    time.Monday,
+	Tuesday:   time.Tuesday,
+	Wednesday: time.Wednesday,
+	Thursday:  time.Thursday,
+	Friday:    time.Friday,
+	Saturday:  time.Saturday,
+	Sunday:    time.Sunday,
+}
+
+// RuleConditions restrict when a rule applies. All of the configured conditions
+// must be met for the rule to apply.
+type RuleConditions struct {
+	// Time windows during which the rule applies. When set, the request must be made
+	// during at least one of them.
+	TimeWindows []TimeWindow `json:"timeWindows,omitempty"`
+	// CIDRs of the client addresses the rule applies to. When set, the request must come
+	// from an address in at least one of them. Allow rules with this condition never apply
+	// when the client address is unknown, while deny rules always do.
+	SourceCIDRs []string `json:"sourceCIDRs,omitempty"`
+}
+
+// TimeWindow represents a range of time on some days of the week.
+type TimeWindow struct {
+	// The days of the week the window starts on. Defaults to every day.
+	Days []Weekday `json:"days,omitempty"`
+	// The time of day the window starts at, in the format `HH:MM`. Defaults to `00:00`.
+	// +kubebuilder:validation:Pattern=`^([01][0-9]|2[0-3]):[0-5][0-9]$`
+	Start string `json:"start,omitempty"`
+	// The time of day the window ends at, in the format `HH:MM`. The end time is not included
+	// in the window. If it is not after the start time, the window ends on the following day.
+	// Defaults to `24:00`.
+	// +kubebuilder:validation:Pattern=`^(([01][0-9]|2[0-3]):[0-5][0-9]|24:00)$`
+	End string `json:"end,omitempty"`
+	// The IANA name of the time zone the window is in, e.g. `Europe/Berlin`. Defaults to `UTC`.
+	TimeZone string `json:"timeZone,omitempty"`
+}
+
+// Validate returns an error if any of the conditions are invalid.
+func (c *RuleConditions) Validate() error {
+	for _, window := range c.TimeWindows {
+		if err := window.Validate(); err != nil {
+			return err
+		}
+	}
+	for _, cidr := range c.SourceCIDRs {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return fmt.Errorf("%s is an invalid CIDR: %s", cidr, err.Error())
+		}
+	}
+	return nil
+}
+
+// Evaluate returns an error describing the first condition not met by a request made at the
+// given time from the given address. The address may be nil if it is unknown.
+func (c *RuleConditions) Evaluate(t time.Time, source net.IP) error {
+	if len(c.TimeWindows) > 0 {
+		inWindow := false
+		for _, window := range c.TimeWindows {
+			if window.Contains(t) {
+				inWindow = true
+				break
+			}
+		}
+		if !inWindow {
+			return fmt.Errorf("the request time %s is outside of the time windows %s", t.UTC().Format(time.RFC3339), c.timeWindowsString())
+		}
+	}
+	if len(c.SourceCIDRs) > 0 {
+		if source == nil {
+			return errors.New("the client address is unknown")
+		}
+		for _, cidr := range c.SourceCIDRs {
+			if _, ipnet, err := net.ParseCIDR(cidr); err == nil && ipnet.Contains(source) {
+				return nil
+			}
+		}
+		return fmt.Errorf("the client address %s is not in %s", source, strings.Join(c.SourceCIDRs, ","))
+	}
+	return nil
+}
+
+func (c *RuleConditions) timeWindowsString() string {
+	strs := make([]string, len(c.TimeWindows))
+	for i, window := range c.TimeWindows {
+		strs[i] = window.String()
+	}
+	return strings.Join(strs, ",")
+}
+
+// Validate returns an error if the days, times or time zone of the window are invalid.
+func (w *TimeWindow) Validate() error {
+	for _, day := range w.Days {
+		if _, ok := weekdays[day]; !ok {
+			return fmt.Errorf("%s is an invalid day of the week", day)
+		}
+	}
+	if _, err := parseTimeOfDay(w.Start, false); err != nil {
+		return err
+	}
+	if _, err := parseTimeOfDay(w.End, true); err != nil {
+		return err
+	}
+	if _, err := w.location(); err != nil {
+		return fmt.Errorf("%s is an invalid time zone: %s", w.TimeZone, err.Error())
+	}
+	return nil
+}
+
+// Contains returns true if the given time falls within the window. Invalid windows
+// never contain any time.
+func (w *TimeWindow) Contains(t time.Time) bool {
+	loc, err := w.location()
+	if err != nil {
+		return false
+	}
+	start, err := parseTimeOfDay(w.Start, false)
+	if err != nil {
+		return false
+	}
+	end, err := parseTimeOfDay(w.End, true)
+	if err != nil {
+		return false
+	}
+	if w.End == "" {
+		end = 24 * 60
+	}
+	local := t.In(loc)
+	minute := local.Hour()*60 + local.Minute()
+	if start < end {
+		return w.hasDay(local.Weekday()) && minute >= start && minute < end
+	}
+	// The window spans midnight, so it may have started the day before
+	if w.hasDay(local.Weekday()) && minute >= start {
+		return true
+	}
+	return w.hasDay(local.AddDate(0, 0, -1).Weekday()) && minute < end
+}
+
+// String returns a user friendly representation of the window.
+func (w *TimeWindow) String() string {
+	days := "every day"
+	if len(w.Days) > 0 {
+		strs := make([]string, len(w.Days))
+		for i, day := range w.Days {
+			strs[i] = string(day)
+		}
+		days = strings.Join(strs, ",")
+	}
+	start, end, tz := w.Start, w.End, w.TimeZone
+	if start == "" {
+		start = "00:00"
+	}
+	if end == "" {
+		end = "24:00"
+	}
+	if tz == "" {
+		tz = "UTC"
+	}
+	return fmt.Sprintf("[%s %s-%s %s]", days, start, end, tz)
+}
+
+func (w *TimeWindow) hasDay(day time.Weekday) bool {
+	if len(w.Days) == 0 {
+		return true
+	}
+	for _, d := range w.Days {
+		if weekdays[d] == day {
+			return true
+		}
+	}
+	return false
+}
+
+// locations caches the time zones loaded for time windows by their name, so the
+// zone database is only read once per zone instead of on every evaluation.
+var locations sync.Map
+
+func (w *TimeWindow) location() (*time.Location, error) {
+	if w.TimeZone == "" {
+		return time.UTC, nil
+	}
+	if loc, ok := locations.Load(w.TimeZone); ok {
+		return loc.(*time.Location), nil
+	}
+	loc, err := time.LoadLocation(w.TimeZone)
+	if err != nil {
+		return nil, err
+	}
+	locations.Store(w.TimeZone, loc)
+	return loc, nil
+}
+
+// parseTimeOfDay returns the minutes since midnight of a time in the format HH:MM.
+// An empty string is the start of the day.
+func parseTimeOfDay(s string, allowEndOfDay bool) (int, error) {
+	if s == "" {
+		return 0, nil
+	}
+	if allowEndOfDay && s == "24:00" {
+		return 24 * 60, nil
+	}
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("%s is an invalid time of day, expected HH:MM", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
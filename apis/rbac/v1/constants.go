@@ -59,7 +59,7 @@ func resourcesToStrings(r []Resource) []string {
 }
 
 // Verb represents an API action
-// +kubebuilder:validation:Enum=create;read;update;delete;use;launch;*
+// +kubebuilder:validation:Enum=create;read;update;delete;use;launch;impersonate;*
 type Verb string
 
 // Verb options
@@ -76,10 +76,26 @@ const (
 	VerbUse Verb = "use"
 	// Launch operations
 	VerbLaunch Verb = "launch"
+	// Impersonate operations, only evaluated for users. They allow acting as the users
+	// matching the rule with the Impersonate-User header.
+	VerbImpersonate Verb = "impersonate"
 	// VerbAll matches all actions
 	VerbAll Verb = "*"
 )
 
+// RuleEffect represents whether a rule allows or denies the actions it matches
+// +kubebuilder:validation:Enum=Allow;Deny
+type RuleEffect string
+
+// RuleEffect options
+const (
+	// EffectAllow allows the actions matched by a rule. This is the default.
+	EffectAllow RuleEffect = "Allow"
+	// EffectDeny denies the actions matched by a rule, even when another rule
+	// allows them.
+	EffectDeny RuleEffect = "Deny"
+)
+
 func verbsToStrings(r []Verb) []string {
 	out := make([]string, len(r))
 	for x, y := range r {
@@ -0,0 +1,105 @@
+/*
+
+Copyright 2020,2021 Avi Zimmerman
+
+This file is part of kvdi.
+
+kvdi is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+kvdi is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package v1
+
+// BandwidthLimits represent token-bucket limits applied to the display and audio
+// streams proxied through the kVDI API. A value of zero means no limit.
+type BandwidthLimits struct {
+	// The maximum sustained throughput, in bytes per second, of a single display or
+	// audio connection.
+	PerConnectionBytesPerSecond int64 `json:"perConnectionBytesPerSecond,omitempty"`
+	// The maximum sustained throughput, in bytes per second, shared by all of a user's
+	// display and audio connections. Audio streams are given priority over display
+	// streams when the shared budget is exhausted.
+	PerUserBytesPerSecond int64 `json:"perUserBytesPerSecond,omitempty"`
+	// The size of the token buckets in bytes. This is the amount of traffic that can
+	// be sent in a single burst before shaping takes effect. Defaults to one second's
+	// worth of traffic at the configured rate.
+	BurstBytes int64 `json:"burstBytes,omitempty"`
+}
+
+// IsEmpty returns true if these limits do not restrict any traffic.
+func (b *BandwidthLimits) IsEmpty() bool {
+	return b == nil || (b.PerConnectionBytesPerSecond <= 0 && b.PerUserBytesPerSecond <= 0)
+}
+
+// GetBurst returns the bucket size to use for a limiter with the given rate.
+func (b *BandwidthLimits) GetBurst(rate int64) int64 {
+	if b != nil && b.BurstBytes > 0 {
+		return b.BurstBytes
+	}
+	return rate
+}
+
+// Restrict returns a new set of limits containing the most restrictive values of
+// these limits and the ones provided. Either may be nil.
+func (b *BandwidthLimits) Restrict(other *BandwidthLimits) *BandwidthLimits {
+	if b == nil {
+		return other.DeepCopy()
+	}
+	if other == nil {
+		return b.DeepCopy()
+	}
+	return &BandwidthLimits{
+		PerConnectionBytesPerSecond: minLimit(b.PerConnectionBytesPerSecond, other.PerConnectionBytesPerSecond),
+		PerUserBytesPerSecond:       minLimit(b.PerUserBytesPerSecond, other.PerUserBytesPerSecond),
+		BurstBytes:                  minLimit(b.BurstBytes, other.BurstBytes),
+	}
+}
+
+// Relax returns a new set of limits containing the least restrictive values of
+// these limits and the ones provided. Either may be nil.
+func (b *BandwidthLimits) Relax(other *BandwidthLimits) *BandwidthLimits {
+	if b == nil {
+		return other.DeepCopy()
+	}
+	if other == nil {
+		return b.DeepCopy()
+	}
+	return &BandwidthLimits{
+		PerConnectionBytesPerSecond: maxLimit(b.PerConnectionBytesPerSecond, other.PerConnectionBytesPerSecond),
+		PerUserBytesPerSecond:       maxLimit(b.PerUserBytesPerSecond, other.PerUserBytesPerSecond),
+		BurstBytes:                  maxLimit(b.BurstBytes, other.BurstBytes),
+	}
+}
+
+// minLimit returns the smaller of two limits, treating zero as unlimited.
+func minLimit(a, b int64) int64 {
+	if a <= 0 {
+		return b
+	}
+	if b <= 0 || a < b {
+		return a
+	}
+	return b
+}
+
+// maxLimit returns the larger of two limits, treating zero as unlimited.
+func maxLimit(a, b int64) int64 {
+	if a <= 0 || b <= 0 {
+		return 0
+	}
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -22,6 +22,7 @@ along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
 package v1
 
 import (
+	"reflect"
 	"regexp"
 	"sort"
 )
@@ -30,8 +31,16 @@ import (
 // an rbacv1.PolicyRule, with resources being a regex and the addition of a
 // namespace selector.
 type Rule struct {
+	// Whether this rule allows or denies the actions it matches. Defaults to `Allow`.
+	// A `Deny` rule takes precedence over any rule that allows the same action, including
+	// rules in the user's other roles. Unlike allow rules, a deny rule without resource
+	// patterns applies to every resource name, and one without namespaces applies to every
+	// namespace. A deny rule with resource patterns only applies to actions that are not
+	// about a single resource, like listing them, if one of the patterns matches an empty
+	// string (e.g. `.*`).
+	Effect RuleEffect `json:"effect,omitempty"`
 	// The actions this rule applies for. VerbAll matches all actions.
-	// Recognized options are: `["create", "read", "update", "delete", "use", "launch", "*"]`
+	// Recognized options are: `["create", "read", "update", "delete", "use", "launch", "impersonate", "*"]`
 	Verbs []Verb `json:"verbs,omitempty"`
 	// Resources this rule applies to. ResourceAll matches all resources.
 	// Recognized options are: `["users", "roles", "templates", "serviceaccounts", "*"]`
@@ -52,6 +61,15 @@ type Rule struct {
 	// Namespaces this rule applies to. Only evaluated for template launching
 	// permissions. Including "*" as an option matches all namespaces.
 	Namespaces []string `json:"namespaces,omitempty"`
+	// Conditions restricting when this rule applies, such as time windows and the
+	// addresses of clients. They are evaluated against the time and client address of
+	// each API request.
+	Conditions *RuleConditions `json:"conditions,omitempty"`
+}
+
+// IsDeny returns true if this rule denies the actions it matches.
+func (r *Rule) IsDeny() bool {
+	return r.Effect == EffectDeny
 }
 
 // IsEmpty returns true if this rule is empty.
@@ -83,7 +101,9 @@ func (r *Rule) DeepEqual(rule Rule) bool {
 	sort.Strings(that.ResourcePatterns)
 	sort.Strings(that.Namespaces)
 
-	return strSliceEqual(thisResourceStrings, thatResourceStrings) &&
+	return r.IsDeny() == rule.IsDeny() &&
+		reflect.DeepEqual(this.Conditions, that.Conditions) &&
+		strSliceEqual(thisResourceStrings, thatResourceStrings) &&
 		strSliceEqual(thisVerbStrings, thatVerbStrings) &&
 		strSliceEqual(this.ResourcePatterns, that.ResourcePatterns) &&
 		strSliceEqual(this.Namespaces, that.Namespaces)
@@ -37,11 +37,24 @@ type VDIRole struct {
 
 	// A list of rules granting access to resources in the VDICluster.
 	Rules []Rule `json:"rules,omitempty"`
+	// Bandwidth limits to apply to the display and audio streams of users bound
+	// to this role. When a user holds multiple roles with limits, the least
+	// restrictive values are used.
+	BandwidthLimits *BandwidthLimits `json:"bandwidthLimits,omitempty"`
+	// DenyAudioCapture forbids users bound to this role from streaming their
+	// microphone to desktops. Capture is denied if any of a user's roles deny it.
+	DenyAudioCapture bool `json:"denyAudioCapture,omitempty"`
 }
 
 // GetRules returns the rules for this VDIRole.
 func (v *VDIRole) GetRules() []Rule { return v.Rules }
 
+// GetBandwidthLimits returns the bandwidth limits for this VDIRole.
+func (v *VDIRole) GetBandwidthLimits() *BandwidthLimits { return v.BandwidthLimits }
+
+// AudioCaptureDenied returns true if this VDIRole forbids microphone capture.
+func (v *VDIRole) AudioCaptureDenied() bool { return v.DenyAudioCapture }
+
 //+kubebuilder:object:root=true
 
 // VDIRoleList contains a list of VDIRole
@@ -0,0 +1,107 @@
+/*
+
+Copyright 2020,2021 Avi Zimmerman
+
+This file is part of kvdi.
+
+kvdi is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+kvdi is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+//+kubebuilder:object:root=true
+//+kubebuilder:resource:path=vdirolebindings,scope=Cluster
+//+kubebuilder:printcolumn:name="Role",type="string",JSONPath=".spec.roleRef"
+
+// VDIRoleBinding is the Schema for the vdirolebindings API. It binds a VDIRole to
+// users and groups of the authentication providers.
+type VDIRoleBinding struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec VDIRoleBindingSpec `json:"spec,omitempty"`
+}
+
+// VDIRoleBindingSpec defines the desired state of a VDIRoleBinding.
+type VDIRoleBindingSpec struct {
+	// The name of the VDIRole to bind.
+	RoleRef string `json:"roleRef"`
+	// The users and groups bound to the role.
+	Subjects []Subject `json:"subjects,omitempty"`
+}
+
+// SubjectKind is the type of a subject in a VDIRoleBinding.
+// +kubebuilder:validation:Enum=User;Group
+type SubjectKind string
+
+const (
+	// SubjectUser binds a single user.
+	SubjectUser SubjectKind = "User"
+	// SubjectGroup binds every member of a group.
+	SubjectGroup SubjectKind = "Group"
+)
+
+// Subject is a user or group bound to a VDIRole.
+type Subject struct {
+	// Whether the subject is a User or a Group.
+	Kind SubjectKind `json:"kind"`
+	// The name of the user or group as reported by the authentication provider. For
+	// the kubernetes provider, users are full ServiceAccount user names, e.g.
+	// `system:serviceaccount:default:builder`.
+	Name string `json:"name"`
+	// The authentication provider the subject belongs to, e.g. `ldap` or `oidc`. Groups
+	// provisioned over SCIM use `scim`. When empty, the subject belongs to the primary
+	// provider of the cluster, which is the first of its configured providers.
+	// +kubebuilder:validation:Enum=local;ldap;oidc;saml;webmesh;clientcert;kubernetes;scim
+	Provider string `json:"provider,omitempty"`
+}
+
+// GetRoleRef returns the name of the VDIRole this binding applies to.
+func (v *VDIRoleBinding) GetRoleRef() string { return v.Spec.RoleRef }
+
+// GetSubjects returns the subjects bound by this VDIRoleBinding.
+func (v *VDIRoleBinding) GetSubjects() []Subject { return v.Spec.Subjects }
+
+// BelongsTo returns true if this subject belongs to the given provider. Subjects without
+// a provider belong to the primary provider.
+func (s Subject) BelongsTo(provider, primaryProvider string) bool {
+	if s.Provider == "" {
+		return provider == primaryProvider
+	}
+	return s.Provider == provider
+}
+
+// Matches returns true if this subject is of the given kind and name, and belongs to
+// the given provider.
+func (s Subject) Matches(kind SubjectKind, provider, primaryProvider, name string) bool {
+	return s.Kind == kind && s.Name == name && s.BelongsTo(provider, primaryProvider)
+}
+
+//+kubebuilder:object:root=true
+
+// VDIRoleBindingList contains a list of VDIRoleBinding
+type VDIRoleBindingList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []VDIRoleBinding `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&VDIRoleBinding{}, &VDIRoleBindingList{})
+}
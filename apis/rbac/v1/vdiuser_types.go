@@ -0,0 +1,104 @@
+/*
+
+Copyright 2020,2021 Avi Zimmerman
+
+This file is part of kvdi.
+
+kvdi is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+kvdi is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// DefaultPasswordSecretKey is the key in a user's password secret holding the
+// hash when none is specified.
+const DefaultPasswordSecretKey = "passwordHash"
+
+//+kubebuilder:object:root=true
+//+kubebuilder:resource:path=vdiusers,scope=Cluster
+//+kubebuilder:printcolumn:name="Username",type="string",JSONPath=".spec.username"
+//+kubebuilder:printcolumn:name="Roles",type="string",JSONPath=".spec.roles"
+
+// VDIUser is the Schema for the vdiusers API. It represents a user of the local
+// authentication provider.
+type VDIUser struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec VDIUserSpec `json:"spec,omitempty"`
+}
+
+// VDIUserSpec defines the desired state of a VDIUser.
+type VDIUserSpec struct {
+	// The name the user logs in with. Defaults to the name of the VDIUser.
+	Username string `json:"username,omitempty"`
+	// The names of the VDIRoles bound to the user.
+	Roles []string `json:"roles,omitempty"`
+	// A reference to the secret holding the hash of the user's password. The user
+	// cannot log in until this is set.
+	PasswordSecretRef *PasswordSecretRef `json:"passwordSecretRef,omitempty"`
+	// Disabled users cannot log in. Users deactivated over SCIM are disabled.
+	Disabled bool `json:"disabled,omitempty"`
+	// The names of the groups the user belongs to. They are provisioned over SCIM and bind
+	// the user to the VDIRoles annotated with `kvdi.io/scim-groups`, or bound to `scim`
+	// groups by VDIRoleBindings.
+	Groups []string `json:"groups,omitempty"`
+	// The ID of the user at the identity provider that provisioned them over SCIM.
+	ExternalID string `json:"externalID,omitempty"`
+	// The name of the user suitable for display.
+	DisplayName string `json:"displayName,omitempty"`
+	// The email addresses of the user.
+	Emails []string `json:"emails,omitempty"`
+}
+
+// PasswordSecretRef references a key in a secret holding a password hash.
+type PasswordSecretRef struct {
+	// The name of the secret. It must live in the namespace of the kVDI app.
+	Name string `json:"name"`
+	// The key in the secret holding the hash. Defaults to `passwordHash`.
+	Key string `json:"key,omitempty"`
+}
+
+// GetUsername returns the name the user logs in with.
+func (v *VDIUser) GetUsername() string {
+	if v.Spec.Username != "" {
+		return v.Spec.Username
+	}
+	return v.GetName()
+}
+
+// GetKey returns the key in the secret holding the password hash.
+func (p *PasswordSecretRef) GetKey() string {
+	if p.Key != "" {
+		return p.Key
+	}
+	return DefaultPasswordSecretKey
+}
+
+//+kubebuilder:object:root=true
+
+// VDIUserList contains a list of VDIUser
+type VDIUserList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []VDIUser `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&VDIUser{}, &VDIUserList{})
+}
@@ -29,6 +29,8 @@ import (
 	"net/http"
 	"os"
 	"time"
+	// The image has no time zone database, embed one for time windows on role rules
+	_ "time/tzdata"
 
 	"github.com/gorilla/handlers"
 	"github.com/gorilla/mux"
@@ -48,7 +50,9 @@ func main() {
 	var vdiCluster string
 	var enableCORS bool
 	var disableTLS bool
+	var requestClientCerts bool
 	flag.BoolVar(&disableTLS, "disable-tls", false, "Disable TLS")
+	flag.BoolVar(&requestClientCerts, "request-client-certs", false, "Request client certificates for authentication")
 	flag.StringVar(&vdiCluster, "vdi-cluster", "", "The VDICluster this application is serving")
 	flag.BoolVar(&enableCORS, "enable-cors", false, "Add CORS headers to requests")
 	common.ParseFlagsAndSetupLogging()
@@ -72,6 +76,7 @@ func main() {
 	// serve
 	applogger.Info(fmt.Sprintf("Starting VDI cluster frontend on :%d", v1.WebPort))
 	if !disableTLS {
+		srvr.TLSConfig = tlsutil.NewAppServerTLSConfig(requestClientCerts)
 		if err := srvr.ListenAndServeTLS(tlsutil.ServerKeypair()); err != nil {
 			applogger.Error(err, "Failed to start https server")
 			os.Exit(1)
@@ -122,12 +127,13 @@ func newServer(cfg *rest.Config, vdiCluster string, enableCORS bool) (*http.Serv
 	}
 	// api routes
 	r.PathPrefix("/api").Handler(apiRouter)
+	// scim provisioning routes
+	r.PathPrefix("/scim").Handler(apiRouter)
 	// vue frontend
 	r.PathPrefix("/").Handler(http.FileServer(http.Dir("/static/")))
-	wrappedRouter := handlers.ProxyHeaders(
-		handlers.CompressHandler(
-			handlers.CustomLoggingHandler(os.Stdout, r, formatLog),
-		),
+	// Forwarded headers are only honored by the API for the configured trusted proxies
+	wrappedRouter := handlers.CompressHandler(
+		handlers.CustomLoggingHandler(os.Stdout, r, formatLog),
 	)
 	if enableCORS {
 		wrappedRouter = handlers.CORS()(wrappedRouter)
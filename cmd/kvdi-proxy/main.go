@@ -24,6 +24,7 @@ along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
 package main
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
 	"os"
@@ -32,6 +33,7 @@ import (
 
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
 
+	desktopsv1 "github.com/kvdi/kvdi/apis/desktops/v1"
 	v1 "github.com/kvdi/kvdi/apis/meta/v1"
 	proxyserver "github.com/kvdi/kvdi/pkg/proxyproto/server"
 	"github.com/kvdi/kvdi/pkg/util/common"
@@ -47,6 +49,8 @@ var (
 	userID                                  int
 	pulseServer                             string
 	displayAddr                             string
+	fileTransferPolicy                      string
+	denyAudioCapture                        bool
 	displayConnectProto, displayConnectAddr string
 
 	monitorDeviceName    = "kvdi"
@@ -67,6 +71,8 @@ func main() {
 	flag.StringVar(&displayAddr, "display-addr", "unix:///var/run/kvdi/display.sock", "The tcp or unix-socket address of the display server")
 	flag.IntVar(&userID, "user-id", 9000, "The ID of the main user in the desktop container, used for chown operations")
 	flag.StringVar(&pulseServer, "pulse-server", "", "The socket where pulseaudio is accepting connections. Defaults to /run/user/<userID>/pulse/native")
+	flag.StringVar(&fileTransferPolicy, "file-transfer-policy", "", "A JSON encoded policy to enforce on file transfers")
+	flag.BoolVar(&denyAudioCapture, "deny-audio-capture", false, "Do not create a microphone device or accept audio capture streams")
 	common.ParseFlagsAndSetupLogging()
 	common.PrintVersion(log)
 
@@ -88,6 +94,16 @@ func main() {
 		pulseServer = fmt.Sprintf("/run/user/%d/pulse/native", userID)
 	}
 
+	// Parse any restrictions on file transfers
+	var transferPolicy *desktopsv1.FileTransferPolicy
+	if fileTransferPolicy != "" {
+		transferPolicy = &desktopsv1.FileTransferPolicy{}
+		if err := json.Unmarshal([]byte(fileTransferPolicy), transferPolicy); err != nil {
+			log.Error(err, "Invalid file transfer policy")
+			os.Exit(1)
+		}
+	}
+
 	// build and run the server
 
 	server := proxyserver.New(log, listenHost, v1.WebPort, &proxyserver.ProxyOpts{
@@ -104,6 +120,8 @@ func main() {
 		RecordingDeviceFormat:      micDeviceFormat,
 		RecordingDeviceSampleRate:  micDeviceSampleRate,
 		RecordingDeviceChannels:    micDeviceChannels,
+		FileTransferPolicy:         transferPolicy,
+		DenyAudioCapture:           denyAudioCapture,
 	})
 
 	if err := server.ListenAndServe(); err != nil {
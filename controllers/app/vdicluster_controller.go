@@ -54,7 +54,8 @@ type VDIClusterReconciler struct {
 //+kubebuilder:rbac:groups="",resources=endpoints;pods/log;configmaps;serviceaccounts;secrets;services,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=apps,resources=deployments;replicasets,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=rbac.authorization.k8s.io,resources=clusterroles;clusterrolebindings,verbs=get;list;watch;create;update;patch;delete
-//+kubebuilder:rbac:groups=rbac.kvdi.io,resources=vdiroles,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=authentication.k8s.io,resources=tokenreviews,verbs=create
+//+kubebuilder:rbac:groups=rbac.kvdi.io,resources=vdiroles;vdirolebindings;vdiusers,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=app.kvdi.io,resources=vdiclusters,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=app.kvdi.io,resources=vdiclusters/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=app.kvdi.io,resources=vdiclusters/finalizers,verbs=update
@@ -25,6 +25,7 @@ import (
 	"net"
 	"net/http"
 	"os"
+	"sync"
 	"time"
 
 	appv1 "github.com/kvdi/kvdi/apis/app/v1"
@@ -33,8 +34,13 @@ import (
 
 	v1 "github.com/kvdi/kvdi/apis/meta/v1"
 	"github.com/kvdi/kvdi/pkg/auth"
+	"github.com/kvdi/kvdi/pkg/auth/apitokens"
 	"github.com/kvdi/kvdi/pkg/auth/common"
+	"github.com/kvdi/kvdi/pkg/auth/lockout"
+	"github.com/kvdi/kvdi/pkg/auth/logins"
 	"github.com/kvdi/kvdi/pkg/auth/mfa"
+	"github.com/kvdi/kvdi/pkg/auth/scim"
+	"github.com/kvdi/kvdi/pkg/auth/signing"
 	"github.com/kvdi/kvdi/pkg/secrets"
 	util "github.com/kvdi/kvdi/pkg/util/common"
 
@@ -75,6 +81,20 @@ type desktopAPI struct {
 	secrets *secrets.SecretEngine
 	// the mfa backend for setting and retrieving OTP secrets
 	mfa *mfa.Manager
+	// the backend for managing personal API tokens
+	apitokens *apitokens.Manager
+	// the backend for throttling failed logins
+	lockout *lockout.Manager
+	// the backend for tracking and revoking logins
+	logins *logins.Manager
+	// the backend for the keys used to sign access tokens
+	signing *signing.Manager
+	// the backend for provisioning users and groups over SCIM
+	scim *scim.Manager
+	// shared bandwidth limiters for user display/audio streams
+	bandwidth *bandwidthManager
+	// logs the first request with forwarded headers while no proxies are trusted
+	warnForwarded sync.Once
 }
 
 func (d *desktopAPI) handleClusterUpdate(req reconcile.Request) error {
@@ -102,17 +122,27 @@ func (d *desktopAPI) handleClusterUpdate(req reconcile.Request) error {
 	if d.secrets == nil {
 		// we have not set up secrets yet
 		d.secrets = secrets.GetSecretEngine(d.vdiCluster)
-		// this means mfa also still need to be setup
+		// this means mfa, api tokens, lockouts, logins, signing keys, and scim also still need to be setup
 		d.mfa = mfa.NewManager(d.secrets)
+		d.apitokens = apitokens.NewManager(d.secrets)
+		d.lockout = lockout.NewManager(d.secrets)
+		d.logins = logins.NewManager(d.secrets)
+		d.signing = signing.NewManager(d.secrets)
+		d.scim = scim.NewManager(d.secrets)
 	}
 	// call Setup on the secrets backend, should be idempotent
 	if err = d.secrets.Setup(d.client, d.vdiCluster); err != nil {
 		return err
 	}
+	d.scim.Setup(d.client, d.vdiCluster)
 
 	if d.auth == nil {
 		// auth has not been setup yet
-		d.auth = auth.GetAuthProvider(d.vdiCluster, d.secrets)
+		provider := auth.GetAuthProvider(d.vdiCluster, d.secrets)
+		if err = d.migrateUsernames(provider); err != nil {
+			return err
+		}
+		d.auth = provider
 	}
 	// call Setup on the auth provider, should be idempotent
 	if err = d.auth.Setup(d.client, d.vdiCluster); err != nil {
@@ -147,7 +177,7 @@ func getClientFromConfigAndScheme(cfg *rest.Config, scheme *runtime.Scheme) (cli
 // and vdi cluster name.
 func NewFromConfig(cfg *rest.Config, vdiCluster string) (DesktopAPI, error) {
 	// create an api object
-	api := &desktopAPI{clusterName: vdiCluster}
+	api := &desktopAPI{clusterName: vdiCluster, bandwidth: newBandwidthManager()}
 
 	// build our scheme
 	scheme, err := buildScheme()
@@ -199,12 +229,15 @@ func NewFromConfig(cfg *rest.Config, vdiCluster string) (DesktopAPI, error) {
 	return api, api.buildRouter()
 }
 
+// testSCIMToken is the bearer token SCIM clients use with the test API.
+const testSCIMToken = "scim-test-token"
+
 // NewTestAPI returns a new API using a fake kubernetes client and in-memory storage.
 func NewTestAPI() (srvr *http.Server, addr, adminPass string, err error) {
 	adminPass = "testing"
 
 	// create an api object
-	api := &desktopAPI{clusterName: "test-cluster"}
+	api := &desktopAPI{clusterName: "test-cluster", bandwidth: newBandwidthManager()}
 
 	// build our scheme
 	var scheme *runtime.Scheme
@@ -219,6 +252,7 @@ func NewTestAPI() (srvr *http.Server, addr, adminPass string, err error) {
 	// create a cluster object
 	api.vdiCluster = &appv1.VDICluster{}
 	api.vdiCluster.Name = "test-cluster"
+	api.vdiCluster.Spec.Auth = &appv1.AuthConfig{SCIM: &appv1.SCIMConfig{Enabled: true}}
 	if err = api.client.Create(context.TODO(), api.vdiCluster); err != nil {
 		return
 	}
@@ -256,16 +290,27 @@ func NewTestAPI() (srvr *http.Server, addr, adminPass string, err error) {
 	// set up auth and secrets
 	api.secrets = secrets.GetSecretEngine(api.vdiCluster)
 	api.mfa = mfa.NewManager(api.secrets)
+	api.apitokens = apitokens.NewManager(api.secrets)
+	api.lockout = lockout.NewManager(api.secrets)
+	api.logins = logins.NewManager(api.secrets)
+	api.signing = signing.NewManager(api.secrets)
+	api.scim = scim.NewManager(api.secrets)
 	api.auth = auth.GetAuthProvider(api.vdiCluster, api.secrets)
 	if err = api.secrets.Setup(api.client, api.vdiCluster); err != nil {
 		return
 	}
+	api.scim.Setup(api.client, api.vdiCluster)
 	if err = api.auth.Setup(api.client, api.vdiCluster); err != nil {
 		return
 	}
 
-	// set a dummy jwt key
-	if err = api.secrets.WriteSecret(v1.JWTSecretKey, []byte("supersecret")); err != nil {
+	// generate a token signing key
+	if _, err = api.signingKey(); err != nil {
+		return
+	}
+
+	// set a dummy scim token
+	if err = api.secrets.WriteSecret(v1.SCIMTokenSecretKey, []byte(testSCIMToken)); err != nil {
 		return
 	}
 
@@ -280,6 +325,7 @@ func NewTestAPI() (srvr *http.Server, addr, adminPass string, err error) {
 
 	// add the api routes
 	r.PathPrefix("/api").Handler(api)
+	r.PathPrefix("/scim").Handler(api)
 
 	srvr = &http.Server{
 		Handler:      r,
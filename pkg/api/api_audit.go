@@ -24,7 +24,9 @@ import (
 	"net/http"
 	"strings"
 
+	"github.com/kvdi/kvdi/pkg/auth/lockout"
 	"github.com/kvdi/kvdi/pkg/types"
+	"github.com/kvdi/kvdi/pkg/util/apiutil"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
 )
 
@@ -34,12 +36,14 @@ var auditLogger = logf.Log.WithName("api_audit")
 
 // AuditResult contains information about an audit event from the API router.
 type AuditResult struct {
-	Allowed     bool
-	FromOwner   bool
-	Actions     []*types.APIAction
-	Resource    string
-	UserSession *types.JWTClaims
-	Request     *http.Request
+	Allowed          bool
+	FromOwner        bool
+	Actions          []*types.APIAction
+	Resource         string
+	UserSession      *types.JWTClaims
+	Request          *http.Request
+	DeniedBy         string
+	FailedConditions []string
 }
 
 // actions maps allowed values to display strings
@@ -68,6 +72,15 @@ func buildAuditMsg(result *AuditResult) string {
 	if result.FromOwner {
 		msg = msg + " (OWNER)"
 	}
+	if result.UserSession.ImpersonatedBy != "" {
+		msg = msg + fmt.Sprintf(" (IMPERSONATED BY %s)", result.UserSession.ImpersonatedBy)
+	}
+	if result.DeniedBy != "" {
+		msg = msg + fmt.Sprintf(" (DENIED BY ROLE %s)", result.DeniedBy)
+	}
+	if len(result.FailedConditions) > 0 {
+		msg = msg + fmt.Sprintf(" (CONDITIONS NOT MET: %s)", strings.Join(result.FailedConditions, "; "))
+	}
 	return msg
 }
 
@@ -81,9 +94,71 @@ func (d *desktopAPI) auditLog(result *AuditResult) {
 		msg,
 		"Allowed", result.Allowed,
 		"Username", result.UserSession.User.Name,
+		"ImpersonatedBy", result.UserSession.ImpersonatedBy,
 		"RequestPath", result.Request.URL.Path,
 		"RequestOrigin", result.Request.RemoteAddr,
 		"RequestForwardedFor", result.Request.Header.Get("X-Forwarded-For"),
 		"APIActions", result.Actions,
+		"DeniedBy", result.DeniedBy,
+		"FailedConditions", result.FailedConditions,
+	)
+}
+
+// MFA events for audit records
+const (
+	mfaEventReset            = "MFA_RESET"
+	mfaEventRecoveryCodeUsed = "MFA_RECOVERY_CODE_USED"
+)
+
+// Login lockout events for audit records
+const (
+	loginEventLockout   = "LOGIN_LOCKOUT"
+	loginEventThrottled = "LOGIN_THROTTLED"
+	loginEventUnlock    = "LOGIN_UNLOCK"
+)
+
+// Login revocation events for audit records
+const (
+	loginEventRevoke    = "LOGIN_REVOKE"
+	loginEventRevokeAll = "LOGIN_REVOKE_ALL"
+)
+
+// auditUserEvent logs a change to a user's MFA enrollment, lockout or logins with parseable
+// metadata. The actor is the user that made the request, and the target the user
+// that was affected. If the actor was impersonated, the real user is recorded as well.
+func (d *desktopAPI) auditUserEvent(event, actor, target string, r *http.Request) {
+	if !d.vdiCluster.AuditLogEnabled() {
+		return
+	}
+	var impersonatedBy string
+	if session, ok := apiutil.LookupRequestUserSession(r); ok {
+		impersonatedBy = session.ImpersonatedBy
+	}
+	auditLogger.Info(
+		fmt.Sprintf("%s %s => %s", event, actor, target),
+		"Event", event,
+		"Username", actor,
+		"ImpersonatedBy", impersonatedBy,
+		"TargetUser", target,
+		"RequestPath", r.URL.Path,
+		"RequestOrigin", r.RemoteAddr,
+		"RequestForwardedFor", r.Header.Get("X-Forwarded-For"),
+	)
+}
+
+// auditLoginLockout logs a lockout, or a login rejected by one, with parseable metadata.
+func (d *desktopAPI) auditLoginLockout(event string, lock *lockout.Lockout, r *http.Request) {
+	if !d.vdiCluster.AuditLogEnabled() {
+		return
+	}
+	auditLogger.Info(
+		fmt.Sprintf("%s %s => %s", event, lock.Subject, lock.Name),
+		"Event", event,
+		"Subject", lock.Subject,
+		"Name", lock.Name,
+		"LockedUntil", lock.Until,
+		"RequestPath", r.URL.Path,
+		"RequestOrigin", r.RemoteAddr,
+		"RequestForwardedFor", r.Header.Get("X-Forwarded-For"),
 	)
 }
@@ -0,0 +1,149 @@
+/*
+
+Copyright 2020,2021 Avi Zimmerman
+
+This file is part of kvdi.
+
+kvdi is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+kvdi is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package api
+
+import (
+	"net/http"
+	"sync"
+
+	"golang.org/x/time/rate"
+
+	rbacv1 "github.com/kvdi/kvdi/apis/rbac/v1"
+	"github.com/kvdi/kvdi/pkg/types"
+)
+
+// bandwidthManager tracks the token buckets shared by all of a user's display
+// and audio streams.
+type bandwidthManager struct {
+	users map[string]*userBandwidth
+	mux   sync.Mutex
+}
+
+// userBandwidth is a shared limiter along with the limits requested by each of the
+// streams using it. The limiter always applies the most restrictive of them.
+type userBandwidth struct {
+	limiter *rate.Limiter
+	streams map[int]streamLimit
+	nextID  int
+}
+
+// streamLimit is the per-user rate and burst requested by a single stream.
+type streamLimit struct {
+	limit rate.Limit
+	burst int
+}
+
+func newBandwidthManager() *bandwidthManager {
+	return &bandwidthManager{users: make(map[string]*userBandwidth)}
+}
+
+// acquire returns the shared limiter for the given user. Streams can be subject to
+// different limits, e.g. when they are for desktops of different templates, so the
+// limiter is set to the most restrictive limits of all the user's streams. Callers
+// must call the returned function when they are finished with the limiter.
+func (b *bandwidthManager) acquire(user string, limits *rbacv1.BandwidthLimits) (*rate.Limiter, func()) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	ub, ok := b.users[user]
+	if !ok {
+		ub = &userBandwidth{streams: make(map[int]streamLimit)}
+		b.users[user] = ub
+	}
+	id := ub.nextID
+	ub.nextID++
+	ub.streams[id] = streamLimit{
+		limit: rate.Limit(limits.PerUserBytesPerSecond),
+		burst: int(limits.GetBurst(limits.PerUserBytesPerSecond)),
+	}
+	ub.apply()
+	var once sync.Once
+	return ub.limiter, func() { once.Do(func() { b.release(user, id) }) }
+}
+
+// release removes the given stream from the user's limiter, and removes the limiter
+// when no more streams are using it.
+func (b *bandwidthManager) release(user string, id int) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	ub, ok := b.users[user]
+	if !ok {
+		return
+	}
+	delete(ub.streams, id)
+	if len(ub.streams) == 0 {
+		delete(b.users, user)
+		return
+	}
+	ub.apply()
+}
+
+// apply sets the limiter to the most restrictive limits of the remaining streams,
+// creating it if necessary.
+func (u *userBandwidth) apply() {
+	var strictest *streamLimit
+	for _, stream := range u.streams {
+		stream := stream
+		if strictest == nil || stream.limit < strictest.limit ||
+			(stream.limit == strictest.limit && stream.burst < strictest.burst) {
+			strictest = &stream
+		}
+	}
+	if u.limiter == nil {
+		u.limiter = rate.NewLimiter(strictest.limit, strictest.burst)
+		return
+	}
+	u.limiter.SetLimit(strictest.limit)
+	u.limiter.SetBurst(strictest.burst)
+}
+
+// getBandwidthLimits returns the bandwidth limits that apply to the desktop session
+// and user in the given request. The template limits and the least restrictive of
+// the user's role limits are combined, with the most restrictive values winning.
+// Nil is returned when no limits apply.
+func (d *desktopAPI) getBandwidthLimits(r *http.Request, sess *types.JWTClaims) (*rbacv1.BandwidthLimits, error) {
+	tmpl, err := d.getTemplateForRequest(r)
+	if err != nil {
+		return nil, err
+	}
+	limits := tmpl.GetBandwidthLimits()
+
+	roles, err := d.vdiCluster.GetRoles(d.client)
+	if err != nil {
+		return nil, err
+	}
+	// Roles without limits do not take part, the rest are combined the same
+	// way their grants are.
+	var roleLimits *rbacv1.BandwidthLimits
+	for _, userRole := range sess.User.Roles {
+		for _, role := range roles {
+			if role.GetName() == userRole.GetName() && !role.GetBandwidthLimits().IsEmpty() {
+				roleLimits = role.GetBandwidthLimits().Relax(roleLimits)
+			}
+		}
+	}
+	limits = limits.Restrict(roleLimits)
+
+	if limits.IsEmpty() {
+		return nil, nil
+	}
+	return limits, nil
+}
@@ -24,10 +24,14 @@ import (
 	"fmt"
 	"net/http"
 
-	"github.com/google/uuid"
 	corev1 "k8s.io/api/core/v1"
 
+	appv1 "github.com/kvdi/kvdi/apis/app/v1"
+	desktopsv1 "github.com/kvdi/kvdi/apis/desktops/v1"
 	v1 "github.com/kvdi/kvdi/apis/meta/v1"
+	"github.com/kvdi/kvdi/pkg/auth/common"
+	"github.com/kvdi/kvdi/pkg/auth/providers/composite"
+	"github.com/kvdi/kvdi/pkg/auth/signing"
 	proxyclient "github.com/kvdi/kvdi/pkg/proxyproto/client"
 	"github.com/kvdi/kvdi/pkg/types"
 	"github.com/kvdi/kvdi/pkg/util/apiutil"
@@ -40,29 +44,41 @@ const TokenHeader = "X-Session-Token"
 // RefreshTokenCookie is the cookie used to store a user's refresh token
 const RefreshTokenCookie = "refreshToken"
 
-// returnNewJWT will return a new JSON web token to the requestor.
-func (d *desktopAPI) returnNewJWT(w http.ResponseWriter, result *types.AuthResult, authorized bool, state string) {
-	// fetch the JWT signing secret
-	secret, err := d.secrets.ReadSecret(v1.JWTSecretKey, true)
-	if err != nil {
-		apiutil.ReturnAPIError(err, w)
-		return
-	}
-
-	// create a new token
-	claims, newToken, err := apiutil.GenerateJWT(secret, result, authorized, d.vdiCluster.GetTokenDuration())
+// returnNewJWT will return a new JSON web token to the requestor. When a refresh token
+// is issued with it, it continues the given login or starts a new one if login is nil.
+func (d *desktopAPI) returnNewJWT(w http.ResponseWriter, r *http.Request, result *types.AuthResult, authorized bool, state string, login *types.UserLogin) {
+	// fetch the key to sign the token with
+	key, err := d.signingKey()
 	if err != nil {
 		apiutil.ReturnAPIError(err, w)
 		return
 	}
 
+	var refreshToken string
 	if authorized && !result.RefreshNotSupported {
+		if login == nil {
+			login = &types.UserLogin{ID: result.LoginID, User: result.User.Name}
+		}
+		login.SourceIP = getLoginSource(r)
+		login.UserAgent = r.UserAgent()
 		// Generate a refresh token
-		refreshToken, err := d.generateRefreshToken(result.User)
+		refreshToken, err = d.logins.IssueRefreshToken(login)
 		if err != nil {
 			apiutil.ReturnAPIError(err, w)
 			return
 		}
+	} else {
+		login = &types.UserLogin{ID: result.LoginID}
+	}
+
+	// create a new token
+	claims, newToken, err := apiutil.GenerateJWT(key, result, authorized, login.ID, d.vdiCluster.GetTokenDuration())
+	if err != nil {
+		apiutil.ReturnAPIError(err, w)
+		return
+	}
+
+	if refreshToken != "" {
 		// Set a Secure, HttpOnly cookie so that it can only be used over HTTPS and not
 		// accessed by the browser.
 		http.SetCookie(w, &http.Cookie{
@@ -84,41 +100,63 @@ func (d *desktopAPI) returnNewJWT(w http.ResponseWriter, result *types.AuthResul
 	}, w)
 }
 
-func (d *desktopAPI) generateRefreshToken(user *types.VDIUser) (string, error) {
-	refreshToken := uuid.New().String()
-	if err := d.secrets.Lock(10); err != nil {
-		return "", err
+// revokeLogin revokes the given login of the user along with any state the auth
+// provider keeps for it.
+func (d *desktopAPI) revokeLogin(username, loginID string) error {
+	if err := d.logins.RevokeLogin(username, loginID, d.vdiCluster.GetTokenDuration()); err != nil {
+		return err
 	}
-	defer d.secrets.Release()
-	tokens, err := d.secrets.ReadSecretMap(v1.RefreshTokensSecretKey, false)
-	if err != nil {
-		if !errors.IsSecretNotFoundError(err) {
-			return "", err
-		}
-		tokens = make(map[string][]byte)
+	d.revokeProviderSessions(username, loginID)
+	return nil
+}
+
+// revokeUserLogins revokes all logins of the user along with any state the auth provider
+// keeps for them.
+func (d *desktopAPI) revokeUserLogins(username string) error {
+	if err := d.logins.RevokeUserLogins(username, d.vdiCluster.GetTokenDuration()); err != nil {
+		return err
 	}
-	tokens[refreshToken] = []byte(user.Name)
-	return refreshToken, d.secrets.WriteSecretMap(v1.RefreshTokensSecretKey, tokens)
+	d.revokeProviderSessions(username)
+	return nil
 }
 
-func (d *desktopAPI) lookupRefreshToken(refreshToken string) (string, error) {
-	if err := d.secrets.Lock(10); err != nil {
-		return "", err
+// revokeProviderSessions discards the state the auth provider keeps for the given logins
+// of the user, or all of their logins when none are given. The logins themselves are
+// already revoked, so failures are only logged.
+func (d *desktopAPI) revokeProviderSessions(username string, loginIDs ...string) {
+	provider, ok := d.auth.(common.SessionProvider)
+	if !ok {
+		return
 	}
-	defer d.secrets.Release()
-	tokens, err := d.secrets.ReadSecretMap(v1.RefreshTokensSecretKey, false)
-	if err != nil {
-		if errors.IsSecretNotFoundError(err) {
-			return "", errors.New("The refresh token does not exist in the secret storage")
-		}
-		return "", err
+	if err := provider.RevokeSessions(username, loginIDs...); err != nil {
+		apiLogger.Error(err, "Failed to discard auth provider sessions", "User", username)
 	}
-	user, ok := tokens[refreshToken]
-	if !ok {
-		return "", errors.New("The refresh token does not exist in the secret storage")
+}
+
+// signingKey returns the key to sign new access tokens with, rotating it when it is due.
+func (d *desktopAPI) signingKey() (*signing.Key, error) {
+	return d.signing.SigningKey(
+		d.vdiCluster.GetTokenSigningAlgorithm(),
+		d.vdiCluster.GetTokenRotationInterval(),
+		d.vdiCluster.GetTokenRotationOverlap(),
+	)
+}
+
+// getUserAuthMethod returns the authentication method the given user belongs to.
+func (d *desktopAPI) getUserAuthMethod(username string) appv1.AuthMethod {
+	if d.vdiCluster.IsUsingMultipleAuthMethods() {
+		method, _ := composite.SplitUsername(username)
+		return method
+	}
+	return d.vdiCluster.GetAuthMethods()[0]
+}
+
+func (d *desktopAPI) getTemplateForRequest(r *http.Request) (*desktopsv1.Template, error) {
+	desktop := &desktopsv1.Session{}
+	if err := d.client.Get(context.TODO(), apiutil.GetNamespacedNameFromRequest(r), desktop); err != nil {
+		return nil, err
 	}
-	delete(tokens, refreshToken)
-	return string(user), d.secrets.WriteSecretMap(v1.RefreshTokensSecretKey, tokens)
+	return desktop.GetTemplate(d.client)
 }
 
 func (d *desktopAPI) getDesktopProxyHost(r *http.Request) (string, error) {
@@ -34,6 +34,9 @@ var Decoders = map[string]map[string]interface{}{
 	"/api/authorize": {
 		"POST": types.AuthorizeRequest{},
 	},
+	"/api/authz/review": {
+		"POST": types.AccessReviewRequest{},
+	},
 	"/api/sessions": {
 		"POST": types.CreateSessionRequest{},
 	},
@@ -43,12 +46,21 @@ var Decoders = map[string]map[string]interface{}{
 	"/api/users/{user}": {
 		"PUT": types.UpdateUserRequest{},
 	},
+	"/api/users/{user}/password": {
+		"PUT": types.ChangePasswordRequest{},
+	},
 	"/api/users/{user}/mfa": {
 		"PUT": types.UpdateMFARequest{},
 	},
 	"/api/users/{user}/mfa/verify": {
 		"PUT": types.AuthorizeRequest{},
 	},
+	"/api/users/{user}/mfa/webauthn": {
+		"PUT": types.RegisterWebAuthnRequest{},
+	},
+	"/api/users/{user}/tokens": {
+		"POST": types.CreateAPITokenRequest{},
+	},
 	"/api/roles": {
 		"POST": types.CreateRoleRequest{},
 	},
@@ -0,0 +1,238 @@
+/*
+
+Copyright 2020,2021 Avi Zimmerman
+
+This file is part of kvdi.
+
+kvdi is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+kvdi is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	desktopsv1 "github.com/kvdi/kvdi/apis/desktops/v1"
+	v1 "github.com/kvdi/kvdi/apis/meta/v1"
+	"github.com/kvdi/kvdi/pkg/types"
+	"github.com/kvdi/kvdi/pkg/util/apiutil"
+	"github.com/kvdi/kvdi/pkg/util/errors"
+)
+
+// Directions of file transfers for audit records
+const (
+	fileTransferUpload   = "upload"
+	fileTransferDownload = "download"
+)
+
+// FileTransferRecord contains information about a file transfer for the audit log.
+type FileTransferRecord struct {
+	Allowed     bool
+	Direction   string
+	FileName    string
+	FileSize    int64
+	SHA256      string
+	Reason      string
+	UserSession *types.JWTClaims
+	Request     *http.Request
+}
+
+// auditFileTransfer logs the given file transfer with parseable metadata.
+func (d *desktopAPI) auditFileTransfer(record *FileTransferRecord) {
+	if !d.vdiCluster.AuditLogEnabled() {
+		return
+	}
+	msg := fmt.Sprintf(
+		"%s %s => %s %s => %s",
+		actions[record.Allowed],
+		record.UserSession.User.GetName(),
+		strings.ToUpper(record.Direction),
+		record.FileName,
+		apiutil.GetNamespacedNameFromRequest(record.Request).String(),
+	)
+	if record.Reason != "" {
+		msg = msg + fmt.Sprintf(" (%s)", record.Reason)
+	}
+	auditLogger.Info(
+		msg,
+		"Allowed", record.Allowed,
+		"Username", record.UserSession.User.Name,
+		"Direction", record.Direction,
+		"FileName", record.FileName,
+		"FileSize", record.FileSize,
+		"SHA256", record.SHA256,
+		"RequestPath", record.Request.URL.Path,
+		"RequestOrigin", record.Request.RemoteAddr,
+		"RequestForwardedFor", record.Request.Header.Get("X-Forwarded-For"),
+	)
+}
+
+// denyFileTransfer audits a rejected file transfer and returns a forbidden response
+// to the client.
+func (d *desktopAPI) denyFileTransfer(w http.ResponseWriter, record *FileTransferRecord, reason error) {
+	record.Allowed = false
+	record.Reason = reason.Error()
+	d.auditFileTransfer(record)
+	apiutil.ReturnAPIForbidden(nil, reason.Error(), w)
+}
+
+// fileTransferReservation is the part of a user's daily usage held for a transfer
+// in progress.
+type fileTransferReservation struct {
+	key      string
+	reserved int64
+}
+
+// reserveFileTransfer returns an error if the given file is not allowed to be transferred
+// by the policy or would put the user over their daily quota for the template. Otherwise
+// the size of the file is added to the user's usage until the transfer is settled.
+func (d *desktopAPI) reserveFileTransfer(tmpl *desktopsv1.Template, user, name, mimeType string, size int64) (*fileTransferReservation, error) {
+	policy := tmpl.GetFileTransferPolicy()
+	if err := policy.CheckFile(name, mimeType, size); err != nil {
+		return nil, err
+	}
+	res := &fileTransferReservation{key: fileTransferUsageKey(tmpl.GetName(), user), reserved: size}
+	if err := d.secrets.Lock(10); err != nil {
+		return nil, err
+	}
+	defer d.secrets.Release()
+	usage, err := d.readFileTransferUsage()
+	if err != nil {
+		return nil, err
+	}
+	used := parseFileTransferUsage(usage[res.key])
+	if policy != nil && policy.DailyUserQuota > 0 && used+size > policy.DailyUserQuota {
+		return nil, fmt.Errorf("transfer would exceed the daily quota of %d bytes (%d bytes used)", policy.DailyUserQuota, used)
+	}
+	usage[res.key] = formatFileTransferUsage(used + size)
+	return res, d.secrets.WriteSecretMap(v1.FileTransferUsageSecretKey, usage)
+}
+
+// settleFileTransfer replaces the reserved bytes in the user's usage with the number
+// of bytes that were actually transferred.
+func (d *desktopAPI) settleFileTransfer(res *fileTransferReservation, transferred int64) error {
+	if err := d.secrets.Lock(10); err != nil {
+		return err
+	}
+	defer d.secrets.Release()
+	usage, err := d.readFileTransferUsage()
+	if err != nil {
+		return err
+	}
+	used := parseFileTransferUsage(usage[res.key]) - res.reserved + transferred
+	if used < 0 {
+		// the reservation was made on the previous day
+		used = transferred
+	}
+	usage[res.key] = formatFileTransferUsage(used)
+	return d.secrets.WriteSecretMap(v1.FileTransferUsageSecretKey, usage)
+}
+
+// releaseFileTransfer settles the usage of a transfer that failed after the given
+// number of bytes.
+func (d *desktopAPI) releaseFileTransfer(record *FileTransferRecord, res *fileTransferReservation, transferred int64) {
+	if err := d.settleFileTransfer(res, transferred); err != nil {
+		apiLogger.Error(err, "Failed to record file transfer usage", "User", record.UserSession.User.Name)
+	}
+}
+
+// fileTransferUsageKey returns the key for a user's usage of a template. Template names
+// cannot contain underscores, so the key is unambiguous.
+func fileTransferUsageKey(template, user string) string {
+	return fmt.Sprintf("%s_%s", template, user)
+}
+
+// fileTransferDay returns the key for the current day of file transfer usage.
+func fileTransferDay() string { return time.Now().UTC().Format("2006-01-02") }
+
+// formatFileTransferUsage returns a usage entry for the given number of bytes today.
+func formatFileTransferUsage(used int64) []byte {
+	return []byte(fmt.Sprintf("%s:%d", fileTransferDay(), used))
+}
+
+// parseFileTransferUsage parses a usage entry in the format "day:bytes" and returns
+// the bytes used if the entry is for the current day.
+func parseFileTransferUsage(entry []byte) int64 {
+	spl := strings.Split(string(entry), ":")
+	if len(spl) != 2 || spl[0] != fileTransferDay() {
+		return 0
+	}
+	used, err := strconv.ParseInt(spl[1], 10, 64)
+	if err != nil {
+		return 0
+	}
+	return used
+}
+
+// readFileTransferUsage returns the daily usage of all users. Entries from previous
+// days are dropped. The secrets lock must be held by the caller.
+func (d *desktopAPI) readFileTransferUsage() (map[string][]byte, error) {
+	usage, err := d.secrets.ReadSecretMap(v1.FileTransferUsageSecretKey, false)
+	if err != nil {
+		if errors.IsSecretNotFoundError(err) {
+			return make(map[string][]byte), nil
+		}
+		return nil, err
+	}
+	for key, entry := range usage {
+		if parseFileTransferUsage(entry) == 0 {
+			delete(usage, key)
+		}
+	}
+	return usage, nil
+}
+
+// completeFileTransfer settles the usage and audits a finished file transfer. The size
+// of the record is the number of bytes that were actually transferred.
+func (d *desktopAPI) completeFileTransfer(record *FileTransferRecord, res *fileTransferReservation, body *hashingReadCloser) {
+	record.Allowed = true
+	record.FileSize = body.read
+	record.SHA256 = hex.EncodeToString(body.hash.Sum(nil))
+	if err := d.settleFileTransfer(res, body.read); err != nil {
+		apiLogger.Error(err, "Failed to record file transfer usage", "User", record.UserSession.User.Name)
+	}
+	d.auditFileTransfer(record)
+}
+
+// hashingReadCloser computes a digest of the data read through it, and counts
+// the bytes read.
+type hashingReadCloser struct {
+	io.Reader
+	io.Closer
+	hash hash.Hash
+	read int64
+}
+
+// newHashingReadCloser returns a ReadCloser that hashes and counts everything read
+// from rdr.
+func newHashingReadCloser(rdr io.ReadCloser) *hashingReadCloser {
+	hasher := sha256.New()
+	return &hashingReadCloser{Reader: io.TeeReader(rdr, hasher), Closer: rdr, hash: hasher}
+}
+
+// Read implements io.Reader.
+func (h *hashingReadCloser) Read(p []byte) (int, error) {
+	n, err := h.Reader.Read(p)
+	h.read += int64(n)
+	return n, err
+}
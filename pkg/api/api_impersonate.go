@@ -0,0 +1,94 @@
+/*
+
+Copyright 2020,2021 Avi Zimmerman
+
+This file is part of kvdi.
+
+kvdi is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+kvdi is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/kvdi/kvdi/pkg/types"
+	"github.com/kvdi/kvdi/pkg/util/apiutil"
+	"github.com/kvdi/kvdi/pkg/util/errors"
+	"github.com/kvdi/kvdi/pkg/util/rbac"
+)
+
+// ImpersonateUserHeader is the header used to act as another user. The requesting user
+// needs the impersonate verb on the target user.
+const ImpersonateUserHeader = "Impersonate-User"
+
+// Impersonation events for audit records
+const (
+	impersonateEventDenied = "IMPERSONATE_DENIED"
+)
+
+// impersonateUser returns a copy of the session acting as the user named in the
+// Impersonate-User header, or the session itself when the header is not set. The copy
+// keeps the login and API token of the real session, so revoking them still ends it.
+// If the impersonation is not allowed, the error is written to the response and false
+// is returned.
+func (d *desktopAPI) impersonateUser(session *types.JWTClaims, w http.ResponseWriter, r *http.Request) (*types.JWTClaims, bool) {
+	target := r.Header.Get(ImpersonateUserHeader)
+	if target == "" {
+		return session, true
+	}
+
+	if !session.Authorized {
+		apiutil.ReturnAPIForbidden(nil, "User session is not authorized", w)
+		return nil, false
+	}
+	// the target's roles would escape the rules the token is limited to
+	if session.APITokenScoped {
+		apiutil.ReturnAPIForbidden(nil, "Scoped API tokens cannot impersonate other users", w)
+		return nil, false
+	}
+	// authorizing a session with mfa would issue tokens for the target user
+	switch apiutil.GetGorillaPath(r) {
+	case "/api/authorize", "/api/authorize/webauthn":
+		apiutil.ReturnAPIForbidden(nil, "Sessions cannot be authorized while impersonating another user", w)
+		return nil, false
+	}
+
+	if !rbac.CanImpersonate(session.User, target, apiutil.GetRequestActionContext(r)) {
+		d.auditUserEvent(impersonateEventDenied, session.User.GetName(), target, r)
+		apiutil.ReturnAPIForbidden(nil, fmt.Sprintf("%s does not have the ability to impersonate %s", session.User.GetName(), target), w)
+		return nil, false
+	}
+
+	user, err := d.auth.GetUser(target)
+	if err != nil {
+		if errors.IsUserNotFoundError(err) {
+			apiutil.ReturnAPINotFound(err, w)
+			return nil, false
+		}
+		apiutil.ReturnAPIError(err, w)
+		return nil, false
+	}
+	if err := d.applyProvisionedUser(user); err != nil {
+		apiutil.ReturnAPIForbidden(nil, err.Error(), w)
+		return nil, false
+	}
+
+	impersonated := *session
+	impersonated.User = user
+	impersonated.ImpersonatedBy = session.User.GetName()
+	return &impersonated, true
+}
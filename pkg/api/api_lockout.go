@@ -0,0 +1,134 @@
+/*
+
+Copyright 2020,2021 Avi Zimmerman
+
+This file is part of kvdi.
+
+kvdi is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+kvdi is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package api
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+
+	appv1 "github.com/kvdi/kvdi/apis/app/v1"
+	"github.com/kvdi/kvdi/pkg/auth/lockout"
+	"github.com/kvdi/kvdi/pkg/auth/providers/composite"
+	"github.com/kvdi/kvdi/pkg/types"
+	"github.com/kvdi/kvdi/pkg/util/apiutil"
+	"github.com/kvdi/kvdi/pkg/util/errors"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// getLockoutPolicy returns the limits to apply to failed logins from the VDICluster.
+func (d *desktopAPI) getLockoutPolicy() lockout.Policy {
+	return lockout.Policy{
+		MaxUserFailures:   d.vdiCluster.GetLockoutMaxUserFailures(),
+		MaxSourceFailures: d.vdiCluster.GetLockoutMaxSourceFailures(),
+		Window:            d.vdiCluster.GetLockoutWindow(),
+		Cooldown:          d.vdiCluster.GetLockoutCooldown(),
+	}
+}
+
+// getLoginUsername returns the name failed logins for the given request are counted
+// against. When multiple authentication methods are enabled, it is qualified with
+// the method the same way user names are.
+func (d *desktopAPI) getLoginUsername(req *types.LoginRequest) string {
+	username := req.GetUsername()
+	if username == "" || !d.vdiCluster.IsUsingMultipleAuthMethods() {
+		return username
+	}
+	method := d.vdiCluster.GetAuthMethods()[0]
+	if req.GetMethod() != "" {
+		method = appv1.AuthMethod(req.GetMethod())
+	}
+	return composite.QualifyUsername(method, username)
+}
+
+// getLoginSource returns the address a login request came from. The RemoteAddr is
+// resolved from trusted proxy headers by the API router.
+func getLoginSource(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// getLockoutSource returns the source address failed logins for the given request are
+// counted against. It is empty when source addresses are not limited.
+func (d *desktopAPI) getLockoutSource(r *http.Request) string {
+	if d.vdiCluster.GetLockoutMaxSourceFailures() <= 0 {
+		return ""
+	}
+	return getLoginSource(r)
+}
+
+// checkLoginLockout returns true and writes a response if the user or source address
+// of the given login request is locked out, or if lockouts could not be checked.
+func (d *desktopAPI) checkLoginLockout(w http.ResponseWriter, r *http.Request, username string) bool {
+	if !d.vdiCluster.LoginLockoutEnabled() {
+		return false
+	}
+	lock, err := d.lockout.Check(username, d.getLockoutSource(r))
+	if err != nil {
+		// Fail closed so the limits can't be bypassed while the secrets backend
+		// is having trouble
+		apiLogger.Error(err, "Failed to check for login lockouts")
+		apiutil.ReturnAPIError(errors.New("Unable to check for login lockouts, try again later"), w)
+		return true
+	}
+	if lock == nil {
+		return false
+	}
+	loginsThrottledTotal.With(prometheus.Labels{"subject": string(lock.Subject)}).Inc()
+	d.auditLoginLockout(loginEventThrottled, lock, r)
+	w.Header().Set("Retry-After", strconv.Itoa(int(lock.RetryAfter().Seconds())))
+	apiutil.ReturnAPIForbidden(nil, "Too many failed login attempts, try again later", w)
+	return true
+}
+
+// recordLoginFailure counts a failed login against the user and source address of
+// the given request, and logs any lockouts that result from it.
+func (d *desktopAPI) recordLoginFailure(r *http.Request, username string) {
+	loginFailuresTotal.Inc()
+	if !d.vdiCluster.LoginLockoutEnabled() {
+		return
+	}
+	locked, err := d.lockout.RecordFailure(d.getLockoutPolicy(), username, d.getLockoutSource(r))
+	if err != nil {
+		apiLogger.Error(err, "Failed to record failed login")
+		return
+	}
+	for _, lock := range locked {
+		apiLogger.Info("Locking out login subject after too many failures", "Subject", lock.Subject, "Name", lock.Name, "Until", lock.Until)
+		loginLockoutsTotal.With(prometheus.Labels{"subject": string(lock.Subject)}).Inc()
+		d.auditLoginLockout(loginEventLockout, lock, r)
+	}
+}
+
+// recordLoginSuccess clears any failed logins counted against the given user. It is
+// only called once the user has completed every step of authentication, including MFA.
+func (d *desktopAPI) recordLoginSuccess(username string) {
+	if !d.vdiCluster.LoginLockoutEnabled() || username == "" {
+		return
+	}
+	if err := d.lockout.RecordSuccess(username); err != nil {
+		apiLogger.Error(err, "Failed to clear failed logins", "Username", username)
+	}
+}
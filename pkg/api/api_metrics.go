@@ -84,6 +84,44 @@ var (
 		Help:      "The current number of active display streams.",
 	})
 
+	// streamThrottlesTotal tracks the number of times a websocket stream was held back
+	// by a bandwidth limit
+	streamThrottlesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "kvdi",
+		Name:      "ws_throttled_total",
+		Help:      "Total number of times websocket streams were throttled by bandwidth limits by desktop and stream.",
+	}, []string{"desktop", "stream"})
+
+	// streamThrottledSeconds tracks the time websocket streams spent waiting on a
+	// bandwidth limit
+	streamThrottledSeconds = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "kvdi",
+		Name:      "ws_throttled_seconds_total",
+		Help:      "Total seconds websocket streams were delayed by bandwidth limits by desktop and stream.",
+	}, []string{"desktop", "stream"})
+
+	// loginFailuresTotal tracks the number of failed logins
+	loginFailuresTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "kvdi",
+		Name:      "login_failures_total",
+		Help:      "Total number of failed login attempts.",
+	})
+
+	// loginLockoutsTotal tracks the number of times a user or source address was locked
+	// out after too many failed logins
+	loginLockoutsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "kvdi",
+		Name:      "login_lockouts_total",
+		Help:      "Total number of lockouts after too many failed logins by subject (user or source).",
+	}, []string{"subject"})
+
+	// loginsThrottledTotal tracks the number of logins rejected due to a lockout
+	loginsThrottledTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "kvdi",
+		Name:      "logins_throttled_total",
+		Help:      "Total number of login attempts rejected due to a lockout by subject (user or source).",
+	}, []string{"subject"})
+
 	// activeDisplayStreams tracks the number of active audio connections
 	activeAudioStreams = promauto.NewGauge(prometheus.GaugeOpts{
 		Namespace: "kvdi",
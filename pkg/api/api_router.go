@@ -60,17 +60,8 @@ func (d *desktopAPI) buildRouter() error {
 	// handler for retrieving auth methods
 	r.PathPrefix("/api/auth_methods").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		out := map[string]string{}
-		if d.vdiCluster.IsUsingLocalAuth() {
-			out["local"] = "true"
-		}
-		if d.vdiCluster.IsUsingOIDCAuth() {
-			out["oidc"] = "true"
-		}
-		if d.vdiCluster.IsUsingLDAPAuth() {
-			out["ldap"] = "true"
-		}
-		if d.vdiCluster.IsUsingWebmeshAuth() {
-			out["webmesh"] = "true"
+		for _, method := range d.vdiCluster.GetAuthMethods() {
+			out[string(method)] = "true"
 		}
 		apiutil.WriteJSON(out, w)
 	}).Methods("GET")
@@ -101,6 +92,32 @@ func (d *desktopAPI) buildRouter() error {
 	// be renamed.  To be honest, the entire OIDC flow is a bit hacky and should be reworked.
 	r.PathPrefix("/api/login").HandlerFunc(d.PostLogin).Methods("POST", "GET")
 
+	// SAML service provider routes are called by the identity provider and the
+	// administrator registering kVDI with it.
+	r.PathPrefix("/api/saml/metadata").HandlerFunc(d.GetSAMLMetadata).Methods("GET")
+	r.PathPrefix("/api/saml/acs").HandlerFunc(d.PostSAMLAssertion).Methods("POST")
+
+	// SCIM provisioning routes authenticate with their own bearer token. They are served
+	// at the base URL identity providers expect, outside of the /api prefix.
+	scimRouter := r.PathPrefix("/scim/v2").Subrouter()
+	scimRouter.Use(d.ValidateSCIMToken)
+	scimRouter.HandleFunc("/ServiceProviderConfig", d.GetSCIMServiceProviderConfig).Methods("GET")
+	scimRouter.HandleFunc("/Users", d.GetSCIMUsers).Methods("GET")
+	scimRouter.HandleFunc("/Users", d.PostSCIMUser).Methods("POST")
+	scimRouter.HandleFunc("/Users/{id}", d.GetSCIMUser).Methods("GET")
+	scimRouter.HandleFunc("/Users/{id}", d.PutSCIMUser).Methods("PUT")
+	scimRouter.HandleFunc("/Users/{id}", d.PatchSCIMUser).Methods("PATCH")
+	scimRouter.HandleFunc("/Users/{id}", d.DeleteSCIMUser).Methods("DELETE")
+	scimRouter.HandleFunc("/Groups", d.GetSCIMGroups).Methods("GET")
+	scimRouter.HandleFunc("/Groups", d.PostSCIMGroup).Methods("POST")
+	scimRouter.HandleFunc("/Groups/{id}", d.GetSCIMGroup).Methods("GET")
+	scimRouter.HandleFunc("/Groups/{id}", d.PutSCIMGroup).Methods("PUT")
+	scimRouter.HandleFunc("/Groups/{id}", d.PatchSCIMGroup).Methods("PATCH")
+	scimRouter.HandleFunc("/Groups/{id}", d.DeleteSCIMGroup).Methods("DELETE")
+
+	// Public keys for verifying access tokens outside of kVDI
+	r.PathPrefix("/api/.well-known/jwks.json").HandlerFunc(d.GetJWKS).Methods("GET")
+
 	r.PathPrefix("/api/refresh_token").HandlerFunc(d.GetRefreshToken).Methods("GET") // Refresh a user's access token
 
 	// Main HTTP routes
@@ -114,24 +131,40 @@ func (d *desktopAPI) buildRouter() error {
 
 	// SUBROUTER ASSUMES /api PREFIX ON ALL ROUTES
 
-	protected.HandleFunc("/authorize", d.PostAuthorize).Methods("POST") // Verify a user's MFA token
+	protected.HandleFunc("/authorize", d.PostAuthorize).Methods("POST")                  // Verify a user's MFA token
+	protected.HandleFunc("/authorize/webauthn", d.PostAuthorizeWebAuthn).Methods("POST") // Start a WebAuthn assertion for a user's MFA
 
 	// Misc routes
 	protected.HandleFunc("/logout", d.PostLogout).Methods("POST")                             // Cleans up user's desktops
 	protected.HandleFunc("/whoami", d.GetWhoAmI).Methods("GET")                               // Convenience route for decoding JWTs
+	protected.HandleFunc("/authz/review", d.PostAuthzReview).Methods("POST")                  // Explain whether a user can perform actions
 	protected.HandleFunc("/config", d.GetConfig).Methods("GET")                               // Retrieve server configuration
 	protected.HandleFunc("/namespaces", d.GetNamespaces).Methods("GET")                       // Retrieve a list of available namespaces for the requesting user
 	protected.HandleFunc("/serviceaccounts/{namespace}", d.GetServiceAccounts).Methods("GET") // Retrieve a list of available service accounts for the requesting user
 
 	// User operations
-	protected.HandleFunc("/users", d.GetUsers).Methods("GET")                           // Retrieve a list of all users
-	protected.HandleFunc("/users", d.PostUsers).Methods("POST")                         // Create a new user
-	protected.HandleFunc("/users/{user}", d.GetUser).Methods("GET")                     // Retrieve information for a single user
-	protected.HandleFunc("/users/{user}", d.PutUser).Methods("PUT")                     // Update a user
-	protected.HandleFunc("/users/{user}/mfa", d.GetUserMFA).Methods("GET")              // Retrieve MFA status for a user
-	protected.HandleFunc("/users/{user}/mfa", d.PutUserMFA).Methods("PUT")              // Update MFA status for a user
-	protected.HandleFunc("/users/{user}/mfa/verify", d.PutUserMFAVerify).Methods("PUT") // Verify that a user has succesfully configured MFA
-	protected.HandleFunc("/users/{user}", d.DeleteUser).Methods("DELETE")               // Delete a user
+	protected.HandleFunc("/users", d.GetUsers).Methods("GET")                                               // Retrieve a list of all users
+	protected.HandleFunc("/users", d.PostUsers).Methods("POST")                                             // Create a new user
+	protected.HandleFunc("/users/{user}", d.GetUser).Methods("GET")                                         // Retrieve information for a single user
+	protected.HandleFunc("/users/{user}", d.PutUser).Methods("PUT")                                         // Update a user
+	protected.HandleFunc("/users/{user}/password", d.PutUserPassword).Methods("PUT")                        // Change a user's password with their current one
+	protected.HandleFunc("/users/{user}/mfa", d.GetUserMFA).Methods("GET")                                  // Retrieve MFA status for a user
+	protected.HandleFunc("/users/{user}/mfa", d.PutUserMFA).Methods("PUT")                                  // Update MFA status for a user
+	protected.HandleFunc("/users/{user}/mfa/verify", d.PutUserMFAVerify).Methods("PUT")                     // Verify that a user has succesfully configured MFA
+	protected.HandleFunc("/users/{user}/mfa/recovery", d.PostUserMFARecovery).Methods("POST")               // Generate new MFA recovery codes for a user
+	protected.HandleFunc("/users/{user}/mfa/reset", d.PostUserMFAReset).Methods("POST")                     // Reset MFA and require a user to enroll again
+	protected.HandleFunc("/users/{user}/mfa/webauthn", d.GetUserWebAuthn).Methods("GET")                    // List a user's WebAuthn credentials
+	protected.HandleFunc("/users/{user}/mfa/webauthn", d.PostUserWebAuthn).Methods("POST")                  // Start registering a WebAuthn credential
+	protected.HandleFunc("/users/{user}/mfa/webauthn", d.PutUserWebAuthn).Methods("PUT")                    // Finish registering a WebAuthn credential
+	protected.HandleFunc("/users/{user}/mfa/webauthn/{credential}", d.DeleteUserWebAuthn).Methods("DELETE") // Remove a WebAuthn credential
+	protected.HandleFunc("/users/{user}/unlock", d.PostUserUnlock).Methods("POST")                          // Unlock a user locked out after failed logins
+	protected.HandleFunc("/users/{user}", d.DeleteUser).Methods("DELETE")                                   // Delete a user
+	protected.HandleFunc("/users/{user}/tokens", d.GetUserAPITokens).Methods("GET")                         // List a user's API tokens
+	protected.HandleFunc("/users/{user}/tokens", d.PostUserAPIToken).Methods("POST")                        // Create an API token for a user
+	protected.HandleFunc("/users/{user}/tokens/{token}", d.DeleteUserAPIToken).Methods("DELETE")            // Revoke an API token
+	protected.HandleFunc("/users/{user}/logins", d.GetUserLogins).Methods("GET")                            // List a user's active logins
+	protected.HandleFunc("/users/{user}/logins", d.DeleteUserLogins).Methods("DELETE")                      // Revoke all of a user's logins
+	protected.HandleFunc("/users/{user}/logins/{login}", d.DeleteUserLogin).Methods("DELETE")               // Revoke a login
 
 	// Role operations
 	protected.HandleFunc("/roles", d.GetRoles).Methods("GET")             // Retrieve a list of all VDIRoles
@@ -177,7 +210,18 @@ func (d *desktopAPI) buildRouter() error {
 	return nil
 }
 
-// ServeHTTP implements an http.Handler for the API
+// ServeHTTP implements an http.Handler for the API. The RemoteAddr of the request
+// is replaced with the resolved client address before it is routed, so handlers,
+// rule conditions and login lockouts all see the same address.
 func (d *desktopAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	trustedProxies := d.vdiCluster.GetTrustedProxies()
+	if len(trustedProxies) == 0 && r.Header.Get("X-Forwarded-For") != "" {
+		d.warnForwarded.Do(func() {
+			apiLogger.Info("WARNING: Requests carry X-Forwarded-For headers but no trusted proxies are configured. "+
+				"The address of the proxy is used as the client address for audit logs, login lockouts, and rule conditions. "+
+				"Set app.trustedProxies in the VDICluster to the networks of your proxies.", "Proxy", r.RemoteAddr)
+		})
+	}
+	r.RemoteAddr = apiutil.ResolveClientAddr(r, trustedProxies)
 	d.router.ServeHTTP(w, r)
 }
@@ -0,0 +1,235 @@
+/*
+
+Copyright 2020,2021 Avi Zimmerman
+
+This file is part of kvdi.
+
+kvdi is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+kvdi is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package api
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	appv1 "github.com/kvdi/kvdi/apis/app/v1"
+	v1 "github.com/kvdi/kvdi/apis/meta/v1"
+	"github.com/kvdi/kvdi/pkg/auth/providers/composite"
+	"github.com/kvdi/kvdi/pkg/auth/scim"
+	"github.com/kvdi/kvdi/pkg/types"
+	"github.com/kvdi/kvdi/pkg/util/apiutil"
+	"github.com/kvdi/kvdi/pkg/util/errors"
+	"github.com/kvdi/kvdi/pkg/util/rbac"
+)
+
+// scimActor is the name SCIM changes are attributed to in audit records.
+const scimActor = "scim"
+
+// SCIM provisioning events for audit records
+const (
+	scimEventUserProvision   = "SCIM_USER_PROVISION"
+	scimEventUserDeprovision = "SCIM_USER_DEPROVISION"
+	scimEventUserDelete      = "SCIM_USER_DELETE"
+)
+
+// ValidateSCIMToken verifies the bearer token of SCIM requests against the one stored
+// in the secrets backend.
+func (d *desktopAPI) ValidateSCIMToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !d.vdiCluster.IsSCIMEnabled() {
+			writeSCIMError(scim.NewError(http.StatusNotFound, "", "SCIM provisioning is not enabled"), w)
+			return
+		}
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		expected, err := d.secrets.ReadSecret(v1.SCIMTokenSecretKey, true)
+		if err != nil {
+			writeSCIMError(err, w)
+			return
+		}
+		if token == "" || subtle.ConstantTimeCompare([]byte(token), expected) != 1 {
+			writeSCIMError(scim.NewError(http.StatusUnauthorized, "", "Invalid bearer token"), w)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// swagger:route GET /scim/v2/ServiceProviderConfig SCIM getSCIMServiceProviderConfig
+// Retrieves the SCIM features supported by kVDI.
+// responses:
+//
+//	200: scimResponse
+//	401: scimError
+func (d *desktopAPI) GetSCIMServiceProviderConfig(w http.ResponseWriter, r *http.Request) {
+	writeSCIM(scim.GetServiceProviderConfig(), http.StatusOK, w)
+}
+
+// applyProvisionedUser applies the VDIUser provisioned over SCIM for the given user, if
+// there is one, to a user that authenticated with one of the methods SCIM provisions
+// users for. An error is returned if the user was deactivated, otherwise the roles bound
+// to the user and their groups are added to the ones from the authentication method.
+// VDIUsers that were not provisioned over SCIM are never applied.
+func (d *desktopAPI) applyProvisionedUser(user *types.VDIUser) error {
+	if !d.vdiCluster.IsSCIMEnabled() || !isSCIMMethod(d.vdiCluster, d.getUserAuthMethod(user.Name)) {
+		return nil
+	}
+	username := user.Name
+	if d.vdiCluster.IsUsingMultipleAuthMethods() {
+		_, username = composite.SplitUsername(username)
+	}
+	objs, err := d.vdiCluster.GetSCIMUsers(d.client)
+	if err != nil {
+		return err
+	}
+	for _, obj := range objs {
+		if obj.GetUsername() != username {
+			continue
+		}
+		if obj.Spec.Disabled {
+			return errors.New("User account is disabled")
+		}
+		bindings, err := rbac.GetRoleBindings(d.client, d.vdiCluster)
+		if err != nil {
+			return err
+		}
+		names := append(obj.Spec.Roles, bindings.BoundRoleNames(rbac.ProviderSCIM, "", obj.Spec.Groups)...)
+		for _, role := range apiutil.FilterUserRolesByNames(bindings.Roles(), names) {
+			if !hasUserRole(user.Roles, role.GetName()) {
+				user.Roles = append(user.Roles, role)
+			}
+		}
+		return nil
+	}
+	return nil
+}
+
+// isSCIMMethod returns true if users provisioned over SCIM log in with the given method.
+func isSCIMMethod(cluster *appv1.VDICluster, method appv1.AuthMethod) bool {
+	for _, m := range cluster.GetSCIMMethods() {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+func hasUserRole(roles []*types.VDIUserRole, name string) bool {
+	for _, role := range roles {
+		if role.GetName() == name {
+			return true
+		}
+	}
+	return false
+}
+
+// deprovisionUser revokes the logins and API tokens of a user deactivated or deleted
+// over SCIM and cleans up their desktops. When multiple authentication methods are
+// enabled, this applies to the user under local auth and the methods SCIM provisions
+// users for.
+func (d *desktopAPI) deprovisionUser(username string, deleted bool) {
+	usernames := []string{username}
+	if d.vdiCluster.IsUsingMultipleAuthMethods() {
+		usernames = make([]string, 0)
+		for _, method := range d.vdiCluster.GetAuthMethods() {
+			if method == appv1.AuthMethodLocal || isSCIMMethod(d.vdiCluster, method) {
+				usernames = append(usernames, composite.QualifyUsername(method, username))
+			}
+		}
+	}
+	for _, name := range usernames {
+		if err := d.revokeUserLogins(name); err != nil {
+			apiLogger.Error(err, "Failed to revoke logins for deprovisioned user", "User", name)
+		}
+		if err := d.apitokens.RevokeUserTokens(name); err != nil {
+			apiLogger.Error(err, "Failed to revoke API tokens for deprovisioned user", "User", name)
+		}
+		if err := d.CleanupUserDesktops(name); err != nil {
+			apiLogger.Error(err, "Failed to clean up desktops for deprovisioned user", "User", name)
+		}
+		if !deleted {
+			continue
+		}
+		if err := d.mfa.DeleteUserWebAuthnCredentials(name); err != nil {
+			apiLogger.Error(err, "Failed to remove WebAuthn credentials for deleted user", "User", name)
+		}
+		if err := d.mfa.DeleteUserRecoveryData(name); err != nil {
+			apiLogger.Error(err, "Failed to remove MFA recovery codes for deleted user", "User", name)
+		}
+	}
+}
+
+// getSCIMPage returns the start index and count of a SCIM query. A negative count
+// is returned when none was requested.
+func getSCIMPage(r *http.Request) (startIndex, count int) {
+	startIndex, count = 1, -1
+	if val, err := strconv.Atoi(r.URL.Query().Get("startIndex")); err == nil {
+		startIndex = val
+	}
+	if val, err := strconv.Atoi(r.URL.Query().Get("count")); err == nil && val >= 0 {
+		count = val
+	}
+	return startIndex, count
+}
+
+// decodeSCIM decodes the body of a SCIM request into the given object.
+func decodeSCIM(r *http.Request, into interface{}) error {
+	if err := json.NewDecoder(r.Body).Decode(into); err != nil {
+		return scim.NewError(http.StatusBadRequest, "invalidSyntax", "Could not decode request: "+err.Error())
+	}
+	return nil
+}
+
+// writeSCIM writes the given object as a SCIM response with the given status.
+func writeSCIM(obj interface{}, status int, w http.ResponseWriter) {
+	out, err := json.Marshal(obj)
+	if err != nil {
+		writeSCIMError(err, w)
+		return
+	}
+	w.Header().Set("Content-Type", scim.ContentType)
+	w.WriteHeader(status)
+	if _, err := w.Write(out); err != nil {
+		apiLogger.Error(err, "Failed to write SCIM response")
+	}
+}
+
+// writeSCIMError writes the given error as a SCIM error response.
+func writeSCIMError(err error, w http.ResponseWriter) {
+	scimErr := scim.ToError(err)
+	out, _ := json.Marshal(scimErr)
+	w.Header().Set("Content-Type", scim.ContentType)
+	w.WriteHeader(scimErr.StatusCode())
+	if _, err := w.Write(out); err != nil {
+		apiLogger.Error(err, "Failed to write SCIM error response")
+	}
+}
+
+// SCIM response
+// swagger:response scimResponse
+type swaggerSCIMResponse struct {
+	// in:body
+	Body map[string]interface{}
+}
+
+// SCIM error response
+// swagger:response scimError
+type swaggerSCIMError struct {
+	// in:body
+	Body scim.Error
+}
@@ -20,11 +20,28 @@ along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
 package api
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"net/http"
+	"net/url"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/golang-jwt/jwt"
+	"github.com/xlzd/gotp"
+	"golang.org/x/time/rate"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	appv1 "github.com/kvdi/kvdi/apis/app/v1"
+	desktopsv1 "github.com/kvdi/kvdi/apis/desktops/v1"
+	v1 "github.com/kvdi/kvdi/apis/meta/v1"
+	rbacv1 "github.com/kvdi/kvdi/apis/rbac/v1"
 	"github.com/kvdi/kvdi/pkg/api/client"
+	"github.com/kvdi/kvdi/pkg/auth/scim"
+	"github.com/kvdi/kvdi/pkg/secrets"
 	"github.com/kvdi/kvdi/pkg/types"
 )
 
@@ -184,3 +201,1161 @@ func TestUsers(t *testing.T) {
 	}
 
 }
+
+// TestAPITokens tests personal API token operations.
+func TestAPITokens(t *testing.T) {
+	srvr, opts := mustNewTestAPI(t)
+	defer srvr.Close()
+	cl, err := client.New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+
+	// create an unrestricted token and a token that can only read users
+	full, err := cl.CreateAPIToken("admin", &types.CreateAPITokenRequest{Name: "full"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	scoped, err := cl.CreateAPIToken("admin", &types.CreateAPITokenRequest{
+		Name: "scoped",
+		TTL:  "1h",
+		Rules: []rbacv1.Rule{{
+			Verbs:            []rbacv1.Verb{rbacv1.VerbRead},
+			Resources:        []rbacv1.Resource{rbacv1.ResourceUsers},
+			ResourcePatterns: []string{".*"},
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if scoped.ExpiresAt == nil {
+		t.Error("Expected scoped token to have an expiry")
+	}
+
+	// names must be unique per user
+	if _, err := cl.CreateAPIToken("admin", &types.CreateAPITokenRequest{Name: "full"}); err == nil {
+		t.Error("Expected error creating token with duplicate name")
+	}
+
+	tokens, err := cl.GetAPITokens("admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tokens) != 2 {
+		t.Fatal("Expected two tokens, got", tokens)
+	}
+
+	// the full token can do anything the user can, except create more tokens
+	fullCl, err := client.New(&client.Opts{URL: opts.URL, APIKey: full.Token})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user, err := fullCl.WhoAmI(); err != nil {
+		t.Error("Expected to be able to use full token, got:", err)
+	} else if user.Name != "admin" {
+		t.Error("Expected token to belong to admin, got", user.Name)
+	}
+	if err := fullCl.CreateVDIUser(&types.CreateUserRequest{
+		Username: "tokenuser",
+		Password: "tokenpassword",
+		Roles:    []string{"test-cluster-launch-templates"},
+	}); err != nil {
+		t.Error("Expected to be able to create user with full token, got:", err)
+	}
+	if _, err := fullCl.CreateAPIToken("admin", &types.CreateAPITokenRequest{Name: "nested"}); err == nil {
+		t.Error("Expected error creating token with a token")
+	}
+
+	// the scoped token can only read users
+	scopedCl, err := client.New(&client.Opts{URL: opts.URL, APIKey: scoped.Token})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := scopedCl.GetVDIUsers(); err != nil {
+		t.Error("Expected to be able to list users with scoped token, got:", err)
+	}
+	if _, err := scopedCl.GetVDIRoles(); err == nil {
+		t.Error("Expected error listing roles with scoped token")
+	}
+	if _, err := scopedCl.GetAPITokens("admin"); err != nil {
+		t.Error("Expected to be able to list tokens with scoped token, got:", err)
+	}
+	if err := scopedCl.DeleteAPIToken("admin", full.ID); err == nil {
+		t.Error("Expected error revoking token with scoped token")
+	}
+
+	// revoked tokens stop working
+	if err := cl.DeleteAPIToken("admin", full.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := cl.DeleteAPIToken("admin", full.ID); err == nil {
+		t.Error("Expected error revoking token twice")
+	}
+	if _, err := fullCl.WhoAmI(); err == nil {
+		t.Error("Expected error using revoked token")
+	}
+	badCl, err := client.New(&client.Opts{URL: opts.URL, APIKey: "kvdi_bad_token"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := badCl.WhoAmI(); err == nil {
+		t.Error("Expected error using malformed token")
+	}
+}
+
+// doAPI performs a raw request against the test API with the given session token and
+// decodes the response into out, if given. The status code is returned.
+func doAPI(t *testing.T, opts *client.Opts, token, method, path string, body, out interface{}) int {
+	t.Helper()
+	var payload bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&payload).Encode(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	req, err := http.NewRequest(method, opts.URL+"/api"+path, &payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set(TokenHeader, token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if out != nil && resp.StatusCode < 300 {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return resp.StatusCode
+}
+
+// TestAPITokenRestrictions tests the limits on who can create API tokens and for how long.
+func TestAPITokenRestrictions(t *testing.T) {
+	srvr, opts := mustNewTestAPI(t)
+	defer srvr.Close()
+	cl, err := client.New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+
+	// tokens always expire, and cannot outlive the maximum ttl
+	token, err := cl.CreateAPIToken("admin", &types.CreateAPITokenRequest{Name: "default-ttl"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.ExpiresAt == nil {
+		t.Error("Expected a token created without a ttl to expire")
+	}
+	if _, err := cl.CreateAPIToken("admin", &types.CreateAPITokenRequest{Name: "forever", TTL: "100000h"}); err == nil {
+		t.Error("Expected error creating a token that outlives the maximum ttl")
+	}
+
+	// users that can update others cannot mint tokens carrying more than they hold
+	if err := cl.CreateVDIRole(&types.CreateRoleRequest{
+		Name: "user-updater",
+		Rules: []rbacv1.Rule{{
+			Verbs:            []rbacv1.Verb{rbacv1.VerbRead, rbacv1.VerbUpdate},
+			Resources:        []rbacv1.Resource{rbacv1.ResourceUsers},
+			ResourcePatterns: []string{".*"},
+		}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	for _, user := range []*types.CreateUserRequest{
+		{Username: "updater", Password: "updater-password", Roles: []string{"user-updater"}},
+		{Username: "launcher", Password: "launcher-password", Roles: []string{"test-cluster-launch-templates"}},
+	} {
+		if err := cl.CreateVDIUser(user); err != nil {
+			t.Fatal(err)
+		}
+	}
+	updaterCl, err := client.New(&client.Opts{URL: opts.URL, Username: "updater", Password: "updater-password"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer updaterCl.Close()
+	if _, err := updaterCl.CreateAPIToken("admin", &types.CreateAPITokenRequest{Name: "stolen"}); err == nil {
+		t.Error("Expected error creating a token for a user with more permissions")
+	}
+	if _, err := updaterCl.CreateAPIToken("launcher", &types.CreateAPITokenRequest{Name: "stolen"}); err == nil {
+		t.Error("Expected error creating a token for a user with other permissions")
+	}
+	if _, err := cl.CreateAPIToken("launcher", &types.CreateAPITokenRequest{Name: "granted"}); err != nil {
+		t.Error("Expected admin to be able to create a token for launcher, got:", err)
+	}
+
+	// sessions waiting on mfa can only complete it
+	if err := cl.ResetUserMFA("launcher"); err != nil {
+		t.Fatal(err)
+	}
+	session := &types.SessionResponse{}
+	if status := doAPI(t, opts, "", http.MethodPost, "/login", &types.LoginRequest{Username: "launcher", Password: "launcher-password"}, session); status != http.StatusOK {
+		t.Fatal("Expected to log in with a pending mfa enrollment, got status", status)
+	}
+	if session.Authorized {
+		t.Fatal("Expected the session to not be authorized")
+	}
+	if status := doAPI(t, opts, session.Token, http.MethodPost, "/users/launcher/tokens", &types.CreateAPITokenRequest{Name: "bypass"}, nil); status != http.StatusForbidden {
+		t.Error("Expected an unauthorized session to be forbidden from creating tokens, got status", status)
+	}
+	if status := doAPI(t, opts, session.Token, http.MethodPost, "/sessions", &types.CreateSessionRequest{Template: "ubuntu"}, nil); status != http.StatusForbidden {
+		t.Error("Expected an unauthorized session to be forbidden from creating sessions, got status", status)
+	}
+
+	// tokens cannot be exchanged for a login session with full privileges
+	scoped, err := cl.CreateAPIToken("admin", &types.CreateAPITokenRequest{
+		Name: "read-templates",
+		Rules: []rbacv1.Rule{{
+			Verbs:            []rbacv1.Verb{rbacv1.VerbRead},
+			Resources:        []rbacv1.Resource{rbacv1.ResourceTemplates},
+			ResourcePatterns: []string{".*"},
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	exchanged := &types.SessionResponse{}
+	if status := doAPI(t, opts, scoped.Token, http.MethodPost, "/authorize", &types.AuthorizeRequest{}, exchanged); status != http.StatusForbidden {
+		t.Error("Expected an API token to be forbidden from authorizing a session, got status", status)
+	}
+	if exchanged.Token != "" {
+		if status := doAPI(t, opts, exchanged.Token, http.MethodPost, "/users/admin/tokens", &types.CreateAPITokenRequest{Name: "escalated"}, nil); status == http.StatusOK {
+			t.Error("Expected a session exchanged for an API token to be forbidden from creating tokens")
+		}
+	}
+	if status := doAPI(t, opts, scoped.Token, http.MethodPost, "/authorize/webauthn", nil, nil); status != http.StatusForbidden {
+		t.Error("Expected an API token to be forbidden from starting a WebAuthn assertion, got status", status)
+	}
+	if status := doAPI(t, opts, scoped.Token, http.MethodGet, "/refresh_token", nil, nil); status != http.StatusForbidden {
+		t.Error("Expected an API token to be forbidden from refreshing a session, got status", status)
+	}
+	if status := doAPI(t, opts, scoped.Token, http.MethodGet, "/users", nil, nil); status != http.StatusForbidden {
+		t.Error("Expected a token scoped to templates to be forbidden from listing users, got status", status)
+	}
+
+	// revoking all of a user's logins also revokes their existing tokens
+	tokenCl, err := client.New(&client.Opts{URL: opts.URL, APIKey: token.Token})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tokenCl.Close()
+	if _, err := tokenCl.WhoAmI(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Second)
+	if err := cl.DeleteLogins("admin"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tokenCl.WhoAmI(); err == nil {
+		t.Error("Expected error using a token created before the user's logins were revoked")
+	}
+}
+
+// TestLogins tests listing and revoking active logins.
+func TestLogins(t *testing.T) {
+	srvr, opts := mustNewTestAPI(t)
+	defer srvr.Close()
+	cl, err := client.New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+
+	if err := cl.CreateVDIUser(&types.CreateUserRequest{
+		Username: "login-user",
+		Password: "login-password",
+		Roles:    []string{"test-cluster-launch-templates"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	userOpts := &client.Opts{URL: opts.URL, Username: "login-user", Password: "login-password"}
+	userCls := make([]*client.Client, 2)
+	for i := range userCls {
+		userCls[i], err = client.New(userOpts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer userCls[i].Close()
+	}
+
+	logins, err := cl.GetLogins("login-user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(logins) != 2 {
+		t.Fatal("Expected two logins, got", logins)
+	}
+	if logins[0].SourceIP == "" || logins[0].UserAgent == "" || logins[0].CreatedAt.IsZero() {
+		t.Error("Expected login details to be recorded, got:", logins[0])
+	}
+
+	// users can see their own logins, but not revoke those of others
+	if own, err := userCls[0].GetLogins("login-user"); err != nil || len(own) != 2 {
+		t.Error("Expected to be able to list own logins, got:", own, err)
+	}
+	if err := userCls[0].DeleteLogins("admin"); err == nil {
+		t.Error("Expected error revoking the logins of another user")
+	}
+
+	// a revoked login stops working immediately, the other one does not
+	if err := cl.DeleteLogin("login-user", logins[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := cl.DeleteLogin("login-user", logins[0].ID); err == nil {
+		t.Error("Expected error revoking a login twice")
+	}
+	var working int
+	for _, userCl := range userCls {
+		if _, err := userCl.WhoAmI(); err == nil {
+			working++
+		}
+	}
+	if working != 1 {
+		t.Error("Expected one login to keep working, got", working)
+	}
+
+	// revoking all logins stops the remaining one
+	if err := cl.DeleteLogins("login-user"); err != nil {
+		t.Fatal(err)
+	}
+	for _, userCl := range userCls {
+		if _, err := userCl.WhoAmI(); err == nil {
+			t.Error("Expected error using a revoked login")
+		}
+	}
+	if logins, err := cl.GetLogins("login-user"); err != nil || len(logins) != 0 {
+		t.Error("Expected no logins left, got:", logins, err)
+	}
+	if _, err := cl.WhoAmI(); err != nil {
+		t.Error("Expected the admin login to be unaffected, got:", err)
+	}
+}
+
+// TestJWKS tests that access tokens can be verified with the published keys.
+func TestJWKS(t *testing.T) {
+	srvr, opts := mustNewTestAPI(t)
+	defer srvr.Close()
+	cl, err := client.New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+
+	jwks, err := cl.GetJWKS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jwks.Keys) != 1 {
+		t.Fatal("Expected one published key, got", jwks.Keys)
+	}
+	if jwk := jwks.Keys[0]; jwk.Algorithm != "RS256" || jwk.Use != "sig" || !jwk.IsPublic() {
+		t.Error("Expected a public RS256 signing key, got:", jwk)
+	}
+
+	// the keys are public
+	resp, err := http.Get(opts.URL + "/api/.well-known/jwks.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Error("Expected the keys to be public, got status", resp.StatusCode)
+	}
+
+	// tokens verify with the published key matching their kid
+	body, _ := json.Marshal(&types.LoginRequest{Username: opts.Username, Password: opts.Password})
+	resp, err = http.Post(opts.URL+"/api/login", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	session := &types.SessionResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(session); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := jwt.Parse(session.Token, func(token *jwt.Token) (interface{}, error) {
+		keys := jwks.Key(token.Header["kid"].(string))
+		if len(keys) != 1 {
+			t.Fatal("Expected the token kid to match a published key, got", token.Header["kid"])
+		}
+		return keys[0].Key, nil
+	}); err != nil {
+		t.Error("Expected the token to verify with the published key, got:", err)
+	}
+}
+
+// TestLoginLockout tests that users are locked out after too many failed logins.
+func TestLoginLockout(t *testing.T) {
+	srvr, opts := mustNewTestAPI(t)
+	defer srvr.Close()
+	cl, err := client.New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+
+	if err := cl.CreateVDIUser(&types.CreateUserRequest{
+		Username: "lockout-user",
+		Password: "lockout-password",
+		Roles:    []string{"test-cluster-launch-templates"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	userOpts := &client.Opts{URL: opts.URL, Username: "lockout-user", Password: "lockout-password"}
+	badOpts := &client.Opts{URL: opts.URL, Username: "lockout-user", Password: "wrong-password"}
+
+	// the default policy allows five failures
+	for i := 0; i < 5; i++ {
+		if _, err := client.New(badOpts); err == nil {
+			t.Fatal("Expected error logging in with the wrong password")
+		} else if !strings.Contains(err.Error(), "Invalid credentials") {
+			t.Fatal("Expected invalid credentials error, got:", err)
+		}
+	}
+
+	// the correct password is now refused as well
+	if _, err := client.New(userOpts); err == nil {
+		t.Fatal("Expected error logging in to a locked account")
+	} else if !strings.Contains(err.Error(), "Too many failed login attempts") {
+		t.Fatal("Expected lockout error, got:", err)
+	}
+	user, err := cl.GetVDIUser("lockout-user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.LockedUntil == nil {
+		t.Error("Expected user to be reported as locked")
+	}
+
+	// an administrator can unlock the user
+	if err := cl.UnlockUser("lockout-user"); err != nil {
+		t.Fatal(err)
+	}
+	userCl, err := client.New(userOpts)
+	if err != nil {
+		t.Fatal("Expected to be able to login after being unlocked, got:", err)
+	}
+	userCl.Close()
+	user, err = cl.GetVDIUser("lockout-user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.LockedUntil != nil {
+		t.Error("Expected user to no longer be locked, got", user.LockedUntil)
+	}
+}
+
+// TestMFALockout tests that failed MFA codes count towards lockouts, and that a correct
+// password alone does not clear failed logins.
+func TestMFALockout(t *testing.T) {
+	srvr, opts := mustNewTestAPI(t)
+	defer srvr.Close()
+	cl, err := client.New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+
+	if err := cl.CreateVDIUser(&types.CreateUserRequest{
+		Username: "mfa-user",
+		Password: "mfa-password",
+		Roles:    []string{"test-cluster-launch-templates"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	admin := &types.SessionResponse{}
+	if status := doAPI(t, opts, "", http.MethodPost, "/login", &types.LoginRequest{Username: opts.Username, Password: opts.Password}, admin); status != http.StatusOK {
+		t.Fatal("Expected admin to log in, got status", status)
+	}
+	mfa := &types.MFAResponse{}
+	if status := doAPI(t, opts, admin.Token, http.MethodPut, "/users/mfa-user/mfa", &types.UpdateMFARequest{Enabled: true}, mfa); status != http.StatusOK {
+		t.Fatal("Expected to enable mfa, got status", status)
+	}
+	uri, err := url.Parse(mfa.ProvisioningURI)
+	if err != nil {
+		t.Fatal(err)
+	}
+	totp := gotp.NewDefaultTOTP(uri.Query().Get("secret"))
+	if status := doAPI(t, opts, admin.Token, http.MethodPut, "/users/mfa-user/mfa/verify", &types.AuthorizeRequest{OTP: totp.Now()}, nil); status != http.StatusOK {
+		t.Fatal("Expected to verify mfa, got status", status)
+	}
+
+	login := func() *types.SessionResponse {
+		t.Helper()
+		session := &types.SessionResponse{}
+		if status := doAPI(t, opts, "", http.MethodPost, "/login", &types.LoginRequest{Username: "mfa-user", Password: "mfa-password"}, session); status != http.StatusOK {
+			t.Fatal("Expected to log in with the correct password, got status", status)
+		}
+		if session.Authorized {
+			t.Fatal("Expected the session to require mfa")
+		}
+		return session
+	}
+
+	// the default policy allows five failures, and a correct password between
+	// failed codes does not reset the count
+	for i := 0; i < 5; i++ {
+		session := login()
+		if status := doAPI(t, opts, session.Token, http.MethodPost, "/authorize", &types.AuthorizeRequest{OTP: "000000"}, nil); status != http.StatusForbidden {
+			t.Fatal("Expected a wrong code to be forbidden, got status", status)
+		}
+	}
+	if status := doAPI(t, opts, "", http.MethodPost, "/login", &types.LoginRequest{Username: "mfa-user", Password: "mfa-password"}, nil); status != http.StatusForbidden {
+		t.Fatal("Expected the user to be locked out after failed codes, got status", status)
+	}
+
+	// completing mfa clears the failures
+	if err := cl.UnlockUser("mfa-user"); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 4; i++ {
+		session := login()
+		if status := doAPI(t, opts, session.Token, http.MethodPost, "/authorize", &types.AuthorizeRequest{RecoveryCode: "not-a-code"}, nil); status != http.StatusForbidden {
+			t.Fatal("Expected a wrong recovery code to be forbidden, got status", status)
+		}
+	}
+	session := login()
+	if status := doAPI(t, opts, session.Token, http.MethodPost, "/authorize", &types.AuthorizeRequest{OTP: totp.Now()}, nil); status != http.StatusOK {
+		t.Fatal("Expected the correct code to authorize the session, got status", status)
+	}
+	session = login()
+	if status := doAPI(t, opts, session.Token, http.MethodPost, "/authorize", &types.AuthorizeRequest{OTP: "000000"}, nil); status != http.StatusForbidden {
+		t.Fatal("Expected a wrong code to be forbidden, got status", status)
+	}
+	login()
+}
+
+// TestMFAEnrollment tests that sessions waiting on MFA enrollment are limited like
+// logins, and can't be used once the user has enrolled.
+func TestMFAEnrollment(t *testing.T) {
+	srvr, opts := mustNewTestAPI(t)
+	defer srvr.Close()
+	cl, err := client.New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+
+	if err := cl.CreateVDIUser(&types.CreateUserRequest{
+		Username: "enroll-user",
+		Password: "enroll-password",
+		Roles:    []string{"test-cluster-launch-templates"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	admin := &types.SessionResponse{}
+	if status := doAPI(t, opts, "", http.MethodPost, "/login", &types.LoginRequest{Username: opts.Username, Password: opts.Password}, admin); status != http.StatusOK {
+		t.Fatal("Expected admin to log in, got status", status)
+	}
+	if status := doAPI(t, opts, admin.Token, http.MethodPost, "/users/enroll-user/mfa/reset", nil, nil); status != http.StatusOK {
+		t.Fatal("Expected to reset mfa, got status", status)
+	}
+
+	session := &types.SessionResponse{}
+	if status := doAPI(t, opts, "", http.MethodPost, "/login", &types.LoginRequest{Username: "enroll-user", Password: "enroll-password"}, session); status != http.StatusOK {
+		t.Fatal("Expected to log in, got status", status)
+	}
+	if session.Authorized {
+		t.Fatal("Expected the session to require enrollment")
+	}
+	mfa := &types.MFAResponse{}
+	if status := doAPI(t, opts, session.Token, http.MethodPut, "/users/enroll-user/mfa", &types.UpdateMFARequest{Enabled: true}, mfa); status != http.StatusOK {
+		t.Fatal("Expected to enroll in mfa, got status", status)
+	}
+
+	// failed codes while verifying the enrollment count towards lockouts
+	for i := 0; i < 5; i++ {
+		if status := doAPI(t, opts, session.Token, http.MethodPut, "/users/enroll-user/mfa/verify", &types.AuthorizeRequest{OTP: "000000"}, nil); status != http.StatusForbidden {
+			t.Fatal("Expected a wrong code to be forbidden, got status", status)
+		}
+	}
+	if status := doAPI(t, opts, session.Token, http.MethodGet, "/users/enroll-user/mfa", nil, nil); status != http.StatusForbidden {
+		t.Fatal("Expected enrollment requests to be refused while locked out, got status", status)
+	}
+	if err := cl.UnlockUser("enroll-user"); err != nil {
+		t.Fatal(err)
+	}
+
+	uri, err := url.Parse(mfa.ProvisioningURI)
+	if err != nil {
+		t.Fatal(err)
+	}
+	totp := gotp.NewDefaultTOTP(uri.Query().Get("secret"))
+	if status := doAPI(t, opts, session.Token, http.MethodPut, "/users/enroll-user/mfa/verify", &types.AuthorizeRequest{OTP: totp.Now()}, nil); status != http.StatusOK {
+		t.Fatal("Expected to verify the enrollment, got status", status)
+	}
+
+	// the enrollment session can't change mfa once the user has enrolled
+	if status := doAPI(t, opts, session.Token, http.MethodPut, "/users/enroll-user/mfa", &types.UpdateMFARequest{Enabled: false}, nil); status != http.StatusForbidden {
+		t.Fatal("Expected the enrollment session to be refused after enrolling, got status", status)
+	}
+}
+
+// TestUpdateRoleLimits tests that role updates without bandwidth limits keep the
+// current ones.
+func TestUpdateRoleLimits(t *testing.T) {
+	srvr, opts := mustNewTestAPI(t)
+	defer srvr.Close()
+	cl, err := client.New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+
+	limits := &rbacv1.BandwidthLimits{PerUserBytesPerSecond: 1024, PerConnectionBytesPerSecond: 512}
+	if err := cl.CreateVDIRole(&types.CreateRoleRequest{
+		Name:            "limited-role",
+		BandwidthLimits: limits,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// editors that don't know about limits leave them out
+	rules := []rbacv1.Rule{{Verbs: []rbacv1.Verb{rbacv1.VerbRead}, Resources: []rbacv1.Resource{rbacv1.ResourceTemplates}}}
+	if err := cl.UpdateVDIRole("limited-role", &types.UpdateRoleRequest{Rules: rules}); err != nil {
+		t.Fatal(err)
+	}
+	role, err := cl.GetVDIRole("limited-role")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(role.Rules) != 1 || role.GetBandwidthLimits() == nil || *role.GetBandwidthLimits() != *limits {
+		t.Error("Expected the rules to be updated and the limits kept, got", role.Rules, role.GetBandwidthLimits())
+	}
+
+	// an empty object removes them
+	if err := cl.UpdateVDIRole("limited-role", &types.UpdateRoleRequest{Rules: rules, BandwidthLimits: &rbacv1.BandwidthLimits{}}); err != nil {
+		t.Fatal(err)
+	}
+	role, err = cl.GetVDIRole("limited-role")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if role.GetBandwidthLimits() != nil {
+		t.Error("Expected the limits to be removed, got", role.GetBandwidthLimits())
+	}
+}
+
+// TestBandwidthManager tests that concurrent streams of a user share a limiter that
+// applies the most restrictive of their limits.
+func TestBandwidthManager(t *testing.T) {
+	b := newBandwidthManager()
+	loose := &rbacv1.BandwidthLimits{PerUserBytesPerSecond: 1000}
+	strict := &rbacv1.BandwidthLimits{PerUserBytesPerSecond: 100}
+
+	var wg sync.WaitGroup
+	limiters := make([]*rate.Limiter, 2)
+	releases := make([]func(), 2)
+	for i, limits := range []*rbacv1.BandwidthLimits{strict, loose} {
+		wg.Add(1)
+		go func(i int, limits *rbacv1.BandwidthLimits) {
+			defer wg.Done()
+			limiters[i], releases[i] = b.acquire("alice", limits)
+		}(i, limits)
+	}
+	wg.Wait()
+
+	if limiters[0] != limiters[1] {
+		t.Fatal("Expected the streams to share a limiter")
+	}
+	if limit := limiters[0].Limit(); limit != 100 {
+		t.Error("Expected the strictest limit to apply regardless of order, got", limit)
+	}
+	other, releaseOther := b.acquire("bob", loose)
+	if other == limiters[0] || other.Limit() != 1000 {
+		t.Error("Expected other users to have their own limiter, got", other.Limit())
+	}
+	releaseOther()
+
+	// the remaining stream's limits apply once the strict one is finished
+	releases[0]()
+	releases[0]()
+	if limit := limiters[1].Limit(); limit != 1000 {
+		t.Error("Expected the remaining stream's limit to apply, got", limit)
+	}
+	releases[1]()
+	if len(b.users) != 0 {
+		t.Error("Expected the limiters to be removed, got", b.users)
+	}
+}
+
+// TestChangePassword tests users changing their own password.
+func TestChangePassword(t *testing.T) {
+	srvr, opts := mustNewTestAPI(t)
+	defer srvr.Close()
+	cl, err := client.New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+
+	if err := cl.CreateVDIUser(&types.CreateUserRequest{
+		Username: "password-user",
+		Password: "old-password",
+		Roles:    []string{"test-cluster-launch-templates"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	userCl, err := client.New(&client.Opts{URL: opts.URL, Username: "password-user", Password: "old-password"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer userCl.Close()
+
+	// the current password is required
+	if err := userCl.ChangeVDIUserPassword("password-user", "wrong-password", "new-password"); err == nil {
+		t.Error("Expected error changing password with the wrong current password")
+	} else if !strings.Contains(err.Error(), "Invalid credentials") {
+		t.Error("Expected invalid credentials error, got:", err)
+	}
+
+	// users can't change the password of others this way
+	if err := userCl.ChangeVDIUserPassword("admin", opts.Password, "new-password"); err == nil {
+		t.Error("Expected error changing the password of another user")
+	}
+
+	if err := userCl.ChangeVDIUserPassword("password-user", "old-password", "new-password"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.New(&client.Opts{URL: opts.URL, Username: "password-user", Password: "old-password"}); err == nil {
+		t.Error("Expected error logging in with the old password")
+	}
+	newCl, err := client.New(&client.Opts{URL: opts.URL, Username: "password-user", Password: "new-password"})
+	if err != nil {
+		t.Fatal("Expected to be able to login with the new password, got:", err)
+	}
+	newCl.Close()
+}
+
+// doSCIM performs a SCIM request against the test API and decodes the response
+// into out, if given. The status code is returned.
+func doSCIM(t *testing.T, opts *client.Opts, token, method, path string, body, out interface{}) int {
+	t.Helper()
+	var payload bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&payload).Encode(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	req, err := http.NewRequest(method, opts.URL+"/scim/v2"+path, &payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", scim.ContentType)
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if out != nil && resp.StatusCode < 300 {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return resp.StatusCode
+}
+
+// TestSCIM tests provisioning users and groups over SCIM.
+func TestSCIM(t *testing.T) {
+	srvr, opts := mustNewTestAPI(t)
+	defer srvr.Close()
+	cl, err := client.New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+
+	// the token is required
+	if status := doSCIM(t, opts, "wrong-token", http.MethodGet, "/Users", nil, nil); status != http.StatusUnauthorized {
+		t.Error("Expected unauthorized with a wrong token, got", status)
+	}
+	if status := doSCIM(t, opts, testSCIMToken, http.MethodGet, "/ServiceProviderConfig", nil, nil); status != http.StatusOK {
+		t.Error("Expected to get the service provider config, got", status)
+	}
+
+	// users created outside of scim are invisible to it
+	if err := cl.CreateVDIUser(&types.CreateUserRequest{
+		Username: "local-user",
+		Password: "local-password",
+		Roles:    []string{"test-cluster-launch-templates"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	list := &struct {
+		TotalResults int          `json:"totalResults"`
+		Resources    []*scim.User `json:"Resources"`
+	}{}
+	if status := doSCIM(t, opts, testSCIMToken, http.MethodGet, "/Users", nil, list); status != http.StatusOK {
+		t.Fatal("Expected to list users, got", status)
+	}
+	if list.TotalResults != 0 {
+		t.Fatal("Expected local users not to be listed, got", list.Resources)
+	}
+
+	// users provisioned over scim are VDIUsers
+	user := &scim.User{}
+	if status := doSCIM(t, opts, testSCIMToken, http.MethodPost, "/Users", &scim.User{
+		Schemas:  []string{scim.UserSchema},
+		UserName: "scim-user",
+	}, user); status != http.StatusCreated {
+		t.Fatal("Expected to create a user, got", status)
+	}
+	if err := cl.UpdateVDIUser("scim-user", &types.UpdateUserRequest{
+		Password: "scim-password",
+		Roles:    []string{"test-cluster-launch-templates"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if status := doSCIM(t, opts, testSCIMToken, http.MethodGet, `/Users?filter=userName+eq+"scim-user"`, nil, list); status != http.StatusOK {
+		t.Fatal("Expected to list users, got", status)
+	}
+	if list.TotalResults != 1 || list.Resources[0].ID != user.ID {
+		t.Fatal("Expected to find scim-user, got", list.Resources)
+	}
+	created := &scim.User{}
+	if status := doSCIM(t, opts, testSCIMToken, http.MethodPost, "/Users", &scim.User{
+		Schemas:  []string{scim.UserSchema},
+		UserName: "provisioned-user",
+	}, created); status != http.StatusCreated {
+		t.Fatal("Expected to create a user, got", status)
+	}
+	if _, err := cl.GetVDIUser("provisioned-user"); err != nil {
+		t.Error("Expected the provisioned user to exist, got:", err)
+	}
+
+	// roles are bound to scim groups by annotation
+	if err := cl.CreateVDIRole(&types.CreateRoleRequest{
+		Name:        "scim-role",
+		Annotations: map[string]string{v1.SCIMGroupRoleAnnotation: "devs"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	group := &scim.Group{}
+	if status := doSCIM(t, opts, testSCIMToken, http.MethodPost, "/Groups", &scim.Group{
+		Schemas:     []string{scim.GroupSchema},
+		DisplayName: "devs",
+		Members:     []scim.Member{{Value: user.ID}},
+	}, group); status != http.StatusCreated {
+		t.Fatal("Expected to create a group, got", status)
+	}
+	userCl, err := client.New(&client.Opts{URL: opts.URL, Username: "scim-user", Password: "scim-password"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer userCl.Close()
+	whoami, err := userCl.WhoAmI()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var bound bool
+	for _, role := range whoami.Roles {
+		bound = bound || role.Name == "scim-role"
+	}
+	if !bound {
+		t.Error("Expected the user to be bound to the group role, got", whoami.Roles)
+	}
+
+	// deactivating a user revokes their logins and blocks new ones
+	if status := doSCIM(t, opts, testSCIMToken, http.MethodPatch, "/Users/"+user.ID, &scim.PatchRequest{
+		Schemas:    []string{scim.PatchOpSchema},
+		Operations: []scim.PatchOperation{{Op: "replace", Path: "active", Value: false}},
+	}, nil); status != http.StatusOK {
+		t.Fatal("Expected to deactivate the user, got", status)
+	}
+	if _, err := userCl.WhoAmI(); err == nil {
+		t.Error("Expected error using the login of a deactivated user")
+	}
+	if _, err := client.New(&client.Opts{URL: opts.URL, Username: "scim-user", Password: "scim-password"}); err == nil {
+		t.Error("Expected error logging in as a deactivated user")
+	}
+
+	// deleting a group or user removes it
+	if status := doSCIM(t, opts, testSCIMToken, http.MethodDelete, "/Groups/"+group.ID, nil, nil); status != http.StatusNoContent {
+		t.Error("Expected to delete the group, got", status)
+	}
+	if status := doSCIM(t, opts, testSCIMToken, http.MethodDelete, "/Users/"+created.ID, nil, nil); status != http.StatusNoContent {
+		t.Error("Expected to delete the user, got", status)
+	}
+	if status := doSCIM(t, opts, testSCIMToken, http.MethodGet, "/Users/"+created.ID, nil, nil); status != http.StatusNotFound {
+		t.Error("Expected the deleted user to be gone, got", status)
+	}
+}
+
+// TestImpersonation tests acting as another user with the Impersonate-User header.
+func TestImpersonation(t *testing.T) {
+	srvr, opts := mustNewTestAPI(t)
+	defer srvr.Close()
+	cl, err := client.New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+
+	if err := cl.CreateVDIUser(&types.CreateUserRequest{
+		Username: "impersonated-user",
+		Password: "impersonated-password",
+		Roles:    []string{"test-cluster-launch-templates"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// requests are evaluated with the roles of the impersonated user
+	asOpts := *opts
+	asOpts.ImpersonateUser = "impersonated-user"
+	asCl, err := client.New(&asOpts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer asCl.Close()
+	whoami, err := asCl.WhoAmI()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if whoami.Name != "impersonated-user" || len(whoami.Roles) != 1 || whoami.Roles[0].Name != "test-cluster-launch-templates" {
+		t.Error("Expected to act as the impersonated user, got", whoami)
+	}
+	if _, err := asCl.GetVDIRoles(); err == nil {
+		t.Error("Expected the impersonated user not to be able to read roles")
+	}
+
+	// the credentials of the impersonated user cannot be managed
+	if _, err := asCl.CreateAPIToken("impersonated-user", &types.CreateAPITokenRequest{Name: "stolen"}); err == nil {
+		t.Error("Expected error creating an API token for the impersonated user")
+	}
+
+	// impersonating requires the impersonate verb
+	userOpts := &client.Opts{URL: opts.URL, Username: "impersonated-user", Password: "impersonated-password", ImpersonateUser: "admin"}
+	if userCl, err := client.New(userOpts); err != nil {
+		t.Fatal(err)
+	} else {
+		if _, err := userCl.WhoAmI(); err == nil {
+			t.Error("Expected error impersonating without the impersonate verb")
+		}
+		userCl.Close()
+	}
+
+	// unknown users cannot be impersonated
+	missingOpts := *opts
+	missingOpts.ImpersonateUser = "missing-user"
+	if missingCl, err := client.New(&missingOpts); err != nil {
+		t.Fatal(err)
+	} else {
+		if _, err := missingCl.WhoAmI(); err == nil {
+			t.Error("Expected error impersonating a user that does not exist")
+		}
+		missingCl.Close()
+	}
+}
+
+// TestAccessReview tests explaining authorization decisions for users.
+func TestAccessReview(t *testing.T) {
+	srvr, opts := mustNewTestAPI(t)
+	defer srvr.Close()
+	cl, err := client.New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+
+	if err := cl.CreateVDIUser(&types.CreateUserRequest{
+		Username: "review-user",
+		Password: "review-password",
+		Roles:    []string{"test-cluster-launch-templates"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	launch := types.APIAction{
+		Verb:              rbacv1.VerbLaunch,
+		ResourceType:      rbacv1.ResourceTemplates,
+		ResourceName:      "ubuntu",
+		ResourceNamespace: "default",
+	}
+	readRoles := types.APIAction{
+		Verb:         rbacv1.VerbRead,
+		ResourceType: rbacv1.ResourceRoles,
+	}
+
+	// admins can review other users
+	resp, err := cl.ReviewAccess(&types.AccessReviewRequest{
+		User:    "review-user",
+		Actions: []types.APIAction{launch, readRoles},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.User != "review-user" || len(resp.Results) != 2 {
+		t.Fatal("Expected two results for review-user, got", resp)
+	}
+	if res := resp.Results[0]; !res.Allowed || len(res.MatchedRules) != 1 || res.MatchedRules[0].Role != "test-cluster-launch-templates" {
+		t.Error("Expected launching templates to be allowed by the launch-templates role, got", res)
+	}
+	if res := resp.Results[1]; res.Allowed || res.Reason == "" || res.MissingRule == nil {
+		t.Error("Expected reading roles to be denied with a missing rule, got", res)
+	}
+
+	// invalid requests are rejected
+	if _, err := cl.ReviewAccess(&types.AccessReviewRequest{User: "review-user"}); err == nil {
+		t.Error("Expected error reviewing access without any actions")
+	}
+	if _, err := cl.ReviewAccess(&types.AccessReviewRequest{User: "missing-user", Actions: []types.APIAction{launch}}); err == nil {
+		t.Error("Expected error reviewing access for a user that does not exist")
+	}
+
+	// users can review themselves, but not others without the ability to read them
+	userCl, err := client.New(&client.Opts{URL: opts.URL, Username: "review-user", Password: "review-password"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer userCl.Close()
+	resp, err = userCl.ReviewAccess(&types.AccessReviewRequest{Actions: []types.APIAction{launch}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.User != "review-user" || !resp.Results[0].Allowed {
+		t.Error("Expected review-user to be able to launch templates, got", resp)
+	}
+	if _, err := userCl.ReviewAccess(&types.AccessReviewRequest{User: "admin", Actions: []types.APIAction{launch}}); err == nil {
+		t.Error("Expected error reviewing another user without the ability to read users")
+	}
+}
+
+// TestFileTransferQuota tests that daily quotas are reserved atomically per user and
+// template, and settled to the bytes actually transferred.
+func TestFileTransferQuota(t *testing.T) {
+	d := &desktopAPI{vdiCluster: &appv1.VDICluster{}}
+	d.vdiCluster.Name = "test-cluster"
+	var err error
+	d.secrets, d.client, err = secrets.NewTestEngine(d.vdiCluster)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newTemplate := func(name string) *desktopsv1.Template {
+		tmpl := &desktopsv1.Template{}
+		tmpl.Name = name
+		tmpl.Spec.ProxyConfig = &desktopsv1.ProxyConfig{FileTransferPolicy: &desktopsv1.FileTransferPolicy{DailyUserQuota: 100}}
+		return tmpl
+	}
+	tmpl := newTemplate("quota-template")
+
+	// concurrent transfers can't reserve more than the quota between them
+	var wg sync.WaitGroup
+	var mux sync.Mutex
+	reservations := make([]*fileTransferReservation, 0)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res, err := d.reserveFileTransfer(tmpl, "alice", "file.txt", "text/plain", 30)
+			if err == nil {
+				mux.Lock()
+				reservations = append(reservations, res)
+				mux.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if len(reservations) != 3 {
+		t.Fatalf("Expected 3 transfers to fit in the quota, got %d", len(reservations))
+	}
+
+	// settling a short transfer frees the rest of its reservation
+	if err := d.settleFileTransfer(reservations[0], 10); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.reserveFileTransfer(tmpl, "alice", "file.txt", "text/plain", 30); err != nil {
+		t.Error("Expected the unused part of a reservation to be released, got:", err)
+	}
+	if _, err := d.reserveFileTransfer(tmpl, "alice", "file.txt", "text/plain", 1); err == nil {
+		t.Error("Expected the quota to be exhausted")
+	}
+
+	// the quota applies to each template separately
+	if _, err := d.reserveFileTransfer(newTemplate("other-template"), "alice", "file.txt", "text/plain", 100); err != nil {
+		t.Error("Expected quotas to be tracked per template, got:", err)
+	}
+	if _, err := d.reserveFileTransfer(tmpl, "bob", "file.txt", "text/plain", 100); err != nil {
+		t.Error("Expected quotas to be tracked per user, got:", err)
+	}
+}
+
+// TestSCIMMethods tests that users provisioned over SCIM only apply to the methods
+// SCIM provisions users for.
+func TestSCIMMethods(t *testing.T) {
+	scheme, err := buildScheme()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := fake.NewFakeClientWithScheme(scheme)
+	d := &desktopAPI{client: c, vdiCluster: &appv1.VDICluster{}}
+	d.vdiCluster.Name = "test-cluster"
+	d.vdiCluster.Spec.Auth = &appv1.AuthConfig{
+		Providers:      []appv1.AuthMethod{appv1.AuthMethodClientCert, appv1.AuthMethodOIDC},
+		OIDCAuth:       &appv1.OIDCConfig{IssuerURL: "https://idp.example.com", RedirectURL: "https://kvdi.example.com/api/login"},
+		ClientCertAuth: &appv1.ClientCertConfig{CACertificates: "test-ca"},
+		SCIM:           &appv1.SCIMConfig{Enabled: true},
+	}
+	role := d.vdiCluster.GetLaunchTemplatesRole()
+	if err := c.Create(context.TODO(), role); err != nil {
+		t.Fatal(err)
+	}
+	provisioned := &rbacv1.VDIUser{}
+	provisioned.Name = "test-cluster-bob"
+	provisioned.Labels = map[string]string{
+		v1.RoleClusterRefLabel:  d.vdiCluster.GetName(),
+		v1.SCIMProvisionedLabel: "true",
+	}
+	provisioned.Spec.Username = "bob"
+	provisioned.Spec.Roles = []string{role.GetName()}
+	if err := c.Create(context.TODO(), provisioned); err != nil {
+		t.Fatal(err)
+	}
+
+	applied := func(username string) bool {
+		t.Helper()
+		user := &types.VDIUser{Name: username}
+		if err := d.applyProvisionedUser(user); err != nil {
+			t.Fatal(err)
+		}
+		return hasUserRole(user.Roles, role.GetName())
+	}
+
+	// the first oidc or saml method is provisioned by default, even when it is not primary
+	if !applied("oidc.bob") {
+		t.Error("Expected the provisioned user to apply to oidc.bob")
+	}
+	if applied("clientcert.bob") {
+		t.Error("Expected the provisioned user to not apply to a client certificate with the same name")
+	}
+
+	// the methods can be configured
+	d.vdiCluster.Spec.Auth.SCIM.Methods = []appv1.AuthMethod{appv1.AuthMethodClientCert}
+	if !applied("clientcert.bob") {
+		t.Error("Expected the provisioned user to apply to clientcert.bob")
+	}
+	if applied("oidc.bob") {
+		t.Error("Expected the provisioned user to not apply to oidc.bob")
+	}
+
+	// deactivated users only fail logins of the provisioned methods
+	provisioned.Spec.Disabled = true
+	if err := c.Update(context.TODO(), provisioned); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.applyProvisionedUser(&types.VDIUser{Name: "clientcert.bob"}); err == nil {
+		t.Error("Expected error applying a deactivated user")
+	}
+	if err := d.applyProvisionedUser(&types.VDIUser{Name: "oidc.bob"}); err != nil {
+		t.Error("Expected a deactivated user to not apply to other methods, got:", err)
+	}
+}
@@ -0,0 +1,86 @@
+/*
+
+Copyright 2020,2021 Avi Zimmerman
+
+This file is part of kvdi.
+
+kvdi is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+kvdi is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package api
+
+import (
+	"time"
+
+	appv1 "github.com/kvdi/kvdi/apis/app/v1"
+	"github.com/kvdi/kvdi/pkg/types"
+	"github.com/kvdi/kvdi/pkg/util/apiutil"
+	"github.com/kvdi/kvdi/pkg/util/rbac"
+
+	"github.com/golang-jwt/jwt"
+)
+
+// getAPITokenSession verifies the given personal API token and builds the session
+// claims for the request. The user's roles are looked up on every request where the
+// auth provider allows it. Otherwise the roles the user had when the token was created
+// are resolved to their current rules, so that role changes apply to existing tokens.
+func (d *desktopAPI) getAPITokenSession(token string) (*types.JWTClaims, error) {
+	record, err := d.apitokens.VerifyToken(token)
+	if err != nil {
+		return nil, err
+	}
+
+	var user *types.VDIUser
+	switch d.getUserAuthMethod(record.User) {
+	case appv1.AuthMethodLocal, appv1.AuthMethodLDAP:
+		user, err = d.auth.GetUser(record.User)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		roles, err := d.vdiCluster.GetRoles(d.client)
+		if err != nil {
+			return nil, err
+		}
+		user = &types.VDIUser{
+			Name:  record.User,
+			Roles: apiutil.FilterUserRolesByNames(roles, record.GetRoleNames()),
+		}
+	}
+
+	if err := d.applyProvisionedUser(user); err != nil {
+		return nil, err
+	}
+
+	if record.Rules != nil {
+		user.Roles = rbac.RestrictUserRoles(user, record.Rules, NewResourceGetter(d))
+	}
+
+	claims := &types.JWTClaims{
+		User:           user,
+		Authorized:     true,
+		APITokenID:     record.ID,
+		APITokenScoped: record.Rules != nil,
+		StandardClaims: jwt.StandardClaims{
+			IssuedAt: record.CreatedAt.Unix(),
+		},
+	}
+	if record.ExpiresAt != nil {
+		claims.ExpiresAt = record.ExpiresAt.Unix()
+	} else {
+		claims.ExpiresAt = time.Now().Add(d.vdiCluster.GetTokenDuration()).Unix()
+	}
+	return claims, nil
+}
@@ -0,0 +1,45 @@
+/*
+
+Copyright 2020,2021 Avi Zimmerman
+
+This file is part of kvdi.
+
+kvdi is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+kvdi is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package api
+
+import (
+	"github.com/kvdi/kvdi/pkg/auth/common"
+	"github.com/kvdi/kvdi/pkg/auth/providers/composite"
+)
+
+// migrateUsernames moves the records kept for users under the names of a single
+// authentication provider to the names served by a composite provider, so that
+// enabling more authentication methods does not orphan MFA enrollments, API tokens
+// and logins. It does nothing for other providers.
+func (d *desktopAPI) migrateUsernames(provider common.AuthProvider) error {
+	cp, ok := provider.(*composite.AuthProvider)
+	if !ok {
+		return nil
+	}
+	if err := d.mfa.MigrateUsernames(cp.QualifyBareUsername); err != nil {
+		return err
+	}
+	if err := d.apitokens.MigrateUsernames(cp.QualifyBareUsername); err != nil {
+		return err
+	}
+	return d.logins.MigrateUsernames(cp.QualifyBareUsername)
+}
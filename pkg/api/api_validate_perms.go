@@ -71,6 +71,28 @@ var RouterGrantRequirements = map[string]map[string]MethodPermissions{
 			OverrideFunc: allowAll,
 		},
 	},
+	"/api/authorize/webauthn": {
+		"POST": {
+			OverrideFunc: allowAll,
+		},
+	},
+	"/api/authz/review": {
+		"POST": {
+			Actions: []ActionTemplate{
+				{
+					APIAction: types.APIAction{
+						Verb:         rbacv1.VerbRead,
+						ResourceType: rbacv1.ResourceUsers,
+					},
+					ResourceNameFunc: func(r *http.Request) string {
+						req := apiutil.GetRequestObject(r).(*types.AccessReviewRequest)
+						return req.User
+					},
+				},
+			},
+			OverrideFunc: allowSelfReview,
+		},
+	},
 	"/api/logout": {
 		"POST": {
 			OverrideFunc: allowAll,
@@ -197,6 +219,193 @@ var RouterGrantRequirements = map[string]map[string]MethodPermissions{
 			OverrideFunc: allowSameUser,
 		},
 	},
+	"/api/users/{user}/password": {
+		"PUT": {
+			Actions: []ActionTemplate{
+				{
+					APIAction: types.APIAction{
+						Verb:         rbacv1.VerbUpdate,
+						ResourceType: rbacv1.ResourceUsers,
+					},
+					ResourceNameFunc: apiutil.GetUserFromRequest,
+				},
+			},
+			OverrideFunc: allowSameUser,
+		},
+	},
+	"/api/users/{user}/mfa/recovery": {
+		"POST": {
+			Actions: []ActionTemplate{
+				{
+					APIAction: types.APIAction{
+						Verb:         rbacv1.VerbUpdate,
+						ResourceType: rbacv1.ResourceUsers,
+					},
+					ResourceNameFunc: apiutil.GetUserFromRequest,
+				},
+			},
+			OverrideFunc: allowSameUser,
+		},
+	},
+	"/api/users/{user}/mfa/reset": {
+		"POST": {
+			Actions: []ActionTemplate{
+				{
+					APIAction: types.APIAction{
+						Verb:         rbacv1.VerbUpdate,
+						ResourceType: rbacv1.ResourceUsers,
+					},
+					ResourceNameFunc: apiutil.GetUserFromRequest,
+				},
+			},
+		},
+	},
+	"/api/users/{user}/unlock": {
+		"POST": {
+			Actions: []ActionTemplate{
+				{
+					APIAction: types.APIAction{
+						Verb:         rbacv1.VerbUpdate,
+						ResourceType: rbacv1.ResourceUsers,
+					},
+					ResourceNameFunc: apiutil.GetUserFromRequest,
+				},
+			},
+		},
+	},
+	"/api/users/{user}/mfa/webauthn": {
+		"GET": {
+			Actions: []ActionTemplate{
+				{
+					APIAction: types.APIAction{
+						Verb:         rbacv1.VerbRead,
+						ResourceType: rbacv1.ResourceUsers,
+					},
+					ResourceNameFunc: apiutil.GetUserFromRequest,
+				},
+			},
+			OverrideFunc: allowSameUser,
+		},
+		"POST": {
+			Actions: []ActionTemplate{
+				{
+					APIAction: types.APIAction{
+						Verb:         rbacv1.VerbUpdate,
+						ResourceType: rbacv1.ResourceUsers,
+					},
+					ResourceNameFunc: apiutil.GetUserFromRequest,
+				},
+			},
+			OverrideFunc: allowSameUser,
+		},
+		"PUT": {
+			Actions: []ActionTemplate{
+				{
+					APIAction: types.APIAction{
+						Verb:         rbacv1.VerbUpdate,
+						ResourceType: rbacv1.ResourceUsers,
+					},
+					ResourceNameFunc: apiutil.GetUserFromRequest,
+				},
+			},
+			OverrideFunc: allowSameUser,
+		},
+	},
+	"/api/users/{user}/mfa/webauthn/{credential}": {
+		"DELETE": {
+			Actions: []ActionTemplate{
+				{
+					APIAction: types.APIAction{
+						Verb:         rbacv1.VerbUpdate,
+						ResourceType: rbacv1.ResourceUsers,
+					},
+					ResourceNameFunc: apiutil.GetUserFromRequest,
+				},
+			},
+			OverrideFunc: allowSameUser,
+		},
+	},
+	"/api/users/{user}/tokens": {
+		"GET": {
+			Actions: []ActionTemplate{
+				{
+					APIAction: types.APIAction{
+						Verb:         rbacv1.VerbRead,
+						ResourceType: rbacv1.ResourceUsers,
+					},
+					ResourceNameFunc: apiutil.GetUserFromRequest,
+				},
+			},
+			OverrideFunc: allowSameUser,
+		},
+		"POST": {
+			Actions: []ActionTemplate{
+				{
+					APIAction: types.APIAction{
+						Verb:         rbacv1.VerbUpdate,
+						ResourceType: rbacv1.ResourceUsers,
+					},
+					ResourceNameFunc: apiutil.GetUserFromRequest,
+				},
+			},
+			OverrideFunc:   allowSameUser,
+			ExtraCheckFunc: denyUserElevatePerms,
+		},
+	},
+	"/api/users/{user}/tokens/{token}": {
+		"DELETE": {
+			Actions: []ActionTemplate{
+				{
+					APIAction: types.APIAction{
+						Verb:         rbacv1.VerbUpdate,
+						ResourceType: rbacv1.ResourceUsers,
+					},
+					ResourceNameFunc: apiutil.GetUserFromRequest,
+				},
+			},
+			OverrideFunc: allowSameUser,
+		},
+	},
+	"/api/users/{user}/logins": {
+		"GET": {
+			Actions: []ActionTemplate{
+				{
+					APIAction: types.APIAction{
+						Verb:         rbacv1.VerbRead,
+						ResourceType: rbacv1.ResourceUsers,
+					},
+					ResourceNameFunc: apiutil.GetUserFromRequest,
+				},
+			},
+			OverrideFunc: allowSameUser,
+		},
+		"DELETE": {
+			Actions: []ActionTemplate{
+				{
+					APIAction: types.APIAction{
+						Verb:         rbacv1.VerbUpdate,
+						ResourceType: rbacv1.ResourceUsers,
+					},
+					ResourceNameFunc: apiutil.GetUserFromRequest,
+				},
+			},
+			OverrideFunc: allowSameUser,
+		},
+	},
+	"/api/users/{user}/logins/{login}": {
+		"DELETE": {
+			Actions: []ActionTemplate{
+				{
+					APIAction: types.APIAction{
+						Verb:         rbacv1.VerbUpdate,
+						ResourceType: rbacv1.ResourceUsers,
+					},
+					ResourceNameFunc: apiutil.GetUserFromRequest,
+				},
+			},
+			OverrideFunc: allowSameUser,
+		},
+	},
 	"/api/roles": {
 		"GET": {
 			Actions: []ActionTemplate{
@@ -556,13 +765,17 @@ func (d *desktopAPI) ValidateUserGrants(next http.Handler) http.Handler {
 			// We were not allowed, but we may have a grant that lets us anyway
 		}
 
+		actionContext := apiutil.GetRequestActionContext(r)
 		for _, action := range methodGrant.Actions {
 			apiAction := buildActionFromTemplate(action, r)
+			apiAction.Context = actionContext
 			result.Actions = append(result.Actions, apiAction)
-			if !rbac.EvaluateUser(userSession.User, apiAction) {
-				msg := fmt.Sprintf("%s does not have the ability to %s", userSession.User.Name, apiAction.String())
+			if decision := rbac.DecideUser(userSession.User, apiAction); !decision.Allowed {
+				msg := fmt.Sprintf("%s does not have the ability to %s: %s", userSession.User.Name, apiAction.String(), decision.Reason())
 				apiutil.ReturnAPIForbidden(nil, msg, w)
 				result.Allowed = false
+				result.DeniedBy = decision.DeniedBy
+				result.FailedConditions = decision.FailedConditions
 				d.auditLog(result)
 				return
 			}
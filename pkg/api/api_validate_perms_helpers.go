@@ -29,6 +29,11 @@ import (
 )
 
 func allowSameUser(d *desktopAPI, reqUser *types.VDIUser, r *http.Request) (allowed, owner bool, err error) {
+	// scoped API tokens only get the permissions in their rules, and impersonators
+	// cannot manage the credentials of the user they act as
+	if session := apiutil.GetRequestUserSession(r); session.APITokenScoped || session.ImpersonatedBy != "" {
+		return false, false, nil
+	}
 	pathUser := apiutil.GetUserFromRequest(r)
 	if reqUser.Name != pathUser {
 		return false, false, nil
@@ -38,7 +43,19 @@ func allowSameUser(d *desktopAPI, reqUser *types.VDIUser, r *http.Request) (allo
 	return allowed, true, err
 }
 
+func allowSelfReview(d *desktopAPI, reqUser *types.VDIUser, r *http.Request) (allowed, owner bool, err error) {
+	req := apiutil.GetRequestObject(r).(*types.AccessReviewRequest)
+	if req.User == "" || req.User == reqUser.Name {
+		return true, true, nil
+	}
+	return false, false, nil
+}
+
 func allowSessionOwner(d *desktopAPI, reqUser *types.VDIUser, r *http.Request) (allowed, owner bool, err error) {
+	// scoped API tokens only get the permissions in their rules
+	if apiutil.GetRequestUserSession(r).APITokenScoped {
+		return false, false, nil
+	}
 	nn := apiutil.GetNamespacedNameFromRequest(r)
 	found := &desktopsv1.Session{}
 	if err := d.client.Get(context.TODO(), nn, found); err != nil {
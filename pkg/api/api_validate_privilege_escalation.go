@@ -22,9 +22,11 @@ package api
 import (
 	"net/http"
 
+	appv1 "github.com/kvdi/kvdi/apis/app/v1"
 	rbacv1 "github.com/kvdi/kvdi/apis/rbac/v1"
 	"github.com/kvdi/kvdi/pkg/types"
 	"github.com/kvdi/kvdi/pkg/util/apiutil"
+	"github.com/kvdi/kvdi/pkg/util/errors"
 	"github.com/kvdi/kvdi/pkg/util/rbac"
 )
 
@@ -33,9 +35,18 @@ var elevateDenyReason = "The requested operation grants more privileges than the
 func denyUserElevatePerms(d *desktopAPI, reqUser *types.VDIUser, r *http.Request) (allowed bool, reason string, err error) {
 
 	// This is an ugly hack at the moment. This will be triggered if called from
-	// allowSameUser while configuring MFA options. No need to check.
-	if apiutil.GetGorillaPath(r) == "/api/users/{user}/mfa" {
+	// allowSameUser while configuring MFA options, passwords, API tokens, or logins. No
+	// need to check, the rules for new API tokens are checked below.
+	switch apiutil.GetGorillaPath(r) {
+	case "/api/users/{user}/mfa", "/api/users/{user}/mfa/verify", "/api/users/{user}/mfa/recovery",
+		"/api/users/{user}/mfa/webauthn", "/api/users/{user}/mfa/webauthn/{credential}",
+		"/api/users/{user}/password", "/api/users/{user}/tokens/{token}",
+		"/api/users/{user}/logins", "/api/users/{user}/logins/{login}":
 		return true, "", nil
+	case "/api/users/{user}/tokens":
+		if r.Method == http.MethodGet {
+			return true, "", nil
+		}
 	}
 
 	// Check that a POST /users will not grant permissions the user does not have.
@@ -44,16 +55,8 @@ func denyUserElevatePerms(d *desktopAPI, reqUser *types.VDIUser, r *http.Request
 		if err != nil {
 			return false, "", err
 		}
-		for _, role := range reqObj.Roles {
-			roleObj := getRoleByName(vdiRoles, role)
-			if roleObj == nil {
-				continue
-			}
-			for _, rule := range roleObj.GetRules() {
-				if !rbac.UserIncludesRule(reqUser, rule, NewResourceGetter(d)) {
-					return false, elevateDenyReason, nil
-				}
-			}
+		if !rbac.UserIncludesRules(reqUser, getRoleRules(vdiRoles, reqObj.Roles), NewResourceGetter(d)) {
+			return false, elevateDenyReason, nil
 		}
 		return true, "", nil
 	}
@@ -64,37 +67,60 @@ func denyUserElevatePerms(d *desktopAPI, reqUser *types.VDIUser, r *http.Request
 		if err != nil {
 			return false, "", err
 		}
-		for _, role := range reqObj.Roles {
-			roleObj := getRoleByName(vdiRoles, role)
-			if roleObj == nil {
-				continue
-			}
-			for _, rule := range roleObj.GetRules() {
-				if !rbac.UserIncludesRule(reqUser, rule, NewResourceGetter(d)) {
-					return false, elevateDenyReason, nil
-				}
-			}
+		if !rbac.UserIncludesRules(reqUser, getRoleRules(vdiRoles, reqObj.Roles), NewResourceGetter(d)) {
+			return false, elevateDenyReason, nil
 		}
 		return true, "", nil
 	}
 
 	// Check that a POST /roles will not grant permissions the user does not have.
 	if reqObj, ok := apiutil.GetRequestObject(r).(*types.CreateRoleRequest); ok {
-		for _, rule := range reqObj.GetRules() {
-			if !rbac.UserIncludesRule(reqUser, rule, NewResourceGetter(d)) {
-				return false, elevateDenyReason, nil
-			}
+		if !rbac.UserIncludesRules(reqUser, reqObj.GetRules(), NewResourceGetter(d)) {
+			return false, elevateDenyReason, nil
 		}
 		return true, "", nil
 	}
 
 	// Check that a PUT /roles/{role} will not grant permissions the user does not have.
 	if reqObj, ok := apiutil.GetRequestObject(r).(*types.UpdateRoleRequest); ok {
-		for _, rule := range reqObj.GetRules() {
+		if !rbac.UserIncludesRules(reqUser, reqObj.GetRules(), NewResourceGetter(d)) {
+			return false, elevateDenyReason, nil
+		}
+		return true, "", nil
+	}
+
+	// Check that a POST /users/{user}/tokens will not grant permissions the user does not have.
+	// The deny rules of the user still apply to the token, so they do not need to be repeated.
+	if reqObj, ok := apiutil.GetRequestObject(r).(*types.CreateAPITokenRequest); ok {
+		for _, rule := range reqObj.Rules {
 			if !rbac.UserIncludesRule(reqUser, rule, NewResourceGetter(d)) {
 				return false, elevateDenyReason, nil
 			}
 		}
+		// A token for another user carries all of their roles, so the requesting user
+		// must already hold every rule of the target.
+		if target := apiutil.GetUserFromRequest(r); target != reqUser.Name {
+			switch d.getUserAuthMethod(target) {
+			case appv1.AuthMethodLocal, appv1.AuthMethodLDAP:
+			default:
+				// the handler refuses tokens for users that cannot be looked up
+				return true, "", nil
+			}
+			targetUser, err := d.auth.GetUser(target)
+			if err != nil {
+				if errors.IsUserNotFoundError(err) {
+					// the handler returns the not found error
+					return true, "", nil
+				}
+				return false, "", err
+			}
+			if err := d.applyProvisionedUser(targetUser); err != nil {
+				return false, "", err
+			}
+			if !rbac.UserIncludesRules(reqUser, getUserRules(targetUser), NewResourceGetter(d)) {
+				return false, elevateDenyReason, nil
+			}
+		}
 		return true, "", nil
 	}
 
@@ -110,3 +136,24 @@ func getRoleByName(roles []*rbacv1.VDIRole, name string) *rbacv1.VDIRole {
 	}
 	return nil
 }
+
+// getRoleRules returns the rules of all the roles with the given names. Deny rules apply
+// across all of a user's roles, so they are checked together.
+func getRoleRules(roles []*rbacv1.VDIRole, names []string) []rbacv1.Rule {
+	rules := make([]rbacv1.Rule, 0)
+	for _, name := range names {
+		if roleObj := getRoleByName(roles, name); roleObj != nil {
+			rules = append(rules, roleObj.GetRules()...)
+		}
+	}
+	return rules
+}
+
+// getUserRules returns the rules of all of the given user's roles.
+func getUserRules(user *types.VDIUser) []rbacv1.Rule {
+	rules := make([]rbacv1.Rule, 0)
+	for _, role := range user.Roles {
+		rules = append(rules, role.Rules...)
+	}
+	return rules
+}
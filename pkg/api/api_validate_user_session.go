@@ -21,13 +21,16 @@ package api
 
 import (
 	"net/http"
+	"strings"
 
-	v1 "github.com/kvdi/kvdi/apis/meta/v1"
+	"github.com/kvdi/kvdi/pkg/auth/apitokens"
+	"github.com/kvdi/kvdi/pkg/types"
 	"github.com/kvdi/kvdi/pkg/util/apiutil"
 )
 
 // ValidateUserSession retrieves the JWT token from the X-Session-Token and
-// verifies that it is valid.
+// verifies that it is valid. When the Impersonate-User header is set, the rest
+// of the request is evaluated as that user.
 func (d *desktopAPI) ValidateUserSession(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// get the auth token
@@ -47,23 +50,71 @@ func (d *desktopAPI) ValidateUserSession(next http.Handler) http.Handler {
 			return
 		}
 
-		// retrieve the jwt secret
-		jwtSecret, err := d.secrets.ReadSecret(v1.JWTSecretKey, true)
-		if err != nil {
-			apiutil.ReturnAPIError(err, w)
+		// personal API tokens are looked up in the secrets backend
+		if strings.HasPrefix(authToken, apitokens.TokenPrefix) {
+			session, err := d.getAPITokenSession(authToken)
+			if err != nil {
+				apiutil.ReturnAPIUnauthorized(err, "Invalid API token", w)
+				return
+			}
+			// tokens created before all of the user's logins were revoked die with them
+			revoked, err := d.logins.IsRevoked(session)
+			if err != nil {
+				apiutil.ReturnAPIError(err, w)
+				return
+			}
+			if revoked {
+				apiutil.ReturnAPIUnauthorized(nil, "API token provided in the request has been revoked", w)
+				return
+			}
+			// tokens are already authorized and cannot be exchanged for a login session
+			if isAuthorizeRequest(r) {
+				apiutil.ReturnAPIForbidden(nil, "API tokens cannot be used to authorize a session", w)
+				return
+			}
+			session, ok := d.impersonateUser(session, w, r)
+			if !ok {
+				return
+			}
+			apiutil.SetRequestUserSession(r, session)
+			next.ServeHTTP(w, r)
 			return
 		}
 
-		// verify the token and retrieve the claims
-		session, err := apiutil.DecodeAndVerifyJWT(jwtSecret, authToken)
+		// verify the token against the key it was signed with and retrieve the claims
+		session, err := apiutil.DecodeAndVerifyJWT(d.signing.VerificationKey, authToken)
 		if err != nil {
 			apiutil.ReturnAPIUnauthorized(nil, err.Error(), w)
 			return
 		}
 
-		// let requests to authorize a token with mfa to go through
-		if !session.Authorized && apiutil.GetGorillaPath(r) != "/api/authorize" && r.Method != http.MethodPost {
-			apiutil.ReturnAPIForbidden(nil, "User session is not authorized", w)
+		// access tokens of revoked logins die with them
+		revoked, err := d.logins.IsRevoked(session)
+		if err != nil {
+			apiutil.ReturnAPIError(err, w)
+			return
+		}
+		if revoked {
+			apiutil.ReturnAPIUnauthorized(nil, "Token provided in the request has been revoked", w)
+			return
+		}
+
+		// let requests to authorize a token with mfa or log out go through, as well as requests
+		// to enroll in mfa when it was reset for the user, or to change an expired password
+		if !session.Authorized && !isUnauthorizedSessionRequest(r) && !isPasswordChangeRequest(session, r) {
+			if !d.isMFAEnrollmentRequest(session, r) {
+				apiutil.ReturnAPIForbidden(nil, "User session is not authorized", w)
+				return
+			}
+			// enrollment attempts are limited the same way logins are
+			if d.checkLoginLockout(w, r, session.User.Name) {
+				return
+			}
+		}
+
+		// Evaluate the request as the impersonated user, if any
+		session, ok := d.impersonateUser(session, w, r)
+		if !ok {
 			return
 		}
 
@@ -74,3 +125,53 @@ func (d *desktopAPI) ValidateUserSession(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+// unauthorizedSessionRoutes are the routes and methods that sessions waiting on MFA
+// can use to complete it or end the session.
+var unauthorizedSessionRoutes = map[string]string{
+	"/api/authorize":          http.MethodPost,
+	"/api/authorize/webauthn": http.MethodPost,
+	"/api/logout":             http.MethodPost,
+}
+
+// isUnauthorizedSessionRequest returns true if the request is allowed for sessions that
+// are not authorized yet.
+func isUnauthorizedSessionRequest(r *http.Request) bool {
+	method, ok := unauthorizedSessionRoutes[apiutil.GetGorillaPath(r)]
+	return ok && r.Method == method
+}
+
+// isAuthorizeRequest returns true if the request is for authorizing a session with MFA.
+func isAuthorizeRequest(r *http.Request) bool {
+	return strings.HasPrefix(apiutil.GetGorillaPath(r), "/api/authorize")
+}
+
+// isMFAEnrollmentRequest returns true if the session is waiting on the user to enroll
+// in MFA and the request is for configuring their own MFA. The stored MFA state is checked
+// as well, so the session can no longer be used once the user has enrolled.
+func (d *desktopAPI) isMFAEnrollmentRequest(session *types.JWTClaims, r *http.Request) bool {
+	if session.User.MFA == nil || !session.User.MFA.EnrollmentRequired {
+		return false
+	}
+	if !strings.HasPrefix(apiutil.GetGorillaPath(r), "/api/users/{user}/mfa") ||
+		apiutil.GetUserFromRequest(r) != session.User.Name {
+		return false
+	}
+	required, err := d.mfa.EnrollmentRequired(session.User.Name)
+	if err != nil {
+		apiLogger.Error(err, "Failed to check if MFA enrollment is required", "User", session.User.Name)
+		return false
+	}
+	return required
+}
+
+// isPasswordChangeRequest returns true if the session is waiting on the user to change
+// their expired password, any MFA was already completed, and the request is for changing
+// their own password.
+func isPasswordChangeRequest(session *types.JWTClaims, r *http.Request) bool {
+	if !session.User.PasswordChangeRequired || session.User.MFA != nil {
+		return false
+	}
+	return apiutil.GetGorillaPath(r) == "/api/users/{user}/password" &&
+		apiutil.GetUserFromRequest(r) == session.User.Name
+}
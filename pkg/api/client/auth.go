@@ -37,6 +37,7 @@ func (c *Client) authenticate() error {
 		Username: c.opts.Username,
 		Password: c.opts.Password,
 		State:    uuid.New().String(),
+		Method:   c.opts.AuthMethod,
 	}
 	payload, err := json.Marshal(loginRequest)
 	if err != nil {
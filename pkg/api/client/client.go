@@ -52,8 +52,11 @@ type Opts struct {
 	Username string
 	// The password to use to authenticate.
 	Password string
-	// TODO: Allow for API keys tied to roles for auth providers that don't allow us
-	// to independently verify credentials (e.g. OpenID).
+	// The authentication method to use when the server enables more than one.
+	AuthMethod string
+	// A personal API token to use instead of a username and password. This is useful
+	// for auth providers that don't allow us to independently verify credentials
+	// (e.g. OpenID).
 	APIKey string
 	// The PEM encoded CA certificate to use when validating the kVDI server certificate.
 	// When using the generated certificate, this can be found in the kvdi-app
@@ -61,6 +64,12 @@ type Opts struct {
 	TLSCACert []byte
 	// Set to true to skip TLS verification.
 	TLSInsecureSkipVerify bool
+	// The PEM encoded client certificate and key to present to the kVDI server. These are
+	// used for authenticating with the clientcert method.
+	TLSClientCert, TLSClientKey []byte
+	// The name of a user to act as with the Impersonate-User header. The authenticated
+	// user needs the impersonate verb on them.
+	ImpersonateUser string
 }
 
 // New creates a new kVDI client.
@@ -86,11 +95,25 @@ func New(opts *Opts) (*Client, error) {
 			certPool.AppendCertsFromPEM(cl.opts.TLSCACert)
 			cl.tlsConfig.RootCAs = certPool
 		}
+		if len(cl.opts.TLSClientCert) != 0 {
+			cert, err := tls.X509KeyPair(cl.opts.TLSClientCert, cl.opts.TLSClientKey)
+			if err != nil {
+				return nil, err
+			}
+			cl.tlsConfig.Certificates = []tls.Certificate{cert}
+		}
 		cl.httpClient.Transport = &http.Transport{
 			TLSClientConfig: cl.tlsConfig,
 		}
 	}
 
+	// API tokens are used as-is and cannot be refreshed
+	if cl.opts.APIKey != "" {
+		cl.tokenRetry = false
+		cl.setAccessToken(cl.opts.APIKey)
+		return cl, nil
+	}
+
 	return cl, cl.authenticate()
 }
 
@@ -100,6 +123,10 @@ func (c *Client) SetAutoRefreshToken(val bool) { c.tokenRetry = val }
 
 // Close will stop the token refresh goroutine if it's running.
 func (c *Client) Close() {
+	// There is no session to log out of when using an API token
+	if c.opts.APIKey != "" {
+		return
+	}
 	if err := c.do(http.MethodPost, "logout", nil, nil, false); err != nil {
 		log.Println("Error posting to /api/logout. Refresh token could not be revoked:", err)
 	}
@@ -25,6 +25,10 @@ import (
 	"io"
 	"mime/multipart"
 	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/go-jose/go-jose/v3"
 
 	appv1 "github.com/kvdi/kvdi/apis/app/v1"
 	desktopsv1 "github.com/kvdi/kvdi/apis/desktops/v1"
@@ -57,6 +61,12 @@ func (c *Client) GetServerConfig() (*appv1.VDIClusterSpec, error) {
 	return spec, c.do(http.MethodGet, "config", nil, spec)
 }
 
+// GetJWKS returns the public keys for verifying access tokens issued by the server.
+func (c *Client) GetJWKS() (*jose.JSONWebKeySet, error) {
+	jwks := &jose.JSONWebKeySet{}
+	return jwks, c.do(http.MethodGet, ".well-known/jwks.json", nil, jwks)
+}
+
 // GetNamespaces retrieves a list of namespaces the current user has access to.
 func (c *Client) GetNamespaces() ([]string, error) {
 	var nss []string
@@ -76,6 +86,13 @@ func (c *Client) WhoAmI() (*types.VDIUser, error) {
 	return user, c.do(http.MethodGet, "whoami", nil, user)
 }
 
+// ReviewAccess evaluates the given actions against the roles of a user and returns
+// the decision for each of them along with the rules that explain it.
+func (c *Client) ReviewAccess(req *types.AccessReviewRequest) (*types.AccessReviewResponse, error) {
+	resp := &types.AccessReviewResponse{}
+	return resp, c.do(http.MethodPost, "authz/review", req, resp)
+}
+
 // Desktop functions
 
 // GetDesktopSessions retrieves the status of currently running desktop sessions in
@@ -103,7 +120,47 @@ func (c *Client) GetDesktopDisplayProxy(nn NamespacedName) (io.ReadWriteCloser,
 
 // GetDesktopAudioProxy returns a ReadWriteCloser proxying the audio of the given session.
 func (c *Client) GetDesktopAudioProxy(nn NamespacedName) (io.ReadWriteCloser, error) {
-	return c.doWebsocket(fmt.Sprintf("desktops/ws/%s/%s/audio", nn.Namespace, nn.Name))
+	return c.GetDesktopAudioProxyWithCodec(nn, "", 0)
+}
+
+// GetDesktopAudioProxyWithCodec returns a ReadWriteCloser proxying the audio of the given session
+// with playback encoded in the given codec and bitrate. Empty values use the server defaults.
+func (c *Client) GetDesktopAudioProxyWithCodec(nn NamespacedName, codec string, bitrate int) (io.ReadWriteCloser, error) {
+	return c.GetDesktopAudioProxyWithOptions(nn, &AudioProxyOptions{Codec: codec, Bitrate: bitrate})
+}
+
+// AudioProxyOptions are the parameters for an audio stream. Empty values use the
+// server defaults.
+type AudioProxyOptions struct {
+	// The codec to encode playback with
+	Codec string
+	// The bitrate in bits per second for Opus codecs
+	Bitrate int
+	// The direction of the stream: playback, capture, or both
+	Mode string
+}
+
+// GetDesktopAudioProxyWithOptions returns a ReadWriteCloser proxying the audio of the given
+// session with the given options.
+func (c *Client) GetDesktopAudioProxyWithOptions(nn NamespacedName, opts *AudioProxyOptions) (io.ReadWriteCloser, error) {
+	if opts == nil {
+		opts = &AudioProxyOptions{}
+	}
+	query := url.Values{}
+	if opts.Codec != "" {
+		query.Set("codec", opts.Codec)
+	}
+	if opts.Bitrate != 0 {
+		query.Set("bitrate", strconv.Itoa(opts.Bitrate))
+	}
+	if opts.Mode != "" {
+		query.Set("mode", opts.Mode)
+	}
+	endpoint := fmt.Sprintf("desktops/ws/%s/%s/audio", nn.Namespace, nn.Name)
+	if len(query) > 0 {
+		endpoint = endpoint + "?" + query.Encode()
+	}
+	return c.doWebsocket(endpoint)
 }
 
 // StatDesktopFile retrieves stat information for the given path on the desktop.
@@ -245,9 +302,64 @@ func (c *Client) UpdateVDIUser(name string, req *types.UpdateUserRequest) error
 	return c.do(http.MethodPut, fmt.Sprintf("users/%s", name), req, nil)
 }
 
+// ChangeVDIUserPassword changes the password of the given VDIUser with their current
+// one. The user must log in again afterwards.
+func (c *Client) ChangeVDIUserPassword(name, currentPassword, newPassword string) error {
+	return c.do(http.MethodPut, fmt.Sprintf("users/%s/password", name), &types.ChangePasswordRequest{
+		CurrentPassword: currentPassword,
+		NewPassword:     newPassword,
+	}, nil)
+}
+
 // DeleteVDIUser will delete the given VDIUser.
 func (c *Client) DeleteVDIUser(name string) error {
 	return c.do(http.MethodDelete, fmt.Sprintf("users/%s", name), nil, nil)
 }
 
+// GetAPITokens returns the personal API tokens for the given user. The tokens themselves
+// are only ever returned when they are created.
+func (c *Client) GetAPITokens(user string) ([]*types.APIToken, error) {
+	tokens := make([]*types.APIToken, 0)
+	return tokens, c.do(http.MethodGet, fmt.Sprintf("users/%s/tokens", user), nil, &tokens)
+}
+
+// CreateAPIToken creates a new personal API token for the given user. The returned
+// token can be used in the APIKey client option.
+func (c *Client) CreateAPIToken(user string, req *types.CreateAPITokenRequest) (*types.CreateAPITokenResponse, error) {
+	resp := &types.CreateAPITokenResponse{}
+	return resp, c.do(http.MethodPost, fmt.Sprintf("users/%s/tokens", user), req, resp)
+}
+
+// DeleteAPIToken revokes the personal API token with the given ID for the given user.
+func (c *Client) DeleteAPIToken(user, id string) error {
+	return c.do(http.MethodDelete, fmt.Sprintf("users/%s/tokens/%s", user, id), nil, nil)
+}
+
+// GetLogins returns the active logins for the given user.
+func (c *Client) GetLogins(user string) ([]*types.UserLogin, error) {
+	logins := make([]*types.UserLogin, 0)
+	return logins, c.do(http.MethodGet, fmt.Sprintf("users/%s/logins", user), nil, &logins)
+}
+
+// DeleteLogin revokes the login with the given ID for the given user.
+func (c *Client) DeleteLogin(user, id string) error {
+	return c.do(http.MethodDelete, fmt.Sprintf("users/%s/logins/%s", user, id), nil, nil)
+}
+
+// DeleteLogins revokes all logins for the given user.
+func (c *Client) DeleteLogins(user string) error {
+	return c.do(http.MethodDelete, fmt.Sprintf("users/%s/logins", user), nil, nil)
+}
+
+// ResetUserMFA removes all MFA methods and recovery codes for the given user and
+// requires them to enroll again at their next login.
+func (c *Client) ResetUserMFA(user string) error {
+	return c.do(http.MethodPost, fmt.Sprintf("users/%s/mfa/reset", user), nil, nil)
+}
+
+// UnlockUser removes the lockout and any failed logins counted against the given user.
+func (c *Client) UnlockUser(user string) error {
+	return c.do(http.MethodPost, fmt.Sprintf("users/%s/unlock", user), nil, nil)
+}
+
 // TODO: Should MFA management functions be implemented?
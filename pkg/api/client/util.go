@@ -45,9 +45,14 @@ func (c *Client) getEndpoint(ep string) string {
 }
 
 // getWebsocketEndpoint returns the full URL (token included) for a given websocket endpoint.
+// The endpoint may already contain query parameters.
 func (c *Client) getWebsocketEndpoint(ep string) string {
 	u := strings.Replace(c.opts.URL, "http", "ws", 1)
-	return fmt.Sprintf("%s/api/%s?token=%s", u, ep, c.getAccessToken())
+	sep := "?"
+	if strings.Contains(ep, "?") {
+		sep = "&"
+	}
+	return fmt.Sprintf("%s/api/%s%stoken=%s", u, ep, sep, c.getAccessToken())
 }
 
 // doWebsocket is a helper function for a generic websocket request flow with the API.
@@ -55,7 +60,11 @@ func (c *Client) doWebsocket(endpoint string) (io.ReadWriteCloser, error) {
 	dialer := websocket.Dialer{
 		TLSClientConfig: c.tlsConfig,
 	}
-	conn, _, err := dialer.Dial(c.getWebsocketEndpoint(endpoint), nil)
+	var header http.Header
+	if c.opts.ImpersonateUser != "" {
+		header = http.Header{"Impersonate-User": []string{c.opts.ImpersonateUser}}
+	}
+	conn, _, err := dialer.Dial(c.getWebsocketEndpoint(endpoint), header)
 	if err != nil {
 		return nil, err
 	}
@@ -81,6 +90,9 @@ func (c *Client) doRaw(method, endpoint string, req interface{}) (*http.Response
 
 	r.Header.Add("X-Session-Token", c.getAccessToken())
 	r.Header.Add("Content-Type", "application/json")
+	if c.opts.ImpersonateUser != "" {
+		r.Header.Add("Impersonate-User", c.opts.ImpersonateUser)
+	}
 
 	return c.httpClient.Do(r)
 }
@@ -56,5 +56,14 @@ func (d *desktopAPI) DeleteUser(w http.ResponseWriter, r *http.Request) {
 		apiutil.ReturnAPIError(err, w)
 		return
 	}
+	if err := d.apitokens.RevokeUserTokens(username); err != nil {
+		apiLogger.Error(err, "Failed to revoke API tokens for deleted user", "User", username)
+	}
+	if err := d.mfa.DeleteUserWebAuthnCredentials(username); err != nil {
+		apiLogger.Error(err, "Failed to remove WebAuthn credentials for deleted user", "User", username)
+	}
+	if err := d.mfa.DeleteUserRecoveryData(username); err != nil {
+		apiLogger.Error(err, "Failed to remove MFA recovery codes for deleted user", "User", username)
+	}
 	apiutil.WriteOK(w)
 }
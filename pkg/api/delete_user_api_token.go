@@ -0,0 +1,67 @@
+/*
+
+Copyright 2020,2021 Avi Zimmerman
+
+This file is part of kvdi.
+
+kvdi is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+kvdi is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package api
+
+import (
+	"net/http"
+
+	"github.com/kvdi/kvdi/pkg/util/apiutil"
+	"github.com/kvdi/kvdi/pkg/util/errors"
+)
+
+// swagger:operation DELETE /api/users/{user}/tokens/{token} Users deleteUserAPITokenRequest
+// ---
+// summary: Revokes a personal API token for the given user.
+// parameters:
+//   - name: user
+//     in: path
+//     description: The user owning the token
+//     type: string
+//     required: true
+//   - name: token
+//     in: path
+//     description: The ID of the token to revoke
+//     type: string
+//     required: true
+//
+// responses:
+//
+//	"200":
+//	  "$ref": "#/responses/boolResponse"
+//	"400":
+//	  "$ref": "#/responses/error"
+//	"403":
+//	  "$ref": "#/responses/error"
+//	"404":
+//	  "$ref": "#/responses/error"
+func (d *desktopAPI) DeleteUserAPIToken(w http.ResponseWriter, r *http.Request) {
+	username := apiutil.GetUserFromRequest(r)
+	if err := d.apitokens.RevokeToken(username, apiutil.GetAPITokenFromRequest(r)); err != nil {
+		if errors.IsAPITokenNotFoundError(err) {
+			apiutil.ReturnAPINotFound(err, w)
+			return
+		}
+		apiutil.ReturnAPIError(err, w)
+		return
+	}
+	apiutil.WriteOK(w)
+}
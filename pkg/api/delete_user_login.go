@@ -0,0 +1,96 @@
+/*
+
+Copyright 2020,2021 Avi Zimmerman
+
+This file is part of kvdi.
+
+kvdi is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+kvdi is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package api
+
+import (
+	"net/http"
+
+	"github.com/kvdi/kvdi/pkg/util/apiutil"
+	"github.com/kvdi/kvdi/pkg/util/errors"
+)
+
+// swagger:operation DELETE /api/users/{user}/logins/{login} Users deleteUserLoginRequest
+// ---
+// summary: Revokes a login for the given user. Its refresh token can no longer be used and its access tokens are rejected immediately.
+// parameters:
+//   - name: user
+//     in: path
+//     description: The user owning the login
+//     type: string
+//     required: true
+//   - name: login
+//     in: path
+//     description: The ID of the login to revoke
+//     type: string
+//     required: true
+//
+// responses:
+//
+//	"200":
+//	  "$ref": "#/responses/boolResponse"
+//	"400":
+//	  "$ref": "#/responses/error"
+//	"403":
+//	  "$ref": "#/responses/error"
+//	"404":
+//	  "$ref": "#/responses/error"
+func (d *desktopAPI) DeleteUserLogin(w http.ResponseWriter, r *http.Request) {
+	username := apiutil.GetUserFromRequest(r)
+	if err := d.revokeLogin(username, apiutil.GetLoginFromRequest(r)); err != nil {
+		if errors.IsLoginNotFoundError(err) {
+			apiutil.ReturnAPINotFound(err, w)
+			return
+		}
+		apiutil.ReturnAPIError(err, w)
+		return
+	}
+	d.auditUserEvent(loginEventRevoke, apiutil.GetRequestUserSession(r).User.Name, username, r)
+	apiutil.WriteOK(w)
+}
+
+// swagger:operation DELETE /api/users/{user}/logins Users deleteUserLoginsRequest
+// ---
+// summary: Revokes all logins for the given user. All of their refresh tokens and access tokens stop working immediately.
+// parameters:
+//   - name: user
+//     in: path
+//     description: The user to log out everywhere
+//     type: string
+//     required: true
+//
+// responses:
+//
+//	"200":
+//	  "$ref": "#/responses/boolResponse"
+//	"400":
+//	  "$ref": "#/responses/error"
+//	"403":
+//	  "$ref": "#/responses/error"
+func (d *desktopAPI) DeleteUserLogins(w http.ResponseWriter, r *http.Request) {
+	username := apiutil.GetUserFromRequest(r)
+	if err := d.revokeUserLogins(username); err != nil {
+		apiutil.ReturnAPIError(err, w)
+		return
+	}
+	d.auditUserEvent(loginEventRevokeAll, apiutil.GetRequestUserSession(r).User.Name, username, r)
+	apiutil.WriteOK(w)
+}
@@ -0,0 +1,67 @@
+/*
+
+Copyright 2020,2021 Avi Zimmerman
+
+This file is part of kvdi.
+
+kvdi is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+kvdi is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package api
+
+import (
+	"net/http"
+
+	"github.com/kvdi/kvdi/pkg/util/apiutil"
+	"github.com/kvdi/kvdi/pkg/util/errors"
+)
+
+// swagger:operation DELETE /api/users/{user}/mfa/webauthn/{credential} Users deleteUserWebAuthnRequest
+// ---
+// summary: Removes a WebAuthn credential for the given user.
+// parameters:
+//   - name: user
+//     in: path
+//     description: The user owning the credential
+//     type: string
+//     required: true
+//   - name: credential
+//     in: path
+//     description: The ID of the credential to remove
+//     type: string
+//     required: true
+//
+// responses:
+//
+//	"200":
+//	  "$ref": "#/responses/boolResponse"
+//	"400":
+//	  "$ref": "#/responses/error"
+//	"403":
+//	  "$ref": "#/responses/error"
+//	"404":
+//	  "$ref": "#/responses/error"
+func (d *desktopAPI) DeleteUserWebAuthn(w http.ResponseWriter, r *http.Request) {
+	username := apiutil.GetUserFromRequest(r)
+	if err := d.mfa.DeleteWebAuthnCredential(username, apiutil.GetWebAuthnCredentialFromRequest(r)); err != nil {
+		if errors.IsWebAuthnCredentialNotFoundError(err) {
+			apiutil.ReturnAPINotFound(err, w)
+			return
+		}
+		apiutil.ReturnAPIError(err, w)
+		return
+	}
+	apiutil.WriteOK(w)
+}
@@ -29,6 +29,7 @@ import (
 	"github.com/kvdi/kvdi/pkg/proxyproto"
 	"github.com/kvdi/kvdi/pkg/types"
 	"github.com/kvdi/kvdi/pkg/util/apiutil"
+	"github.com/kvdi/kvdi/pkg/util/errors"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
@@ -127,7 +128,7 @@ type swaggerStatDesktopFileResponse struct {
 //	"404":
 //	  "$ref": "#/responses/error"
 func (d *desktopAPI) GetDownloadDesktopFile(w http.ResponseWriter, r *http.Request) {
-	proxy, err := d.getProxyClientForRequest(r)
+	tmpl, err := d.getTemplateForRequest(r)
 	if err != nil {
 		if client.IgnoreNotFound(err) == nil {
 			apiutil.ReturnAPINotFound(err, w)
@@ -137,6 +138,26 @@ func (d *desktopAPI) GetDownloadDesktopFile(w http.ResponseWriter, r *http.Reque
 		return
 	}
 	path := getPathFromRequest(r)
+	record := &FileTransferRecord{
+		Direction:   fileTransferDownload,
+		FileName:    path,
+		UserSession: apiutil.GetRequestUserSession(r),
+		Request:     r,
+	}
+	if !tmpl.FileDownloadEnabled() {
+		d.denyFileTransfer(w, record, errors.New("file downloads are not allowed from this desktop"))
+		return
+	}
+
+	proxy, err := d.getProxyClientForRequest(r)
+	if err != nil {
+		if client.IgnoreNotFound(err) == nil {
+			apiutil.ReturnAPINotFound(err, w)
+			return
+		}
+		apiutil.ReturnAPIError(err, w)
+		return
+	}
 	res, err := proxy.GetFile(&proxyproto.FGetRequest{
 		Path: path,
 	})
@@ -146,6 +167,13 @@ func (d *desktopAPI) GetDownloadDesktopFile(w http.ResponseWriter, r *http.Reque
 	}
 	defer res.Body.Close()
 
+	record.FileSize = res.Size
+	reservation, err := d.reserveFileTransfer(tmpl, record.UserSession.User.Name, res.Name, res.Type, res.Size)
+	if err != nil {
+		d.denyFileTransfer(w, record, err)
+		return
+	}
+
 	fileSizeStr := strconv.FormatInt(res.Size, 10)
 
 	w.Header().Set("Content-Length", fileSizeStr)
@@ -156,9 +184,11 @@ func (d *desktopAPI) GetDownloadDesktopFile(w http.ResponseWriter, r *http.Reque
 	w.WriteHeader(http.StatusOK)
 
 	// Copy the file contents to the response
-	if _, err := io.Copy(w, res.Body); err != nil {
+	body := newHashingReadCloser(res.Body)
+	if _, err := io.Copy(w, body); err != nil {
 		apiLogger.Error(err, "Failed to copy file contents to response buffer")
 	}
+	d.completeFileTransfer(record, reservation, body)
 }
 
 func getPathFromRequest(r *http.Request) string {
@@ -0,0 +1,57 @@
+/*
+
+Copyright 2020,2021 Avi Zimmerman
+
+This file is part of kvdi.
+
+kvdi is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+kvdi is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package api
+
+import (
+	"net/http"
+
+	"github.com/go-jose/go-jose/v3"
+
+	"github.com/kvdi/kvdi/pkg/util/apiutil"
+)
+
+// swagger:operation GET /api/.well-known/jwks.json Auth getJWKS
+// ---
+// summary: Retrieve the public keys for verifying access tokens issued by kVDI.
+// description: Tokens carry the ID of the key they were signed with in their kid header.
+//
+// responses:
+//
+//	"200":
+//	  "$ref": "#/responses/jwksResponse"
+//	"500":
+//	  "$ref": "#/responses/error"
+func (d *desktopAPI) GetJWKS(w http.ResponseWriter, r *http.Request) {
+	jwks, err := d.signing.JWKS()
+	if err != nil {
+		apiutil.ReturnAPIError(err, w)
+		return
+	}
+	apiutil.WriteJSON(jwks, w)
+}
+
+// JSON Web Key Set response
+// swagger:response jwksResponse
+type swaggerJWKSResponse struct {
+	// in:body
+	Body jose.JSONWebKeySet
+}
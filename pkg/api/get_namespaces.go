@@ -43,8 +43,7 @@ func (d *desktopAPI) GetNamespaces(w http.ResponseWriter, r *http.Request) {
 		apiutil.ReturnAPIError(err, w)
 		return
 	}
-	rbac.FilterUserNamespaces(sess.User, namespaces)
-	apiutil.WriteJSON(rbac.FilterUserNamespaces(sess.User, namespaces), w)
+	apiutil.WriteJSON(rbac.FilterUserNamespaces(sess.User, namespaces, apiutil.GetRequestActionContext(r)), w)
 }
 
 // ListKubernetesNamespaces returns a string slice of all the namespaces
@@ -21,7 +21,11 @@ package api
 
 import (
 	"net/http"
+	"strings"
 
+	appv1 "github.com/kvdi/kvdi/apis/app/v1"
+	"github.com/kvdi/kvdi/pkg/auth/apitokens"
+	"github.com/kvdi/kvdi/pkg/auth/common"
 	"github.com/kvdi/kvdi/pkg/types"
 	"github.com/kvdi/kvdi/pkg/util/apiutil"
 	"github.com/kvdi/kvdi/pkg/util/errors"
@@ -36,9 +40,9 @@ import (
 //	403: error
 //	500: error
 func (d *desktopAPI) GetRefreshToken(w http.ResponseWriter, r *http.Request) {
-
-	if d.vdiCluster.IsUsingOIDCAuth() {
-		apiutil.ReturnAPIError(errors.New("Token has expired and cannot be refreshed due to OIDC auth"), w)
+	// API tokens cannot be exchanged for a login session
+	if strings.HasPrefix(r.Header.Get(TokenHeader), apitokens.TokenPrefix) {
+		apiutil.ReturnAPIForbidden(nil, "API tokens cannot be used to refresh a session", w)
 		return
 	}
 
@@ -52,20 +56,47 @@ func (d *desktopAPI) GetRefreshToken(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	username, err := d.lookupRefreshToken(refreshToken.Value)
+	login, err := d.logins.ConsumeRefreshToken(refreshToken.Value)
 	if err != nil {
 		apiutil.ReturnAPIError(err, w)
 		return
 	}
+	username := login.User
 
-	// retrieve the user from the auth provider
-	user, err := d.auth.GetUser(username)
-	if err != nil {
-		apiutil.ReturnAPIError(err, w)
+	var result *types.AuthResult
+	switch d.getUserAuthMethod(username) {
+	case appv1.AuthMethodOIDC:
+		// re-derive the user and their roles from the identity provider
+		provider, ok := d.auth.(common.RefreshProvider)
+		if !ok {
+			apiutil.ReturnAPIError(errors.New("Token has expired and cannot be refreshed due to OIDC auth"), w)
+			return
+		}
+		result, err = provider.Refresh(username, login.ID)
+		if err != nil {
+			apiutil.ReturnAPIForbidden(err, "Could not refresh the session with the OIDC provider", w)
+			return
+		}
+	case appv1.AuthMethodSAML:
+		apiutil.ReturnAPIError(errors.New("Token has expired and cannot be refreshed due to SAML auth"), w)
+		return
+	default:
+		// retrieve the user from the auth provider
+		user, err := d.auth.GetUser(username)
+		if err != nil {
+			apiutil.ReturnAPIError(err, w)
+			return
+		}
+		result = &types.AuthResult{User: user}
+	}
+
+	// Apply the user provisioned over SCIM, if any
+	if err := d.applyProvisionedUser(result.User); err != nil {
+		apiutil.ReturnAPIForbidden(err, err.Error(), w)
 		return
 	}
 
-	// return a new access and refresh token for the user
+	// return a new access and refresh token for the user, continuing their login
 	// TODO: Use state during a refresh?
-	d.returnNewJWT(w, &types.AuthResult{User: user}, true, "")
+	d.returnNewJWT(w, r, result, true, "", login)
 }
@@ -0,0 +1,60 @@
+/*
+
+Copyright 2020,2021 Avi Zimmerman
+
+This file is part of kvdi.
+
+kvdi is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+kvdi is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package api
+
+import (
+	"net/http"
+
+	appv1 "github.com/kvdi/kvdi/apis/app/v1"
+	"github.com/kvdi/kvdi/pkg/auth/common"
+	"github.com/kvdi/kvdi/pkg/util/apiutil"
+	"github.com/kvdi/kvdi/pkg/util/errors"
+)
+
+// swagger:operation GET /api/saml/metadata Auth getSAMLMetadata
+// ---
+// summary: Retrieve the SAML service provider metadata for registering kVDI with an identity provider.
+// produces:
+//   - application/samlmetadata+xml
+//
+// responses:
+//
+//	"200":
+//	  description: The service provider metadata document
+//	"404":
+//	  "$ref": "#/responses/error"
+func (d *desktopAPI) GetSAMLMetadata(w http.ResponseWriter, r *http.Request) {
+	provider, ok := d.auth.(common.MetadataProvider)
+	if !ok || !d.vdiCluster.IsAuthMethodEnabled(appv1.AuthMethodSAML) {
+		apiutil.ReturnAPINotFound(errors.New("SAML authentication is not configured"), w)
+		return
+	}
+	contentType, body, err := provider.Metadata()
+	if err != nil {
+		apiutil.ReturnAPIError(err, w)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	if _, err := w.Write(body); err != nil {
+		apiLogger.Error(err, "Failed to write SAML metadata response")
+	}
+}
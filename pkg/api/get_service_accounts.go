@@ -56,7 +56,7 @@ func (d *desktopAPI) GetServiceAccounts(w http.ResponseWriter, r *http.Request)
 		apiutil.ReturnAPIError(err, w)
 		return
 	}
-	apiutil.WriteJSON(rbac.FilterUserServiceAccounts(sess.User, serviceAccounts, namespace), w)
+	apiutil.WriteJSON(rbac.FilterUserServiceAccounts(sess.User, serviceAccounts, namespace, apiutil.GetRequestActionContext(r)), w)
 }
 
 // ListServiceAccounts returns a string slice of all the service accounts
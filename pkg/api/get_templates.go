@@ -46,7 +46,7 @@ func (d *desktopAPI) GetDesktopTemplates(w http.ResponseWriter, r *http.Request)
 		apiutil.ReturnAPIError(err, w)
 		return
 	}
-	apiutil.WriteJSON(rbac.FilterTemplates(sess.User, tmpls.Trim()), w)
+	apiutil.WriteJSON(rbac.FilterTemplates(sess.User, tmpls.Trim(), apiutil.GetRequestActionContext(r)), w)
 }
 
 // getAllDesktopTemplates lists the DesktopTemplates registered in the api servers.
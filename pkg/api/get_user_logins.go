@@ -0,0 +1,63 @@
+/*
+
+Copyright 2020,2021 Avi Zimmerman
+
+This file is part of kvdi.
+
+kvdi is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+kvdi is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package api
+
+import (
+	"net/http"
+
+	"github.com/kvdi/kvdi/pkg/types"
+	"github.com/kvdi/kvdi/pkg/util/apiutil"
+)
+
+// swagger:operation GET /api/users/{user}/logins Users getUserLoginsRequest
+// ---
+// summary: Retrieves the active logins for the given user.
+// parameters:
+//   - name: user
+//     in: path
+//     description: The user to query
+//     type: string
+//     required: true
+//
+// responses:
+//
+//	"200":
+//	  "$ref": "#/responses/getLoginsResponse"
+//	"400":
+//	  "$ref": "#/responses/error"
+//	"403":
+//	  "$ref": "#/responses/error"
+func (d *desktopAPI) GetUserLogins(w http.ResponseWriter, r *http.Request) {
+	logins, err := d.logins.ListLogins(apiutil.GetUserFromRequest(r))
+	if err != nil {
+		apiutil.ReturnAPIError(err, w)
+		return
+	}
+	apiutil.WriteJSON(logins, w)
+}
+
+// Logins response
+// swagger:response getLoginsResponse
+type swaggerGetLoginsResponse struct {
+	// in:body
+	Body []types.UserLogin
+}
@@ -52,11 +52,19 @@ import (
 func (d *desktopAPI) GetUserMFA(w http.ResponseWriter, r *http.Request) {
 	username := apiutil.GetUserFromRequest(r)
 
+	status, err := d.mfa.GetUserStatus(username)
+	if err != nil {
+		apiutil.ReturnAPIError(err, w)
+		return
+	}
+
 	secret, verified, err := d.mfa.GetUserMFAStatus(username)
 	if err != nil {
 		if errors.IsUserNotFoundError(err) {
 			apiutil.WriteJSON(&types.MFAResponse{
-				Enabled: false,
+				Enabled:                false,
+				Methods:                status.Methods,
+				RecoveryCodesRemaining: status.RecoveryCodesRemaining,
 			}, w)
 			return
 		}
@@ -65,9 +73,11 @@ func (d *desktopAPI) GetUserMFA(w http.ResponseWriter, r *http.Request) {
 	}
 
 	apiutil.WriteJSON(&types.MFAResponse{
-		Enabled:         true,
-		Verified:        verified,
-		ProvisioningURI: gotp.NewDefaultTOTP(secret).ProvisioningUri(username, "kVDI"),
+		Enabled:                true,
+		Verified:               verified,
+		ProvisioningURI:        gotp.NewDefaultTOTP(secret).ProvisioningUri(username, "kVDI"),
+		Methods:                status.Methods,
+		RecoveryCodesRemaining: status.RecoveryCodesRemaining,
 	}, w)
 }
 
@@ -0,0 +1,63 @@
+/*
+
+Copyright 2020,2021 Avi Zimmerman
+
+This file is part of kvdi.
+
+kvdi is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+kvdi is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package api
+
+import (
+	"net/http"
+
+	"github.com/kvdi/kvdi/pkg/types"
+	"github.com/kvdi/kvdi/pkg/util/apiutil"
+)
+
+// swagger:operation GET /api/users/{user}/mfa/webauthn Users getUserWebAuthnRequest
+// ---
+// summary: Retrieves the WebAuthn credentials registered for the given user.
+// parameters:
+//   - name: user
+//     in: path
+//     description: The user to query
+//     type: string
+//     required: true
+//
+// responses:
+//
+//	"200":
+//	  "$ref": "#/responses/getWebAuthnCredentialsResponse"
+//	"400":
+//	  "$ref": "#/responses/error"
+//	"403":
+//	  "$ref": "#/responses/error"
+func (d *desktopAPI) GetUserWebAuthn(w http.ResponseWriter, r *http.Request) {
+	creds, err := d.mfa.GetWebAuthnCredentials(apiutil.GetUserFromRequest(r))
+	if err != nil {
+		apiutil.ReturnAPIError(err, w)
+		return
+	}
+	apiutil.WriteJSON(creds, w)
+}
+
+// WebAuthn credentials response
+// swagger:response getWebAuthnCredentialsResponse
+type swaggerGetWebAuthnCredentialsResponse struct {
+	// in:body
+	Body []types.WebAuthnCredential
+}
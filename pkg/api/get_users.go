@@ -21,6 +21,7 @@ package api
 
 import (
 	"net/http"
+	"time"
 
 	"github.com/kvdi/kvdi/pkg/types"
 	"github.com/kvdi/kvdi/pkg/util/apiutil"
@@ -40,17 +41,26 @@ func (d *desktopAPI) GetUsers(w http.ResponseWriter, r *http.Request) {
 		apiutil.ReturnAPIError(err, w)
 		return
 	}
-	mfaUsers, err := d.mfa.GetMFAUsers()
+	mfaUsers, err := d.mfa.GetUsersStatus()
 	if err != nil {
 		apiutil.ReturnAPIError(err, w)
 		return
 	}
+	locked, err := d.lockout.GetLockedUsers()
+	if err != nil {
+		apiutil.ReturnAPIError(err, w)
+		return
+	}
+	lockedUntil := make(map[string]time.Time, len(locked))
+	for _, lock := range locked {
+		lockedUntil[lock.Name] = lock.Until
+	}
 	for _, user := range users {
-		if verified, ok := mfaUsers[user.Name]; ok {
-			user.MFA = &types.UserMFAStatus{
-				Enabled:  true,
-				Verified: verified,
-			}
+		if until, ok := lockedUntil[user.Name]; ok {
+			user.LockedUntil = &until
+		}
+		if status, ok := mfaUsers[user.Name]; ok {
+			user.MFA = status
 		} else {
 			user.MFA = &types.UserMFAStatus{
 				Enabled: false,
@@ -92,19 +102,19 @@ func (d *desktopAPI) GetUser(w http.ResponseWriter, r *http.Request) {
 		apiutil.ReturnAPIError(err, w)
 		return
 	}
-	if _, verified, err := d.mfa.GetUserMFAStatus(username); err != nil {
-		if !errors.IsUserNotFoundError(err) {
-			apiutil.ReturnAPIError(err, w)
-			return
-		}
-		user.MFA = &types.UserMFAStatus{
-			Enabled: false,
-		}
-	} else if err == nil {
-		user.MFA = &types.UserMFAStatus{
-			Enabled:  true,
-			Verified: verified,
-		}
+	status, err := d.mfa.GetUserStatus(username)
+	if err != nil {
+		apiutil.ReturnAPIError(err, w)
+		return
+	}
+	user.MFA = status
+	lock, err := d.lockout.Check(username, "")
+	if err != nil {
+		apiutil.ReturnAPIError(err, w)
+		return
+	}
+	if lock != nil {
+		user.LockedUntil = &lock.Until
 	}
 	apiutil.WriteJSON(user, w)
 }
@@ -24,15 +24,22 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	v1 "github.com/kvdi/kvdi/apis/meta/v1"
+	rbacv1 "github.com/kvdi/kvdi/apis/rbac/v1"
+	"github.com/kvdi/kvdi/pkg/audio"
 	"github.com/kvdi/kvdi/pkg/proxyproto"
+	"github.com/kvdi/kvdi/pkg/types"
 	"github.com/kvdi/kvdi/pkg/util/apiutil"
 	"github.com/kvdi/kvdi/pkg/util/lock"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
 	"github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/time/rate"
 )
 
 // swagger:operation GET /api/desktops/ws/{namespace}/{name}/display Desktops doWebsocket
@@ -71,7 +78,7 @@ func (d *desktopAPI) GetWebsockify(w http.ResponseWriter, r *http.Request) {
 		strings.Replace(apiutil.GetNamespacedNameFromRequest(r).String(), "/", "-", -1),
 	)
 	labels := d.vdiCluster.GetComponentLabels("display-lock")
-	labels[v1.ClientAddrLabel] = strings.Split(r.RemoteAddr, ":")[0] // Populated by ProxyHeaders handler wrapping the router
+	labels[v1.ClientAddrLabel] = strings.Split(r.RemoteAddr, ":")[0] // Resolved from trusted proxy headers by the API router
 	sessionLock := lock.New(d.client, lockName, -1).WithLabels(labels)
 
 	if err := sessionLock.Acquire(); err != nil {
@@ -107,6 +114,21 @@ func (d *desktopAPI) GetWebsockify(w http.ResponseWriter, r *http.Request) {
 //     description: The X-Session-Token of the requesting client. Can also be provided in the header.
 //     type: string
 //     required: false
+//   - name: codec
+//     in: query
+//     description: The codec to encode playback with. One of pcm, opus-webm, opus-ogg, or opus. Defaults to opus-webm.
+//     type: string
+//     required: false
+//   - name: bitrate
+//     in: query
+//     description: The bitrate in bits per second to use for Opus codecs.
+//     type: integer
+//     required: false
+//   - name: mode
+//     in: query
+//     description: The direction of the stream. One of both, playback, or capture. Defaults to both. When microphone capture is denied, both is downgraded to playback.
+//     type: string
+//     required: false
 //
 // responses:
 //
@@ -123,7 +145,7 @@ func (d *desktopAPI) GetWebsockifyAudio(w http.ResponseWriter, r *http.Request)
 		strings.Replace(apiutil.GetNamespacedNameFromRequest(r).String(), "/", "-", -1),
 	)
 	labels := d.vdiCluster.GetComponentLabels("audio-lock")
-	labels[v1.ClientAddrLabel] = strings.Split(r.RemoteAddr, ":")[0] // Populated by ProxyHeaders handler wrapping the router
+	labels[v1.ClientAddrLabel] = strings.Split(r.RemoteAddr, ":")[0] // Resolved from trusted proxy headers by the API router
 	sessionLock := lock.New(d.client, lockName, -1).WithLabels(labels)
 
 	if err := sessionLock.Acquire(); err != nil {
@@ -159,6 +181,13 @@ func (d *desktopAPI) ServeWebsocketProxy(w http.ResponseWriter, r *http.Request,
 		return
 	}
 
+	sess := apiutil.GetRequestUserSession(r)
+	limits, err := d.getBandwidthLimits(r, sess)
+	if err != nil {
+		apiutil.ReturnAPIError(err, w)
+		return
+	}
+
 	apiLogger.Info("Connecting to desktop proxy", "Path", r.URL.Path)
 
 	var conn *proxyproto.Conn
@@ -166,7 +195,21 @@ func (d *desktopAPI) ServeWebsocketProxy(w http.ResponseWriter, r *http.Request,
 	case proxyproto.RequestTypeDisplay:
 		conn, err = proxy.DisplayProxy()
 	case proxyproto.RequestTypeAudio:
-		conn, err = proxy.AudioProxy()
+		var captureAllowed bool
+		if captureAllowed, err = d.audioCaptureAllowed(r, sess); err != nil {
+			apiutil.ReturnAPIError(err, w)
+			return
+		}
+		var req *proxyproto.AudioRequest
+		if req, err = audioRequestFromQuery(r, captureAllowed); err != nil {
+			if err == audio.ErrCaptureNotAllowed {
+				apiutil.ReturnAPIForbidden(err, err.Error(), w)
+				return
+			}
+			apiutil.ReturnAPIError(err, w)
+			return
+		}
+		conn, err = proxy.AudioProxy(req)
 	}
 	if err != nil {
 		apiLogger.Error(err, "Error creating connection to proxy server")
@@ -185,11 +228,20 @@ func (d *desktopAPI) ServeWebsocketProxy(w http.ResponseWriter, r *http.Request,
 
 	client := apiutil.NewGorillaReadWriter(wsconn)
 	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Wrap both ends of the stream in any configured bandwidth limits
+	var clientWriter, proxyWriter io.Writer = client, conn
+	if limits != nil {
+		var release func()
+		clientWriter, proxyWriter, release = d.throttleStreams(ctx, r, sess, rt, limits, client, conn)
+		defer release()
+	}
 
 	// Copy client connection to server
 	go func() {
 		defer cancel()
-		if _, err := io.Copy(conn, client); err != nil {
+		if _, err := io.Copy(proxyWriter, client); err != nil {
 			apiLogger.Error(err, "Error while copying stream from websocket connection to proxy")
 		}
 	}()
@@ -197,7 +249,7 @@ func (d *desktopAPI) ServeWebsocketProxy(w http.ResponseWriter, r *http.Request,
 	// Copy server connection to the client
 	go func() {
 		defer cancel()
-		if _, err := io.Copy(client, conn); err != nil {
+		if _, err := io.Copy(clientWriter, conn); err != nil {
 			apiLogger.Error(err, "Error while copying stream from proxy to websocket connection")
 		}
 	}()
@@ -206,3 +258,104 @@ func (d *desktopAPI) ServeWebsocketProxy(w http.ResponseWriter, r *http.Request,
 	for range ctx.Done() {
 	}
 }
+
+// audioRequestFromQuery builds the parameters for an audio stream from the query
+// of the given request. When capture is not allowed, the requested mode is downgraded
+// to playback, or audio.ErrCaptureNotAllowed is returned if only capture was requested.
+// No request is returned when only the defaults are requested.
+func audioRequestFromQuery(r *http.Request, captureAllowed bool) (*proxyproto.AudioRequest, error) {
+	codec, err := audio.ParseCodec(r.URL.Query().Get("codec"))
+	if err != nil {
+		return nil, err
+	}
+	var bitrate int
+	if bitrateStr := r.URL.Query().Get("bitrate"); bitrateStr != "" {
+		if bitrate, err = strconv.Atoi(bitrateStr); err != nil {
+			return nil, fmt.Errorf("invalid bitrate: %s", bitrateStr)
+		}
+	}
+	if err := codec.ValidateBitrate(bitrate); err != nil {
+		return nil, err
+	}
+	mode, err := audio.ParseMode(r.URL.Query().Get("mode"))
+	if err != nil {
+		return nil, err
+	}
+	if !captureAllowed && mode.Capture() {
+		if mode, err = mode.WithoutCapture(); err != nil {
+			return nil, err
+		}
+	}
+	if codec == audio.DefaultCodec && bitrate == 0 && mode == audio.DefaultMode {
+		// served by all proxies, including ones that predate audio options
+		return nil, nil
+	}
+	return &proxyproto.AudioRequest{
+		Codec:   string(codec),
+		Bitrate: int64(bitrate),
+		Mode:    string(mode),
+	}, nil
+}
+
+// audioCaptureAllowed returns whether the user in the given session may stream their
+// microphone to the desktop in the request. Capture is denied if the template or any
+// of the user's roles deny it.
+func (d *desktopAPI) audioCaptureAllowed(r *http.Request, sess *types.JWTClaims) (bool, error) {
+	tmpl, err := d.getTemplateForRequest(r)
+	if err != nil {
+		return false, err
+	}
+	if !tmpl.AudioCaptureAllowed() {
+		return false, nil
+	}
+	roles, err := d.vdiCluster.GetRoles(d.client)
+	if err != nil {
+		return false, err
+	}
+	for _, userRole := range sess.User.Roles {
+		for _, role := range roles {
+			if role.GetName() == userRole.GetName() && role.AudioCaptureDenied() {
+				return false, nil
+			}
+		}
+	}
+	return true, nil
+}
+
+// throttleStreams wraps the client and proxy ends of a stream in the given bandwidth limits.
+// The returned function must be called when the stream is finished to release the user's
+// shared limiter. Audio streams are charged against the user limiter, but never wait on it,
+// giving them priority over display streams.
+func (d *desktopAPI) throttleStreams(ctx context.Context, r *http.Request, sess *types.JWTClaims, rt proxyproto.RequestType, limits *rbacv1.BandwidthLimits, client, conn io.Writer) (clientWriter, proxyWriter io.Writer, release func()) {
+	var connLimiter *rate.Limiter
+	if limits.PerConnectionBytesPerSecond > 0 {
+		connLimiter = rate.NewLimiter(
+			rate.Limit(limits.PerConnectionBytesPerSecond),
+			int(limits.GetBurst(limits.PerConnectionBytesPerSecond)),
+		)
+	}
+	var userLimiter *rate.Limiter
+	release = func() {}
+	if limits.PerUserBytesPerSecond > 0 {
+		userLimiter, release = d.bandwidth.acquire(sess.User.Name, limits)
+	}
+
+	labels := prometheus.Labels{
+		"desktop": apiutil.GetNamespacedNameFromRequest(r).String(),
+		"stream":  rt.String(),
+	}
+	onThrottle := func(delay time.Duration) {
+		streamThrottlesTotal.With(labels).Inc()
+		streamThrottledSeconds.With(labels).Add(delay.Seconds())
+	}
+
+	wrap := func(w io.Writer) io.Writer {
+		tw := apiutil.NewThrottledWriter(ctx, w).WithLimiters(connLimiter).OnThrottle(onThrottle)
+		if rt == proxyproto.RequestTypeAudio {
+			return tw.WithPriorityLimiters(userLimiter)
+		}
+		return tw.WithLimiters(userLimiter)
+	}
+
+	return wrap(client), wrap(conn), release
+}
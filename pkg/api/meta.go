@@ -21,6 +21,8 @@ along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
 //
 // The purpose of this API is to provide resources to the user frontend of kVDI, however it can also be used for programatic management of the cluster.
 //
+// Authenticated requests may set the `Impersonate-User` header to be evaluated as another user. This requires the `impersonate` verb on that user.
+//
 //	Schemes: https
 //	BasePath: /
 //	License: GNU GENERAL PUBLIC LICENSE Version 3, 29 June 2007
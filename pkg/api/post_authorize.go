@@ -30,7 +30,7 @@ import (
 )
 
 // swagger:route POST /api/authorize Auth authorizeRequest
-// Authorizes a JWT token with a one time password.
+// Authorizes a JWT token with a one time password, a WebAuthn assertion, or a recovery code.
 // responses:
 //
 //	200: sessionResponse
@@ -38,6 +38,10 @@ import (
 //	403: error
 func (d *desktopAPI) PostAuthorize(w http.ResponseWriter, r *http.Request) {
 	userSession := apiutil.GetRequestUserSession(r)
+	if userSession.APITokenID != "" {
+		apiutil.ReturnAPIForbidden(nil, "API tokens cannot be used to authorize a session", w)
+		return
+	}
 
 	// retrieve the OTP from the request
 	req := apiutil.GetRequestObject(r).(*types.AuthorizeRequest)
@@ -46,18 +50,65 @@ func (d *desktopAPI) PostAuthorize(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Failed codes are counted against the user and source address the same way
+	// failed passwords are.
+	if d.checkLoginLockout(w, r, userSession.User.Name) {
+		return
+	}
+
+	status, err := d.mfa.GetUserStatus(userSession.User.Name)
+	if err != nil {
+		apiutil.ReturnAPIError(err, w)
+		return
+	}
+
+	if status.EnrollmentRequired {
+		// The user's MFA was reset and they have not enrolled a new method yet
+		apiutil.ReturnAPIForbidden(nil, "MFA enrollment is required before the session can be authorized", w)
+		return
+	}
+
+	if req.WebAuthn != nil {
+		if err := d.mfa.FinishWebAuthnLogin(userSession.User.Name, req.WebAuthn); err != nil {
+			d.recordLoginFailure(r, userSession.User.Name)
+			apiutil.ReturnAPIForbidden(err, "Invalid WebAuthn assertion", w)
+			return
+		}
+		d.returnAuthorizedJWT(w, r, userSession, status, req.GetState())
+		return
+	}
+
+	if req.RecoveryCode != "" {
+		if !status.Verified {
+			apiutil.ReturnAPIForbidden(nil, "MFA has not been verified", w)
+			return
+		}
+		remaining, err := d.mfa.UseRecoveryCode(userSession.User.Name, req.RecoveryCode)
+		if err != nil {
+			d.recordLoginFailure(r, userSession.User.Name)
+			apiutil.ReturnAPIForbidden(err, "Invalid recovery code", w)
+			return
+		}
+		d.auditUserEvent(mfaEventRecoveryCodeUsed, userSession.User.Name, userSession.User.Name, r)
+		status.RecoveryCodesRemaining = remaining
+		d.returnAuthorizedJWT(w, r, userSession, status, req.GetState())
+		return
+	}
+
 	secret, verified, err := d.mfa.GetUserMFAStatus(userSession.User.Name)
 	if err != nil {
 		if !errors.IsUserNotFoundError(err) {
 			apiutil.ReturnAPIError(err, w)
 			return
 		}
+		// The user may only have WebAuthn credentials
+		if status.Verified {
+			apiutil.ReturnAPIForbidden(nil, "A WebAuthn assertion is required", w)
+			return
+		}
 		// The user does not require MFA - this shouldn't happen but go ahead
 		// and send back an authorized token
-		d.returnNewJWT(w, &types.AuthResult{
-			User:                userSession.User,
-			RefreshNotSupported: !userSession.Renewable,
-		}, true, req.GetState())
+		d.returnAuthorizedJWT(w, r, userSession, status, req.GetState())
 		return
 	}
 
@@ -71,17 +122,34 @@ func (d *desktopAPI) PostAuthorize(w http.ResponseWriter, r *http.Request) {
 	totp := gotp.NewDefaultTOTP(secret)
 
 	if totp.Now() != req.GetOTP() {
+		d.recordLoginFailure(r, userSession.User.Name)
 		apiutil.ReturnAPIForbidden(nil, "Invalid MFA Code", w)
 		return
 	}
 
-	d.returnNewJWT(w, &types.AuthResult{
+	d.returnAuthorizedJWT(w, r, userSession, status, req.GetState())
+}
+
+// returnAuthorizedJWT returns a new authorized token for the user in the given session
+// with their current MFA status. If the user's password has expired, the token is left
+// unauthorized without an MFA status, which only allows them to change their password.
+func (d *desktopAPI) returnAuthorizedJWT(w http.ResponseWriter, r *http.Request, userSession *types.JWTClaims, status *types.UserMFAStatus, state string) {
+	result := &types.AuthResult{
 		User:                userSession.User,
 		RefreshNotSupported: !userSession.Renewable,
-	}, true, req.GetState())
+		LoginID:             userSession.LoginID,
+	}
+	d.recordLoginSuccess(userSession.User.Name)
+	if userSession.User.PasswordChangeRequired {
+		userSession.User.MFA = nil
+		d.returnNewJWT(w, r, result, false, state, nil)
+		return
+	}
+	userSession.User.MFA = status
+	d.returnNewJWT(w, r, result, true, state, nil)
 }
 
-// Request containing a one-time password.
+// Request containing a one-time password, WebAuthn assertion, or recovery code.
 // swagger:parameters authorizeRequest
 type swaggerAuthorizeRequest struct {
 	// in:body
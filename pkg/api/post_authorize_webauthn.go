@@ -0,0 +1,63 @@
+/*
+
+Copyright 2020,2021 Avi Zimmerman
+
+This file is part of kvdi.
+
+kvdi is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+kvdi is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package api
+
+import (
+	"net/http"
+
+	"github.com/kvdi/kvdi/pkg/types"
+	"github.com/kvdi/kvdi/pkg/util/apiutil"
+	"github.com/kvdi/kvdi/pkg/util/errors"
+)
+
+// swagger:route POST /api/authorize/webauthn Auth authorizeWebAuthnRequest
+// Starts a WebAuthn assertion for authorizing a JWT token. The result is sent to /api/authorize.
+// responses:
+//
+//	200: webAuthnRequestOptionsResponse
+//	400: error
+//	403: error
+//	404: error
+func (d *desktopAPI) PostAuthorizeWebAuthn(w http.ResponseWriter, r *http.Request) {
+	userSession := apiutil.GetRequestUserSession(r)
+	if userSession.APITokenID != "" {
+		apiutil.ReturnAPIForbidden(nil, "API tokens cannot be used to authorize a session", w)
+		return
+	}
+	opts, err := d.mfa.BeginWebAuthnLogin(userSession.User.Name, d.webauthnRelyingParty())
+	if err != nil {
+		if errors.IsUserNotFoundError(err) {
+			apiutil.ReturnAPINotFound(err, w)
+			return
+		}
+		apiutil.ReturnAPIError(err, w)
+		return
+	}
+	apiutil.WriteJSON(opts, w)
+}
+
+// WebAuthn assertion options
+// swagger:response webAuthnRequestOptionsResponse
+type swaggerWebAuthnRequestOptionsResponse struct {
+	// in:body
+	Body types.WebAuthnRequestOptions
+}
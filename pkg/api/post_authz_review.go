@@ -0,0 +1,95 @@
+/*
+
+Copyright 2020,2021 Avi Zimmerman
+
+This file is part of kvdi.
+
+kvdi is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+kvdi is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package api
+
+import (
+	"net/http"
+
+	"github.com/kvdi/kvdi/pkg/types"
+	"github.com/kvdi/kvdi/pkg/util/apiutil"
+	"github.com/kvdi/kvdi/pkg/util/errors"
+	"github.com/kvdi/kvdi/pkg/util/rbac"
+)
+
+// swagger:parameters postAuthzReviewRequest
+type swaggerAccessReviewRequest struct {
+	// in:body
+	Body types.AccessReviewRequest
+}
+
+// swagger:route POST /api/authz/review Miscellaneous postAuthzReviewRequest
+// Evaluates actions against the roles of a user and explains the decisions. Reviewing
+// another user requires the ability to read them.
+// responses:
+//
+//	200: accessReviewResponse
+//	400: error
+//	403: error
+//	404: error
+func (d *desktopAPI) PostAuthzReview(w http.ResponseWriter, r *http.Request) {
+	req := apiutil.GetRequestObject(r).(*types.AccessReviewRequest)
+	session := apiutil.GetRequestUserSession(r)
+
+	user := session.User
+	if req.User != "" && req.User != user.Name {
+		var err error
+		user, err = d.auth.GetUser(req.User)
+		if err != nil {
+			if errors.IsUserNotFoundError(err) {
+				apiutil.ReturnAPINotFound(err, w)
+				return
+			}
+			apiutil.ReturnAPIError(err, w)
+			return
+		}
+		if err := d.applyProvisionedUser(user); err != nil {
+			apiutil.ReturnAPIError(err, w)
+			return
+		}
+	}
+
+	// evaluate conditions for the requesting client unless told otherwise
+	ctx := apiutil.GetRequestActionContext(r)
+	if t := req.GetTime(); !t.IsZero() {
+		ctx.Time = t
+	}
+	if ip := req.GetSourceIP(); ip != nil {
+		ctx.SourceIP = ip
+	}
+
+	resp := &types.AccessReviewResponse{
+		User:    user.Name,
+		Results: make([]*types.AccessReviewResult, len(req.Actions)),
+	}
+	for idx, action := range req.Actions {
+		action.Context = ctx
+		resp.Results[idx] = rbac.Review(user, action)
+	}
+	apiutil.WriteJSON(resp, w)
+}
+
+// Decisions for each action in an access review
+// swagger:response accessReviewResponse
+type swaggerAccessReviewResponse struct {
+	// in:body
+	Body types.AccessReviewResponse
+}
@@ -22,6 +22,7 @@ package api
 import (
 	"net/http"
 
+	appv1 "github.com/kvdi/kvdi/apis/app/v1"
 	"github.com/kvdi/kvdi/pkg/types"
 	"github.com/kvdi/kvdi/pkg/util/apiutil"
 	"github.com/kvdi/kvdi/pkg/util/errors"
@@ -32,6 +33,7 @@ const userAnonymous = "anonymous"
 
 // swagger:route POST /api/login Auth loginRequest
 // Retrieves a new JWT token. This route may behave differently depending on the auth provider.
+// Too many failed attempts lock out the user or source address for a time.
 // responses:
 //
 //	200: sessionResponse
@@ -44,8 +46,8 @@ func (d *desktopAPI) PostLogin(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodGet {
 		// Create a login request to pass to the auth backend containing just the
 		// raw request object. The backend provider should know how to use it to
-		// return valid claims.
-		req := &types.LoginRequest{}
+		// return valid claims. OIDC is the only method that calls back here.
+		req := &types.LoginRequest{Method: string(appv1.AuthMethodOIDC)}
 		req.SetRequest(r)
 
 		// pass the request object to the auth backend, it should know how to handle a
@@ -75,6 +77,13 @@ func (d *desktopAPI) PostLogin(w http.ResponseWriter, r *http.Request) {
 	// is needed in the authentication flow.
 	req.SetRequest(r)
 
+	// Refuse the attempt outright if the user or source address has been locked
+	// out after too many failed logins.
+	username := d.getLoginUsername(req)
+	if d.checkLoginLockout(w, r, username) {
+		return
+	}
+
 	// Pass the request to the provider
 	result, err := d.auth.Authenticate(req)
 	if err != nil {
@@ -87,9 +96,10 @@ func (d *desktopAPI) PostLogin(w http.ResponseWriter, r *http.Request) {
 					Roles: []*types.VDIUserRole{rbac.VDIRoleToUserRole(d.vdiCluster.GetLaunchTemplatesRole())},
 				},
 			}
-			d.returnNewJWT(w, result, true, req.GetState())
+			d.returnNewJWT(w, r, result, true, req.GetState(), nil)
 			return
 		}
+		d.recordLoginFailure(r, username)
 		// If it's not an actual credential error, it will still be logged server side,
 		// but always tell the user 'Invalid credentials'.
 		apiutil.ReturnAPIForbidden(err, "Invalid credentials", w)
@@ -106,24 +116,40 @@ func (d *desktopAPI) PostLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	d.checkMFAAndReturnJWT(w, result, req.GetState())
+	// Apply the user provisioned over SCIM, if any
+	if err := d.applyProvisionedUser(result.User); err != nil {
+		apiutil.ReturnAPIForbidden(err, err.Error(), w)
+		return
+	}
+
+	d.checkMFAAndReturnJWT(w, r, result, req.GetState())
 }
 
-func (d *desktopAPI) checkMFAAndReturnJWT(w http.ResponseWriter, result *types.AuthResult, state string) {
-	// check if MFA is configured for the user and that they have verified their secret
-	if _, verified, err := d.mfa.GetUserMFAStatus(result.User.Name); err != nil || !verified {
-		// Return any error that isn't a not found error
-		if err != nil && !errors.IsUserNotFoundError(err) {
-			apiutil.ReturnAPIError(err, w)
-			return
-		}
-		// The user does not require MFA
-		d.returnNewJWT(w, result, true, state)
+func (d *desktopAPI) checkMFAAndReturnJWT(w http.ResponseWriter, r *http.Request, result *types.AuthResult, state string) {
+	// check if MFA is configured for the user and that they have verified a method
+	status, err := d.mfa.GetUserStatus(result.User.Name)
+	if err != nil {
+		apiutil.ReturnAPIError(err, w)
+		return
+	}
+	if status.EnrollmentRequired {
+		// The user's MFA was reset and they must enroll again before the session
+		// can be authorized.
+		result.User.MFA = status
+		d.returnNewJWT(w, r, result, false, state, nil)
+		return
+	}
+	if !status.Verified {
+		// The user does not require MFA, but may still need to change their
+		// password before the session is authorized.
+		d.recordLoginSuccess(result.User.Name)
+		d.returnNewJWT(w, r, result, !result.User.PasswordChangeRequired, state, nil)
 		return
 	}
 
-	// the user requires MFA
-	d.returnNewJWT(w, result, false, state)
+	// the user requires MFA, let them know which methods they can use
+	result.User.MFA = status
+	d.returnNewJWT(w, r, result, false, state, nil)
 }
 
 // Login request
@@ -24,6 +24,7 @@ import (
 	"net/http"
 
 	desktopsv1 "github.com/kvdi/kvdi/apis/desktops/v1"
+	"github.com/kvdi/kvdi/pkg/auth/common"
 	"github.com/kvdi/kvdi/pkg/util/apiutil"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -31,7 +32,8 @@ import (
 )
 
 // swagger:route POST /api/logout Auth logout
-// Ends the current user session.
+// Ends the current user session. When the session must also be ended at an external
+// identity provider, the URL to visit is returned in the `X-Redirect` header.
 // responses:
 //
 //	200: boolResponse
@@ -46,8 +48,8 @@ func (d *desktopAPI) PostLogout(w http.ResponseWriter, r *http.Request) {
 	refreshToken, err := r.Cookie(RefreshTokenCookie)
 	if err == nil {
 		// Revoke the token and remove the cookie
-		// Lookup will fetch and clear the token from the db.
-		if _, err := d.lookupRefreshToken(refreshToken.Value); err != nil {
+		// Consuming the token clears it from the db.
+		if _, err := d.logins.ConsumeRefreshToken(refreshToken.Value); err != nil {
 			apiLogger.Error(err, "Error while revoking refresh token, garbage may be left in the db")
 		}
 		// Set the cookie to an empty value
@@ -58,6 +60,16 @@ func (d *desktopAPI) PostLogout(w http.ResponseWriter, r *http.Request) {
 			Secure:   true,
 		})
 	}
+	// End the session at the identity provider, if the provider supports it
+	if provider, ok := d.auth.(common.LogoutProvider); ok {
+		userSession := apiutil.GetRequestUserSession(r)
+		logoutURL, err := provider.Logout(userSession.GetRealUsername(), userSession.LoginID)
+		if err != nil {
+			apiLogger.Error(err, "Error while ending the session with the identity provider")
+		} else if logoutURL != "" {
+			w.Header().Set("X-Redirect", logoutURL)
+		}
+	}
 	apiutil.WriteOK(w)
 }
 
@@ -70,6 +70,8 @@ func (d *desktopAPI) newRoleFromRequest(req *types.CreateRoleRequest) *rbacv1.VD
 				v1.RoleClusterRefLabel: d.vdiCluster.GetName(),
 			},
 		},
-		Rules: req.GetRules(),
+		Rules:            req.GetRules(),
+		BandwidthLimits:  req.GetBandwidthLimits(),
+		DenyAudioCapture: req.DenyAudioCapture,
 	}
 }
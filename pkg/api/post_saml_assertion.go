@@ -0,0 +1,75 @@
+/*
+
+Copyright 2020,2021 Avi Zimmerman
+
+This file is part of kvdi.
+
+kvdi is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+kvdi is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package api
+
+import (
+	"net/http"
+
+	appv1 "github.com/kvdi/kvdi/apis/app/v1"
+	"github.com/kvdi/kvdi/pkg/types"
+	"github.com/kvdi/kvdi/pkg/util/apiutil"
+)
+
+// swagger:operation POST /api/saml/acs Auth postSAMLAssertion
+// ---
+// summary: The SAML assertion consumer service.
+// description: Receives responses from the identity provider over the HTTP-POST binding. The client is then redirected back to the UI to retrieve its token with the same state.
+// consumes:
+//   - application/x-www-form-urlencoded
+//
+// parameters:
+//   - name: SAMLResponse
+//     in: formData
+//     description: The base64 encoded response from the identity provider
+//     type: string
+//     required: true
+//   - name: RelayState
+//     in: formData
+//     description: The state provided by the client when it started the login
+//     type: string
+//     required: true
+//
+// responses:
+//
+//	"303":
+//	  description: Redirect back to the login page
+//	"400":
+//	  "$ref": "#/responses/error"
+func (d *desktopAPI) PostSAMLAssertion(w http.ResponseWriter, r *http.Request) {
+	// Create a login request with the relay state and the raw request object. The
+	// provider reads the response from the form and records claims for the state.
+	req := &types.LoginRequest{
+		State:  r.PostFormValue("RelayState"),
+		Method: string(appv1.AuthMethodSAML),
+	}
+	req.SetRequest(r)
+
+	if _, err := d.auth.Authenticate(req); err != nil {
+		apiLogger.Error(err, "Failure handling SAML response")
+		apiutil.ReturnAPIError(err, w)
+		return
+	}
+
+	// redirect back to home page. the ui knows to use it's existing state token
+	// and attempt anonymous login. The next POST should return the proper claims.
+	http.Redirect(w, r, "/#/login", http.StatusSeeOther)
+}
@@ -0,0 +1,139 @@
+/*
+
+Copyright 2020,2021 Avi Zimmerman
+
+This file is part of kvdi.
+
+kvdi is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+kvdi is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	appv1 "github.com/kvdi/kvdi/apis/app/v1"
+	"github.com/kvdi/kvdi/pkg/types"
+	"github.com/kvdi/kvdi/pkg/util/apiutil"
+	"github.com/kvdi/kvdi/pkg/util/errors"
+	"github.com/kvdi/kvdi/pkg/util/rbac"
+)
+
+// swagger:operation POST /api/users/{user}/tokens Users postUserAPITokenRequest
+// ---
+// summary: Creates a new personal API token for the given user.
+// description: The token is only returned in this response. When rules are provided the token is limited to those of the user's rules. Creating a token for another user requires holding all of their rules.
+// parameters:
+//   - name: user
+//     in: path
+//     description: The user to create the token for
+//     type: string
+//     required: true
+//   - in: body
+//     name: postUserAPITokenRequest
+//     description: The token to create
+//     schema:
+//     "$ref": "#/definitions/CreateAPITokenRequest"
+//
+// responses:
+//
+//	"200":
+//	  "$ref": "#/responses/postAPITokenResponse"
+//	"400":
+//	  "$ref": "#/responses/error"
+//	"403":
+//	  "$ref": "#/responses/error"
+//	"404":
+//	  "$ref": "#/responses/error"
+func (d *desktopAPI) PostUserAPIToken(w http.ResponseWriter, r *http.Request) {
+	session := apiutil.GetRequestUserSession(r)
+	if session.APITokenID != "" {
+		apiutil.ReturnAPIForbidden(nil, "API tokens cannot be used to create other API tokens", w)
+		return
+	}
+	// tokens are always authorized, so the session must have completed MFA and any
+	// required password change
+	if !session.Authorized || session.User.PasswordChangeRequired {
+		apiutil.ReturnAPIForbidden(nil, "User session is not authorized", w)
+		return
+	}
+
+	req := apiutil.GetRequestObject(r).(*types.CreateAPITokenRequest)
+	if req == nil {
+		apiutil.ReturnAPIError(errors.New("Malformed request"), w)
+		return
+	}
+
+	username := apiutil.GetUserFromRequest(r)
+
+	// Look up the user the token is for. Users of providers that cannot be queried
+	// can only create tokens for themselves.
+	user := session.User
+	if user.Name != username {
+		if method := d.getUserAuthMethod(username); method != appv1.AuthMethodLocal && method != appv1.AuthMethodLDAP {
+			apiutil.ReturnAPIError(fmt.Errorf("Tokens can only be created by %s users for themselves", method), w)
+			return
+		}
+		var err error
+		user, err = d.auth.GetUser(username)
+		if err != nil {
+			if errors.IsUserNotFoundError(err) {
+				apiutil.ReturnAPINotFound(err, w)
+				return
+			}
+			apiutil.ReturnAPIError(err, w)
+			return
+		}
+	}
+
+	// An empty list of rules means the token is not limited
+	rules := req.Rules
+	if len(rules) == 0 {
+		rules = nil
+	}
+	for _, rule := range rules {
+		if !rbac.UserIncludesRule(user, rule, NewResourceGetter(d)) {
+			apiutil.ReturnAPIForbidden(nil, fmt.Sprintf("%s does not have the permissions requested for the token", username), w)
+			return
+		}
+	}
+
+	ttl, maxTTL := req.GetTTL(), d.vdiCluster.GetMaxAPITokenTTL()
+	if ttl == 0 {
+		ttl = maxTTL
+	} else if ttl > maxTTL {
+		apiutil.ReturnAPIError(fmt.Errorf("The ttl for the token cannot be longer than %s", maxTTL), w)
+		return
+	}
+
+	roleNames := make([]string, len(user.Roles))
+	for i, role := range user.Roles {
+		roleNames[i] = role.Name
+	}
+	resp, err := d.apitokens.CreateToken(username, req.Name, rules, roleNames, ttl)
+	if err != nil {
+		apiutil.ReturnAPIError(err, w)
+		return
+	}
+	apiutil.WriteJSON(resp, w)
+}
+
+// Create API token response
+// swagger:response postAPITokenResponse
+type swaggerPostAPITokenResponse struct {
+	// in:body
+	Body types.CreateAPITokenResponse
+}
@@ -0,0 +1,83 @@
+/*
+
+Copyright 2020,2021 Avi Zimmerman
+
+This file is part of kvdi.
+
+kvdi is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+kvdi is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package api
+
+import (
+	"net/http"
+
+	"github.com/kvdi/kvdi/pkg/types"
+	"github.com/kvdi/kvdi/pkg/util/apiutil"
+	"github.com/kvdi/kvdi/pkg/util/errors"
+)
+
+// swagger:operation POST /api/users/{user}/mfa/recovery Users postUserMFARecoveryRequest
+// ---
+// summary: Generates new MFA recovery codes for the given user.
+// description: Any existing recovery codes for the user stop working. The new codes are only returned in this response.
+// parameters:
+//   - name: user
+//     in: path
+//     description: The user to generate recovery codes for
+//     type: string
+//     required: true
+//
+// responses:
+//
+//	"200":
+//	  "$ref": "#/responses/recoveryCodesResponse"
+//	"400":
+//	  "$ref": "#/responses/error"
+//	"403":
+//	  "$ref": "#/responses/error"
+func (d *desktopAPI) PostUserMFARecovery(w http.ResponseWriter, r *http.Request) {
+	username := apiutil.GetUserFromRequest(r)
+
+	status, err := d.mfa.GetUserStatus(username)
+	if err != nil {
+		apiutil.ReturnAPIError(err, w)
+		return
+	}
+	if !status.Verified {
+		apiutil.ReturnAPIError(errors.New("MFA must be configured and verified before generating recovery codes"), w)
+		return
+	}
+
+	codes, err := d.mfa.GenerateRecoveryCodes(username)
+	if err != nil {
+		apiutil.ReturnAPIError(err, w)
+		return
+	}
+	apiutil.WriteJSON(&types.MFAResponse{
+		Enabled:                true,
+		Verified:               true,
+		Methods:                status.Methods,
+		RecoveryCodes:          codes,
+		RecoveryCodesRemaining: len(codes),
+	}, w)
+}
+
+// Response with new recovery codes for the user
+// swagger:response recoveryCodesResponse
+type swaggerRecoveryCodesResponse struct {
+	// in:body
+	Body types.MFAResponse
+}
@@ -0,0 +1,83 @@
+/*
+
+Copyright 2020,2021 Avi Zimmerman
+
+This file is part of kvdi.
+
+kvdi is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+kvdi is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package api
+
+import (
+	"net/http"
+
+	appv1 "github.com/kvdi/kvdi/apis/app/v1"
+	"github.com/kvdi/kvdi/pkg/util/apiutil"
+	"github.com/kvdi/kvdi/pkg/util/errors"
+)
+
+// swagger:operation POST /api/users/{user}/mfa/reset Users postUserMFAResetRequest
+// ---
+// summary: Resets MFA for the given user.
+// description: All MFA methods and recovery codes for the user are removed, their refresh tokens are revoked, and they must enroll again at their next login.
+// parameters:
+//   - name: user
+//     in: path
+//     description: The user to reset MFA for
+//     type: string
+//     required: true
+//
+// responses:
+//
+//	"200":
+//	  "$ref": "#/responses/boolResponse"
+//	"400":
+//	  "$ref": "#/responses/error"
+//	"403":
+//	  "$ref": "#/responses/error"
+//	"404":
+//	  "$ref": "#/responses/error"
+func (d *desktopAPI) PostUserMFAReset(w http.ResponseWriter, r *http.Request) {
+	username := apiutil.GetUserFromRequest(r)
+
+	// Same as when updating MFA, we can only make sure the user exists when they
+	// are not from OIDC or SAML.
+	if method := d.getUserAuthMethod(username); method != appv1.AuthMethodOIDC && method != appv1.AuthMethodSAML {
+		if _, err := d.auth.GetUser(username); err != nil {
+			if errors.IsUserNotFoundError(err) {
+				apiutil.ReturnAPINotFound(err, w)
+				return
+			}
+			apiutil.ReturnAPIError(err, w)
+			return
+		}
+	}
+
+	userSession := apiutil.GetRequestUserSession(r)
+	if err := d.mfa.ResetUser(username, userSession.User.Name); err != nil {
+		apiutil.ReturnAPIError(err, w)
+		return
+	}
+	d.auditUserEvent(mfaEventReset, userSession.User.Name, username, r)
+
+	// make sure the user has to log in again to enroll
+	if err := d.revokeUserLogins(username); err != nil {
+		apiutil.ReturnAPIError(err, w)
+		return
+	}
+
+	apiutil.WriteOK(w)
+}
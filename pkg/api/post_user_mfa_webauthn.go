@@ -0,0 +1,74 @@
+/*
+
+Copyright 2020,2021 Avi Zimmerman
+
+This file is part of kvdi.
+
+kvdi is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+kvdi is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package api
+
+import (
+	"net/http"
+
+	"github.com/kvdi/kvdi/pkg/auth/mfa"
+	"github.com/kvdi/kvdi/pkg/types"
+	"github.com/kvdi/kvdi/pkg/util/apiutil"
+)
+
+// swagger:operation POST /api/users/{user}/mfa/webauthn Users postUserWebAuthnRequest
+// ---
+// summary: Starts the registration of a new WebAuthn credential for the given user.
+// description: The returned options are passed to navigator.credentials.create() and the result is sent to PUT /api/users/{user}/mfa/webauthn.
+// parameters:
+//   - name: user
+//     in: path
+//     description: The user to register the credential for
+//     type: string
+//     required: true
+//
+// responses:
+//
+//	"200":
+//	  "$ref": "#/responses/webAuthnCreationOptionsResponse"
+//	"400":
+//	  "$ref": "#/responses/error"
+//	"403":
+//	  "$ref": "#/responses/error"
+func (d *desktopAPI) PostUserWebAuthn(w http.ResponseWriter, r *http.Request) {
+	opts, err := d.mfa.BeginWebAuthnRegistration(apiutil.GetUserFromRequest(r), d.webauthnRelyingParty())
+	if err != nil {
+		apiutil.ReturnAPIError(err, w)
+		return
+	}
+	apiutil.WriteJSON(opts, w)
+}
+
+// webauthnRelyingParty returns the WebAuthn relying party configured in the VDICluster.
+// It is never derived from the request, since the Host header is chosen by the client.
+func (d *desktopAPI) webauthnRelyingParty() mfa.RelyingParty {
+	return mfa.RelyingParty{
+		ID:      d.vdiCluster.GetWebAuthnRPID(),
+		Origins: d.vdiCluster.GetWebAuthnOrigins(),
+	}
+}
+
+// WebAuthn registration options
+// swagger:response webAuthnCreationOptionsResponse
+type swaggerWebAuthnCreationOptionsResponse struct {
+	// in:body
+	Body types.WebAuthnCreationOptions
+}
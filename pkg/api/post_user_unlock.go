@@ -0,0 +1,57 @@
+/*
+
+Copyright 2020,2021 Avi Zimmerman
+
+This file is part of kvdi.
+
+kvdi is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+kvdi is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package api
+
+import (
+	"net/http"
+
+	"github.com/kvdi/kvdi/pkg/util/apiutil"
+)
+
+// swagger:operation POST /api/users/{user}/unlock Users postUserUnlockRequest
+// ---
+// summary: Unlocks the given user.
+// description: Removes the lockout and any failed logins counted against the user. Lockouts of source addresses are left to expire on their own.
+// parameters:
+//   - name: user
+//     in: path
+//     description: The user to unlock
+//     type: string
+//     required: true
+//
+// responses:
+//
+//	"200":
+//	  "$ref": "#/responses/boolResponse"
+//	"400":
+//	  "$ref": "#/responses/error"
+//	"403":
+//	  "$ref": "#/responses/error"
+func (d *desktopAPI) PostUserUnlock(w http.ResponseWriter, r *http.Request) {
+	username := apiutil.GetUserFromRequest(r)
+	if err := d.lockout.Unlock(username); err != nil {
+		apiutil.ReturnAPIError(err, w)
+		return
+	}
+	d.auditUserEvent(loginEventUnlock, apiutil.GetRequestUserSession(r).User.Name, username, r)
+	apiutil.WriteOK(w)
+}
@@ -20,11 +20,13 @@ along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
 package api
 
 import (
+	"io"
 	"net/http"
 
 	"github.com/kennygrant/sanitize"
 	"github.com/kvdi/kvdi/pkg/proxyproto"
 	"github.com/kvdi/kvdi/pkg/util/apiutil"
+	"github.com/kvdi/kvdi/pkg/util/errors"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
@@ -60,14 +62,55 @@ import (
 //	"404":
 //	  "$ref": "#/responses/error"
 func (d *desktopAPI) PutDesktopFile(w http.ResponseWriter, r *http.Request) {
+	tmpl, err := d.getTemplateForRequest(r)
+	if err != nil {
+		if client.IgnoreNotFound(err) == nil {
+			apiutil.ReturnAPINotFound(err, w)
+			return
+		}
+		apiutil.ReturnAPIError(err, w)
+		return
+	}
+
 	file, handler, err := r.FormFile("file")
 	if err != nil {
 		apiutil.ReturnAPIError(err, w)
 		return
 	}
+	defer file.Close()
+
+	record := &FileTransferRecord{
+		Direction:   fileTransferUpload,
+		FileName:    sanitize.BaseName(handler.Filename),
+		FileSize:    handler.Size,
+		UserSession: apiutil.GetRequestUserSession(r),
+		Request:     r,
+	}
+	if !tmpl.FileUploadEnabled() {
+		d.denyFileTransfer(w, record, errors.New("file uploads are not allowed to this desktop"))
+		return
+	}
+
+	// Read the header of the upload to detect its content type
+	hdr := make([]byte, 512)
+	n, err := file.Read(hdr)
+	if err != nil && err != io.EOF {
+		apiutil.ReturnAPIError(err, w)
+		return
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		apiutil.ReturnAPIError(err, w)
+		return
+	}
+	reservation, err := d.reserveFileTransfer(tmpl, record.UserSession.User.Name, record.FileName, http.DetectContentType(hdr[:n]), handler.Size)
+	if err != nil {
+		d.denyFileTransfer(w, record, err)
+		return
+	}
 
 	proxy, err := d.getProxyClientForRequest(r)
 	if err != nil {
+		d.releaseFileTransfer(record, reservation, 0)
 		if client.IgnoreNotFound(err) == nil {
 			apiutil.ReturnAPINotFound(err, w)
 			return
@@ -76,14 +119,17 @@ func (d *desktopAPI) PutDesktopFile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	body := newHashingReadCloser(file)
 	if err := proxy.PutFile(&proxyproto.FPutRequest{
-		Name: sanitize.BaseName(handler.Filename),
+		Name: record.FileName,
 		Size: handler.Size,
-		Body: file,
+		Body: body,
 	}); err != nil {
+		d.releaseFileTransfer(record, reservation, body.read)
 		apiutil.ReturnAPIError(err, w)
 		return
 	}
 
+	d.completeFileTransfer(record, reservation, body)
 	apiutil.WriteOK(w)
 }
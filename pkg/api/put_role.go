@@ -38,7 +38,7 @@ import (
 // swagger:operation PUT /api/roles/{role} Roles putRoleRequest
 // ---
 // summary: Update the specified role.
-// description: All properties will be overwritten with those provided in the payload, even if undefined.
+// description: Annotations and rules will be overwritten with those provided in the payload, even if undefined. Bandwidth limits and audio capture are only changed when provided, and an empty limits object removes the limits.
 // parameters:
 //   - name: role
 //     in: path
@@ -80,6 +80,18 @@ func (d *desktopAPI) UpdateRole(w http.ResponseWriter, r *http.Request) {
 	}
 	vdiRole.Annotations = params.GetAnnotations()
 	vdiRole.Rules = params.GetRules()
+	if limits := params.GetBandwidthLimits(); limits != nil {
+		// Clients that don't know about limits leave them out, so only replace them
+		// when they are provided
+		if limits.IsEmpty() {
+			vdiRole.BandwidthLimits = nil
+		} else {
+			vdiRole.BandwidthLimits = limits
+		}
+	}
+	if params.DenyAudioCapture != nil {
+		vdiRole.DenyAudioCapture = *params.DenyAudioCapture
+	}
 	if err := d.client.Update(context.TODO(), vdiRole); err != nil {
 		apiutil.ReturnAPIError(err, w)
 		return
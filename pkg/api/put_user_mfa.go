@@ -22,6 +22,7 @@ package api
 import (
 	"net/http"
 
+	appv1 "github.com/kvdi/kvdi/apis/app/v1"
 	"github.com/kvdi/kvdi/pkg/types"
 	"github.com/kvdi/kvdi/pkg/util/apiutil"
 	"github.com/kvdi/kvdi/pkg/util/errors"
@@ -57,10 +58,10 @@ import (
 func (d *desktopAPI) PutUserMFA(w http.ResponseWriter, r *http.Request) {
 	username := apiutil.GetUserFromRequest(r)
 
-	// Only verify user if not using OIDC. We don't have a way to verify the user
+	// Only verify user if not using OIDC or SAML. We don't have a way to verify the user
 	// otherwise. This does leave the door open for someone with access to this endpoint
 	// to go rogue and flood the secrets with bad users.
-	if !d.vdiCluster.IsUsingOIDCAuth() {
+	if method := d.getUserAuthMethod(username); method != appv1.AuthMethodOIDC && method != appv1.AuthMethodSAML {
 		if _, err := d.auth.GetUser(username); err != nil {
 			if errors.IsUserNotFoundError(err) {
 				apiutil.ReturnAPINotFound(err, w)
@@ -32,6 +32,7 @@ import (
 // swagger:operation PUT /api/users/{user}/mfa/verify Users putUserMFAVerifyRequest
 // ---
 // summary: Verifies the MFA setup for the given user.
+// description: The first successful verification also returns one-time recovery codes for the user.
 // parameters:
 //   - name: user
 //     in: path
@@ -64,6 +65,12 @@ func (d *desktopAPI) PutUserMFAVerify(w http.ResponseWriter, r *http.Request) {
 	username := apiutil.GetUserFromRequest(r)
 	token := req.OTP
 
+	// Failed codes are counted against the user and source address the same way
+	// failed logins are, so this route can't be used to guess codes.
+	if d.checkLoginLockout(w, r, username) {
+		return
+	}
+
 	secret, alreadyVerified, err := d.mfa.GetUserMFAStatus(username)
 	if err != nil {
 		if !errors.IsUserNotFoundError(err) {
@@ -80,23 +87,32 @@ func (d *desktopAPI) PutUserMFAVerify(w http.ResponseWriter, r *http.Request) {
 		// just return an error, if they are already verified we don't want to
 		// change that. This way, this route can also be used by a user to simply
 		// make sure their MFA still works.
+		d.recordLoginFailure(r, username)
 		apiutil.ReturnAPIForbidden(nil, "Invalid MFA Code", w)
 		return
 	}
 
+	resp := &types.MFAResponse{
+		Enabled:  true,
+		Verified: true,
+	}
+
 	if !alreadyVerified {
 		// We can mark the user as verified now
 		if err := d.mfa.SetUserMFAStatus(username, secret, true); err != nil {
 			apiutil.ReturnAPIError(err, w)
 			return
 		}
+		// and give them recovery codes in case they lose their device
+		if resp.RecoveryCodes, err = d.mfa.GenerateRecoveryCodes(username); err != nil {
+			apiutil.ReturnAPIError(err, w)
+			return
+		}
+		resp.RecoveryCodesRemaining = len(resp.RecoveryCodes)
 	}
 
 	// Return to the user the token was valid
-	apiutil.WriteJSON(&types.MFAResponse{
-		Enabled:  true,
-		Verified: true,
-	}, w)
+	apiutil.WriteJSON(resp, w)
 }
 
 // Request containing an OTP token
@@ -0,0 +1,86 @@
+/*
+
+Copyright 2020,2021 Avi Zimmerman
+
+This file is part of kvdi.
+
+kvdi is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+kvdi is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package api
+
+import (
+	"net/http"
+
+	"github.com/kvdi/kvdi/pkg/types"
+	"github.com/kvdi/kvdi/pkg/util/apiutil"
+	"github.com/kvdi/kvdi/pkg/util/errors"
+)
+
+// swagger:operation PUT /api/users/{user}/mfa/webauthn Users putUserWebAuthnRequest
+// ---
+// summary: Completes the registration of a new WebAuthn credential for the given user.
+// parameters:
+//   - name: user
+//     in: path
+//     description: The user to register the credential for
+//     type: string
+//     required: true
+//   - in: body
+//     name: body
+//     description: The response from the authenticator
+//     schema:
+//     "$ref": "#/definitions/RegisterWebAuthnRequest"
+//
+// responses:
+//
+//	"200":
+//	  "$ref": "#/responses/webAuthnCredentialResponse"
+//	"400":
+//	  "$ref": "#/responses/error"
+//	"403":
+//	  "$ref": "#/responses/error"
+func (d *desktopAPI) PutUserWebAuthn(w http.ResponseWriter, r *http.Request) {
+	req := apiutil.GetRequestObject(r).(*types.RegisterWebAuthnRequest)
+	if req == nil {
+		apiutil.ReturnAPIError(errors.New("Malformed request"), w)
+		return
+	}
+	username := apiutil.GetUserFromRequest(r)
+	cred, err := d.mfa.FinishWebAuthnRegistration(username, req)
+	if err != nil {
+		// failed enrollments count against the user the same way failed logins do
+		if !apiutil.GetRequestUserSession(r).Authorized {
+			d.recordLoginFailure(r, username)
+		}
+		apiutil.ReturnAPIError(err, w)
+		return
+	}
+	apiutil.WriteJSON(cred, w)
+}
+
+// Request containing a new WebAuthn credential
+// swagger:parameters putUserWebAuthnRequest
+type swaggerPutUserWebAuthnRequest struct {
+	// in:body
+	Body types.RegisterWebAuthnRequest
+}
+
+// WebAuthn credential response
+// swagger:response webAuthnCredentialResponse
+type swaggerWebAuthnCredentialResponse struct {
+	// in:body
+	Body types.WebAuthnCredential
+}
@@ -0,0 +1,106 @@
+/*
+
+Copyright 2020,2021 Avi Zimmerman
+
+This file is part of kvdi.
+
+kvdi is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+kvdi is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package api
+
+import (
+	"net/http"
+
+	appv1 "github.com/kvdi/kvdi/apis/app/v1"
+	"github.com/kvdi/kvdi/pkg/auth/providers/composite"
+	"github.com/kvdi/kvdi/pkg/types"
+	"github.com/kvdi/kvdi/pkg/util/apiutil"
+	"github.com/kvdi/kvdi/pkg/util/errors"
+)
+
+// swagger:operation PUT /api/users/{user}/password Users putUserPasswordRequest
+// ---
+// summary: Change the password of the specified user.
+// description: Users can change their own password with their current one, including when it has expired. Only local users can change their passwords. Refresh tokens for the user are revoked, so they must log in again with the new password.
+// parameters:
+//   - name: user
+//     in: path
+//     description: The user to change the password for
+//     type: string
+//     required: true
+//   - in: body
+//     name: passwordDetails
+//     description: The current and new passwords.
+//     schema:
+//     "$ref": "#/definitions/ChangePasswordRequest"
+//
+// responses:
+//
+//	"200":
+//	  "$ref": "#/responses/boolResponse"
+//	"400":
+//	  "$ref": "#/responses/error"
+//	"403":
+//	  "$ref": "#/responses/error"
+func (d *desktopAPI) PutUserPassword(w http.ResponseWriter, r *http.Request) {
+	username := apiutil.GetUserFromRequest(r)
+	req := apiutil.GetRequestObject(r).(*types.ChangePasswordRequest)
+	if req == nil {
+		apiutil.ReturnAPIError(errors.New("Malformed request"), w)
+		return
+	}
+
+	if d.getUserAuthMethod(username) != appv1.AuthMethodLocal {
+		apiutil.ReturnAPIError(errors.New("Only local users can change their password"), w)
+		return
+	}
+
+	// Verifying the current password counts towards login lockouts the same as
+	// a regular login.
+	if d.checkLoginLockout(w, r, username) {
+		return
+	}
+	loginReq := &types.LoginRequest{Username: username, Password: req.CurrentPassword}
+	if d.vdiCluster.IsUsingMultipleAuthMethods() {
+		method, name := composite.SplitUsername(username)
+		loginReq.Method, loginReq.Username = string(method), name
+	}
+	if _, err := d.auth.Authenticate(loginReq); err != nil {
+		d.recordLoginFailure(r, username)
+		apiutil.ReturnAPIForbidden(err, "Invalid credentials", w)
+		return
+	}
+
+	if err := d.auth.UpdateUser(username, &types.UpdateUserRequest{Password: req.NewPassword}); err != nil {
+		apiutil.ReturnAPIError(err, w)
+		return
+	}
+
+	// make sure the user logs in again with their new password
+	if err := d.revokeUserLogins(username); err != nil {
+		apiutil.ReturnAPIError(err, w)
+		return
+	}
+
+	apiutil.WriteOK(w)
+}
+
+// Request containing the current and new password for a user
+// swagger:parameters putUserPasswordRequest
+type swaggerChangePasswordRequest struct {
+	// in:body
+	Body types.ChangePasswordRequest
+}
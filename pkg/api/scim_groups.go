@@ -0,0 +1,154 @@
+/*
+
+Copyright 2020,2021 Avi Zimmerman
+
+This file is part of kvdi.
+
+kvdi is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+kvdi is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package api
+
+import (
+	"net/http"
+
+	"github.com/kvdi/kvdi/pkg/auth/scim"
+	"github.com/kvdi/kvdi/pkg/util/apiutil"
+)
+
+// swagger:route GET /scim/v2/Groups SCIM getSCIMGroups
+// Retrieves the groups matching the filter in the query. Only equality filters on
+// displayName, externalId and id are supported.
+// responses:
+//
+//	200: scimResponse
+//	400: scimError
+//	401: scimError
+func (d *desktopAPI) GetSCIMGroups(w http.ResponseWriter, r *http.Request) {
+	groups, err := d.scim.ListGroups(r.URL.Query().Get("filter"))
+	if err != nil {
+		writeSCIMError(err, w)
+		return
+	}
+	startIndex, count := getSCIMPage(r)
+	start, end := scim.Page(len(groups), startIndex, count)
+	writeSCIM(&scim.ListResponse{
+		Schemas:      []string{scim.ListResponseSchema},
+		TotalResults: len(groups),
+		StartIndex:   start + 1,
+		ItemsPerPage: end - start,
+		Resources:    groups[start:end],
+	}, http.StatusOK, w)
+}
+
+// swagger:route GET /scim/v2/Groups/{id} SCIM getSCIMGroup
+// Retrieves a single group.
+// responses:
+//
+//	200: scimResponse
+//	401: scimError
+//	404: scimError
+func (d *desktopAPI) GetSCIMGroup(w http.ResponseWriter, r *http.Request) {
+	group, err := d.scim.GetGroup(apiutil.GetSCIMIDFromRequest(r))
+	if err != nil {
+		writeSCIMError(err, w)
+		return
+	}
+	writeSCIM(group, http.StatusOK, w)
+}
+
+// swagger:route POST /scim/v2/Groups SCIM postSCIMGroup
+// Provisions a new group. VDIRoles are bound to it by its displayName with the
+// kvdi.io/scim-groups annotation.
+// responses:
+//
+//	201: scimResponse
+//	400: scimError
+//	401: scimError
+//	409: scimError
+func (d *desktopAPI) PostSCIMGroup(w http.ResponseWriter, r *http.Request) {
+	req := &scim.Group{}
+	if err := decodeSCIM(r, req); err != nil {
+		writeSCIMError(err, w)
+		return
+	}
+	group, err := d.scim.CreateGroup(req)
+	if err != nil {
+		writeSCIMError(err, w)
+		return
+	}
+	writeSCIM(group, http.StatusCreated, w)
+}
+
+// swagger:route PUT /scim/v2/Groups/{id} SCIM putSCIMGroup
+// Replaces the attributes and members of a group.
+// responses:
+//
+//	200: scimResponse
+//	400: scimError
+//	401: scimError
+//	404: scimError
+//	409: scimError
+func (d *desktopAPI) PutSCIMGroup(w http.ResponseWriter, r *http.Request) {
+	req := &scim.Group{}
+	if err := decodeSCIM(r, req); err != nil {
+		writeSCIMError(err, w)
+		return
+	}
+	group, err := d.scim.ReplaceGroup(apiutil.GetSCIMIDFromRequest(r), req)
+	if err != nil {
+		writeSCIMError(err, w)
+		return
+	}
+	writeSCIM(group, http.StatusOK, w)
+}
+
+// swagger:route PATCH /scim/v2/Groups/{id} SCIM patchSCIMGroup
+// Modifies the attributes or members of a group.
+// responses:
+//
+//	200: scimResponse
+//	400: scimError
+//	401: scimError
+//	404: scimError
+//	409: scimError
+func (d *desktopAPI) PatchSCIMGroup(w http.ResponseWriter, r *http.Request) {
+	req := &scim.PatchRequest{}
+	if err := decodeSCIM(r, req); err != nil {
+		writeSCIMError(err, w)
+		return
+	}
+	group, err := d.scim.PatchGroup(apiutil.GetSCIMIDFromRequest(r), req)
+	if err != nil {
+		writeSCIMError(err, w)
+		return
+	}
+	writeSCIM(group, http.StatusOK, w)
+}
+
+// swagger:route DELETE /scim/v2/Groups/{id} SCIM deleteSCIMGroup
+// Removes a group and unbinds its members from it.
+// responses:
+//
+//	204: scimResponse
+//	401: scimError
+//	404: scimError
+func (d *desktopAPI) DeleteSCIMGroup(w http.ResponseWriter, r *http.Request) {
+	if err := d.scim.DeleteGroup(apiutil.GetSCIMIDFromRequest(r)); err != nil {
+		writeSCIMError(err, w)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
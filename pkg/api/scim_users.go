@@ -0,0 +1,170 @@
+/*
+
+Copyright 2020,2021 Avi Zimmerman
+
+This file is part of kvdi.
+
+kvdi is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+kvdi is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package api
+
+import (
+	"net/http"
+
+	"github.com/kvdi/kvdi/pkg/auth/scim"
+	"github.com/kvdi/kvdi/pkg/util/apiutil"
+)
+
+// swagger:route GET /scim/v2/Users SCIM getSCIMUsers
+// Retrieves the users matching the filter in the query. Only equality filters on
+// userName, externalId and id are supported.
+// responses:
+//
+//	200: scimResponse
+//	400: scimError
+//	401: scimError
+func (d *desktopAPI) GetSCIMUsers(w http.ResponseWriter, r *http.Request) {
+	users, err := d.scim.ListUsers(r.URL.Query().Get("filter"))
+	if err != nil {
+		writeSCIMError(err, w)
+		return
+	}
+	startIndex, count := getSCIMPage(r)
+	start, end := scim.Page(len(users), startIndex, count)
+	writeSCIM(&scim.ListResponse{
+		Schemas:      []string{scim.ListResponseSchema},
+		TotalResults: len(users),
+		StartIndex:   start + 1,
+		ItemsPerPage: end - start,
+		Resources:    users[start:end],
+	}, http.StatusOK, w)
+}
+
+// swagger:route GET /scim/v2/Users/{id} SCIM getSCIMUser
+// Retrieves a single user.
+// responses:
+//
+//	200: scimResponse
+//	401: scimError
+//	404: scimError
+func (d *desktopAPI) GetSCIMUser(w http.ResponseWriter, r *http.Request) {
+	user, err := d.scim.GetUser(apiutil.GetSCIMIDFromRequest(r))
+	if err != nil {
+		writeSCIMError(err, w)
+		return
+	}
+	writeSCIM(user, http.StatusOK, w)
+}
+
+// swagger:route POST /scim/v2/Users SCIM postSCIMUser
+// Provisions a new user.
+// responses:
+//
+//	201: scimResponse
+//	400: scimError
+//	401: scimError
+//	409: scimError
+func (d *desktopAPI) PostSCIMUser(w http.ResponseWriter, r *http.Request) {
+	req := &scim.User{}
+	if err := decodeSCIM(r, req); err != nil {
+		writeSCIMError(err, w)
+		return
+	}
+	user, err := d.scim.CreateUser(req)
+	if err != nil {
+		writeSCIMError(err, w)
+		return
+	}
+	d.auditUserEvent(scimEventUserProvision, scimActor, user.UserName, r)
+	writeSCIM(user, http.StatusCreated, w)
+}
+
+// swagger:route PUT /scim/v2/Users/{id} SCIM putSCIMUser
+// Replaces the attributes of a user. Deactivated users have their logins revoked and
+// their desktops removed.
+// responses:
+//
+//	200: scimResponse
+//	400: scimError
+//	401: scimError
+//	404: scimError
+//	409: scimError
+func (d *desktopAPI) PutSCIMUser(w http.ResponseWriter, r *http.Request) {
+	req := &scim.User{}
+	if err := decodeSCIM(r, req); err != nil {
+		writeSCIMError(err, w)
+		return
+	}
+	user, err := d.scim.ReplaceUser(apiutil.GetSCIMIDFromRequest(r), req)
+	if err != nil {
+		writeSCIMError(err, w)
+		return
+	}
+	d.checkSCIMUserDeactivated(user, r)
+	writeSCIM(user, http.StatusOK, w)
+}
+
+// swagger:route PATCH /scim/v2/Users/{id} SCIM patchSCIMUser
+// Modifies the attributes of a user. Deactivated users have their logins revoked and
+// their desktops removed.
+// responses:
+//
+//	200: scimResponse
+//	400: scimError
+//	401: scimError
+//	404: scimError
+//	409: scimError
+func (d *desktopAPI) PatchSCIMUser(w http.ResponseWriter, r *http.Request) {
+	req := &scim.PatchRequest{}
+	if err := decodeSCIM(r, req); err != nil {
+		writeSCIMError(err, w)
+		return
+	}
+	user, err := d.scim.PatchUser(apiutil.GetSCIMIDFromRequest(r), req)
+	if err != nil {
+		writeSCIMError(err, w)
+		return
+	}
+	d.checkSCIMUserDeactivated(user, r)
+	writeSCIM(user, http.StatusOK, w)
+}
+
+// swagger:route DELETE /scim/v2/Users/{id} SCIM deleteSCIMUser
+// Removes a user, revokes their logins and removes their desktops.
+// responses:
+//
+//	204: scimResponse
+//	401: scimError
+//	404: scimError
+func (d *desktopAPI) DeleteSCIMUser(w http.ResponseWriter, r *http.Request) {
+	user, err := d.scim.DeleteUser(apiutil.GetSCIMIDFromRequest(r))
+	if err != nil {
+		writeSCIMError(err, w)
+		return
+	}
+	d.auditUserEvent(scimEventUserDelete, scimActor, user.UserName, r)
+	d.deprovisionUser(user.UserName, true)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// checkSCIMUserDeactivated deprovisions the given user if they are not active.
+func (d *desktopAPI) checkSCIMUserDeactivated(user *scim.User, r *http.Request) {
+	if user.IsActive() {
+		return
+	}
+	d.auditUserEvent(scimEventUserDeprovision, scimActor, user.UserName, r)
+	d.deprovisionUser(user.UserName, false)
+}
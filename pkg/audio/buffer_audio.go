@@ -22,6 +22,7 @@ along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
 package audio
 
 import (
+	"errors"
 	"io"
 	"sync"
 	"time"
@@ -32,8 +33,8 @@ import (
 )
 
 // Buffer provides a ReadWriteCloser for proxying audio data to
-// and from a websocket connection. The read-buffer is populated with opus/webm
-// data and writes to write-buffer can be in any format that gstreamer `decodebin`
+// and from a websocket connection. The read-buffer is populated with data in the
+// configured codec (opus/webm by default) and writes to write-buffer can be in any format that gstreamer `decodebin`
 // supports.
 type buffer struct {
 	mainLoop                                                       *glib.MainLoop
@@ -43,6 +44,9 @@ type buffer struct {
 	micSinkPipeline                                                *gst.Pipeline
 	channels, sampleRate, micChannels, micSampleRate               int
 	pulseServer, pulseFormat, pulseMonitor, pulseMic, pulseMicPath string
+	codec                                                          Codec
+	mode                                                           Mode
+	bitrate                                                        int
 	closed                                                         bool
 	wmux                                                           sync.Mutex
 	wsize                                                          int
@@ -64,6 +68,9 @@ func NewBuffer(opts *BufferOpts) Buffer {
 		pulseMonitor:  opts.GetPulseMonitorName(),
 		pulseMic:      opts.GetMicName(),
 		pulseMicPath:  opts.GetMicPath(),
+		codec:         opts.GetCodec(),
+		bitrate:       opts.GetBitrate(),
+		mode:          opts.GetMode(),
 		errChan:       make(chan error),
 	}
 }
@@ -105,6 +112,8 @@ func (a *buffer) newPlaybackPipeline() (io.ReadCloser, error) {
 			SourceFormat:   a.pulseFormat,
 			SourceRate:     a.sampleRate,
 			SourceChannels: a.channels,
+			Codec:          a.codec,
+			Bitrate:        a.bitrate,
 		},
 	)
 }
@@ -113,10 +122,17 @@ func (a *buffer) newPlaybackPipeline() (io.ReadCloser, error) {
 func (a *buffer) Start() error {
 	var err error
 
-	a.pbkReader, err = a.newPlaybackPipeline()
-	if err != nil {
-		return err
+	if a.mode.Playback() {
+		a.pbkReader, err = a.newPlaybackPipeline()
+		if err != nil {
+			return err
+		}
+	}
+
+	if !a.mode.Capture() {
+		return nil
 	}
+
 	a.recWriter, err = a.newRecordingPipeline()
 	if err != nil {
 		return err
@@ -195,6 +211,9 @@ func (a *buffer) Read(p []byte) (int, error) {
 		a.mainLoop.Quit()
 		return 0, err
 	default:
+		if a.pbkReader == nil {
+			return 0, errors.New("playback is not enabled on this audio buffer")
+		}
 		n, err := a.pbkReader.Read(p)
 		if err != nil {
 			a.mainLoop.Quit()
@@ -212,6 +231,9 @@ func (a *buffer) Write(p []byte) (int, error) {
 	default:
 		a.wmux.Lock()
 		defer a.wmux.Unlock()
+		if a.recWriter == nil {
+			return 0, errors.New("capture is not enabled on this audio buffer")
+		}
 		s, err := a.recWriter.Write(p)
 		if err != nil {
 			a.mainLoop.Quit()
@@ -229,14 +251,20 @@ func (a *buffer) IsClosed() bool { return a.closed }
 func (a *buffer) Close() error {
 	if !a.IsClosed() {
 		a.mainLoop.Quit()
-		if err := a.pbkReader.Close(); err != nil {
-			return err
+		if a.pbkReader != nil {
+			if err := a.pbkReader.Close(); err != nil {
+				return err
+			}
 		}
-		if err := a.recWriter.Close(); err != nil {
-			return err
+		if a.recWriter != nil {
+			if err := a.recWriter.Close(); err != nil {
+				return err
+			}
 		}
-		if err := a.micSinkPipeline.SetState(gst.StateNull); err != nil {
-			return err
+		if a.micSinkPipeline != nil {
+			if err := a.micSinkPipeline.SetState(gst.StateNull); err != nil {
+				return err
+			}
 		}
 		a.closed = true
 	}
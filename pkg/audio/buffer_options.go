@@ -57,6 +57,15 @@ type BufferOpts struct {
 	PulseMicSampleRate int
 	// The number of channels on the mic. Defaults to 1.
 	PulseMicChannels int
+	// The codec to encode the playback stream with. Defaults to Opus in a
+	// WebM container.
+	Codec Codec
+	// The bitrate in bits per second to use for Opus codecs. The encoder default
+	// is used when omitted.
+	Bitrate int
+	// The directions in which audio should flow. Defaults to both playback and
+	// capture.
+	Mode Mode
 }
 
 func (o *BufferOpts) GetLogger() logr.Logger {
@@ -121,3 +130,22 @@ func (o *BufferOpts) GetMicPath() string {
 	}
 	return o.PulseMicPath
 }
+
+func (o *BufferOpts) GetCodec() Codec {
+	if o.Codec == "" {
+		return DefaultCodec
+	}
+	return o.Codec
+}
+
+func (o *BufferOpts) GetBitrate() int {
+	// the encoder default is used if this is zero
+	return o.Bitrate
+}
+
+func (o *BufferOpts) GetMode() Mode {
+	if o.Mode == "" {
+		return DefaultMode
+	}
+	return o.Mode
+}
@@ -0,0 +1,117 @@
+/*
+
+Copyright 2020,2021 Avi Zimmerman
+
+This file is part of kvdi.
+
+kvdi is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+kvdi is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package audio
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+)
+
+// Codec represents the encoding of the playback stream sent to clients.
+type Codec string
+
+const (
+	// CodecPCM streams raw interleaved samples in the format of the pulse monitor.
+	CodecPCM Codec = "pcm"
+	// CodecOpusWebM streams Opus audio in a WebM container. This is the default and
+	// what browsers expect.
+	CodecOpusWebM Codec = "opus-webm"
+	// CodecOpusOgg streams Opus audio in an Ogg container.
+	CodecOpusOgg Codec = "opus-ogg"
+	// CodecOpus streams raw Opus frames, each prefixed with its length as a big-endian
+	// 16-bit integer.
+	CodecOpus Codec = "opus"
+)
+
+// DefaultCodec is the codec used when none is requested.
+const DefaultCodec = CodecOpusWebM
+
+// Codecs returns all of the supported codecs.
+func Codecs() []Codec {
+	return []Codec{CodecPCM, CodecOpusWebM, CodecOpusOgg, CodecOpus}
+}
+
+// ParseCodec returns the codec for the given string. An empty string returns the
+// default codec.
+func ParseCodec(s string) (Codec, error) {
+	if s == "" {
+		return DefaultCodec, nil
+	}
+	for _, c := range Codecs() {
+		if strings.EqualFold(s, string(c)) {
+			return c, nil
+		}
+	}
+	return "", fmt.Errorf("unsupported audio codec: %s", s)
+}
+
+// IsOpus returns true if the codec uses the Opus encoder.
+func (c Codec) IsOpus() bool { return c != CodecPCM }
+
+// ValidateBitrate returns an error if the given bitrate (in bits per second) is not
+// supported by the codec. Zero means to use the encoder default.
+func (c Codec) ValidateBitrate(bitrate int) error {
+	if bitrate == 0 {
+		return nil
+	}
+	if !c.IsOpus() {
+		return fmt.Errorf("a bitrate cannot be set for the %s codec", c)
+	}
+	if bitrate < 4000 || bitrate > 650000 {
+		return fmt.Errorf("bitrate %d is out of range, must be between 4000 and 650000", bitrate)
+	}
+	return nil
+}
+
+// WriteOpusFrame writes a frame of the CodecOpus stream to the given writer, prefixed
+// with its length.
+func WriteOpusFrame(w io.Writer, frame []byte) error {
+	if len(frame) > math.MaxUint16 {
+		return fmt.Errorf("opus frame of %d bytes is too large", len(frame))
+	}
+	buf := make([]byte, 2+len(frame))
+	binary.BigEndian.PutUint16(buf, uint16(len(frame)))
+	copy(buf[2:], frame)
+	_, err := w.Write(buf)
+	return err
+}
+
+// ReadOpusFrame reads the next frame of a CodecOpus stream from the given reader.
+// io.EOF is returned at the end of the stream, and io.ErrUnexpectedEOF if it ends
+// within a frame.
+func ReadOpusFrame(r io.Reader) ([]byte, error) {
+	var size uint16
+	if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+		return nil, err
+	}
+	frame := make([]byte, size)
+	if _, err := io.ReadFull(r, frame); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return frame, nil
+}
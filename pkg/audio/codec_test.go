@@ -0,0 +1,87 @@
+/*
+
+Copyright 2020,2021 Avi Zimmerman
+
+This file is part of kvdi.
+
+kvdi is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+kvdi is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package audio
+
+import (
+	"bytes"
+	"io"
+	"math"
+	"testing"
+)
+
+func TestParseCodec(t *testing.T) {
+	if codec, err := ParseCodec(""); err != nil || codec != DefaultCodec {
+		t.Error("Expected the default codec, got:", codec, err)
+	}
+	if codec, err := ParseCodec("OPUS-OGG"); err != nil || codec != CodecOpusOgg {
+		t.Error("Expected codecs to be case insensitive, got:", codec, err)
+	}
+	if _, err := ParseCodec("mp3"); err == nil {
+		t.Error("Expected error parsing an unsupported codec")
+	}
+	if err := CodecPCM.ValidateBitrate(64000); err == nil {
+		t.Error("Expected error setting a bitrate for pcm")
+	}
+	if err := CodecOpus.ValidateBitrate(1000); err == nil {
+		t.Error("Expected error using a bitrate out of range")
+	}
+	if err := CodecOpus.ValidateBitrate(64000); err != nil {
+		t.Error("Expected bitrate to be valid, got:", err)
+	}
+}
+
+func TestOpusFrames(t *testing.T) {
+	frames := [][]byte{[]byte("first"), {}, bytes.Repeat([]byte{0xff}, math.MaxUint16)}
+	var buf bytes.Buffer
+	for _, frame := range frames {
+		if err := WriteOpusFrame(&buf, frame); err != nil {
+			t.Fatal(err)
+		}
+	}
+	encoded := buf.Bytes()
+	for _, expected := range frames {
+		frame, err := ReadOpusFrame(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(frame, expected) {
+			t.Errorf("Expected frame of %d bytes, got %d", len(expected), len(frame))
+		}
+	}
+	if _, err := ReadOpusFrame(&buf); err != io.EOF {
+		t.Error("Expected EOF at the end of the stream, got:", err)
+	}
+
+	if err := WriteOpusFrame(&buf, make([]byte, math.MaxUint16+1)); err == nil {
+		t.Error("Expected error writing a frame that is too large")
+	}
+
+	malformed := map[string][]byte{
+		"truncated length": encoded[:1],
+		"truncated frame":  encoded[:4],
+	}
+	for name, data := range malformed {
+		if _, err := ReadOpusFrame(bytes.NewReader(data)); err != io.ErrUnexpectedEOF {
+			t.Errorf("Expected unexpected EOF reading a %s, got: %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+/*
+
+Copyright 2020,2021 Avi Zimmerman
+
+This file is part of kvdi.
+
+kvdi is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+kvdi is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package audio
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Mode represents the directions in which audio flows over a stream.
+type Mode string
+
+const (
+	// ModeBoth streams playback to the client and captures the client's microphone.
+	ModeBoth Mode = "both"
+	// ModePlayback only streams playback to the client.
+	ModePlayback Mode = "playback"
+	// ModeCapture only captures the client's microphone.
+	ModeCapture Mode = "capture"
+)
+
+// DefaultMode is the mode used when none is requested.
+const DefaultMode = ModeBoth
+
+// ErrCaptureNotAllowed is returned when microphone capture is requested but not allowed.
+var ErrCaptureNotAllowed = errors.New("microphone capture is not allowed for this desktop")
+
+// Modes returns all of the supported modes.
+func Modes() []Mode {
+	return []Mode{ModeBoth, ModePlayback, ModeCapture}
+}
+
+// ParseMode returns the mode for the given string. An empty string returns the
+// default mode.
+func ParseMode(s string) (Mode, error) {
+	if s == "" {
+		return DefaultMode, nil
+	}
+	for _, m := range Modes() {
+		if strings.EqualFold(s, string(m)) {
+			return m, nil
+		}
+	}
+	return "", fmt.Errorf("unsupported audio mode: %s", s)
+}
+
+// Playback returns true if playback is streamed in this mode.
+func (m Mode) Playback() bool { return m != ModeCapture }
+
+// Capture returns true if the microphone is captured in this mode.
+func (m Mode) Capture() bool { return m != ModePlayback }
+
+// WithoutCapture returns this mode with microphone capture removed. An error is
+// returned if nothing would be left to stream.
+func (m Mode) WithoutCapture() (Mode, error) {
+	if !m.Playback() {
+		return "", ErrCaptureNotAllowed
+	}
+	return ModePlayback, nil
+}
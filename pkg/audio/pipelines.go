@@ -37,6 +37,23 @@ import (
 type playbackPipelineOpts struct {
 	PulseServer, DeviceName, SourceFormat string
 	SourceRate, SourceChannels            int
+	Codec                                 Codec
+	Bitrate                               int
+}
+
+// encoderElements returns the elements to place between the cutter and appsink
+// for the given codec.
+func encoderElements(codec Codec) []string {
+	switch codec {
+	case CodecPCM:
+		return []string{}
+	case CodecOpusOgg:
+		return []string{"opusenc", "oggmux"}
+	case CodecOpus:
+		return []string{"opusenc"}
+	default:
+		return []string{"opusenc", "webmmux"}
+	}
 }
 
 type pipelineReader struct {
@@ -51,11 +68,19 @@ func newPlaybackPipelineReader(log logr.Logger, errors chan error, opts *playbac
 		return
 	}
 
-	elements, err := gst.NewElementMany("pulsesrc", "cutter", "opusenc", "webmmux", "appsink")
+	elementNames := append([]string{"pulsesrc", "cutter"}, encoderElements(opts.Codec)...)
+	elements, err := gst.NewElementMany(append(elementNames, "appsink")...)
 	if err != nil {
 		return
 	}
-	pulsesrc, cutter, opusenc, webmmux, appsink := elements[0], elements[1], elements[2], elements[3], elements[4]
+	pulsesrc, cutter, appsink := elements[0], elements[1], elements[len(elements)-1]
+
+	if opts.Codec.IsOpus() && opts.Bitrate > 0 {
+		// opusenc always directly follows the cutter
+		if err = elements[2].SetProperty("bitrate", opts.Bitrate); err != nil {
+			return
+		}
+	}
 
 	if err = pulsesrc.SetProperty("server", opts.PulseServer); err != nil {
 		return
@@ -84,6 +109,13 @@ func newPlaybackPipelineReader(log logr.Logger, errors chan error, opts *playbac
 			if buffer == nil {
 				return gst.FlowError
 			}
+			if opts.Codec == CodecOpus {
+				// Frame boundaries are lost on the wire, so prefix each one with its length
+				if err := WriteOpusFrame(w, buffer.Bytes()); err != nil {
+					return gst.FlowError
+				}
+				return gst.FlowOK
+			}
 			if _, err := io.Copy(w, buffer.Reader()); err != nil {
 				return gst.FlowError
 			}
@@ -97,7 +129,7 @@ func newPlaybackPipelineReader(log logr.Logger, errors chan error, opts *playbac
 	if err = pulsesrc.LinkFiltered(cutter, pulsecaps); err != nil {
 		return
 	}
-	if err = gst.ElementLinkMany(cutter, opusenc, webmmux, appsink); err != nil {
+	if err = gst.ElementLinkMany(elements[1:]...); err != nil {
 		return
 	}
 
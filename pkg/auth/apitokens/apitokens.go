@@ -0,0 +1,292 @@
+/*
+
+Copyright 2020,2021 Avi Zimmerman
+
+This file is part of kvdi.
+
+kvdi is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+kvdi is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package apitokens
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	v1 "github.com/kvdi/kvdi/apis/meta/v1"
+	rbacv1 "github.com/kvdi/kvdi/apis/rbac/v1"
+	"github.com/kvdi/kvdi/pkg/secrets"
+	"github.com/kvdi/kvdi/pkg/types"
+	"github.com/kvdi/kvdi/pkg/util/errors"
+)
+
+// TokenPrefix is the prefix of all personal API tokens. It is used to tell them
+// apart from JWTs.
+const TokenPrefix = "kvdi_"
+
+// Token is the record kept for a personal API token in the secrets backend.
+type Token struct {
+	types.APIToken `json:",inline"`
+	// The hex encoded SHA-256 hash of the token's secret
+	Hash string `json:"hash"`
+	// The names of the user's roles when the token was created. They are resolved
+	// to their current rules when the token is used, for users whose roles cannot be
+	// looked up again later.
+	RoleNames []string `json:"roleNames,omitempty"`
+	// The roles of the user when the token was created, kept by tokens created before
+	// role names were recorded.
+	Roles []*types.VDIUserRole `json:"roles,omitempty"`
+}
+
+// GetRoleNames returns the names of the user's roles when the token was created.
+func (t *Token) GetRoleNames() []string {
+	if t.RoleNames != nil {
+		return t.RoleNames
+	}
+	names := make([]string, len(t.Roles))
+	for i, role := range t.Roles {
+		names[i] = role.Name
+	}
+	return names
+}
+
+// Expired returns true if the token has expired.
+func (t *Token) Expired() bool {
+	return t.ExpiresAt != nil && time.Now().After(*t.ExpiresAt)
+}
+
+// Manager is an object for tracking personal API tokens. It uses the configured
+// secrets backend for storage.
+type Manager struct {
+	secrets *secrets.SecretEngine
+}
+
+// NewManager returns a new API token manager with the given secrets engine.
+func NewManager(secrets *secrets.SecretEngine) *Manager {
+	return &Manager{secrets: secrets}
+}
+
+// CreateToken creates a new token for the given user and returns its details along
+// with the token to present to the API. The role names are kept in case the user's roles
+// cannot be looked up when the token is used.
+func (m *Manager) CreateToken(user, name string, rules []rbacv1.Rule, roleNames []string, ttl time.Duration) (*types.CreateAPITokenResponse, error) {
+	id, err := randomHex(8)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := randomHex(32)
+	if err != nil {
+		return nil, err
+	}
+	record := &Token{
+		APIToken: types.APIToken{
+			ID:        id,
+			Name:      name,
+			User:      user,
+			Rules:     rules,
+			CreatedAt: time.Now().UTC().Truncate(time.Second),
+		},
+		Hash:      hashSecret(secret),
+		RoleNames: roleNames,
+	}
+	if ttl > 0 {
+		expiresAt := record.CreatedAt.Add(ttl)
+		record.ExpiresAt = &expiresAt
+	}
+
+	if err := m.secrets.Lock(15); err != nil {
+		return nil, err
+	}
+	defer m.secrets.Release()
+	tokens, err := m.readTokens()
+	if err != nil {
+		return nil, err
+	}
+	for _, existing := range tokens {
+		if existing.User == user && existing.Name == name {
+			return nil, fmt.Errorf("A token named '%s' already exists for %s", name, user)
+		}
+	}
+	tokens[id] = record
+	if err := m.writeTokens(tokens); err != nil {
+		return nil, err
+	}
+	return &types.CreateAPITokenResponse{
+		APIToken: record.APIToken,
+		Token:    TokenPrefix + id + "_" + secret,
+	}, nil
+}
+
+// ListTokens returns the details of the given user's tokens, oldest first.
+func (m *Manager) ListTokens(user string) ([]*types.APIToken, error) {
+	tokens, err := m.readTokens()
+	if err != nil {
+		return nil, err
+	}
+	out := make([]*types.APIToken, 0)
+	for _, token := range tokens {
+		if token.User == user {
+			apiToken := token.APIToken
+			out = append(out, &apiToken)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].CreatedAt.Equal(out[j].CreatedAt) {
+			return out[i].Name < out[j].Name
+		}
+		return out[i].CreatedAt.Before(out[j].CreatedAt)
+	})
+	return out, nil
+}
+
+// RevokeToken deletes the token with the given ID. A NotFound error is returned
+// if the token does not exist for the given user.
+func (m *Manager) RevokeToken(user, id string) error {
+	if err := m.secrets.Lock(15); err != nil {
+		return err
+	}
+	defer m.secrets.Release()
+	tokens, err := m.readTokens()
+	if err != nil {
+		return err
+	}
+	token, ok := tokens[id]
+	if !ok || token.User != user {
+		return errors.NewAPITokenNotFoundError(id)
+	}
+	delete(tokens, id)
+	return m.writeTokens(tokens)
+}
+
+// RevokeUserTokens deletes all of the tokens for the given user.
+func (m *Manager) RevokeUserTokens(user string) error {
+	if err := m.secrets.Lock(15); err != nil {
+		return err
+	}
+	defer m.secrets.Release()
+	tokens, err := m.readTokens()
+	if err != nil {
+		return err
+	}
+	var changed bool
+	for id, token := range tokens {
+		if token.User == user {
+			delete(tokens, id)
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	return m.writeTokens(tokens)
+}
+
+// MigrateUsernames moves the tokens of users to the names returned by rename. Names it
+// returns false for are left alone.
+func (m *Manager) MigrateUsernames(rename func(string) (string, bool)) error {
+	if err := m.secrets.Lock(15); err != nil {
+		return err
+	}
+	defer m.secrets.Release()
+	tokens, err := m.readTokens()
+	if err != nil {
+		return err
+	}
+	var changed bool
+	for _, token := range tokens {
+		if newName, ok := rename(token.User); ok {
+			token.User = newName
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	return m.writeTokens(tokens)
+}
+
+// VerifyToken looks up the record for the given token and verifies its secret and
+// expiry.
+func (m *Manager) VerifyToken(token string) (*Token, error) {
+	spl := strings.Split(strings.TrimPrefix(token, TokenPrefix), "_")
+	if !strings.HasPrefix(token, TokenPrefix) || len(spl) != 2 {
+		return nil, errors.New("The API token is malformed")
+	}
+	tokens, err := m.readTokens()
+	if err != nil {
+		return nil, err
+	}
+	record, ok := tokens[spl[0]]
+	if !ok || subtle.ConstantTimeCompare([]byte(record.Hash), []byte(hashSecret(spl[1]))) != 1 {
+		return nil, errors.New("The API token is invalid or has been revoked")
+	}
+	if record.Expired() {
+		return nil, errors.New("The API token has expired")
+	}
+	return record, nil
+}
+
+// readTokens reads all token records from the secrets backend. Reads skip the cache
+// so that revocations take effect on all replicas immediately.
+func (m *Manager) readTokens() (map[string]*Token, error) {
+	data, err := m.secrets.ReadSecretMap(v1.APITokensSecretKey, false)
+	if err != nil {
+		if errors.IsSecretNotFoundError(err) {
+			return map[string]*Token{}, nil
+		}
+		return nil, err
+	}
+	tokens := make(map[string]*Token, len(data))
+	for id, raw := range data {
+		token := &Token{}
+		if err := json.Unmarshal(raw, token); err != nil {
+			return nil, err
+		}
+		tokens[id] = token
+	}
+	return tokens, nil
+}
+
+func (m *Manager) writeTokens(tokens map[string]*Token) error {
+	data := make(map[string][]byte, len(tokens))
+	for id, token := range tokens {
+		raw, err := json.Marshal(token)
+		if err != nil {
+			return err
+		}
+		data[id] = raw
+	}
+	return m.secrets.WriteSecretMap(v1.APITokensSecretKey, data)
+}
+
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomHex(size int) (string, error) {
+	b := make([]byte, size)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
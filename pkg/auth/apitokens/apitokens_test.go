@@ -0,0 +1,202 @@
+/*
+
+Copyright 2020,2021 Avi Zimmerman
+
+This file is part of kvdi.
+
+kvdi is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+kvdi is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package apitokens
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	rbacv1 "github.com/kvdi/kvdi/apis/rbac/v1"
+	"github.com/kvdi/kvdi/pkg/secrets"
+	"github.com/kvdi/kvdi/pkg/util/errors"
+)
+
+func newTestManager(t *testing.T) *Manager {
+	t.Helper()
+	engine, _, err := secrets.NewTestEngine(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewManager(engine)
+}
+
+func TestCreateAndVerifyToken(t *testing.T) {
+	m := newTestManager(t)
+
+	rules := []rbacv1.Rule{{Verbs: []rbacv1.Verb{rbacv1.VerbRead}}}
+	resp, err := m.CreateToken("alice", "ci", rules, nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(resp.Token, TokenPrefix) {
+		t.Error("Expected token to have prefix, got", resp.Token)
+	}
+	if resp.ExpiresAt != nil {
+		t.Error("Expected token without a TTL not to expire")
+	}
+
+	record, err := m.VerifyToken(resp.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.User != "alice" || record.ID != resp.ID || len(record.Rules) != 1 {
+		t.Error("Unexpected token record:", record)
+	}
+	if strings.Contains(record.Hash, strings.Split(resp.Token, "_")[2]) {
+		t.Error("Expected the token secret not to be stored")
+	}
+
+	if _, err := m.CreateToken("alice", "ci", nil, nil, 0); err == nil {
+		t.Error("Expected error creating token with duplicate name")
+	}
+	if _, err := m.CreateToken("bob", "ci", nil, nil, 0); err != nil {
+		t.Error("Expected different users to be able to use the same token name, got:", err)
+	}
+}
+
+func TestVerifyInvalidTokens(t *testing.T) {
+	m := newTestManager(t)
+
+	resp, err := m.CreateToken("alice", "ci", nil, nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, token := range []string{
+		"",
+		"not-a-token",
+		TokenPrefix + resp.ID,
+		TokenPrefix + resp.ID + "_wrongsecret",
+		TokenPrefix + "unknown_" + strings.Split(resp.Token, "_")[2],
+	} {
+		if _, err := m.VerifyToken(token); err == nil {
+			t.Errorf("Expected error verifying %q", token)
+		}
+	}
+}
+
+func TestTokenExpiry(t *testing.T) {
+	m := newTestManager(t)
+
+	resp, err := m.CreateToken("alice", "short", nil, nil, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.ExpiresAt == nil {
+		t.Fatal("Expected token to have an expiry")
+	}
+	if _, err := m.VerifyToken(resp.Token); err != nil {
+		t.Fatal(err)
+	}
+
+	// rewrite the record so it is already expired
+	tokens, err := m.readTokens()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired := time.Now().Add(-time.Minute)
+	tokens[resp.ID].ExpiresAt = &expired
+	if err := m.writeTokens(tokens); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.VerifyToken(resp.Token); err == nil {
+		t.Error("Expected error verifying expired token")
+	}
+}
+
+func TestListAndRevokeTokens(t *testing.T) {
+	m := newTestManager(t)
+
+	first, err := m.CreateToken("alice", "first", nil, nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := m.CreateToken("alice", "second", nil, nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := m.CreateToken("bob", "other", nil, nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tokens, err := m.ListTokens("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tokens) != 2 || tokens[0].Name != "first" || tokens[1].Name != "second" {
+		t.Error("Expected alice's two tokens in order, got", tokens)
+	}
+
+	// users cannot revoke each other's tokens
+	if err := m.RevokeToken("alice", other.ID); !errors.IsAPITokenNotFoundError(err) {
+		t.Error("Expected not found revoking another user's token, got:", err)
+	}
+	if err := m.RevokeToken("alice", first.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.VerifyToken(first.Token); err == nil {
+		t.Error("Expected error verifying revoked token")
+	}
+	if err := m.RevokeToken("alice", first.ID); !errors.IsAPITokenNotFoundError(err) {
+		t.Error("Expected not found revoking token twice, got:", err)
+	}
+
+	if err := m.RevokeUserTokens("alice"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.VerifyToken(second.Token); err == nil {
+		t.Error("Expected error verifying token of revoked user")
+	}
+	if _, err := m.VerifyToken(other.Token); err != nil {
+		t.Error("Expected other user's token to still be valid, got:", err)
+	}
+}
+
+func TestMigrateUsernames(t *testing.T) {
+	m := newTestManager(t)
+
+	resp, err := m.CreateToken("alice", "ci", nil, nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	qualify := func(name string) (string, bool) {
+		if strings.HasPrefix(name, "ldap.") {
+			return "", false
+		}
+		return "ldap." + name, true
+	}
+	if err := m.MigrateUsernames(qualify); err != nil {
+		t.Fatal(err)
+	}
+	record, err := m.VerifyToken(resp.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.User != "ldap.alice" {
+		t.Error("Expected the token to be moved to the qualified name, got:", record.User)
+	}
+	if tokens, err := m.ListTokens("ldap.alice"); err != nil || len(tokens) != 1 {
+		t.Error("Expected the token to be listed for the qualified name, got:", tokens, err)
+	}
+}
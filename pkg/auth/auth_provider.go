@@ -24,24 +24,47 @@ package auth
 import (
 	appv1 "github.com/kvdi/kvdi/apis/app/v1"
 	"github.com/kvdi/kvdi/pkg/auth/common"
+	"github.com/kvdi/kvdi/pkg/auth/providers/clientcert"
+	"github.com/kvdi/kvdi/pkg/auth/providers/composite"
+	"github.com/kvdi/kvdi/pkg/auth/providers/kubernetes"
 	"github.com/kvdi/kvdi/pkg/auth/providers/ldap"
 	"github.com/kvdi/kvdi/pkg/auth/providers/local"
 	"github.com/kvdi/kvdi/pkg/auth/providers/oidc"
+	"github.com/kvdi/kvdi/pkg/auth/providers/saml"
 	"github.com/kvdi/kvdi/pkg/auth/providers/webmesh"
 	"github.com/kvdi/kvdi/pkg/secrets"
 )
 
 // GetAuthProvider returns the authentication provider for the given VDICluster. The secret engine passed
-// to the provider is assumed to already be setup.
+// to the provider is assumed to already be setup. When the cluster enables more than one authentication
+// method, a composite provider serving all of them is returned.
 func GetAuthProvider(cluster *appv1.VDICluster, s *secrets.SecretEngine) common.AuthProvider {
-	if cluster.IsUsingLDAPAuth() {
-		return ldap.New(s)
+	methods := cluster.GetAuthMethods()
+	if len(methods) == 1 {
+		return newProvider(methods[0], s)
 	}
-	if cluster.IsUsingOIDCAuth() {
-		return oidc.New(s)
+	members := make([]composite.Member, len(methods))
+	for i, method := range methods {
+		members[i] = composite.Member{Method: method, Provider: newProvider(method, s)}
 	}
-	if cluster.IsUsingWebmeshAuth() {
+	return composite.New(s, members...)
+}
+
+// newProvider returns the provider implementing the given authentication method.
+func newProvider(method appv1.AuthMethod, s *secrets.SecretEngine) common.AuthProvider {
+	switch method {
+	case appv1.AuthMethodLDAP:
+		return ldap.New(s)
+	case appv1.AuthMethodOIDC:
+		return oidc.New(s)
+	case appv1.AuthMethodSAML:
+		return saml.New(s)
+	case appv1.AuthMethodWebmesh:
 		return webmesh.New()
+	case appv1.AuthMethodClientCert:
+		return clientcert.New()
+	case appv1.AuthMethodKubernetes:
+		return kubernetes.New()
 	}
 	return local.New(s)
 }
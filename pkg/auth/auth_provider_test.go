@@ -24,6 +24,8 @@ import (
 	"testing"
 
 	appv1 "github.com/kvdi/kvdi/apis/app/v1"
+	"github.com/kvdi/kvdi/pkg/auth/providers/composite"
+	"github.com/kvdi/kvdi/pkg/auth/providers/kubernetes"
 	"github.com/kvdi/kvdi/pkg/auth/providers/local"
 )
 
@@ -33,3 +35,52 @@ func TestGetAuthProvider(t *testing.T) {
 		t.Error("Should have received a local auth provider")
 	}
 }
+
+func TestGetCompositeAuthProvider(t *testing.T) {
+	cluster := &appv1.VDICluster{
+		Spec: appv1.VDIClusterSpec{
+			Auth: &appv1.AuthConfig{
+				LDAPAuth: &appv1.LDAPConfig{URL: "ldaps://ldap.local"},
+				// oidc is not configured and should be ignored
+				Providers: []appv1.AuthMethod{appv1.AuthMethodLocal, appv1.AuthMethodOIDC, appv1.AuthMethodLDAP},
+			},
+		},
+	}
+	authProvider, ok := GetAuthProvider(cluster, nil).(*composite.AuthProvider)
+	if !ok {
+		t.Fatal("Should have received a composite auth provider")
+	}
+	expected := []appv1.AuthMethod{appv1.AuthMethodLocal, appv1.AuthMethodLDAP}
+	if !reflect.DeepEqual(authProvider.Methods(), expected) {
+		t.Error("Expected methods", expected, "got", authProvider.Methods())
+	}
+
+	// A single listed method should not use a composite provider
+	cluster.Spec.Auth.Providers = []appv1.AuthMethod{appv1.AuthMethodLocal}
+	if reflect.TypeOf(GetAuthProvider(cluster, nil)) != reflect.TypeOf(&local.AuthProvider{}) {
+		t.Error("Should have received a local auth provider")
+	}
+}
+
+func TestGetKubernetesAuthProvider(t *testing.T) {
+	cluster := &appv1.VDICluster{
+		Spec: appv1.VDIClusterSpec{
+			Auth: &appv1.AuthConfig{
+				KubernetesAuth: &appv1.KubernetesConfig{},
+			},
+		},
+	}
+	if reflect.TypeOf(GetAuthProvider(cluster, nil)) != reflect.TypeOf(&kubernetes.AuthProvider{}) {
+		t.Error("Should have received a kubernetes auth provider")
+	}
+
+	// ServiceAccounts can authenticate alongside other methods
+	cluster.Spec.Auth.Providers = []appv1.AuthMethod{appv1.AuthMethodLocal, appv1.AuthMethodKubernetes}
+	authProvider, ok := GetAuthProvider(cluster, nil).(*composite.AuthProvider)
+	if !ok {
+		t.Fatal("Should have received a composite auth provider")
+	}
+	if !reflect.DeepEqual(authProvider.Methods(), cluster.Spec.Auth.Providers) {
+		t.Error("Expected methods", cluster.Spec.Auth.Providers, "got", authProvider.Methods())
+	}
+}
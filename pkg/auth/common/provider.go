@@ -61,3 +61,35 @@ type AuthProvider interface {
 	// DeleteUser should remove a VDIUser
 	DeleteUser(string) error
 }
+
+// MetadataProvider is implemented by AuthProviders that publish a document describing
+// kVDI to an external identity provider, such as SAML service provider metadata.
+type MetadataProvider interface {
+	// Metadata returns the content type and body of the metadata document.
+	Metadata() (contentType string, body []byte, err error)
+}
+
+// RefreshProvider is implemented by AuthProviders that can re-derive a user's identity
+// from an external identity provider without starting a new login flow.
+type RefreshProvider interface {
+	// Refresh returns a new AuthResult for the given login of the user, or an error if
+	// their session with the identity provider can no longer be refreshed.
+	Refresh(username, loginID string) (*types.AuthResult, error)
+}
+
+// LogoutProvider is implemented by AuthProviders that can end a user's session at an
+// external identity provider.
+type LogoutProvider interface {
+	// Logout discards any state held for the given login of the user and returns a URL the
+	// client should visit to end its session at the identity provider. The URL is empty when
+	// the identity provider does not support it.
+	Logout(username, loginID string) (string, error)
+}
+
+// SessionProvider is implemented by AuthProviders that keep state for each login, such
+// as the tokens an external identity provider issued for it.
+type SessionProvider interface {
+	// RevokeSessions discards the state held for the given logins of the user, or for all
+	// of their logins when none are given.
+	RevokeSessions(username string, loginIDs ...string) error
+}
@@ -0,0 +1,338 @@
+/*
+
+Copyright 2020,2021 Avi Zimmerman
+
+This file is part of kvdi.
+
+kvdi is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+kvdi is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package lockout
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	v1 "github.com/kvdi/kvdi/apis/meta/v1"
+	"github.com/kvdi/kvdi/pkg/secrets"
+	"github.com/kvdi/kvdi/pkg/util/errors"
+)
+
+// MaxRecords is the maximum number of subjects failures are tracked for. Once it is
+// exceeded, the records that have seen the least recent activity are evicted, preferring
+// records that are not locked.
+const MaxRecords = 500
+
+// MaxRecordsSize is the maximum number of bytes the records may take up in the secrets
+// backend. Records are evicted the same way as when MaxRecords is exceeded. The records
+// share storage with every other app secret, so this keeps failed logins from unknown
+// users and addresses from filling it up.
+const MaxRecordsSize = 128 * 1024
+
+// maxNameLength is the length usernames and source addresses are truncated to before
+// they are used as record keys.
+const maxNameLength = 256
+
+// Subject is the type of thing failed logins are counted against.
+type Subject string
+
+// Subjects failed logins are counted against
+const (
+	SubjectUser   Subject = "user"
+	SubjectSource Subject = "source"
+)
+
+// Policy contains the limits to apply to failed logins. A maximum of zero disables
+// tracking for that subject.
+type Policy struct {
+	// The number of failures allowed for a username within the window
+	MaxUserFailures int
+	// The number of failures allowed from a source address within the window
+	MaxSourceFailures int
+	// The sliding window failures are counted in
+	Window time.Duration
+	// How long a subject stays locked once it exceeds its maximum
+	Cooldown time.Duration
+}
+
+// Lockout describes a username or source address that is locked out.
+type Lockout struct {
+	// The type of the locked subject
+	Subject Subject
+	// The username or source address that is locked
+	Name string
+	// When the lockout expires
+	Until time.Time
+}
+
+// RetryAfter returns the duration until the lockout expires, rounded up to the second.
+func (l *Lockout) RetryAfter() time.Duration {
+	return time.Until(l.Until).Truncate(time.Second) + time.Second
+}
+
+// record is what is kept in the secrets backend for a single subject.
+type record struct {
+	Failures    []time.Time `json:"failures,omitempty"`
+	LockedUntil time.Time   `json:"lockedUntil,omitempty"`
+}
+
+func (r *record) lockedAt(now time.Time) bool { return r.LockedUntil.After(now) }
+
+// prune drops failures that have fallen outside the window.
+func (r *record) prune(now time.Time, window time.Duration) {
+	failures := make([]time.Time, 0, len(r.Failures))
+	for _, failure := range r.Failures {
+		if now.Sub(failure) < window {
+			failures = append(failures, failure)
+		}
+	}
+	r.Failures = failures
+}
+
+func (r *record) empty(now time.Time) bool { return len(r.Failures) == 0 && !r.lockedAt(now) }
+
+// lastActivity returns the time of the most recent failure or lockout in the record.
+func (r *record) lastActivity() time.Time {
+	last := r.LockedUntil
+	for _, failure := range r.Failures {
+		if failure.After(last) {
+			last = failure
+		}
+	}
+	return last
+}
+
+// evict removes records until there are no more than MaxRecords of them and their encoded
+// size is within MaxRecordsSize. Records that are not locked are evicted first, and the
+// least recently active go first within them.
+func evict(records map[string]*record, data map[string][]byte, now time.Time) {
+	size := secrets.MapSize(data)
+	if len(records) <= MaxRecords && size <= MaxRecordsSize {
+		return
+	}
+	keys := make([]string, 0, len(records))
+	for key := range records {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := records[keys[i]], records[keys[j]]
+		if a.lockedAt(now) != b.lockedAt(now) {
+			return !a.lockedAt(now)
+		}
+		return a.lastActivity().Before(b.lastActivity())
+	})
+	for _, key := range keys {
+		if len(records) <= MaxRecords && size <= MaxRecordsSize {
+			return
+		}
+		size -= secrets.EntrySize(key, data[key])
+		delete(records, key)
+		delete(data, key)
+	}
+}
+
+// Manager is an object for tracking failed logins. It uses the configured secrets
+// backend for storage so that the counts are shared by all app replicas.
+type Manager struct {
+	secrets *secrets.SecretEngine
+	now     func() time.Time
+}
+
+// NewManager returns a new lockout manager with the given secrets engine.
+func NewManager(secrets *secrets.SecretEngine) *Manager {
+	return &Manager{secrets: secrets, now: time.Now}
+}
+
+// Check returns the lockout for the given username or source address if either is
+// currently locked. A nil lockout means the login may proceed.
+func (m *Manager) Check(username, source string) (*Lockout, error) {
+	records, err := m.readRecords()
+	if err != nil {
+		return nil, err
+	}
+	now := m.now()
+	for _, subject := range []struct {
+		subject Subject
+		name    string
+	}{{SubjectUser, username}, {SubjectSource, source}} {
+		if subject.name == "" {
+			continue
+		}
+		if rec, ok := records[recordKey(subject.subject, subject.name)]; ok && rec.lockedAt(now) {
+			return &Lockout{Subject: subject.subject, Name: subject.name, Until: rec.LockedUntil}, nil
+		}
+	}
+	return nil, nil
+}
+
+// RecordFailure records a failed login for the given username and source address.
+// Any subjects that became locked by this failure are returned. Records that no
+// longer hold failures within the window or an active lockout are reaped, and the
+// records are capped at MaxRecords and MaxRecordsSize.
+func (m *Manager) RecordFailure(policy Policy, username, source string) ([]*Lockout, error) {
+	if err := m.secrets.Lock(15); err != nil {
+		return nil, err
+	}
+	defer m.secrets.Release()
+	records, err := m.readRecords()
+	if err != nil {
+		return nil, err
+	}
+	now := m.now()
+	for key, rec := range records {
+		rec.prune(now, policy.Window)
+		if rec.empty(now) {
+			delete(records, key)
+		}
+	}
+	locked := make([]*Lockout, 0)
+	for _, subject := range []struct {
+		subject Subject
+		name    string
+		max     int
+	}{{SubjectUser, username, policy.MaxUserFailures}, {SubjectSource, source, policy.MaxSourceFailures}} {
+		if subject.name == "" || subject.max <= 0 {
+			continue
+		}
+		key := recordKey(subject.subject, subject.name)
+		rec, ok := records[key]
+		if !ok {
+			rec = &record{}
+			records[key] = rec
+		}
+		if rec.lockedAt(now) {
+			continue
+		}
+		rec.Failures = append(rec.Failures, now)
+		if len(rec.Failures) >= subject.max {
+			rec.Failures = nil
+			rec.LockedUntil = now.Add(policy.Cooldown)
+			locked = append(locked, &Lockout{Subject: subject.subject, Name: subject.name, Until: rec.LockedUntil})
+		}
+	}
+	data, err := encodeRecords(records)
+	if err != nil {
+		return nil, err
+	}
+	evict(records, data, now)
+	return locked, m.secrets.WriteSecretMap(v1.LoginFailuresSecretKey, data)
+}
+
+// RecordSuccess clears the failed logins recorded for the given username. Failures
+// from source addresses are left to expire on their own.
+func (m *Manager) RecordSuccess(username string) error {
+	records, err := m.readRecords()
+	if err != nil {
+		return err
+	}
+	if _, ok := records[recordKey(SubjectUser, username)]; !ok {
+		return nil
+	}
+	return m.Unlock(username)
+}
+
+// Unlock removes any lockout and failed logins recorded for the given username.
+func (m *Manager) Unlock(username string) error {
+	if err := m.secrets.Lock(15); err != nil {
+		return err
+	}
+	defer m.secrets.Release()
+	records, err := m.readRecords()
+	if err != nil {
+		return err
+	}
+	key := recordKey(SubjectUser, username)
+	if _, ok := records[key]; !ok {
+		return nil
+	}
+	delete(records, key)
+	return m.writeRecords(records)
+}
+
+// GetLockedUsers returns the usernames that are currently locked out.
+func (m *Manager) GetLockedUsers() ([]*Lockout, error) {
+	records, err := m.readRecords()
+	if err != nil {
+		return nil, err
+	}
+	now := m.now()
+	locked := make([]*Lockout, 0)
+	for key, rec := range records {
+		subject, name := splitRecordKey(key)
+		if subject == SubjectUser && rec.lockedAt(now) {
+			locked = append(locked, &Lockout{Subject: subject, Name: name, Until: rec.LockedUntil})
+		}
+	}
+	return locked, nil
+}
+
+// readRecords reads all failure records from the secrets backend. Reads skip the
+// cache so that failures on other replicas are counted.
+func (m *Manager) readRecords() (map[string]*record, error) {
+	data, err := m.secrets.ReadSecretMap(v1.LoginFailuresSecretKey, false)
+	if err != nil {
+		if errors.IsSecretNotFoundError(err) {
+			return map[string]*record{}, nil
+		}
+		return nil, err
+	}
+	records := make(map[string]*record, len(data))
+	for key, raw := range data {
+		rec := &record{}
+		if err := json.Unmarshal(raw, rec); err != nil {
+			return nil, err
+		}
+		records[key] = rec
+	}
+	return records, nil
+}
+
+func (m *Manager) writeRecords(records map[string]*record) error {
+	data, err := encodeRecords(records)
+	if err != nil {
+		return err
+	}
+	return m.secrets.WriteSecretMap(v1.LoginFailuresSecretKey, data)
+}
+
+func encodeRecords(records map[string]*record) (map[string][]byte, error) {
+	data := make(map[string][]byte, len(records))
+	for key, rec := range records {
+		raw, err := json.Marshal(rec)
+		if err != nil {
+			return nil, err
+		}
+		data[key] = raw
+	}
+	return data, nil
+}
+
+func recordKey(subject Subject, name string) string {
+	if len(name) > maxNameLength {
+		name = name[:maxNameLength]
+	}
+	return fmt.Sprintf("%s/%s", subject, name)
+}
+
+func splitRecordKey(key string) (Subject, string) {
+	spl := strings.SplitN(key, "/", 2)
+	if len(spl) != 2 {
+		return "", key
+	}
+	return Subject(spl[0]), spl[1]
+}
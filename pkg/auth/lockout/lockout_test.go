@@ -0,0 +1,267 @@
+/*
+
+Copyright 2020,2021 Avi Zimmerman
+
+This file is part of kvdi.
+
+kvdi is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+kvdi is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package lockout
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	v1 "github.com/kvdi/kvdi/apis/meta/v1"
+	"github.com/kvdi/kvdi/pkg/secrets"
+)
+
+var testPolicy = Policy{
+	MaxUserFailures:   3,
+	MaxSourceFailures: 5,
+	Window:            time.Minute,
+	Cooldown:          5 * time.Minute,
+}
+
+// testClock is a clock that only moves when told to.
+type testClock struct{ now time.Time }
+
+func (c *testClock) Now() time.Time          { return c.now }
+func (c *testClock) Advance(d time.Duration) { c.now = c.now.Add(d) }
+
+func newTestClock(m *Manager) *testClock {
+	clock := &testClock{now: time.Now()}
+	m.now = clock.Now
+	return clock
+}
+
+func newTestManager(t *testing.T) *Manager {
+	t.Helper()
+	engine, _, err := secrets.NewTestEngine(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewManager(engine)
+}
+
+func mustCheck(t *testing.T, m *Manager, username, source string) *Lockout {
+	t.Helper()
+	lockout, err := m.Check(username, source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return lockout
+}
+
+func mustFail(t *testing.T, m *Manager, username, source string) []*Lockout {
+	t.Helper()
+	locked, err := m.RecordFailure(testPolicy, username, source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return locked
+}
+
+func TestUserLockout(t *testing.T) {
+	m := newTestManager(t)
+	clock := newTestClock(m)
+
+	for i := 0; i < testPolicy.MaxUserFailures-1; i++ {
+		if locked := mustFail(t, m, "alice", "10.0.0.1"); len(locked) != 0 {
+			t.Fatal("Expected no lockouts before the maximum, got", locked)
+		}
+	}
+	if lockout := mustCheck(t, m, "alice", "10.0.0.1"); lockout != nil {
+		t.Fatal("Expected alice to not be locked yet, got", lockout)
+	}
+
+	locked := mustFail(t, m, "alice", "10.0.0.2")
+	if len(locked) != 1 || locked[0].Subject != SubjectUser || locked[0].Name != "alice" {
+		t.Fatal("Expected alice to be locked, got", locked)
+	}
+	// the lockout applies from any address, but not to other users
+	if lockout := mustCheck(t, m, "alice", "10.0.0.3"); lockout == nil || lockout.Subject != SubjectUser {
+		t.Error("Expected alice to be locked, got", lockout)
+	}
+	if lockout := mustCheck(t, m, "bob", "10.0.0.1"); lockout != nil {
+		t.Error("Expected bob to not be locked, got", lockout)
+	}
+	users, err := m.GetLockedUsers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 1 || users[0].Name != "alice" {
+		t.Error("Expected alice to be the only locked user, got", users)
+	}
+
+	// the lockout expires after the cooldown
+	clock.Advance(testPolicy.Cooldown)
+	if lockout := mustCheck(t, m, "alice", "10.0.0.1"); lockout != nil {
+		t.Error("Expected alice lockout to have expired, got", lockout)
+	}
+	if locked := mustFail(t, m, "alice", "10.0.0.1"); len(locked) != 0 {
+		t.Error("Expected failures to start over after a lockout, got", locked)
+	}
+
+	// an administrator can unlock a user early
+	mustFail(t, m, "alice", "10.0.0.1")
+	mustFail(t, m, "alice", "10.0.0.1")
+	if lockout := mustCheck(t, m, "alice", ""); lockout == nil {
+		t.Fatal("Expected alice to be locked again")
+	}
+	if err := m.Unlock("alice"); err != nil {
+		t.Fatal(err)
+	}
+	if lockout := mustCheck(t, m, "alice", ""); lockout != nil {
+		t.Error("Expected alice to be unlocked, got", lockout)
+	}
+}
+
+func TestFailureWindow(t *testing.T) {
+	m := newTestManager(t)
+	clock := newTestClock(m)
+
+	// failures slide out of the window
+	for i := 0; i < testPolicy.MaxUserFailures*2; i++ {
+		if locked := mustFail(t, m, "alice", ""); len(locked) != 0 {
+			t.Fatal("Expected failures outside the window to not count, got", locked)
+		}
+		clock.Advance(testPolicy.Window / time.Duration(testPolicy.MaxUserFailures-1))
+	}
+
+	// a successful login clears the count
+	mustFail(t, m, "bob", "")
+	mustFail(t, m, "bob", "")
+	if err := m.RecordSuccess("bob"); err != nil {
+		t.Fatal(err)
+	}
+	if locked := mustFail(t, m, "bob", ""); len(locked) != 0 {
+		t.Error("Expected a successful login to clear failures, got", locked)
+	}
+}
+
+func TestSourceLockout(t *testing.T) {
+	m := newTestManager(t)
+	newTestClock(m)
+
+	var locked []*Lockout
+	for i := 0; i < testPolicy.MaxSourceFailures; i++ {
+		// spray a different user each time
+		locked = mustFail(t, m, string(rune('a'+i)), "10.0.0.1")
+	}
+	if len(locked) != 1 || locked[0].Subject != SubjectSource || locked[0].Name != "10.0.0.1" {
+		t.Fatal("Expected the source address to be locked, got", locked)
+	}
+	if lockout := mustCheck(t, m, "zed", "10.0.0.1"); lockout == nil || lockout.Subject != SubjectSource {
+		t.Error("Expected logins from the source address to be locked, got", lockout)
+	}
+	if lockout := mustCheck(t, m, "zed", "10.0.0.2"); lockout != nil {
+		t.Error("Expected logins from other addresses to be allowed, got", lockout)
+	}
+	// source lockouts are not listed as locked users
+	users, err := m.GetLockedUsers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 0 {
+		t.Error("Expected no locked users, got", users)
+	}
+}
+
+func TestRecordsAreCapped(t *testing.T) {
+	m := newTestManager(t)
+	clock := newTestClock(m)
+
+	// lock out a user before flooding the records
+	for i := 0; i < testPolicy.MaxUserFailures; i++ {
+		mustFail(t, m, "alice", "")
+	}
+	policy := testPolicy
+	policy.MaxSourceFailures = 0
+	for i := 0; i < MaxRecords+10; i++ {
+		clock.Advance(time.Millisecond)
+		if _, err := m.RecordFailure(policy, fmt.Sprintf("user-%d", i), ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	records, err := m.readRecords()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != MaxRecords {
+		t.Errorf("Expected %d records, got %d", MaxRecords, len(records))
+	}
+	if _, ok := records[recordKey(SubjectUser, "user-0")]; ok {
+		t.Error("Expected the oldest record to be evicted")
+	}
+	if lockout := mustCheck(t, m, "alice", ""); lockout == nil {
+		t.Error("Expected locked records to be kept over older ones")
+	}
+
+	// records are also capped by the space they take up in the secrets backend
+	clock.Advance(testPolicy.Cooldown)
+	padding := strings.Repeat("x", maxNameLength)
+	for i := 0; i < MaxRecords; i++ {
+		clock.Advance(time.Millisecond)
+		if _, err := m.RecordFailure(policy, fmt.Sprintf("%d-%s", i, padding), ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data, err := m.secrets.ReadSecretMap(v1.LoginFailuresSecretKey, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) >= MaxRecords {
+		t.Errorf("Expected large records to be capped below %d records, got %d", MaxRecords, len(data))
+	}
+	if size := secrets.MapSize(data); size > MaxRecordsSize {
+		t.Errorf("Expected records to take up at most %d bytes, got %d", MaxRecordsSize, size)
+	}
+
+	// records are reaped once their failures leave the window
+	clock.Advance(testPolicy.Cooldown)
+	mustFail(t, m, "bob", "")
+	records, err = m.readRecords()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 {
+		t.Errorf("Expected stale records to be reaped, got %d", len(records))
+	}
+}
+
+func TestLongNamesAreTruncated(t *testing.T) {
+	m := newTestManager(t)
+	newTestClock(m)
+	long := strings.Repeat("a", maxNameLength*4)
+	for i := 0; i < testPolicy.MaxUserFailures; i++ {
+		mustFail(t, m, long, "")
+	}
+	if lockout := mustCheck(t, m, long, ""); lockout == nil {
+		t.Error("Expected the user with a long name to be locked")
+	}
+	records, err := m.readRecords()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key := range records {
+		if len(key) > maxNameLength+len(SubjectUser)+1 {
+			t.Error("Expected the record key to be truncated, got length", len(key))
+		}
+	}
+}
@@ -0,0 +1,399 @@
+/*
+
+Copyright 2020,2021 Avi Zimmerman
+
+This file is part of kvdi.
+
+kvdi is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+kvdi is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package logins
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	v1 "github.com/kvdi/kvdi/apis/meta/v1"
+	"github.com/kvdi/kvdi/pkg/secrets"
+	"github.com/kvdi/kvdi/pkg/types"
+	"github.com/kvdi/kvdi/pkg/util/errors"
+)
+
+// revocation is the record kept for a revoked login or user. Access tokens covered by
+// it are rejected until it expires, at which point they have expired on their own.
+type revocation struct {
+	// When the revocation happened
+	RevokedAt time.Time `json:"revokedAt"`
+	// When the revocation can be forgotten
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// revocationCacheTTL is how long revocations read from the secrets backend are used
+// before they are read again. Revocations made on other replicas take effect after at
+// most this long, while ones made locally take effect immediately.
+var revocationCacheTTL = 5 * time.Second
+
+// Manager is an object for tracking user logins and the refresh tokens issued for
+// them. It uses the configured secrets backend for storage.
+type Manager struct {
+	secrets *secrets.SecretEngine
+
+	mu          sync.Mutex
+	revocations map[string]*revocation
+	readAt      time.Time
+}
+
+// NewManager returns a new login manager with the given secrets engine.
+func NewManager(secrets *secrets.SecretEngine) *Manager {
+	return &Manager{secrets: secrets}
+}
+
+// IssueRefreshToken issues a new refresh token for the given login and returns it.
+// A new login is started if the given one does not have an ID yet.
+func (m *Manager) IssueRefreshToken(login *types.UserLogin) (string, error) {
+	now := time.Now().UTC().Truncate(time.Second)
+	if login.ID == "" {
+		id, err := NewLoginID()
+		if err != nil {
+			return "", err
+		}
+		login.ID = id
+	}
+	if login.CreatedAt.IsZero() {
+		login.CreatedAt = now
+	}
+	login.IssuedAt = now
+
+	refreshToken := uuid.New().String()
+	if err := m.secrets.Lock(10); err != nil {
+		return "", err
+	}
+	defer m.secrets.Release()
+	tokens, err := m.readTokens()
+	if err != nil {
+		return "", err
+	}
+	tokens[refreshToken] = login
+	return refreshToken, m.writeTokens(tokens)
+}
+
+// ConsumeRefreshToken removes the given refresh token and returns the login it was
+// issued for.
+func (m *Manager) ConsumeRefreshToken(refreshToken string) (*types.UserLogin, error) {
+	if err := m.secrets.Lock(10); err != nil {
+		return nil, err
+	}
+	defer m.secrets.Release()
+	tokens, err := m.readTokens()
+	if err != nil {
+		return nil, err
+	}
+	login, ok := tokens[refreshToken]
+	if !ok {
+		return nil, errors.New("The refresh token does not exist in the secret storage")
+	}
+	delete(tokens, refreshToken)
+	return login, m.writeTokens(tokens)
+}
+
+// ListLogins returns the active logins for the given user, oldest first.
+func (m *Manager) ListLogins(user string) ([]*types.UserLogin, error) {
+	tokens, err := m.readTokens()
+	if err != nil {
+		return nil, err
+	}
+	out := make([]*types.UserLogin, 0)
+	for _, login := range tokens {
+		if login.User == user {
+			out = append(out, login)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].CreatedAt.Equal(out[j].CreatedAt) {
+			return out[i].ID < out[j].ID
+		}
+		return out[i].CreatedAt.Before(out[j].CreatedAt)
+	})
+	return out, nil
+}
+
+// RevokeLogin removes the refresh token of the given login and rejects the access
+// tokens issued for it for the given duration. A NotFound error is returned if the
+// login does not exist for the given user.
+func (m *Manager) RevokeLogin(user, id string, ttl time.Duration) error {
+	if err := m.secrets.Lock(10); err != nil {
+		return err
+	}
+	defer m.secrets.Release()
+	tokens, err := m.readTokens()
+	if err != nil {
+		return err
+	}
+	var found bool
+	for token, login := range tokens {
+		if login.User == user && login.ID == id {
+			delete(tokens, token)
+			found = true
+		}
+	}
+	if !found {
+		return errors.NewLoginNotFoundError(id)
+	}
+	if err := m.writeTokens(tokens); err != nil {
+		return err
+	}
+	return m.addRevocation(ttl, loginRevocationKey(id))
+}
+
+// RevokeUserLogins removes all refresh tokens for the given user and rejects the
+// access tokens issued to them until now for the given duration.
+func (m *Manager) RevokeUserLogins(user string, ttl time.Duration) error {
+	if err := m.secrets.Lock(10); err != nil {
+		return err
+	}
+	defer m.secrets.Release()
+	tokens, err := m.readTokens()
+	if err != nil {
+		return err
+	}
+	keys := []string{userRevocationKey(user)}
+	for token, login := range tokens {
+		if login.User == user {
+			delete(tokens, token)
+			// Revoking the login itself also covers tokens issued in the same
+			// second as the revocation.
+			keys = append(keys, loginRevocationKey(login.ID))
+		}
+	}
+	if len(keys) > 1 {
+		if err := m.writeTokens(tokens); err != nil {
+			return err
+		}
+	}
+	return m.addRevocation(ttl, keys...)
+}
+
+// MigrateUsernames moves the logins and revocations of users to the names returned by
+// rename. Names it returns false for are left alone.
+func (m *Manager) MigrateUsernames(rename func(string) (string, bool)) error {
+	if err := m.secrets.Lock(15); err != nil {
+		return err
+	}
+	defer m.secrets.Release()
+	tokens, err := m.readTokens()
+	if err != nil {
+		return err
+	}
+	var changed bool
+	for _, login := range tokens {
+		if newName, ok := rename(login.User); ok {
+			login.User = newName
+			changed = true
+		}
+	}
+	if changed {
+		if err := m.writeTokens(tokens); err != nil {
+			return err
+		}
+	}
+
+	revocations, err := m.readRevocations()
+	if err != nil {
+		return err
+	}
+	changed = false
+	for key, rev := range revocations {
+		if !strings.HasPrefix(key, userRevocationKey("")) {
+			continue
+		}
+		newName, ok := rename(strings.TrimPrefix(key, userRevocationKey("")))
+		if !ok {
+			continue
+		}
+		if _, exists := revocations[userRevocationKey(newName)]; exists {
+			continue
+		}
+		revocations[userRevocationKey(newName)] = rev
+		delete(revocations, key)
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+	return m.writeRevocations(revocations)
+}
+
+// IsRevoked returns true if the login the given claims belong to was revoked, or
+// they were issued to their user before all of the user's logins were revoked.
+func (m *Manager) IsRevoked(claims *types.JWTClaims) (bool, error) {
+	revocations, err := m.cachedRevocations()
+	if err != nil {
+		return false, err
+	}
+	now := time.Now()
+	if claims.LoginID != "" {
+		if rev, ok := revocations[loginRevocationKey(claims.LoginID)]; ok && now.Before(rev.ExpiresAt) {
+			return true, nil
+		}
+	}
+	if claims.User != nil {
+		// Tokens issued in the same second as the revocation are allowed, so that a
+		// user can log in again straight away.
+		if rev, ok := revocations[userRevocationKey(claims.User.Name)]; ok && now.Before(rev.ExpiresAt) &&
+			claims.IssuedAt < rev.RevokedAt.Unix() {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// addRevocation records a revocation under the given keys and forgets the ones that
+// have expired. The caller must hold the secrets lock.
+func (m *Manager) addRevocation(ttl time.Duration, keys ...string) error {
+	revocations, err := m.readRevocations()
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC().Truncate(time.Second)
+	for k, rev := range revocations {
+		if !now.Before(rev.ExpiresAt) {
+			delete(revocations, k)
+		}
+	}
+	for _, key := range keys {
+		revocations[key] = &revocation{RevokedAt: now, ExpiresAt: now.Add(ttl)}
+	}
+	return m.writeRevocations(revocations)
+}
+
+// writeRevocations writes the given revocations to the secrets backend and uses them
+// as the cached ones. The caller must hold the secrets lock.
+func (m *Manager) writeRevocations(revocations map[string]*revocation) error {
+	data := make(map[string][]byte, len(revocations))
+	for k, rev := range revocations {
+		raw, err := json.Marshal(rev)
+		if err != nil {
+			return err
+		}
+		data[k] = raw
+	}
+	if err := m.secrets.WriteSecretMap(v1.RevokedLoginsSecretKey, data); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.revocations, m.readAt = revocations, time.Now()
+	return nil
+}
+
+// cachedRevocations returns the revocations read from the secrets backend within the
+// last revocationCacheTTL, reading them again if needed. The returned map must not be
+// modified.
+func (m *Manager) cachedRevocations() (map[string]*revocation, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.readAt.IsZero() && time.Since(m.readAt) < revocationCacheTTL {
+		return m.revocations, nil
+	}
+	revocations, err := m.readRevocations()
+	if err != nil {
+		return nil, err
+	}
+	m.revocations, m.readAt = revocations, time.Now()
+	return revocations, nil
+}
+
+// readRevocations reads all revocations from the secrets backend. Reads skip the secrets
+// cache, revocations are cached by the manager instead.
+func (m *Manager) readRevocations() (map[string]*revocation, error) {
+	data, err := m.secrets.ReadSecretMap(v1.RevokedLoginsSecretKey, false)
+	if err != nil {
+		if errors.IsSecretNotFoundError(err) {
+			return map[string]*revocation{}, nil
+		}
+		return nil, err
+	}
+	revocations := make(map[string]*revocation, len(data))
+	for key, raw := range data {
+		rev := &revocation{}
+		if err := json.Unmarshal(raw, rev); err != nil {
+			return nil, err
+		}
+		revocations[key] = rev
+	}
+	return revocations, nil
+}
+
+// readTokens reads all refresh tokens and their logins from the secrets backend.
+func (m *Manager) readTokens() (map[string]*types.UserLogin, error) {
+	data, err := m.secrets.ReadSecretMap(v1.RefreshTokensSecretKey, false)
+	if err != nil {
+		if errors.IsSecretNotFoundError(err) {
+			return map[string]*types.UserLogin{}, nil
+		}
+		return nil, err
+	}
+	tokens := make(map[string]*types.UserLogin, len(data))
+	for token, raw := range data {
+		login := &types.UserLogin{}
+		if err := json.Unmarshal(raw, login); err != nil {
+			// Refresh tokens issued before logins were tracked only hold the username.
+			login = &types.UserLogin{ID: legacyLoginID(token), User: string(raw)}
+		}
+		tokens[token] = login
+	}
+	return tokens, nil
+}
+
+func (m *Manager) writeTokens(tokens map[string]*types.UserLogin) error {
+	data := make(map[string][]byte, len(tokens))
+	for token, login := range tokens {
+		raw, err := json.Marshal(login)
+		if err != nil {
+			return err
+		}
+		data[token] = raw
+	}
+	return m.secrets.WriteSecretMap(v1.RefreshTokensSecretKey, data)
+}
+
+func loginRevocationKey(id string) string { return "login/" + id }
+
+func userRevocationKey(user string) string { return "user/" + user }
+
+// legacyLoginID derives a stable ID for a refresh token that was issued without one.
+func legacyLoginID(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:8])
+}
+
+// NewLoginID returns a new random ID for a login.
+func NewLoginID() (string, error) { return randomHex(8) }
+
+func randomHex(size int) (string, error) {
+	b := make([]byte, size)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,269 @@
+/*
+
+Copyright 2020,2021 Avi Zimmerman
+
+This file is part of kvdi.
+
+kvdi is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+kvdi is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package logins
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	v1 "github.com/kvdi/kvdi/apis/meta/v1"
+	"github.com/kvdi/kvdi/pkg/secrets"
+	"github.com/kvdi/kvdi/pkg/types"
+	"github.com/kvdi/kvdi/pkg/util/errors"
+)
+
+func newTestManager(t *testing.T) *Manager {
+	t.Helper()
+	engine, _, err := secrets.NewTestEngine(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewManager(engine)
+}
+
+func TestRefreshTokens(t *testing.T) {
+	m := newTestManager(t)
+
+	token, err := m.IssueRefreshToken(&types.UserLogin{User: "alice", SourceIP: "10.0.0.1", UserAgent: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	login, err := m.ConsumeRefreshToken(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if login.ID == "" || login.User != "alice" || login.CreatedAt.IsZero() || login.SourceIP != "10.0.0.1" {
+		t.Fatal("Got unexpected login:", login)
+	}
+	if _, err := m.ConsumeRefreshToken(token); err == nil {
+		t.Error("Expected error consuming a refresh token twice")
+	}
+
+	// Refreshing continues the same login
+	id := login.ID
+	if _, err := m.IssueRefreshToken(login); err != nil {
+		t.Fatal(err)
+	}
+	logins, err := m.ListLogins("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(logins) != 1 || logins[0].ID != id {
+		t.Error("Expected the login to be continued, got:", logins)
+	}
+}
+
+func TestLegacyRefreshTokens(t *testing.T) {
+	m := newTestManager(t)
+
+	if err := m.secrets.WriteSecretMap(v1.RefreshTokensSecretKey, map[string][]byte{"legacy-token": []byte("alice")}); err != nil {
+		t.Fatal(err)
+	}
+	logins, err := m.ListLogins("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(logins) != 1 || logins[0].ID == "" {
+		t.Fatal("Expected the legacy refresh token to be listed, got:", logins)
+	}
+	login, err := m.ConsumeRefreshToken("legacy-token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if login.User != "alice" {
+		t.Error("Expected the legacy refresh token to belong to alice, got:", login.User)
+	}
+}
+
+func TestRevokeLogin(t *testing.T) {
+	m := newTestManager(t)
+
+	first := &types.UserLogin{User: "alice"}
+	if _, err := m.IssueRefreshToken(first); err != nil {
+		t.Fatal(err)
+	}
+	second := &types.UserLogin{User: "alice"}
+	token, err := m.IssueRefreshToken(second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.RevokeLogin("bob", first.ID, time.Minute); !errors.IsLoginNotFoundError(err) {
+		t.Error("Expected not found revoking another user's login, got:", err)
+	}
+	if err := m.RevokeLogin("alice", first.ID, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.RevokeLogin("alice", first.ID, time.Minute); !errors.IsLoginNotFoundError(err) {
+		t.Error("Expected not found revoking a login twice, got:", err)
+	}
+
+	user := &types.VDIUser{Name: "alice"}
+	now := time.Now().Unix()
+	if revoked, err := m.IsRevoked(&types.JWTClaims{User: user, LoginID: first.ID}); err != nil || !revoked {
+		t.Error("Expected access tokens of the revoked login to be revoked, got:", revoked, err)
+	}
+	secondClaims := &types.JWTClaims{User: user, LoginID: second.ID}
+	secondClaims.IssuedAt = now
+	if revoked, err := m.IsRevoked(secondClaims); err != nil || revoked {
+		t.Error("Expected access tokens of other logins to be valid, got:", revoked, err)
+	}
+	if _, err := m.ConsumeRefreshToken(token); err != nil {
+		t.Error("Expected the other login's refresh token to be valid, got:", err)
+	}
+}
+
+func TestRevokeUserLogins(t *testing.T) {
+	m := newTestManager(t)
+
+	login := &types.UserLogin{User: "alice"}
+	token, err := m.IssueRefreshToken(login)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.IssueRefreshToken(&types.UserLogin{User: "bob"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.RevokeUserLogins("alice", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.ConsumeRefreshToken(token); err == nil {
+		t.Error("Expected error using a revoked refresh token")
+	}
+	if logins, err := m.ListLogins("bob"); err != nil || len(logins) != 1 {
+		t.Error("Expected bob's login to be untouched, got:", logins, err)
+	}
+
+	alice := &types.VDIUser{Name: "alice"}
+	claims := []*types.JWTClaims{
+		// access token of the revoked login, issued in the same second
+		{User: alice, LoginID: login.ID},
+		// access token without a login, issued before the revocation
+		{User: alice},
+	}
+	claims[0].IssuedAt = time.Now().Unix()
+	claims[1].IssuedAt = time.Now().Add(-time.Minute).Unix()
+	for _, c := range claims {
+		if revoked, err := m.IsRevoked(c); err != nil || !revoked {
+			t.Error("Expected access token to be revoked, got:", revoked, err)
+		}
+	}
+
+	// tokens issued after the revocation are valid
+	after := &types.JWTClaims{User: alice}
+	after.IssuedAt = time.Now().Add(time.Second).Unix()
+	if revoked, err := m.IsRevoked(after); err != nil || revoked {
+		t.Error("Expected new access tokens to be valid, got:", revoked, err)
+	}
+}
+
+func TestRevocationExpiry(t *testing.T) {
+	m := newTestManager(t)
+
+	login := &types.UserLogin{User: "alice"}
+	if _, err := m.IssueRefreshToken(login); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.RevokeLogin("alice", login.ID, -time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if revoked, err := m.IsRevoked(&types.JWTClaims{LoginID: login.ID}); err != nil || revoked {
+		t.Error("Expected expired revocation to be ignored, got:", revoked, err)
+	}
+
+	// expired revocations are forgotten on the next write
+	if err := m.RevokeUserLogins("bob", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	revocations, err := m.readRevocations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := revocations[loginRevocationKey(login.ID)]; ok || len(revocations) != 1 {
+		t.Error("Expected only bob's revocation to be kept, got:", revocations)
+	}
+}
+
+func TestRevocationCache(t *testing.T) {
+	m := newTestManager(t)
+	other := NewManager(m.secrets)
+
+	claims := &types.JWTClaims{User: &types.VDIUser{Name: "alice"}, LoginID: "login"}
+	claims.IssuedAt = time.Now().Unix()
+	if revoked, err := m.IsRevoked(claims); err != nil || revoked {
+		t.Fatal("Expected access token to be valid, got:", revoked, err)
+	}
+
+	// Revocations from other managers are seen once the cache expires
+	if err := other.addRevocation(time.Minute, loginRevocationKey("login")); err != nil {
+		t.Fatal(err)
+	}
+	if revoked, err := other.IsRevoked(claims); err != nil || !revoked {
+		t.Error("Expected local revocations to take effect immediately, got:", revoked, err)
+	}
+	if revoked, err := m.IsRevoked(claims); err != nil || revoked {
+		t.Error("Expected cached revocations to be used, got:", revoked, err)
+	}
+	m.readAt = time.Now().Add(-revocationCacheTTL)
+	if revoked, err := m.IsRevoked(claims); err != nil || !revoked {
+		t.Error("Expected revocations to be read again after the cache expired, got:", revoked, err)
+	}
+}
+
+func TestMigrateUsernames(t *testing.T) {
+	m := newTestManager(t)
+
+	if err := m.secrets.WriteSecretMap(v1.RefreshTokensSecretKey, map[string][]byte{"legacy-token": []byte("bob")}); err != nil {
+		t.Fatal(err)
+	}
+	token, err := m.IssueRefreshToken(&types.UserLogin{User: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.RevokeUserLogins("carol", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	qualify := func(name string) (string, bool) {
+		if strings.HasPrefix(name, "ldap.") {
+			return "", false
+		}
+		return "ldap." + name, true
+	}
+	if err := m.MigrateUsernames(qualify); err != nil {
+		t.Fatal(err)
+	}
+
+	if login, err := m.ConsumeRefreshToken(token); err != nil || login.User != "ldap.alice" {
+		t.Error("Expected alice's login to be moved, got:", login, err)
+	}
+	if login, err := m.ConsumeRefreshToken("legacy-token"); err != nil || login.User != "ldap.bob" {
+		t.Error("Expected bob's legacy login to be moved, got:", login, err)
+	}
+	claims := &types.JWTClaims{User: &types.VDIUser{Name: "ldap.carol"}}
+	claims.IssuedAt = time.Now().Add(-time.Minute).Unix()
+	if revoked, err := m.IsRevoked(claims); err != nil || !revoked {
+		t.Error("Expected carol's revocation to be moved, got:", revoked, err)
+	}
+}
@@ -22,6 +22,7 @@ package mfa
 import (
 	"bufio"
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"io"
 	"strconv"
@@ -29,11 +30,12 @@ import (
 
 	v1 "github.com/kvdi/kvdi/apis/meta/v1"
 	"github.com/kvdi/kvdi/pkg/secrets"
+	"github.com/kvdi/kvdi/pkg/types"
 	"github.com/kvdi/kvdi/pkg/util/errors"
 )
 
-// Manager is an object for tracking users and their OTP secrets. It uses
-// the configured secrets backend for storage.
+// Manager is an object for tracking users and their OTP secrets and WebAuthn
+// credentials. It uses the configured secrets backend for storage.
 type Manager struct {
 	secrets *secrets.SecretEngine
 }
@@ -74,6 +76,99 @@ func (m *Manager) GetMFAUsers() (map[string]bool, error) {
 	return mfaUsers, nil
 }
 
+// GetUserStatus returns the MFA methods enrolled for the given user. The user
+// is considered verified if they have verified their OTP secret or registered
+// a WebAuthn credential.
+func (m *Manager) GetUserStatus(name string) (*types.UserMFAStatus, error) {
+	status := &types.UserMFAStatus{}
+	_, verified, err := m.GetUserMFAStatus(name)
+	if err != nil && !errors.IsUserNotFoundError(err) {
+		return nil, err
+	}
+	if err == nil {
+		status.Enabled = true
+		status.Verified = verified
+		if verified {
+			status.Methods = append(status.Methods, types.MFAMethodTOTP)
+		}
+	}
+	creds, err := m.readWebAuthnCredentials(name)
+	if err != nil {
+		return nil, err
+	}
+	if len(creds) > 0 {
+		status.Enabled = true
+		status.Verified = true
+		status.Methods = append(status.Methods, types.MFAMethodWebAuthn)
+	}
+	if status.RecoveryCodesRemaining, err = m.GetRecoveryCodesRemaining(name); err != nil {
+		return nil, err
+	}
+	if status.EnrollmentRequired, err = m.EnrollmentRequired(name); err != nil {
+		return nil, err
+	}
+	return status, nil
+}
+
+// GetUsersStatus returns the MFA status of all users with an MFA method
+// configured or a pending enrollment.
+func (m *Manager) GetUsersStatus() (map[string]*types.UserMFAStatus, error) {
+	otpUsers, err := m.GetMFAUsers()
+	if err != nil {
+		return nil, err
+	}
+	webauthnUsers, err := m.readAllWebAuthnCredentials()
+	if err != nil {
+		return nil, err
+	}
+	statuses := make(map[string]*types.UserMFAStatus)
+	for name, verified := range otpUsers {
+		statuses[name] = &types.UserMFAStatus{Enabled: true, Verified: verified}
+		if verified {
+			statuses[name].Methods = []string{types.MFAMethodTOTP}
+		}
+	}
+	for name, creds := range webauthnUsers {
+		if len(creds) == 0 {
+			continue
+		}
+		status, ok := statuses[name]
+		if !ok {
+			status = &types.UserMFAStatus{}
+			statuses[name] = status
+		}
+		status.Enabled = true
+		status.Verified = true
+		status.Methods = append(status.Methods, types.MFAMethodWebAuthn)
+	}
+	recoveryCodes, err := m.readMap(v1.MFARecoveryCodesSecretKey)
+	if err != nil {
+		return nil, err
+	}
+	for name, raw := range recoveryCodes {
+		if status, ok := statuses[name]; ok {
+			hashes := make([]string, 0)
+			if err := json.Unmarshal(raw, &hashes); err != nil {
+				return nil, err
+			}
+			status.RecoveryCodesRemaining = len(hashes)
+		}
+	}
+	resets, err := m.readMap(v1.MFAResetsSecretKey)
+	if err != nil {
+		return nil, err
+	}
+	for name := range resets {
+		status, ok := statuses[name]
+		if !ok {
+			status = &types.UserMFAStatus{}
+			statuses[name] = status
+		}
+		status.EnrollmentRequired = true
+	}
+	return statuses, nil
+}
+
 // GetUserMFAStatus will retrieve the OTP secret for the given user, and
 // whether it has been verified. If there is no secret for this user, a
 // UserNotFound error is returned.
@@ -90,7 +185,7 @@ func (m *Manager) GetUserMFAStatus(name string) (string, bool, error) {
 }
 
 // SetUserMFAStatus sets the value of the user's OTP secret and whether it
-// is verified.
+// is verified. Verifying the secret completes any enrollment required by a reset.
 func (m *Manager) SetUserMFAStatus(name, secret string, verified bool) error {
 	if err := m.secrets.Lock(15); err != nil {
 		return err
@@ -106,7 +201,13 @@ func (m *Manager) SetUserMFAStatus(name, secret string, verified bool) error {
 	if err != nil {
 		return err
 	}
-	return m.secrets.WriteSecret(v1.OTPUsersSecretKey, newData)
+	if err := m.secrets.WriteSecret(v1.OTPUsersSecretKey, newData); err != nil {
+		return err
+	}
+	if verified {
+		return m.deleteMapKey(v1.MFAResetsSecretKey, name)
+	}
+	return nil
 }
 
 // DeleteUserSecret will remove OTP data for the given username.
@@ -128,6 +229,97 @@ func (m *Manager) DeleteUserSecret(name string) error {
 	return m.secrets.WriteSecret(v1.OTPUsersSecretKey, newData)
 }
 
+// MigrateUsernames moves the OTP secrets, WebAuthn credentials, recovery codes and
+// pending enrollments of users to the names returned by rename. Names it returns false
+// for are left alone, as are users that already have data under their new name.
+func (m *Manager) MigrateUsernames(rename func(string) (string, bool)) error {
+	if err := m.secrets.Lock(15); err != nil {
+		return err
+	}
+	defer m.secrets.Release()
+	users, err := m.secrets.ReadSecret(v1.OTPUsersSecretKey, false)
+	if err != nil && !errors.IsSecretNotFoundError(err) {
+		return err
+	}
+	if err == nil {
+		newData, changed, err := renameUsersInReader(rename, bytes.NewReader(users))
+		if err != nil {
+			return err
+		}
+		if changed {
+			if err := m.secrets.WriteSecret(v1.OTPUsersSecretKey, newData); err != nil {
+				return err
+			}
+		}
+	}
+	for _, key := range []string{v1.WebAuthnCredentialsSecretKey, v1.MFARecoveryCodesSecretKey, v1.MFAResetsSecretKey} {
+		data, err := m.readMap(key)
+		if err != nil {
+			return err
+		}
+		if !renameMapKeys(rename, data) {
+			continue
+		}
+		if err := m.secrets.WriteSecretMap(key, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// renameUsersInReader will iterate the given reader, replacing the names of users that
+// rename returns a new name for.
+func renameUsersInReader(rename func(string) (string, bool), rdr io.Reader) ([]byte, bool, error) {
+	scanner := bufio.NewScanner(rdr)
+	lines := make([][]string, 0)
+	names := make(map[string]struct{})
+	for scanner.Scan() {
+		text := scanner.Text()
+		if text == "" {
+			continue
+		}
+		fields := strings.SplitN(text, ":", 2)
+		lines = append(lines, fields)
+		names[fields[0]] = struct{}{}
+	}
+	if err := scanner.Err(); err != nil && err != io.EOF {
+		return nil, false, err
+	}
+
+	var newData bytes.Buffer
+	var changed bool
+	for _, fields := range lines {
+		if newName, ok := rename(fields[0]); ok && len(fields) == 2 {
+			if _, exists := names[newName]; !exists {
+				fields[0], changed = newName, true
+			}
+		}
+		if _, err := newData.WriteString(strings.Join(fields, ":") + "\n"); err != nil {
+			return nil, false, err
+		}
+	}
+	return newData.Bytes(), changed, nil
+}
+
+// renameMapKeys replaces the names of users in a secret map that rename returns a new
+// name for, and returns whether anything changed.
+func renameMapKeys(rename func(string) (string, bool), data map[string][]byte) bool {
+	var changed bool
+	for name, value := range data {
+		newName, ok := rename(name)
+		if !ok {
+			continue
+		}
+		if _, exists := data[newName]; exists {
+			continue
+		}
+		data[newName] = value
+		delete(data, name)
+		changed = true
+	}
+	return changed
+}
+
 // getUserStatusFromReader will scan a given Reader interface for the provided
 // username and return the OTP secret and verification status if found, or a
 // UserNotFound error if the end of the data is reached first.
@@ -0,0 +1,139 @@
+/*
+
+Copyright 2020,2021 Avi Zimmerman
+
+This file is part of kvdi.
+
+kvdi is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+kvdi is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package mfa
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/kvdi/kvdi/pkg/secrets"
+	"github.com/kvdi/kvdi/pkg/types"
+	"github.com/kvdi/kvdi/pkg/util/errors"
+)
+
+func newTestManager(t *testing.T) *Manager {
+	t.Helper()
+	engine, _, err := secrets.NewTestEngine(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewManager(engine)
+}
+
+func TestOTPStatus(t *testing.T) {
+	m := newTestManager(t)
+
+	if _, _, err := m.GetUserMFAStatus("alice"); !errors.IsUserNotFoundError(err) {
+		t.Error("Expected user not found error, got", err)
+	}
+
+	if err := m.SetUserMFAStatus("alice", "secret", false); err != nil {
+		t.Fatal(err)
+	}
+	status, err := m.GetUserStatus("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !status.Enabled || status.Verified || len(status.Methods) != 0 {
+		t.Error("Expected unverified OTP to be enabled without any methods, got", status)
+	}
+
+	if err := m.SetUserMFAStatus("alice", "secret", true); err != nil {
+		t.Fatal(err)
+	}
+	secret, verified, err := m.GetUserMFAStatus("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret != "secret" || !verified {
+		t.Error("Unexpected OTP status:", secret, verified)
+	}
+	users, err := m.GetUsersStatus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status, ok := users["alice"]; !ok || !status.Verified || !reflect.DeepEqual(status.Methods, []string{types.MFAMethodTOTP}) {
+		t.Error("Unexpected MFA status for users:", users)
+	}
+
+	if err := m.DeleteUserSecret("alice"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := m.GetUserMFAStatus("alice"); !errors.IsUserNotFoundError(err) {
+		t.Error("Expected user not found error after deleting secret, got", err)
+	}
+}
+
+func TestMigrateUsernames(t *testing.T) {
+	m := newTestManager(t)
+
+	for name, secret := range map[string]string{"alice": "alice-secret", "bob": "old-secret", "ldap.bob": "bob-secret"} {
+		if err := m.SetUserMFAStatus(name, secret, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := m.GenerateRecoveryCodes("alice"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.ResetUser("carol", "admin"); err != nil {
+		t.Fatal(err)
+	}
+
+	qualify := func(name string) (string, bool) {
+		if _, qualified := map[string]bool{"ldap.alice": true, "ldap.bob": true, "ldap.carol": true}[name]; qualified {
+			return "", false
+		}
+		return "ldap." + name, true
+	}
+	if err := m.MigrateUsernames(qualify); err != nil {
+		t.Fatal(err)
+	}
+
+	if secret, verified, err := m.GetUserMFAStatus("ldap.alice"); err != nil || secret != "alice-secret" || !verified {
+		t.Error("Expected alice's secret to be moved, got:", secret, verified, err)
+	}
+	if remaining, err := m.GetRecoveryCodesRemaining("ldap.alice"); err != nil || remaining != RecoveryCodeCount {
+		t.Error("Expected alice's recovery codes to be moved, got:", remaining, err)
+	}
+	if required, err := m.EnrollmentRequired("ldap.carol"); err != nil || !required {
+		t.Error("Expected carol's pending enrollment to be moved, got:", required, err)
+	}
+	// existing data under the new name is kept
+	if secret, _, err := m.GetUserMFAStatus("ldap.bob"); err != nil || secret != "bob-secret" {
+		t.Error("Expected bob's secret to be kept, got:", secret, err)
+	}
+	users, err := m.GetMFAUsers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := users["alice"]; ok {
+		t.Error("Expected alice's bare name to be gone, got:", users)
+	}
+
+	// migrating again changes nothing
+	if err := m.MigrateUsernames(qualify); err != nil {
+		t.Fatal(err)
+	}
+	if again, err := m.GetMFAUsers(); err != nil || !reflect.DeepEqual(again, users) {
+		t.Error("Expected migrating twice to change nothing, got:", again, err)
+	}
+}
@@ -0,0 +1,221 @@
+/*
+
+Copyright 2020,2021 Avi Zimmerman
+
+This file is part of kvdi.
+
+kvdi is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+kvdi is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package mfa
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"time"
+
+	v1 "github.com/kvdi/kvdi/apis/meta/v1"
+	"github.com/kvdi/kvdi/pkg/util/errors"
+)
+
+// RecoveryCodeCount is the number of recovery codes generated for a user.
+const RecoveryCodeCount = 10
+
+// recoveryCodeEncoding is used for the random part of recovery codes. Codes
+// are lowercase and grouped for readability.
+var recoveryCodeEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// mfaReset is the record kept when an administrator resets a user's MFA.
+type mfaReset struct {
+	// The user who performed the reset
+	ResetBy string `json:"resetBy"`
+	// When the reset happened
+	ResetAt time.Time `json:"resetAt"`
+}
+
+// GenerateRecoveryCodes creates a new set of one-time recovery codes for the
+// given user, replacing any existing ones. Only hashes of the codes are stored,
+// so the returned codes cannot be retrieved again.
+func (m *Manager) GenerateRecoveryCodes(name string) ([]string, error) {
+	codes := make([]string, RecoveryCodeCount)
+	hashes := make([]string, RecoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 10)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		code := strings.ToLower(recoveryCodeEncoding.EncodeToString(b))
+		codes[i] = code[:8] + "-" + code[8:]
+		hashes[i] = hashRecoveryCode(codes[i])
+	}
+	raw, err := json.Marshal(hashes)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.secrets.Lock(15); err != nil {
+		return nil, err
+	}
+	defer m.secrets.Release()
+	if err := m.setMapKey(v1.MFARecoveryCodesSecretKey, name, raw); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// UseRecoveryCode checks the given recovery code for the user and removes it so
+// that it cannot be used again. The number of codes remaining is returned.
+func (m *Manager) UseRecoveryCode(name, code string) (int, error) {
+	if err := m.secrets.Lock(15); err != nil {
+		return 0, err
+	}
+	defer m.secrets.Release()
+	hashes, err := m.readRecoveryCodes(name)
+	if err != nil {
+		return 0, err
+	}
+	hashed := hashRecoveryCode(code)
+	for i, hash := range hashes {
+		if subtle.ConstantTimeCompare([]byte(hash), []byte(hashed)) != 1 {
+			continue
+		}
+		remaining := append(hashes[:i], hashes[i+1:]...)
+		raw, err := json.Marshal(remaining)
+		if err != nil {
+			return 0, err
+		}
+		return len(remaining), m.setMapKey(v1.MFARecoveryCodesSecretKey, name, raw)
+	}
+	return len(hashes), errors.New("The recovery code is invalid or has already been used")
+}
+
+// GetRecoveryCodesRemaining returns the number of unused recovery codes for the user.
+func (m *Manager) GetRecoveryCodesRemaining(name string) (int, error) {
+	hashes, err := m.readRecoveryCodes(name)
+	if err != nil {
+		return 0, err
+	}
+	return len(hashes), nil
+}
+
+// ResetUser removes all MFA enrollments and recovery codes for the given user and
+// requires them to enroll again before their next login can be authorized.
+func (m *Manager) ResetUser(name, resetBy string) error {
+	if err := m.DeleteUserSecret(name); err != nil {
+		return err
+	}
+	if err := m.DeleteUserWebAuthnCredentials(name); err != nil {
+		return err
+	}
+	raw, err := json.Marshal(&mfaReset{ResetBy: resetBy, ResetAt: time.Now().UTC().Truncate(time.Second)})
+	if err != nil {
+		return err
+	}
+	if err := m.secrets.Lock(15); err != nil {
+		return err
+	}
+	defer m.secrets.Release()
+	if err := m.deleteMapKey(v1.MFARecoveryCodesSecretKey, name); err != nil {
+		return err
+	}
+	return m.setMapKey(v1.MFAResetsSecretKey, name, raw)
+}
+
+// DeleteUserRecoveryData removes the recovery codes and any pending reset for the
+// given user.
+func (m *Manager) DeleteUserRecoveryData(name string) error {
+	if err := m.secrets.Lock(15); err != nil {
+		return err
+	}
+	defer m.secrets.Release()
+	if err := m.deleteMapKey(v1.MFARecoveryCodesSecretKey, name); err != nil {
+		return err
+	}
+	return m.deleteMapKey(v1.MFAResetsSecretKey, name)
+}
+
+// EnrollmentRequired returns true if the user's MFA was reset and they have not
+// enrolled again.
+func (m *Manager) EnrollmentRequired(name string) (bool, error) {
+	resets, err := m.readMap(v1.MFAResetsSecretKey)
+	if err != nil {
+		return false, err
+	}
+	_, ok := resets[name]
+	return ok, nil
+}
+
+func (m *Manager) readRecoveryCodes(name string) ([]string, error) {
+	data, err := m.readMap(v1.MFARecoveryCodesSecretKey)
+	if err != nil {
+		return nil, err
+	}
+	raw, ok := data[name]
+	if !ok {
+		return []string{}, nil
+	}
+	hashes := make([]string, 0)
+	if err := json.Unmarshal(raw, &hashes); err != nil {
+		return nil, err
+	}
+	return hashes, nil
+}
+
+// readMap reads a secret map, returning an empty one if it does not exist yet.
+// Reads skip the cache so that changes take effect on all replicas immediately.
+func (m *Manager) readMap(key string) (map[string][]byte, error) {
+	data, err := m.secrets.ReadSecretMap(key, false)
+	if err != nil {
+		if errors.IsSecretNotFoundError(err) {
+			return map[string][]byte{}, nil
+		}
+		return nil, err
+	}
+	return data, nil
+}
+
+// setMapKey sets a single key in a secret map. The caller must hold the lock.
+func (m *Manager) setMapKey(key, name string, value []byte) error {
+	data, err := m.readMap(key)
+	if err != nil {
+		return err
+	}
+	data[name] = value
+	return m.secrets.WriteSecretMap(key, data)
+}
+
+// deleteMapKey removes a single key from a secret map. The caller must hold the lock.
+func (m *Manager) deleteMapKey(key, name string) error {
+	data, err := m.readMap(key)
+	if err != nil {
+		return err
+	}
+	if _, ok := data[name]; !ok {
+		return nil
+	}
+	delete(data, name)
+	return m.secrets.WriteSecretMap(key, data)
+}
+
+// hashRecoveryCode returns the hex encoded SHA-256 hash of a normalized recovery code.
+func hashRecoveryCode(code string) string {
+	normalized := strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
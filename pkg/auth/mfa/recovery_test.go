@@ -0,0 +1,147 @@
+/*
+
+Copyright 2020,2021 Avi Zimmerman
+
+This file is part of kvdi.
+
+kvdi is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+kvdi is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package mfa
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRecoveryCodes(t *testing.T) {
+	m := newTestManager(t)
+
+	if _, err := m.UseRecoveryCode("alice", "abcd-efgh"); err == nil {
+		t.Error("Expected error using a recovery code before any were generated")
+	}
+
+	codes, err := m.GenerateRecoveryCodes("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != RecoveryCodeCount {
+		t.Fatal("Expected", RecoveryCodeCount, "codes, got", codes)
+	}
+
+	// codes are normalized before being checked
+	remaining, err := m.UseRecoveryCode("alice", strings.ToUpper(strings.Replace(codes[3], "-", " ", 1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if remaining != RecoveryCodeCount-1 {
+		t.Error("Expected", RecoveryCodeCount-1, "codes remaining, got", remaining)
+	}
+	if _, err := m.UseRecoveryCode("alice", codes[3]); err == nil {
+		t.Error("Expected error reusing a recovery code")
+	}
+	if _, err := m.UseRecoveryCode("bob", codes[4]); err == nil {
+		t.Error("Expected error using another user's recovery code")
+	}
+
+	status, err := m.GetUserStatus("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.RecoveryCodesRemaining != RecoveryCodeCount-1 {
+		t.Error("Expected status to report remaining codes, got", status)
+	}
+
+	// generating new codes invalidates the old ones
+	if _, err := m.GenerateRecoveryCodes("alice"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.UseRecoveryCode("alice", codes[4]); err == nil {
+		t.Error("Expected error using a replaced recovery code")
+	}
+}
+
+func TestResetUser(t *testing.T) {
+	m := newTestManager(t)
+
+	if err := m.SetUserMFAStatus("alice", "secret", true); err != nil {
+		t.Fatal(err)
+	}
+	codes, err := m.GenerateRecoveryCodes("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.ResetUser("alice", "admin"); err != nil {
+		t.Fatal(err)
+	}
+	status, err := m.GetUserStatus("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Verified || !status.EnrollmentRequired || status.RecoveryCodesRemaining != 0 {
+		t.Error("Expected MFA to be cleared and enrollment required after a reset, got", status)
+	}
+	if _, err := m.UseRecoveryCode("alice", codes[0]); err == nil {
+		t.Error("Expected recovery codes to be removed by a reset")
+	}
+	users, err := m.GetUsersStatus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status, ok := users["alice"]; !ok || !status.EnrollmentRequired {
+		t.Error("Expected users status to report the pending enrollment, got", users)
+	}
+
+	// setting up a new secret does not complete enrollment until it is verified
+	if err := m.SetUserMFAStatus("alice", "new-secret", false); err != nil {
+		t.Fatal(err)
+	}
+	if required, err := m.EnrollmentRequired("alice"); err != nil {
+		t.Fatal(err)
+	} else if !required {
+		t.Error("Expected enrollment to still be required before verification")
+	}
+	if err := m.SetUserMFAStatus("alice", "new-secret", true); err != nil {
+		t.Fatal(err)
+	}
+	if required, err := m.EnrollmentRequired("alice"); err != nil {
+		t.Fatal(err)
+	} else if required {
+		t.Error("Expected enrollment to be complete after verification")
+	}
+
+	// registering a WebAuthn credential also completes enrollment
+	if err := m.ResetUser("alice", "admin"); err != nil {
+		t.Fatal(err)
+	}
+	authn := newTestAuthenticator(t)
+	opts, err := m.BeginWebAuthnRegistration("alice", testRP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.FinishWebAuthnRegistration("alice", authn.register(t, opts, "yubikey")); err != nil {
+		t.Fatal(err)
+	}
+	if required, err := m.EnrollmentRequired("alice"); err != nil {
+		t.Fatal(err)
+	} else if required {
+		t.Error("Expected enrollment to be complete after registering a credential")
+	}
+
+	if err := m.DeleteUserRecoveryData("alice"); err != nil {
+		t.Fatal(err)
+	}
+}
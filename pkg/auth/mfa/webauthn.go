@@ -0,0 +1,557 @@
+/*
+
+Copyright 2020,2021 Avi Zimmerman
+
+This file is part of kvdi.
+
+kvdi is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+kvdi is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package mfa
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/protocol/webauthncose"
+
+	v1 "github.com/kvdi/kvdi/apis/meta/v1"
+	"github.com/kvdi/kvdi/pkg/types"
+	"github.com/kvdi/kvdi/pkg/util/errors"
+)
+
+// WebAuthnTimeout is how long a client has to complete a WebAuthn ceremony.
+const WebAuthnTimeout = 5 * time.Minute
+
+// webauthnRPName is the relying party name presented to authenticators.
+const webauthnRPName = "kVDI"
+
+// supportedCOSEAlgorithms are the algorithms offered to authenticators during
+// registration, in order of preference.
+var supportedCOSEAlgorithms = []webauthncose.COSEAlgorithmIdentifier{
+	webauthncose.AlgES256,
+	webauthncose.AlgEdDSA,
+	webauthncose.AlgRS256,
+}
+
+// webauthnCredential is the record kept for a WebAuthn credential in the secrets backend.
+type webauthnCredential struct {
+	types.WebAuthnCredential `json:",inline"`
+	// The COSE encoded public key of the credential
+	PublicKey []byte `json:"publicKey"`
+	// The last signature counter reported by the authenticator
+	SignCount uint32 `json:"signCount"`
+}
+
+// RelyingParty identifies kVDI to WebAuthn authenticators.
+type RelyingParty struct {
+	// The relying party ID credentials are scoped to
+	ID string
+	// The origins ceremonies are accepted from
+	Origins []string
+}
+
+// webauthnChallenge is a pending ceremony for a user.
+type webauthnChallenge struct {
+	// The raw challenge
+	Challenge []byte `json:"challenge"`
+	// The relying party ID the ceremony was started for
+	RPID string `json:"rpId"`
+	// The origins the ceremony is accepted from
+	Origins []string `json:"origins"`
+	// When the ceremony expires
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// GetWebAuthnCredentials returns the WebAuthn credentials registered for the given user,
+// oldest first.
+func (m *Manager) GetWebAuthnCredentials(name string) ([]*types.WebAuthnCredential, error) {
+	creds, err := m.readWebAuthnCredentials(name)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]*types.WebAuthnCredential, len(creds))
+	for i, cred := range creds {
+		details := cred.WebAuthnCredential
+		out[i] = &details
+	}
+	return out, nil
+}
+
+// BeginWebAuthnRegistration starts the registration of a new WebAuthn credential for the
+// given user and returns the options to pass to the authenticator.
+func (m *Manager) BeginWebAuthnRegistration(name string, rp RelyingParty) (*types.WebAuthnCreationOptions, error) {
+	creds, err := m.readWebAuthnCredentials(name)
+	if err != nil {
+		return nil, err
+	}
+	challenge, err := m.newWebAuthnChallenge(protocol.CreateCeremony, name, rp)
+	if err != nil {
+		return nil, err
+	}
+	opts := &types.WebAuthnCreationOptions{
+		Challenge: encodeBase64URL(challenge),
+		RP: types.WebAuthnRelyingParty{
+			ID:   rp.ID,
+			Name: webauthnRPName,
+		},
+		User: types.WebAuthnUserEntity{
+			ID:          encodeBase64URL(webauthnUserHandle(name)),
+			Name:        name,
+			DisplayName: name,
+		},
+		PubKeyCredParams:   make([]types.WebAuthnCredentialParameters, len(supportedCOSEAlgorithms)),
+		Timeout:            WebAuthnTimeout.Milliseconds(),
+		ExcludeCredentials: credentialDescriptors(creds),
+		Attestation:        "none",
+	}
+	for i, alg := range supportedCOSEAlgorithms {
+		opts.PubKeyCredParams[i] = types.WebAuthnCredentialParameters{Type: "public-key", Alg: int(alg)}
+	}
+	return opts, nil
+}
+
+// FinishWebAuthnRegistration verifies the response to a registration ceremony and stores
+// the new credential for the given user. Authenticators are asked not to provide an
+// attestation statement, but one that is provided must be valid.
+func (m *Manager) FinishWebAuthnRegistration(name string, req *types.RegisterWebAuthnRequest) (*types.WebAuthnCredential, error) {
+	challenge, err := m.consumeWebAuthnChallenge(protocol.CreateCeremony, name)
+	if err != nil {
+		return nil, err
+	}
+	ccr, err := creationResponse(req)
+	if err != nil {
+		return nil, err
+	}
+	parsed, err := ccr.Parse()
+	if err != nil {
+		return nil, webauthnError(err)
+	}
+	if err := parsed.Verify(encodeBase64URL(challenge.Challenge), false, challenge.RPID, challenge.Origins); err != nil {
+		return nil, webauthnError(err)
+	}
+	authData := parsed.Response.AttestationObject.AuthData
+	if !bytes.Equal(ccr.RawID, authData.AttData.CredentialID) {
+		return nil, errors.New("The credential ID does not match the authenticator data")
+	}
+	if err := verifyCredentialPublicKey(authData.AttData.CredentialPublicKey); err != nil {
+		return nil, err
+	}
+
+	record := &webauthnCredential{
+		WebAuthnCredential: types.WebAuthnCredential{
+			ID:        encodeBase64URL(authData.AttData.CredentialID),
+			Name:      req.Name,
+			CreatedAt: time.Now().UTC().Truncate(time.Second),
+		},
+		PublicKey: authData.AttData.CredentialPublicKey,
+		SignCount: authData.Counter,
+	}
+
+	if err := m.secrets.Lock(15); err != nil {
+		return nil, err
+	}
+	defer m.secrets.Release()
+	allCreds, err := m.readAllWebAuthnCredentials()
+	if err != nil {
+		return nil, err
+	}
+	for _, creds := range allCreds {
+		for _, existing := range creds {
+			if existing.ID == record.ID {
+				return nil, errors.New("The credential is already registered")
+			}
+		}
+	}
+	for _, existing := range allCreds[name] {
+		if existing.Name == record.Name {
+			return nil, fmt.Errorf("A credential named '%s' already exists for %s", record.Name, name)
+		}
+	}
+	allCreds[name] = append(allCreds[name], record)
+	if err := m.writeAllWebAuthnCredentials(allCreds); err != nil {
+		return nil, err
+	}
+	// registering a credential completes any enrollment required by a reset
+	if err := m.deleteMapKey(v1.MFAResetsSecretKey, name); err != nil {
+		return nil, err
+	}
+	details := record.WebAuthnCredential
+	return &details, nil
+}
+
+// BeginWebAuthnLogin starts an assertion ceremony for the given user and returns the
+// options to pass to the authenticator. A UserNotFound error is returned if the user has
+// no WebAuthn credentials.
+func (m *Manager) BeginWebAuthnLogin(name string, rp RelyingParty) (*types.WebAuthnRequestOptions, error) {
+	creds, err := m.readWebAuthnCredentials(name)
+	if err != nil {
+		return nil, err
+	}
+	if len(creds) == 0 {
+		return nil, errors.NewUserNotFoundError(name)
+	}
+	challenge, err := m.newWebAuthnChallenge(protocol.AssertCeremony, name, rp)
+	if err != nil {
+		return nil, err
+	}
+	return &types.WebAuthnRequestOptions{
+		Challenge:        encodeBase64URL(challenge),
+		Timeout:          WebAuthnTimeout.Milliseconds(),
+		RPID:             rp.ID,
+		AllowCredentials: credentialDescriptors(creds),
+		UserVerification: "preferred",
+	}, nil
+}
+
+// FinishWebAuthnLogin verifies an assertion from one of the given user's credentials
+// against the challenge from BeginWebAuthnLogin.
+func (m *Manager) FinishWebAuthnLogin(name string, assertion *types.WebAuthnAssertion) error {
+	challenge, err := m.consumeWebAuthnChallenge(protocol.AssertCeremony, name)
+	if err != nil {
+		return err
+	}
+	car, err := assertionResponse(assertion)
+	if err != nil {
+		return err
+	}
+	if len(car.AssertionResponse.UserHandle) > 0 && !bytes.Equal(car.AssertionResponse.UserHandle, webauthnUserHandle(name)) {
+		return errors.New("The credential does not belong to the user")
+	}
+	parsed, err := car.Parse()
+	if err != nil {
+		return webauthnError(err)
+	}
+
+	if err := m.secrets.Lock(15); err != nil {
+		return err
+	}
+	defer m.secrets.Release()
+	allCreds, err := m.readAllWebAuthnCredentials()
+	if err != nil {
+		return err
+	}
+	var cred *webauthnCredential
+	for _, c := range allCreds[name] {
+		if c.ID == strings.TrimRight(assertion.ID, "=") {
+			cred = c
+			break
+		}
+	}
+	if cred == nil {
+		return errors.NewWebAuthnCredentialNotFoundError(assertion.ID)
+	}
+	if err := parsed.Verify(encodeBase64URL(challenge.Challenge), challenge.RPID, challenge.Origins, "", false, cred.PublicKey); err != nil {
+		return webauthnError(err)
+	}
+	// A counter that does not increase may mean the authenticator was cloned.
+	// Authenticators that do not implement a counter always report zero.
+	signCount := parsed.Response.AuthenticatorData.Counter
+	if (signCount != 0 || cred.SignCount != 0) && signCount <= cred.SignCount {
+		return errors.New("The authenticator signature counter did not increase")
+	}
+	now := time.Now().UTC().Truncate(time.Second)
+	cred.SignCount = signCount
+	cred.LastUsedAt = &now
+	return m.writeAllWebAuthnCredentials(allCreds)
+}
+
+// DeleteWebAuthnCredential removes the credential with the given ID for the user. A
+// NotFound error is returned if the user has no such credential.
+func (m *Manager) DeleteWebAuthnCredential(name, id string) error {
+	if err := m.secrets.Lock(15); err != nil {
+		return err
+	}
+	defer m.secrets.Release()
+	allCreds, err := m.readAllWebAuthnCredentials()
+	if err != nil {
+		return err
+	}
+	creds := allCreds[name]
+	for i, cred := range creds {
+		if cred.ID == strings.TrimRight(id, "=") {
+			allCreds[name] = append(creds[:i], creds[i+1:]...)
+			if len(allCreds[name]) == 0 {
+				delete(allCreds, name)
+			}
+			return m.writeAllWebAuthnCredentials(allCreds)
+		}
+	}
+	return errors.NewWebAuthnCredentialNotFoundError(id)
+}
+
+// DeleteUserWebAuthnCredentials removes all WebAuthn credentials for the given user.
+func (m *Manager) DeleteUserWebAuthnCredentials(name string) error {
+	if err := m.secrets.Lock(15); err != nil {
+		return err
+	}
+	defer m.secrets.Release()
+	allCreds, err := m.readAllWebAuthnCredentials()
+	if err != nil {
+		return err
+	}
+	if _, ok := allCreds[name]; !ok {
+		return nil
+	}
+	delete(allCreds, name)
+	return m.writeAllWebAuthnCredentials(allCreds)
+}
+
+// newWebAuthnChallenge generates a challenge for a ceremony and stores it for the user,
+// replacing any pending ceremony of the same type.
+func (m *Manager) newWebAuthnChallenge(ceremony protocol.CeremonyType, name string, rp RelyingParty) ([]byte, error) {
+	if rp.ID == "" || len(rp.Origins) == 0 {
+		return nil, errors.New("WebAuthn is not configured for this cluster")
+	}
+	challenge := make([]byte, 32)
+	if _, err := rand.Read(challenge); err != nil {
+		return nil, err
+	}
+	raw, err := json.Marshal(&webauthnChallenge{
+		Challenge: challenge,
+		RPID:      rp.ID,
+		Origins:   rp.Origins,
+		ExpiresAt: time.Now().Add(WebAuthnTimeout),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := m.secrets.Lock(15); err != nil {
+		return nil, err
+	}
+	defer m.secrets.Release()
+	challenges, err := m.readWebAuthnChallenges()
+	if err != nil {
+		return nil, err
+	}
+	// prune abandoned ceremonies while we are here
+	for key, data := range challenges {
+		existing := &webauthnChallenge{}
+		if err := json.Unmarshal(data, existing); err != nil || time.Now().After(existing.ExpiresAt) {
+			delete(challenges, key)
+		}
+	}
+	challenges[challengeKey(ceremony, name)] = raw
+	if err := m.secrets.WriteSecretMap(v1.WebAuthnChallengesSecretKey, challenges); err != nil {
+		return nil, err
+	}
+	return challenge, nil
+}
+
+// consumeWebAuthnChallenge removes and returns the pending ceremony of the given type
+// for the user. A challenge can only be used once, whether or not the ceremony succeeds.
+func (m *Manager) consumeWebAuthnChallenge(ceremony protocol.CeremonyType, name string) (*webauthnChallenge, error) {
+	if err := m.secrets.Lock(15); err != nil {
+		return nil, err
+	}
+	defer m.secrets.Release()
+	challenges, err := m.readWebAuthnChallenges()
+	if err != nil {
+		return nil, err
+	}
+	key := challengeKey(ceremony, name)
+	raw, ok := challenges[key]
+	if !ok {
+		return nil, errors.New("There is no pending WebAuthn request for the user")
+	}
+	delete(challenges, key)
+	if err := m.secrets.WriteSecretMap(v1.WebAuthnChallengesSecretKey, challenges); err != nil {
+		return nil, err
+	}
+	challenge := &webauthnChallenge{}
+	if err := json.Unmarshal(raw, challenge); err != nil {
+		return nil, err
+	}
+	if time.Now().After(challenge.ExpiresAt) {
+		return nil, errors.New("The WebAuthn request has expired")
+	}
+	return challenge, nil
+}
+
+func (m *Manager) readWebAuthnChallenges() (map[string][]byte, error) {
+	challenges, err := m.secrets.ReadSecretMap(v1.WebAuthnChallengesSecretKey, false)
+	if err != nil {
+		if errors.IsSecretNotFoundError(err) {
+			return map[string][]byte{}, nil
+		}
+		return nil, err
+	}
+	return challenges, nil
+}
+
+// readWebAuthnCredentials reads the credentials for a single user, oldest first.
+func (m *Manager) readWebAuthnCredentials(name string) ([]*webauthnCredential, error) {
+	allCreds, err := m.readAllWebAuthnCredentials()
+	if err != nil {
+		return nil, err
+	}
+	creds := allCreds[name]
+	sort.Slice(creds, func(i, j int) bool { return creds[i].CreatedAt.Before(creds[j].CreatedAt) })
+	return creds, nil
+}
+
+// readAllWebAuthnCredentials reads the credentials for all users from the secrets backend.
+// Reads skip the cache so that removed credentials stop working on all replicas immediately.
+func (m *Manager) readAllWebAuthnCredentials() (map[string][]*webauthnCredential, error) {
+	data, err := m.secrets.ReadSecretMap(v1.WebAuthnCredentialsSecretKey, false)
+	if err != nil {
+		if errors.IsSecretNotFoundError(err) {
+			return map[string][]*webauthnCredential{}, nil
+		}
+		return nil, err
+	}
+	allCreds := make(map[string][]*webauthnCredential, len(data))
+	for user, raw := range data {
+		creds := make([]*webauthnCredential, 0)
+		if err := json.Unmarshal(raw, &creds); err != nil {
+			return nil, err
+		}
+		allCreds[user] = creds
+	}
+	return allCreds, nil
+}
+
+func (m *Manager) writeAllWebAuthnCredentials(allCreds map[string][]*webauthnCredential) error {
+	data := make(map[string][]byte, len(allCreds))
+	for user, creds := range allCreds {
+		raw, err := json.Marshal(creds)
+		if err != nil {
+			return err
+		}
+		data[user] = raw
+	}
+	return m.secrets.WriteSecretMap(v1.WebAuthnCredentialsSecretKey, data)
+}
+
+// creationResponse converts a registration request into the response the authenticator
+// returned to the browser.
+func creationResponse(req *types.RegisterWebAuthnRequest) (*protocol.CredentialCreationResponse, error) {
+	rawID, err := decodeBase64URL(req.ID)
+	if err != nil {
+		return nil, err
+	}
+	clientData, err := decodeBase64URL(req.Response.ClientDataJSON)
+	if err != nil {
+		return nil, err
+	}
+	attestation, err := decodeBase64URL(req.Response.AttestationObject)
+	if err != nil {
+		return nil, err
+	}
+	ccr := &protocol.CredentialCreationResponse{}
+	ccr.ID = encodeBase64URL(rawID)
+	ccr.Type = "public-key"
+	ccr.RawID = rawID
+	ccr.AttestationResponse.ClientDataJSON = clientData
+	ccr.AttestationResponse.AttestationObject = attestation
+	return ccr, nil
+}
+
+// assertionResponse converts an assertion into the response the authenticator returned
+// to the browser.
+func assertionResponse(assertion *types.WebAuthnAssertion) (*protocol.CredentialAssertionResponse, error) {
+	rawID, err := decodeBase64URL(assertion.ID)
+	if err != nil {
+		return nil, err
+	}
+	car := &protocol.CredentialAssertionResponse{}
+	car.ID = encodeBase64URL(rawID)
+	car.Type = "public-key"
+	car.RawID = rawID
+	for _, field := range []struct {
+		data string
+		dest *protocol.URLEncodedBase64
+	}{
+		{assertion.Response.ClientDataJSON, &car.AssertionResponse.ClientDataJSON},
+		{assertion.Response.AuthenticatorData, &car.AssertionResponse.AuthenticatorData},
+		{assertion.Response.Signature, &car.AssertionResponse.Signature},
+		{assertion.Response.UserHandle, &car.AssertionResponse.UserHandle},
+	} {
+		raw, err := decodeBase64URL(field.data)
+		if err != nil {
+			return nil, err
+		}
+		*field.dest = raw
+	}
+	return car, nil
+}
+
+// verifyCredentialPublicKey checks that a new credential uses one of the algorithms
+// offered during registration.
+func verifyCredentialPublicKey(key []byte) error {
+	parsed, err := webauthncose.ParsePublicKey(key)
+	if err != nil {
+		return webauthnError(err)
+	}
+	var alg webauthncose.COSEAlgorithmIdentifier
+	switch pub := parsed.(type) {
+	case webauthncose.EC2PublicKeyData:
+		alg = webauthncose.COSEAlgorithmIdentifier(pub.Algorithm)
+	case webauthncose.OKPPublicKeyData:
+		alg = webauthncose.COSEAlgorithmIdentifier(pub.Algorithm)
+	case webauthncose.RSAPublicKeyData:
+		alg = webauthncose.COSEAlgorithmIdentifier(pub.Algorithm)
+	}
+	for _, supported := range supportedCOSEAlgorithms {
+		if alg == supported {
+			return nil
+		}
+	}
+	return fmt.Errorf("Unsupported credential algorithm %d", alg)
+}
+
+// webauthnError flattens the details of an error from the WebAuthn protocol package.
+func webauthnError(err error) error {
+	if perr, ok := err.(*protocol.Error); ok {
+		if perr.DevInfo != "" {
+			return fmt.Errorf("%s: %s", perr.Details, perr.DevInfo)
+		}
+		return errors.New(perr.Details)
+	}
+	return err
+}
+
+func credentialDescriptors(creds []*webauthnCredential) []types.WebAuthnCredentialDescriptor {
+	out := make([]types.WebAuthnCredentialDescriptor, len(creds))
+	for i, cred := range creds {
+		out[i] = types.WebAuthnCredentialDescriptor{Type: "public-key", ID: cred.ID}
+	}
+	return out
+}
+
+// webauthnUserHandle returns the opaque user handle for the given user.
+func webauthnUserHandle(name string) []byte {
+	sum := sha256.Sum256([]byte(name))
+	return sum[:]
+}
+
+func challengeKey(ceremony protocol.CeremonyType, name string) string {
+	return string(ceremony) + "/" + name
+}
+
+func encodeBase64URL(data []byte) string { return base64.RawURLEncoding.EncodeToString(data) }
+
+// decodeBase64URL decodes base64url data with or without padding.
+func decodeBase64URL(data string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(data, "="))
+}
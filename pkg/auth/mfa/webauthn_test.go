@@ -0,0 +1,365 @@
+/*
+
+Copyright 2020,2021 Avi Zimmerman
+
+This file is part of kvdi.
+
+kvdi is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+kvdi is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package mfa
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/protocol/webauthncbor"
+	"github.com/go-webauthn/webauthn/protocol/webauthncose"
+
+	"github.com/kvdi/kvdi/pkg/types"
+	"github.com/kvdi/kvdi/pkg/util/errors"
+)
+
+const (
+	testRPID   = "kvdi.example.com"
+	testOrigin = "https://kvdi.example.com"
+)
+
+var testRP = RelyingParty{ID: testRPID, Origins: []string{testOrigin}}
+
+// testAuthenticator is a software authenticator with a single ES256 credential.
+type testAuthenticator struct {
+	key     *ecdsa.PrivateKey
+	alg     webauthncose.COSEAlgorithmIdentifier
+	id      []byte
+	counter uint32
+}
+
+func newTestAuthenticator(t *testing.T) *testAuthenticator {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		t.Fatal(err)
+	}
+	return &testAuthenticator{key: key, alg: webauthncose.AlgES256, id: id}
+}
+
+func (a *testAuthenticator) authData(t *testing.T, rpID string, attested bool) []byte {
+	t.Helper()
+	rpIDHash := sha256.Sum256([]byte(rpID))
+	data := append([]byte{}, rpIDHash[:]...)
+	flags := protocol.FlagUserPresent
+	if attested {
+		flags |= protocol.FlagAttestedCredentialData
+	}
+	data = append(data, byte(flags))
+	data = binary.BigEndian.AppendUint32(data, a.counter)
+	if attested {
+		data = append(data, make([]byte, 16)...)
+		data = binary.BigEndian.AppendUint16(data, uint16(len(a.id)))
+		data = append(data, a.id...)
+		data = append(data, testCBOR(t, &webauthncose.EC2PublicKeyData{
+			PublicKeyData: webauthncose.PublicKeyData{
+				KeyType:   int64(webauthncose.EllipticKey),
+				Algorithm: int64(a.alg),
+			},
+			Curve:  int64(webauthncose.P256),
+			XCoord: a.key.X.FillBytes(make([]byte, 32)),
+			YCoord: a.key.Y.FillBytes(make([]byte, 32)),
+		})...)
+	}
+	return data
+}
+
+func testClientData(t *testing.T, typ protocol.CeremonyType, challenge, origin string) []byte {
+	t.Helper()
+	data, err := json.Marshal(&protocol.CollectedClientData{Type: typ, Challenge: challenge, Origin: origin})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func testCBOR(t *testing.T, v interface{}) []byte {
+	t.Helper()
+	data, err := webauthncbor.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func (a *testAuthenticator) register(t *testing.T, opts *types.WebAuthnCreationOptions, name string) *types.RegisterWebAuthnRequest {
+	t.Helper()
+	attestation := testCBOR(t, map[string]interface{}{
+		"fmt":      "none",
+		"attStmt":  map[string]interface{}{},
+		"authData": a.authData(t, opts.RP.ID, true),
+	})
+	return &types.RegisterWebAuthnRequest{
+		Name: name,
+		ID:   encodeBase64URL(a.id),
+		Response: types.WebAuthnAttestationResponse{
+			ClientDataJSON:    encodeBase64URL(testClientData(t, protocol.CreateCeremony, opts.Challenge, testOrigin)),
+			AttestationObject: encodeBase64URL(attestation),
+		},
+	}
+}
+
+func (a *testAuthenticator) assert(t *testing.T, opts *types.WebAuthnRequestOptions) *types.WebAuthnAssertion {
+	t.Helper()
+	a.counter++
+	authData := a.authData(t, opts.RPID, false)
+	clientData := testClientData(t, protocol.AssertCeremony, opts.Challenge, testOrigin)
+	clientDataHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(append([]byte{}, authData...), clientDataHash[:]...))
+	sig, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &types.WebAuthnAssertion{
+		ID: encodeBase64URL(a.id),
+		Response: types.WebAuthnAssertionResponse{
+			ClientDataJSON:    encodeBase64URL(clientData),
+			AuthenticatorData: encodeBase64URL(authData),
+			Signature:         encodeBase64URL(sig),
+		},
+	}
+}
+
+func TestWebAuthnRegistrationAndLogin(t *testing.T) {
+	m := newTestManager(t)
+	authn := newTestAuthenticator(t)
+
+	if _, err := m.BeginWebAuthnLogin("alice", testRP); !errors.IsUserNotFoundError(err) {
+		t.Error("Expected user not found error for user without credentials, got", err)
+	}
+
+	opts, err := m.BeginWebAuthnRegistration("alice", testRP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.RP.ID != testRPID || opts.User.Name != "alice" || len(opts.PubKeyCredParams) == 0 {
+		t.Error("Unexpected creation options:", opts)
+	}
+	cred, err := m.FinishWebAuthnRegistration("alice", authn.register(t, opts, "yubikey"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cred.Name != "yubikey" || cred.ID != encodeBase64URL(authn.id) {
+		t.Error("Unexpected credential:", cred)
+	}
+
+	// the challenge can only be used once
+	if _, err := m.FinishWebAuthnRegistration("alice", authn.register(t, opts, "again")); err == nil {
+		t.Error("Expected error reusing a registration challenge")
+	}
+
+	// the same authenticator cannot be registered twice
+	opts, err = m.BeginWebAuthnRegistration("alice", testRP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(opts.ExcludeCredentials) != 1 {
+		t.Error("Expected registered credential to be excluded, got", opts.ExcludeCredentials)
+	}
+	if _, err := m.FinishWebAuthnRegistration("alice", authn.register(t, opts, "again")); err == nil {
+		t.Error("Expected error registering a credential twice")
+	}
+
+	status, err := m.GetUserStatus("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !status.Enabled || !status.Verified || !reflect.DeepEqual(status.Methods, []string{types.MFAMethodWebAuthn}) {
+		t.Error("Unexpected MFA status:", status)
+	}
+
+	loginOpts, err := m.BeginWebAuthnLogin("alice", testRP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loginOpts.AllowCredentials) != 1 {
+		t.Error("Expected one allowed credential, got", loginOpts.AllowCredentials)
+	}
+	assertion := authn.assert(t, loginOpts)
+	if err := m.FinishWebAuthnLogin("alice", assertion); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.FinishWebAuthnLogin("alice", assertion); err == nil {
+		t.Error("Expected error replaying an assertion")
+	}
+
+	creds, err := m.GetWebAuthnCredentials("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(creds) != 1 || creds[0].LastUsedAt == nil {
+		t.Error("Expected credential to be marked as used, got", creds)
+	}
+
+	// a counter that does not increase is rejected
+	loginOpts, err = m.BeginWebAuthnLogin("alice", testRP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authn.counter--
+	if err := m.FinishWebAuthnLogin("alice", authn.assert(t, loginOpts)); err == nil {
+		t.Error("Expected error for a signature counter that did not increase")
+	}
+
+	// another user cannot use the credential
+	if _, err := m.BeginWebAuthnRegistration("bob", testRP); err != nil {
+		t.Fatal(err)
+	}
+	bobOpts, err := m.BeginWebAuthnLogin("bob", testRP)
+	if !errors.IsUserNotFoundError(err) {
+		t.Error("Expected user not found error for bob, got", bobOpts, err)
+	}
+
+	if err := m.DeleteWebAuthnCredential("alice", "fake"); !errors.IsWebAuthnCredentialNotFoundError(err) {
+		t.Error("Expected not found error deleting unknown credential, got", err)
+	}
+	if err := m.DeleteWebAuthnCredential("alice", cred.ID); err != nil {
+		t.Fatal(err)
+	}
+	status, err = m.GetUserStatus("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Enabled || status.Verified || len(status.Methods) != 0 {
+		t.Error("Expected MFA to be disabled after removing the credential, got", status)
+	}
+}
+
+func TestWebAuthnRejectsBadAssertions(t *testing.T) {
+	m := newTestManager(t)
+	authn := newTestAuthenticator(t)
+
+	opts, err := m.BeginWebAuthnRegistration("alice", testRP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.FinishWebAuthnRegistration("alice", authn.register(t, opts, "yubikey")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		rpID   string
+		mutate func(*types.WebAuthnAssertion)
+	}{
+		{
+			name: "wrong relying party",
+			rpID: "evil.example.com",
+		},
+		{
+			name: "bad signature",
+			rpID: testRPID,
+			mutate: func(a *types.WebAuthnAssertion) {
+				sig, _ := decodeBase64URL(a.Response.Signature)
+				sig[len(sig)-1] ^= 0xff
+				a.Response.Signature = encodeBase64URL(sig)
+			},
+		},
+		{
+			name: "wrong user handle",
+			rpID: testRPID,
+			mutate: func(a *types.WebAuthnAssertion) {
+				a.Response.UserHandle = encodeBase64URL(webauthnUserHandle("bob"))
+			},
+		},
+		{
+			name: "unknown credential",
+			rpID: testRPID,
+			mutate: func(a *types.WebAuthnAssertion) {
+				a.ID = encodeBase64URL([]byte("unknown"))
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		loginOpts, err := m.BeginWebAuthnLogin("alice", testRP)
+		if err != nil {
+			t.Fatal(err)
+		}
+		signOpts := *loginOpts
+		signOpts.RPID = tc.rpID
+		assertion := authn.assert(t, &signOpts)
+		if tc.mutate != nil {
+			tc.mutate(assertion)
+		}
+		if err := m.FinishWebAuthnLogin("alice", assertion); err == nil {
+			t.Errorf("%s: expected assertion to be rejected", tc.name)
+		}
+	}
+
+	// the client data must come from the relying party origin
+	loginOpts, err := m.BeginWebAuthnLogin("alice", testRP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertion := authn.assert(t, loginOpts)
+	assertion.Response.ClientDataJSON = encodeBase64URL(testClientData(t, protocol.AssertCeremony, loginOpts.Challenge, "https://evil.example.com"))
+	if err := m.FinishWebAuthnLogin("alice", assertion); err == nil {
+		t.Error("Expected assertion from another origin to be rejected")
+	}
+
+	// origins under the relying party ID must be allowed as well
+	loginOpts, err = m.BeginWebAuthnLogin("alice", testRP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertion = authn.assert(t, loginOpts)
+	assertion.Response.ClientDataJSON = encodeBase64URL(testClientData(t, protocol.AssertCeremony, loginOpts.Challenge, "https://desktops.kvdi.example.com"))
+	if err := m.FinishWebAuthnLogin("alice", assertion); err == nil {
+		t.Error("Expected assertion from an origin that is not allowed to be rejected")
+	}
+
+	// the relying party must be configured
+	if _, err := m.BeginWebAuthnLogin("alice", RelyingParty{ID: testRPID}); err == nil {
+		t.Error("Expected error without allowed origins")
+	}
+}
+
+func TestWebAuthnRejectsUnsupportedAlgorithms(t *testing.T) {
+	m := newTestManager(t)
+	authn := newTestAuthenticator(t)
+	authn.alg = webauthncose.AlgES384
+
+	opts, err := m.BeginWebAuthnRegistration("alice", testRP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.FinishWebAuthnRegistration("alice", authn.register(t, opts, "yubikey")); err == nil {
+		t.Fatal("Expected credential with an algorithm that was not offered to be rejected")
+	}
+	if creds, err := m.GetWebAuthnCredentials("alice"); err != nil {
+		t.Fatal(err)
+	} else if len(creds) != 0 {
+		t.Error("Expected no credentials to be registered, got", len(creds))
+	}
+}
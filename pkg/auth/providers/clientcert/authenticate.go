@@ -0,0 +1,100 @@
+/*
+
+Copyright 2020,2021 Avi Zimmerman
+
+This file is part of kvdi.
+
+kvdi is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+kvdi is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package clientcert
+
+import (
+	"crypto/x509"
+	"fmt"
+
+	appv1 "github.com/kvdi/kvdi/apis/app/v1"
+	"github.com/kvdi/kvdi/pkg/types"
+	"github.com/kvdi/kvdi/pkg/util/common"
+	"github.com/kvdi/kvdi/pkg/util/errors"
+	"github.com/kvdi/kvdi/pkg/util/rbac"
+	"github.com/kvdi/kvdi/pkg/util/tlsutil"
+)
+
+// Authenticate implements the AuthProvider interface. The certificate presented on
+// the TLS connection of the login request is verified against the configured CA bundle
+// and mapped to a user. The username and password in the request are ignored.
+func (a *AuthProvider) Authenticate(req *types.LoginRequest) (*types.AuthResult, error) {
+	r := req.GetRequest()
+	if r == nil || r.TLS == nil {
+		return nil, errors.New("No client certificate presented")
+	}
+	cert, err := tlsutil.VerifyClientCertificate(r.TLS.PeerCertificates, a.caBundle)
+	if err != nil {
+		return nil, fmt.Errorf("invalid client certificate: %s", err.Error())
+	}
+
+	username, err := getUsername(cert, a.cluster.GetClientCertUsernameSource())
+	if err != nil {
+		return nil, err
+	}
+
+	bindings, err := rbac.GetRoleBindings(a.client, a.cluster)
+	if err != nil {
+		return nil, err
+	}
+	// organizational units and organizations are bound as groups, usernames can only
+	// be bound by role bindings
+	groups := make([]string, 0)
+	for _, names := range [][]string{cert.Subject.OrganizationalUnit, cert.Subject.Organization} {
+		for _, group := range names {
+			groups = common.AppendStringIfMissing(groups, group)
+		}
+	}
+
+	return &types.AuthResult{
+		User: &types.VDIUser{
+			Name:  username,
+			Roles: bindings.BoundRoles(string(appv1.AuthMethodClientCert), username, groups),
+		},
+		// The certificate is presented again on the next login
+		RefreshNotSupported: true,
+	}, nil
+}
+
+// getUsername returns the username for the given certificate.
+func getUsername(cert *x509.Certificate, source appv1.ClientCertUsernameSource) (string, error) {
+	var username string
+	switch source {
+	case appv1.ClientCertUsernameFromEmail:
+		if len(cert.EmailAddresses) > 0 {
+			username = cert.EmailAddresses[0]
+		}
+	case appv1.ClientCertUsernameFromDNS:
+		if len(cert.DNSNames) > 0 {
+			username = cert.DNSNames[0]
+		}
+	case appv1.ClientCertUsernameFromURI:
+		if len(cert.URIs) > 0 {
+			username = cert.URIs[0].String()
+		}
+	default:
+		username = cert.Subject.CommonName
+	}
+	if username == "" {
+		return "", fmt.Errorf("The client certificate does not contain a username in its %s", source)
+	}
+	return username, nil
+}
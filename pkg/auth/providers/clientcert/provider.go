@@ -0,0 +1,82 @@
+/*
+
+Copyright 2020,2021 Avi Zimmerman
+
+This file is part of kvdi.
+
+kvdi is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+kvdi is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+// Package clientcert contains an AuthProvider implementation that authenticates
+// users with X.509 client certificates presented to the app server.
+package clientcert
+
+import (
+	"context"
+
+	"github.com/go-logr/logr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	appv1 "github.com/kvdi/kvdi/apis/app/v1"
+	"github.com/kvdi/kvdi/pkg/auth/common"
+	"github.com/kvdi/kvdi/pkg/util/errors"
+)
+
+// AuthProvider implements an auth provider that verifies client certificates against
+// a configured CA bundle. Access for the organizational units in certificate subjects
+// is supplied through annotations on VDIRoles.
+type AuthProvider struct {
+	// k8s client
+	client client.Client
+	// our cluster instance
+	cluster *appv1.VDICluster
+	// the PEM encoded certificate authorities client certificates must be issued by
+	caBundle []byte
+}
+
+// Blank assignment to make sure AuthProvider satisfies the interface.
+var _ common.AuthProvider = &AuthProvider{}
+
+// New returns a new client certificate AuthProvider.
+func New() common.AuthProvider {
+	return &AuthProvider{}
+}
+
+// Reconcile should ensure any k8s resources required for this authentication provider.
+func (a *AuthProvider) Reconcile(context.Context, logr.Logger, client.Client, *appv1.VDICluster, string) error {
+	return nil
+}
+
+// Setup implements the AuthProvider interface and sets a local reference to the
+// k8s client and vdi cluster. It then loads the configured CA bundle.
+func (a *AuthProvider) Setup(c client.Client, cluster *appv1.VDICluster) error {
+	a.client = c
+	a.cluster = cluster
+	caBundle, err := cluster.GetClientCertCACertificates()
+	if err != nil {
+		return err
+	}
+	if len(caBundle) == 0 {
+		return errors.New("No CA certificates configured for client certificate authentication")
+	}
+	a.caBundle = caBundle
+	return nil
+}
+
+// Close is called after temporary uses of the auth provider. It should close
+// any open connections and perform cleanup. It should be non-destructive.
+func (a *AuthProvider) Close() error {
+	return nil
+}
@@ -0,0 +1,254 @@
+/*
+
+Copyright 2020,2021 Avi Zimmerman
+
+This file is part of kvdi.
+
+kvdi is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+kvdi is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package clientcert
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	appv1 "github.com/kvdi/kvdi/apis/app/v1"
+	v1 "github.com/kvdi/kvdi/apis/meta/v1"
+	rbacv1 "github.com/kvdi/kvdi/apis/rbac/v1"
+	"github.com/kvdi/kvdi/pkg/types"
+)
+
+type testPKI struct {
+	ca    *x509.Certificate
+	caKey *ecdsa.PrivateKey
+}
+
+func newTestPKI(t *testing.T) *testPKI {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "workstations"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testPKI{ca: ca, caKey: key}
+}
+
+func (p *testPKI) bundle() string {
+	return base64.StdEncoding.EncodeToString(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: p.ca.Raw}))
+}
+
+func (p *testPKI) issue(t *testing.T, tmpl *x509.Certificate) *x509.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl.SerialNumber = big.NewInt(time.Now().UnixNano())
+	tmpl.NotBefore = time.Now().Add(-time.Hour)
+	tmpl.NotAfter = time.Now().Add(time.Hour)
+	tmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, p.ca, &key.PublicKey, p.caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func newLoginRequest(t *testing.T, certs ...*x509.Certificate) *types.LoginRequest {
+	t.Helper()
+	r, err := http.NewRequest(http.MethodPost, "/api/login", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.TLS = &tls.ConnectionState{PeerCertificates: certs}
+	req := &types.LoginRequest{Username: "admin", Password: "ignored"}
+	req.SetRequest(r)
+	return req
+}
+
+func newTestProvider(t *testing.T, pki *testPKI, source appv1.ClientCertUsernameSource) *AuthProvider {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	rbacv1.AddToScheme(scheme)
+	c := fake.NewFakeClientWithScheme(scheme)
+	for name, groups := range map[string]string{
+		"engineering": "engineering;contractors",
+		"personal":    "bob;bob@example.com",
+		"company":     "acme",
+		"finance":     "finance",
+	} {
+		role := &rbacv1.VDIRole{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        name,
+				Labels:      map[string]string{v1.RoleClusterRefLabel: "test-cluster"},
+				Annotations: map[string]string{v1.ClientCertGroupRoleAnnotation: groups},
+			},
+		}
+		if err := c.Create(context.TODO(), role); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cluster := &appv1.VDICluster{}
+	cluster.Name = "test-cluster"
+	cluster.Spec.Auth = &appv1.AuthConfig{
+		ClientCertAuth: &appv1.ClientCertConfig{
+			CACertificates: pki.bundle(),
+			UsernameFrom:   source,
+		},
+	}
+	provider := New().(*AuthProvider)
+	if err := provider.Setup(c, cluster); err != nil {
+		t.Fatal(err)
+	}
+	return provider
+}
+
+func roleNames(user *types.VDIUser) []string {
+	names := make([]string, len(user.Roles))
+	for i, role := range user.Roles {
+		names[i] = role.Name
+	}
+	return names
+}
+
+func TestAuthenticate(t *testing.T) {
+	pki := newTestPKI(t)
+	cert := pki.issue(t, &x509.Certificate{
+		Subject:        pkix.Name{CommonName: "bob", OrganizationalUnit: []string{"engineering"}, Organization: []string{"acme"}},
+		EmailAddresses: []string{"bob@example.com"},
+		DNSNames:       []string{"bob.workstations.local"},
+		URIs:           []*url.URL{{Scheme: "spiffe", Host: "example.com", Path: "/bob"}},
+	})
+
+	tcs := []struct {
+		source   appv1.ClientCertUsernameSource
+		username string
+		roles    []string
+	}{
+		// usernames are not bound as groups
+		{"", "bob", []string{"engineering", "company"}},
+		{appv1.ClientCertUsernameFromEmail, "bob@example.com", []string{"engineering", "company"}},
+		{appv1.ClientCertUsernameFromDNS, "bob.workstations.local", []string{"engineering", "company"}},
+		{appv1.ClientCertUsernameFromURI, "spiffe://example.com/bob", []string{"engineering", "company"}},
+	}
+	for _, tc := range tcs {
+		provider := newTestProvider(t, pki, tc.source)
+		result, err := provider.Authenticate(newLoginRequest(t, cert))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.User.Name != tc.username {
+			t.Errorf("Expected username %q from %q, got %q", tc.username, tc.source, result.User.Name)
+		}
+		names := roleNames(result.User)
+		if len(names) != len(tc.roles) {
+			t.Errorf("Expected roles %v for %q, got %v", tc.roles, tc.username, names)
+			continue
+		}
+		for _, role := range tc.roles {
+			found := false
+			for _, name := range names {
+				found = found || name == role
+			}
+			if !found {
+				t.Errorf("Expected roles %v for %q, got %v", tc.roles, tc.username, names)
+			}
+		}
+		if !result.RefreshNotSupported {
+			t.Error("Expected refresh to not be supported")
+		}
+	}
+}
+
+func TestAuthenticateRejected(t *testing.T) {
+	pki := newTestPKI(t)
+	provider := newTestProvider(t, pki, appv1.ClientCertUsernameFromEmail)
+	other := newTestPKI(t)
+
+	// no TLS connection at all
+	if _, err := provider.Authenticate(&types.LoginRequest{Username: "bob"}); err == nil {
+		t.Error("Expected error without a TLS connection")
+	}
+	for name, req := range map[string]*types.LoginRequest{
+		"no certificate":    newLoginRequest(t),
+		"untrusted issuer":  newLoginRequest(t, other.issue(t, &x509.Certificate{Subject: pkix.Name{CommonName: "bob"}, EmailAddresses: []string{"bob@example.com"}})),
+		"missing username":  newLoginRequest(t, pki.issue(t, &x509.Certificate{Subject: pkix.Name{CommonName: "bob"}})),
+		"ca as client cert": newLoginRequest(t, pki.ca),
+	} {
+		if _, err := provider.Authenticate(req); err == nil {
+			t.Errorf("Expected error for %s", name)
+		}
+	}
+}
+
+func TestSetupRequiresCA(t *testing.T) {
+	cluster := &appv1.VDICluster{}
+	cluster.Spec.Auth = &appv1.AuthConfig{ClientCertAuth: &appv1.ClientCertConfig{}}
+	if err := New().Setup(nil, cluster); err == nil {
+		t.Error("Expected error without CA certificates")
+	}
+	cluster.Spec.Auth.ClientCertAuth.CACertificates = "not base64!"
+	if err := New().Setup(nil, cluster); err == nil {
+		t.Error("Expected error for malformed CA certificates")
+	}
+}
+
+func TestGetUsernameDefaultsToCommonName(t *testing.T) {
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: "alice"}}
+	username, err := getUsername(cert, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if username != "alice" {
+		t.Error("Expected alice, got", username)
+	}
+}
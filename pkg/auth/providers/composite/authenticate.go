@@ -0,0 +1,157 @@
+/*
+
+Copyright 2020,2021 Avi Zimmerman
+
+This file is part of kvdi.
+
+kvdi is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+kvdi is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package composite
+
+import (
+	"fmt"
+
+	appv1 "github.com/kvdi/kvdi/apis/app/v1"
+	"github.com/kvdi/kvdi/pkg/auth/common"
+	"github.com/kvdi/kvdi/pkg/types"
+	"github.com/kvdi/kvdi/pkg/util/errors"
+)
+
+// Authenticate passes the request to the member provider for the requested method.
+// Requests without a method go to the provider that started a redirect flow for
+// their state, or to the first member. The user name in the result is qualified
+// with the method of the provider.
+func (a *AuthProvider) Authenticate(req *types.LoginRequest) (*types.AuthResult, error) {
+	member, recorded, err := a.memberForRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := member.Provider.Authenticate(req)
+	if err != nil || result == nil {
+		return result, err
+	}
+
+	// The provider is sending the client elsewhere, remember where the flow started
+	// in case the client does not send the method again when it returns.
+	if result.RedirectURL != "" {
+		if req.GetState() != "" {
+			if err := a.writeStateMethod(req.GetState(), member.Method); err != nil {
+				return nil, err
+			}
+		}
+		return result, nil
+	}
+
+	if recorded {
+		if err := a.writeStateMethod(req.GetState(), ""); err != nil {
+			return nil, err
+		}
+	}
+
+	if result.User != nil {
+		result.User.Name = QualifyUsername(member.Method, result.User.Name)
+	}
+	return result, nil
+}
+
+// Refresh passes the refresh to the member provider the user belongs to. The user name
+// in the result is qualified with the method of the provider.
+func (a *AuthProvider) Refresh(username, loginID string) (*types.AuthResult, error) {
+	member, name, err := a.memberForUser(username)
+	if err != nil {
+		return nil, err
+	}
+	provider, ok := member.Provider.(common.RefreshProvider)
+	if !ok {
+		return nil, fmt.Errorf("Sessions cannot be refreshed with %s authentication", member.Method)
+	}
+	result, err := provider.Refresh(name, loginID)
+	if err != nil {
+		return nil, err
+	}
+	if result.User != nil {
+		result.User.Name = QualifyUsername(member.Method, result.User.Name)
+	}
+	return result, nil
+}
+
+// Logout passes the logout to the member provider the user belongs to, if it ends
+// sessions at an identity provider.
+func (a *AuthProvider) Logout(username, loginID string) (string, error) {
+	member, name, err := a.memberForUser(username)
+	if err != nil {
+		return "", err
+	}
+	provider, ok := member.Provider.(common.LogoutProvider)
+	if !ok {
+		return "", nil
+	}
+	return provider.Logout(name, loginID)
+}
+
+// RevokeSessions passes the revocation to the member provider the user belongs to, if
+// it keeps state for logins.
+func (a *AuthProvider) RevokeSessions(username string, loginIDs ...string) error {
+	member, name, err := a.memberForUser(username)
+	if err != nil {
+		return err
+	}
+	provider, ok := member.Provider.(common.SessionProvider)
+	if !ok {
+		return nil
+	}
+	return provider.RevokeSessions(name, loginIDs...)
+}
+
+// memberForRequest returns the member provider that should handle the given login
+// request, and whether a redirect flow was recorded for the request's state.
+func (a *AuthProvider) memberForRequest(req *types.LoginRequest) (Member, bool, error) {
+	var recorded appv1.AuthMethod
+	if state := req.GetState(); state != "" {
+		method, err := a.secrets.ReadSecret(getStateSecretKey(state), false)
+		if err != nil && !errors.IsSecretNotFoundError(err) {
+			return Member{}, false, err
+		}
+		recorded = appv1.AuthMethod(method)
+	}
+	method := appv1.AuthMethod(req.GetMethod())
+	if method == "" {
+		method = recorded
+	}
+	if method == "" {
+		return a.members[0], false, nil
+	}
+	member, err := a.member(method)
+	return member, recorded != "", err
+}
+
+// writeStateMethod records the method used for the given state. An empty method
+// removes the record.
+func (a *AuthProvider) writeStateMethod(state string, method appv1.AuthMethod) error {
+	if err := a.secrets.Lock(15); err != nil {
+		return err
+	}
+	defer a.secrets.Release()
+	if method == "" {
+		return a.secrets.WriteSecret(getStateSecretKey(state), nil)
+	}
+	return a.secrets.WriteSecret(getStateSecretKey(state), []byte(method))
+}
+
+func getStateSecretKey(state string) string {
+	return fmt.Sprintf("auth_method_%s", state)
+}
@@ -0,0 +1,178 @@
+/*
+
+Copyright 2020,2021 Avi Zimmerman
+
+This file is part of kvdi.
+
+kvdi is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+kvdi is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+// Package composite contains an AuthProvider implementation that serves several
+// other providers at once.
+package composite
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/go-logr/logr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	appv1 "github.com/kvdi/kvdi/apis/app/v1"
+	"github.com/kvdi/kvdi/pkg/auth/common"
+	"github.com/kvdi/kvdi/pkg/secrets"
+	"github.com/kvdi/kvdi/pkg/util/errors"
+)
+
+// UsernameSeparator separates the authentication method from the provider's own
+// user name in the names served by the composite provider.
+const UsernameSeparator = "."
+
+// Member is an authentication provider served by the composite provider.
+type Member struct {
+	// The method the provider implements
+	Method appv1.AuthMethod
+	// The provider itself
+	Provider common.AuthProvider
+}
+
+// AuthProvider implements an AuthProvider that routes requests to one of several
+// member providers. User names are prefixed with the method of the provider they
+// belong to so that users from different providers cannot collide.
+type AuthProvider struct {
+	// the secrets engine where we store flow state
+	secrets *secrets.SecretEngine
+	// the member providers in order of preference
+	members []Member
+}
+
+// Blank assignments to make sure AuthProvider satisfies the interfaces.
+var _ common.AuthProvider = &AuthProvider{}
+var _ common.MetadataProvider = &AuthProvider{}
+var _ common.RefreshProvider = &AuthProvider{}
+var _ common.LogoutProvider = &AuthProvider{}
+
+// New returns a new composite AuthProvider serving the given members. The first
+// member is used for login requests that do not specify a method.
+func New(s *secrets.SecretEngine, members ...Member) *AuthProvider {
+	return &AuthProvider{secrets: s, members: members}
+}
+
+// QualifyUsername returns the name served by the composite provider for the given
+// method and user name.
+func QualifyUsername(method appv1.AuthMethod, username string) string {
+	return string(method) + UsernameSeparator + username
+}
+
+// SplitUsername returns the method and provider user name for a name served by the
+// composite provider. The method is empty if the name is not qualified.
+func SplitUsername(username string) (appv1.AuthMethod, string) {
+	spl := strings.SplitN(username, UsernameSeparator, 2)
+	if len(spl) != 2 {
+		return "", username
+	}
+	return appv1.AuthMethod(spl[0]), spl[1]
+}
+
+// Methods returns the methods served by this provider.
+func (a *AuthProvider) Methods() []appv1.AuthMethod {
+	methods := make([]appv1.AuthMethod, len(a.members))
+	for i, member := range a.members {
+		methods[i] = member.Method
+	}
+	return methods
+}
+
+// QualifyBareUsername returns the name served by this provider for a user name that
+// records were kept under before it was in use. Such names belong to the first member,
+// which was the only provider before more methods were enabled. False is returned if
+// the name is already qualified with the method of a member.
+func (a *AuthProvider) QualifyBareUsername(username string) (string, bool) {
+	if len(a.members) == 0 {
+		return "", false
+	}
+	if method, _ := SplitUsername(username); method != "" {
+		if _, err := a.member(method); err == nil {
+			return "", false
+		}
+	}
+	return QualifyUsername(a.members[0].Method, username), true
+}
+
+// Reconcile reconciles the resources required by every member provider.
+func (a *AuthProvider) Reconcile(ctx context.Context, reqLogger logr.Logger, c client.Client, cluster *appv1.VDICluster, adminPass string) error {
+	for _, member := range a.members {
+		if err := member.Provider.Reconcile(ctx, reqLogger.WithValues("AuthMethod", member.Method), c, cluster, adminPass); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Setup sets up every member provider.
+func (a *AuthProvider) Setup(c client.Client, cluster *appv1.VDICluster) error {
+	for _, member := range a.members {
+		if err := member.Provider.Setup(c, cluster); err != nil {
+			return fmt.Errorf("failed to setup %s authentication: %w", member.Method, err)
+		}
+	}
+	return nil
+}
+
+// Close closes every member provider and returns the first error encountered.
+func (a *AuthProvider) Close() error {
+	var closeErr error
+	for _, member := range a.members {
+		if err := member.Provider.Close(); err != nil && closeErr == nil {
+			closeErr = err
+		}
+	}
+	return closeErr
+}
+
+// Metadata returns the metadata of the first member provider that publishes any.
+func (a *AuthProvider) Metadata() (string, []byte, error) {
+	for _, member := range a.members {
+		if provider, ok := member.Provider.(common.MetadataProvider); ok {
+			return provider.Metadata()
+		}
+	}
+	return "", nil, errors.New("None of the enabled authentication methods publish metadata")
+}
+
+// member returns the member provider for the given method.
+func (a *AuthProvider) member(method appv1.AuthMethod) (Member, error) {
+	for _, member := range a.members {
+		if member.Method == method {
+			return member, nil
+		}
+	}
+	return Member{}, fmt.Errorf("Authentication method '%s' is not enabled", method)
+}
+
+// memberForUser returns the member provider and provider user name for a name
+// served by the composite provider.
+func (a *AuthProvider) memberForUser(username string) (Member, string, error) {
+	method, name := SplitUsername(username)
+	if method == "" || name == "" {
+		return Member{}, "", errors.NewUserNotFoundError(username)
+	}
+	member, err := a.member(method)
+	if err != nil {
+		return Member{}, "", errors.NewUserNotFoundError(username)
+	}
+	return member, name, nil
+}
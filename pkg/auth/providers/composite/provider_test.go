@@ -0,0 +1,307 @@
+/*
+
+Copyright 2020,2021 Avi Zimmerman
+
+This file is part of kvdi.
+
+kvdi is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+kvdi is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package composite
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	appv1 "github.com/kvdi/kvdi/apis/app/v1"
+	"github.com/kvdi/kvdi/pkg/secrets"
+	"github.com/kvdi/kvdi/pkg/types"
+	"github.com/kvdi/kvdi/pkg/util/errors"
+)
+
+// fakeProvider is a member provider that knows a fixed set of users. When redirect
+// is set it behaves like a redirect flow, returning the user only when a login
+// request arrives for a state it has already seen.
+type fakeProvider struct {
+	users    map[string]*types.VDIUser
+	redirect bool
+	states   map[string]bool
+	created  []string
+}
+
+func newFakeProvider(redirect bool, names ...string) *fakeProvider {
+	p := &fakeProvider{users: make(map[string]*types.VDIUser), redirect: redirect, states: make(map[string]bool)}
+	for _, name := range names {
+		p.users[name] = &types.VDIUser{Name: name}
+	}
+	return p
+}
+
+func (f *fakeProvider) Reconcile(context.Context, logr.Logger, client.Client, *appv1.VDICluster, string) error {
+	return nil
+}
+func (f *fakeProvider) Setup(client.Client, *appv1.VDICluster) error { return nil }
+func (f *fakeProvider) Close() error                                 { return nil }
+
+func (f *fakeProvider) Authenticate(req *types.LoginRequest) (*types.AuthResult, error) {
+	if f.redirect {
+		if !f.states[req.GetState()] {
+			f.states[req.GetState()] = true
+			return &types.AuthResult{RedirectURL: "https://idp.local"}, nil
+		}
+		delete(f.states, req.GetState())
+	}
+	user, err := f.GetUser(req.GetUsername())
+	if err != nil {
+		return nil, err
+	}
+	return &types.AuthResult{User: user}, nil
+}
+
+func (f *fakeProvider) GetUsers() ([]*types.VDIUser, error) {
+	users := make([]*types.VDIUser, 0)
+	for name := range f.users {
+		users = append(users, &types.VDIUser{Name: name})
+	}
+	return users, nil
+}
+
+func (f *fakeProvider) GetUser(name string) (*types.VDIUser, error) {
+	if _, ok := f.users[name]; !ok {
+		return nil, errors.NewUserNotFoundError(name)
+	}
+	return &types.VDIUser{Name: name}, nil
+}
+
+func (f *fakeProvider) CreateUser(req *types.CreateUserRequest) error {
+	f.created = append(f.created, req.Username)
+	return nil
+}
+func (f *fakeProvider) UpdateUser(string, *types.UpdateUserRequest) error { return nil }
+func (f *fakeProvider) DeleteUser(string) error                           { return nil }
+
+// fakeSessionProvider is a member provider that can refresh and end its users'
+// sessions at an identity provider.
+type fakeSessionProvider struct {
+	*fakeProvider
+	loggedOut []string
+}
+
+func (f *fakeSessionProvider) Refresh(name, loginID string) (*types.AuthResult, error) {
+	user, err := f.GetUser(name)
+	if err != nil {
+		return nil, err
+	}
+	return &types.AuthResult{User: user}, nil
+}
+
+func (f *fakeSessionProvider) Logout(name, loginID string) (string, error) {
+	f.loggedOut = append(f.loggedOut, name)
+	return "https://idp.local/logout", nil
+}
+
+func newTestSecretEngine(t *testing.T) *secrets.SecretEngine {
+	t.Helper()
+	engine, _, err := secrets.NewTestEngine(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return engine
+}
+
+func loginRequest(username, method, state string) *types.LoginRequest {
+	req := &types.LoginRequest{Username: username, Method: method, State: state}
+	req.SetRequest(httptest.NewRequest(http.MethodPost, "/api/login", nil))
+	return req
+}
+
+func TestSplitUsername(t *testing.T) {
+	tc := []struct {
+		username, method, name string
+	}{
+		{"local.admin", "local", "admin"},
+		{"ldap.bob.smith", "ldap", "bob.smith"},
+		{"admin", "", "admin"},
+	}
+	for _, c := range tc {
+		method, name := SplitUsername(c.username)
+		if string(method) != c.method || name != c.name {
+			t.Errorf("Expected %q to split into %q and %q, got %q and %q", c.username, c.method, c.name, method, name)
+		}
+		if c.method != "" && QualifyUsername(method, name) != c.username {
+			t.Errorf("Expected %q to qualify back to %q", name, c.username)
+		}
+	}
+}
+
+func TestQualifyBareUsername(t *testing.T) {
+	a := New(nil, Member{Method: appv1.AuthMethodLDAP}, Member{Method: appv1.AuthMethodLocal})
+	tc := map[string]string{
+		"bob":         "ldap.bob",
+		"bob.smith":   "ldap.bob.smith",
+		"local.admin": "",
+		"ldap.bob":    "",
+	}
+	for username, expected := range tc {
+		name, ok := a.QualifyBareUsername(username)
+		if ok != (expected != "") || name != expected {
+			t.Errorf("Expected %q to qualify to %q, got %q", username, expected, name)
+		}
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	provider := New(newTestSecretEngine(t),
+		Member{Method: appv1.AuthMethodLocal, Provider: newFakeProvider(false, "admin")},
+		Member{Method: appv1.AuthMethodLDAP, Provider: newFakeProvider(false, "admin", "bob")},
+	)
+
+	tc := []struct {
+		username, method, expected string
+	}{
+		{"admin", "", "local.admin"},
+		{"admin", "local", "local.admin"},
+		{"admin", "ldap", "ldap.admin"},
+		{"bob", "ldap", "ldap.bob"},
+	}
+	for _, c := range tc {
+		result, err := provider.Authenticate(loginRequest(c.username, c.method, ""))
+		if err != nil {
+			t.Fatalf("Expected %q to authenticate with %q, got: %s", c.username, c.method, err)
+		}
+		if result.User.Name != c.expected {
+			t.Errorf("Expected user %q, got %q", c.expected, result.User.Name)
+		}
+	}
+
+	if _, err := provider.Authenticate(loginRequest("bob", "", "")); !errors.IsUserNotFoundError(err) {
+		t.Error("Expected bob to be unknown to the default provider, got:", err)
+	}
+	if _, err := provider.Authenticate(loginRequest("bob", "oidc", "")); err == nil {
+		t.Error("Expected an error for a method that is not enabled")
+	}
+}
+
+func TestRedirectFlow(t *testing.T) {
+	provider := New(newTestSecretEngine(t),
+		Member{Method: appv1.AuthMethodLocal, Provider: newFakeProvider(false)},
+		Member{Method: appv1.AuthMethodOIDC, Provider: newFakeProvider(true, "alice")},
+	)
+
+	result, err := provider.Authenticate(loginRequest("alice", "oidc", "test-state"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.RedirectURL == "" {
+		t.Fatal("Expected a redirect to start the flow")
+	}
+
+	// The client returns without the method, the recorded state should route it
+	result, err = provider.Authenticate(loginRequest("alice", "", "test-state"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.User == nil || result.User.Name != "oidc.alice" {
+		t.Fatal("Expected the redirect flow to complete as oidc.alice, got:", result.User)
+	}
+
+	// The record should be gone once the flow completed
+	if _, err := provider.Authenticate(loginRequest("alice", "", "test-state")); !errors.IsUserNotFoundError(err) {
+		t.Error("Expected the state to no longer route to the oidc provider, got:", err)
+	}
+}
+
+func TestUsers(t *testing.T) {
+	local := newFakeProvider(false, "admin")
+	provider := New(newTestSecretEngine(t),
+		Member{Method: appv1.AuthMethodLocal, Provider: local},
+		Member{Method: appv1.AuthMethodLDAP, Provider: newFakeProvider(false, "bob")},
+		Member{Method: appv1.AuthMethodOIDC, Provider: newFakeProvider(true, "alice")},
+	)
+
+	users, err := provider.GetUsers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make(map[string]bool)
+	for _, user := range users {
+		names[user.Name] = true
+	}
+	if len(names) != 2 || !names["local.admin"] || !names["ldap.bob"] {
+		t.Error("Expected only the local and ldap users, got:", names)
+	}
+
+	user, err := provider.GetUser("ldap.bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Name != "ldap.bob" {
+		t.Error("Expected ldap.bob, got:", user.Name)
+	}
+	for _, name := range []string{"bob", "local.bob", "saml.bob", "ldap."} {
+		if _, err := provider.GetUser(name); !errors.IsUserNotFoundError(err) {
+			t.Errorf("Expected %q to not be found, got: %v", name, err)
+		}
+	}
+
+	if err := provider.CreateUser(&types.CreateUserRequest{Username: "local.jane"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(local.created) != 1 || local.created[0] != "jane" {
+		t.Error("Expected jane to be created by the local provider, got:", local.created)
+	}
+	if err := provider.CreateUser(&types.CreateUserRequest{Username: "jane"}); err == nil {
+		t.Error("Expected an error creating a user without a method prefix")
+	}
+}
+
+func TestRefreshAndLogout(t *testing.T) {
+	oidc := &fakeSessionProvider{fakeProvider: newFakeProvider(true, "alice")}
+	provider := New(newTestSecretEngine(t),
+		Member{Method: appv1.AuthMethodLocal, Provider: newFakeProvider(false, "admin")},
+		Member{Method: appv1.AuthMethodOIDC, Provider: oidc},
+	)
+
+	result, err := provider.Refresh("oidc.alice", "login")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.User == nil || result.User.Name != "oidc.alice" {
+		t.Error("Expected the refreshed user to be oidc.alice, got:", result.User)
+	}
+	if _, err := provider.Refresh("local.admin", "login"); err == nil {
+		t.Error("Expected an error refreshing a user of a provider without refresh support")
+	}
+
+	logoutURL, err := provider.Logout("oidc.alice", "login")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if logoutURL != "https://idp.local/logout" || len(oidc.loggedOut) != 1 || oidc.loggedOut[0] != "alice" {
+		t.Error("Expected alice to be logged out at the identity provider, got:", logoutURL, oidc.loggedOut)
+	}
+	logoutURL, err = provider.Logout("local.admin", "login")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if logoutURL != "" {
+		t.Error("Expected no logout URL for a local user, got:", logoutURL)
+	}
+}
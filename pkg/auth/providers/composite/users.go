@@ -0,0 +1,102 @@
+/*
+
+Copyright 2020,2021 Avi Zimmerman
+
+This file is part of kvdi.
+
+kvdi is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+kvdi is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package composite
+
+import (
+	"fmt"
+
+	appv1 "github.com/kvdi/kvdi/apis/app/v1"
+	"github.com/kvdi/kvdi/pkg/types"
+)
+
+// listsUsers contains the methods whose providers are able to list their users.
+var listsUsers = map[appv1.AuthMethod]bool{
+	appv1.AuthMethodLocal: true,
+	appv1.AuthMethodLDAP:  true,
+}
+
+// GetUsers returns the users of every member provider that is able to list them.
+func (a *AuthProvider) GetUsers() ([]*types.VDIUser, error) {
+	users := make([]*types.VDIUser, 0)
+	for _, member := range a.members {
+		if !listsUsers[member.Method] {
+			continue
+		}
+		memberUsers, err := member.Provider.GetUsers()
+		if err != nil {
+			return nil, err
+		}
+		for _, user := range memberUsers {
+			user.Name = QualifyUsername(member.Method, user.Name)
+			users = append(users, user)
+		}
+	}
+	return users, nil
+}
+
+// GetUser retrieves a single user from the provider it belongs to.
+func (a *AuthProvider) GetUser(username string) (*types.VDIUser, error) {
+	member, name, err := a.memberForUser(username)
+	if err != nil {
+		return nil, err
+	}
+	user, err := member.Provider.GetUser(name)
+	if err != nil {
+		return nil, err
+	}
+	user.Name = QualifyUsername(member.Method, user.Name)
+	return user, nil
+}
+
+// CreateUser creates a user with the provider named by the method prefix of the
+// requested user name.
+func (a *AuthProvider) CreateUser(req *types.CreateUserRequest) error {
+	method, name := SplitUsername(req.Username)
+	if method == "" || name == "" {
+		return fmt.Errorf("User names must be prefixed with their authentication method, e.g. '%s'", QualifyUsername(a.members[0].Method, req.Username))
+	}
+	member, err := a.member(method)
+	if err != nil {
+		return err
+	}
+	memberReq := *req
+	memberReq.Username = name
+	return member.Provider.CreateUser(&memberReq)
+}
+
+// UpdateUser updates a user with the provider it belongs to.
+func (a *AuthProvider) UpdateUser(username string, req *types.UpdateUserRequest) error {
+	member, name, err := a.memberForUser(username)
+	if err != nil {
+		return err
+	}
+	return member.Provider.UpdateUser(name, req)
+}
+
+// DeleteUser removes a user from the provider it belongs to.
+func (a *AuthProvider) DeleteUser(username string) error {
+	member, name, err := a.memberForUser(username)
+	if err != nil {
+		return err
+	}
+	return member.Provider.DeleteUser(name)
+}
@@ -0,0 +1,125 @@
+/*
+
+Copyright 2020,2021 Avi Zimmerman
+
+This file is part of kvdi.
+
+kvdi is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+kvdi is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+
+	appv1 "github.com/kvdi/kvdi/apis/app/v1"
+	"github.com/kvdi/kvdi/pkg/types"
+	"github.com/kvdi/kvdi/pkg/util/common"
+	"github.com/kvdi/kvdi/pkg/util/errors"
+	"github.com/kvdi/kvdi/pkg/util/rbac"
+)
+
+// serviceAccountPrefix is the prefix of the user names Kubernetes assigns to
+// ServiceAccounts.
+const serviceAccountPrefix = "system:serviceaccount:"
+
+// Authenticate implements the AuthProvider interface. The ServiceAccount token is
+// read from a bearer Authorization header, or the password of the login request,
+// and validated with a TokenReview. The user name in the request is ignored.
+func (a *AuthProvider) Authenticate(req *types.LoginRequest) (*types.AuthResult, error) {
+	token := getRequestToken(req)
+	if token == "" {
+		return nil, errors.New("No ServiceAccount token provided in the request")
+	}
+
+	audiences := a.cluster.GetKubernetesAuthAudiences()
+	review := &authenticationv1.TokenReview{
+		Spec: authenticationv1.TokenReviewSpec{
+			Token:     token,
+			Audiences: audiences,
+		},
+	}
+	if err := a.client.Create(context.TODO(), review); err != nil {
+		return nil, err
+	}
+	if !review.Status.Authenticated {
+		if review.Status.Error != "" {
+			return nil, fmt.Errorf("invalid credentials: %s", review.Status.Error)
+		}
+		return nil, errors.New("invalid credentials")
+	}
+	// Authenticators that are not audience aware leave the audiences empty, so the
+	// token must be rejected unless one of the requested audiences was confirmed.
+	if len(audiences) > 0 && !audiencesIntersect(audiences, review.Status.Audiences) {
+		return nil, errors.New("invalid credentials: the token is not valid for any of the required audiences")
+	}
+
+	username := review.Status.User.Username
+	namespace, name, ok := splitServiceAccountUsername(username)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a ServiceAccount", username)
+	}
+
+	bindings, err := rbac.GetRoleBindings(a.client, a.cluster)
+	if err != nil {
+		return nil, err
+	}
+
+	return &types.AuthResult{
+		User: &types.VDIUser{
+			Name:  fmt.Sprintf("%s.%s", namespace, name),
+			Roles: bindings.BoundRoles(string(appv1.AuthMethodKubernetes), username, review.Status.User.Groups),
+		},
+		RefreshNotSupported: true,
+	}, nil
+}
+
+// audiencesIntersect returns true if any of the requested audiences was returned.
+func audiencesIntersect(requested, returned []string) bool {
+	for _, aud := range returned {
+		if common.StringSliceContains(requested, aud) {
+			return true
+		}
+	}
+	return false
+}
+
+// getRequestToken returns the bearer token in the Authorization header of the request,
+// falling back to the password in the login request.
+func getRequestToken(req *types.LoginRequest) string {
+	if r := req.GetRequest(); r != nil {
+		if header := r.Header.Get("Authorization"); strings.HasPrefix(header, "Bearer ") {
+			return strings.TrimSpace(strings.TrimPrefix(header, "Bearer "))
+		}
+	}
+	return req.GetPassword()
+}
+
+// splitServiceAccountUsername returns the namespace and name of the ServiceAccount
+// the given Kubernetes user name belongs to.
+func splitServiceAccountUsername(username string) (namespace, name string, ok bool) {
+	if !strings.HasPrefix(username, serviceAccountPrefix) {
+		return "", "", false
+	}
+	spl := strings.Split(strings.TrimPrefix(username, serviceAccountPrefix), ":")
+	if len(spl) != 2 || spl[0] == "" || spl[1] == "" {
+		return "", "", false
+	}
+	return spl[0], spl[1], true
+}
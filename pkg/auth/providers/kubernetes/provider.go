@@ -0,0 +1,71 @@
+/*
+
+Copyright 2020,2021 Avi Zimmerman
+
+This file is part of kvdi.
+
+kvdi is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+kvdi is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+// Package kubernetes contains an AuthProvider implementation that authenticates
+// Kubernetes ServiceAccounts with their tokens.
+package kubernetes
+
+import (
+	"context"
+
+	"github.com/go-logr/logr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	appv1 "github.com/kvdi/kvdi/apis/app/v1"
+	"github.com/kvdi/kvdi/pkg/auth/common"
+)
+
+// AuthProvider implements an auth provider that validates ServiceAccount tokens
+// with the Kubernetes TokenReview API. Access for ServiceAccounts and their groups
+// is supplied through annotations on VDIRoles.
+type AuthProvider struct {
+	// k8s client
+	client client.Client
+	// our cluster instance
+	cluster *appv1.VDICluster
+}
+
+// Blank assignment to make sure AuthProvider satisfies the interface.
+var _ common.AuthProvider = &AuthProvider{}
+
+// New returns a new kubernetes AuthProvider.
+func New() common.AuthProvider {
+	return &AuthProvider{}
+}
+
+// Reconcile should ensure any k8s resources required for this authentication provider.
+func (a *AuthProvider) Reconcile(context.Context, logr.Logger, client.Client, *appv1.VDICluster, string) error {
+	return nil
+}
+
+// Setup implements the AuthProvider interface and sets a local reference to the
+// k8s client and vdi cluster.
+func (a *AuthProvider) Setup(c client.Client, cluster *appv1.VDICluster) error {
+	a.client = c
+	a.cluster = cluster
+	return nil
+}
+
+// Close is called after temporary uses of the auth provider. It should close
+// any open connections and perform cleanup. It should be non-destructive.
+func (a *AuthProvider) Close() error {
+	return nil
+}
@@ -0,0 +1,217 @@
+/*
+
+Copyright 2020,2021 Avi Zimmerman
+
+This file is part of kvdi.
+
+kvdi is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+kvdi is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package kubernetes
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"testing"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	appv1 "github.com/kvdi/kvdi/apis/app/v1"
+	v1 "github.com/kvdi/kvdi/apis/meta/v1"
+	rbacv1 "github.com/kvdi/kvdi/apis/rbac/v1"
+	"github.com/kvdi/kvdi/pkg/types"
+)
+
+// reviewClient wraps a fake client and answers TokenReviews from a fixed set of
+// tokens, recording the audiences requested. Tokens are valid for the requested
+// audiences unless others are listed for them.
+type reviewClient struct {
+	client.Client
+	users          map[string]authenticationv1.UserInfo
+	tokenAudiences map[string][]string
+	audiences      []string
+}
+
+func (r *reviewClient) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) error {
+	review, ok := obj.(*authenticationv1.TokenReview)
+	if !ok {
+		return r.Client.Create(ctx, obj, opts...)
+	}
+	r.audiences = review.Spec.Audiences
+	if user, ok := r.users[review.Spec.Token]; ok {
+		review.Status.Authenticated = true
+		review.Status.User = user
+		review.Status.Audiences = review.Spec.Audiences
+		if audiences, ok := r.tokenAudiences[review.Spec.Token]; ok {
+			review.Status.Audiences = audiences
+		}
+		return nil
+	}
+	review.Status.Error = "token not recognized"
+	return nil
+}
+
+func newTestProvider(t *testing.T) (*AuthProvider, *reviewClient) {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	rbacv1.AddToScheme(scheme)
+	c := fake.NewFakeClientWithScheme(scheme)
+	for name, subjects := range map[string]string{
+		"ci-role":    "system:serviceaccount:ci:runner",
+		"group-role": "system:serviceaccounts:testing;other-group",
+		"other-role": "system:serviceaccount:ci:other",
+	} {
+		role := &rbacv1.VDIRole{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        name,
+				Labels:      map[string]string{v1.RoleClusterRefLabel: "test-cluster"},
+				Annotations: map[string]string{v1.KubernetesSubjectRoleAnnotation: subjects},
+			},
+		}
+		if err := c.Create(context.TODO(), role); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cl := &reviewClient{
+		Client: c,
+		users: map[string]authenticationv1.UserInfo{
+			"ci-token": {
+				Username: "system:serviceaccount:ci:runner",
+				Groups:   []string{"system:serviceaccounts", "system:serviceaccounts:ci"},
+			},
+			"testing-token": {
+				Username: "system:serviceaccount:testing:runner",
+				Groups:   []string{"system:serviceaccounts", "system:serviceaccounts:testing"},
+			},
+			"user-token": {
+				Username: "jane",
+				Groups:   []string{"system:authenticated"},
+			},
+			"api-token": {
+				Username: "system:serviceaccount:ci:runner",
+				Groups:   []string{"system:serviceaccounts", "system:serviceaccounts:ci"},
+			},
+			"unaware-token": {
+				Username: "system:serviceaccount:ci:runner",
+				Groups:   []string{"system:serviceaccounts", "system:serviceaccounts:ci"},
+			},
+		},
+		tokenAudiences: map[string][]string{
+			// issued for another audience
+			"api-token": {"https://kubernetes.default.svc"},
+			// reviewed by an authenticator that is not audience aware
+			"unaware-token": nil,
+		},
+	}
+	cluster := &appv1.VDICluster{}
+	cluster.Name = "test-cluster"
+	cluster.Spec.Auth = &appv1.AuthConfig{
+		KubernetesAuth: &appv1.KubernetesConfig{Audiences: []string{"kvdi"}},
+	}
+	provider := New().(*AuthProvider)
+	if err := provider.Setup(cl, cluster); err != nil {
+		t.Fatal(err)
+	}
+	return provider, cl
+}
+
+func roleNames(user *types.VDIUser) []string {
+	names := make([]string, len(user.Roles))
+	for i, role := range user.Roles {
+		names[i] = role.Name
+	}
+	return names
+}
+
+func TestAuthenticate(t *testing.T) {
+	provider, cl := newTestProvider(t)
+
+	// tokens are accepted in the password field and the username is ignored
+	result, err := provider.Authenticate(&types.LoginRequest{Username: "admin", Password: "ci-token"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.User.Name != "ci.runner" {
+		t.Error("Expected user ci.runner, got", result.User.Name)
+	}
+	if !reflect.DeepEqual(roleNames(result.User), []string{"ci-role"}) {
+		t.Error("Expected only ci-role to be bound, got", roleNames(result.User))
+	}
+	if !result.RefreshNotSupported {
+		t.Error("Expected refresh to not be supported")
+	}
+	if !reflect.DeepEqual(cl.audiences, []string{"kvdi"}) {
+		t.Error("Expected the configured audiences to be reviewed, got", cl.audiences)
+	}
+
+	// roles can be bound to groups and tokens can be sent as a bearer token
+	req := &types.LoginRequest{Password: "ci-token"}
+	r, err := http.NewRequest(http.MethodPost, "/api/login", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set("Authorization", "Bearer testing-token")
+	req.SetRequest(r)
+	result, err = provider.Authenticate(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.User.Name != "testing.runner" {
+		t.Error("Expected user testing.runner, got", result.User.Name)
+	}
+	if !reflect.DeepEqual(roleNames(result.User), []string{"group-role"}) {
+		t.Error("Expected only group-role to be bound, got", roleNames(result.User))
+	}
+}
+
+func TestAuthenticateRejected(t *testing.T) {
+	provider, _ := newTestProvider(t)
+
+	for name, req := range map[string]*types.LoginRequest{
+		"no token":       {Username: "admin"},
+		"invalid token":  {Password: "bad-token"},
+		"not an sa":      {Password: "user-token"},
+		"wrong audience": {Password: "api-token"},
+		"no audience":    {Password: "unaware-token"},
+	} {
+		if _, err := provider.Authenticate(req); err == nil {
+			t.Errorf("Expected error for %s", name)
+		}
+	}
+}
+
+func TestSplitServiceAccountUsername(t *testing.T) {
+	tcs := []struct {
+		username, namespace, name string
+		ok                        bool
+	}{
+		{"system:serviceaccount:ci:runner", "ci", "runner", true},
+		{"system:serviceaccount:ci", "", "", false},
+		{"system:serviceaccount::runner", "", "", false},
+		{"system:serviceaccounts:ci", "", "", false},
+		{"jane", "", "", false},
+	}
+	for _, tc := range tcs {
+		namespace, name, ok := splitServiceAccountUsername(tc.username)
+		if namespace != tc.namespace || name != tc.name || ok != tc.ok {
+			t.Errorf("Unexpected result for %q: %q %q %v", tc.username, namespace, name, ok)
+		}
+	}
+}
@@ -25,29 +25,24 @@ import (
 
 	ldapv3 "github.com/go-ldap/ldap/v3"
 
-	v1 "github.com/kvdi/kvdi/apis/meta/v1"
-	rbacv1 "github.com/kvdi/kvdi/apis/rbac/v1"
+	appv1 "github.com/kvdi/kvdi/apis/app/v1"
 	"github.com/kvdi/kvdi/pkg/types"
-	"github.com/kvdi/kvdi/pkg/util/apiutil"
-	"github.com/kvdi/kvdi/pkg/util/common"
 	"github.com/kvdi/kvdi/pkg/util/errors"
+	rbacutil "github.com/kvdi/kvdi/pkg/util/rbac"
 )
 
 // Authenticate is called for API authentication requests. It should generate
 // a new JWTClaims object and serve an AuthResult back to the API.
 func (a *AuthProvider) Authenticate(req *types.LoginRequest) (*types.AuthResult, error) {
-	conn, err := a.connect()
+	conn, err := a.pool.get()
 	if err != nil {
 		return nil, err
 	}
-	defer conn.Close()
-
-	if err := a.bind(conn); err != nil {
-		return nil, err
-	}
+	reuse := true
+	defer func() { a.pool.put(conn, reuse) }()
 
 	// fetch the role mappings
-	roles, err := a.cluster.GetRoles(a.client)
+	bindings, err := rbacutil.GetRoleBindings(a.client, a.cluster)
 	if err != nil {
 		return nil, err
 	}
@@ -55,12 +50,13 @@ func (a *AuthProvider) Authenticate(req *types.LoginRequest) (*types.AuthResult,
 	searchRequest := ldapv3.NewSearchRequest(
 		a.getUserBase(),
 		ldapv3.ScopeWholeSubtree, ldapv3.NeverDerefAliases, 0, 0, false,
-		fmt.Sprintf(a.userFilter(), req.Username),
+		fmt.Sprintf(a.userFilter(), ldapv3.EscapeFilter(req.Username)),
 		a.userAttrs(),
 		nil,
 	)
 	sr, err := conn.Search(searchRequest)
 	if err != nil {
+		reuse = reusable(err)
 		return nil, err
 	}
 
@@ -76,10 +72,19 @@ func (a *AuthProvider) Authenticate(req *types.LoginRequest) (*types.AuthResult,
 		}
 	}
 
-	// perform a bind to check the credentials
-	if err := conn.Bind(user.DN, req.Password); err != nil {
+	// perform a bind to check the credentials, then bind as the service account again
+	// so the connection can go back to the pool
+	bindErr := conn.Bind(user.DN, req.Password)
+	if err := a.bind(conn); err != nil {
+		reuse = false
+		if bindErr != nil {
+			return nil, bindErr
+		}
 		return nil, err
 	}
+	if bindErr != nil {
+		return nil, bindErr
+	}
 
 	// make a new user object
 	vdiUser := &types.VDIUser{
@@ -87,34 +92,15 @@ func (a *AuthProvider) Authenticate(req *types.LoginRequest) (*types.AuthResult,
 		Roles: make([]*types.VDIUserRole, 0),
 	}
 
-	// we'll have to iterate our available roles and check if any have an annotation
-	// binding it to one of this user's ldap groups
-	boundRoles := make([]string, 0)
-	userGroups := user.GetAttributeValues(a.cluster.GetLDAPUserGroupsAttribute())
-
-	for _, role := range roles {
-		boundRoles = appendRoleIfBound(boundRoles, userGroups, role)
+	// resolve the roles bound to the user or one of their ldap groups
+	userGroups, err := a.getUserGroups(conn, user)
+	if err != nil {
+		reuse = reusable(err)
+		return nil, err
 	}
 
-	vdiUser.Roles = apiutil.FilterUserRolesByNames(roles, boundRoles)
+	vdiUser.Roles = bindings.BoundRoles(string(appv1.AuthMethodLDAP), req.Username, userGroups)
 
 	// user is a regular user, check their ldap groups against any bound VDIRoles.
 	return &types.AuthResult{User: vdiUser}, nil
 }
-
-func appendRoleIfBound(boundRoles, userGroups []string, role *rbacv1.VDIRole) []string {
-	if annotations := role.GetAnnotations(); annotations != nil {
-		if ldapGroups, ok := annotations[v1.LDAPGroupRoleAnnotation]; ok {
-			boundGroups := strings.Split(ldapGroups, v1.AuthGroupSeparator)
-			for _, group := range boundGroups {
-				if group == "" {
-					continue
-				}
-				if common.StringSliceContains(userGroups, group) {
-					boundRoles = common.AppendStringIfMissing(boundRoles, role.GetName())
-				}
-			}
-		}
-	}
-	return boundRoles
-}
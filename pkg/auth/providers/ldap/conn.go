@@ -35,10 +35,34 @@ func (a *AuthProvider) connect() (*ldapv3.Conn, error) {
 	return ldapv3.DialURL(a.cluster.GetLDAPURL())
 }
 
-func (a *AuthProvider) bind(conn *ldapv3.Conn) error {
+// dial creates a connection with the ldap server and binds it as the service account.
+func (a *AuthProvider) dial() (ldapv3.Client, error) {
+	conn, err := a.connect()
+	if err != nil {
+		return nil, err
+	}
+	if err := a.bind(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+func (a *AuthProvider) bind(conn ldapv3.Client) error {
 	return conn.Bind(a.bindDN, a.bindPassw)
 }
 
+// withConn calls fn with a pooled connection bound as the service account.
+func (a *AuthProvider) withConn(fn func(ldapv3.Client) error) error {
+	conn, err := a.pool.get()
+	if err != nil {
+		return err
+	}
+	err = fn(conn)
+	a.pool.put(conn, reusable(err))
+	return err
+}
+
 func (a *AuthProvider) fetchAndSetBindCredentials() error {
 	var err error
 	a.bindDN, a.bindPassw, err = a.getCredentials()
@@ -0,0 +1,143 @@
+/*
+
+Copyright 2020,2021 Avi Zimmerman
+
+This file is part of kvdi.
+
+kvdi is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+kvdi is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package ldap
+
+import (
+	"fmt"
+
+	ldapv3 "github.com/go-ldap/ldap/v3"
+
+	appv1 "github.com/kvdi/kvdi/apis/app/v1"
+)
+
+// matchingRuleInChain is the OID of Active Directory's LDAP_MATCHING_RULE_IN_CHAIN. It
+// makes membership filters match through any number of nested groups.
+const matchingRuleInChain = "1.2.840.113556.1.4.1941"
+
+// getUserGroups returns the DNs of the groups the given user is a member of, including
+// nested groups when configured.
+func (a *AuthProvider) getUserGroups(conn ldapv3.Client, user *ldapv3.Entry) ([]string, error) {
+	switch a.cluster.GetLDAPNestedGroups() {
+	case appv1.LDAPNestedGroupsInChain:
+		return a.searchGroups(conn, fmt.Sprintf("(member:%s:=%s)", matchingRuleInChain, ldapv3.EscapeFilter(user.DN)))
+	case appv1.LDAPNestedGroupsRecursive:
+		groups, err := a.getDirectGroups(conn, user)
+		if err != nil {
+			return nil, err
+		}
+		return resolveNestedGroups(groups, a.cluster.GetLDAPMaxGroupDepth(), func(group string) ([]string, error) {
+			return a.getParentGroups(conn, group)
+		})
+	default:
+		return a.getDirectGroups(conn, user)
+	}
+}
+
+// getDirectGroups returns the DNs of the groups the given user is a direct member of.
+func (a *AuthProvider) getDirectGroups(conn ldapv3.Client, user *ldapv3.Entry) ([]string, error) {
+	if a.cluster.GetLDAPGroupSearchBase() != "" {
+		return a.searchGroups(conn, fmt.Sprintf(a.cluster.GetLDAPGroupSearchFilter(), ldapv3.EscapeFilter(user.DN)))
+	}
+	return user.GetAttributeValues(a.cluster.GetLDAPUserGroupsAttribute()), nil
+}
+
+// getParentGroups returns the DNs of the groups the given group is a direct member of.
+func (a *AuthProvider) getParentGroups(conn ldapv3.Client, group string) ([]string, error) {
+	if a.cluster.GetLDAPGroupSearchBase() != "" {
+		return a.searchGroups(conn, fmt.Sprintf(a.cluster.GetLDAPGroupSearchFilter(), ldapv3.EscapeFilter(group)))
+	}
+	groupsAttr := a.cluster.GetLDAPUserGroupsAttribute()
+	sr, err := conn.Search(ldapv3.NewSearchRequest(
+		group,
+		ldapv3.ScopeBaseObject, ldapv3.NeverDerefAliases, 0, 0, false,
+		"(objectClass=*)",
+		[]string{groupsAttr},
+		nil,
+	))
+	if err != nil {
+		// a group referenced by a user may be outside of what we can read
+		if ldapv3.IsErrorWithCode(err, ldapv3.LDAPResultNoSuchObject) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	parents := make([]string, 0)
+	for _, entry := range sr.Entries {
+		parents = append(parents, entry.GetAttributeValues(groupsAttr)...)
+	}
+	return parents, nil
+}
+
+// searchGroups returns the DNs of the groups matching the given filter.
+func (a *AuthProvider) searchGroups(conn ldapv3.Client, filter string) ([]string, error) {
+	sr, err := conn.SearchWithPaging(ldapv3.NewSearchRequest(
+		a.getGroupBase(),
+		ldapv3.ScopeWholeSubtree, ldapv3.NeverDerefAliases, 0, 0, false,
+		filter,
+		[]string{"dn"},
+		nil,
+	), searchPageSize)
+	if err != nil {
+		return nil, err
+	}
+	groups := make([]string, len(sr.Entries))
+	for i, entry := range sr.Entries {
+		groups[i] = entry.DN
+	}
+	return groups, nil
+}
+
+// resolveNestedGroups returns the given groups along with all the groups they are
+// nested in, following parents up to the given depth. Cycles in the group graph are
+// only followed once.
+func resolveNestedGroups(groups []string, maxDepth int, parents func(string) ([]string, error)) ([]string, error) {
+	seen := make(map[string]struct{}, len(groups))
+	out := make([]string, 0, len(groups))
+	frontier := make([]string, 0, len(groups))
+	for _, group := range groups {
+		if _, ok := seen[group]; ok {
+			continue
+		}
+		seen[group] = struct{}{}
+		out = append(out, group)
+		frontier = append(frontier, group)
+	}
+	for depth := 0; depth < maxDepth && len(frontier) > 0; depth++ {
+		next := make([]string, 0)
+		for _, group := range frontier {
+			groupParents, err := parents(group)
+			if err != nil {
+				return nil, err
+			}
+			for _, parent := range groupParents {
+				if _, ok := seen[parent]; ok {
+					continue
+				}
+				seen[parent] = struct{}{}
+				out = append(out, parent)
+				next = append(next, parent)
+			}
+		}
+		frontier = next
+	}
+	return out, nil
+}
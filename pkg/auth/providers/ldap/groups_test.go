@@ -0,0 +1,65 @@
+/*
+
+Copyright 2020,2021 Avi Zimmerman
+
+This file is part of kvdi.
+
+kvdi is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+kvdi is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package ldap
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestResolveNestedGroups(t *testing.T) {
+	parents := map[string][]string{
+		"cn=devs":     {"cn=eng", "cn=all"},
+		"cn=eng":      {"cn=all"},
+		"cn=all":      {"cn=everyone"},
+		"cn=everyone": {"cn=devs"}, // cycle
+	}
+	lookup := func(group string) ([]string, error) { return parents[group], nil }
+
+	tc := []struct {
+		groups   []string
+		depth    int
+		expected []string
+	}{
+		{[]string{"cn=devs"}, 0, []string{"cn=devs"}},
+		{[]string{"cn=devs"}, 1, []string{"cn=devs", "cn=eng", "cn=all"}},
+		{[]string{"cn=devs"}, 10, []string{"cn=devs", "cn=eng", "cn=all", "cn=everyone"}},
+		{[]string{"cn=eng", "cn=eng", "cn=other"}, 10, []string{"cn=eng", "cn=other", "cn=all", "cn=everyone", "cn=devs"}},
+		{[]string{}, 10, []string{}},
+	}
+	for _, c := range tc {
+		got, err := resolveNestedGroups(c.groups, c.depth, lookup)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, c.expected) {
+			t.Errorf("Expected %v at depth %d, got %v", c.expected, c.depth, got)
+		}
+	}
+
+	if _, err := resolveNestedGroups([]string{"cn=devs"}, 10, func(string) ([]string, error) {
+		return nil, errors.New("search failed")
+	}); err == nil {
+		t.Error("Expected lookup error to be returned")
+	}
+}
@@ -0,0 +1,160 @@
+/*
+
+Copyright 2020,2021 Avi Zimmerman
+
+This file is part of kvdi.
+
+kvdi is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+kvdi is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package ldap
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	ldapv3 "github.com/go-ldap/ldap/v3"
+)
+
+// poolWaitTimeout is how long to wait for a free connection when the pool is exhausted.
+var poolWaitTimeout = time.Duration(30) * time.Second
+
+// healthCheckInterval is how long a connection can sit idle before it is checked again
+// on its next use.
+var healthCheckInterval = time.Duration(30) * time.Second
+
+// connPool is a bounded pool of connections to the LDAP server. Connections in the pool
+// are always bound as the service account.
+type connPool struct {
+	// opens and binds a new connection
+	dial func() (ldapv3.Client, error)
+	// checks that an idle connection still works
+	check func(ldapv3.Client) error
+	// how long a connection can sit idle before it is closed
+	idleTimeout time.Duration
+	// holds a token for every open connection that is in use
+	slots chan struct{}
+	// idle connections, the most recently used last
+	idle []*idleConn
+	// whether the pool was closed
+	closed bool
+	// protects idle and closed
+	mux sync.Mutex
+	// returns the current time, replaced in tests
+	now func() time.Time
+}
+
+// idleConn is a connection waiting in the pool.
+type idleConn struct {
+	conn  ldapv3.Client
+	since time.Time
+}
+
+// newConnPool returns a new pool that keeps at most size connections open.
+func newConnPool(size int, idleTimeout time.Duration, dial func() (ldapv3.Client, error), check func(ldapv3.Client) error) *connPool {
+	return &connPool{
+		dial:        dial,
+		check:       check,
+		idleTimeout: idleTimeout,
+		slots:       make(chan struct{}, size),
+		idle:        make([]*idleConn, 0),
+		now:         time.Now,
+	}
+}
+
+// get returns a connection from the pool, opening a new one if none are idle. Idle
+// connections that expired or fail their health check are closed and skipped. Every
+// connection retrieved must be returned with put.
+func (p *connPool) get() (ldapv3.Client, error) {
+	select {
+	case p.slots <- struct{}{}:
+	case <-time.After(poolWaitTimeout):
+		return nil, errors.New("timed out waiting for a free LDAP connection")
+	}
+	for {
+		idle := p.popIdle()
+		if idle == nil {
+			break
+		}
+		idleFor := p.now().Sub(idle.since)
+		if idle.conn.IsClosing() || idleFor > p.idleTimeout {
+			idle.conn.Close()
+			continue
+		}
+		if idleFor > healthCheckInterval {
+			if err := p.check(idle.conn); err != nil {
+				idle.conn.Close()
+				continue
+			}
+		}
+		return idle.conn, nil
+	}
+	conn, err := p.dial()
+	if err != nil {
+		<-p.slots
+		return nil, err
+	}
+	return conn, nil
+}
+
+// put returns a connection to the pool. Connections that may no longer be bound as
+// the service account, or that had a network error, should be returned with reuse
+// set to false so they are closed instead.
+func (p *connPool) put(conn ldapv3.Client, reuse bool) {
+	defer func() { <-p.slots }()
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	if !reuse || p.closed || conn.IsClosing() {
+		conn.Close()
+		return
+	}
+	// close connections that expired while waiting, they are the oldest
+	now := p.now()
+	for len(p.idle) > 0 && now.Sub(p.idle[0].since) > p.idleTimeout {
+		p.idle[0].conn.Close()
+		p.idle = p.idle[1:]
+	}
+	p.idle = append(p.idle, &idleConn{conn: conn, since: now})
+}
+
+// close closes all idle connections. Connections in use are closed when they are
+// returned.
+func (p *connPool) close() {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	p.closed = true
+	for _, idle := range p.idle {
+		idle.conn.Close()
+	}
+	p.idle = nil
+}
+
+func (p *connPool) popIdle() *idleConn {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+	if len(p.idle) == 0 {
+		return nil
+	}
+	idle := p.idle[len(p.idle)-1]
+	p.idle = p.idle[:len(p.idle)-1]
+	return idle
+}
+
+// reusable returns false if the given error from an operation on a connection means
+// the connection should not be reused.
+func reusable(err error) bool {
+	return err == nil || !ldapv3.IsErrorWithCode(err, ldapv3.ErrorNetwork)
+}
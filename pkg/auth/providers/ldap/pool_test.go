@@ -0,0 +1,193 @@
+/*
+
+Copyright 2020,2021 Avi Zimmerman
+
+This file is part of kvdi.
+
+kvdi is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+kvdi is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package ldap
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	ldapv3 "github.com/go-ldap/ldap/v3"
+)
+
+// fakeConn is a connection that only tracks whether it was closed.
+type fakeConn struct {
+	ldapv3.Client
+	id     int
+	closed bool
+}
+
+func (f *fakeConn) Close()          { f.closed = true }
+func (f *fakeConn) IsClosing() bool { return f.closed }
+
+type testPool struct {
+	*connPool
+	dialed  []*fakeConn
+	checked int
+	failing bool
+	clock   time.Time
+}
+
+func newTestPool(size int) *testPool {
+	tp := &testPool{clock: time.Now()}
+	tp.connPool = newConnPool(size, time.Minute, func() (ldapv3.Client, error) {
+		if tp.failing {
+			return nil, errors.New("dial failed")
+		}
+		conn := &fakeConn{id: len(tp.dialed)}
+		tp.dialed = append(tp.dialed, conn)
+		return conn, nil
+	}, func(ldapv3.Client) error {
+		tp.checked++
+		if tp.failing {
+			return errors.New("check failed")
+		}
+		return nil
+	})
+	tp.now = func() time.Time { return tp.clock }
+	return tp
+}
+
+func (tp *testPool) mustGet(t *testing.T) *fakeConn {
+	t.Helper()
+	conn, err := tp.get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn.(*fakeConn)
+}
+
+func TestPoolReuse(t *testing.T) {
+	pool := newTestPool(2)
+
+	first := pool.mustGet(t)
+	pool.put(first, true)
+	if again := pool.mustGet(t); again != first {
+		t.Error("Expected idle connection to be reused")
+	}
+	pool.put(first, false)
+	if !first.closed {
+		t.Error("Expected connection returned without reuse to be closed")
+	}
+	if next := pool.mustGet(t); next == first {
+		t.Error("Expected a new connection after the last one was discarded")
+	} else {
+		pool.put(next, true)
+	}
+	if len(pool.dialed) != 2 {
+		t.Error("Expected 2 connections to be dialed, got", len(pool.dialed))
+	}
+}
+
+func TestPoolBounded(t *testing.T) {
+	defer func(timeout time.Duration) { poolWaitTimeout = timeout }(poolWaitTimeout)
+	poolWaitTimeout = 10 * time.Millisecond
+	pool := newTestPool(2)
+
+	first, second := pool.mustGet(t), pool.mustGet(t)
+	if _, err := pool.get(); err == nil {
+		t.Fatal("Expected error getting a connection from an exhausted pool")
+	}
+
+	poolWaitTimeout = time.Second
+	done := make(chan *fakeConn)
+	go func() {
+		conn, err := pool.get()
+		if err != nil {
+			done <- nil
+			return
+		}
+		done <- conn.(*fakeConn)
+	}()
+	pool.put(second, true)
+	if conn := <-done; conn != second {
+		t.Error("Expected waiting caller to receive the returned connection")
+	}
+	pool.put(first, true)
+	pool.put(second, true)
+
+	// failed dials don't use up the pool
+	pool = newTestPool(2)
+	pool.failing = true
+	for i := 0; i < 3; i++ {
+		if _, err := pool.get(); err == nil {
+			t.Fatal("Expected dial error")
+		}
+	}
+	if len(pool.slots) != 0 {
+		t.Error("Expected failed dials to release their slots, got", len(pool.slots))
+	}
+}
+
+func TestPoolHealthCheck(t *testing.T) {
+	pool := newTestPool(2)
+
+	conn := pool.mustGet(t)
+	pool.put(conn, true)
+
+	// recently used connections are not checked
+	pool.put(pool.mustGet(t), true)
+	if pool.checked != 0 {
+		t.Error("Expected recently used connection to not be checked")
+	}
+
+	// connections idle for a while are checked
+	pool.clock = pool.clock.Add(healthCheckInterval + time.Second)
+	pool.put(pool.mustGet(t), true)
+	if pool.checked != 1 {
+		t.Error("Expected idle connection to be checked")
+	}
+
+	// connections failing the check are replaced
+	pool.clock = pool.clock.Add(healthCheckInterval + time.Second)
+	pool.failing = true
+	if _, err := pool.get(); err == nil {
+		t.Fatal("Expected error when check and dial fail")
+	}
+	if !conn.closed {
+		t.Error("Expected connection failing its check to be closed")
+	}
+	pool.failing = false
+
+	// expired connections are closed
+	conn = pool.mustGet(t)
+	pool.put(conn, true)
+	pool.clock = pool.clock.Add(2 * time.Minute)
+	if next := pool.mustGet(t); next == conn || !conn.closed {
+		t.Error("Expected expired connection to be closed and replaced")
+	} else {
+		pool.put(next, true)
+	}
+
+	// closing the pool closes idle and returned connections
+	inUse := pool.mustGet(t)
+	idle := pool.mustGet(t)
+	pool.put(idle, true)
+	pool.close()
+	if !idle.closed {
+		t.Error("Expected idle connection to be closed with the pool")
+	}
+	pool.put(inUse, true)
+	if !inUse.closed {
+		t.Error("Expected connection returned to a closed pool to be closed")
+	}
+}
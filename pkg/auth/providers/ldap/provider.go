@@ -26,6 +26,7 @@ import (
 	"crypto/tls"
 	"strings"
 
+	ldapv3 "github.com/go-ldap/ldap/v3"
 	"github.com/go-logr/logr"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
@@ -52,6 +53,10 @@ type AuthProvider struct {
 	tlsConfig *tls.Config
 	// the base DN for the connected LDAP server
 	baseDN string
+	// the pool of connections bound as the service account
+	pool *connPool
+	// a cache of the users returned by GetUsers
+	users userCache
 }
 
 // Blank assignment to make sure AuthProvider satisfies the interface.
@@ -88,15 +93,15 @@ func (a *AuthProvider) Setup(c client.Client, cluster *appv1.VDICluster) error {
 	}
 	a.baseDN = strings.Join(baseDnFields, ",")
 
-	// verify we can connect to the ldap server
-	conn, err := a.connect()
-	if err != nil {
-		return err
+	// replace the connection pool in case the server or credentials changed
+	if a.pool != nil {
+		a.pool.close()
 	}
+	a.pool = newConnPool(a.cluster.GetLDAPMaxConnections(), a.cluster.GetLDAPConnectionIdleTimeout(), a.dial, a.bind)
+	a.users.clear()
 
-	// verify credentials work
-	defer conn.Close()
-	return a.bind(conn)
+	// verify we can connect to the ldap server and that the credentials work
+	return a.withConn(func(ldapv3.Client) error { return nil })
 }
 
 // Reconcile just makes sure that we are able to succesfully set up a connection.
@@ -105,7 +110,10 @@ func (a *AuthProvider) Reconcile(ctx context.Context, reqLogger logr.Logger, c c
 	return a.Setup(c, cluster)
 }
 
-// Close just returns nil as connections are not persistent
+// Close closes the pooled connections to the LDAP server.
 func (a *AuthProvider) Close() error {
+	if a.pool != nil {
+		a.pool.close()
+	}
 	return nil
 }
@@ -1,4 +1,5 @@
 /*
+
 Copyright 2020,2021 Avi Zimmerman
 
 This file is part of kvdi.
@@ -15,136 +16,208 @@ GNU General Public License for more details.
 
 You should have received a copy of the GNU General Public License
 along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
+
 */
 
 package ldap
 
 import (
 	"fmt"
-	"strings"
+	"sync"
+	"time"
 
-	v1 "github.com/kvdi/kvdi/apis/meta/v1"
+	appv1 "github.com/kvdi/kvdi/apis/app/v1"
 	"github.com/kvdi/kvdi/pkg/types"
-	"github.com/kvdi/kvdi/pkg/util/common"
 	"github.com/kvdi/kvdi/pkg/util/errors"
 	rbacutil "github.com/kvdi/kvdi/pkg/util/rbac"
 
 	ldapv3 "github.com/go-ldap/ldap/v3"
 )
 
-// GetUsers should return a list of VDIUsers.
+// userCache holds the users returned by GetUsers for a limited time.
+type userCache struct {
+	users     []*types.VDIUser
+	expiresAt time.Time
+	mux       sync.Mutex
+}
+
+// get returns copies of the cached users, or false if the cache expired.
+func (c *userCache) get() ([]*types.VDIUser, bool) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if c.users == nil || time.Now().After(c.expiresAt) {
+		return nil, false
+	}
+	return copyUsers(c.users), true
+}
+
+// set caches copies of the given users for the given duration.
+func (c *userCache) set(users []*types.VDIUser, ttl time.Duration) {
+	if ttl <= 0 {
+		return
+	}
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.users = copyUsers(users)
+	c.expiresAt = time.Now().Add(ttl)
+}
+
+// clear empties the cache.
+func (c *userCache) clear() {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.users = nil
+}
+
+// copyUsers returns shallow copies of the given users, so callers can fill in fields
+// without touching the cache.
+func copyUsers(users []*types.VDIUser) []*types.VDIUser {
+	out := make([]*types.VDIUser, len(users))
+	for i, user := range users {
+		u := *user
+		out[i] = &u
+	}
+	return out
+}
+
+// GetUsers should return a list of VDIUsers. Results are cached for the duration
+// configured in the cluster.
 func (a *AuthProvider) GetUsers() ([]*types.VDIUser, error) {
-	conn, err := a.connect()
-	if err != nil {
-		return nil, err
+	if users, ok := a.users.get(); ok {
+		return users, nil
 	}
-	defer conn.Close()
 
-	if err := a.bind(conn); err != nil {
+	// fetch the role mappings
+	bindings, err := rbacutil.GetRoleBindings(a.client, a.cluster)
+	if err != nil {
 		return nil, err
 	}
-	// fetch the role mappings
-	roles, err := a.cluster.GetRoles(a.client)
+
+	var vdiUsers []*types.VDIUser
+	err = a.withConn(func(conn ldapv3.Client) error {
+		var err error
+		if a.cluster.GetLDAPGroupSearchBase() != "" || a.cluster.GetLDAPNestedGroups() == appv1.LDAPNestedGroupsRecursive {
+			// membership can't be expressed as a filter on users, resolve the groups
+			// of every user instead
+			vdiUsers, err = a.listUsersByGroups(conn, bindings)
+			return err
+		}
+		vdiUsers, err = a.listUsersByRoles(conn, bindings)
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
 
+	a.users.set(vdiUsers, a.cluster.GetLDAPUserCacheTTL())
+	return vdiUsers, nil
+}
+
+// listUsersByRoles searches for the members of every group bound to a role.
+func (a *AuthProvider) listUsersByRoles(conn ldapv3.Client, bindings *rbacutil.RoleBindings) ([]*types.VDIUser, error) {
+	filter := a.groupUsersFilter()
+	if a.cluster.GetLDAPNestedGroups() == appv1.LDAPNestedGroupsInChain {
+		filter = a.groupUsersInChainFilter()
+	}
+
 	vdiUsers := make([]*types.VDIUser, 0)
-	for _, role := range roles {
+	for _, role := range bindings.Roles() {
 
 		userRole := rbacutil.VDIRoleToUserRole(role)
 
-		if annotations := role.GetAnnotations(); annotations != nil {
-			if ldapGroupStr, ok := annotations[v1.LDAPGroupRoleAnnotation]; ok {
-				groups := strings.Split(ldapGroupStr, v1.AuthGroupSeparator)
-			GroupLoop:
-				for _, group := range groups {
-					if group == "" {
-						continue GroupLoop
-					}
-					searchRequest := ldapv3.NewSearchRequest(
-						a.getUserBase(),
-						ldapv3.ScopeWholeSubtree, ldapv3.NeverDerefAliases, 0, 0, false,
-						fmt.Sprintf(a.groupUsersFilter(), group),
-						a.userAttrs(),
-						nil,
-					)
-					sr, err := conn.Search(searchRequest)
-					if err != nil {
-						return nil, err
-					}
-					for _, entry := range sr.Entries {
-						vdiUsers = appendUser(vdiUsers, entry.GetAttributeValue(a.cluster.GetLDAPUserIDAttribute()), userRole)
-					}
-				}
+		for _, group := range bindings.BoundGroups(string(appv1.AuthMethodLDAP), role.GetName()) {
+			searchRequest := ldapv3.NewSearchRequest(
+				a.getUserBase(),
+				ldapv3.ScopeWholeSubtree, ldapv3.NeverDerefAliases, 0, 0, false,
+				fmt.Sprintf(filter, ldapv3.EscapeFilter(group)),
+				a.userAttrs(),
+				nil,
+			)
+			sr, err := conn.SearchWithPaging(searchRequest, searchPageSize)
+			if err != nil {
+				return nil, err
+			}
+			for _, entry := range sr.Entries {
+				vdiUsers = appendUser(vdiUsers, entry.GetAttributeValue(a.cluster.GetLDAPUserIDAttribute()), userRole)
 			}
 		}
 	}
 
 	return vdiUsers, nil
-
 }
 
-// GetUser should retrieve a single VDIUser.
-func (a *AuthProvider) GetUser(username string) (*types.VDIUser, error) {
-	conn, err := a.connect()
-	if err != nil {
-		return nil, err
-	}
-	defer conn.Close()
-
-	if err := a.bind(conn); err != nil {
-		return nil, err
-	}
-
-	// fetch the role mappings
-	roles, err := a.cluster.GetRoles(a.client)
-	if err != nil {
-		return nil, err
-	}
-
+// listUsersByGroups resolves the groups of every user in the directory and returns
+// those bound to at least one role.
+func (a *AuthProvider) listUsersByGroups(conn ldapv3.Client, bindings *rbacutil.RoleBindings) ([]*types.VDIUser, error) {
 	searchRequest := ldapv3.NewSearchRequest(
 		a.getUserBase(),
 		ldapv3.ScopeWholeSubtree, ldapv3.NeverDerefAliases, 0, 0, false,
-		fmt.Sprintf(a.userFilter(), username),
+		a.allUsersFilter(),
 		a.userAttrs(),
 		nil,
 	)
-	sr, err := conn.Search(searchRequest)
+	sr, err := conn.SearchWithPaging(searchRequest, searchPageSize)
 	if err != nil {
 		return nil, err
 	}
 
-	if len(sr.Entries) != 1 {
-		return nil, errors.NewUserNotFoundError(fmt.Sprintf("Received %d matches for %s", len(sr.Entries), username))
+	vdiUsers := make([]*types.VDIUser, 0)
+	for _, entry := range sr.Entries {
+		userGroups, err := a.getUserGroups(conn, entry)
+		if err != nil {
+			return nil, err
+		}
+		name := entry.GetAttributeValue(a.cluster.GetLDAPUserIDAttribute())
+		boundRoles := bindings.BoundRoles(string(appv1.AuthMethodLDAP), name, userGroups)
+		if len(boundRoles) == 0 {
+			continue
+		}
+		vdiUsers = append(vdiUsers, &types.VDIUser{
+			Name:  name,
+			Roles: boundRoles,
+		})
 	}
 
-	user := sr.Entries[0]
+	return vdiUsers, nil
+}
 
-	vdiUser := &types.VDIUser{
-		Name:  username,
-		Roles: make([]*types.VDIUserRole, 0),
+// GetUser should retrieve a single VDIUser.
+func (a *AuthProvider) GetUser(username string) (*types.VDIUser, error) {
+	// fetch the role mappings
+	bindings, err := rbacutil.GetRoleBindings(a.client, a.cluster)
+	if err != nil {
+		return nil, err
 	}
 
-RoleLoop:
-	for _, role := range roles {
-		if annotations := role.GetAnnotations(); annotations != nil {
-			if ldapGroupStr, ok := annotations[v1.LDAPGroupRoleAnnotation]; ok {
-			GroupLoop:
-				for _, group := range strings.Split(ldapGroupStr, v1.AuthGroupSeparator) {
-					if group == "" {
-						continue GroupLoop
-					}
-					if common.StringSliceContains(user.GetAttributeValues(a.cluster.GetLDAPUserGroupsAttribute()), group) {
-						vdiUser.Roles = append(vdiUser.Roles, rbacutil.VDIRoleToUserRole(role))
-						continue RoleLoop
-					}
-				}
-			}
+	var user *ldapv3.Entry
+	var userGroups []string
+	err = a.withConn(func(conn ldapv3.Client) error {
+		searchRequest := ldapv3.NewSearchRequest(
+			a.getUserBase(),
+			ldapv3.ScopeWholeSubtree, ldapv3.NeverDerefAliases, 0, 0, false,
+			fmt.Sprintf(a.userFilter(), ldapv3.EscapeFilter(username)),
+			a.userAttrs(),
+			nil,
+		)
+		sr, err := conn.Search(searchRequest)
+		if err != nil {
+			return err
 		}
+		if len(sr.Entries) != 1 {
+			return errors.NewUserNotFoundError(fmt.Sprintf("Received %d matches for %s", len(sr.Entries), username))
+		}
+		user = sr.Entries[0]
+		userGroups, err = a.getUserGroups(conn, user)
+		return err
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	return vdiUser, nil
+	return &types.VDIUser{
+		Name:  username,
+		Roles: bindings.BoundRoles(string(appv1.AuthMethodLDAP), username, userGroups),
+	}, nil
 }
 
 // CreateUser should handle any logic required to register a new user in kVDI.
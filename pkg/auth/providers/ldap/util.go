@@ -23,6 +23,10 @@ import (
 	"fmt"
 )
 
+// searchPageSize is the page size used for searches that can return many entries.
+// Active Directory returns at most 1000 entries per page by default.
+const searchPageSize = 500
+
 func (a *AuthProvider) getUserBase() string {
 	if base := a.cluster.GetLDAPSearchBase(); base != "" {
 		return base
@@ -30,6 +34,13 @@ func (a *AuthProvider) getUserBase() string {
 	return a.baseDN
 }
 
+func (a *AuthProvider) getGroupBase() string {
+	if base := a.cluster.GetLDAPGroupSearchBase(); base != "" {
+		return base
+	}
+	return a.baseDN
+}
+
 func (a *AuthProvider) userAttrs() []string {
 	attrs := []string{"cn", "dn", a.cluster.GetLDAPUserIDAttribute(), a.cluster.GetLDAPUserGroupsAttribute()}
 	if a.cluster.GetLDAPDoUserStatusCheck() {
@@ -45,3 +56,14 @@ func (a *AuthProvider) userFilter() string {
 func (a *AuthProvider) groupUsersFilter() string {
 	return fmt.Sprintf("(%s=%%s)", a.cluster.GetLDAPUserGroupsAttribute())
 }
+
+// groupUsersInChainFilter matches the users that are members of a group through any
+// number of nested groups in Active Directory.
+func (a *AuthProvider) groupUsersInChainFilter() string {
+	return fmt.Sprintf("(%s:%s:=%%s)", a.cluster.GetLDAPUserGroupsAttribute(), matchingRuleInChain)
+}
+
+// allUsersFilter matches every user with an ID.
+func (a *AuthProvider) allUsersFilter() string {
+	return fmt.Sprintf("(%s=*)", a.cluster.GetLDAPUserIDAttribute())
+}
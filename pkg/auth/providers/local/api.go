@@ -21,13 +21,13 @@ package local
 
 import (
 	"github.com/kvdi/kvdi/pkg/types"
-	"github.com/kvdi/kvdi/pkg/util/apiutil"
 	"github.com/kvdi/kvdi/pkg/util/common"
+	rbacutil "github.com/kvdi/kvdi/pkg/util/rbac"
 )
 
 // GetUsers implements AuthProvider and serves a GET /api/users request
 func (a *AuthProvider) GetUsers() ([]*types.VDIUser, error) {
-	roles, err := a.cluster.GetRoles(a.client)
+	bindings, err := rbacutil.GetRoleBindings(a.client, a.cluster)
 	if err != nil {
 		return nil, err
 	}
@@ -39,7 +39,7 @@ func (a *AuthProvider) GetUsers() ([]*types.VDIUser, error) {
 	for _, user := range users {
 		res = append(res, &types.VDIUser{
 			Name:  user.Username,
-			Roles: apiutil.FilterUserRolesByNames(roles, user.Groups),
+			Roles: user.Roles(bindings),
 		})
 	}
 
@@ -48,6 +48,9 @@ func (a *AuthProvider) GetUsers() ([]*types.VDIUser, error) {
 
 // CreateUser implements AuthProvider and serves a POST /api/users request
 func (a *AuthProvider) CreateUser(req *types.CreateUserRequest) error {
+	if err := checkPasswordRules(a.cluster.GetPasswordPolicy(), req.Password); err != nil {
+		return err
+	}
 	passwdHash, err := common.HashPassword(req.Password)
 	if err != nil {
 		return err
@@ -67,14 +70,14 @@ func (a *AuthProvider) GetUser(username string) (*types.VDIUser, error) {
 		return nil, err
 	}
 
-	roles, err := a.cluster.GetRoles(a.client)
+	bindings, err := rbacutil.GetRoleBindings(a.client, a.cluster)
 	if err != nil {
 		return nil, err
 	}
 
 	return &types.VDIUser{
 		Name:  user.Username,
-		Roles: apiutil.FilterUserRolesByNames(roles, user.Groups),
+		Roles: user.Roles(bindings),
 	}, nil
 }
 
@@ -85,13 +88,16 @@ func (a *AuthProvider) UpdateUser(username string, req *types.UpdateUserRequest)
 		user.Groups = req.Roles
 	}
 	if req.Password != "" {
+		if err := checkPasswordRules(a.cluster.GetPasswordPolicy(), req.Password); err != nil {
+			return err
+		}
 		passwdHash, err := common.HashPassword(req.Password)
 		if err != nil {
 			return err
 		}
 		user.PasswordHash = passwdHash
 	}
-	return a.updateUser(user)
+	return a.updateUser(user, req.Password)
 }
 
 // DeleteUser implements AuthProvider and serves a DELETE /api/users/{user} request
@@ -23,11 +23,11 @@ import (
 	"errors"
 
 	"github.com/kvdi/kvdi/pkg/types"
-	"github.com/kvdi/kvdi/pkg/util/apiutil"
+	rbacutil "github.com/kvdi/kvdi/pkg/util/rbac"
 )
 
-// Authenticate implements AuthProvider and simply checks the provided password
-// in the request against the hash in the file.
+// Authenticate implements AuthProvider and checks the provided password in the
+// request against the hash in the file.
 func (a *AuthProvider) Authenticate(req *types.LoginRequest) (*types.AuthResult, error) {
 	user := &types.VDIUser{
 		Name:  req.Username,
@@ -37,13 +37,23 @@ func (a *AuthProvider) Authenticate(req *types.LoginRequest) (*types.AuthResult,
 	if err != nil {
 		return nil, err
 	}
+	if localUser.Disabled {
+		return nil, errors.New("user is disabled")
+	}
 	if !localUser.PasswordMatchesHash(req.Password) {
 		return nil, errors.New("invalid credentials")
 	}
-	roles, err := a.cluster.GetRoles(a.client)
+	// now that we know the password, replace an outdated hash and check if the
+	// password must be changed
+	expired, err := a.checkLoginPassword(localUser, req.Password)
+	if err != nil {
+		return nil, err
+	}
+	user.PasswordChangeRequired = expired
+	bindings, err := rbacutil.GetRoleBindings(a.client, a.cluster)
 	if err != nil {
 		return nil, err
 	}
-	user.Roles = apiutil.FilterUserRolesByNames(roles, localUser.Groups)
+	user.Roles = localUser.Roles(bindings)
 	return &types.AuthResult{User: user}, nil
 }
@@ -0,0 +1,244 @@
+/*
+
+Copyright 2020,2021 Avi Zimmerman
+
+This file is part of kvdi.
+
+kvdi is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+kvdi is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package local
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+
+	appv1 "github.com/kvdi/kvdi/apis/app/v1"
+	"github.com/kvdi/kvdi/pkg/util/common"
+	"github.com/kvdi/kvdi/pkg/util/errors"
+)
+
+// passwdMetaKey is where a mapping of users to when their password was last changed
+// and their previous password hashes is stored in the secrets backend.
+const passwdMetaKey = "passwdMeta"
+
+// passwordMeta is the record kept for a user's password alongside the passwd file.
+type passwordMeta struct {
+	// When the password was last changed
+	ChangedAt time.Time `json:"changedAt"`
+	// The hashes of the user's most recent passwords, newest first
+	History []string `json:"history,omitempty"`
+}
+
+// checkPasswordRules returns an error describing the rules of the given policy that
+// the password does not follow.
+func checkPasswordRules(policy *appv1.PasswordPolicy, passw string) error {
+	var hasUpper, hasLower, hasDigit, hasSymbol bool
+	for _, r := range passw {
+		switch {
+		case unicode.IsUpper(r):
+			hasUpper = true
+		case unicode.IsLower(r):
+			hasLower = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		default:
+			hasSymbol = true
+		}
+	}
+	unmet := make([]string, 0)
+	if policy.MinLength > 0 && len([]rune(passw)) < policy.MinLength {
+		unmet = append(unmet, fmt.Sprintf("be at least %d characters long", policy.MinLength))
+	}
+	if policy.RequireUppercase && !hasUpper {
+		unmet = append(unmet, "contain an uppercase letter")
+	}
+	if policy.RequireLowercase && !hasLower {
+		unmet = append(unmet, "contain a lowercase letter")
+	}
+	if policy.RequireDigit && !hasDigit {
+		unmet = append(unmet, "contain a digit")
+	}
+	if policy.RequireSymbol && !hasSymbol {
+		unmet = append(unmet, "contain a symbol")
+	}
+	if len(unmet) > 0 {
+		return fmt.Errorf("Password must %s", strings.Join(unmet, ", "))
+	}
+	return nil
+}
+
+// checkPasswordHistory returns an error if the password matches one of the most recent
+// passwords of the user, as limited by the size of the history in the policy.
+func checkPasswordHistory(policy *appv1.PasswordPolicy, meta *passwordMeta, currentHash, passw string) error {
+	if policy.HistorySize <= 0 {
+		return nil
+	}
+	hashes := []string{currentHash}
+	if meta != nil && len(meta.History) > 0 {
+		// the history already starts with the current password when it is in sync
+		hashes = meta.History
+		if meta.History[0] != currentHash {
+			hashes = append([]string{currentHash}, meta.History...)
+		}
+	}
+	if len(hashes) > policy.HistorySize {
+		hashes = hashes[:policy.HistorySize]
+	}
+	for _, hash := range hashes {
+		if hash != "" && common.PasswordMatchesHash(passw, hash) {
+			return errors.New("Password was used recently and cannot be reused")
+		}
+	}
+	return nil
+}
+
+// readPasswordMeta reads the password records of all users from the secrets backend.
+func (a *AuthProvider) readPasswordMeta() (map[string]*passwordMeta, error) {
+	data, err := a.secrets.ReadSecretMap(passwdMetaKey, false)
+	if err != nil {
+		if errors.IsSecretNotFoundError(err) {
+			return map[string]*passwordMeta{}, nil
+		}
+		return nil, err
+	}
+	meta := make(map[string]*passwordMeta, len(data))
+	for user, raw := range data {
+		m := &passwordMeta{}
+		if err := json.Unmarshal(raw, m); err != nil {
+			return nil, err
+		}
+		meta[user] = m
+	}
+	return meta, nil
+}
+
+func (a *AuthProvider) writePasswordMeta(meta map[string]*passwordMeta) error {
+	data := make(map[string][]byte, len(meta))
+	for user, m := range meta {
+		raw, err := json.Marshal(m)
+		if err != nil {
+			return err
+		}
+		data[user] = raw
+	}
+	return a.secrets.WriteSecretMap(passwdMetaKey, data)
+}
+
+// recordPasswordChange records that the user's password was changed to the given hash.
+// The caller must hold the secrets lock.
+func (a *AuthProvider) recordPasswordChange(username, hash string) error {
+	meta, err := a.readPasswordMeta()
+	if err != nil {
+		return err
+	}
+	m, ok := meta[username]
+	if !ok {
+		m = &passwordMeta{}
+		meta[username] = m
+	}
+	m.ChangedAt = time.Now()
+	// the history includes the current password so it survives further changes
+	if size := a.cluster.GetPasswordPolicy().HistorySize; size > 0 {
+		m.History = append([]string{hash}, m.History...)
+		if len(m.History) > size {
+			m.History = m.History[:size]
+		}
+	} else {
+		m.History = nil
+	}
+	return a.writePasswordMeta(meta)
+}
+
+// deletePasswordMeta removes the password record for the given user. The caller
+// must hold the secrets lock.
+func (a *AuthProvider) deletePasswordMeta(username string) error {
+	meta, err := a.readPasswordMeta()
+	if err != nil {
+		return err
+	}
+	if _, ok := meta[username]; !ok {
+		return nil
+	}
+	delete(meta, username)
+	return a.writePasswordMeta(meta)
+}
+
+// checkLoginPassword is called after the given password was verified for the user. It
+// replaces outdated password hashes and returns whether the password has expired.
+func (a *AuthProvider) checkLoginPassword(user *User, passw string) (expired bool, err error) {
+	maxAge := a.cluster.GetPasswordPolicy().GetMaxAge()
+	meta, err := a.readPasswordMeta()
+	if err != nil {
+		return false, err
+	}
+	userMeta, tracked := meta[user.Username]
+	if tracked && maxAge > 0 {
+		expired = time.Since(userMeta.ChangedAt) > maxAge
+	}
+	rehash := common.PasswordHashNeedsRehash(user.PasswordHash)
+	// passwords set before expiry was configured start aging now
+	startClock := !tracked && maxAge > 0
+	if !rehash && !startClock {
+		return expired, nil
+	}
+
+	if err := a.secrets.Lock(15); err != nil {
+		return false, err
+	}
+	defer a.secrets.Release()
+	if rehash {
+		if err := a.rehashPassword(user, passw); err != nil {
+			return false, err
+		}
+	}
+	if startClock {
+		if meta, err = a.readPasswordMeta(); err != nil {
+			return false, err
+		}
+		if _, ok := meta[user.Username]; !ok {
+			meta[user.Username] = &passwordMeta{ChangedAt: time.Now()}
+			if err := a.writePasswordMeta(meta); err != nil {
+				return false, err
+			}
+		}
+	}
+	return expired, nil
+}
+
+// rehashPassword replaces the stored hash for the user with a new one created from the
+// given password. If the password was changed in the meantime nothing is done. The
+// caller must hold the secrets lock.
+func (a *AuthProvider) rehashPassword(user *User, passw string) error {
+	obj, err := a.getUserObject(user.Username)
+	if err != nil {
+		return err
+	}
+	current, err := a.userFromObject(obj)
+	if err != nil {
+		return err
+	}
+	if current.PasswordHash != user.PasswordHash {
+		return nil
+	}
+	hash, err := common.HashPassword(passw)
+	if err != nil {
+		return err
+	}
+	return a.writePasswordHash(obj, hash)
+}
@@ -0,0 +1,218 @@
+/*
+
+Copyright 2020,2021 Avi Zimmerman
+
+This file is part of kvdi.
+
+kvdi is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+kvdi is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package local
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	appv1 "github.com/kvdi/kvdi/apis/app/v1"
+	"github.com/kvdi/kvdi/pkg/types"
+	"github.com/kvdi/kvdi/pkg/util/common"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func policyProviderSetUp(t *testing.T, policy *appv1.PasswordPolicy) *AuthProvider {
+	t.Helper()
+	provider := providerSetUp(t)
+	provider.cluster.Spec.Auth = &appv1.AuthConfig{
+		LocalAuth: &appv1.LocalAuthConfig{PasswordPolicy: policy},
+	}
+	return provider
+}
+
+func mustAuthenticate(t *testing.T, provider *AuthProvider, username, passw string) *types.AuthResult {
+	t.Helper()
+	result, err := provider.Authenticate(&types.LoginRequest{Username: username, Password: passw})
+	if err != nil {
+		t.Fatal("Expected to authenticate, got:", err)
+	}
+	return result
+}
+
+func TestCheckPasswordRules(t *testing.T) {
+	policy := &appv1.PasswordPolicy{
+		MinLength:        8,
+		RequireUppercase: true,
+		RequireLowercase: true,
+		RequireDigit:     true,
+		RequireSymbol:    true,
+	}
+	tc := []struct {
+		password string
+		unmet    []string
+	}{
+		{"Passw0rd!", nil},
+		{"Pässw0rd!", nil},
+		{"Pa0!", []string{"at least 8 characters"}},
+		{"password", []string{"uppercase", "digit", "symbol"}},
+		{"PASSWORD1!", []string{"lowercase"}},
+		{"Password1", []string{"symbol"}},
+	}
+	for _, c := range tc {
+		err := checkPasswordRules(policy, c.password)
+		if len(c.unmet) == 0 {
+			if err != nil {
+				t.Errorf("Expected %q to follow the policy, got: %s", c.password, err)
+			}
+			continue
+		}
+		if err == nil {
+			t.Errorf("Expected %q to not follow the policy", c.password)
+			continue
+		}
+		for _, unmet := range c.unmet {
+			if !strings.Contains(err.Error(), unmet) {
+				t.Errorf("Expected error for %q to mention %q, got: %s", c.password, unmet, err)
+			}
+		}
+	}
+
+	// an empty policy accepts anything
+	if err := checkPasswordRules(&appv1.PasswordPolicy{}, "a"); err != nil {
+		t.Error("Expected empty policy to accept any password, got:", err)
+	}
+}
+
+func TestPasswordPolicy(t *testing.T) {
+	provider := policyProviderSetUp(t, &appv1.PasswordPolicy{MinLength: 8, HistorySize: 2})
+
+	if err := provider.CreateUser(&types.CreateUserRequest{Username: "alice", Password: "short", Roles: []string{testGroup}}); err == nil {
+		t.Error("Expected error creating user with a short password")
+	}
+	if err := provider.CreateUser(&types.CreateUserRequest{Username: "alice", Password: "password-1", Roles: []string{testGroup}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := provider.UpdateUser("alice", &types.UpdateUserRequest{Password: "short"}); err == nil {
+		t.Error("Expected error updating user with a short password")
+	}
+
+	for _, step := range []struct {
+		password string
+		reused   bool
+	}{
+		{"password-1", true},
+		{"password-2", false},
+		{"password-1", true},
+		{"password-3", false},
+		// password-1 has fallen out of the history
+		{"password-1", false},
+	} {
+		err := provider.UpdateUser("alice", &types.UpdateUserRequest{Password: step.password})
+		if step.reused && err == nil {
+			t.Errorf("Expected error reusing %q", step.password)
+		} else if !step.reused && err != nil {
+			t.Errorf("Expected to be able to change password to %q, got: %s", step.password, err)
+		}
+	}
+	mustAuthenticate(t, provider, "alice", "password-1")
+
+	// role only updates don't touch the password
+	if err := provider.UpdateUser("alice", &types.UpdateUserRequest{Roles: []string{"other-group"}}); err != nil {
+		t.Fatal(err)
+	}
+	mustAuthenticate(t, provider, "alice", "password-1")
+
+	// deleting the user removes their password history
+	if err := provider.DeleteUser("alice"); err != nil {
+		t.Fatal(err)
+	}
+	meta, err := provider.readPasswordMeta()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := meta["alice"]; ok {
+		t.Error("Expected password record to be removed with the user")
+	}
+}
+
+func TestPasswordExpiry(t *testing.T) {
+	provider := policyProviderSetUp(t, &appv1.PasswordPolicy{MaxAge: "1h"})
+
+	if err := provider.CreateUser(&types.CreateUserRequest{Username: "alice", Password: "password", Roles: []string{testGroup}}); err != nil {
+		t.Fatal(err)
+	}
+	if result := mustAuthenticate(t, provider, "alice", "password"); result.User.PasswordChangeRequired {
+		t.Error("Expected new password to not require a change")
+	}
+
+	// age the password
+	meta, err := provider.readPasswordMeta()
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta["alice"].ChangedAt = time.Now().Add(-2 * time.Hour)
+	if err := provider.writePasswordMeta(meta); err != nil {
+		t.Fatal(err)
+	}
+	if result := mustAuthenticate(t, provider, "alice", "password"); !result.User.PasswordChangeRequired {
+		t.Error("Expected old password to require a change")
+	}
+
+	if err := provider.UpdateUser("alice", &types.UpdateUserRequest{Password: "new-password"}); err != nil {
+		t.Fatal(err)
+	}
+	if result := mustAuthenticate(t, provider, "alice", "new-password"); result.User.PasswordChangeRequired {
+		t.Error("Expected changed password to not require a change")
+	}
+}
+
+func TestRehashOnLogin(t *testing.T) {
+	provider := policyProviderSetUp(t, &appv1.PasswordPolicy{MaxAge: "1h"})
+
+	// a user from before argon2id hashes and password expiry
+	legacy, err := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := &User{Username: "alice", Groups: []string{testGroup}, PasswordHash: string(legacy)}
+	if err := provider.createUserObject(user); err != nil {
+		t.Fatal(err)
+	}
+
+	if result := mustAuthenticate(t, provider, "alice", "password"); result.User.PasswordChangeRequired {
+		t.Error("Expected untracked password to not require a change")
+	}
+	updated, err := provider.getUser("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.PasswordHash == string(legacy) || common.PasswordHashNeedsRehash(updated.PasswordHash) {
+		t.Error("Expected legacy hash to be replaced, got", updated.PasswordHash)
+	}
+	if len(updated.Groups) != 1 || updated.Groups[0] != testGroup {
+		t.Error("Expected groups to be preserved, got", updated.Groups)
+	}
+	meta, err := provider.readPasswordMeta()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := meta["alice"]; !ok {
+		t.Error("Expected the password to start aging after login")
+	}
+
+	mustAuthenticate(t, provider, "alice", "password")
+	if _, err := provider.Authenticate(&types.LoginRequest{Username: "alice", Password: "wrong"}); err == nil {
+		t.Error("Expected error authenticating with the wrong password")
+	}
+}
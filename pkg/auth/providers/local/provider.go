@@ -17,8 +17,8 @@ You should have received a copy of the GNU General Public License
 along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
 */
 
-// Package local contains an AuthProvider implementation backed by a passwd-like file
-// stored in the secrets backend.
+// Package local contains an AuthProvider implementation backed by VDIUser resources,
+// with password hashes stored in secrets referenced by them.
 // This is primarily meant for testing, but could also be used in small setups.
 package local
 
@@ -30,15 +30,15 @@ import (
 	"github.com/kvdi/kvdi/pkg/secrets"
 )
 
-// AuthProvider implements an AuthProvider that uses a local secret similar
-// to a passwd file to authenticate users and map them to roles. This is primarily
-// intended for testing and ideally external auth providers would be supported.
+// AuthProvider implements an AuthProvider that uses VDIUser resources to authenticate
+// users and map them to roles. This is primarily intended for testing and ideally
+// external auth providers would be supported.
 type AuthProvider struct {
 	// k8s client
 	client client.Client
 	// our cluster instance
 	cluster *appv1.VDICluster
-	// the secrets engine where we store our passwd
+	// the secrets engine where we store password records
 	secrets *secrets.SecretEngine
 }
 
@@ -24,6 +24,7 @@ import (
 	"testing"
 
 	appv1 "github.com/kvdi/kvdi/apis/app/v1"
+	kvdirbacv1 "github.com/kvdi/kvdi/apis/rbac/v1"
 
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
@@ -43,6 +44,7 @@ func getFakeClient(t *testing.T) client.Client {
 	corev1.AddToScheme(scheme)
 	appsv1.AddToScheme(scheme)
 	rbacv1.AddToScheme(scheme)
+	kvdirbacv1.AddToScheme(scheme)
 	return fake.NewFakeClientWithScheme(scheme)
 }
 
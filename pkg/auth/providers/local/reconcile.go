@@ -1,4 +1,5 @@
 /*
+
 Copyright 2020,2021 Avi Zimmerman
 
 This file is part of kvdi.
@@ -15,13 +16,14 @@ GNU General Public License for more details.
 
 You should have received a copy of the GNU General Public License
 along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
+
 */
 
 package local
 
 import (
+	"bytes"
 	"context"
-	"fmt"
 
 	appv1 "github.com/kvdi/kvdi/apis/app/v1"
 	"github.com/kvdi/kvdi/pkg/util/common"
@@ -31,22 +33,80 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
+// passwdKey is where users were stored before they were moved to VDIUsers. It is
+// still used to mark that the local users of a cluster were initialized.
 const passwdKey = "passwd"
 
+// passwdMigratedMarker replaces the contents of the passwd file once its users are
+// imported as VDIUsers.
+const passwdMigratedMarker = "# users have been migrated to VDIUser resources\n"
+
 // Reconcile prepares the resources required to use the local authentication driver.
+// The first time it runs for a cluster an admin user is created. If users from a
+// passwd file are found they are imported as VDIUsers.
 func (l *AuthProvider) Reconcile(ctx context.Context, reqLogger logr.Logger, c client.Client, cluster *appv1.VDICluster, adminPass string) error {
-	if _, err := l.secrets.ReadSecret(passwdKey, false); err != nil {
+	if err := l.Setup(c, cluster); err != nil {
+		return err
+	}
+	data, err := l.secrets.ReadSecret(passwdKey, false)
+	if err != nil {
 		if !errors.IsSecretNotFoundError(err) {
 			return err
 		}
-		adminRole := cluster.GetAdminRole()
-		hash, err := common.HashPassword(adminPass)
-		if err != nil {
+		if err := l.bootstrapAdminUser(reqLogger, adminPass); err != nil {
+			return err
+		}
+		return l.secrets.WriteSecret(passwdKey, []byte(passwdMigratedMarker))
+	}
+	return l.migratePasswdFile(reqLogger, data)
+}
+
+// bootstrapAdminUser creates the admin user with the given password, unless an admin
+// user already exists.
+func (l *AuthProvider) bootstrapAdminUser(reqLogger logr.Logger, adminPass string) error {
+	if _, err := l.getUserObject("admin"); err == nil {
+		return nil
+	} else if !errors.IsUserNotFoundError(err) {
+		return err
+	}
+	hash, err := common.HashPassword(adminPass)
+	if err != nil {
+		return err
+	}
+	reqLogger.Info("Creating admin user")
+	return l.createUser(&User{
+		Username:     "admin",
+		Groups:       []string{l.cluster.GetAdminRole().GetName()},
+		PasswordHash: hash,
+	})
+}
+
+// migratePasswdFile imports the users in the given passwd file as VDIUsers and then
+// replaces the file with a marker. Users that already have a VDIUser are skipped.
+// Existing hashes are kept as-is and replaced the next time the user logs in.
+func (l *AuthProvider) migratePasswdFile(reqLogger logr.Logger, data []byte) error {
+	users, err := getAllUsersFromBuffer(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	if len(users) == 0 {
+		return nil
+	}
+	if err := l.secrets.Lock(15); err != nil {
+		return err
+	}
+	defer l.secrets.Release()
+	for _, user := range users {
+		if _, err := l.getUserObject(user.Username); err == nil {
+			reqLogger.Info("Skipping passwd user that already has a VDIUser", "User", user.Username)
+			continue
+		} else if !errors.IsUserNotFoundError(err) {
 			return err
 		}
-		if err := l.secrets.WriteSecret(passwdKey, []byte(fmt.Sprintf("admin:%s:%s\n", adminRole.GetName(), hash))); err != nil {
+		reqLogger.Info("Importing user from passwd file", "User", user.Username)
+		if err := l.createUserObject(user); err != nil {
 			return err
 		}
 	}
-	return nil
+	return l.secrets.WriteSecret(passwdKey, []byte(passwdMigratedMarker))
 }
@@ -0,0 +1,117 @@
+/*
+
+Copyright 2020,2021 Avi Zimmerman
+
+This file is part of kvdi.
+
+kvdi is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+kvdi is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package local
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/kvdi/kvdi/pkg/types"
+	"github.com/kvdi/kvdi/pkg/util/errors"
+
+	"github.com/go-logr/logr"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestReconcileAdminUser(t *testing.T) {
+	provider := providerSetUp(t)
+
+	if err := provider.Reconcile(context.TODO(), logr.Discard(), provider.client, provider.cluster, "testing"); err != nil {
+		t.Fatal(err)
+	}
+	admin, err := provider.getUser("admin")
+	if err != nil {
+		t.Fatal("Expected admin user to be created, got:", err)
+	}
+	if len(admin.Groups) != 1 || admin.Groups[0] != provider.cluster.GetAdminRole().GetName() {
+		t.Error("Expected admin user to have the admin role, got", admin.Groups)
+	}
+	if _, err := provider.Authenticate(&types.LoginRequest{Username: "admin", Password: "testing"}); err != nil {
+		t.Error("Expected to authenticate as admin, got:", err)
+	}
+
+	// the admin user is only created once
+	if err := provider.DeleteUser("admin"); err != nil {
+		t.Fatal(err)
+	}
+	if err := provider.Reconcile(context.TODO(), logr.Discard(), provider.client, provider.cluster, "testing"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := provider.getUser("admin"); !errors.IsUserNotFoundError(err) {
+		t.Error("Expected deleted admin user to not be recreated, got:", err)
+	}
+}
+
+func TestReconcilePasswdMigration(t *testing.T) {
+	provider := providerSetUp(t)
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	for _, name := range []string{"admin", "alice", "Bob_Smith"} {
+		buf.Write((&User{Username: name, Groups: []string{testGroup}, PasswordHash: string(hash)}).Encode())
+	}
+	if err := provider.updatePasswdFile(&buf); err != nil {
+		t.Fatal(err)
+	}
+	// users already managed as VDIUsers are left alone
+	if err := provider.createUserObject(&User{Username: "admin", Groups: []string{"other-group"}, PasswordHash: testHash}); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := provider.Reconcile(context.TODO(), logr.Discard(), provider.client, provider.cluster, "testing"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	users, err := provider.GetUsers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 3 {
+		t.Fatal("Expected 3 users after migration, got", len(users))
+	}
+	admin, err := provider.getUser("admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if admin.PasswordHash != testHash || admin.Groups[0] != "other-group" {
+		t.Error("Expected existing VDIUser to not be overwritten, got", admin)
+	}
+	for _, name := range []string{"alice", "Bob_Smith"} {
+		if _, err := provider.Authenticate(&types.LoginRequest{Username: name, Password: "password"}); err != nil {
+			t.Errorf("Expected to authenticate migrated user %s, got: %s", name, err)
+		}
+	}
+
+	data, err := provider.secrets.ReadSecret(passwdKey, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != passwdMigratedMarker {
+		t.Error("Expected passwd file to be replaced after migration, got:", string(data))
+	}
+}
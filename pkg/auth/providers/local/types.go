@@ -24,7 +24,11 @@ import (
 	"fmt"
 	"strings"
 
+	appv1 "github.com/kvdi/kvdi/apis/app/v1"
+	"github.com/kvdi/kvdi/pkg/types"
+	"github.com/kvdi/kvdi/pkg/util/apiutil"
 	"github.com/kvdi/kvdi/pkg/util/common"
+	rbacutil "github.com/kvdi/kvdi/pkg/util/rbac"
 )
 
 // User is a struct implementation of a user as stored in the passwd file.
@@ -32,6 +36,11 @@ type User struct {
 	Username     string
 	Groups       []string
 	PasswordHash string
+	// The groups the user was provisioned into over SCIM. They are not stored in the
+	// passwd file.
+	SCIMGroups []string
+	// Disabled users cannot log in. This is not stored in the passwd file.
+	Disabled bool
 }
 
 // PasswordMatchesHash returns true if the supplied password matches the hash for this
@@ -40,6 +49,20 @@ func (u *User) PasswordMatchesHash(passw string) bool {
 	return common.PasswordMatchesHash(passw, u.PasswordHash)
 }
 
+// Roles returns the cluster roles the user is bound to, either by name, by VDIRoleBindings
+// or through the groups they were provisioned into.
+func (u *User) Roles(bindings *rbacutil.RoleBindings) []*types.VDIUserRole {
+	names := append([]string{}, u.Groups...)
+	bound := append(
+		bindings.BoundRoleNames(string(appv1.AuthMethodLocal), u.Username, nil),
+		bindings.BoundRoleNames(rbacutil.ProviderSCIM, "", u.SCIMGroups)...,
+	)
+	for _, name := range bound {
+		names = common.AppendStringIfMissing(names, name)
+	}
+	return apiutil.FilterUserRolesByNames(bindings.Roles(), names)
+}
+
 // Encode will return the string representation of this user for storage in the secret.
 func (u *User) Encode() []byte {
 	return []byte(fmt.Sprintf("%s:%s:%s\n", u.Username, strings.Join(u.Groups, ","), u.PasswordHash))
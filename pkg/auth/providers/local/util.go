@@ -1,4 +1,5 @@
 /*
+
 Copyright 2020,2021 Avi Zimmerman
 
 This file is part of kvdi.
@@ -15,78 +16,143 @@ GNU General Public License for more details.
 
 You should have received a copy of the GNU General Public License
 along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
+
 */
 
 package local
 
-// listUsers builds a map of users to their "groups".
+import (
+	"context"
+	"fmt"
+
+	"github.com/kvdi/kvdi/pkg/util/errors"
+)
+
+// listUsers returns all the VDIUsers of the cluster. Password hashes are not read
+// for the returned users.
 func (a *AuthProvider) listUsers() ([]*User, error) {
-	file, err := a.getPasswdFile()
+	objs, err := a.cluster.GetLocalUsers(a.client)
 	if err != nil {
 		return nil, err
 	}
-
-	return getAllUsersFromBuffer(file)
+	out := make([]*User, len(objs))
+	for i, obj := range objs {
+		out[i] = &User{
+			Username:   obj.GetUsername(),
+			Groups:     obj.Spec.Roles,
+			SCIMGroups: obj.Spec.Groups,
+			Disabled:   obj.Spec.Disabled,
+		}
+	}
+	return out, nil
 }
 
-// getUser retrieves a user, their groups, and their password hash
-// from the local file.
+// getUser retrieves a user, their groups, and their password hash.
 func (a *AuthProvider) getUser(username string) (*User, error) {
-	file, err := a.getPasswdFile()
+	obj, err := a.getUserObject(username)
 	if err != nil {
 		return nil, err
 	}
-	return getUserFromBuffer(file, username)
+	return a.userFromObject(obj)
 }
 
-// createUser adds a new user to the passwd file. If it already exists an error
-// is returned.
+// createUser creates a VDIUser and password secret for a new user. If it already
+// exists an error is returned.
 func (a *AuthProvider) createUser(user *User) error {
 	if err := a.secrets.Lock(15); err != nil {
 		return err
 	}
 	defer a.secrets.Release()
-	file, err := a.getPasswdFile()
-	if err != nil {
+	if err := a.createUserObject(user); err != nil {
 		return err
 	}
-	// addUserToBuffer returns an error if it finds a matching user in the file
-	// already
-	newFile, err := addUserToBuffer(file, user)
-	if err != nil {
+	return a.recordPasswordChange(user.Username, user.PasswordHash)
+}
+
+// createUserObject creates the VDIUser and password secret for a user without
+// touching their password record.
+func (a *AuthProvider) createUserObject(user *User) error {
+	if _, err := a.getUserObject(user.Username); err == nil {
+		return fmt.Errorf("a user with the name %s already exists", user.Username)
+	} else if !errors.IsUserNotFoundError(err) {
+		return err
+	}
+	obj := a.newUserObject(user)
+	if err := a.client.Create(context.TODO(), obj); err != nil {
+		return err
+	}
+	if err := a.writePasswordHash(obj, user.PasswordHash); err != nil {
+		// don't leave behind a user that can't log in
+		if derr := a.client.Delete(context.TODO(), obj); derr != nil {
+			return fmt.Errorf("%s: failed to clean up user: %s", err, derr)
+		}
 		return err
 	}
-	return a.updatePasswdFile(newFile)
+	return nil
 }
 
-func (a *AuthProvider) updateUser(user *User) error {
+// updateUser updates the groups or password hash of a user. When the password is
+// changed, the plain text password is checked against the user's password history.
+func (a *AuthProvider) updateUser(user *User, passw string) error {
 	if err := a.secrets.Lock(15); err != nil {
 		return err
 	}
 	defer a.secrets.Release()
-	file, err := a.getPasswdFile()
+	obj, err := a.getUserObject(user.Username)
 	if err != nil {
 		return err
 	}
-	newFile, err := updateUserInBuffer(file, user)
+	if user.PasswordHash != "" {
+		if err := a.checkUserPasswordHistory(user.Username, passw); err != nil {
+			return err
+		}
+	}
+	if len(user.Groups) != 0 {
+		obj.Spec.Roles = user.Groups
+		if err := a.client.Update(context.TODO(), obj); err != nil {
+			return err
+		}
+	}
+	if user.PasswordHash == "" {
+		return nil
+	}
+	if err := a.writePasswordHash(obj, user.PasswordHash); err != nil {
+		return err
+	}
+	return a.recordPasswordChange(user.Username, user.PasswordHash)
+}
+
+// checkUserPasswordHistory returns an error if the password was recently used by the
+// user. The caller must hold the secrets lock.
+func (a *AuthProvider) checkUserPasswordHistory(username, passw string) error {
+	policy := a.cluster.GetPasswordPolicy()
+	if policy.HistorySize <= 0 {
+		return nil
+	}
+	current, err := a.getUser(username)
+	if err != nil {
+		return err
+	}
+	meta, err := a.readPasswordMeta()
 	if err != nil {
 		return err
 	}
-	return a.updatePasswdFile(newFile)
+	return checkPasswordHistory(policy, meta[username], current.PasswordHash, passw)
 }
 
+// deleteUser removes the VDIUser for a user. Secrets created for the user's password
+// are owned by the VDIUser and garbage collected along with it.
 func (a *AuthProvider) deleteUser(username string) error {
 	if err := a.secrets.Lock(15); err != nil {
 		return err
 	}
 	defer a.secrets.Release()
-	file, err := a.getPasswdFile()
+	obj, err := a.getUserObject(username)
 	if err != nil {
 		return err
 	}
-	newFile, err := deleteUserInBuffer(file, username)
-	if err != nil {
+	if err := a.client.Delete(context.TODO(), obj); err != nil {
 		return err
 	}
-	return a.updatePasswdFile(newFile)
+	return a.deletePasswordMeta(username)
 }
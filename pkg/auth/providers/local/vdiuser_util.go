@@ -0,0 +1,144 @@
+/*
+
+Copyright 2020,2021 Avi Zimmerman
+
+This file is part of kvdi.
+
+kvdi is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+kvdi is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package local
+
+import (
+	"context"
+	"fmt"
+
+	v1 "github.com/kvdi/kvdi/apis/meta/v1"
+	rbacv1 "github.com/kvdi/kvdi/apis/rbac/v1"
+	"github.com/kvdi/kvdi/pkg/util/errors"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// getUserObject returns the VDIUser with the given username. An error is returned if
+// more than one VDIUser has the username, since there is no telling which one is meant.
+func (a *AuthProvider) getUserObject(username string) (*rbacv1.VDIUser, error) {
+	users, err := a.cluster.GetLocalUsers(a.client)
+	if err != nil {
+		return nil, err
+	}
+	var found *rbacv1.VDIUser
+	for _, user := range users {
+		if user.GetUsername() != username {
+			continue
+		}
+		if found != nil {
+			return nil, fmt.Errorf("the VDIUsers %s and %s both have the username %s", found.GetName(), user.GetName(), username)
+		}
+		found = user
+	}
+	if found == nil {
+		return nil, errors.NewUserNotFoundError(username)
+	}
+	return found, nil
+}
+
+// newUserObject returns a new VDIUser for the given user, with its password secret
+// named after it.
+func (a *AuthProvider) newUserObject(user *User) *rbacv1.VDIUser {
+	name := a.cluster.GetLocalUserObjectName(user.Username)
+	return &rbacv1.VDIUser{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   name,
+			Labels: map[string]string{v1.RoleClusterRefLabel: a.cluster.GetName()},
+		},
+		Spec: rbacv1.VDIUserSpec{
+			Username:          user.Username,
+			Roles:             user.Groups,
+			PasswordSecretRef: &rbacv1.PasswordSecretRef{Name: name},
+		},
+	}
+}
+
+// userFromObject converts a VDIUser to a User, reading the password hash from the
+// referenced secret. A missing secret results in an empty hash, which never
+// matches a password.
+func (a *AuthProvider) userFromObject(obj *rbacv1.VDIUser) (*User, error) {
+	user := &User{
+		Username:   obj.GetUsername(),
+		Groups:     obj.Spec.Roles,
+		SCIMGroups: obj.Spec.Groups,
+		Disabled:   obj.Spec.Disabled,
+	}
+	ref := obj.Spec.PasswordSecretRef
+	if ref == nil {
+		return user, nil
+	}
+	secret := &corev1.Secret{}
+	nn := types.NamespacedName{Name: ref.Name, Namespace: a.cluster.GetCoreNamespace()}
+	if err := a.client.Get(context.TODO(), nn, secret); err != nil {
+		if apierrors.IsNotFound(err) {
+			return user, nil
+		}
+		return nil, err
+	}
+	user.PasswordHash = string(secret.Data[ref.GetKey()])
+	return user, nil
+}
+
+// writePasswordHash stores the password hash for the given VDIUser in its referenced
+// secret. If the user does not reference a secret yet, one is created that is owned
+// by the VDIUser.
+func (a *AuthProvider) writePasswordHash(obj *rbacv1.VDIUser, hash string) error {
+	if obj.Spec.PasswordSecretRef == nil {
+		obj.Spec.PasswordSecretRef = &rbacv1.PasswordSecretRef{Name: obj.GetName()}
+		if err := a.client.Update(context.TODO(), obj); err != nil {
+			return err
+		}
+	}
+	ref := obj.Spec.PasswordSecretRef
+	secret := &corev1.Secret{}
+	nn := types.NamespacedName{Name: ref.Name, Namespace: a.cluster.GetCoreNamespace()}
+	if err := a.client.Get(context.TODO(), nn, secret); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return err
+		}
+		secret = &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      nn.Name,
+				Namespace: nn.Namespace,
+				Labels:    map[string]string{v1.RoleClusterRefLabel: a.cluster.GetName()},
+				OwnerReferences: []metav1.OwnerReference{
+					{
+						APIVersion: rbacv1.GroupVersion.String(),
+						Kind:       "VDIUser",
+						Name:       obj.GetName(),
+						UID:        obj.GetUID(),
+					},
+				},
+			},
+			Data: map[string][]byte{ref.GetKey(): []byte(hash)},
+		}
+		return a.client.Create(context.TODO(), secret)
+	}
+	if secret.Data == nil {
+		secret.Data = make(map[string][]byte)
+	}
+	secret.Data[ref.GetKey()] = []byte(hash)
+	return a.client.Update(context.TODO(), secret)
+}
@@ -0,0 +1,111 @@
+/*
+
+Copyright 2020,2021 Avi Zimmerman
+
+This file is part of kvdi.
+
+kvdi is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+kvdi is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package local
+
+import (
+	"context"
+	"testing"
+
+	"github.com/kvdi/kvdi/pkg/types"
+	"github.com/kvdi/kvdi/pkg/util/errors"
+)
+
+func TestUserObjectName(t *testing.T) {
+	provider := providerSetUp(t)
+
+	if name := provider.cluster.GetLocalUserObjectName("alice"); name != "test-cluster-alice" {
+		t.Error("Expected valid usernames to be used as-is, got", name)
+	}
+	seen := make(map[string]string)
+	for _, username := range []string{"Alice", "alice_", "alice@example.com", "@@@"} {
+		name := provider.cluster.GetLocalUserObjectName(username)
+		if name == "test-cluster-alice" {
+			t.Errorf("Expected %q to not collide with a valid username", username)
+		}
+		if other, ok := seen[name]; ok {
+			t.Errorf("Expected %q and %q to have different names, both got %s", username, other, name)
+		}
+		seen[name] = username
+	}
+}
+
+func TestPasswordSecretRef(t *testing.T) {
+	provider := providerSetUp(t)
+
+	if err := provider.CreateUser(&types.CreateUserRequest{Username: "alice", Password: "password", Roles: []string{testGroup}}); err != nil {
+		t.Fatal(err)
+	}
+	obj, err := provider.getUserObject("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if obj.Spec.PasswordSecretRef == nil || obj.Spec.PasswordSecretRef.Name != obj.GetName() {
+		t.Fatal("Expected user to reference a secret named after it, got", obj.Spec.PasswordSecretRef)
+	}
+
+	// a user without a secret can't log in until a password is set
+	obj.Spec.PasswordSecretRef = nil
+	if err := provider.client.Update(context.TODO(), obj); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := provider.Authenticate(&types.LoginRequest{Username: "alice", Password: "password"}); err == nil {
+		t.Error("Expected error authenticating without a password secret")
+	}
+	if err := provider.UpdateUser("alice", &types.UpdateUserRequest{Password: "new-password"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := provider.Authenticate(&types.LoginRequest{Username: "alice", Password: "new-password"}); err != nil {
+		t.Error("Expected to authenticate after setting a password, got:", err)
+	}
+}
+
+func TestDuplicateUsernames(t *testing.T) {
+	provider := providerSetUp(t)
+
+	if err := provider.CreateUser(&types.CreateUserRequest{Username: "alice", Password: "password", Roles: []string{testGroup}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := provider.CreateUser(&types.CreateUserRequest{Username: "alice", Password: "password", Roles: []string{testGroup}}); err == nil {
+		t.Error("Expected error creating a user with a taken username")
+	}
+
+	// a second VDIUser with the same username makes the name ambiguous
+	obj, err := provider.getUserObject("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := provider.newUserObject(&User{Username: "alice", Groups: []string{testGroup}})
+	other.Name = "other-alice"
+	other.Spec.PasswordSecretRef = obj.Spec.PasswordSecretRef
+	if err := provider.client.Create(context.TODO(), other); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := provider.getUserObject("alice"); err == nil || errors.IsUserNotFoundError(err) {
+		t.Error("Expected error looking up an ambiguous username, got:", err)
+	}
+	if _, err := provider.Authenticate(&types.LoginRequest{Username: "alice", Password: "password"}); err == nil {
+		t.Error("Expected error authenticating with an ambiguous username")
+	}
+	if err := provider.UpdateUser("alice", &types.UpdateUserRequest{Password: "new-password"}); err == nil {
+		t.Error("Expected error updating a user with an ambiguous username")
+	}
+}
@@ -28,11 +28,9 @@ import (
 
 	"golang.org/x/oauth2"
 
-	v1 "github.com/kvdi/kvdi/apis/meta/v1"
-	rbacv1 "github.com/kvdi/kvdi/apis/rbac/v1"
+	appv1 "github.com/kvdi/kvdi/apis/app/v1"
+	"github.com/kvdi/kvdi/pkg/auth/logins"
 	"github.com/kvdi/kvdi/pkg/types"
-	"github.com/kvdi/kvdi/pkg/util/apiutil"
-	"github.com/kvdi/kvdi/pkg/util/common"
 	"github.com/kvdi/kvdi/pkg/util/errors"
 	"github.com/kvdi/kvdi/pkg/util/rbac"
 )
@@ -62,11 +60,7 @@ func (a *AuthProvider) Authenticate(req *types.LoginRequest) (*types.AuthResult,
 			// If the secret is not found it means we have not generated claims yet
 			// for this user. Return the oauth redirect.
 			if errors.IsSecretNotFoundError(err) {
-				return &types.AuthResult{
-					// Use offline access to get a refresh token that we can use to generate new
-					// internal access tokens for the user.
-					RedirectURL: a.oauthCfg.AuthCodeURL(req.GetState(), oauth2.AccessTypeOffline),
-				}, nil
+				return a.startFlow(req.GetState())
 			}
 			return nil, err
 		}
@@ -86,31 +80,90 @@ func (a *AuthProvider) Authenticate(req *types.LoginRequest) (*types.AuthResult,
 	// sending another post to retrieve its token.
 
 	// fetch the state key from the request
-	stateKey := getStateSecretKey(r.URL.Query().Get("state"))
+	state := r.URL.Query().Get("state")
+	stateKey := getStateSecretKey(state)
+	// retrieve the verifier for the flow started with this state
+	verifier, err := a.popPKCEVerifier(state)
+	if err != nil {
+		return nil, err
+	}
 	// get the oauth token from the provider
-	oauth2Token, err := a.oauthCfg.Exchange(a.ctx, r.URL.Query().Get("code"))
+	oauth2Token, err := a.oauthCfg.Exchange(a.ctx, r.URL.Query().Get("code"), oauth2.SetAuthURLParam("code_verifier", verifier))
 	if err != nil {
 		return nil, err
 	}
 
-	// Extract the ID Token from OAuth2 token.
-	rawIDToken, ok := oauth2Token.Extra("id_token").(string)
-	if !ok {
+	// parse and verify the claims from the ID token
+	claims, rawIDToken, err := a.claimsFromToken(oauth2Token)
+	if err != nil {
 		return nil, err
 	}
+	if claims == nil {
+		return nil, errors.New("The OIDC provider did not return an id_token")
+	}
 
-	// Parse and verify ID Token payload.
-	idToken, err := a.verifier.Verify(a.ctx, rawIDToken)
+	result, err := a.resultFromClaims(claims, oauth2Token)
+	if err != nil {
+		return nil, err
+	}
+
+	// keep the tokens for refreshing and ending the session later, if there are any
+	// worth keeping, under the ID the login will be started with
+	loginID, err := logins.NewLoginID()
 	if err != nil {
 		return nil, err
 	}
+	if sess := a.newSession(result.User.Name, oauth2Token, rawIDToken); sess != nil {
+		if err := a.writeSession(loginID, sess); err != nil {
+			return nil, err
+		}
+	}
+	result.LoginID = loginID
 
-	// parse the claims from the token
+	// save the claims to the secret backend, they will be retrieved on the next POST
+	// for this state.
+	return nil, a.marshalClaimsToSecret(stateKey, result)
+}
+
+// startFlow records a PKCE verifier for the given state and returns the redirect
+// to the provider.
+func (a *AuthProvider) startFlow(state string) (*types.AuthResult, error) {
+	verifier, err := newPKCEVerifier()
+	if err != nil {
+		return nil, err
+	}
+	if err := a.writePKCEVerifier(state, verifier); err != nil {
+		return nil, err
+	}
+	// Use offline access to get a refresh token that we can use to generate new
+	// internal access tokens for the user.
+	opts := append([]oauth2.AuthCodeOption{oauth2.AccessTypeOffline}, pkceChallengeOptions(verifier)...)
+	return &types.AuthResult{
+		RedirectURL: a.oauthCfg.AuthCodeURL(state, opts...),
+	}, nil
+}
+
+// claimsFromToken verifies the ID token included with the given token and returns its
+// claims along with the raw ID token. The claims are nil if there is no ID token.
+func (a *AuthProvider) claimsFromToken(token *oauth2.Token) (map[string]interface{}, string, error) {
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok || rawIDToken == "" {
+		return nil, "", nil
+	}
+	idToken, err := a.verifier.Verify(a.ctx, rawIDToken)
+	if err != nil {
+		return nil, "", err
+	}
 	claims := make(map[string]interface{})
 	if err := idToken.Claims(&claims); err != nil {
-		return nil, err
+		return nil, "", err
 	}
+	return claims, rawIDToken, nil
+}
 
+// resultFromClaims builds the user and their roles from the given claims. The session
+// can be refreshed if tokens are preserved and the provider issued a refresh token.
+func (a *AuthProvider) resultFromClaims(claims map[string]interface{}, token *oauth2.Token) (*types.AuthResult, error) {
 	// start building a user from the claims object
 	username, err := getUsernameFromClaims(claims)
 	if err != nil {
@@ -122,16 +175,15 @@ func (a *AuthProvider) Authenticate(req *types.LoginRequest) (*types.AuthResult,
 			Name:  username,
 			Roles: make([]*types.VDIUserRole, 0),
 		},
-		RefreshNotSupported: true,
+		RefreshNotSupported: !a.cluster.PreserveOIDCTokens() || token.RefreshToken == "",
 	}
 
-	// BADDDDD
+	// The refresh token stays in the secrets backend, see newSession.
 	if a.cluster.PreserveOIDCTokens() {
 		result.Data = map[string]string{
-			"access_token":  oauth2Token.AccessToken,
-			"token_type":    oauth2Token.TokenType,
-			"refresh_token": oauth2Token.RefreshToken,
-			"expiry":        oauth2Token.Expiry.Format(time.RFC3339),
+			"access_token": token.AccessToken,
+			"token_type":   token.TokenType,
+			"expiry":       token.Expiry.Format(time.RFC3339),
 		}
 	}
 
@@ -142,7 +194,7 @@ func (a *AuthProvider) Authenticate(req *types.LoginRequest) (*types.AuthResult,
 		// allows the user in anyway.
 		if a.cluster.AllowNonGroupedReadOnly() {
 			result.User.Roles = []*types.VDIUserRole{rbac.VDIRoleToUserRole(a.cluster.GetLaunchTemplatesRole())}
-			return nil, a.marshalClaimsToSecret(stateKey, result)
+			return result, nil
 		}
 		return nil, errors.New("No groups provided in claims and allow non-grouped users is set to false")
 	}
@@ -153,22 +205,13 @@ func (a *AuthProvider) Authenticate(req *types.LoginRequest) (*types.AuthResult,
 	}
 
 	// At this point we are ready to authorize the user
-	roles, err := a.cluster.GetRoles(a.client)
+	bindings, err := rbac.GetRoleBindings(a.client, a.cluster)
 	if err != nil {
 		return nil, err
 	}
 
-	boundRoles := make([]string, 0)
-	for _, role := range roles {
-		boundRoles = appendRoleIfBound(boundRoles, userGroupSlc, role)
-	}
-
-	result.User.Roles = apiutil.FilterUserRolesByNames(roles, boundRoles)
-	fmt.Println("Saving claims to state key", stateKey)
-
-	// save the claims to the secret backend, they will be retrieved on the next POST
-	// for this state.
-	return nil, a.marshalClaimsToSecret(stateKey, result)
+	result.User.Roles = bindings.BoundRoles(string(appv1.AuthMethodOIDC), result.User.Name, userGroupSlc)
+	return result, nil
 }
 
 func (a *AuthProvider) marshalClaimsToSecret(stateKey string, result *types.AuthResult) error {
@@ -216,20 +259,3 @@ func getUsernameFromClaims(claims map[string]interface{}) (string, error) {
 	}
 	return "", fmt.Errorf("could not parse username from claims: %+v", claims)
 }
-
-func appendRoleIfBound(boundRoles, userGroups []string, role *rbacv1.VDIRole) []string {
-	if annotations := role.GetAnnotations(); annotations != nil {
-		if oidcGroupStr, ok := annotations[v1.OIDCGroupRoleAnnotation]; ok {
-			oidcGroups := strings.Split(oidcGroupStr, v1.AuthGroupSeparator)
-			for _, group := range oidcGroups {
-				if group == "" {
-					continue
-				}
-				if common.StringSliceContains(userGroups, group) {
-					boundRoles = common.AppendStringIfMissing(boundRoles, role.GetName())
-				}
-			}
-		}
-	}
-	return boundRoles
-}
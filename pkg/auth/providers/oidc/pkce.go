@@ -0,0 +1,172 @@
+/*
+
+Copyright 2020,2021 Avi Zimmerman
+
+This file is part of kvdi.
+
+kvdi is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+kvdi is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"sort"
+	"time"
+
+	"golang.org/x/oauth2"
+
+	v1 "github.com/kvdi/kvdi/apis/meta/v1"
+	"github.com/kvdi/kvdi/pkg/util/errors"
+)
+
+// PKCE (RFC 7636) binds the authorization code to the flow that requested it, so a
+// code intercepted on its way back to kvdi cannot be exchanged by anyone else.
+
+// pkceVerifierLength is the number of random bytes in a code verifier. Encoded it
+// yields the 43 characters the RFC recommends.
+const pkceVerifierLength = 32
+
+// newPKCEVerifier returns a new random code verifier.
+func newPKCEVerifier() (string, error) {
+	buf := make([]byte, pkceVerifierLength)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// pkceChallenge returns the S256 code challenge for the given verifier.
+func pkceChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// pkceChallengeOptions returns the options that add the challenge for the given
+// verifier to an authorization request.
+func pkceChallengeOptions(verifier string) []oauth2.AuthCodeOption {
+	return []oauth2.AuthCodeOption{
+		oauth2.SetAuthURLParam("code_challenge", pkceChallenge(verifier)),
+		oauth2.SetAuthURLParam("code_challenge_method", "S256"),
+	}
+}
+
+// pkceFlowTTL is how long a started flow has to complete before its verifier is reaped.
+const pkceFlowTTL = 10 * time.Minute
+
+// maxPKCEFlows is the maximum number of flows kept in progress. The oldest flows are
+// dropped once it is exceeded.
+const maxPKCEFlows = 1000
+
+// pkceFlow is what is kept in the secrets backend for a flow in progress.
+type pkceFlow struct {
+	Verifier  string    `json:"verifier"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+func (f *pkceFlow) expired(now time.Time) bool { return now.Sub(f.CreatedAt) > pkceFlowTTL }
+
+// writePKCEVerifier stores the code verifier for the given state.
+func (a *AuthProvider) writePKCEVerifier(state, verifier string) error {
+	if err := a.secrets.Lock(15); err != nil {
+		return err
+	}
+	defer a.secrets.Release()
+	flows, err := a.readPKCEFlows()
+	if err != nil {
+		return err
+	}
+	flows[state] = &pkceFlow{Verifier: verifier, CreatedAt: time.Now().UTC()}
+	return a.writePKCEFlows(flows)
+}
+
+// popPKCEVerifier retrieves and clears the code verifier for the given state. It is
+// an error if no flow was started for the state, or if it has expired.
+func (a *AuthProvider) popPKCEVerifier(state string) (string, error) {
+	if err := a.secrets.Lock(15); err != nil {
+		return "", err
+	}
+	defer a.secrets.Release()
+	flows, err := a.readPKCEFlows()
+	if err != nil {
+		return "", err
+	}
+	flow, ok := flows[state]
+	if ok {
+		delete(flows, state)
+		if err := a.writePKCEFlows(flows); err != nil {
+			return "", err
+		}
+	}
+	if !ok || flow.Verifier == "" || flow.expired(time.Now()) {
+		return "", errors.New("No authentication flow was started for the provided state")
+	}
+	return flow.Verifier, nil
+}
+
+// readPKCEFlows reads the flows in progress from the secrets backend.
+func (a *AuthProvider) readPKCEFlows() (map[string]*pkceFlow, error) {
+	data, err := a.secrets.ReadSecretMap(v1.OIDCPKCESecretKey, false)
+	if err != nil {
+		if errors.IsSecretNotFoundError(err) {
+			return map[string]*pkceFlow{}, nil
+		}
+		return nil, err
+	}
+	flows := make(map[string]*pkceFlow, len(data))
+	for state, raw := range data {
+		flow := &pkceFlow{}
+		if err := json.Unmarshal(raw, flow); err != nil {
+			// drop anything that can't be read instead of failing every login
+			continue
+		}
+		flows[state] = flow
+	}
+	return flows, nil
+}
+
+// writePKCEFlows reaps expired flows, drops the oldest flows beyond maxPKCEFlows, and
+// writes the rest to the secrets backend.
+func (a *AuthProvider) writePKCEFlows(flows map[string]*pkceFlow) error {
+	now := time.Now()
+	states := make([]string, 0, len(flows))
+	for state, flow := range flows {
+		if flow.expired(now) {
+			delete(flows, state)
+			continue
+		}
+		states = append(states, state)
+	}
+	if len(states) > maxPKCEFlows {
+		sort.Slice(states, func(i, j int) bool {
+			return flows[states[i]].CreatedAt.Before(flows[states[j]].CreatedAt)
+		})
+		for _, state := range states[:len(states)-maxPKCEFlows] {
+			delete(flows, state)
+		}
+	}
+	data := make(map[string][]byte, len(flows))
+	for state, flow := range flows {
+		raw, err := json.Marshal(flow)
+		if err != nil {
+			return err
+		}
+		data[state] = raw
+	}
+	return a.secrets.WriteSecretMap(v1.OIDCPKCESecretKey, data)
+}
@@ -0,0 +1,119 @@
+/*
+
+Copyright 2020,2021 Avi Zimmerman
+
+This file is part of kvdi.
+
+kvdi is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+kvdi is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package oidc
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	v1 "github.com/kvdi/kvdi/apis/meta/v1"
+)
+
+func TestPKCEChallenge(t *testing.T) {
+	// The challenge is the unpadded base64url SHA-256 digest of the verifier
+	verifier := "abc"
+	if challenge := pkceChallenge(verifier); challenge != "ungWv48Bz-pBQUDeXa4iI7ADYaOWF3qctBD_YfIAFa0" {
+		t.Error("Got unexpected challenge:", challenge)
+	}
+
+	verifier, err := newPKCEVerifier()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(verifier) != 43 {
+		t.Error("Expected a 43 character verifier, got:", verifier)
+	}
+	other, err := newPKCEVerifier()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other == verifier {
+		t.Error("Expected unique verifiers")
+	}
+}
+
+func TestPKCEVerifierStorage(t *testing.T) {
+	provider := newTestProvider(t)
+
+	if _, err := provider.popPKCEVerifier("test-state"); err == nil {
+		t.Error("Expected an error for a state without a flow")
+	}
+	if err := provider.writePKCEVerifier("test-state", "verifier"); err != nil {
+		t.Fatal(err)
+	}
+	verifier, err := provider.popPKCEVerifier("test-state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if verifier != "verifier" {
+		t.Error("Got unexpected verifier:", verifier)
+	}
+	// A verifier can only be used once
+	if _, err := provider.popPKCEVerifier("test-state"); err == nil {
+		t.Error("Expected an error reusing a verifier")
+	}
+}
+
+func TestPKCEVerifierExpiry(t *testing.T) {
+	provider := newTestProvider(t)
+
+	// Flows that took too long are rejected
+	flows := map[string]*pkceFlow{
+		"stale-state": {Verifier: "stale", CreatedAt: time.Now().Add(-pkceFlowTTL - time.Minute)},
+	}
+	for i := 0; i < maxPKCEFlows+10; i++ {
+		flows[fmt.Sprintf("state-%d", i)] = &pkceFlow{Verifier: "verifier", CreatedAt: time.Now().Add(time.Duration(i) * time.Millisecond)}
+	}
+	if err := provider.writePKCEFlows(flows); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := provider.readPKCEFlows()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored) != maxPKCEFlows {
+		t.Errorf("Expected %d flows to be kept, got %d", maxPKCEFlows, len(stored))
+	}
+	if _, ok := stored["stale-state"]; ok {
+		t.Error("Expected the expired flow to be reaped")
+	}
+	if _, ok := stored["state-0"]; ok {
+		t.Error("Expected the oldest flow to be dropped")
+	}
+	if _, err := provider.popPKCEVerifier("state-0"); err == nil {
+		t.Error("Expected an error for a dropped flow")
+	}
+	if verifier, err := provider.popPKCEVerifier(fmt.Sprintf("state-%d", maxPKCEFlows+9)); err != nil || verifier != "verifier" {
+		t.Error("Expected the newest flow to be kept, got:", verifier, err)
+	}
+
+	// An expired flow that has not been reaped yet can't be used either
+	if err := provider.secrets.WriteSecretMap(v1.OIDCPKCESecretKey, map[string][]byte{
+		"stale-state": []byte(fmt.Sprintf(`{"verifier":"stale","createdAt":%q}`, time.Now().Add(-pkceFlowTTL-time.Minute).Format(time.RFC3339))),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := provider.popPKCEVerifier("stale-state"); err == nil {
+		t.Error("Expected an error for an expired flow")
+	}
+}
@@ -51,8 +51,12 @@ type AuthProvider struct {
 	oauthCfg oauth2.Config
 	// verifier for verifying id tokens
 	verifier *gooidc.IDTokenVerifier
+	// the discovered provider, used for querying user info
+	provider *gooidc.Provider
 	// the url that can be used for exchanging refresh tokens
 	tokenURL string
+	// the url for ending a user's session at the provider, if it supports it
+	endSessionURL string
 	// the context containing our http client
 	ctx context.Context
 	// the client id
@@ -61,8 +65,10 @@ type AuthProvider struct {
 	clientSecret string
 }
 
-// Blank assignment to make sure AuthProvider satisfies the interface.
+// Blank assignments to make sure AuthProvider satisfies the interfaces.
 var _ common.AuthProvider = &AuthProvider{}
+var _ common.RefreshProvider = &AuthProvider{}
+var _ common.LogoutProvider = &AuthProvider{}
 
 // New returns a new OIDC AuthProvider.
 func New(s *secrets.SecretEngine) common.AuthProvider {
@@ -111,8 +117,19 @@ func (a *AuthProvider) Setup(c client.Client, cluster *appv1.VDICluster) error {
 		return err
 	}
 
+	a.provider = provider
 	a.tokenURL = provider.Endpoint().TokenURL
 
+	// RP-initiated logout is optional, providers that support it advertise it
+	// in the discovery document.
+	var discovery struct {
+		EndSessionEndpoint string `json:"end_session_endpoint"`
+	}
+	if err := provider.Claims(&discovery); err != nil {
+		return err
+	}
+	a.endSessionURL = discovery.EndSessionEndpoint
+
 	a.oauthCfg = oauth2.Config{
 		ClientID:     oidcSecrets[clientIDKey],
 		ClientSecret: oidcSecrets[clientSecretKey],
@@ -0,0 +1,307 @@
+/*
+
+Copyright 2020,2021 Avi Zimmerman
+
+This file is part of kvdi.
+
+kvdi is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+kvdi is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package oidc
+
+import (
+	"encoding/json"
+	"net/url"
+	"sort"
+	"time"
+
+	"golang.org/x/oauth2"
+
+	v1 "github.com/kvdi/kvdi/apis/meta/v1"
+	"github.com/kvdi/kvdi/pkg/secrets"
+	"github.com/kvdi/kvdi/pkg/types"
+	"github.com/kvdi/kvdi/pkg/util/common"
+	"github.com/kvdi/kvdi/pkg/util/errors"
+)
+
+// sessionIdleTTL is how long a session holding a refresh token is kept without being
+// refreshed. Sessions are refreshed whenever the access token of their login expires,
+// so only logins that are no longer used go idle for this long. Sessions without a
+// refresh token are only kept as long as the access token of their login.
+const sessionIdleTTL = 24 * time.Hour
+
+// maxSessionsSize is the maximum number of bytes sessions may take up in the secrets
+// backend. Once it is exceeded, the sessions refreshed least recently are evicted and
+// their logins can no longer be refreshed.
+const maxSessionsSize = 256 * 1024
+
+// session holds the tokens the provider issued for a login at its start or last
+// refresh. Sessions are kept for each login, so a user can be logged in from several
+// places at once.
+type session struct {
+	// The user the session belongs to
+	User string `json:"user"`
+	// The refresh token used to renew the session. It is only kept when tokens
+	// are preserved.
+	RefreshToken string `json:"refreshToken,omitempty"`
+	// The raw ID token, sent as a hint when ending the session at the provider. It is
+	// only kept when the provider has an end session endpoint.
+	IDToken string `json:"idToken,omitempty"`
+	// When the session was last written
+	UpdatedAt time.Time `json:"updatedAt"`
+	// When the session can be reaped
+	ExpiresAt time.Time `json:"expiresAt,omitempty"`
+}
+
+// expired returns true if the session can be reaped. Sessions written before they had
+// an expiry are kept for the sessionIdleTTL.
+func (s *session) expired(now time.Time) bool {
+	if s.ExpiresAt.IsZero() {
+		return now.Sub(s.UpdatedAt) > sessionIdleTTL
+	}
+	return now.After(s.ExpiresAt)
+}
+
+// Refresh implements the RefreshProvider interface. It uses the refresh token stored
+// for the login to obtain new tokens from the provider and re-derives the user's roles
+// from the returned claims.
+func (a *AuthProvider) Refresh(username, loginID string) (*types.AuthResult, error) {
+	sess, err := a.getSession(username, loginID)
+	if err != nil {
+		return nil, err
+	}
+	if sess == nil || sess.RefreshToken == "" {
+		return nil, errors.New("Token has expired and there is no OIDC session to refresh it with")
+	}
+
+	// A token with only a refresh token is never valid, so the token source always
+	// goes to the provider.
+	token, err := a.oauthCfg.TokenSource(a.ctx, &oauth2.Token{RefreshToken: sess.RefreshToken}).Token()
+	if err != nil {
+		// the refresh token is no good anymore, so neither is the session
+		if werr := a.writeSession(loginID, nil); werr != nil {
+			return nil, werr
+		}
+		return nil, err
+	}
+
+	claims, rawIDToken, err := a.claimsFromToken(token)
+	if err != nil {
+		return nil, err
+	}
+	// Not every provider issues a new ID token on refresh, the user info endpoint
+	// serves the same claims.
+	if claims == nil {
+		userInfo, err := a.provider.UserInfo(a.ctx, oauth2.StaticTokenSource(token))
+		if err != nil {
+			return nil, err
+		}
+		claims = make(map[string]interface{})
+		if err := userInfo.Claims(&claims); err != nil {
+			return nil, err
+		}
+		rawIDToken = sess.IDToken
+	}
+
+	result, err := a.resultFromClaims(claims, token)
+	if err != nil {
+		return nil, err
+	}
+	if result.User.Name != username {
+		if err := a.writeSession(loginID, nil); err != nil {
+			return nil, err
+		}
+		return nil, errors.New("The OIDC provider returned a different user while refreshing the session")
+	}
+	return result, a.writeSession(loginID, a.newSession(username, token, rawIDToken))
+}
+
+// Logout implements the LogoutProvider interface. It discards the tokens stored for
+// the login and returns the provider's end session URL.
+func (a *AuthProvider) Logout(username, loginID string) (string, error) {
+	sess, err := a.getSession(username, loginID)
+	if err != nil {
+		return "", err
+	}
+	if sess != nil {
+		if err := a.writeSession(loginID, nil); err != nil {
+			return "", err
+		}
+	}
+	return a.getLogoutURL(sess)
+}
+
+// RevokeSessions implements the SessionProvider interface. It discards the sessions of
+// the given logins of the user, or all of their sessions when no logins are given.
+func (a *AuthProvider) RevokeSessions(username string, loginIDs ...string) error {
+	if err := a.secrets.Lock(15); err != nil {
+		return err
+	}
+	defer a.secrets.Release()
+	sessions, err := a.readSessions()
+	if err != nil {
+		return err
+	}
+	var changed bool
+	for id, sess := range sessions {
+		if sess.User != username || (len(loginIDs) > 0 && !common.StringSliceContains(loginIDs, id)) {
+			continue
+		}
+		delete(sessions, id)
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+	return a.writeSessions(sessions)
+}
+
+// getLogoutURL builds the URL for ending the given session at the provider, or returns
+// an empty string if the provider does not support RP-initiated logout.
+func (a *AuthProvider) getLogoutURL(sess *session) (string, error) {
+	if a.endSessionURL == "" {
+		return "", nil
+	}
+	logoutURL, err := url.Parse(a.endSessionURL)
+	if err != nil {
+		return "", err
+	}
+	query := logoutURL.Query()
+	if sess != nil && sess.IDToken != "" {
+		query.Set("id_token_hint", sess.IDToken)
+	} else {
+		query.Set("client_id", a.clientID)
+	}
+	if redirect := a.cluster.GetOIDCPostLogoutRedirectURL(); redirect != "" {
+		query.Set("post_logout_redirect_uri", redirect)
+	}
+	logoutURL.RawQuery = query.Encode()
+	return logoutURL.String(), nil
+}
+
+// newSession returns the session to store for the given user and tokens. It is nil
+// when there is no refresh token to keep and no end session endpoint to send the ID
+// token to.
+func (a *AuthProvider) newSession(username string, token *oauth2.Token, rawIDToken string) *session {
+	sess := &session{User: username}
+	if a.cluster.PreserveOIDCTokens() {
+		sess.RefreshToken = token.RefreshToken
+	}
+	if a.endSessionURL != "" {
+		sess.IDToken = rawIDToken
+	}
+	if sess.RefreshToken == "" && sess.IDToken == "" {
+		return nil
+	}
+	return sess
+}
+
+// getSession returns the session stored for the given login of the user, or nil if
+// there is none.
+func (a *AuthProvider) getSession(username, loginID string) (*session, error) {
+	if loginID == "" {
+		return nil, nil
+	}
+	sessions, err := a.readSessions()
+	if err != nil {
+		return nil, err
+	}
+	sess, ok := sessions[loginID]
+	if !ok || sess.User != username || sess.expired(time.Now()) {
+		return nil, nil
+	}
+	return sess, nil
+}
+
+// writeSession stores the session for the given login. A nil session removes it.
+// Expired sessions are reaped, and the sessions refreshed least recently are evicted
+// when they would take up more than maxSessionsSize.
+func (a *AuthProvider) writeSession(loginID string, sess *session) error {
+	if err := a.secrets.Lock(15); err != nil {
+		return err
+	}
+	defer a.secrets.Release()
+	sessions, err := a.readSessions()
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	for id, existing := range sessions {
+		if existing.expired(now) {
+			delete(sessions, id)
+		}
+	}
+	if sess == nil {
+		delete(sessions, loginID)
+	} else {
+		sess.UpdatedAt = now
+		if sess.RefreshToken != "" {
+			sess.ExpiresAt = now.Add(sessionIdleTTL)
+		} else {
+			sess.ExpiresAt = now.Add(a.cluster.GetTokenDuration())
+		}
+		sessions[loginID] = sess
+	}
+	return a.writeSessions(sessions)
+}
+
+// readSessions reads all sessions from the secrets backend. Sessions that cannot be
+// decoded are skipped and dropped on the next write.
+func (a *AuthProvider) readSessions() (map[string]*session, error) {
+	data, err := a.secrets.ReadSecretMap(v1.OIDCSessionsSecretKey, false)
+	if err != nil {
+		if errors.IsSecretNotFoundError(err) {
+			return map[string]*session{}, nil
+		}
+		return nil, err
+	}
+	sessions := make(map[string]*session, len(data))
+	for id, raw := range data {
+		sess := &session{}
+		if err := json.Unmarshal(raw, sess); err != nil {
+			continue
+		}
+		sessions[id] = sess
+	}
+	return sessions, nil
+}
+
+// writeSessions writes the given sessions to the secrets backend, evicting the ones
+// refreshed least recently until they fit in maxSessionsSize.
+func (a *AuthProvider) writeSessions(sessions map[string]*session) error {
+	data := make(map[string][]byte, len(sessions))
+	for id, sess := range sessions {
+		out, err := json.Marshal(sess)
+		if err != nil {
+			return err
+		}
+		data[id] = out
+	}
+	if size := secrets.MapSize(data); size > maxSessionsSize {
+		ids := make([]string, 0, len(sessions))
+		for id := range sessions {
+			ids = append(ids, id)
+		}
+		sort.Slice(ids, func(i, j int) bool { return sessions[ids[i]].UpdatedAt.Before(sessions[ids[j]].UpdatedAt) })
+		for _, id := range ids {
+			if size <= maxSessionsSize {
+				break
+			}
+			size -= secrets.EntrySize(id, data[id])
+			delete(data, id)
+		}
+	}
+	return a.secrets.WriteSecretMap(v1.OIDCSessionsSecretKey, data)
+}
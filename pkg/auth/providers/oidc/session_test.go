@@ -0,0 +1,258 @@
+/*
+
+Copyright 2020,2021 Avi Zimmerman
+
+This file is part of kvdi.
+
+kvdi is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+kvdi is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package oidc
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+
+	appv1 "github.com/kvdi/kvdi/apis/app/v1"
+	v1 "github.com/kvdi/kvdi/apis/meta/v1"
+	"github.com/kvdi/kvdi/pkg/secrets"
+)
+
+func newTestProvider(t *testing.T) *AuthProvider {
+	t.Helper()
+	cluster := &appv1.VDICluster{}
+	cluster.Name = "test-cluster"
+	cluster.Spec.Auth = &appv1.AuthConfig{OIDCAuth: &appv1.OIDCConfig{}}
+	engine, c, err := secrets.NewTestEngine(cluster)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &AuthProvider{client: c, cluster: cluster, secrets: engine, clientID: "kvdi"}
+}
+
+func TestSessionStorage(t *testing.T) {
+	provider := newTestProvider(t)
+	token := &oauth2.Token{RefreshToken: "refresh"}
+
+	// Nothing is kept without preserved tokens or an end session endpoint
+	if sess := provider.newSession("alice", token, "id-token"); sess != nil {
+		t.Error("Expected no session to be kept, got:", sess)
+	}
+	// The refresh token is only kept when tokens are preserved
+	provider.endSessionURL = "https://idp.local/logout"
+	if sess := provider.newSession("alice", token, "id-token"); sess == nil || sess.RefreshToken != "" || sess.IDToken != "id-token" {
+		t.Error("Expected only the ID token to be kept, got:", sess)
+	}
+	provider.cluster.Spec.Auth.OIDCAuth.PreserveTokens = true
+	if err := provider.writeSession("login-1", provider.newSession("alice", token, "id-token")); err != nil {
+		t.Fatal(err)
+	}
+	// A second login of the same user does not replace the first
+	if err := provider.writeSession("login-2", provider.newSession("alice", &oauth2.Token{RefreshToken: "other"}, "other-id-token")); err != nil {
+		t.Fatal(err)
+	}
+
+	sess, err := provider.getSession("alice", "login-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sess == nil || sess.RefreshToken != "refresh" || sess.IDToken != "id-token" {
+		t.Fatal("Got unexpected session:", sess)
+	}
+	if sess, err := provider.getSession("bob", "login-1"); err != nil || sess != nil {
+		t.Error("Expected no session for bob, got:", sess, err)
+	}
+	if sess, err := provider.getSession("alice", ""); err != nil || sess != nil {
+		t.Error("Expected no session without a login ID, got:", sess, err)
+	}
+
+	// Logging out without an end session endpoint only discards the session
+	provider.endSessionURL = ""
+	logoutURL, err := provider.Logout("alice", "login-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if logoutURL != "" {
+		t.Error("Expected no logout URL, got:", logoutURL)
+	}
+	if sess, err := provider.getSession("alice", "login-1"); err != nil || sess != nil {
+		t.Error("Expected the session to be discarded, got:", sess, err)
+	}
+	if _, err := provider.Refresh("alice", "login-1"); err == nil {
+		t.Error("Expected an error refreshing a discarded session")
+	}
+	if sess, err := provider.getSession("alice", "login-2"); err != nil || sess == nil || sess.RefreshToken != "other" {
+		t.Error("Expected the other login to keep its session, got:", sess, err)
+	}
+}
+
+func TestSessionReaping(t *testing.T) {
+	provider := newTestProvider(t)
+	stale, err := json.Marshal(&session{User: "alice", IDToken: "stale", UpdatedAt: time.Now().Add(-sessionIdleTTL - time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := provider.secrets.WriteSecretMap(v1.OIDCSessionsSecretKey, map[string][]byte{"stale-login": stale}); err != nil {
+		t.Fatal(err)
+	}
+	if sess, err := provider.getSession("alice", "stale-login"); err != nil || sess != nil {
+		t.Error("Expected an idle session to be ignored, got:", sess, err)
+	}
+	provider.cluster.Spec.Auth.OIDCAuth.PreserveTokens = true
+	if err := provider.writeSession("new-login", provider.newSession("bob", &oauth2.Token{RefreshToken: "refresh"}, "id-token")); err != nil {
+		t.Fatal(err)
+	}
+	sessions, err := provider.secrets.ReadSecretMap(v1.OIDCSessionsSecretKey, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := sessions["stale-login"]; ok || len(sessions) != 1 {
+		t.Error("Expected the idle session to be reaped, got:", sessions)
+	}
+}
+
+func TestSessionExpiry(t *testing.T) {
+	provider := newTestProvider(t)
+	provider.endSessionURL = "https://idp.local/logout"
+
+	// sessions only holding an ID token are kept as long as the access token
+	if err := provider.writeSession("login", provider.newSession("alice", &oauth2.Token{}, "id-token")); err != nil {
+		t.Fatal(err)
+	}
+	sess, err := provider.getSession("alice", "login")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sess == nil || !sess.ExpiresAt.Equal(sess.UpdatedAt.Add(provider.cluster.GetTokenDuration())) {
+		t.Error("Expected the session to expire with the access token, got:", sess)
+	}
+	if !sess.expired(sess.UpdatedAt.Add(provider.cluster.GetTokenDuration() + time.Second)) {
+		t.Error("Expected the session to be expired after the access token")
+	}
+
+	// sessions holding a refresh token are kept while they are refreshed
+	provider.cluster.Spec.Auth.OIDCAuth.PreserveTokens = true
+	if err := provider.writeSession("login", provider.newSession("alice", &oauth2.Token{RefreshToken: "refresh"}, "id-token")); err != nil {
+		t.Fatal(err)
+	}
+	sess, err = provider.getSession("alice", "login")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sess == nil || !sess.ExpiresAt.Equal(sess.UpdatedAt.Add(sessionIdleTTL)) {
+		t.Error("Expected the session to expire when idle, got:", sess)
+	}
+}
+
+func TestRevokeSessions(t *testing.T) {
+	provider := newTestProvider(t)
+	provider.cluster.Spec.Auth.OIDCAuth.PreserveTokens = true
+	for _, login := range []struct{ user, id string }{
+		{"alice", "alice-1"}, {"alice", "alice-2"}, {"alice", "alice-3"}, {"bob", "bob-1"},
+	} {
+		if err := provider.writeSession(login.id, provider.newSession(login.user, &oauth2.Token{RefreshToken: login.id}, "")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	hasSession := func(user, id string) bool {
+		t.Helper()
+		sess, err := provider.getSession(user, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sess != nil
+	}
+
+	// sessions of other users are never revoked
+	if err := provider.RevokeSessions("alice", "alice-1", "bob-1"); err != nil {
+		t.Fatal(err)
+	}
+	if hasSession("alice", "alice-1") || !hasSession("alice", "alice-2") || !hasSession("bob", "bob-1") {
+		t.Error("Expected only alice-1 to be revoked")
+	}
+	if err := provider.RevokeSessions("alice"); err != nil {
+		t.Fatal(err)
+	}
+	if hasSession("alice", "alice-2") || hasSession("alice", "alice-3") || !hasSession("bob", "bob-1") {
+		t.Error("Expected all of alice's sessions to be revoked")
+	}
+}
+
+func TestSessionsAreCapped(t *testing.T) {
+	provider := newTestProvider(t)
+	provider.cluster.Spec.Auth.OIDCAuth.PreserveTokens = true
+	provider.endSessionURL = "https://idp.local/logout"
+	idToken := strings.Repeat("x", 1500)
+	for i := 0; i < 200; i++ {
+		if err := provider.writeSession(fmt.Sprintf("login-%d", i), provider.newSession("alice", &oauth2.Token{RefreshToken: "refresh"}, idToken)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sessions, err := provider.secrets.ReadSecretMap(v1.OIDCSessionsSecretKey, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size := secrets.MapSize(sessions); size > maxSessionsSize {
+		t.Errorf("Expected sessions to take up at most %d bytes, got %d", maxSessionsSize, size)
+	}
+	if _, ok := sessions["login-0"]; ok {
+		t.Error("Expected the oldest session to be evicted")
+	}
+	if _, ok := sessions["login-199"]; !ok {
+		t.Error("Expected the newest session to be kept")
+	}
+}
+
+func TestLogoutURL(t *testing.T) {
+	provider := newTestProvider(t)
+	provider.endSessionURL = "https://idp.local/logout?tenant=kvdi"
+
+	logoutURL, err := provider.getLogoutURL(&session{IDToken: "id-token"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(logoutURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := u.Query()
+	if u.Host != "idp.local" || query.Get("tenant") != "kvdi" || query.Get("id_token_hint") != "id-token" {
+		t.Error("Got unexpected logout URL:", logoutURL)
+	}
+	if query.Get("post_logout_redirect_uri") != "" {
+		t.Error("Expected no post logout redirect, got:", logoutURL)
+	}
+
+	// Without an ID token the client identifies itself instead
+	provider.cluster.Spec.Auth.OIDCAuth.PostLogoutRedirectURL = "https://kvdi.local/#/login"
+	logoutURL, err = provider.getLogoutURL(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err = url.Parse(logoutURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query = u.Query()
+	if query.Get("client_id") != "kvdi" || query.Get("id_token_hint") != "" || query.Get("post_logout_redirect_uri") != "https://kvdi.local/#/login" {
+		t.Error("Got unexpected logout URL:", logoutURL)
+	}
+}
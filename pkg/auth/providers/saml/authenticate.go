@@ -0,0 +1,294 @@
+/*
+
+Copyright 2020,2021 Avi Zimmerman
+
+This file is part of kvdi.
+
+kvdi is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+kvdi is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package saml
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	appv1 "github.com/kvdi/kvdi/apis/app/v1"
+	"github.com/kvdi/kvdi/pkg/types"
+	"github.com/kvdi/kvdi/pkg/util/errors"
+	"github.com/kvdi/kvdi/pkg/util/rbac"
+)
+
+// Authenticate is called for API authentication requests. It should generate
+// a new JWTClaims object and serve an AuthResult back to the API.
+func (a *AuthProvider) Authenticate(req *types.LoginRequest) (*types.AuthResult, error) {
+	r := req.GetRequest()
+
+	// Responses from the identity provider are posted to the assertion consumer
+	// service as a form. This is the middle part of the flow, the claims are saved
+	// for the client to retrieve on its next POST to the login route.
+	if samlResponse := r.PostFormValue("SAMLResponse"); samlResponse != "" {
+		return nil, a.handleResponse(req.GetState(), samlResponse)
+	}
+
+	// Other POST methods are the start and end of a saml flow. If we recorded claims
+	// for the provided state we return them back to the API. Otherwise, we start a new
+	// flow with the provided state.
+	if req.State == "" {
+		return nil, errors.New("No 'state' provided in the request")
+	}
+	stateKey := getStateSecretKey(req.GetState())
+	existingClaim, err := a.secrets.ReadSecret(stateKey, true)
+	if err != nil {
+		// If the secret is not found it means we have not generated claims yet
+		// for this user. Return the redirect to the identity provider.
+		if errors.IsSecretNotFoundError(err) {
+			redirectURL, err := a.newAuthnRequest(req.GetState())
+			if err != nil {
+				return nil, err
+			}
+			return &types.AuthResult{RedirectURL: redirectURL}, nil
+		}
+		return nil, err
+	}
+	// clear the state secret for this auth session
+	if err := a.secrets.Lock(15); err != nil {
+		return nil, err
+	}
+	defer a.secrets.Release()
+	if err := a.secrets.WriteSecret(stateKey, nil); err != nil {
+		return nil, err
+	}
+	authResult := &types.AuthResult{}
+	return authResult, json.Unmarshal(existingClaim, authResult)
+}
+
+// newAuthnRequest records a new AuthnRequest for the given state and returns the URL to
+// send it to the identity provider with the HTTP-Redirect binding.
+func (a *AuthProvider) newAuthnRequest(state string) (string, error) {
+	idBytes := make([]byte, 20)
+	if _, err := rand.Read(idBytes); err != nil {
+		return "", err
+	}
+	// IDs must not start with a number
+	requestID := "_" + hex.EncodeToString(idBytes)
+
+	redirectURL, err := a.buildAuthnRequestURL(requestID, state, time.Now())
+	if err != nil {
+		return "", err
+	}
+
+	// Save the request ID so the response can be matched to it. It is removed
+	// when the response is received so it can't be replayed.
+	if err := a.secrets.Lock(15); err != nil {
+		return "", err
+	}
+	defer a.secrets.Release()
+	if err := a.secrets.WriteSecret(getRequestSecretKey(state), []byte(requestID)); err != nil {
+		return "", err
+	}
+	return redirectURL, nil
+}
+
+// buildAuthnRequestURL builds a signed AuthnRequest URL using the HTTP-Redirect binding.
+func (a *AuthProvider) buildAuthnRequestURL(requestID, relayState string, now time.Time) (string, error) {
+	req := &authnRequest{
+		ID:                          requestID,
+		Version:                     "2.0",
+		IssueInstant:                now.UTC().Format(time.RFC3339),
+		Destination:                 a.cluster.GetSAMLIDPSSOURL(),
+		AssertionConsumerServiceURL: a.cluster.GetSAMLACSURL(),
+		ProtocolBinding:             bindingHTTPPOST,
+		Issuer:                      a.cluster.GetSAMLEntityID(),
+		NameIDPolicy: nameIDPolicy{
+			Format:      nameIDFormatUnspec,
+			AllowCreate: true,
+		},
+	}
+	out, err := xml.Marshal(req)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	fw, err := flate.NewWriter(&buf, flate.BestCompression)
+	if err != nil {
+		return "", err
+	}
+	if _, err := fw.Write(out); err != nil {
+		return "", err
+	}
+	if err := fw.Close(); err != nil {
+		return "", err
+	}
+
+	// The signature covers the query string in this exact order
+	query := "SAMLRequest=" + url.QueryEscape(base64.StdEncoding.EncodeToString(buf.Bytes()))
+	if relayState != "" {
+		query += "&RelayState=" + url.QueryEscape(relayState)
+	}
+	query += "&SigAlg=" + url.QueryEscape(algRSASHA256)
+	hashed := sha256.Sum256([]byte(query))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, a.spKey, crypto.SHA256, hashed[:])
+	if err != nil {
+		return "", err
+	}
+	query += "&Signature=" + url.QueryEscape(base64.StdEncoding.EncodeToString(sig))
+
+	ssoURL := a.cluster.GetSAMLIDPSSOURL()
+	if strings.Contains(ssoURL, "?") {
+		return ssoURL + "&" + query, nil
+	}
+	return ssoURL + "?" + query, nil
+}
+
+// handleResponse validates a response from the identity provider and saves the resulting
+// claims for the given state.
+func (a *AuthProvider) handleResponse(state, samlResponse string) error {
+	if state == "" {
+		return errors.New("No 'RelayState' provided with the response, unsolicited responses are not supported")
+	}
+	requestID, err := a.consumeRequestID(state)
+	if err != nil {
+		return err
+	}
+
+	raw, err := decodeBase64(samlResponse)
+	if err != nil {
+		return err
+	}
+	validator := &responseValidator{
+		certs:       a.idpCerts,
+		entityID:    a.cluster.GetSAMLEntityID(),
+		acsURL:      a.cluster.GetSAMLACSURL(),
+		idpEntityID: a.cluster.GetSAMLIDPEntityID(),
+		requestID:   requestID,
+		now:         time.Now(),
+	}
+	assertion, err := validator.validate(raw)
+	if err != nil {
+		return err
+	}
+	if err := a.consumeAssertionID(assertion); err != nil {
+		return err
+	}
+
+	result, err := a.resultFromAssertion(assertion)
+	if err != nil {
+		return err
+	}
+	// save the claims to the secret backend, they will be retrieved on the next POST
+	// for this state.
+	return a.marshalClaimsToSecret(getStateSecretKey(state), result)
+}
+
+// consumeRequestID returns the ID of the pending AuthnRequest for the given state and
+// removes it so that it can only be used once.
+func (a *AuthProvider) consumeRequestID(state string) (string, error) {
+	requestKey := getRequestSecretKey(state)
+	if err := a.secrets.Lock(15); err != nil {
+		return "", err
+	}
+	defer a.secrets.Release()
+	requestID, err := a.secrets.ReadSecret(requestKey, false)
+	if err != nil {
+		if errors.IsSecretNotFoundError(err) {
+			return "", errors.New("No pending authentication request for the provided state")
+		}
+		return "", err
+	}
+	if err := a.secrets.WriteSecret(requestKey, nil); err != nil {
+		return "", err
+	}
+	if len(requestID) == 0 {
+		return "", errors.New("No pending authentication request for the provided state")
+	}
+	return string(requestID), nil
+}
+
+// resultFromAssertion builds the user and their roles from a validated assertion.
+func (a *AuthProvider) resultFromAssertion(assertion *samlAssertion) (*types.AuthResult, error) {
+	username := assertion.Subject.NameID
+	if attr := a.cluster.GetSAMLUsernameAttribute(); attr != "" {
+		values, _ := assertion.attributeValues(attr)
+		if len(values) == 0 {
+			return nil, fmt.Errorf("assertion does not contain the username attribute %q", attr)
+		}
+		username = values[0]
+	}
+	username = strings.TrimSpace(username)
+	if username == "" {
+		return nil, errors.New("could not determine a username from the assertion")
+	}
+
+	result := &types.AuthResult{
+		User: &types.VDIUser{
+			Name:  username,
+			Roles: make([]*types.VDIUserRole, 0),
+		},
+		RefreshNotSupported: true,
+	}
+
+	// check if we can handle group membership
+	groups, ok := assertion.attributeValues(a.cluster.GetSAMLGroupsAttribute())
+	if !ok || len(groups) == 0 {
+		// if we can't determine group membership, check if cluster configuration
+		// allows the user in anyway.
+		if a.cluster.SAMLAllowNonGroupedReadOnly() {
+			result.User.Roles = []*types.VDIUserRole{rbac.VDIRoleToUserRole(a.cluster.GetLaunchTemplatesRole())}
+			return result, nil
+		}
+		return nil, errors.New("No groups provided in the assertion and allow non-grouped users is set to false")
+	}
+
+	bindings, err := rbac.GetRoleBindings(a.client, a.cluster)
+	if err != nil {
+		return nil, err
+	}
+	result.User.Roles = bindings.BoundRoles(string(appv1.AuthMethodSAML), username, groups)
+	return result, nil
+}
+
+func (a *AuthProvider) marshalClaimsToSecret(stateKey string, result *types.AuthResult) error {
+	if err := a.secrets.Lock(15); err != nil {
+		return err
+	}
+	defer a.secrets.Release()
+	out, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	return a.secrets.WriteSecret(stateKey, out)
+}
+
+func getStateSecretKey(state string) string {
+	return fmt.Sprintf("saml_%s", state)
+}
+
+func getRequestSecretKey(state string) string {
+	return fmt.Sprintf("saml_request_%s", state)
+}
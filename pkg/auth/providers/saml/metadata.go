@@ -0,0 +1,93 @@
+/*
+
+Copyright 2020,2021 Avi Zimmerman
+
+This file is part of kvdi.
+
+kvdi is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+kvdi is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package saml
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+)
+
+const metadataContentType = "application/samlmetadata+xml"
+
+type entityDescriptor struct {
+	XMLName         xml.Name        `xml:"urn:oasis:names:tc:SAML:2.0:metadata EntityDescriptor"`
+	EntityID        string          `xml:"entityID,attr"`
+	SPSSODescriptor spSSODescriptor `xml:"SPSSODescriptor"`
+}
+
+type spSSODescriptor struct {
+	AuthnRequestsSigned        bool              `xml:"AuthnRequestsSigned,attr"`
+	WantAssertionsSigned       bool              `xml:"WantAssertionsSigned,attr"`
+	ProtocolSupportEnumeration string            `xml:"protocolSupportEnumeration,attr"`
+	KeyDescriptors             []keyDescriptor   `xml:"KeyDescriptor"`
+	NameIDFormats              []string          `xml:"NameIDFormat"`
+	AssertionConsumerServices  []indexedEndpoint `xml:"AssertionConsumerService"`
+}
+
+type keyDescriptor struct {
+	Use     string  `xml:"use,attr"`
+	KeyInfo keyInfo `xml:"http://www.w3.org/2000/09/xmldsig# KeyInfo"`
+}
+
+type keyInfo struct {
+	X509Certificate string `xml:"X509Data>X509Certificate"`
+}
+
+type indexedEndpoint struct {
+	Binding  string `xml:"Binding,attr"`
+	Location string `xml:"Location,attr"`
+	Index    int    `xml:"index,attr"`
+}
+
+// Metadata implements the MetadataProvider interface and returns the service provider
+// metadata to register with the identity provider.
+func (a *AuthProvider) Metadata() (string, []byte, error) {
+	descriptor := &entityDescriptor{
+		EntityID: a.cluster.GetSAMLEntityID(),
+		SPSSODescriptor: spSSODescriptor{
+			AuthnRequestsSigned:        true,
+			WantAssertionsSigned:       true,
+			ProtocolSupportEnumeration: nsProtocol,
+			KeyDescriptors: []keyDescriptor{
+				{
+					Use: "signing",
+					KeyInfo: keyInfo{
+						X509Certificate: base64.StdEncoding.EncodeToString(a.spCert.Raw),
+					},
+				},
+			},
+			NameIDFormats: []string{nameIDFormatUnspec},
+			AssertionConsumerServices: []indexedEndpoint{
+				{
+					Binding:  bindingHTTPPOST,
+					Location: a.cluster.GetSAMLACSURL(),
+					Index:    1,
+				},
+			},
+		},
+	}
+	out, err := xml.MarshalIndent(descriptor, "", "  ")
+	if err != nil {
+		return "", nil, err
+	}
+	return metadataContentType, append([]byte(xml.Header), out...), nil
+}
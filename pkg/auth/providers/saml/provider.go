@@ -0,0 +1,211 @@
+/*
+
+Copyright 2020,2021 Avi Zimmerman
+
+This file is part of kvdi.
+
+kvdi is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+kvdi is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+// Package saml contains an AuthProvider implementation backed by a SAML 2.0
+// identity provider.
+package saml
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"time"
+
+	"github.com/go-logr/logr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	appv1 "github.com/kvdi/kvdi/apis/app/v1"
+	"github.com/kvdi/kvdi/pkg/auth/common"
+	"github.com/kvdi/kvdi/pkg/secrets"
+	"github.com/kvdi/kvdi/pkg/util/errors"
+)
+
+// AuthProvider implements an auth provider that uses a SAML 2.0 identity provider
+// as the authentication backend. Access to groups provided in assertion attributes
+// is supplied through annotations on VDIRoles.
+type AuthProvider struct {
+	// k8s client
+	client client.Client
+	// our cluster instance
+	cluster *appv1.VDICluster
+	// the secrets engine where we store flow state
+	secrets *secrets.SecretEngine
+	// the certificates trusted for signing responses
+	idpCerts []*x509.Certificate
+	// the service provider certificate published in metadata
+	spCert *x509.Certificate
+	// the service provider key used for signing AuthnRequests
+	spKey *rsa.PrivateKey
+}
+
+// Blank assignments to make sure AuthProvider satisfies the interfaces.
+var _ common.AuthProvider = &AuthProvider{}
+var _ common.MetadataProvider = &AuthProvider{}
+
+// New returns a new SAML AuthProvider.
+func New(s *secrets.SecretEngine) common.AuthProvider {
+	return &AuthProvider{secrets: s}
+}
+
+// Setup implements the AuthProvider interface and sets a local reference to the
+// k8s client and vdi cluster. It then loads the identity provider certificates and
+// the service provider key pair.
+func (a *AuthProvider) Setup(c client.Client, cluster *appv1.VDICluster) error {
+	a.client = c
+	a.cluster = cluster
+
+	idpCertPEM, err := a.cluster.GetSAMLIDPCertificate()
+	if err != nil {
+		return err
+	}
+	if a.idpCerts, err = parseCertificates(idpCertPEM); err != nil {
+		return err
+	}
+
+	certKey := a.cluster.GetSAMLSPCertificateKey()
+	privKeyKey := a.cluster.GetSAMLSPPrivateKeyKey()
+	spSecrets, err := common.GetAuthSecrets(a.client, a.cluster, a.secrets, certKey, privKeyKey)
+	if err != nil {
+		return err
+	}
+	spCerts, err := parseCertificates([]byte(spSecrets[certKey]))
+	if err != nil {
+		return err
+	}
+	a.spCert = spCerts[0]
+	a.spKey, err = parsePrivateKey([]byte(spSecrets[privKeyKey]))
+	return err
+}
+
+// Reconcile makes sure the service provider has a key pair for signing requests,
+// generating a self-signed one in the secrets backend if needed. The generated admin
+// password is ignored for now in place of configuring admin groups.
+func (a *AuthProvider) Reconcile(ctx context.Context, reqLogger logr.Logger, c client.Client, cluster *appv1.VDICluster, adminPass string) error {
+	if cluster.AuthIsUsingSecretEngine() {
+		if err := a.ensureServiceProviderKeyPair(reqLogger, cluster); err != nil {
+			return err
+		}
+	}
+	return a.Setup(c, cluster)
+}
+
+// Close just returns nil as connections are not persistent
+func (a *AuthProvider) Close() error {
+	return nil
+}
+
+func (a *AuthProvider) ensureServiceProviderKeyPair(reqLogger logr.Logger, cluster *appv1.VDICluster) error {
+	certKey := cluster.GetSAMLSPCertificateKey()
+	if _, err := a.secrets.ReadSecret(certKey, false); err == nil {
+		return nil
+	} else if !errors.IsSecretNotFoundError(err) {
+		return err
+	}
+
+	reqLogger.Info("Generating a new SAML service provider key pair")
+	certPEM, keyPEM, err := generateKeyPair(cluster.GetSAMLEntityID())
+	if err != nil {
+		return err
+	}
+	if err := a.secrets.Lock(15); err != nil {
+		return err
+	}
+	defer a.secrets.Release()
+	if err := a.secrets.WriteSecret(cluster.GetSAMLSPPrivateKeyKey(), keyPEM); err != nil {
+		return err
+	}
+	return a.secrets.WriteSecret(certKey, certPEM)
+}
+
+// generateKeyPair creates a self-signed certificate and key for the service provider.
+// Identity providers only use it to verify signatures, so the subject is informational.
+func generateKeyPair(entityID string) (certPEM, keyPEM []byte, err error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: entityID},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().AddDate(10, 0, 0),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	return certPEM, keyPEM, nil
+}
+
+// parseCertificates parses all of the PEM encoded certificates in the given data.
+func parseCertificates(data []byte) ([]*x509.Certificate, error) {
+	certs := make([]*x509.Certificate, 0)
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, errors.New("no PEM encoded certificates found")
+	}
+	return certs, nil
+}
+
+// parsePrivateKey parses a PEM encoded PKCS1 or PKCS8 RSA private key.
+func parsePrivateKey(data []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM encoded private key found")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("the service provider key must be an RSA key")
+	}
+	return rsaKey, nil
+}
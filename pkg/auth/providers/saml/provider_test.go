@@ -0,0 +1,522 @@
+/*
+
+Copyright 2020,2021 Avi Zimmerman
+
+This file is part of kvdi.
+
+kvdi is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+kvdi is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package saml
+
+import (
+	"bytes"
+	"compress/flate"
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/beevik/etree"
+	"github.com/go-logr/logr"
+	dsig "github.com/russellhaering/goxmldsig"
+	"github.com/russellhaering/goxmldsig/etreeutils"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	appv1 "github.com/kvdi/kvdi/apis/app/v1"
+	v1 "github.com/kvdi/kvdi/apis/meta/v1"
+	rbacv1 "github.com/kvdi/kvdi/apis/rbac/v1"
+	"github.com/kvdi/kvdi/pkg/secrets"
+	"github.com/kvdi/kvdi/pkg/types"
+)
+
+const (
+	testACSURL      = "https://kvdi.local/api/saml/acs"
+	testEntityID    = "https://kvdi.local/api/saml/metadata"
+	testSSOURL      = "https://idp.local/sso"
+	testIDPEntityID = "https://idp.local"
+	testState       = "test-state"
+	testRole        = "test-role"
+)
+
+// testIDP is an in-process stand-in for a SAML identity provider.
+type testIDP struct {
+	key     *rsa.PrivateKey
+	certPEM []byte
+}
+
+func newTestIDP(t *testing.T) *testIDP {
+	t.Helper()
+	certPEM, keyPEM, err := generateKeyPair(testIDPEntityID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := parsePrivateKey(keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testIDP{key: key, certPEM: certPEM}
+}
+
+// assertionOpts are the values the test IdP puts in a response.
+type assertionOpts struct {
+	requestID    string
+	assertionID  string
+	nameID       string
+	groups       []string
+	audience     string
+	recipient    string
+	notOnOrAfter time.Time
+	signResponse bool
+	unsigned     bool
+}
+
+func defaultAssertionOpts(requestID string) *assertionOpts {
+	return &assertionOpts{
+		requestID:    requestID,
+		assertionID:  "_assertion" + requestID,
+		nameID:       "alice",
+		groups:       []string{"engineers"},
+		audience:     testEntityID,
+		recipient:    testACSURL,
+		notOnOrAfter: time.Now().Add(5 * time.Minute),
+	}
+}
+
+const responseTemplate = `<?xml version="1.0" encoding="UTF-8"?>
+<samlp:Response xmlns:samlp="urn:oasis:names:tc:SAML:2.0:protocol" xmlns:saml="urn:oasis:names:tc:SAML:2.0:assertion" xmlns:xs="http://www.w3.org/2001/XMLSchema" Version="2.0" ID="_response" InResponseTo="%[1]s" IssueInstant="%[2]s" Destination="%[3]s">
+  <saml:Issuer>%[4]s</saml:Issuer>
+  {{RESPONSE_SIGNATURE}}
+  <samlp:Status>
+    <samlp:StatusCode Value="urn:oasis:names:tc:SAML:2.0:status:Success"/>
+  </samlp:Status>
+  <saml:Assertion Version="2.0" ID="%[9]s" IssueInstant="%[2]s">
+    <saml:Issuer>%[4]s</saml:Issuer>
+    {{ASSERTION_SIGNATURE}}
+    <saml:Subject>
+      <saml:NameID Format="urn:oasis:names:tc:SAML:1.1:nameid-format:unspecified">%[5]s</saml:NameID>
+      <saml:SubjectConfirmation Method="urn:oasis:names:tc:SAML:2.0:cm:bearer">
+        <saml:SubjectConfirmationData Recipient="%[3]s" NotOnOrAfter="%[6]s" InResponseTo="%[1]s"/>
+      </saml:SubjectConfirmation>
+    </saml:Subject>
+    <saml:Conditions NotOnOrAfter="%[6]s" NotBefore="%[2]s">
+      <saml:AudienceRestriction>
+        <saml:Audience>%[7]s</saml:Audience>
+      </saml:AudienceRestriction>
+    </saml:Conditions>
+    <saml:AttributeStatement>
+      <saml:Attribute Name="groups">%[8]s</saml:Attribute>
+    </saml:AttributeStatement>
+  </saml:Assertion>
+</samlp:Response>`
+
+// response builds and signs a response document for the given options.
+func (i *testIDP) response(t *testing.T, opts *assertionOpts) string {
+	t.Helper()
+	var groups strings.Builder
+	for _, group := range opts.groups {
+		groups.WriteString(`<saml:AttributeValue xsi:type="xs:string" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance">` + group + `</saml:AttributeValue>`)
+	}
+	doc := fmt.Sprintf(responseTemplate,
+		opts.requestID,
+		time.Now().Add(-time.Minute).UTC().Format(time.RFC3339),
+		opts.recipient,
+		testIDPEntityID,
+		opts.nameID,
+		opts.notOnOrAfter.UTC().Format(time.RFC3339),
+		opts.audience,
+		groups.String(),
+		opts.assertionID,
+	)
+
+	unsigned := strings.NewReplacer("{{RESPONSE_SIGNATURE}}", "", "{{ASSERTION_SIGNATURE}}", "").Replace(doc)
+	if opts.unsigned {
+		return unsigned
+	}
+	root, err := parseXML([]byte(unsigned))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.signResponse {
+		return strings.NewReplacer(
+			"{{RESPONSE_SIGNATURE}}", i.signature(t, root),
+			"{{ASSERTION_SIGNATURE}}", "",
+		).Replace(doc)
+	}
+	return strings.NewReplacer(
+		"{{RESPONSE_SIGNATURE}}", "",
+		"{{ASSERTION_SIGNATURE}}", i.signature(t, childElements(root, nsAssertion, "Assertion")[0]),
+	).Replace(doc)
+}
+
+// signature returns an enveloped signature for the given element.
+func (i *testIDP) signature(t *testing.T, el *etree.Element) string {
+	t.Helper()
+	cert, err := parseCertificates(i.certPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := dsig.NewSigningContext(i.key, [][]byte{cert[0].Raw})
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer.Canonicalizer = dsig.MakeC14N10ExclusiveCanonicalizerWithPrefixList("")
+	ctx, err := etreeutils.NSBuildParentContext(el)
+	if err != nil {
+		t.Fatal(err)
+	}
+	detached, err := etreeutils.NSDetatch(ctx, el)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err := signer.ConstructSignature(detached, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := etree.NewDocument()
+	doc.SetRoot(sig)
+	out, err := doc.WriteToString()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func newTestProvider(t *testing.T, idp *testIDP) *AuthProvider {
+	t.Helper()
+	role := &rbacv1.VDIRole{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        testRole,
+			Labels:      map[string]string{v1.RoleClusterRefLabel: "test-cluster"},
+			Annotations: map[string]string{v1.SAMLGroupRoleAnnotation: "admins;engineers"},
+		},
+	}
+	cluster := &appv1.VDICluster{}
+	cluster.Name = "test-cluster"
+	cluster.Spec = appv1.VDIClusterSpec{
+		Auth: &appv1.AuthConfig{
+			SAMLAuth: &appv1.SAMLConfig{
+				ACSURL:         testACSURL,
+				IDPSSOURL:      testSSOURL,
+				IDPEntityID:    testIDPEntityID,
+				IDPCertificate: base64.StdEncoding.EncodeToString(idp.certPEM),
+			},
+		},
+	}
+	engine, c, err := secrets.NewTestEngine(cluster, role)
+	if err != nil {
+		t.Fatal(err)
+	}
+	provider := New(engine).(*AuthProvider)
+	if err := provider.Reconcile(context.TODO(), logr.Discard(), c, cluster, ""); err != nil {
+		t.Fatal(err)
+	}
+	return provider
+}
+
+func loginRequest(state string) *types.LoginRequest {
+	req := &types.LoginRequest{State: state}
+	req.SetRequest(httptest.NewRequest(http.MethodPost, "/api/login", nil))
+	return req
+}
+
+// startLogin begins a login and verifies the signed AuthnRequest the user is
+// redirected with. The ID of the request is returned.
+func startLogin(t *testing.T, provider *AuthProvider) string {
+	t.Helper()
+	result, err := provider.Authenticate(loginRequest(testState))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(result.RedirectURL, testSSOURL+"?") {
+		t.Fatal("Expected a redirect to the identity provider, got:", result.RedirectURL)
+	}
+
+	u, err := url.Parse(result.RedirectURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.SplitN(u.RawQuery, "&Signature=", 2)
+	if len(parts) != 2 {
+		t.Fatal("Expected the AuthnRequest to be signed")
+	}
+	sig, err := base64.StdEncoding.DecodeString(u.Query().Get("Signature"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hashed := sha256.Sum256([]byte(parts[0]))
+	if err := rsa.VerifyPKCS1v15(provider.spCert.PublicKey.(*rsa.PublicKey), crypto.SHA256, hashed[:], sig); err != nil {
+		t.Fatal("AuthnRequest signature did not verify:", err)
+	}
+	if u.Query().Get("RelayState") != testState {
+		t.Error("Expected the state to be relayed, got:", u.Query().Get("RelayState"))
+	}
+
+	deflated, err := base64.StdEncoding.DecodeString(u.Query().Get("SAMLRequest"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := io.ReadAll(flate.NewReader(bytes.NewReader(deflated)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := &authnRequest{}
+	if err := xml.Unmarshal(raw, req); err != nil {
+		t.Fatal(err)
+	}
+	if req.AssertionConsumerServiceURL != testACSURL || req.Issuer != testEntityID || req.Destination != testSSOURL {
+		t.Errorf("Unexpected AuthnRequest: %+v", req)
+	}
+	return req.ID
+}
+
+// postResponse delivers a response to the provider as the assertion consumer service would.
+func postResponse(provider *AuthProvider, state, response string) error {
+	form := url.Values{}
+	form.Set("SAMLResponse", base64.StdEncoding.EncodeToString([]byte(response)))
+	form.Set("RelayState", state)
+	r := httptest.NewRequest(http.MethodPost, "/api/saml/acs", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req := &types.LoginRequest{State: state}
+	req.SetRequest(r)
+	_, err := provider.Authenticate(req)
+	return err
+}
+
+func TestLoginFlow(t *testing.T) {
+	idp := newTestIDP(t)
+	provider := newTestProvider(t, idp)
+
+	for _, signResponse := range []bool{false, true} {
+		requestID := startLogin(t, provider)
+		opts := defaultAssertionOpts(requestID)
+		opts.signResponse = signResponse
+		if err := postResponse(provider, testState, idp.response(t, opts)); err != nil {
+			t.Fatal("Expected response to be accepted, got:", err)
+		}
+
+		result, err := provider.Authenticate(loginRequest(testState))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.User == nil || result.User.Name != "alice" {
+			t.Fatalf("Expected claims for alice, got: %+v", result)
+		}
+		if len(result.User.Roles) != 1 || result.User.Roles[0].Name != testRole {
+			t.Errorf("Expected user to be bound to %s, got: %+v", testRole, result.User.Roles)
+		}
+		if !result.RefreshNotSupported {
+			t.Error("Expected refresh to be unsupported")
+		}
+
+		// The claims can only be retrieved once
+		result, err = provider.Authenticate(loginRequest(testState))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.RedirectURL == "" {
+			t.Error("Expected a new flow to start after the claims were retrieved")
+		}
+	}
+}
+
+func TestRejectedResponses(t *testing.T) {
+	idp := newTestIDP(t)
+	otherIDP := newTestIDP(t)
+	provider := newTestProvider(t, idp)
+
+	tc := []struct {
+		name   string
+		modify func(*assertionOpts)
+		build  func(t *testing.T, opts *assertionOpts) string
+	}{
+		{
+			name:   "unsigned",
+			modify: func(o *assertionOpts) { o.unsigned = true },
+		},
+		{
+			name: "tampered",
+			build: func(t *testing.T, opts *assertionOpts) string {
+				return strings.Replace(idp.response(t, opts), ">alice<", ">mallory<", 1)
+			},
+		},
+		{
+			name: "wrapped",
+			build: func(t *testing.T, opts *assertionOpts) string {
+				// Move the signed assertion under an extension and put a forged one in its place
+				signed := idp.response(t, opts)
+				start := strings.Index(signed, "<saml:Assertion")
+				end := strings.Index(signed, "</saml:Assertion>") + len("</saml:Assertion>")
+				original := signed[start:end]
+				forged := strings.Replace(original, ">alice<", ">mallory<", 1)
+				return signed[:start] + "<samlp:Extensions>" + original + "</samlp:Extensions>" + forged + signed[end:]
+			},
+		},
+		{
+			name: "untrusted signer",
+			build: func(t *testing.T, opts *assertionOpts) string {
+				return otherIDP.response(t, opts)
+			},
+		},
+		{
+			name:   "wrong audience",
+			modify: func(o *assertionOpts) { o.audience = "https://other.local" },
+		},
+		{
+			name:   "wrong recipient",
+			modify: func(o *assertionOpts) { o.recipient = "https://other.local/acs" },
+		},
+		{
+			name:   "no recipient",
+			modify: func(o *assertionOpts) { o.recipient = "" },
+		},
+		{
+			name:   "expired",
+			modify: func(o *assertionOpts) { o.notOnOrAfter = time.Now().Add(-time.Hour) },
+		},
+		{
+			name:   "wrong request",
+			modify: func(o *assertionOpts) { o.requestID = "_unknown" },
+		},
+		{
+			name:   "no groups",
+			modify: func(o *assertionOpts) { o.groups = nil },
+		},
+	}
+
+	for _, c := range tc {
+		opts := defaultAssertionOpts(startLogin(t, provider))
+		if c.modify != nil {
+			c.modify(opts)
+		}
+		var response string
+		if c.build != nil {
+			response = c.build(t, opts)
+		} else {
+			response = idp.response(t, opts)
+		}
+		if err := postResponse(provider, testState, response); err == nil {
+			t.Errorf("%s: expected response to be rejected", c.name)
+		}
+	}
+}
+
+func TestReplayedResponse(t *testing.T) {
+	idp := newTestIDP(t)
+	provider := newTestProvider(t, idp)
+
+	response := idp.response(t, defaultAssertionOpts(startLogin(t, provider)))
+	if err := postResponse(provider, testState, response); err != nil {
+		t.Fatal(err)
+	}
+	if err := postResponse(provider, testState, response); err == nil {
+		t.Error("Expected a replayed response to be rejected")
+	}
+}
+
+func TestReplayedAssertion(t *testing.T) {
+	idp := newTestIDP(t)
+	provider := newTestProvider(t, idp)
+
+	opts := defaultAssertionOpts(startLogin(t, provider))
+	if err := postResponse(provider, testState, idp.response(t, opts)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := provider.Authenticate(loginRequest(testState)); err != nil {
+		t.Fatal(err)
+	}
+
+	// The same assertion in a response to a new request is still rejected
+	replayed := defaultAssertionOpts(startLogin(t, provider))
+	replayed.assertionID = opts.assertionID
+	if err := postResponse(provider, testState, idp.response(t, replayed)); err == nil {
+		t.Error("Expected a replayed assertion to be rejected")
+	}
+
+	// Expired IDs are reaped
+	ids, err := provider.readAssertionIDs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids["_expired"] = time.Now().Add(-time.Minute)
+	if err := provider.writeAssertionIDs(ids, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if ids, err = provider.readAssertionIDs(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := ids["_expired"]; ok {
+		t.Error("Expected expired assertion IDs to be reaped")
+	}
+	if _, ok := ids[assertionIDKey(opts.assertionID)]; !ok {
+		t.Error("Expected the consumed assertion ID to be kept until it expires")
+	}
+
+	// The IDs closest to expiring are dropped once there are too many
+	for i := 0; i < 2*maxAssertionIDs; i++ {
+		ids[assertionIDKey(fmt.Sprintf("_flood-%d", i))] = time.Now().Add(time.Hour + time.Duration(i)*time.Second)
+	}
+	if err := provider.writeAssertionIDs(ids, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	data, err := provider.secrets.ReadSecretMap(v1.SAMLAssertionIDsSecretKey, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) > maxAssertionIDs {
+		t.Errorf("Expected at most %d assertion IDs, got %d", maxAssertionIDs, len(data))
+	}
+	if size := secrets.MapSize(data); size > maxAssertionIDsSize {
+		t.Errorf("Expected assertion IDs to take up at most %d bytes, got %d", maxAssertionIDsSize, size)
+	}
+	if _, ok := data[assertionIDKey(fmt.Sprintf("_flood-%d", 2*maxAssertionIDs-1))]; !ok {
+		t.Error("Expected the assertion ID furthest from expiring to be kept")
+	}
+}
+
+func TestMetadata(t *testing.T) {
+	provider := newTestProvider(t, newTestIDP(t))
+	contentType, body, err := provider.Metadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if contentType != metadataContentType {
+		t.Error("Unexpected content type:", contentType)
+	}
+	descriptor := &entityDescriptor{}
+	if err := xml.Unmarshal(body, descriptor); err != nil {
+		t.Fatal(err)
+	}
+	if descriptor.EntityID != testEntityID {
+		t.Error("Unexpected entity ID:", descriptor.EntityID)
+	}
+	sp := descriptor.SPSSODescriptor
+	if !sp.AuthnRequestsSigned || len(sp.AssertionConsumerServices) != 1 || sp.AssertionConsumerServices[0].Location != testACSURL {
+		t.Errorf("Unexpected service provider descriptor: %+v", sp)
+	}
+	if len(sp.KeyDescriptors) != 1 || sp.KeyDescriptors[0].KeyInfo.X509Certificate != base64.StdEncoding.EncodeToString(provider.spCert.Raw) {
+		t.Error("Expected the service provider certificate in the metadata")
+	}
+}
@@ -0,0 +1,120 @@
+/*
+
+Copyright 2020,2021 Avi Zimmerman
+
+This file is part of kvdi.
+
+kvdi is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+kvdi is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package saml
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+	"time"
+
+	v1 "github.com/kvdi/kvdi/apis/meta/v1"
+	"github.com/kvdi/kvdi/pkg/secrets"
+	"github.com/kvdi/kvdi/pkg/util/errors"
+)
+
+// maxAssertionIDs is the maximum number of consumed assertion IDs that are remembered.
+// The IDs closest to expiring are dropped once it is exceeded. IDs are stored as fixed
+// size digests, so this keeps them to about 64KiB in the secrets backend.
+const maxAssertionIDs = 1000
+
+// maxAssertionIDsSize is the maximum number of bytes the consumed assertion IDs may take
+// up in the secrets backend. IDs are dropped the same way as when maxAssertionIDs is
+// exceeded.
+const maxAssertionIDsSize = 64 * 1024
+
+// consumeAssertionID records the ID of an accepted assertion until it expires. It is an
+// error if the ID was already consumed, which means the assertion is being replayed.
+func (a *AuthProvider) consumeAssertionID(assertion *samlAssertion) error {
+	if err := a.secrets.Lock(15); err != nil {
+		return err
+	}
+	defer a.secrets.Release()
+	ids, err := a.readAssertionIDs()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	key := assertionIDKey(assertion.ID)
+	if expiry, ok := ids[key]; ok && now.Before(expiry) {
+		return errors.New("assertion has already been used")
+	}
+	ids[key] = assertion.expiry()
+	return a.writeAssertionIDs(ids, now)
+}
+
+// assertionIDKey returns the key an assertion ID is stored under. IDs are chosen by the
+// identity provider and can be of any length, so a digest of them is stored instead.
+func assertionIDKey(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return hex.EncodeToString(sum[:16])
+}
+
+// readAssertionIDs reads the consumed assertion IDs and their expiry from the secrets backend.
+func (a *AuthProvider) readAssertionIDs() (map[string]time.Time, error) {
+	data, err := a.secrets.ReadSecretMap(v1.SAMLAssertionIDsSecretKey, false)
+	if err != nil {
+		if errors.IsSecretNotFoundError(err) {
+			return map[string]time.Time{}, nil
+		}
+		return nil, err
+	}
+	ids := make(map[string]time.Time, len(data))
+	for id, raw := range data {
+		expiry, err := time.Parse(time.RFC3339, string(raw))
+		if err != nil {
+			// drop anything that can't be read instead of failing every login
+			continue
+		}
+		ids[id] = expiry
+	}
+	return ids, nil
+}
+
+// writeAssertionIDs reaps expired IDs, drops the IDs closest to expiring beyond
+// maxAssertionIDs and maxAssertionIDsSize, and writes the rest to the secrets backend.
+func (a *AuthProvider) writeAssertionIDs(ids map[string]time.Time, now time.Time) error {
+	keep := make([]string, 0, len(ids))
+	for id, expiry := range ids {
+		if !now.Before(expiry) {
+			delete(ids, id)
+			continue
+		}
+		keep = append(keep, id)
+	}
+	data := make(map[string][]byte, len(ids))
+	for id, expiry := range ids {
+		data[id] = []byte(expiry.UTC().Format(time.RFC3339))
+	}
+	size := secrets.MapSize(data)
+	if len(keep) > maxAssertionIDs || size > maxAssertionIDsSize {
+		sort.Slice(keep, func(i, j int) bool { return ids[keep[i]].Before(ids[keep[j]]) })
+		for _, id := range keep {
+			if len(data) <= maxAssertionIDs && size <= maxAssertionIDsSize {
+				break
+			}
+			size -= secrets.EntrySize(id, data[id])
+			delete(data, id)
+		}
+	}
+	return a.secrets.WriteSecretMap(v1.SAMLAssertionIDsSecretKey, data)
+}
@@ -0,0 +1,281 @@
+/*
+
+Copyright 2020,2021 Avi Zimmerman
+
+This file is part of kvdi.
+
+kvdi is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+kvdi is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package saml
+
+import (
+	"crypto/x509"
+	"encoding/xml"
+	"fmt"
+	"time"
+
+	"github.com/kvdi/kvdi/pkg/util/errors"
+)
+
+const (
+	bindingHTTPPOST          = "urn:oasis:names:tc:SAML:2.0:bindings:HTTP-POST"
+	nameIDFormatUnspec       = "urn:oasis:names:tc:SAML:1.1:nameid-format:unspecified"
+	statusSuccess            = "urn:oasis:names:tc:SAML:2.0:status:Success"
+	confirmationMethodBearer = "urn:oasis:names:tc:SAML:2.0:cm:bearer"
+
+	// allowedClockSkew is the leeway given when checking the validity window of
+	// an assertion.
+	allowedClockSkew = 90 * time.Second
+)
+
+// authnRequest is the request sent to the identity provider to start a login.
+type authnRequest struct {
+	XMLName                     xml.Name     `xml:"urn:oasis:names:tc:SAML:2.0:protocol AuthnRequest"`
+	ID                          string       `xml:"ID,attr"`
+	Version                     string       `xml:"Version,attr"`
+	IssueInstant                string       `xml:"IssueInstant,attr"`
+	Destination                 string       `xml:"Destination,attr"`
+	AssertionConsumerServiceURL string       `xml:"AssertionConsumerServiceURL,attr"`
+	ProtocolBinding             string       `xml:"ProtocolBinding,attr"`
+	Issuer                      string       `xml:"urn:oasis:names:tc:SAML:2.0:assertion Issuer"`
+	NameIDPolicy                nameIDPolicy `xml:"NameIDPolicy"`
+}
+
+type nameIDPolicy struct {
+	Format      string `xml:"Format,attr"`
+	AllowCreate bool   `xml:"AllowCreate,attr"`
+}
+
+// samlResponse is the subset of a Response needed to validate it. It is only ever
+// parsed from the canonical bytes returned by a signature verification.
+type samlResponse struct {
+	XMLName      xml.Name `xml:"urn:oasis:names:tc:SAML:2.0:protocol Response"`
+	ID           string   `xml:"ID,attr"`
+	InResponseTo string   `xml:"InResponseTo,attr"`
+	Destination  string   `xml:"Destination,attr"`
+	Issuer       string   `xml:"urn:oasis:names:tc:SAML:2.0:assertion Issuer"`
+	Status       struct {
+		StatusCode struct {
+			Value string `xml:"Value,attr"`
+		} `xml:"urn:oasis:names:tc:SAML:2.0:protocol StatusCode"`
+		StatusMessage string `xml:"urn:oasis:names:tc:SAML:2.0:protocol StatusMessage"`
+	} `xml:"urn:oasis:names:tc:SAML:2.0:protocol Status"`
+}
+
+// samlAssertion is the subset of an Assertion used for authenticating a user.
+type samlAssertion struct {
+	XMLName xml.Name `xml:"urn:oasis:names:tc:SAML:2.0:assertion Assertion"`
+	ID      string   `xml:"ID,attr"`
+	Issuer  string   `xml:"urn:oasis:names:tc:SAML:2.0:assertion Issuer"`
+	Subject struct {
+		NameID        string                    `xml:"urn:oasis:names:tc:SAML:2.0:assertion NameID"`
+		Confirmations []samlSubjectConfirmation `xml:"urn:oasis:names:tc:SAML:2.0:assertion SubjectConfirmation"`
+	} `xml:"urn:oasis:names:tc:SAML:2.0:assertion Subject"`
+	Conditions          *samlConditions `xml:"urn:oasis:names:tc:SAML:2.0:assertion Conditions"`
+	AttributeStatements []struct {
+		Attributes []samlAttribute `xml:"urn:oasis:names:tc:SAML:2.0:assertion Attribute"`
+	} `xml:"urn:oasis:names:tc:SAML:2.0:assertion AttributeStatement"`
+}
+
+type samlSubjectConfirmation struct {
+	Method string `xml:"Method,attr"`
+	Data   struct {
+		Recipient    string    `xml:"Recipient,attr"`
+		InResponseTo string    `xml:"InResponseTo,attr"`
+		NotOnOrAfter time.Time `xml:"NotOnOrAfter,attr"`
+	} `xml:"urn:oasis:names:tc:SAML:2.0:assertion SubjectConfirmationData"`
+}
+
+type samlConditions struct {
+	NotBefore            time.Time `xml:"NotBefore,attr"`
+	NotOnOrAfter         time.Time `xml:"NotOnOrAfter,attr"`
+	AudienceRestrictions []struct {
+		Audiences []string `xml:"urn:oasis:names:tc:SAML:2.0:assertion Audience"`
+	} `xml:"urn:oasis:names:tc:SAML:2.0:assertion AudienceRestriction"`
+}
+
+type samlAttribute struct {
+	Name         string   `xml:"Name,attr"`
+	FriendlyName string   `xml:"FriendlyName,attr"`
+	Values       []string `xml:"urn:oasis:names:tc:SAML:2.0:assertion AttributeValue"`
+}
+
+// attributeValues returns the values of the attribute with the given name or friendly name,
+// and whether the attribute was present at all.
+func (s *samlAssertion) attributeValues(name string) ([]string, bool) {
+	for _, stmt := range s.AttributeStatements {
+		for _, attr := range stmt.Attributes {
+			if attr.Name == name || attr.FriendlyName == name {
+				return attr.Values, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// expiry returns the time after which the assertion can no longer be used. It is
+// the latest of its validity window and bearer confirmations.
+func (s *samlAssertion) expiry() time.Time {
+	var expiry time.Time
+	if s.Conditions != nil {
+		expiry = s.Conditions.NotOnOrAfter
+	}
+	for _, confirmation := range s.Subject.Confirmations {
+		if confirmation.Data.NotOnOrAfter.After(expiry) {
+			expiry = confirmation.Data.NotOnOrAfter
+		}
+	}
+	return expiry.Add(allowedClockSkew)
+}
+
+// responseValidator holds the expectations for a response to an AuthnRequest.
+type responseValidator struct {
+	certs       []*x509.Certificate
+	entityID    string
+	acsURL      string
+	idpEntityID string
+	requestID   string
+	now         time.Time
+}
+
+// validate verifies the signatures on the given Response document and returns its
+// assertion. Either the response or the assertion inside it must be signed.
+func (v *responseValidator) validate(raw []byte) (*samlAssertion, error) {
+	root, err := parseXML(raw)
+	if err != nil {
+		return nil, err
+	}
+	if !isElement(root, nsProtocol, "Response") {
+		return nil, errors.New("document is not a SAML response")
+	}
+
+	// A signed response covers the assertion inside it. Continue with only the
+	// verified element.
+	responseSigned := true
+	verified, err := verifySignature(root, v.certs, v.now)
+	if err != nil {
+		if err != errNotSigned {
+			return nil, err
+		}
+		responseSigned = false
+		verified = root
+	}
+
+	responseBytes, err := elementBytes(verified)
+	if err != nil {
+		return nil, err
+	}
+	resp := &samlResponse{}
+	if err := xml.Unmarshal(responseBytes, resp); err != nil {
+		return nil, err
+	}
+	if err := v.validateResponse(resp); err != nil {
+		return nil, err
+	}
+
+	if len(childElements(verified, nsAssertion, "EncryptedAssertion")) != 0 {
+		return nil, errors.New("encrypted assertions are not supported")
+	}
+	assertions := childElements(verified, nsAssertion, "Assertion")
+	if len(assertions) != 1 {
+		return nil, errors.New("response must contain exactly one assertion")
+	}
+
+	assertionEl := assertions[0]
+	if !responseSigned {
+		assertionEl, err = verifySignature(assertionEl, v.certs, v.now)
+		if err == errNotSigned {
+			err = errors.New("neither the response nor the assertion is signed")
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	assertionBytes, err := elementBytes(assertionEl)
+	if err != nil {
+		return nil, err
+	}
+
+	assertion := &samlAssertion{}
+	if err := xml.Unmarshal(assertionBytes, assertion); err != nil {
+		return nil, err
+	}
+	return assertion, v.validateAssertion(assertion)
+}
+
+func (v *responseValidator) validateResponse(resp *samlResponse) error {
+	if resp.Destination != "" && resp.Destination != v.acsURL {
+		return fmt.Errorf("response destination %q does not match the assertion consumer service", resp.Destination)
+	}
+	if resp.InResponseTo != v.requestID {
+		return errors.New("response is not for a pending authentication request")
+	}
+	if resp.Status.StatusCode.Value != statusSuccess {
+		return fmt.Errorf("identity provider returned status %q: %s", resp.Status.StatusCode.Value, resp.Status.StatusMessage)
+	}
+	if v.idpEntityID != "" && resp.Issuer != "" && resp.Issuer != v.idpEntityID {
+		return fmt.Errorf("response issuer %q is not trusted", resp.Issuer)
+	}
+	return nil
+}
+
+func (v *responseValidator) validateAssertion(assertion *samlAssertion) error {
+	if assertion.ID == "" {
+		return errors.New("assertion has no ID")
+	}
+	if v.idpEntityID != "" && assertion.Issuer != v.idpEntityID {
+		return fmt.Errorf("assertion issuer %q is not trusted", assertion.Issuer)
+	}
+
+	if conditions := assertion.Conditions; conditions != nil {
+		if !conditions.NotBefore.IsZero() && v.now.Add(allowedClockSkew).Before(conditions.NotBefore) {
+			return errors.New("assertion is not yet valid")
+		}
+		if !conditions.NotOnOrAfter.IsZero() && !v.now.Add(-allowedClockSkew).Before(conditions.NotOnOrAfter) {
+			return errors.New("assertion has expired")
+		}
+		for _, restriction := range conditions.AudienceRestrictions {
+			var found bool
+			for _, audience := range restriction.Audiences {
+				if audience == v.entityID {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return errors.New("assertion is not intended for this service provider")
+			}
+		}
+	}
+
+	for _, confirmation := range assertion.Subject.Confirmations {
+		if confirmation.Method != confirmationMethodBearer {
+			continue
+		}
+		data := confirmation.Data
+		if data.Recipient != v.acsURL {
+			continue
+		}
+		if data.InResponseTo != "" && data.InResponseTo != v.requestID {
+			continue
+		}
+		if data.NotOnOrAfter.IsZero() || !v.now.Add(-allowedClockSkew).Before(data.NotOnOrAfter) {
+			continue
+		}
+		return nil
+	}
+	return errors.New("assertion has no valid bearer subject confirmation")
+}
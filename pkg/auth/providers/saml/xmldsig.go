@@ -0,0 +1,156 @@
+/*
+
+Copyright 2020,2021 Avi Zimmerman
+
+This file is part of kvdi.
+
+kvdi is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+kvdi is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package saml
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/beevik/etree"
+	dsig "github.com/russellhaering/goxmldsig"
+	"github.com/russellhaering/goxmldsig/etreeutils"
+
+	"github.com/kvdi/kvdi/pkg/util/errors"
+)
+
+// Namespaces used in SAML documents
+const (
+	nsProtocol  = "urn:oasis:names:tc:SAML:2.0:protocol"
+	nsAssertion = "urn:oasis:names:tc:SAML:2.0:assertion"
+)
+
+// algRSASHA256 is the algorithm used to sign AuthnRequests.
+const algRSASHA256 = dsig.RSASHA256SignatureMethod
+
+// errNotSigned is returned when verifying an element that has no signature.
+var errNotSigned = errors.New("element is not signed")
+
+// parseXML parses the given document and returns its root element. Documents
+// containing DTDs are rejected.
+func parseXML(data []byte) (*etree.Element, error) {
+	// The prolog is checked on its own so a DTD is reported before any entities
+	// it declares are encountered.
+	dec := xml.NewDecoder(bytes.NewReader(data))
+prolog:
+	for {
+		token, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		switch token.(type) {
+		case xml.Directive:
+			return nil, errors.New("documents containing a DTD are not allowed")
+		case xml.StartElement:
+			break prolog
+		}
+	}
+
+	doc := etree.NewDocument()
+	if err := doc.ReadFromBytes(data); err != nil {
+		return nil, err
+	}
+	root := doc.Root()
+	if root == nil {
+		return nil, errors.New("document has no root element")
+	}
+	return root, nil
+}
+
+// isElement returns true if the element has the given namespace and local name.
+func isElement(el *etree.Element, ns, local string) bool {
+	return el.Tag == local && el.NamespaceURI() == ns
+}
+
+// childElements returns the direct children of the element with the given
+// namespace and local name.
+func childElements(el *etree.Element, ns, local string) []*etree.Element {
+	children := make([]*etree.Element, 0)
+	for _, child := range el.ChildElements() {
+		if isElement(child, ns, local) {
+			children = append(children, child)
+		}
+	}
+	return children
+}
+
+// elementBytes serializes the element on its own, declaring any namespaces it
+// inherits from its ancestors.
+func elementBytes(el *etree.Element) ([]byte, error) {
+	ctx, err := etreeutils.NSBuildParentContext(el)
+	if err != nil {
+		return nil, err
+	}
+	detached, err := etreeutils.NSDetatch(ctx, el)
+	if err != nil {
+		return nil, err
+	}
+	doc := etree.NewDocument()
+	doc.SetRoot(detached)
+	return doc.WriteToBytes()
+}
+
+// decodeBase64 decodes base64 content that may be wrapped across multiple lines.
+func decodeBase64(s string) ([]byte, error) {
+	return base64.StdEncoding.DecodeString(strings.Join(strings.Fields(s), ""))
+}
+
+// verifySignature verifies the enveloped XML signature referencing the given element
+// against the given certificates. On success the verified element is returned without
+// its signature. Callers should only trust data read from the returned element, which
+// guards against signature wrapping attacks.
+func verifySignature(el *etree.Element, certs []*x509.Certificate, now time.Time) (*etree.Element, error) {
+	if el.SelectAttrValue(dsig.DefaultIdAttr, "") == "" {
+		return nil, errors.New("signed elements must have an ID")
+	}
+	ctx, err := etreeutils.NSBuildParentContext(el)
+	if err != nil {
+		return nil, err
+	}
+	detached, err := etreeutils.NSDetatch(ctx, el)
+	if err != nil {
+		return nil, err
+	}
+
+	// Each certificate is tried on its own so signatures without KeyInfo can be
+	// verified while the identity provider rotates its certificate.
+	err = errors.New("no identity provider certificates are configured")
+	for _, cert := range certs {
+		validator := dsig.NewDefaultValidationContext(&dsig.MemoryX509CertificateStore{
+			Roots: []*x509.Certificate{cert},
+		})
+		validator.Clock = dsig.NewFakeClockAt(now)
+		var verified *etree.Element
+		verified, err = validator.Validate(detached)
+		if err == nil {
+			return verified, nil
+		}
+		if err == dsig.ErrMissingSignature {
+			return nil, errNotSigned
+		}
+	}
+	return nil, fmt.Errorf("signature could not be verified with the identity provider certificate: %s", err)
+}
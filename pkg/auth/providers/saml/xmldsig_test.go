@@ -0,0 +1,34 @@
+/*
+
+Copyright 2020,2021 Avi Zimmerman
+
+This file is part of kvdi.
+
+kvdi is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+kvdi is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package saml
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseXMLRejectsDTD(t *testing.T) {
+	doc := `<!DOCTYPE root [<!ENTITY x "boom">]><root>&x;</root>`
+	if _, err := parseXML([]byte(doc)); err == nil || !strings.Contains(err.Error(), "DTD") {
+		t.Error("Expected documents with DTDs to be rejected, got:", err)
+	}
+}
@@ -0,0 +1,87 @@
+/*
+
+Copyright 2020,2021 Avi Zimmerman
+
+This file is part of kvdi.
+
+kvdi is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+kvdi is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package webmesh
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// issuerDiscovery reads the issuer of ID tokens from the metadata server's discovery
+// document, for when it is not configured.
+type issuerDiscovery struct {
+	url    string
+	client *http.Client
+
+	mu        sync.Mutex
+	issuer    string
+	err       error
+	attempted time.Time
+}
+
+// newIssuerDiscovery returns an issuerDiscovery that reads the document at the given URL.
+func newIssuerDiscovery(url string, client *http.Client) *issuerDiscovery {
+	return &issuerDiscovery{url: url, client: client}
+}
+
+// get returns the discovered issuer. It is fetched until it is found, at most once
+// every minRefreshInterval.
+func (d *issuerDiscovery) get() (string, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.issuer != "" {
+		return d.issuer, nil
+	}
+	now := time.Now()
+	if !d.attempted.IsZero() && now.Sub(d.attempted) < minRefreshInterval {
+		return "", d.err
+	}
+	d.attempted = now
+	d.issuer, d.err = d.fetch()
+	return d.issuer, d.err
+}
+
+// fetch retrieves the discovery document and returns the issuer in it.
+func (d *issuerDiscovery) fetch() (string, error) {
+	resp, err := d.client.Get(d.url)
+	if err != nil {
+		return "", fmt.Errorf("could not discover the ID token issuer: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("invalid status code discovering the ID token issuer: %d", resp.StatusCode)
+	}
+	var doc struct {
+		Issuer string `json:"issuer"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		return "", err
+	}
+	if doc.Issuer == "" {
+		return "", errors.New("the discovery document does not contain an issuer")
+	}
+	return doc.Issuer, nil
+}
@@ -0,0 +1,108 @@
+/*
+
+Copyright 2020,2021 Avi Zimmerman
+
+This file is part of kvdi.
+
+kvdi is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+kvdi is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package webmesh
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-jose/go-jose/v3"
+)
+
+// minRefreshInterval is the minimum time between fetches of the key set triggered by
+// tokens signed with an unknown key. It prevents tokens with made up key IDs from
+// causing a request to the metadata server on every login.
+var minRefreshInterval = time.Duration(10) * time.Second
+
+// keySet caches the JSON Web Key Set of the webmesh ID token issuer.
+type keySet struct {
+	url    string
+	client *http.Client
+	ttl    time.Duration
+
+	mu      sync.Mutex
+	keys    []jose.JSONWebKey
+	fetched time.Time
+}
+
+// newKeySet returns a key set that fetches keys from the given URL.
+func newKeySet(url string, client *http.Client, ttl time.Duration) *keySet {
+	return &keySet{url: url, client: client, ttl: ttl}
+}
+
+// lookup returns the keys matching the given key ID, or all keys if the ID is empty.
+// The key set is fetched if the cache has expired or holds no matching keys.
+func (k *keySet) lookup(kid string) ([]jose.JSONWebKey, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	now := time.Now()
+	if k.fetched.IsZero() || now.Sub(k.fetched) > k.ttl {
+		if err := k.refresh(now); err != nil {
+			return nil, err
+		}
+		return k.matching(kid), nil
+	}
+	keys := k.matching(kid)
+	if len(keys) == 0 && now.Sub(k.fetched) > minRefreshInterval {
+		if err := k.refresh(now); err != nil {
+			return nil, err
+		}
+		keys = k.matching(kid)
+	}
+	return keys, nil
+}
+
+// matching returns the cached public signing keys matching the given key ID.
+func (k *keySet) matching(kid string) []jose.JSONWebKey {
+	keys := make([]jose.JSONWebKey, 0)
+	for _, key := range k.keys {
+		if !key.IsPublic() || (key.Use != "" && key.Use != "sig") {
+			continue
+		}
+		if kid == "" || key.KeyID == kid {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// refresh fetches the key set and replaces the cached keys. The lock must be held.
+func (k *keySet) refresh(now time.Time) error {
+	resp, err := k.client.Get(k.url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("invalid status code fetching key set: %d", resp.StatusCode)
+	}
+	var jwks jose.JSONWebKeySet
+	if err := json.NewDecoder(resp.Body).Decode(&jwks); err != nil {
+		return err
+	}
+	k.keys = jwks.Keys
+	k.fetched = now
+	return nil
+}
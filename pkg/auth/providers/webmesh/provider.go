@@ -22,30 +22,32 @@ package webmesh
 
 import (
 	"context"
-	"encoding/json"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
 	"net/http"
-	"path"
-	"strings"
 
 	"github.com/go-jose/go-jose/v3/jwt"
 	"github.com/go-logr/logr"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
 	appv1 "github.com/kvdi/kvdi/apis/app/v1"
-	v1 "github.com/kvdi/kvdi/apis/meta/v1"
 	"github.com/kvdi/kvdi/pkg/types"
-	"github.com/kvdi/kvdi/pkg/util/apiutil"
-	"github.com/kvdi/kvdi/pkg/util/common"
+	"github.com/kvdi/kvdi/pkg/util/rbac"
 )
 
 // AuthProvider implements an auth provider that uses a webmesh cluster as the
 // authentication backend. Access to groups provided in the claims is supplied
-// through annotations on VDIRoles.
+// through VDIRoleBindings and annotations on VDIRoles. ID tokens are verified
+// locally against the issuer's key set.
 type AuthProvider struct {
-	metadataURL string
+	validateURL string
 	cluster     *appv1.VDICluster
 	client      client.Client
+	httpClient  *http.Client
+	keys        *keySet
+	discovery   *issuerDiscovery
 }
 
 // New returns a new AuthProvider.
@@ -61,9 +63,45 @@ func (a *AuthProvider) Reconcile(context.Context, logr.Logger, client.Client, *a
 // Setup is called when the kVDI app launches and is a chance for the provider
 // to setup any resources it needs to serve requests.
 func (a *AuthProvider) Setup(cli client.Client, cluster *appv1.VDICluster) error {
-	a.metadataURL = cluster.Spec.Auth.WebmeshAuth.MetadataURL
 	a.cluster = cluster
 	a.client = cli
+
+	caCert, err := cluster.GetWebmeshCA()
+	if err != nil {
+		return err
+	}
+	var caCertPool *x509.CertPool
+	if caCert != nil {
+		caCertPool = x509.NewCertPool()
+		caCertPool.AppendCertsFromPEM(caCert)
+	}
+	a.httpClient = &http.Client{
+		Timeout: cluster.GetWebmeshRequestTimeout(),
+		Transport: &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			TLSClientConfig: &tls.Config{
+				InsecureSkipVerify: cluster.GetWebmeshInsecureSkipVerify(),
+				RootCAs:            caCertPool,
+			},
+		},
+	}
+
+	a.validateURL, err = cluster.GetWebmeshValidateURL()
+	if err != nil {
+		return err
+	}
+	jwksURL, err := cluster.GetWebmeshJWKSURL()
+	if err != nil {
+		return err
+	}
+	a.keys = newKeySet(jwksURL, a.httpClient, cluster.GetWebmeshJWKSCacheTTL())
+	if cluster.GetWebmeshIssuer() == "" {
+		discoveryURL, err := cluster.GetWebmeshDiscoveryURL()
+		if err != nil {
+			return err
+		}
+		a.discovery = newIssuerDiscovery(discoveryURL, a.httpClient)
+	}
 	return nil
 }
 
@@ -82,62 +120,35 @@ type Claims struct {
 // Authenticate is called for API authentication requests. It should generate
 // a new JWTClaims object and serve an AuthResult back to the API.
 func (a *AuthProvider) Authenticate(req *types.LoginRequest) (*types.AuthResult, error) {
-	token := req.GetRequest().Header.Get("Authorization")
-	if token == "" {
+	var header string
+	if r := req.GetRequest(); r != nil {
+		header = r.Header.Get("Authorization")
+	}
+	if header == "" {
 		return nil, fmt.Errorf("no Authorization header provided")
 	}
-	r, err := http.NewRequest("GET", path.Join(a.metadataURL, "id-tokens", "validate"), nil)
-	if err != nil {
-		return nil, err
+	claims, err := a.verifyToken(bearerToken(header))
+	if errors.Is(err, errKeyUnavailable) && a.cluster.AllowWebmeshRemoteValidation() {
+		claims, err = a.validateRemote(header)
+		if err == nil {
+			err = a.validateClaims(claims)
+		}
 	}
-	r.Header.Set("Authorization", token)
-	resp, err := http.DefaultClient.Do(r)
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("invalid status code: %d", resp.StatusCode)
-	}
-	var claims Claims
-	err = json.NewDecoder(resp.Body).Decode(&claims)
+	bindings, err := rbac.GetRoleBindings(a.client, a.cluster)
 	if err != nil {
 		return nil, err
 	}
-	roles, err := a.cluster.GetRoles(a.client)
-	if err != nil {
-		return nil, err
+	name := claims.ID
+	if claims.ID == ":sub" {
+		name = claims.Subject
 	}
 	return &types.AuthResult{
 		User: &types.VDIUser{
-			Name: func() string {
-				if claims.ID == ":sub" {
-					return claims.Subject
-				}
-				return claims.ID
-			}(),
-			Roles: func() []*types.VDIUserRole {
-				bound := make([]string, 0)
-			Roles:
-				for _, role := range roles {
-					annotations := role.GetAnnotations()
-					if annotations == nil {
-						continue
-					}
-					groupstr, ok := annotations[v1.WebmeshGroupRoleAnnotation]
-					if !ok {
-						continue
-					}
-					groups := strings.Split(groupstr, v1.AuthGroupSeparator)
-					for _, group := range groups {
-						if common.StringSliceContains(claims.Groups, group) {
-							bound = append(bound, role.GetName())
-							continue Roles
-						}
-					}
-				}
-				return apiutil.FilterUserRolesByNames(roles, bound)
-			}(),
+			Name:  name,
+			Roles: bindings.BoundRoles(string(appv1.AuthMethodWebmesh), name, claims.Groups),
 		},
 		RefreshNotSupported: true,
 	}, nil
@@ -0,0 +1,358 @@
+/*
+
+Copyright 2020,2021 Avi Zimmerman
+
+This file is part of kvdi.
+
+kvdi is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+kvdi is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package webmesh
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v3"
+	"github.com/go-jose/go-jose/v3/jwt"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	appv1 "github.com/kvdi/kvdi/apis/app/v1"
+	v1 "github.com/kvdi/kvdi/apis/meta/v1"
+	rbacv1 "github.com/kvdi/kvdi/apis/rbac/v1"
+	"github.com/kvdi/kvdi/pkg/types"
+)
+
+const testIssuer = "https://webmesh.local"
+
+// testServer is a fake metadata server that publishes a key set and counts the
+// requests made to it.
+type testServer struct {
+	*httptest.Server
+	key         *ecdsa.PrivateKey
+	keyID       string
+	remoteToken string
+	issuer      string
+	jwksFetches int32
+	validations int32
+	discoveries int32
+}
+
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &testServer{key: key, keyID: "key-1", issuer: testIssuer}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metadata/id-tokens/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&srv.discoveries, 1)
+		if srv.issuer == "" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"issuer": srv.issuer})
+	})
+	mux.HandleFunc("/metadata/id-tokens/jwks.json", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&srv.jwksFetches, 1)
+		json.NewEncoder(w).Encode(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{
+			{Key: &srv.key.PublicKey, KeyID: srv.keyID, Algorithm: string(jose.ES256), Use: "sig"},
+		}})
+	})
+	mux.HandleFunc("/metadata/id-tokens/validate", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&srv.validations, 1)
+		if srv.remoteToken == "" || r.Header.Get("Authorization") != "Bearer "+srv.remoteToken {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(Claims{
+			Claims: jwt.Claims{
+				ID:       "remote-user",
+				Issuer:   testIssuer,
+				Audience: jwt.Audience{"kvdi"},
+				Expiry:   jwt.NewNumericDate(time.Now().Add(time.Hour)),
+				Subject:  "remote-user",
+			},
+		})
+	})
+	srv.Server = httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// sign returns a token with the given claims signed by the key with the given ID.
+func (s *testServer) sign(t *testing.T, key *ecdsa.PrivateKey, kid string, claims Claims) string {
+	t.Helper()
+	opts := (&jose.SignerOptions{}).WithType("JWT").WithHeader("kid", kid)
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.ES256, Key: key}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := jwt.Signed(signer).Claims(claims).CompactSerialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+func newTestProvider(t *testing.T, srv *testServer, config appv1.WebmeshConfig) *AuthProvider {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	rbacv1.AddToScheme(scheme)
+	c := fake.NewFakeClientWithScheme(scheme)
+	role := &rbacv1.VDIRole{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "mesh-role",
+			Labels:      map[string]string{v1.RoleClusterRefLabel: "test-cluster"},
+			Annotations: map[string]string{v1.WebmeshGroupRoleAnnotation: "mesh-admins"},
+		},
+	}
+	if err := c.Create(context.TODO(), role); err != nil {
+		t.Fatal(err)
+	}
+	config.MetadataURL = srv.URL + "/metadata"
+	cluster := &appv1.VDICluster{}
+	cluster.Name = "test-cluster"
+	cluster.Spec.Auth = &appv1.AuthConfig{WebmeshAuth: &config}
+	provider := New()
+	if err := provider.Setup(c, cluster); err != nil {
+		t.Fatal(err)
+	}
+	return provider
+}
+
+func newLoginRequest(t *testing.T, token string) *types.LoginRequest {
+	t.Helper()
+	r, err := http.NewRequest(http.MethodPost, "/api/login", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set("Authorization", "Bearer "+token)
+	req := &types.LoginRequest{}
+	req.SetRequest(r)
+	return req
+}
+
+func validClaims() Claims {
+	return Claims{
+		Claims: jwt.Claims{
+			ID:       ":sub",
+			Subject:  "node-1",
+			Issuer:   testIssuer,
+			Audience: jwt.Audience{"kvdi"},
+			Expiry:   jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		},
+		Groups: []string{"mesh-admins"},
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	srv := newTestServer(t)
+	provider := newTestProvider(t, srv, appv1.WebmeshConfig{
+		Issuer:    testIssuer,
+		Audiences: []string{"other", "kvdi"},
+	})
+
+	for i := 0; i < 2; i++ {
+		token := srv.sign(t, srv.key, srv.keyID, validClaims())
+		result, err := provider.Authenticate(newLoginRequest(t, token))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.User.Name != "node-1" {
+			t.Error("Expected user node-1, got", result.User.Name)
+		}
+		if len(result.User.Roles) != 1 || result.User.Roles[0].Name != "mesh-role" {
+			t.Error("Expected mesh-role to be bound, got", result.User.Roles)
+		}
+		if !result.RefreshNotSupported {
+			t.Error("Expected refresh to not be supported")
+		}
+	}
+	if fetches := atomic.LoadInt32(&srv.jwksFetches); fetches != 1 {
+		t.Error("Expected the key set to be fetched once, got", fetches)
+	}
+	if validations := atomic.LoadInt32(&srv.validations); validations != 0 {
+		t.Error("Expected no remote validations, got", validations)
+	}
+}
+
+func TestAuthenticateRejected(t *testing.T) {
+	srv := newTestServer(t)
+	provider := newTestProvider(t, srv, appv1.WebmeshConfig{
+		Issuer:    testIssuer,
+		Audiences: []string{"kvdi"},
+	})
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expired := validClaims()
+	expired.Expiry = jwt.NewNumericDate(time.Now().Add(-time.Hour))
+	wrongIssuer := validClaims()
+	wrongIssuer.Issuer = "https://other.local"
+	wrongAudience := validClaims()
+	wrongAudience.Audience = jwt.Audience{"other"}
+	noExpiry := validClaims()
+	noExpiry.Expiry = nil
+
+	for name, token := range map[string]string{
+		"malformed":      "not-a-token",
+		"wrong key":      srv.sign(t, otherKey, srv.keyID, validClaims()),
+		"unknown key":    srv.sign(t, otherKey, "key-2", validClaims()),
+		"expired":        srv.sign(t, srv.key, srv.keyID, expired),
+		"wrong issuer":   srv.sign(t, srv.key, srv.keyID, wrongIssuer),
+		"wrong audience": srv.sign(t, srv.key, srv.keyID, wrongAudience),
+		"no expiry":      srv.sign(t, srv.key, srv.keyID, noExpiry),
+	} {
+		if _, err := provider.Authenticate(newLoginRequest(t, token)); err == nil {
+			t.Errorf("Expected error for %s", name)
+		}
+	}
+	if _, err := provider.Authenticate(&types.LoginRequest{}); err == nil {
+		t.Error("Expected error for a request without a token")
+	}
+	if validations := atomic.LoadInt32(&srv.validations); validations != 0 {
+		t.Error("Expected no remote validations, got", validations)
+	}
+}
+
+func TestAuthenticateRemoteValidation(t *testing.T) {
+	srv := newTestServer(t)
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// keys that cannot be found are validated remotely when allowed
+	provider := newTestProvider(t, srv, appv1.WebmeshConfig{
+		JWKSURL:               srv.URL + "/missing",
+		ClientID:              "kvdi",
+		AllowRemoteValidation: true,
+	})
+	if _, err := provider.Authenticate(newLoginRequest(t, "not-a-token")); err == nil {
+		t.Error("Expected error for a malformed token")
+	}
+	remoteToken := srv.sign(t, otherKey, "key-2", validClaims())
+	if _, err := provider.Authenticate(newLoginRequest(t, remoteToken)); err == nil {
+		t.Error("Expected error for a token the metadata server rejects")
+	}
+	srv.remoteToken = remoteToken
+	result, err := provider.Authenticate(newLoginRequest(t, remoteToken))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.User.Name != "remote-user" {
+		t.Error("Expected user remote-user, got", result.User.Name)
+	}
+	if validations := atomic.LoadInt32(&srv.validations); validations != 2 {
+		t.Error("Expected two remote validations, got", validations)
+	}
+
+	// signatures that do not verify are never validated remotely
+	provider = newTestProvider(t, srv, appv1.WebmeshConfig{ClientID: "kvdi", AllowRemoteValidation: true})
+	if _, err := provider.Authenticate(newLoginRequest(t, srv.sign(t, otherKey, srv.keyID, validClaims()))); err == nil {
+		t.Error("Expected error for a token signed by the wrong key")
+	}
+	if validations := atomic.LoadInt32(&srv.validations); validations != 2 {
+		t.Error("Expected no further remote validations, got", validations)
+	}
+
+	// without remote validation, unknown keys are rejected
+	provider = newTestProvider(t, srv, appv1.WebmeshConfig{JWKSURL: srv.URL + "/missing", ClientID: "kvdi"})
+	if _, err := provider.Authenticate(newLoginRequest(t, remoteToken)); err == nil {
+		t.Error("Expected error when the key set is unavailable")
+	}
+}
+
+func TestAuthenticateDefaults(t *testing.T) {
+	srv := newTestServer(t)
+
+	// the issuer is discovered and the audience defaults to the client ID
+	provider := newTestProvider(t, srv, appv1.WebmeshConfig{ClientID: "kvdi"})
+	if _, err := provider.Authenticate(newLoginRequest(t, srv.sign(t, srv.key, srv.keyID, validClaims()))); err != nil {
+		t.Fatal(err)
+	}
+	wrongIssuer := validClaims()
+	wrongIssuer.Issuer = "https://other.local"
+	wrongAudience := validClaims()
+	wrongAudience.Audience = jwt.Audience{"other"}
+	for name, claims := range map[string]Claims{"wrong issuer": wrongIssuer, "wrong audience": wrongAudience} {
+		if _, err := provider.Authenticate(newLoginRequest(t, srv.sign(t, srv.key, srv.keyID, claims))); err == nil {
+			t.Errorf("Expected error for %s", name)
+		}
+	}
+	if discoveries := atomic.LoadInt32(&srv.discoveries); discoveries != 1 {
+		t.Error("Expected the issuer to be discovered once, got", discoveries)
+	}
+
+	// without an audience tokens are rejected
+	provider = newTestProvider(t, srv, appv1.WebmeshConfig{Issuer: testIssuer})
+	if _, err := provider.Authenticate(newLoginRequest(t, srv.sign(t, srv.key, srv.keyID, validClaims()))); err == nil {
+		t.Error("Expected error when no audience is configured")
+	}
+
+	// without an issuer tokens are rejected
+	srv.issuer = ""
+	provider = newTestProvider(t, srv, appv1.WebmeshConfig{ClientID: "kvdi"})
+	if _, err := provider.Authenticate(newLoginRequest(t, srv.sign(t, srv.key, srv.keyID, validClaims()))); err == nil {
+		t.Error("Expected error when the issuer cannot be discovered")
+	}
+}
+
+func TestKeySetRefresh(t *testing.T) {
+	defer func(interval time.Duration) { minRefreshInterval = interval }(minRefreshInterval)
+	minRefreshInterval = 0
+
+	srv := newTestServer(t)
+	keys := newKeySet(srv.URL+"/metadata/id-tokens/jwks.json", srv.Client(), time.Hour)
+
+	found, err := keys.lookup(srv.keyID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 1 {
+		t.Fatal("Expected one key, got", len(found))
+	}
+
+	// a rotated key is picked up when a token references it
+	srv.keyID = "key-2"
+	found, err = keys.lookup("key-2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 1 || found[0].KeyID != "key-2" {
+		t.Error("Expected the rotated key to be found, got", found)
+	}
+	if fetches := atomic.LoadInt32(&srv.jwksFetches); fetches != 2 {
+		t.Error("Expected the key set to be fetched twice, got", fetches)
+	}
+	if !reflect.DeepEqual(keys.matching("key-1"), []jose.JSONWebKey{}) {
+		t.Error("Expected the old key to be dropped")
+	}
+}
@@ -0,0 +1,164 @@
+/*
+
+Copyright 2020,2021 Avi Zimmerman
+
+This file is part of kvdi.
+
+kvdi is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+kvdi is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package webmesh
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-jose/go-jose/v3"
+	"github.com/go-jose/go-jose/v3/jwt"
+)
+
+// errKeyUnavailable is returned when a token cannot be verified locally because the
+// key set could not be fetched or does not contain the signing key.
+var errKeyUnavailable = errors.New("no key available to verify the token")
+
+// signingAlgorithms are the algorithms accepted for ID tokens. Symmetric algorithms
+// are never accepted since the keys are published.
+var signingAlgorithms = []jose.SignatureAlgorithm{
+	jose.RS256, jose.RS384, jose.RS512,
+	jose.PS256, jose.PS384, jose.PS512,
+	jose.ES256, jose.ES384, jose.ES512,
+	jose.EdDSA,
+}
+
+// bearerToken returns the token in an Authorization header value.
+func bearerToken(header string) string {
+	if len(header) > 7 && strings.EqualFold(header[:7], "bearer ") {
+		return strings.TrimSpace(header[7:])
+	}
+	return strings.TrimSpace(header)
+}
+
+// verifyToken verifies the signature of a token against the issuer's key set and
+// validates its claims.
+func (a *AuthProvider) verifyToken(raw string) (*Claims, error) {
+	tok, err := jwt.ParseSigned(raw)
+	if err != nil {
+		return nil, err
+	}
+	if len(tok.Headers) != 1 {
+		return nil, errors.New("token must have exactly one signature")
+	}
+	header := tok.Headers[0]
+	if !isSigningAlgorithm(header.Algorithm) {
+		return nil, fmt.Errorf("unsupported signing algorithm: %s", header.Algorithm)
+	}
+	keys, err := a.keys.lookup(header.KeyID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", errKeyUnavailable, err.Error())
+	}
+	if len(keys) == 0 {
+		return nil, errKeyUnavailable
+	}
+	var claims Claims
+	verified := false
+	for _, key := range keys {
+		if err = tok.Claims(key.Key, &claims); err == nil {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return nil, err
+	}
+	if err := a.validateClaims(&claims); err != nil {
+		return nil, err
+	}
+	return &claims, nil
+}
+
+// validateClaims checks the expiry, issuer and audience of verified claims. Claims are
+// rejected when the expected issuer or audience cannot be determined.
+func (a *AuthProvider) validateClaims(claims *Claims) error {
+	if claims.Expiry == nil {
+		return errors.New("token does not have an expiry")
+	}
+	issuer, err := a.expectedIssuer()
+	if err != nil {
+		return err
+	}
+	if err := claims.Validate(jwt.Expected{
+		Issuer: issuer,
+		Time:   time.Now(),
+	}); err != nil {
+		return err
+	}
+	audiences := a.cluster.GetWebmeshAudiences()
+	if len(audiences) == 0 {
+		return errors.New("no audience is configured for ID tokens, set a clientID or audiences")
+	}
+	for _, aud := range audiences {
+		if claims.Audience.Contains(aud) {
+			return nil
+		}
+	}
+	return jwt.ErrInvalidAudience
+}
+
+// expectedIssuer returns the configured issuer of ID tokens, or the discovered one.
+func (a *AuthProvider) expectedIssuer() (string, error) {
+	if issuer := a.cluster.GetWebmeshIssuer(); issuer != "" {
+		return issuer, nil
+	}
+	if a.discovery == nil {
+		return "", errors.New("no issuer is configured for ID tokens")
+	}
+	return a.discovery.get()
+}
+
+// validateRemote validates a token with the metadata server and returns the claims
+// it responds with.
+func (a *AuthProvider) validateRemote(header string) (*Claims, error) {
+	r, err := http.NewRequest(http.MethodGet, a.validateURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	r.Header.Set("Authorization", header)
+	resp, err := a.httpClient.Do(r)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("invalid status code: %d", resp.StatusCode)
+	}
+	var claims Claims
+	if err := json.NewDecoder(resp.Body).Decode(&claims); err != nil {
+		return nil, err
+	}
+	return &claims, nil
+}
+
+func isSigningAlgorithm(alg string) bool {
+	for _, supported := range signingAlgorithms {
+		if string(supported) == alg {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,76 @@
+/*
+
+Copyright 2020,2021 Avi Zimmerman
+
+This file is part of kvdi.
+
+kvdi is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+kvdi is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package scim
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// filterRegex matches equality filters, e.g. `userName eq "alice"`.
+var filterRegex = regexp.MustCompile(`^\s*([A-Za-z][\w.]*)\s+(?i:eq)\s+("(?:[^"\\]|\\.)*")\s*$`)
+
+// Filter is a parsed query filter. Only equality filters on a single attribute are
+// supported, which is what provisioning clients use to look up existing resources.
+type Filter struct {
+	// The attribute to compare
+	Attribute string
+	// The value the attribute must be equal to
+	Value string
+}
+
+// ParseFilter parses the given query filter, allowing only the given attributes. A
+// nil filter is returned when it is empty.
+func ParseFilter(filter string, attributes ...string) (*Filter, error) {
+	if strings.TrimSpace(filter) == "" {
+		return nil, nil
+	}
+	match := filterRegex.FindStringSubmatch(filter)
+	if match == nil {
+		return nil, NewError(http.StatusBadRequest, "invalidFilter", "Only equality filters are supported")
+	}
+	value, err := strconv.Unquote(match[2])
+	if err != nil {
+		return nil, NewError(http.StatusBadRequest, "invalidFilter", "Invalid value in filter: "+match[2])
+	}
+	for _, attr := range attributes {
+		if strings.EqualFold(attr, match[1]) {
+			return &Filter{Attribute: attr, Value: value}, nil
+		}
+	}
+	return nil, NewError(http.StatusBadRequest, "invalidFilter", "Filtering is not supported on "+match[1])
+}
+
+// Matches returns true if the given values of the resource match the filter. Values
+// are compared case-insensitively, except for IDs.
+func (f *Filter) Matches(values map[string]string) bool {
+	if f == nil {
+		return true
+	}
+	value := values[f.Attribute]
+	if f.Attribute == "id" || f.Attribute == "externalId" {
+		return value == f.Value
+	}
+	return strings.EqualFold(value, f.Value)
+}
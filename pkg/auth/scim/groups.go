@@ -0,0 +1,319 @@
+/*
+
+Copyright 2020,2021 Avi Zimmerman
+
+This file is part of kvdi.
+
+kvdi is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+kvdi is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package scim
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+
+	v1 "github.com/kvdi/kvdi/apis/meta/v1"
+	rbacv1 "github.com/kvdi/kvdi/apis/rbac/v1"
+	"github.com/kvdi/kvdi/pkg/util/common"
+	"github.com/kvdi/kvdi/pkg/util/errors"
+)
+
+// groupRecord is the record kept for a group in the secrets backend. Its members are
+// recorded on the VDIUsers by the group's display name.
+type groupRecord struct {
+	// The ID of the group
+	ID string `json:"id"`
+	// The name of the group, which VDIRoles are bound to
+	DisplayName string `json:"displayName"`
+	// The ID of the group at the identity provider
+	ExternalID string `json:"externalId,omitempty"`
+	// When the group was created
+	CreatedAt time.Time `json:"createdAt"`
+	// When the group was last changed
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// ListGroups returns the groups matching the given filter, ordered by their displayName.
+func (m *Manager) ListGroups(filter string) ([]*Group, error) {
+	f, err := ParseFilter(filter, "id", "displayName", "externalId")
+	if err != nil {
+		return nil, err
+	}
+	records, err := m.readGroups()
+	if err != nil {
+		return nil, err
+	}
+	users, err := m.cluster.GetSCIMUsers(m.client)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]*Group, 0)
+	for _, record := range records {
+		if f.Matches(map[string]string{"id": record.ID, "displayName": record.DisplayName, "externalId": record.ExternalID}) {
+			out = append(out, groupFromRecord(record, users))
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].DisplayName < out[j].DisplayName })
+	return out, nil
+}
+
+// GetGroup returns the group with the given ID.
+func (m *Manager) GetGroup(id string) (*Group, error) {
+	records, err := m.readGroups()
+	if err != nil {
+		return nil, err
+	}
+	record, ok := records[id]
+	if !ok {
+		return nil, newNotFoundError("Group", id)
+	}
+	users, err := m.cluster.GetSCIMUsers(m.client)
+	if err != nil {
+		return nil, err
+	}
+	return groupFromRecord(record, users), nil
+}
+
+// CreateGroup creates the given group with its members and returns it.
+func (m *Manager) CreateGroup(group *Group) (*Group, error) {
+	if err := m.secrets.Lock(15); err != nil {
+		return nil, err
+	}
+	defer m.secrets.Release()
+	records, err := m.readGroups()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC().Truncate(time.Second)
+	record := &groupRecord{ID: uuid.New().String(), CreatedAt: now}
+	return m.writeGroup(records, record, group)
+}
+
+// ReplaceGroup replaces the attributes and members of the group with the given ID and
+// returns it.
+func (m *Manager) ReplaceGroup(id string, group *Group) (*Group, error) {
+	if err := m.secrets.Lock(15); err != nil {
+		return nil, err
+	}
+	defer m.secrets.Release()
+	records, err := m.readGroups()
+	if err != nil {
+		return nil, err
+	}
+	record, ok := records[id]
+	if !ok {
+		return nil, newNotFoundError("Group", id)
+	}
+	return m.writeGroup(records, record, group)
+}
+
+// PatchGroup applies the given patch to the group with the given ID and returns it.
+func (m *Manager) PatchGroup(id string, req *PatchRequest) (*Group, error) {
+	if err := m.secrets.Lock(15); err != nil {
+		return nil, err
+	}
+	defer m.secrets.Release()
+	records, err := m.readGroups()
+	if err != nil {
+		return nil, err
+	}
+	record, ok := records[id]
+	if !ok {
+		return nil, newNotFoundError("Group", id)
+	}
+	users, err := m.cluster.GetSCIMUsers(m.client)
+	if err != nil {
+		return nil, err
+	}
+	group := groupFromRecord(record, users)
+	if err := applyGroupPatch(group, req.Operations); err != nil {
+		return nil, err
+	}
+	return m.writeGroup(records, record, group)
+}
+
+// DeleteGroup removes the group with the given ID and its members from it.
+func (m *Manager) DeleteGroup(id string) error {
+	if err := m.secrets.Lock(15); err != nil {
+		return err
+	}
+	defer m.secrets.Release()
+	records, err := m.readGroups()
+	if err != nil {
+		return err
+	}
+	record, ok := records[id]
+	if !ok {
+		return newNotFoundError("Group", id)
+	}
+	if err := m.syncMembers(record.DisplayName, "", nil); err != nil {
+		return err
+	}
+	delete(records, id)
+	return m.writeGroups(records)
+}
+
+// writeGroup stores the attributes of the given group in its record and updates the
+// VDIUsers of its members. The caller must hold the secrets lock.
+func (m *Manager) writeGroup(records map[string]*groupRecord, record *groupRecord, group *Group) (*Group, error) {
+	if group.DisplayName == "" {
+		return nil, newInvalidValueError("displayName is required")
+	}
+	if other := findGroupByName(records, group.DisplayName); other != nil && other.ID != record.ID {
+		return nil, newUniquenessError(fmt.Sprintf("Group %s already exists", group.DisplayName))
+	}
+	if err := m.syncMembers(record.DisplayName, group.DisplayName, group.Members); err != nil {
+		return nil, err
+	}
+	record.DisplayName = group.DisplayName
+	record.ExternalID = group.ExternalID
+	record.UpdatedAt = time.Now().UTC().Truncate(time.Second)
+	records[record.ID] = record
+	if err := m.writeGroups(records); err != nil {
+		return nil, err
+	}
+	users, err := m.cluster.GetSCIMUsers(m.client)
+	if err != nil {
+		return nil, err
+	}
+	return groupFromRecord(record, users), nil
+}
+
+// syncMembers moves the given members from the group with the old name to the group
+// with the new name, and removes everyone else from it. An empty new name removes
+// all members.
+func (m *Manager) syncMembers(oldName, newName string, members []Member) error {
+	users, err := m.cluster.GetSCIMUsers(m.client)
+	if err != nil {
+		return err
+	}
+	known := make(map[string]bool, len(users))
+	for _, user := range users {
+		known[user.GetName()] = true
+	}
+	for _, member := range members {
+		if !known[member.Value] {
+			return newInvalidValueError(fmt.Sprintf("Member %s is not a user", member.Value))
+		}
+	}
+	for _, user := range users {
+		groups := make([]string, 0, len(user.Spec.Groups))
+		for _, group := range user.Spec.Groups {
+			if oldName == "" || group != oldName {
+				groups = append(groups, group)
+			}
+		}
+		if newName != "" && isMember(members, user.GetName()) {
+			groups = common.AppendStringIfMissing(groups, newName)
+		}
+		if stringSlicesEqual(groups, user.Spec.Groups) {
+			continue
+		}
+		user.Spec.Groups = groups
+		if err := m.client.Update(context.TODO(), user); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readGroups reads all group records from the secrets backend.
+func (m *Manager) readGroups() (map[string]*groupRecord, error) {
+	data, err := m.secrets.ReadSecretMap(v1.SCIMGroupsSecretKey, false)
+	if err != nil {
+		if errors.IsSecretNotFoundError(err) {
+			return map[string]*groupRecord{}, nil
+		}
+		return nil, err
+	}
+	records := make(map[string]*groupRecord, len(data))
+	for id, raw := range data {
+		record := &groupRecord{}
+		if err := json.Unmarshal(raw, record); err != nil {
+			return nil, err
+		}
+		records[id] = record
+	}
+	return records, nil
+}
+
+func (m *Manager) writeGroups(records map[string]*groupRecord) error {
+	data := make(map[string][]byte, len(records))
+	for id, record := range records {
+		raw, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		data[id] = raw
+	}
+	return m.secrets.WriteSecretMap(v1.SCIMGroupsSecretKey, data)
+}
+
+// groupFromRecord converts the given record to a SCIM group with the given users that
+// are members of it.
+func groupFromRecord(record *groupRecord, users []*rbacv1.VDIUser) *Group {
+	created, modified := record.CreatedAt, record.UpdatedAt
+	group := &Group{
+		Schemas:     []string{GroupSchema},
+		ID:          record.ID,
+		ExternalID:  record.ExternalID,
+		DisplayName: record.DisplayName,
+		Members:     make([]Member, 0),
+		Meta:        &Meta{ResourceType: "Group", Created: &created, LastModified: &modified},
+	}
+	for _, user := range users {
+		if common.StringSliceContains(user.Spec.Groups, record.DisplayName) {
+			group.Members = append(group.Members, Member{Value: user.GetName(), Display: user.GetUsername()})
+		}
+	}
+	sort.Slice(group.Members, func(i, j int) bool { return group.Members[i].Value < group.Members[j].Value })
+	return group
+}
+
+func findGroupByName(records map[string]*groupRecord, name string) *groupRecord {
+	for _, record := range records {
+		if record.DisplayName == name {
+			return record
+		}
+	}
+	return nil
+}
+
+func isMember(members []Member, id string) bool {
+	for _, member := range members {
+		if member.Value == id {
+			return true
+		}
+	}
+	return false
+}
+
+func stringSlicesEqual(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
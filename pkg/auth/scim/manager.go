@@ -0,0 +1,49 @@
+/*
+
+Copyright 2020,2021 Avi Zimmerman
+
+This file is part of kvdi.
+
+kvdi is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+kvdi is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package scim
+
+import (
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	appv1 "github.com/kvdi/kvdi/apis/app/v1"
+	"github.com/kvdi/kvdi/pkg/secrets"
+)
+
+// Manager is an object for provisioning users and groups over SCIM. Users are stored
+// as VDIUsers and groups in the configured secrets backend.
+type Manager struct {
+	secrets *secrets.SecretEngine
+	client  client.Client
+	cluster *appv1.VDICluster
+}
+
+// NewManager returns a new SCIM manager with the given secrets engine.
+func NewManager(secrets *secrets.SecretEngine) *Manager {
+	return &Manager{secrets: secrets}
+}
+
+// Setup sets the k8s client and VDICluster used to manage VDIUsers. It should be
+// called again whenever the VDICluster changes.
+func (m *Manager) Setup(c client.Client, cluster *appv1.VDICluster) {
+	m.client = c
+	m.cluster = cluster
+}
@@ -0,0 +1,277 @@
+/*
+
+Copyright 2020,2021 Avi Zimmerman
+
+This file is part of kvdi.
+
+kvdi is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+kvdi is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package scim
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// memberPathRegex matches paths selecting a single group member, e.g.
+// `members[value eq "2819c223"]`.
+var memberPathRegex = regexp.MustCompile(`^(?i:members)\[\s*(?i:value)\s+(?i:eq)\s+"([^"]*)"\s*\]$`)
+
+// applyUserPatch applies the given operations to the user. Attributes kVDI does not
+// keep are ignored.
+func applyUserPatch(user *User, ops []PatchOperation) error {
+	return applyPatch(ops, func(attr string, value interface{}, add bool) error {
+		return setUserAttribute(user, attr, value, add)
+	}, nil)
+}
+
+// applyGroupPatch applies the given operations to the group. Attributes kVDI does not
+// keep are ignored.
+func applyGroupPatch(group *Group, ops []PatchOperation) error {
+	return applyPatch(ops, func(attr string, value interface{}, add bool) error {
+		return setGroupAttribute(group, attr, value, add)
+	}, func(path string, value interface{}) (bool, error) {
+		if match := memberPathRegex.FindStringSubmatch(path); match != nil {
+			group.Members = removeMembers(group.Members, []Member{{Value: match[1]}})
+			return true, nil
+		}
+		if strings.EqualFold(path, "members") && value != nil {
+			members, err := membersValue(value)
+			if err != nil {
+				return true, err
+			}
+			group.Members = removeMembers(group.Members, members)
+			return true, nil
+		}
+		return false, nil
+	})
+}
+
+// applyPatch calls set for every attribute changed by the given operations. Removed
+// attributes are set to nil. The remove function can handle removals that do not
+// clear an attribute and returns true if it did.
+func applyPatch(
+	ops []PatchOperation,
+	set func(attr string, value interface{}, add bool) error,
+	remove func(path string, value interface{}) (bool, error),
+) error {
+	for _, op := range ops {
+		switch strings.ToLower(op.Op) {
+		case "add", "replace":
+			add := strings.EqualFold(op.Op, "add")
+			if op.Path != "" {
+				if err := set(op.Path, op.Value, add); err != nil {
+					return err
+				}
+				continue
+			}
+			values, ok := op.Value.(map[string]interface{})
+			if !ok {
+				return newInvalidValueError("Operations without a path must have an object value")
+			}
+			for attr, value := range values {
+				if err := set(attr, value, add); err != nil {
+					return err
+				}
+			}
+		case "remove":
+			if op.Path == "" {
+				return NewError(http.StatusBadRequest, "noTarget", "Remove operations require a path")
+			}
+			if remove != nil {
+				if handled, err := remove(op.Path, op.Value); handled || err != nil {
+					if err != nil {
+						return err
+					}
+					continue
+				}
+			}
+			if err := set(op.Path, nil, false); err != nil {
+				return err
+			}
+		default:
+			return NewError(http.StatusBadRequest, "invalidSyntax", "Unsupported patch operation: "+op.Op)
+		}
+	}
+	return nil
+}
+
+func setUserAttribute(user *User, attr string, value interface{}, add bool) error {
+	var err error
+	switch strings.ToLower(attr) {
+	case "active":
+		if value == nil {
+			user.Active = nil
+			return nil
+		}
+		var active bool
+		if active, err = boolValue(attr, value); err == nil {
+			user.Active = &active
+		}
+	case "username":
+		if value == nil {
+			return NewError(http.StatusBadRequest, "mutability", "userName cannot be removed")
+		}
+		user.UserName, err = stringValue(attr, value)
+	case "displayname":
+		user.DisplayName, err = stringValue(attr, value)
+	case "externalid":
+		user.ExternalID, err = stringValue(attr, value)
+	case "emails":
+		var emails []Email
+		if emails, err = emailsValue(value); err == nil {
+			if add {
+				emails = append(user.Emails, emails...)
+			}
+			user.Emails = emails
+		}
+	}
+	return err
+}
+
+func setGroupAttribute(group *Group, attr string, value interface{}, add bool) error {
+	var err error
+	switch strings.ToLower(attr) {
+	case "displayname":
+		if value == nil {
+			return NewError(http.StatusBadRequest, "mutability", "displayName cannot be removed")
+		}
+		group.DisplayName, err = stringValue(attr, value)
+	case "externalid":
+		group.ExternalID, err = stringValue(attr, value)
+	case "members":
+		var members []Member
+		if members, err = membersValue(value); err == nil {
+			if add {
+				members = append(removeMembers(group.Members, members), members...)
+			}
+			group.Members = members
+		}
+	}
+	return err
+}
+
+// removeMembers returns the given members without the ones to remove.
+func removeMembers(members, remove []Member) []Member {
+	out := make([]Member, 0, len(members))
+	for _, member := range members {
+		var removed bool
+		for _, r := range remove {
+			if r.Value == member.Value {
+				removed = true
+				break
+			}
+		}
+		if !removed {
+			out = append(out, member)
+		}
+	}
+	return out
+}
+
+func stringValue(attr string, value interface{}) (string, error) {
+	if value == nil {
+		return "", nil
+	}
+	str, ok := value.(string)
+	if !ok {
+		return "", newInvalidValueError(fmt.Sprintf("%s must be a string", attr))
+	}
+	return str, nil
+}
+
+// boolValue reads a boolean value. Some clients send booleans as strings.
+func boolValue(attr string, value interface{}) (bool, error) {
+	switch v := value.(type) {
+	case bool:
+		return v, nil
+	case string:
+		switch strings.ToLower(v) {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		}
+	}
+	return false, newInvalidValueError(fmt.Sprintf("%s must be a boolean", attr))
+}
+
+func emailsValue(value interface{}) ([]Email, error) {
+	items, err := objectsValue("emails", value)
+	if err != nil {
+		return nil, err
+	}
+	emails := make([]Email, 0, len(items))
+	for _, item := range items {
+		email := Email{}
+		if email.Value, err = stringValue("emails.value", item["value"]); err != nil {
+			return nil, err
+		}
+		if email.Type, err = stringValue("emails.type", item["type"]); err != nil {
+			return nil, err
+		}
+		if primary, ok := item["primary"]; ok {
+			if email.Primary, err = boolValue("emails.primary", primary); err != nil {
+				return nil, err
+			}
+		}
+		emails = append(emails, email)
+	}
+	return emails, nil
+}
+
+func membersValue(value interface{}) ([]Member, error) {
+	items, err := objectsValue("members", value)
+	if err != nil {
+		return nil, err
+	}
+	members := make([]Member, 0, len(items))
+	for _, item := range items {
+		member := Member{}
+		if member.Value, err = stringValue("members.value", item["value"]); err != nil {
+			return nil, err
+		}
+		if member.Value == "" {
+			return nil, newInvalidValueError("members.value is required")
+		}
+		members = append(members, member)
+	}
+	return members, nil
+}
+
+// objectsValue reads a multi-valued attribute of complex values. A single value is
+// also accepted.
+func objectsValue(attr string, value interface{}) ([]map[string]interface{}, error) {
+	switch v := value.(type) {
+	case nil:
+		return nil, nil
+	case map[string]interface{}:
+		return []map[string]interface{}{v}, nil
+	case []interface{}:
+		out := make([]map[string]interface{}, 0, len(v))
+		for _, item := range v {
+			obj, ok := item.(map[string]interface{})
+			if !ok {
+				return nil, newInvalidValueError(fmt.Sprintf("%s must be a list of objects", attr))
+			}
+			out = append(out, obj)
+		}
+		return out, nil
+	}
+	return nil, newInvalidValueError(fmt.Sprintf("%s must be a list of objects", attr))
+}
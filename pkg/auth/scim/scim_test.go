@@ -0,0 +1,349 @@
+/*
+
+Copyright 2020,2021 Avi Zimmerman
+
+This file is part of kvdi.
+
+kvdi is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+kvdi is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package scim
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/types"
+
+	appv1 "github.com/kvdi/kvdi/apis/app/v1"
+	v1 "github.com/kvdi/kvdi/apis/meta/v1"
+	rbacv1 "github.com/kvdi/kvdi/apis/rbac/v1"
+	"github.com/kvdi/kvdi/pkg/secrets"
+)
+
+func newTestManager(t *testing.T) *Manager {
+	t.Helper()
+	cluster := &appv1.VDICluster{}
+	cluster.Name = "test-cluster"
+	engine, c, err := secrets.NewTestEngine(cluster)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := NewManager(engine)
+	m.Setup(c, cluster)
+	return m
+}
+
+func expectSCIMError(t *testing.T, err error, status int) {
+	t.Helper()
+	if err == nil {
+		t.Fatalf("Expected error with status %d, got nil", status)
+	}
+	if code := ToError(err).StatusCode(); code != status {
+		t.Errorf("Expected status %d, got %d: %s", status, code, err)
+	}
+}
+
+func TestParseFilter(t *testing.T) {
+	f, err := ParseFilter(`UserName eq "Alice"`, "userName")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Attribute != "userName" || f.Value != "Alice" {
+		t.Errorf("Unexpected filter: %+v", f)
+	}
+	if !f.Matches(map[string]string{"userName": "alice"}) {
+		t.Error("Expected userName to match case-insensitively")
+	}
+	if f.Matches(map[string]string{"userName": "bob"}) {
+		t.Error("Expected bob not to match")
+	}
+
+	f, err = ParseFilter(`externalId eq "ABC"`, "externalId")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Matches(map[string]string{"externalId": "abc"}) {
+		t.Error("Expected externalId to match case-sensitively")
+	}
+
+	if f, err = ParseFilter("", "userName"); err != nil || f != nil || !f.Matches(nil) {
+		t.Error("Expected an empty filter to match everything")
+	}
+	for _, filter := range []string{`userName sw "a"`, `userName eq alice`, `emails eq "a@example.com"`} {
+		_, err := ParseFilter(filter, "userName")
+		expectSCIMError(t, err, http.StatusBadRequest)
+	}
+}
+
+func TestPage(t *testing.T) {
+	for _, tc := range []struct {
+		total, startIndex, count int
+		start, end               int
+	}{
+		{10, 1, -1, 0, 10},
+		{10, 0, 5, 0, 5},
+		{10, 6, 10, 5, 10},
+		{10, 20, 5, 10, 10},
+		{10, 1, 0, 0, 0},
+		{2000, 1, 5000, 0, maxResults},
+	} {
+		start, end := Page(tc.total, tc.startIndex, tc.count)
+		if start != tc.start || end != tc.end {
+			t.Errorf("Page(%d, %d, %d) = %d, %d, expected %d, %d",
+				tc.total, tc.startIndex, tc.count, start, end, tc.start, tc.end)
+		}
+	}
+}
+
+func TestApplyPatch(t *testing.T) {
+	user := &User{UserName: "alice"}
+	err := applyUserPatch(user, []PatchOperation{
+		{Op: "Replace", Value: map[string]interface{}{"active": "False", "displayName": "Alice"}},
+		{Op: "add", Path: "emails", Value: []interface{}{map[string]interface{}{"value": "alice@example.com", "primary": true}}},
+		{Op: "replace", Path: "nickName", Value: "ali"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.IsActive() {
+		t.Error("Expected user to be deactivated")
+	}
+	if user.DisplayName != "Alice" {
+		t.Errorf("Expected display name to be set, got %q", user.DisplayName)
+	}
+	if len(user.Emails) != 1 || user.Emails[0].Value != "alice@example.com" || !user.Emails[0].Primary {
+		t.Errorf("Unexpected emails: %+v", user.Emails)
+	}
+
+	if err := applyUserPatch(user, []PatchOperation{{Op: "remove", Path: "displayName"}}); err != nil {
+		t.Fatal(err)
+	}
+	if user.DisplayName != "" {
+		t.Error("Expected display name to be removed")
+	}
+	expectSCIMError(t, applyUserPatch(user, []PatchOperation{{Op: "remove"}}), http.StatusBadRequest)
+	expectSCIMError(t, applyUserPatch(user, []PatchOperation{{Op: "move", Path: "userName"}}), http.StatusBadRequest)
+
+	group := &Group{DisplayName: "devs", Members: []Member{{Value: "a"}, {Value: "b"}}}
+	err = applyGroupPatch(group, []PatchOperation{
+		{Op: "add", Path: "members", Value: []interface{}{map[string]interface{}{"value": "c"}}},
+		{Op: "remove", Path: `members[value eq "a"]`},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(group.Members) != 2 || !isMember(group.Members, "b") || !isMember(group.Members, "c") {
+		t.Errorf("Unexpected members: %+v", group.Members)
+	}
+	if err := applyGroupPatch(group, []PatchOperation{{Op: "remove", Path: "members"}}); err != nil {
+		t.Fatal(err)
+	}
+	if len(group.Members) != 0 {
+		t.Errorf("Expected all members to be removed, got %+v", group.Members)
+	}
+}
+
+func TestUsers(t *testing.T) {
+	m := newTestManager(t)
+
+	alice, err := m.CreateUser(&User{
+		UserName:   "alice",
+		ExternalID: "ext-alice",
+		Emails:     []Email{{Value: "alice@work.com"}, {Value: "alice@example.com", Primary: true}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if alice.ID == "" || !alice.IsActive() {
+		t.Fatalf("Unexpected user: %+v", alice)
+	}
+	obj := &rbacv1.VDIUser{}
+	if err := m.client.Get(context.TODO(), types.NamespacedName{Name: alice.ID}, obj); err != nil {
+		t.Fatal(err)
+	}
+	if obj.GetUsername() != "alice" || len(obj.Spec.Emails) != 2 || obj.Spec.Emails[0] != "alice@example.com" {
+		t.Errorf("Unexpected VDIUser spec: %+v", obj.Spec)
+	}
+
+	_, err = m.CreateUser(&User{UserName: "alice"})
+	expectSCIMError(t, err, http.StatusConflict)
+	_, err = m.CreateUser(&User{})
+	expectSCIMError(t, err, http.StatusBadRequest)
+
+	if _, err := m.CreateUser(&User{UserName: "bob"}); err != nil {
+		t.Fatal(err)
+	}
+	users, err := m.ListUsers(`externalId eq "ext-alice"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 1 || users[0].ID != alice.ID {
+		t.Errorf("Expected filter to only return alice, got %+v", users)
+	}
+	if users, err = m.ListUsers(""); err != nil {
+		t.Fatal(err)
+	} else if len(users) != 2 {
+		t.Errorf("Expected 2 users, got %d", len(users))
+	}
+
+	updated, err := m.PatchUser(alice.ID, &PatchRequest{Operations: []PatchOperation{
+		{Op: "replace", Path: "active", Value: false},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.IsActive() {
+		t.Error("Expected alice to be deactivated")
+	}
+	if err := m.client.Get(context.TODO(), types.NamespacedName{Name: alice.ID}, obj); err != nil {
+		t.Fatal(err)
+	}
+	if !obj.Spec.Disabled {
+		t.Error("Expected VDIUser to be disabled")
+	}
+
+	_, err = m.ReplaceUser(alice.ID, &User{UserName: "bob"})
+	expectSCIMError(t, err, http.StatusConflict)
+
+	if _, err := m.DeleteUser(alice.ID); err != nil {
+		t.Fatal(err)
+	}
+	_, err = m.GetUser(alice.ID)
+	expectSCIMError(t, err, http.StatusNotFound)
+}
+
+func TestLocalUsersAreInvisible(t *testing.T) {
+	m := newTestManager(t)
+
+	admin := &rbacv1.VDIUser{}
+	admin.Name = "test-cluster-admin"
+	admin.Labels = map[string]string{v1.RoleClusterRefLabel: "test-cluster"}
+	admin.Spec.Username = "admin"
+	admin.Spec.Roles = []string{"test-cluster-admin"}
+	if err := m.client.Create(context.TODO(), admin); err != nil {
+		t.Fatal(err)
+	}
+
+	users, err := m.ListUsers("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 0 {
+		t.Errorf("Expected local users not to be listed, got %+v", users)
+	}
+	_, err = m.GetUser(admin.Name)
+	expectSCIMError(t, err, http.StatusNotFound)
+	_, err = m.PatchUser(admin.Name, &PatchRequest{Operations: []PatchOperation{
+		{Op: "replace", Path: "active", Value: false},
+	}})
+	expectSCIMError(t, err, http.StatusNotFound)
+	_, err = m.ReplaceUser(admin.Name, &User{UserName: "admin"})
+	expectSCIMError(t, err, http.StatusNotFound)
+	_, err = m.DeleteUser(admin.Name)
+	expectSCIMError(t, err, http.StatusNotFound)
+
+	// local users cannot be added to groups, and their usernames cannot be taken
+	_, err = m.CreateGroup(&Group{DisplayName: "admins", Members: []Member{{Value: admin.Name}}})
+	expectSCIMError(t, err, http.StatusBadRequest)
+	_, err = m.CreateUser(&User{UserName: "admin"})
+	expectSCIMError(t, err, http.StatusConflict)
+
+	obj := &rbacv1.VDIUser{}
+	if err := m.client.Get(context.TODO(), types.NamespacedName{Name: admin.Name}, obj); err != nil {
+		t.Fatal(err)
+	}
+	if obj.Spec.Disabled || len(obj.Spec.Groups) != 0 {
+		t.Errorf("Expected the local user to be untouched, got %+v", obj.Spec)
+	}
+}
+
+func TestGroups(t *testing.T) {
+	m := newTestManager(t)
+
+	alice, err := m.CreateUser(&User{UserName: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, err := m.CreateUser(&User{UserName: "bob"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = m.CreateGroup(&Group{DisplayName: "devs", Members: []Member{{Value: "nobody"}}})
+	expectSCIMError(t, err, http.StatusBadRequest)
+
+	group, err := m.CreateGroup(&Group{DisplayName: "devs", Members: []Member{{Value: alice.ID}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(group.Members) != 1 || group.Members[0].Value != alice.ID {
+		t.Errorf("Unexpected members: %+v", group.Members)
+	}
+	_, err = m.CreateGroup(&Group{DisplayName: "devs"})
+	expectSCIMError(t, err, http.StatusConflict)
+
+	user, err := m.GetUser(alice.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(user.Groups) != 1 || user.Groups[0].Value != group.ID {
+		t.Errorf("Expected alice to be in devs, got %+v", user.Groups)
+	}
+
+	// Renaming the group and swapping its members updates the VDIUsers
+	group, err = m.PatchGroup(group.ID, &PatchRequest{Operations: []PatchOperation{
+		{Op: "replace", Path: "displayName", Value: "developers"},
+		{Op: "add", Path: "members", Value: []interface{}{map[string]interface{}{"value": bob.ID}}},
+		{Op: "remove", Path: `members[value eq "` + alice.ID + `"]`},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if group.DisplayName != "developers" || len(group.Members) != 1 || group.Members[0].Value != bob.ID {
+		t.Errorf("Unexpected group: %+v", group)
+	}
+	for id, expected := range map[string][]string{alice.ID: {}, bob.ID: {"developers"}} {
+		obj := &rbacv1.VDIUser{}
+		if err := m.client.Get(context.TODO(), types.NamespacedName{Name: id}, obj); err != nil {
+			t.Fatal(err)
+		}
+		if !stringSlicesEqual(obj.Spec.Groups, expected) {
+			t.Errorf("Expected %s to have groups %v, got %v", id, expected, obj.Spec.Groups)
+		}
+	}
+
+	groups, err := m.ListGroups(`displayName eq "Developers"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(groups) != 1 || groups[0].ID != group.ID {
+		t.Errorf("Expected filter to return the group, got %+v", groups)
+	}
+
+	if err := m.DeleteGroup(group.ID); err != nil {
+		t.Fatal(err)
+	}
+	_, err = m.GetGroup(group.ID)
+	expectSCIMError(t, err, http.StatusNotFound)
+	obj := &rbacv1.VDIUser{}
+	if err := m.client.Get(context.TODO(), types.NamespacedName{Name: bob.ID}, obj); err != nil {
+		t.Fatal(err)
+	}
+	if len(obj.Spec.Groups) != 0 {
+		t.Errorf("Expected bob to be removed from the deleted group, got %v", obj.Spec.Groups)
+	}
+}
@@ -0,0 +1,244 @@
+/*
+
+Copyright 2020,2021 Avi Zimmerman
+
+This file is part of kvdi.
+
+kvdi is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+kvdi is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package scim
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Schema URNs used in requests and responses
+const (
+	UserSchema                  = "urn:ietf:params:scim:schemas:core:2.0:User"
+	GroupSchema                 = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	ServiceProviderConfigSchema = "urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"
+	ListResponseSchema          = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	PatchOpSchema               = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	ErrorSchema                 = "urn:ietf:params:scim:api:messages:2.0:Error"
+)
+
+// ContentType is the media type of SCIM requests and responses.
+const ContentType = "application/scim+json"
+
+// Meta contains the metadata of a resource.
+type Meta struct {
+	// The type of the resource
+	ResourceType string `json:"resourceType"`
+	// When the resource was created
+	Created *time.Time `json:"created,omitempty"`
+	// When the resource was last changed
+	LastModified *time.Time `json:"lastModified,omitempty"`
+}
+
+// User is a SCIM user resource. Only the attributes kVDI has a use for are kept,
+// others are accepted and dropped.
+type User struct {
+	Schemas     []string `json:"schemas"`
+	ID          string   `json:"id,omitempty"`
+	ExternalID  string   `json:"externalId,omitempty"`
+	UserName    string   `json:"userName"`
+	DisplayName string   `json:"displayName,omitempty"`
+	Emails      []Email  `json:"emails,omitempty"`
+	Active      *bool    `json:"active,omitempty"`
+	// The groups the user belongs to. This is read-only, membership is managed
+	// through the groups.
+	Groups []Member `json:"groups,omitempty"`
+	Meta   *Meta    `json:"meta,omitempty"`
+}
+
+// IsActive returns false if the user was deactivated.
+func (u *User) IsActive() bool { return u.Active == nil || *u.Active }
+
+// Email is an email address of a user.
+type Email struct {
+	Value   string `json:"value"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+}
+
+// Group is a SCIM group resource.
+type Group struct {
+	Schemas     []string `json:"schemas"`
+	ID          string   `json:"id,omitempty"`
+	ExternalID  string   `json:"externalId,omitempty"`
+	DisplayName string   `json:"displayName"`
+	Members     []Member `json:"members,omitempty"`
+	Meta        *Meta    `json:"meta,omitempty"`
+}
+
+// Member references a user in a group, or a group of a user.
+type Member struct {
+	Value   string `json:"value"`
+	Display string `json:"display,omitempty"`
+}
+
+// ListResponse is the response to a query for resources.
+type ListResponse struct {
+	Schemas      []string    `json:"schemas"`
+	TotalResults int         `json:"totalResults"`
+	StartIndex   int         `json:"startIndex"`
+	ItemsPerPage int         `json:"itemsPerPage"`
+	Resources    interface{} `json:"Resources"`
+}
+
+// Page returns the bounds of the page of a query with the given 1-based start index
+// and count out of the given number of results. A negative count returns as many
+// results as allowed.
+func Page(total, startIndex, count int) (start, end int) {
+	if startIndex < 1 {
+		startIndex = 1
+	}
+	if count < 0 || count > maxResults {
+		count = maxResults
+	}
+	start = startIndex - 1
+	if start > total {
+		start = total
+	}
+	end = start + count
+	if end > total {
+		end = total
+	}
+	return start, end
+}
+
+// PatchRequest is a request to modify a resource.
+type PatchRequest struct {
+	Schemas    []string         `json:"schemas"`
+	Operations []PatchOperation `json:"Operations"`
+}
+
+// PatchOperation is a single modification in a PatchRequest.
+type PatchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path,omitempty"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+// Error is a SCIM error response. It also implements the error interface so it can
+// be returned from the manager and written as is.
+type Error struct {
+	Schemas  []string `json:"schemas"`
+	Status   string   `json:"status"`
+	ScimType string   `json:"scimType,omitempty"`
+	Detail   string   `json:"detail,omitempty"`
+}
+
+// Error implements the error interface.
+func (e *Error) Error() string { return e.Detail }
+
+// StatusCode returns the HTTP status code of the error.
+func (e *Error) StatusCode() int {
+	code, err := strconv.Atoi(e.Status)
+	if err != nil {
+		return http.StatusInternalServerError
+	}
+	return code
+}
+
+// NewError returns a new error with the given status, SCIM error type and detail.
+func NewError(status int, scimType, detail string) *Error {
+	return &Error{
+		Schemas:  []string{ErrorSchema},
+		Status:   strconv.Itoa(status),
+		ScimType: scimType,
+		Detail:   detail,
+	}
+}
+
+// ToError converts the given error to a SCIM error. Errors that are not already
+// SCIM errors are internal server errors.
+func ToError(err error) *Error {
+	if scimErr, ok := err.(*Error); ok {
+		return scimErr
+	}
+	return NewError(http.StatusInternalServerError, "", err.Error())
+}
+
+func newNotFoundError(resourceType, id string) *Error {
+	return NewError(http.StatusNotFound, "", resourceType+" "+id+" not found")
+}
+
+func newInvalidValueError(detail string) *Error {
+	return NewError(http.StatusBadRequest, "invalidValue", detail)
+}
+
+func newUniquenessError(detail string) *Error {
+	return NewError(http.StatusConflict, "uniqueness", detail)
+}
+
+// ServiceProviderConfig describes the SCIM features supported by kVDI.
+type ServiceProviderConfig struct {
+	Schemas               []string               `json:"schemas"`
+	Patch                 Supported              `json:"patch"`
+	Bulk                  BulkSupport            `json:"bulk"`
+	Filter                FilterSupport          `json:"filter"`
+	ChangePassword        Supported              `json:"changePassword"`
+	Sort                  Supported              `json:"sort"`
+	ETag                  Supported              `json:"etag"`
+	AuthenticationSchemes []AuthenticationScheme `json:"authenticationSchemes"`
+}
+
+// Supported describes whether a feature is supported.
+type Supported struct {
+	Supported bool `json:"supported"`
+}
+
+// BulkSupport describes the support for bulk operations.
+type BulkSupport struct {
+	Supported      bool `json:"supported"`
+	MaxOperations  int  `json:"maxOperations"`
+	MaxPayloadSize int  `json:"maxPayloadSize"`
+}
+
+// FilterSupport describes the support for filtering queries.
+type FilterSupport struct {
+	Supported  bool `json:"supported"`
+	MaxResults int  `json:"maxResults"`
+}
+
+// AuthenticationScheme describes a way clients can authenticate.
+type AuthenticationScheme struct {
+	Type        string `json:"type"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// maxResults is the most resources returned for a single query.
+const maxResults = 1000
+
+// GetServiceProviderConfig returns the SCIM features supported by kVDI.
+func GetServiceProviderConfig() *ServiceProviderConfig {
+	return &ServiceProviderConfig{
+		Schemas: []string{ServiceProviderConfigSchema},
+		Patch:   Supported{Supported: true},
+		Filter:  FilterSupport{Supported: true, MaxResults: maxResults},
+		AuthenticationSchemes: []AuthenticationScheme{
+			{
+				Type:        "oauthbearertoken",
+				Name:        "Bearer Token",
+				Description: "The token stored under scimToken in the kVDI secrets backend",
+			},
+		},
+	}
+}
@@ -0,0 +1,227 @@
+/*
+
+Copyright 2020,2021 Avi Zimmerman
+
+This file is part of kvdi.
+
+kvdi is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+kvdi is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package scim
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	v1 "github.com/kvdi/kvdi/apis/meta/v1"
+	rbacv1 "github.com/kvdi/kvdi/apis/rbac/v1"
+)
+
+// ListUsers returns the users matching the given filter, ordered by their userName.
+func (m *Manager) ListUsers(filter string) ([]*User, error) {
+	f, err := ParseFilter(filter, "id", "userName", "externalId")
+	if err != nil {
+		return nil, err
+	}
+	objs, err := m.cluster.GetSCIMUsers(m.client)
+	if err != nil {
+		return nil, err
+	}
+	groups, err := m.readGroups()
+	if err != nil {
+		return nil, err
+	}
+	out := make([]*User, 0)
+	for _, obj := range objs {
+		if f.Matches(map[string]string{"id": obj.GetName(), "userName": obj.GetUsername(), "externalId": obj.Spec.ExternalID}) {
+			out = append(out, userFromObject(obj, groups))
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].UserName < out[j].UserName })
+	return out, nil
+}
+
+// GetUser returns the user with the given ID.
+func (m *Manager) GetUser(id string) (*User, error) {
+	obj, err := m.getUserObject(id)
+	if err != nil {
+		return nil, err
+	}
+	groups, err := m.readGroups()
+	if err != nil {
+		return nil, err
+	}
+	return userFromObject(obj, groups), nil
+}
+
+// CreateUser creates a VDIUser for the given user and returns it. The user cannot log
+// in with a password until one is set by an administrator.
+func (m *Manager) CreateUser(user *User) (*User, error) {
+	if err := m.checkUserName(user.UserName, ""); err != nil {
+		return nil, err
+	}
+	obj := &rbacv1.VDIUser{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: m.cluster.GetLocalUserObjectName(user.UserName),
+			Labels: map[string]string{
+				v1.RoleClusterRefLabel:  m.cluster.GetName(),
+				v1.SCIMProvisionedLabel: "true",
+			},
+		},
+	}
+	applyUser(obj, user)
+	if err := m.client.Create(context.TODO(), obj); err != nil {
+		if apierrors.IsAlreadyExists(err) {
+			return nil, newUniquenessError(fmt.Sprintf("User %s already exists", user.UserName))
+		}
+		return nil, err
+	}
+	return userFromObject(obj, nil), nil
+}
+
+// ReplaceUser replaces the attributes of the user with the given ID and returns it.
+func (m *Manager) ReplaceUser(id string, user *User) (*User, error) {
+	obj, err := m.getUserObject(id)
+	if err != nil {
+		return nil, err
+	}
+	return m.updateUser(obj, user)
+}
+
+// PatchUser applies the given patch to the user with the given ID and returns it.
+func (m *Manager) PatchUser(id string, req *PatchRequest) (*User, error) {
+	obj, err := m.getUserObject(id)
+	if err != nil {
+		return nil, err
+	}
+	user := userFromObject(obj, nil)
+	if err := applyUserPatch(user, req.Operations); err != nil {
+		return nil, err
+	}
+	return m.updateUser(obj, user)
+}
+
+// DeleteUser removes the user with the given ID and returns it as it was before it
+// was deleted.
+func (m *Manager) DeleteUser(id string) (*User, error) {
+	obj, err := m.getUserObject(id)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.client.Delete(context.TODO(), obj); err != nil {
+		return nil, err
+	}
+	return userFromObject(obj, nil), nil
+}
+
+func (m *Manager) updateUser(obj *rbacv1.VDIUser, user *User) (*User, error) {
+	if err := m.checkUserName(user.UserName, obj.GetName()); err != nil {
+		return nil, err
+	}
+	applyUser(obj, user)
+	if err := m.client.Update(context.TODO(), obj); err != nil {
+		return nil, err
+	}
+	groups, err := m.readGroups()
+	if err != nil {
+		return nil, err
+	}
+	return userFromObject(obj, groups), nil
+}
+
+// checkUserName returns an error if the given userName is empty or taken by a user
+// other than the one with the given ID. Users that were not provisioned over SCIM are
+// checked too, since they share the same usernames.
+func (m *Manager) checkUserName(userName, id string) error {
+	if userName == "" {
+		return newInvalidValueError("userName is required")
+	}
+	objs, err := m.cluster.GetLocalUsers(m.client)
+	if err != nil {
+		return err
+	}
+	for _, obj := range objs {
+		if obj.GetUsername() == userName && obj.GetName() != id {
+			return newUniquenessError(fmt.Sprintf("User %s already exists", userName))
+		}
+	}
+	return nil
+}
+
+// getUserObject returns the VDIUser of this cluster with the given name. Users that were
+// not provisioned over SCIM are not found.
+func (m *Manager) getUserObject(id string) (*rbacv1.VDIUser, error) {
+	obj := &rbacv1.VDIUser{}
+	if err := m.client.Get(context.TODO(), types.NamespacedName{Name: id}, obj); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, newNotFoundError("User", id)
+		}
+		return nil, err
+	}
+	if obj.GetLabels()[v1.RoleClusterRefLabel] != m.cluster.GetName() || obj.GetLabels()[v1.SCIMProvisionedLabel] != "true" {
+		return nil, newNotFoundError("User", id)
+	}
+	return obj, nil
+}
+
+// applyUser copies the attributes of the given user to the VDIUser. The primary email
+// address is stored first.
+func applyUser(obj *rbacv1.VDIUser, user *User) {
+	obj.Spec.Username = user.UserName
+	obj.Spec.ExternalID = user.ExternalID
+	obj.Spec.DisplayName = user.DisplayName
+	obj.Spec.Disabled = !user.IsActive()
+	obj.Spec.Emails = nil
+	for _, email := range user.Emails {
+		if email.Primary {
+			obj.Spec.Emails = append([]string{email.Value}, obj.Spec.Emails...)
+		} else {
+			obj.Spec.Emails = append(obj.Spec.Emails, email.Value)
+		}
+	}
+}
+
+// userFromObject converts the given VDIUser to a SCIM user. Only the groups that were
+// provisioned over SCIM are listed.
+func userFromObject(obj *rbacv1.VDIUser, groups map[string]*groupRecord) *User {
+	active := !obj.Spec.Disabled
+	user := &User{
+		Schemas:     []string{UserSchema},
+		ID:          obj.GetName(),
+		ExternalID:  obj.Spec.ExternalID,
+		UserName:    obj.GetUsername(),
+		DisplayName: obj.Spec.DisplayName,
+		Active:      &active,
+		Meta:        &Meta{ResourceType: "User"},
+	}
+	if created := obj.GetCreationTimestamp(); !created.IsZero() {
+		t := created.Time.UTC()
+		user.Meta.Created = &t
+	}
+	for i, email := range obj.Spec.Emails {
+		user.Emails = append(user.Emails, Email{Value: email, Primary: i == 0})
+	}
+	for _, name := range obj.Spec.Groups {
+		if group := findGroupByName(groups, name); group != nil {
+			user.Groups = append(user.Groups, Member{Value: group.ID, Display: group.DisplayName})
+		}
+	}
+	return user
+}
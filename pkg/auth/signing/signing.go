@@ -0,0 +1,357 @@
+/*
+
+Copyright 2020,2021 Avi Zimmerman
+
+This file is part of kvdi.
+
+kvdi is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+kvdi is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package signing
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-jose/go-jose/v3"
+	"github.com/golang-jwt/jwt"
+
+	appv1 "github.com/kvdi/kvdi/apis/app/v1"
+	v1 "github.com/kvdi/kvdi/apis/meta/v1"
+	"github.com/kvdi/kvdi/pkg/secrets"
+	"github.com/kvdi/kvdi/pkg/util/errors"
+)
+
+// rsaKeySize is the size of generated RSA keys.
+const rsaKeySize = 2048
+
+// Unknown key IDs are remembered for unknownKeyTTL so that tokens with made up key IDs
+// do not read the secrets backend on every request. At most maxUnknownKeys are kept.
+var (
+	unknownKeyTTL  = time.Minute
+	maxUnknownKeys = 1000
+)
+
+// Key is a key used to sign access tokens.
+type Key struct {
+	// The ID of the key, set in the kid header of the tokens it signs
+	ID string `json:"id"`
+	// The algorithm the key signs tokens with
+	Algorithm appv1.TokenSigningAlgorithm `json:"algorithm"`
+	// The PKCS #8 encoded private key
+	PrivateKey []byte `json:"privateKey"`
+	// When the key was generated
+	CreatedAt time.Time `json:"createdAt"`
+	// When the key stops being accepted. It is only set once the key was replaced.
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+}
+
+// NewKey generates a new key for the given algorithm. The ID of the key is its
+// RFC 7638 thumbprint.
+func NewKey(alg appv1.TokenSigningAlgorithm) (*Key, error) {
+	var priv crypto.Signer
+	var err error
+	switch alg {
+	case appv1.TokenSigningRS256:
+		priv, err = rsa.GenerateKey(rand.Reader, rsaKeySize)
+	case appv1.TokenSigningEdDSA:
+		_, priv, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return nil, fmt.Errorf("Unsupported token signing algorithm: %s", alg)
+	}
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		return nil, err
+	}
+	thumbprint, err := (&jose.JSONWebKey{Key: priv.Public()}).Thumbprint(crypto.SHA256)
+	if err != nil {
+		return nil, err
+	}
+	return &Key{
+		ID:         base64.RawURLEncoding.EncodeToString(thumbprint),
+		Algorithm:  alg,
+		PrivateKey: der,
+		CreatedAt:  time.Now().UTC(),
+	}, nil
+}
+
+// SigningMethod returns the JWT signing method for the key.
+func (k *Key) SigningMethod() jwt.SigningMethod {
+	return jwt.GetSigningMethod(string(k.Algorithm))
+}
+
+// Signer returns the private key.
+func (k *Key) Signer() (crypto.Signer, error) {
+	priv, err := x509.ParsePKCS8PrivateKey(k.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := priv.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("Signing key %s is not a valid private key", k.ID)
+	}
+	return signer, nil
+}
+
+// PublicKey returns the public key for verifying tokens signed with the key.
+func (k *Key) PublicKey() (crypto.PublicKey, error) {
+	signer, err := k.Signer()
+	if err != nil {
+		return nil, err
+	}
+	return signer.Public(), nil
+}
+
+// JSONWebKey returns the public key in JWK format.
+func (k *Key) JSONWebKey() (jose.JSONWebKey, error) {
+	pub, err := k.PublicKey()
+	if err != nil {
+		return jose.JSONWebKey{}, err
+	}
+	return jose.JSONWebKey{
+		Key:       pub,
+		KeyID:     k.ID,
+		Algorithm: string(k.Algorithm),
+		Use:       "sig",
+	}, nil
+}
+
+// IsExpired returns true if the key is no longer accepted.
+func (k *Key) IsExpired() bool {
+	return k.ExpiresAt != nil && !time.Now().Before(*k.ExpiresAt)
+}
+
+// Manager is an object for managing the keys used to sign access tokens. It uses
+// the configured secrets backend for storage.
+type Manager struct {
+	secrets *secrets.SecretEngine
+
+	mu      sync.Mutex
+	unknown map[string]time.Time
+}
+
+// NewManager returns a new signing key manager with the given secrets engine.
+func NewManager(secrets *secrets.SecretEngine) *Manager {
+	return &Manager{secrets: secrets, unknown: make(map[string]time.Time)}
+}
+
+// SigningKey returns the key to sign new tokens with. A new key is generated when
+// there is none for the given algorithm or the current one is older than the given
+// interval. Replaced keys are still accepted for the given overlap. The interval must
+// be positive.
+func (m *Manager) SigningKey(alg appv1.TokenSigningAlgorithm, interval, overlap time.Duration) (*Key, error) {
+	if interval <= 0 {
+		return nil, fmt.Errorf("Invalid token signing key rotation interval: %s", interval)
+	}
+	keys, err := m.readKeys(true)
+	if err != nil {
+		return nil, err
+	}
+	if key := currentKey(keys, alg, interval); key != nil {
+		return key, nil
+	}
+	if err := m.secrets.Lock(10); err != nil {
+		return nil, err
+	}
+	defer m.secrets.Release()
+	// Another replica may have rotated the key already
+	keys, err = m.readKeys(false)
+	if err != nil {
+		return nil, err
+	}
+	if key := currentKey(keys, alg, interval); key != nil {
+		return key, nil
+	}
+	return m.rotate(keys, alg, overlap)
+}
+
+// Rotate replaces the current signing key with a new one for the given algorithm.
+// The replaced key is still accepted for the given overlap.
+func (m *Manager) Rotate(alg appv1.TokenSigningAlgorithm, overlap time.Duration) (*Key, error) {
+	if err := m.secrets.Lock(10); err != nil {
+		return nil, err
+	}
+	defer m.secrets.Release()
+	keys, err := m.readKeys(false)
+	if err != nil {
+		return nil, err
+	}
+	return m.rotate(keys, alg, overlap)
+}
+
+// VerificationKey returns the key with the given ID for verifying a token. An error
+// is returned if the key does not exist or has expired.
+func (m *Manager) VerificationKey(id string) (*Key, error) {
+	keys, err := m.readKeys(true)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := keys[id]
+	if !ok {
+		if m.isUnknown(id) {
+			return nil, fmt.Errorf("Unknown signing key: %s", id)
+		}
+		// The key may have been generated by another replica since it was cached
+		keys, err = m.readKeys(false)
+		if err != nil {
+			return nil, err
+		}
+		if key, ok = keys[id]; !ok {
+			m.addUnknown(id)
+			return nil, fmt.Errorf("Unknown signing key: %s", id)
+		}
+	}
+	if key.IsExpired() {
+		return nil, fmt.Errorf("Signing key %s has expired", id)
+	}
+	return key, nil
+}
+
+// JWKS returns the public keys of all keys that are still accepted, newest first.
+func (m *Manager) JWKS() (*jose.JSONWebKeySet, error) {
+	keys, err := m.readKeys(true)
+	if err != nil {
+		return nil, err
+	}
+	out := &jose.JSONWebKeySet{Keys: make([]jose.JSONWebKey, 0)}
+	for _, key := range sortedKeys(keys) {
+		if key.IsExpired() {
+			continue
+		}
+		jwk, err := key.JSONWebKey()
+		if err != nil {
+			return nil, err
+		}
+		out.Keys = append(out.Keys, jwk)
+	}
+	return out, nil
+}
+
+// isUnknown returns true if the given key ID was recently not found in the secrets
+// backend.
+func (m *Manager) isUnknown(id string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	seen, ok := m.unknown[id]
+	return ok && time.Since(seen) < unknownKeyTTL
+}
+
+// addUnknown remembers that the given key ID was not found in the secrets backend. It
+// forgets the oldest entries when there are too many.
+func (m *Manager) addUnknown(id string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for k, seen := range m.unknown {
+		if time.Since(seen) >= unknownKeyTTL {
+			delete(m.unknown, k)
+		}
+	}
+	if len(m.unknown) >= maxUnknownKeys {
+		m.unknown = make(map[string]time.Time)
+	}
+	m.unknown[id] = time.Now()
+}
+
+// rotate generates a new signing key, starts the overlap for the keys it replaces and
+// forgets the ones that have expired. The caller must hold the secrets lock.
+func (m *Manager) rotate(keys map[string]*Key, alg appv1.TokenSigningAlgorithm, overlap time.Duration) (*Key, error) {
+	newKey, err := NewKey(alg)
+	if err != nil {
+		return nil, err
+	}
+	expiresAt := newKey.CreatedAt.Add(overlap)
+	for id, key := range keys {
+		if key.IsExpired() {
+			delete(keys, id)
+			continue
+		}
+		if key.ExpiresAt == nil {
+			key.ExpiresAt = &expiresAt
+		}
+	}
+	keys[newKey.ID] = newKey
+	return newKey, m.writeKeys(keys)
+}
+
+// currentKey returns the newest key that has not been replaced if it uses the given
+// algorithm and is younger than the given interval.
+func currentKey(keys map[string]*Key, alg appv1.TokenSigningAlgorithm, interval time.Duration) *Key {
+	for _, key := range sortedKeys(keys) {
+		if key.ExpiresAt != nil {
+			continue
+		}
+		if key.Algorithm == alg && time.Since(key.CreatedAt) < interval {
+			return key
+		}
+		return nil
+	}
+	return nil
+}
+
+// sortedKeys returns the given keys newest first.
+func sortedKeys(keys map[string]*Key) []*Key {
+	out := make([]*Key, 0, len(keys))
+	for _, key := range keys {
+		out = append(out, key)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].CreatedAt.After(out[j].CreatedAt)
+	})
+	return out
+}
+
+// readKeys reads all signing keys from the secrets backend.
+func (m *Manager) readKeys(cache bool) (map[string]*Key, error) {
+	data, err := m.secrets.ReadSecretMap(v1.JWTSigningKeysSecretKey, cache)
+	if err != nil {
+		if errors.IsSecretNotFoundError(err) {
+			return map[string]*Key{}, nil
+		}
+		return nil, err
+	}
+	keys := make(map[string]*Key, len(data))
+	for id, raw := range data {
+		key := &Key{}
+		if err := json.Unmarshal(raw, key); err != nil {
+			return nil, err
+		}
+		keys[id] = key
+	}
+	return keys, nil
+}
+
+func (m *Manager) writeKeys(keys map[string]*Key) error {
+	data := make(map[string][]byte, len(keys))
+	for id, key := range keys {
+		raw, err := json.Marshal(key)
+		if err != nil {
+			return err
+		}
+		data[id] = raw
+	}
+	return m.secrets.WriteSecretMap(v1.JWTSigningKeysSecretKey, data)
+}
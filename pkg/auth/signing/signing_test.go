@@ -0,0 +1,165 @@
+/*
+
+Copyright 2020,2021 Avi Zimmerman
+
+This file is part of kvdi.
+
+kvdi is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+kvdi is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package signing
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	appv1 "github.com/kvdi/kvdi/apis/app/v1"
+	"github.com/kvdi/kvdi/pkg/secrets"
+)
+
+func newTestManager(t *testing.T) *Manager {
+	t.Helper()
+	engine, _, err := secrets.NewTestEngine(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewManager(engine)
+}
+
+func TestNewKey(t *testing.T) {
+	for _, alg := range []appv1.TokenSigningAlgorithm{appv1.TokenSigningRS256, appv1.TokenSigningEdDSA} {
+		key, err := NewKey(alg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if key.ID == "" || key.SigningMethod() == nil || key.SigningMethod().Alg() != string(alg) {
+			t.Error("Expected a key for", alg, "got:", key)
+		}
+		jwk, err := key.JSONWebKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !jwk.IsPublic() || jwk.KeyID != key.ID || jwk.Algorithm != string(alg) {
+			t.Error("Expected the public key in the JWK, got:", jwk)
+		}
+	}
+	if _, err := NewKey("HS256"); err == nil {
+		t.Error("Expected error generating a key for an unsupported algorithm")
+	}
+}
+
+func TestSigningKeyRotation(t *testing.T) {
+	m := newTestManager(t)
+	interval, overlap := time.Hour, time.Minute
+
+	key, err := m.SigningKey(appv1.TokenSigningRS256, interval, overlap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, err := m.SigningKey(appv1.TokenSigningRS256, interval, overlap); err != nil || again.ID != key.ID {
+		t.Error("Expected the same key until the interval passed, got:", again, err)
+	}
+
+	// changing the algorithm rotates the key and the old one overlaps
+	edKey, err := m.SigningKey(appv1.TokenSigningEdDSA, interval, overlap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if edKey.ID == key.ID || edKey.Algorithm != appv1.TokenSigningEdDSA {
+		t.Fatal("Expected a new EdDSA key, got:", edKey)
+	}
+	old, err := m.VerificationKey(key.ID)
+	if err != nil {
+		t.Fatal("Expected the replaced key to still be accepted, got:", err)
+	}
+	if old.ExpiresAt == nil || old.ExpiresAt.Sub(edKey.CreatedAt) != overlap {
+		t.Error("Expected the replaced key to expire after the overlap, got:", old.ExpiresAt)
+	}
+	jwks, err := m.JWKS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jwks.Keys) != 2 || jwks.Keys[0].KeyID != edKey.ID {
+		t.Error("Expected both keys to be published newest first, got:", jwks.Keys)
+	}
+
+	// an invalid interval is rejected
+	if _, err := m.SigningKey(appv1.TokenSigningEdDSA, 0, 0); err == nil {
+		t.Error("Expected error using an interval that is not positive")
+	}
+
+	// an expired interval rotates the key
+	newKey, err := m.SigningKey(appv1.TokenSigningEdDSA, time.Nanosecond, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if newKey.ID == edKey.ID {
+		t.Fatal("Expected a new key after the interval passed")
+	}
+	if _, err := m.VerificationKey(edKey.ID); err == nil {
+		t.Error("Expected error using a key past its overlap")
+	}
+	if _, err := m.VerificationKey(key.ID); err != nil {
+		t.Error("Expected a key within its overlap to still be accepted, got:", err)
+	}
+	if _, err := m.VerificationKey("unknown"); err == nil {
+		t.Error("Expected error using an unknown key")
+	}
+
+	// expired keys are forgotten on the next rotation
+	if _, err := m.Rotate(appv1.TokenSigningEdDSA, overlap); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := m.readKeys(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := keys[edKey.ID]; ok {
+		t.Error("Expected the expired key to be forgotten")
+	}
+	if _, ok := keys[key.ID]; !ok || len(keys) != 3 {
+		t.Error("Expected the keys within their overlap to be kept, got:", keys)
+	}
+}
+
+func TestUnknownKeys(t *testing.T) {
+	m := newTestManager(t)
+	other := NewManager(m.secrets)
+
+	if _, err := m.VerificationKey("unknown"); err == nil {
+		t.Fatal("Expected error using an unknown key")
+	}
+	if !m.isUnknown("unknown") {
+		t.Fatal("Expected the unknown key to be remembered")
+	}
+
+	// Keys generated since are still found
+	key, err := other.Rotate(appv1.TokenSigningEdDSA, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.VerificationKey(key.ID); err != nil {
+		t.Error("Expected a new key to be found, got:", err)
+	}
+
+	// The number of remembered keys is bounded
+	for i := 0; i <= maxUnknownKeys; i++ {
+		m.addUnknown(fmt.Sprintf("unknown-%d", i))
+	}
+	if len(m.unknown) > maxUnknownKeys {
+		t.Error("Expected at most", maxUnknownKeys, "unknown keys, got:", len(m.unknown))
+	}
+}
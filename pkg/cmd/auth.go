@@ -0,0 +1,134 @@
+/*
+
+Copyright 2020,2021 Avi Zimmerman
+
+This file is part of kvdi.
+
+kvdi is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+kvdi is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	rbacv1 "github.com/kvdi/kvdi/apis/rbac/v1"
+	"github.com/kvdi/kvdi/pkg/types"
+)
+
+var (
+	canINamespace string
+	canIUser      string
+	canISourceIP  string
+	canIAt        string
+	canIExplain   bool
+)
+
+func init() {
+	canIFlags := authCanICmd.Flags()
+	canIFlags.StringVarP(&canINamespace, "namespace", "n", "", "the namespace of the resource")
+	canIFlags.StringVar(&canIUser, "for", "", "the user to check, defaults to the current user")
+	canIFlags.StringVar(&canISourceIP, "source-ip", "", "the client address to evaluate rule conditions against, defaults to your own")
+	canIFlags.StringVar(&canIAt, "at", "", "the time to evaluate rule conditions at in RFC3339 format, defaults to now")
+	canIFlags.BoolVar(&canIExplain, "explain", false, "dump the full review including the matched and missing rules")
+
+	authCanICmd.RegisterFlagCompletionFunc("for", completeUsers)
+
+	authCmd.AddCommand(authCanICmd)
+
+	rootCmd.AddCommand(authCmd)
+}
+
+var authCmd = &cobra.Command{
+	Use:   "auth",
+	Short: "Authorization commands",
+}
+
+var authCanICmd = &cobra.Command{
+	Use:   "can-i VERB RESOURCE [NAME]",
+	Short: "Check whether an action is allowed",
+	Long: `Check whether an action is allowed for the current user, or another user with --for.
+
+Prints "yes" or "no" along with the reason and exits non-zero when the action is not allowed.
+Use --explain to dump the rules that matched the action, or the rule that would allow it.`,
+	Example: `  kvdictl auth can-i launch templates ubuntu-xfce -n default
+  kvdictl auth can-i read roles --for jdoe --explain`,
+	Args:    cobra.RangeArgs(2, 3),
+	PreRunE: checkClientInitErr,
+	ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		switch len(args) {
+		case 0:
+			return completeVerbs(cmd, args, toComplete)
+		case 1:
+			return completeResources(cmd, args, toComplete)
+		}
+		return []string{}, cobra.ShellCompDirectiveNoFileComp
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		action := types.APIAction{
+			Verb:              rbacv1.Verb(args[0]),
+			ResourceType:      rbacv1.Resource(args[1]),
+			ResourceNamespace: canINamespace,
+		}
+		if len(args) == 3 {
+			action.ResourceName = args[2]
+		}
+		resp, err := kvdiClient.ReviewAccess(&types.AccessReviewRequest{
+			User:     canIUser,
+			Actions:  []types.APIAction{action},
+			Time:     canIAt,
+			SourceIP: canISourceIP,
+		})
+		if err != nil {
+			return err
+		}
+		if len(resp.Results) != 1 {
+			return fmt.Errorf("expected one result in the review, got %d", len(resp.Results))
+		}
+		result := resp.Results[0]
+		if canIExplain {
+			if err := writeObject(result); err != nil {
+				return err
+			}
+		} else {
+			printReviewResult(result)
+		}
+		if !result.Allowed {
+			os.Exit(1)
+		}
+		return nil
+	},
+}
+
+func printReviewResult(result *types.AccessReviewResult) {
+	if result.Allowed {
+		fmt.Println("yes")
+	} else {
+		fmt.Printf("no - %s\n", result.Reason)
+	}
+	for _, match := range result.MatchedRules {
+		if !match.Applied {
+			continue
+		}
+		verb := "allowed"
+		if match.Rule.IsDeny() {
+			verb = "denied"
+		}
+		fmt.Printf("  %s by rule %d in role %s\n", verb, match.Index, match.Role)
+	}
+}
@@ -0,0 +1,92 @@
+/*
+
+Copyright 2020,2021 Avi Zimmerman
+
+This file is part of kvdi.
+
+kvdi is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+kvdi is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+var loginsDeleteAll bool
+
+func init() {
+	loginsDeleteCmd.Flags().BoolVar(&loginsDeleteAll, "all", false, "revoke all of the user's logins")
+
+	loginsCmd.AddCommand(loginsGetCmd)
+	loginsCmd.AddCommand(loginsDeleteCmd)
+
+	usersCmd.AddCommand(loginsCmd)
+}
+
+var loginsCmd = &cobra.Command{
+	Use:     "logins",
+	Aliases: []string{"login"},
+	Short:   "Active login commands",
+}
+
+var loginsGetCmd = &cobra.Command{
+	Use:               "get [USER]",
+	Short:             "Retrieve the active logins for a user, defaults to the current user",
+	Args:              cobra.MaximumNArgs(1),
+	PreRunE:           checkClientInitErr,
+	ValidArgsFunction: completeUsers,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		user, err := tokenUser(args)
+		if err != nil {
+			return err
+		}
+		logins, err := kvdiClient.GetLogins(user)
+		if err != nil {
+			return err
+		}
+		return writeObject(logins)
+	},
+}
+
+var loginsDeleteCmd = &cobra.Command{
+	Use:     "delete USER [IDS...]",
+	Aliases: []string{"del", "remove", "rem", "rm", "revoke"},
+	Short:   "Revoke logins for a user",
+	Long:    "Revoke logins for a user. Their refresh tokens can no longer be used and their access tokens are rejected immediately.",
+	Args:    cobra.MinimumNArgs(1),
+	PreRunE: checkClientInitErr,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if loginsDeleteAll {
+			if err := kvdiClient.DeleteLogins(args[0]); err != nil {
+				return err
+			}
+			fmt.Printf("All logins revoked successfully for %q\n", args[0])
+			return nil
+		}
+		if len(args) < 2 {
+			return fmt.Errorf("provide the IDs of the logins to revoke, or --all")
+		}
+		for _, id := range args[1:] {
+			if err := kvdiClient.DeleteLogin(args[0], id); err != nil {
+				return err
+			}
+			fmt.Printf("Login %q revoked successfully\n", id)
+		}
+		return nil
+	},
+}
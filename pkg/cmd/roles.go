@@ -34,6 +34,7 @@ import (
 var (
 	createRoleOpts       types.CreateRoleRequest
 	updateRoleName       string
+	ruleEffect           string
 	ruleVerbs            []string
 	ruleResources        []string
 	ruleResourcePatterns []string
@@ -79,17 +80,20 @@ func addUpdateRoleFlags(cmd *cobra.Command) {
 func addRuleFlags(cmd *cobra.Command) {
 	flagSet := cmd.Flags()
 
+	flagSet.StringVar(&ruleEffect, "effect", "", "whether the rule allows or denies the actions it matches (Allow or Deny)")
 	flagSet.StringSliceVar(&ruleVerbs, "verbs", []string{}, "verbs for the rule")
 	flagSet.StringSliceVar(&ruleResources, "resources", []string{}, "resources for a rule")
 	flagSet.StringSliceVar(&ruleResourcePatterns, "resource-patterns", []string{}, "resource patterns for the rule")
 	flagSet.StringSliceVar(&ruleNamespaces, "namespaces", []string{}, "namespaces for the rule")
 
+	cmd.RegisterFlagCompletionFunc("effect", completeEffects)
 	cmd.RegisterFlagCompletionFunc("verbs", completeVerbs)
 	cmd.RegisterFlagCompletionFunc("resources", completeResources)
 }
 
 func ruleFlagsToRule() rbacv1.Rule {
 	r := rbacv1.Rule{
+		Effect:           rbacv1.RuleEffect(ruleEffect),
 		ResourcePatterns: ruleResourcePatterns,
 		Namespaces:       ruleNamespaces,
 	}
@@ -0,0 +1,86 @@
+/*
+
+Copyright 2020,2021 Avi Zimmerman
+
+This file is part of kvdi.
+
+kvdi is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+kvdi is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package cmd
+
+import (
+	"os"
+	"testing"
+
+	rbacv1 "github.com/kvdi/kvdi/apis/rbac/v1"
+	"github.com/kvdi/kvdi/pkg/api"
+	"github.com/kvdi/kvdi/pkg/api/client"
+	"github.com/kvdi/kvdi/pkg/types"
+)
+
+// TestRoleUpdatesKeepSettings tests that updating a role's rules and annotations
+// with kvdictl keeps the settings the commands don't manage.
+func TestRoleUpdatesKeepSettings(t *testing.T) {
+	srvr, addr, adminPass, err := api.NewTestAPI()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srvr.Close()
+	cl, err := client.New(&client.Opts{URL: addr, Username: "admin", Password: adminPass})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+
+	limits := &rbacv1.BandwidthLimits{PerUserBytesPerSecond: 2048}
+	if err := cl.CreateVDIRole(&types.CreateRoleRequest{
+		Name:             "restricted",
+		BandwidthLimits:  limits,
+		DenyAudioCapture: true,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	os.Setenv("KVDI_PASSWORD", adminPass)
+	defer os.Unsetenv("KVDI_PASSWORD")
+	for _, args := range [][]string{
+		{"roles", "rules", "add", "--name", "restricted", "--verbs", "read", "--resources", "templates"},
+		{"roles", "annotations", "set", "--name", "restricted", "team", "dev"},
+		{"roles", "rules", "remove", "--name", "restricted", "--verbs", "read", "--resources", "templates"},
+	} {
+		rootCmd.SetArgs(append([]string{"--server", addr, "--user", "admin"}, args...))
+		if err := rootCmd.Execute(); err != nil {
+			t.Fatalf("Expected %v to succeed, got: %s", args, err)
+		}
+		role, err := cl.GetVDIRole("restricted")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !role.AudioCaptureDenied() {
+			t.Errorf("Expected %v to keep audio capture denied", args)
+		}
+		if role.GetBandwidthLimits() == nil || *role.GetBandwidthLimits() != *limits {
+			t.Errorf("Expected %v to keep the bandwidth limits, got %v", args, role.GetBandwidthLimits())
+		}
+	}
+	role, err := cl.GetVDIRole("restricted")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if role.GetAnnotations()["team"] != "dev" {
+		t.Error("Expected the annotation to be set, got", role.GetAnnotations())
+	}
+}
@@ -50,19 +50,32 @@ func init() {
 	persistentFlags.StringVarP(&cfgFile, "config", "c", "", `configuration file (default "$HOME/.kvdi.yaml")`)
 	persistentFlags.StringP("server", "s", "https://127.0.0.1", "the address to the kvdi API server")
 	persistentFlags.StringP("user", "u", "admin", "the username to use when authenticating against the API")
+	persistentFlags.String("auth-method", "", "the authentication method to use when the server enables more than one")
+	persistentFlags.StringP("token", "t", "", "a personal API token to authenticate with instead of a password")
 	persistentFlags.StringP("ca-file", "C", "", "the CA certificate to use to verify the API certificate")
 	persistentFlags.BoolP("insecure-skip-verify", "k", false, "skip verification of the API server certificate")
+	persistentFlags.String("client-cert", "", "a client certificate to present to the API server for the clientcert auth method")
+	persistentFlags.String("client-key", "", "the private key for the client certificate")
+	persistentFlags.String("as", "", "the name of a user to impersonate for the request")
 	persistentFlags.StringP("output", "o", "json", "the format to dump results in")
 	persistentFlags.StringVarP(&outFilter, "filter", "f", "", "a jmespath expression for filtering results (where applicable)")
 
 	rootCmd.RegisterFlagCompletionFunc("output", completeFormats)
+	rootCmd.RegisterFlagCompletionFunc("auth-method", completeAuthMethods)
 	rootCmd.MarkFlagFilename("config", "yaml", "yml", "json", "toml", "ini", "hcl", "env")
 	rootCmd.MarkFlagFilename("ca-file", "crt", "pem")
+	rootCmd.MarkFlagFilename("client-cert", "crt", "pem")
+	rootCmd.MarkFlagFilename("client-key", "key", "pem")
 
 	viper.BindPFlag("server.url", persistentFlags.Lookup("server"))
 	viper.BindPFlag("server.user", persistentFlags.Lookup("user"))
+	viper.BindPFlag("server.authMethod", persistentFlags.Lookup("auth-method"))
+	viper.BindPFlag("server.token", persistentFlags.Lookup("token"))
 	viper.BindPFlag("server.caFile", persistentFlags.Lookup("ca-file"))
 	viper.BindPFlag("server.insecureSkipVerify", persistentFlags.Lookup("insecure-skip-verify"))
+	viper.BindPFlag("server.clientCertFile", persistentFlags.Lookup("client-cert"))
+	viper.BindPFlag("server.clientKeyFile", persistentFlags.Lookup("client-key"))
+	viper.BindPFlag("server.impersonateUser", persistentFlags.Lookup("as"))
 	viper.BindPFlag("server.output", persistentFlags.Lookup("output"))
 
 	// Allow the configuration file to contain the actual certificate contents (base64 encoded)
@@ -92,8 +105,17 @@ There are two additional fields available in the configuration that are not expo
 Instead of a file, you can inline the CA certificate of the server directly with "server.caCert".
 You may also specify the password for authentication at "server.password". If not found in the 
 configuration file, you will be prompted when credentials are required. You may also set the 
-password in the environment variable KVDI_PASSWORD to avoid being prompted. In the future, there 
-will potentially be the ability to create API tokens specifically for API and CLI usage.
+password in the environment variable KVDI_PASSWORD to avoid being prompted.
+
+Alternatively, a personal API token created with "kvdictl users tokens create" can be used in
+place of a username and password. It can be passed with --token, set at "server.token" in the
+configuration, or set in the environment variable KVDI_TOKEN. When the server enables Kubernetes
+authentication, a ServiceAccount token can be used as the password with "--auth-method kubernetes".
+When it enables client certificate authentication, pass your certificate and key with --client-cert
+and --client-key along with "--auth-method clientcert".
+
+Users allowed to impersonate others can run any command as another user with --as, e.g. to
+check which templates that user is able to launch.
 
 Using the CLI with a user that requires MFA is currently not supported.
 
@@ -103,6 +125,7 @@ An example for a configuration file might look similar to this:
       url: https://127.0.0.1
       user: admin
       password: "supersecret"
+      authMethod: local
       insecureSkipVerify: false
       caFile: "/path/to/file.crt"
       # OR #
@@ -164,6 +187,7 @@ func initClient() {
 	var err error
 	var tlsCA []byte
 	var password []byte
+	var clientCert, clientKey []byte
 
 	if caCertBody := viper.GetString("server.caCert"); caCertBody != "" {
 		tlsCA = []byte(caCertBody)
@@ -172,14 +196,27 @@ func initClient() {
 		cobra.CheckErr(err)
 	}
 
+	if clientCertFile := viper.GetString("server.clientCertFile"); clientCertFile != "" {
+		clientCert, err = os.ReadFile(clientCertFile)
+		cobra.CheckErr(err)
+		clientKey, err = os.ReadFile(viper.GetString("server.clientKeyFile"))
+		cobra.CheckErr(err)
+	}
+
 	kvdiUser := viper.GetString("server.user")
 	kvdiPassword := viper.GetString("server.password")
+	kvdiToken := viper.GetString("server.token")
 
 	if kvdiPassword == "" {
 		kvdiPassword = os.Getenv("KVDI_PASSWORD")
 	}
 
-	if kvdiPassword == "" && notVersionCmd() {
+	if kvdiToken == "" {
+		kvdiToken = os.Getenv("KVDI_TOKEN")
+	}
+
+	usesCredentials := kvdiToken == "" && viper.GetString("server.authMethod") != "clientcert"
+	if kvdiPassword == "" && usesCredentials && notVersionCmd() {
 		fmt.Printf("Enter Password for %q: ", kvdiUser)
 		password, err = term.ReadPassword(int(os.Stdin.Fd()))
 		cobra.CheckErr(err)
@@ -190,15 +227,21 @@ func initClient() {
 		URL:                   viper.GetString("server.url"),
 		Username:              kvdiUser,
 		Password:              kvdiPassword,
+		AuthMethod:            viper.GetString("server.authMethod"),
+		APIKey:                kvdiToken,
 		TLSCACert:             tlsCA,
 		TLSInsecureSkipVerify: viper.GetBool("server.insecureSkipVerify"),
+		TLSClientCert:         clientCert,
+		TLSClientKey:          clientKey,
+		ImpersonateUser:       viper.GetString("server.impersonateUser"),
 	})
 
-	// This would only happen during a bizarre memory allocation issue during cookiejar.New().
-	// Authentication errors are not always fatal depending on the command being used, and the
-	// client object will still be usable (e.g. when querying server version).
+	// This would only happen during a bizarre memory allocation issue during cookiejar.New(),
+	// or when the client certificate is invalid. Authentication errors are not always fatal
+	// depending on the command being used, and the client object will still be usable (e.g.
+	// when querying server version).
 	if kvdiClient == nil {
-		fmt.Fprint(os.Stderr, "ERROR: Fatal error creating kvdi client")
+		fmt.Fprint(os.Stderr, "ERROR: Fatal error creating kvdi client: ", clientErr)
 		os.Exit(3)
 	}
 
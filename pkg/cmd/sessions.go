@@ -29,6 +29,7 @@ import (
 	"strings"
 
 	"github.com/kvdi/kvdi/pkg/api/client"
+	"github.com/kvdi/kvdi/pkg/audio"
 	"github.com/kvdi/kvdi/pkg/types"
 	"github.com/spf13/cobra"
 )
@@ -37,6 +38,9 @@ var (
 	createSessionOpts types.CreateSessionRequest
 	proxyHost         string
 	proxyPort         int
+	audioCodec        string
+	audioBitrate      int
+	audioMode         string
 )
 
 func init() {
@@ -70,6 +74,25 @@ func init() {
 	proxyFlags.StringVar(&proxyHost, "host", "127.0.0.1", "the host to bind the listener to")
 	proxyFlags.IntVar(&proxyPort, "port", 5900, "the port to bind the listener to")
 
+	audioFlags := sessionAudioProxyCmd.Flags()
+	audioFlags.StringVar(&audioCodec, "codec", "", "the codec to encode playback with (pcm, opus-webm, opus-ogg, opus), defaults to opus-webm")
+	audioFlags.IntVar(&audioBitrate, "bitrate", 0, "the bitrate in bits per second to use for opus codecs, defaults to the encoder default")
+	audioFlags.StringVar(&audioMode, "mode", "", "the direction of the stream (both, playback, capture), defaults to both")
+	sessionAudioProxyCmd.RegisterFlagCompletionFunc("codec", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		codecs := make([]string, 0)
+		for _, codec := range audio.Codecs() {
+			codecs = append(codecs, string(codec))
+		}
+		return codecs, cobra.ShellCompDirectiveNoFileComp
+	})
+	sessionAudioProxyCmd.RegisterFlagCompletionFunc("mode", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		modes := make([]string, 0)
+		for _, mode := range audio.Modes() {
+			modes = append(modes, string(mode))
+		}
+		return modes, cobra.ShellCompDirectiveNoFileComp
+	})
+
 	sessionsProxyCmd.AddCommand(sessionDisplayProxyCmd)
 	sessionsProxyCmd.AddCommand(sessionAudioProxyCmd)
 
@@ -187,7 +210,11 @@ var sessionAudioProxyCmd = &cobra.Command{
 			return err
 		}
 		fmt.Println("Retrieving audio connection to", nn.String(), "(if this takes a while a connection may already be open)")
-		conn, err := kvdiClient.GetDesktopAudioProxy(nn)
+		conn, err := kvdiClient.GetDesktopAudioProxyWithOptions(nn, &client.AudioProxyOptions{
+			Codec:   audioCodec,
+			Bitrate: audioBitrate,
+			Mode:    audioMode,
+		})
 		if err != nil {
 			return err
 		}
@@ -0,0 +1,129 @@
+/*
+
+Copyright 2020,2021 Avi Zimmerman
+
+This file is part of kvdi.
+
+kvdi is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+kvdi is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package cmd
+
+import (
+	"fmt"
+
+	rbacv1 "github.com/kvdi/kvdi/apis/rbac/v1"
+	"github.com/kvdi/kvdi/pkg/types"
+	"github.com/spf13/cobra"
+)
+
+var tokenCreateOpts types.CreateAPITokenRequest
+
+func init() {
+	createFlags := tokenCreateCmd.Flags()
+	createFlags.StringVar(&tokenCreateOpts.Name, "name", "", "a name to identify the token")
+	createFlags.StringVar(&tokenCreateOpts.TTL, "ttl", "", "how long the token is valid for, defaults to the maximum allowed by the server")
+	addRuleFlags(tokenCreateCmd)
+	tokenCreateCmd.MarkFlagRequired("name")
+
+	tokensCmd.AddCommand(tokensGetCmd)
+	tokensCmd.AddCommand(tokenCreateCmd)
+	tokensCmd.AddCommand(tokensDeleteCmd)
+
+	usersCmd.AddCommand(tokensCmd)
+}
+
+// tokenUser returns the user to manage tokens or logins for. It is either the one provided
+// as the first argument or the currently authenticated user.
+func tokenUser(args []string) (string, error) {
+	if len(args) > 0 {
+		return args[0], nil
+	}
+	user, err := kvdiClient.WhoAmI()
+	if err != nil {
+		return "", err
+	}
+	return user.Name, nil
+}
+
+var tokensCmd = &cobra.Command{
+	Use:     "tokens",
+	Aliases: []string{"token", "tok"},
+	Short:   "Personal API token commands",
+}
+
+var tokensGetCmd = &cobra.Command{
+	Use:               "get [USER]",
+	Short:             "Retrieve the API tokens for a user, defaults to the current user",
+	Args:              cobra.MaximumNArgs(1),
+	PreRunE:           checkClientInitErr,
+	ValidArgsFunction: completeUsers,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		user, err := tokenUser(args)
+		if err != nil {
+			return err
+		}
+		tokens, err := kvdiClient.GetAPITokens(user)
+		if err != nil {
+			return err
+		}
+		return writeObject(tokens)
+	},
+}
+
+var tokenCreateCmd = &cobra.Command{
+	Use:               "create [USER]",
+	Aliases:           []string{"new"},
+	Short:             "Create an API token for a user, defaults to the current user",
+	Long:              "Create an API token for a user. When rule flags are provided, the token is limited to that rule.",
+	Args:              cobra.MaximumNArgs(1),
+	PreRunE:           checkClientInitErr,
+	ValidArgsFunction: completeUsers,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		user, err := tokenUser(args)
+		if err != nil {
+			return err
+		}
+		if len(ruleVerbs) > 0 || len(ruleResources) > 0 || len(ruleResourcePatterns) > 0 || len(ruleNamespaces) > 0 {
+			tokenCreateOpts.Rules = []rbacv1.Rule{ruleFlagsToRule()}
+		}
+		resp, err := kvdiClient.CreateAPIToken(user, &tokenCreateOpts)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Token %q created successfully for %q\n", resp.Name, user)
+		fmt.Println("  ID:", resp.ID)
+		fmt.Println("  Token:", resp.Token)
+		fmt.Println("The token will not be shown again")
+		return nil
+	},
+}
+
+var tokensDeleteCmd = &cobra.Command{
+	Use:     "delete [USER] [IDS...]",
+	Aliases: []string{"del", "remove", "rem", "rm", "revoke"},
+	Short:   "Revoke API tokens for a user",
+	Args:    cobra.MinimumNArgs(2),
+	PreRunE: checkClientInitErr,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		for _, id := range args[1:] {
+			if err := kvdiClient.DeleteAPIToken(args[0], id); err != nil {
+				return err
+			}
+			fmt.Printf("Token %q revoked successfully\n", id)
+		}
+		return nil
+	},
+}
@@ -58,6 +58,8 @@ func init() {
 	usersCmd.AddCommand(userCreateCmd)
 	usersCmd.AddCommand(usersDeleteCmd)
 	usersCmd.AddCommand(userUpdateCmd)
+	usersCmd.AddCommand(usersResetMFACmd)
+	usersCmd.AddCommand(usersUnlockCmd)
 
 	rootCmd.AddCommand(usersCmd)
 }
@@ -143,6 +145,42 @@ var usersDeleteCmd = &cobra.Command{
 	},
 }
 
+var usersResetMFACmd = &cobra.Command{
+	Use:               "reset-mfa [USERS...]",
+	Short:             "Reset MFA for VDI users",
+	Long:              "Removes all MFA methods and recovery codes for the given users. They will have to enroll again at their next login.",
+	Args:              cobra.MinimumNArgs(1),
+	PreRunE:           checkClientInitErr,
+	ValidArgsFunction: completeUsers,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		for _, arg := range args {
+			if err := kvdiClient.ResetUserMFA(arg); err != nil {
+				return err
+			}
+			fmt.Printf("MFA for user %q reset successfully\n", arg)
+		}
+		return nil
+	},
+}
+
+var usersUnlockCmd = &cobra.Command{
+	Use:               "unlock [USERS...]",
+	Short:             "Unlock VDI users",
+	Long:              "Removes the lockout and any failed logins counted against the given users.",
+	Args:              cobra.MinimumNArgs(1),
+	PreRunE:           checkClientInitErr,
+	ValidArgsFunction: completeUsers,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		for _, arg := range args {
+			if err := kvdiClient.UnlockUser(arg); err != nil {
+				return err
+			}
+			fmt.Printf("User %q unlocked successfully\n", arg)
+		}
+		return nil
+	},
+}
+
 var userUpdateCmd = &cobra.Command{
 	Use:               "update [USER]",
 	Short:             "Update VDI users",
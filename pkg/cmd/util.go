@@ -33,6 +33,7 @@ import (
 	"github.com/spf13/viper"
 	"gopkg.in/yaml.v2"
 
+	appv1 "github.com/kvdi/kvdi/apis/app/v1"
 	rbacv1 "github.com/kvdi/kvdi/apis/rbac/v1"
 )
 
@@ -51,6 +52,16 @@ func completeFormats(cmd *cobra.Command, args []string, toComplete string) ([]st
 	return []string{"json", "yaml"}, cobra.ShellCompDirectiveFilterFileExt
 }
 
+func completeAuthMethods(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	return []string{
+		string(appv1.AuthMethodLocal),
+		string(appv1.AuthMethodLDAP),
+		string(appv1.AuthMethodOIDC),
+		string(appv1.AuthMethodSAML),
+		string(appv1.AuthMethodWebmesh),
+	}, cobra.ShellCompDirectiveDefault
+}
+
 func completeVerbs(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
 	return []string{
 		string(rbacv1.VerbCreate),
@@ -59,10 +70,18 @@ func completeVerbs(cmd *cobra.Command, args []string, toComplete string) ([]stri
 		string(rbacv1.VerbDelete),
 		string(rbacv1.VerbUse),
 		string(rbacv1.VerbLaunch),
+		string(rbacv1.VerbImpersonate),
 		string(rbacv1.VerbAll),
 	}, cobra.ShellCompDirectiveDefault
 }
 
+func completeEffects(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	return []string{
+		string(rbacv1.EffectAllow),
+		string(rbacv1.EffectDeny),
+	}, cobra.ShellCompDirectiveDefault
+}
+
 func completeResources(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
 	return []string{
 		string(rbacv1.ResourceUsers),
@@ -24,12 +24,19 @@
 package client
 
 import (
+	"errors"
 	"io"
+	"net"
+	"time"
 
 	"github.com/go-logr/logr"
 	"github.com/kvdi/kvdi/pkg/proxyproto"
 )
 
+// optionsTimeout is how long to wait for a proxy to answer a request type it may not
+// support. Setting up the audio buffer happens before the answer, so it is generous.
+const optionsTimeout = 15 * time.Second
+
 // Client is a structure used by the kvdi-app for sending traffic to and from
 // the kvdi-proxy instances.
 type Client struct {
@@ -60,13 +67,41 @@ func (p *Client) DisplayProxy() (*proxyproto.Conn, error) {
 	return c, nil
 }
 
-// AudioProxy returns a new connection for proxying a display stream.
-func (p *Client) AudioProxy() (*proxyproto.Conn, error) {
-	c, err := proxyproto.Dial(p.log, p.proxyAddr, proxyproto.RequestTypeAudio)
+// AudioProxy returns a new connection for proxying an audio stream with the given
+// parameters. A nil request uses the proxy defaults and is supported by all proxies,
+// while proxies that predate audio options fail requests with parameters.
+func (p *Client) AudioProxy(req *proxyproto.AudioRequest) (*proxyproto.Conn, error) {
+	if req == nil {
+		c, err := proxyproto.Dial(p.log, p.proxyAddr, proxyproto.RequestTypeAudio)
+		if err != nil {
+			return nil, err
+		}
+		if err := c.ReadStatus(); err != nil {
+			return nil, err
+		}
+		return c, nil
+	}
+	c, err := proxyproto.Dial(p.log, p.proxyAddr, proxyproto.RequestTypeAudioOptions)
 	if err != nil {
 		return nil, err
 	}
+	if err := c.WriteStructure(req); err != nil {
+		p.tryCloseError(c)
+		return nil, err
+	}
+	// Older proxies never answer request types they do not know
+	if err := c.SetReadDeadline(time.Now().Add(optionsTimeout)); err != nil {
+		p.tryCloseError(c)
+		return nil, err
+	}
 	if err := c.ReadStatus(); err != nil {
+		if nerr, ok := err.(net.Error); ok && nerr.Timeout() {
+			return nil, errors.New("The desktop proxy does not support audio options, it may need to be updated")
+		}
+		return nil, err
+	}
+	if err := c.SetReadDeadline(time.Time{}); err != nil {
+		p.tryCloseError(c)
 		return nil, err
 	}
 	return c, nil
@@ -156,16 +156,26 @@ func (c *Conn) readByte() (byte, error) {
 // ReadString reads until the next newline sent over the connection. Request arguments
 // are newline delimited strings sent immediately after the RequestType. This
 // implementation satisfies the request types currently being used, but may need to be
-// adapted further in the future.
+// adapted further in the future. It reads one byte at a time so that nothing following
+// the newline is consumed.
 func (c *Conn) readString() (string, error) {
-	scanner := bufio.NewScanner(c.Conn)
-	scanner.Scan()
-	if err := scanner.Err(); err != nil {
-		return "", err
+	var b []byte
+	for {
+		next, err := c.readByte()
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return "", err
+		}
+		if next == '\n' {
+			return string(b), nil
+		}
+		if len(b) >= bufio.MaxScanTokenSize {
+			return "", errors.New("String argument is too long")
+		}
+		b = append(b, next)
 	}
-	s := scanner.Text()
-	c.rsize += int64(len([]byte(s)))
-	return s, nil
 }
 
 // ReadInt64 is used similarly to ReadString, except it reads a signed 64-bit integer argument
@@ -53,6 +53,10 @@ const (
 	RequestTypeFGet
 	// RequestTypeFPut is a request to put a file on the system.
 	RequestTypeFPut
+	// RequestTypeAudioOptions is a request for an audio feed with the parameters in an
+	// AudioRequest. Proxies that predate it leave it unanswered, so RequestTypeAudio is
+	// still used when the defaults are requested.
+	RequestTypeAudioOptions
 )
 
 // RequestStatus represents the non-wire related status of a request.
@@ -72,6 +76,8 @@ func (r RequestType) String() string {
 		return "display"
 	case RequestTypeAudio:
 		return "audio"
+	case RequestTypeAudioOptions:
+		return "audio-options"
 	case RequestTypeFStat:
 		return "stat-file"
 	case RequestTypeFGet:
@@ -83,6 +89,45 @@ func (r RequestType) String() string {
 	}
 }
 
+// AudioRequest contains the parameters for requesting an audio stream from a proxy.
+// It is only sent with RequestTypeAudioOptions.
+type AudioRequest struct {
+	// The codec to encode the playback stream with. The proxy default is used
+	// when empty.
+	Codec string
+	// The bitrate in bits per second to use for Opus codecs. The encoder default
+	// is used when zero.
+	Bitrate int64
+	// The direction of the stream: playback, capture, or both. Both directions
+	// are requested when empty.
+	Mode string
+}
+
+func (a *AudioRequest) String() string {
+	return fmt.Sprintf("Audio { Codec: %s, Bitrate: %d, Mode: %s }", a.Codec, a.Bitrate, a.Mode)
+}
+
+func (a *AudioRequest) send(c *Conn) (err error) {
+	if err = c.writeString(a.Codec); err != nil {
+		return
+	}
+	if err = c.writeInt64(a.Bitrate); err != nil {
+		return
+	}
+	return c.writeString(a.Mode)
+}
+
+func (a *AudioRequest) recv(c *Conn) (err error) {
+	if a.Codec, err = c.readString(); err != nil {
+		return
+	}
+	if a.Bitrate, err = c.readInt64(); err != nil {
+		return
+	}
+	a.Mode, err = c.readString()
+	return
+}
+
 // FStatRequest contains the parameters for sending a stat request to a proxy.
 type FStatRequest struct {
 	Path string
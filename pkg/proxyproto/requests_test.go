@@ -0,0 +1,86 @@
+/*
+
+Copyright 2020,2021 Avi Zimmerman
+
+This file is part of kvdi.
+
+kvdi is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+kvdi is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package proxyproto
+
+import (
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr"
+)
+
+func newTestConns(t *testing.T) (client, server *Conn) {
+	t.Helper()
+	c, s := net.Pipe()
+	t.Cleanup(func() {
+		c.Close()
+		s.Close()
+	})
+	return &Conn{Conn: c, log: logr.Discard()}, &Conn{Conn: s, log: logr.Discard()}
+}
+
+func TestAudioRequest(t *testing.T) {
+	client, server := newTestConns(t)
+	req := &AudioRequest{Codec: "opus", Bitrate: 64000, Mode: "playback"}
+	go func() {
+		if err := client.WriteStructure(req); err != nil {
+			t.Error(err)
+		}
+		// data following the request is left on the wire
+		client.Write([]byte("stream"))
+	}()
+
+	got := &AudioRequest{}
+	if err := server.ReadStructure(got); err != nil {
+		t.Fatal(err)
+	}
+	if *got != *req {
+		t.Errorf("Expected %s, got %s", req, got)
+	}
+	buf := make([]byte, len("stream"))
+	if _, err := io.ReadFull(server, buf); err != nil || string(buf) != "stream" {
+		t.Error("Expected the stream to follow the request, got:", string(buf), err)
+	}
+}
+
+func TestAudioRequestMalformed(t *testing.T) {
+	tcs := map[string]string{
+		"empty":              "",
+		"unterminated codec": "opus",
+		"truncated bitrate":  "opus\n\x00\xfa",
+		"missing mode":       "opus\n\x00\xfa\x00\x00\x00\x00\x00\x00",
+		"unterminated mode":  "opus\n\x00\xfa\x00\x00\x00\x00\x00\x00playback",
+		"codec is too long":  strings.Repeat("a", 1<<17),
+	}
+	for name, data := range tcs {
+		client, server := newTestConns(t)
+		go func() {
+			client.Write([]byte(data))
+			client.Close()
+		}()
+		if err := server.ReadStructure(&AudioRequest{}); err == nil {
+			t.Errorf("Expected error reading a request with %s", name)
+		}
+	}
+}
@@ -22,6 +22,7 @@
 package server
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
@@ -110,9 +111,43 @@ func (p *Server) handleDisplay(conn *proxyproto.Conn) {
 }
 
 func (p *Server) handleAudio(conn *proxyproto.Conn) {
-	p.log.Info("Received audio proxy request, setting up pulseaudio/g-streamer")
 	defer conn.Close()
 
+	// Plain audio requests stream the defaults
+	req := &proxyproto.AudioRequest{}
+	if conn.RequestType() == proxyproto.RequestTypeAudioOptions {
+		if err := conn.ReadStructure(req); err != nil {
+			p.log.Error(err, "Could not read audio request from client")
+			conn.WriteError(err)
+			return
+		}
+	}
+	p.log.Info(req.String())
+
+	codec, err := audio.ParseCodec(req.Codec)
+	if err != nil {
+		conn.WriteError(err)
+		return
+	}
+	if err := codec.ValidateBitrate(int(req.Bitrate)); err != nil {
+		conn.WriteError(err)
+		return
+	}
+	mode, err := audio.ParseMode(req.Mode)
+	if err != nil {
+		conn.WriteError(err)
+		return
+	}
+	if p.opts.DenyAudioCapture && mode.Capture() {
+		if mode, err = mode.WithoutCapture(); err != nil {
+			conn.WriteError(err)
+			return
+		}
+		p.log.Info("Microphone capture is not allowed, only streaming playback")
+	}
+
+	p.log.Info("Received audio proxy request, setting up pulseaudio/g-streamer")
+
 	p.log.Info("Starting audio buffer")
 	// Create a new audio buffer
 	audioBuffer := audio.NewBuffer(&audio.BufferOpts{
@@ -122,6 +157,9 @@ func (p *Server) handleAudio(conn *proxyproto.Conn) {
 		PulseMonitorName:       p.opts.PlaybackDeviceName,
 		PulseMicName:           p.opts.RecordingDeviceName,
 		PulseMicPath:           p.opts.RecordingDevicePath,
+		Codec:                  codec,
+		Bitrate:                int(req.Bitrate),
+		Mode:                   mode,
 	})
 
 	// Start the audio buffer
@@ -140,24 +178,39 @@ func (p *Server) handleAudio(conn *proxyproto.Conn) {
 	defer func() { stChan <- struct{}{} }()
 
 	// Copy audio playback data to the connection
-	go func() {
-		defer audioBuffer.Close()
-		if _, err := io.Copy(conn, audioBuffer); err != nil {
-			if !errors.IsBrokenPipeError(err) {
-				p.log.Error(err, "Error while copying from audio stream to websocket connection")
+	if mode.Playback() {
+		go func() {
+			defer audioBuffer.Close()
+			if _, err := io.Copy(conn, audioBuffer); err != nil {
+				if !errors.IsBrokenPipeError(err) {
+					p.log.Error(err, "Error while copying from audio stream to websocket connection")
+				}
 			}
-		}
-	}()
+		}()
+	}
 
 	// Copy any received recording data to the buffer
-	go func() {
-		defer audioBuffer.Close()
-		if _, err := io.Copy(audioBuffer, conn); err != nil {
-			if !errors.IsBrokenPipeError(err) {
-				p.log.Error(err, "Error while copying from websocket connection to audio buffer")
+	if mode.Capture() {
+		go func() {
+			defer audioBuffer.Close()
+			if _, err := io.Copy(audioBuffer, conn); err != nil {
+				if !errors.IsBrokenPipeError(err) {
+					p.log.Error(err, "Error while copying from websocket connection to audio buffer")
+				}
 			}
-		}
-	}()
+		}()
+	} else {
+		// Still watch the connection so the buffer is closed when the client
+		// goes away, but discard anything it sends.
+		go func() {
+			defer audioBuffer.Close()
+			if _, err := io.Copy(io.Discard, conn); err != nil {
+				if !errors.IsBrokenPipeError(err) {
+					p.log.Error(err, "Error while reading from websocket connection")
+				}
+			}
+		}()
+	}
 
 	// Block on the audio pipeline
 	audioBuffer.RunLoop()
@@ -172,6 +225,12 @@ func (p *Server) setupPulseAudio(manager pa.DeviceManager) error {
 		return err
 	}
 
+	// Don't create a microphone at all when capture is not allowed
+	if p.opts.DenyAudioCapture {
+		p.log.Info("Microphone capture is not allowed, skipping recording device setup")
+		return nil
+	}
+
 	if _, err := manager.AddSource(&pa.SourceOpts{
 		Name:         p.opts.RecordingDeviceName,
 		Description:  p.opts.RecordingDeviceDescription,
@@ -285,12 +344,17 @@ func (p *Server) handleGet(conn *proxyproto.Conn) {
 		return
 	}
 
+	if !p.opts.FileTransferPolicy.AllowsDownload() {
+		conn.WriteError(errors.New("File downloads are not allowed from this desktop"))
+		return
+	}
+
 	if finfo.IsDir() {
-		serveDir(conn, path)
+		p.serveDir(conn, path)
 		return
 	}
 
-	serveFile(conn, finfo, path)
+	p.serveFile(conn, finfo, path)
 }
 
 func (p *Server) handlePut(conn *proxyproto.Conn) {
@@ -304,6 +368,25 @@ func (p *Server) handlePut(conn *proxyproto.Conn) {
 	}
 	p.log.Info(req.String())
 
+	if !p.opts.FileTransferPolicy.AllowsUpload() {
+		conn.WriteError(errors.New("File uploads are not allowed to this desktop"))
+		return
+	}
+
+	fName := sanitize.BaseName(req.Name)
+
+	// Read the header of the upload to detect its content type
+	hdr := make([]byte, minInt64(512, req.Size))
+	if _, err := io.ReadFull(req.Body, hdr); err != nil {
+		conn.WriteError(err)
+		return
+	}
+	if err := p.opts.FileTransferPolicy.CheckFile(fName, http.DetectContentType(hdr), req.Size); err != nil {
+		p.log.Info("Rejecting file upload", "Reason", err.Error())
+		conn.WriteError(err)
+		return
+	}
+
 	uploadDir := filepath.Join(v1.DesktopHomeMntPath, "Uploads")
 	if err := os.MkdirAll(uploadDir, 0755); err != nil {
 		conn.WriteError(err)
@@ -314,7 +397,6 @@ func (p *Server) handlePut(conn *proxyproto.Conn) {
 		return
 	}
 
-	fName := sanitize.BaseName(req.Name)
 	dstFile := filepath.Join(uploadDir, fName)
 
 	f, err := os.Create(dstFile)
@@ -324,7 +406,8 @@ func (p *Server) handlePut(conn *proxyproto.Conn) {
 	}
 	defer f.Close()
 
-	if _, err := io.CopyN(f, req.Body, req.Size); err != nil {
+	body := io.MultiReader(bytes.NewReader(hdr), req.Body)
+	if _, err := io.CopyN(f, body, req.Size); err != nil {
 		conn.WriteError(err)
 		return
 	}
@@ -339,7 +422,7 @@ func (p *Server) handlePut(conn *proxyproto.Conn) {
 	}
 }
 
-func serveDir(conn *proxyproto.Conn, path string) {
+func (p *Server) serveDir(conn *proxyproto.Conn, path string) {
 	tarball, err := common.TarDirectoryToTempFile(path)
 	if err != nil {
 		conn.WriteError(err)
@@ -350,10 +433,10 @@ func serveDir(conn *proxyproto.Conn, path string) {
 		conn.WriteError(err)
 		return
 	}
-	serveFile(conn, finfo, tarball)
+	p.serveFile(conn, finfo, tarball)
 }
 
-func serveFile(conn *proxyproto.Conn, finfo os.FileInfo, path string) {
+func (p *Server) serveFile(conn *proxyproto.Conn, finfo os.FileInfo, path string) {
 	f, err := os.Open(path)
 	if err != nil {
 		conn.WriteError(err)
@@ -377,6 +460,12 @@ func serveFile(conn *proxyproto.Conn, finfo os.FileInfo, path string) {
 	// Get content type of file
 	contentType := http.DetectContentType(hdr)
 
+	if err := p.opts.FileTransferPolicy.CheckFile(finfo.Name(), contentType, finfo.Size()); err != nil {
+		p.log.Info("Rejecting file download", "Reason", err.Error())
+		conn.WriteError(err)
+		return
+	}
+
 	conn.WriteResponse(&proxyproto.FGetResponse{
 		Name: filepath.Base(finfo.Name()),
 		Type: contentType,
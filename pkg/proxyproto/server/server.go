@@ -25,11 +25,13 @@ package server
 
 import (
 	"crypto/tls"
+	"fmt"
 	"net"
 	"strconv"
 
 	"github.com/go-logr/logr"
 
+	desktopsv1 "github.com/kvdi/kvdi/apis/desktops/v1"
 	"github.com/kvdi/kvdi/pkg/proxyproto"
 	"github.com/kvdi/kvdi/pkg/util/tlsutil"
 )
@@ -53,6 +55,8 @@ type ProxyOpts struct {
 	RecordingDeviceName, RecordingDeviceDescription    string
 	RecordingDevicePath, RecordingDeviceFormat         string
 	RecordingDeviceSampleRate, RecordingDeviceChannels int
+	FileTransferPolicy                                 *desktopsv1.FileTransferPolicy
+	DenyAudioCapture                                   bool
 }
 
 // New returns a new proxy server configured to listen on the given host and
@@ -97,7 +101,7 @@ func (p *Server) handler(rt proxyproto.RequestType) Handler {
 	switch rt {
 	case proxyproto.RequestTypeDisplay:
 		return p.handleDisplay
-	case proxyproto.RequestTypeAudio:
+	case proxyproto.RequestTypeAudio, proxyproto.RequestTypeAudioOptions:
 		return p.handleAudio
 	case proxyproto.RequestTypeFStat:
 		return p.handleStat
@@ -113,11 +117,14 @@ func (p *Server) handleConn(c net.Conn) {
 	pc, err := proxyproto.NewConn(p.log, c)
 	if err != nil {
 		p.log.Error(err, "Error initiating new client connection")
+		return
 	}
 	p.log.Info("Serving new request", "Type", pc.RequestType().String(), "Client", pc.Conn.RemoteAddr().String())
 	hdlr := p.handler(pc.RequestType())
 	if hdlr == nil {
 		p.log.Info("No handler for request")
+		defer pc.Close()
+		pc.WriteError(fmt.Errorf("Unsupported request type: %d", pc.RequestType()))
 		return
 	}
 	hdlr(pc)
@@ -65,3 +65,10 @@ func (p *Server) logConnectionMetrics(proxyType string, conn *proxyproto.Conn) c
 	}()
 	return st
 }
+
+func minInt64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -105,6 +105,8 @@ func newAppContainerForCR(instance *appv1.VDICluster) corev1.Container {
 	}
 	if instance.AppTLSIsDisabled() {
 		args = append(args, "--disable-tls")
+	} else if instance.IsAuthMethodEnabled(appv1.AuthMethodClientCert) {
+		args = append(args, "--request-client-certs")
 	}
 	return corev1.Container{
 		Name:            "app",
@@ -38,7 +38,7 @@ var appRules = []rbacv1.PolicyRule{
 	},
 	{
 		APIGroups: []string{"rbac.kvdi.io"},
-		Resources: []string{"vdiroles"},
+		Resources: []string{"vdiroles", "vdirolebindings", "vdiusers"},
 		Verbs:     verbsAll,
 	},
 	{
@@ -56,6 +56,11 @@ var appRules = []rbacv1.PolicyRule{
 		Resources: []string{"configmaps", "secrets"},
 		Verbs:     verbsAll,
 	},
+	{
+		APIGroups: []string{"authentication.k8s.io"},
+		Resources: []string{"tokenreviews"},
+		Verbs:     []string{"create"},
+	},
 }
 
 func newAppClusterRoleForCR(instance *appv1.VDICluster) *rbacv1.ClusterRole {
@@ -27,6 +27,7 @@ import (
 	v1 "github.com/kvdi/kvdi/apis/meta/v1"
 
 	"github.com/kvdi/kvdi/pkg/auth"
+	"github.com/kvdi/kvdi/pkg/auth/signing"
 	"github.com/kvdi/kvdi/pkg/pki"
 	"github.com/kvdi/kvdi/pkg/resources"
 	"github.com/kvdi/kvdi/pkg/secrets"
@@ -75,18 +76,31 @@ func (f *Reconciler) Reconcile(ctx context.Context, reqLogger logr.Logger, insta
 		}
 	}()
 
-	// Reconcile a secret for generating JWT tokens
-	reqLogger.Info("Reconciling JWT secrets")
-	if _, err := secretsEngine.ReadSecret(v1.JWTSecretKey, false); err != nil {
-		if !errors.IsSecretNotFoundError(err) {
-			return err
-		}
-		jwtSecret, err := common.GeneratePassword(32)
-		if err != nil {
-			return err
-		}
-		if err := secretsEngine.WriteSecret(v1.JWTSecretKey, []byte(jwtSecret)); err != nil {
-			return err
+	// Reconcile a key for signing JWT tokens. This also rotates it when it is due
+	// or the signing algorithm changed.
+	reqLogger.Info("Reconciling JWT signing keys")
+	if _, err := signing.NewManager(secretsEngine).SigningKey(
+		instance.GetTokenSigningAlgorithm(),
+		instance.GetTokenRotationInterval(),
+		instance.GetTokenRotationOverlap(),
+	); err != nil {
+		return err
+	}
+
+	// Reconcile the bearer token SCIM clients authenticate with
+	if instance.IsSCIMEnabled() {
+		reqLogger.Info("Reconciling SCIM token")
+		if _, err := secretsEngine.ReadSecret(v1.SCIMTokenSecretKey, false); err != nil {
+			if !errors.IsSecretNotFoundError(err) {
+				return err
+			}
+			scimToken, err := common.GeneratePassword(48)
+			if err != nil {
+				return err
+			}
+			if err := secretsEngine.WriteSecret(v1.SCIMTokenSecretKey, []byte(scimToken)); err != nil {
+				return err
+			}
 		}
 	}
 
@@ -20,8 +20,6 @@ along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
 package secrets
 
 import (
-	"context"
-	"os"
 	"reflect"
 	"testing"
 	"time"
@@ -31,10 +29,6 @@ import (
 	"github.com/kvdi/kvdi/pkg/secrets/providers/k8secret"
 	"github.com/kvdi/kvdi/pkg/secrets/providers/vault"
 	"github.com/kvdi/kvdi/pkg/util/errors"
-
-	corev1 "k8s.io/api/core/v1"
-	"k8s.io/apimachinery/pkg/runtime"
-	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 )
 
 func newTestCluster(t *testing.T) *appv1.VDICluster {
@@ -50,19 +44,8 @@ func newTestCluster(t *testing.T) *appv1.VDICluster {
 }
 
 func mustSetupSecretEngine(t *testing.T) *SecretEngine {
-	scheme := runtime.NewScheme()
-	appv1.AddToScheme(scheme)
-	corev1.AddToScheme(scheme)
-	os.Setenv("POD_NAME", "test-pod")
-	os.Setenv("POD_NAMESPACE", "test-namespace")
-	c := fake.NewFakeClientWithScheme(scheme)
-	p := &corev1.Pod{}
-	p.Name = "test-pod"
-	p.Namespace = "test-namespace"
-	c.Create(context.TODO(), p)
-	cluster := newTestCluster(t)
-	se := GetSecretEngine(cluster)
-	if err := se.Setup(c, cluster); err != nil {
+	se, _, err := NewTestEngine(newTestCluster(t))
+	if err != nil {
 		t.Fatal(err)
 	}
 	return se
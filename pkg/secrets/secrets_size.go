@@ -0,0 +1,42 @@
+/*
+
+Copyright 2020,2021 Avi Zimmerman
+
+This file is part of kvdi.
+
+kvdi is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+kvdi is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package secrets
+
+import "encoding/base64"
+
+// EntrySize returns roughly how many bytes a single key and value of a map secret take
+// up once written to the backend. Values are base64 encoded when they are stored, and
+// each entry carries a few bytes of JSON punctuation.
+func EntrySize(key string, value []byte) int {
+	return len(key) + base64.StdEncoding.EncodedLen(len(value)) + 6
+}
+
+// MapSize returns roughly how many bytes the given map secret takes up once written to
+// the backend. All secrets share the same storage when using the default Kubernetes
+// Secret backend, so maps that grow with user activity are bounded by this size.
+func MapSize(contents map[string][]byte) int {
+	var size int
+	for key, value := range contents {
+		size += EntrySize(key, value)
+	}
+	return size
+}
@@ -0,0 +1,69 @@
+/*
+
+Copyright 2020,2021 Avi Zimmerman
+
+This file is part of kvdi.
+
+kvdi is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+kvdi is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package secrets
+
+import (
+	"context"
+	"os"
+
+	appv1 "github.com/kvdi/kvdi/apis/app/v1"
+	rbacv1 "github.com/kvdi/kvdi/apis/rbac/v1"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// NewTestEngine returns a SecretEngine for the given cluster using a fake kubernetes client
+// and the default backend, along with the client. The environment is set up as if running
+// in a pod so that the engine can take locks, and the given objects are created in the
+// client. A cluster named test-cluster is used when cluster is nil.
+func NewTestEngine(cluster *appv1.VDICluster, objs ...runtime.Object) (*SecretEngine, client.Client, error) {
+	scheme := runtime.NewScheme()
+	for _, add := range []func(*runtime.Scheme) error{appv1.AddToScheme, rbacv1.AddToScheme, corev1.AddToScheme} {
+		if err := add(scheme); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// create a fake running pod/ns and set environment
+	os.Setenv("POD_NAME", "test-pod")
+	os.Setenv("POD_NAMESPACE", "test-namespace")
+	c := fake.NewFakeClientWithScheme(scheme, objs...)
+	pod := &corev1.Pod{}
+	pod.Name = "test-pod"
+	pod.Namespace = "test-namespace"
+	if err := c.Create(context.TODO(), pod); err != nil {
+		return nil, nil, err
+	}
+
+	if cluster == nil {
+		cluster = &appv1.VDICluster{}
+		cluster.Name = "test-cluster"
+	}
+	engine := GetSecretEngine(cluster)
+	if err := engine.Setup(c, cluster); err != nil {
+		return nil, nil, err
+	}
+	return engine, c, nil
+}
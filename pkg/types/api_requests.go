@@ -22,9 +22,11 @@ package types
 import (
 	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"regexp"
 	"strings"
+	"time"
 
 	metav1 "github.com/kvdi/kvdi/apis/meta/v1"
 	rbacv1 "github.com/kvdi/kvdi/apis/rbac/v1"
@@ -45,6 +47,9 @@ type LoginRequest struct {
 	// State generated by requesting client to prevent CSRF and retrieve tokens
 	// from an oidc flow
 	State string `json:"state"`
+	// The authentication method to use when more than one is enabled. Defaults to
+	// the first enabled method.
+	Method string `json:"method"`
 	// the underlying request object for usage by auth providers
 	request *http.Request
 }
@@ -59,6 +64,9 @@ func (l *LoginRequest) GetPassword() string { return l.Password }
 // GetState returns the state secret in the request.
 func (l *LoginRequest) GetState() string { return l.State }
 
+// GetMethod returns the authentication method in the request.
+func (l *LoginRequest) GetMethod() string { return l.Method }
+
 // SetRequest sets the request object in the LoginRequest.
 func (l *LoginRequest) SetRequest(r *http.Request) {
 	l.request = r
@@ -75,6 +83,10 @@ type AuthorizeRequest struct {
 	OTP string `json:"otp"`
 	// The state secret for the request flow
 	State string `json:"state"`
+	// A WebAuthn assertion to use instead of the one-time password
+	WebAuthn *WebAuthnAssertion `json:"webauthn,omitempty"`
+	// A recovery code to use instead of the one-time password
+	RecoveryCode string `json:"recoveryCode,omitempty"`
 }
 
 // GetOTP returns the OTP from the request.
@@ -143,6 +155,23 @@ func (r *UpdateUserRequest) Validate() error {
 	return nil
 }
 
+// ChangePasswordRequest requests a change to a user's own password. Only users of
+// the local auth provider can change their passwords.
+type ChangePasswordRequest struct {
+	// The user's current password.
+	CurrentPassword string `json:"currentPassword"`
+	// The new password for the user.
+	NewPassword string `json:"newPassword"`
+}
+
+// Validate the ChangePasswordRequest
+func (r *ChangePasswordRequest) Validate() error {
+	if r.CurrentPassword == "" || r.NewPassword == "" {
+		return errors.New("'currentPassword' and 'newPassword' must be provided in the request")
+	}
+	return nil
+}
+
 // UpdateMFARequest sets the MFA configuration for the user. If enabling,
 // a provisioning URI will be returned.
 type UpdateMFARequest struct {
@@ -152,12 +181,235 @@ type UpdateMFARequest struct {
 
 // MFAResponse contains the response to an UpdateMFARequest or GetMFARequest.
 type MFAResponse struct {
-	// Whether MFA is enabled for the user
+	// Whether one-time passwords are enabled for the user
 	Enabled bool `json:"enabled"`
 	// If enabled is set, a provisioning URI is also returned.
 	ProvisioningURI string `json:"provisioningURI"`
 	// If enabled is set, whether or not the user has verified their MFA setup
 	Verified bool `json:"verified"`
+	// All of the MFA methods the user has enrolled, including WebAuthn
+	Methods []string `json:"methods,omitempty"`
+	// One-time recovery codes for the user. These are only returned when they are
+	// generated and cannot be retrieved again.
+	RecoveryCodes []string `json:"recoveryCodes,omitempty"`
+	// The number of unused recovery codes the user has.
+	RecoveryCodesRemaining int `json:"recoveryCodesRemaining,omitempty"`
+}
+
+// WebAuthnCredential contains the details of a WebAuthn authenticator registered
+// for a user.
+type WebAuthnCredential struct {
+	// The base64url encoded ID of the credential
+	ID string `json:"id"`
+	// The name given to the credential when it was registered
+	Name string `json:"name"`
+	// When the credential was registered
+	CreatedAt time.Time `json:"createdAt"`
+	// When the credential was last used to authorize a session, if ever
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+}
+
+// WebAuthnRelyingParty identifies kVDI to an authenticator.
+type WebAuthnRelyingParty struct {
+	// The relying party ID, which is the host name kVDI is served on
+	ID string `json:"id"`
+	// A human-friendly name for the relying party
+	Name string `json:"name"`
+}
+
+// WebAuthnUserEntity identifies the user to an authenticator.
+type WebAuthnUserEntity struct {
+	// The base64url encoded user handle
+	ID string `json:"id"`
+	// The name of the user
+	Name string `json:"name"`
+	// The display name of the user
+	DisplayName string `json:"displayName"`
+}
+
+// WebAuthnCredentialParameters describes a type of credential that can be created.
+type WebAuthnCredentialParameters struct {
+	// The type of the credential, always public-key
+	Type string `json:"type"`
+	// The COSE algorithm identifier
+	Alg int `json:"alg"`
+}
+
+// WebAuthnCredentialDescriptor references an existing credential.
+type WebAuthnCredentialDescriptor struct {
+	// The type of the credential, always public-key
+	Type string `json:"type"`
+	// The base64url encoded ID of the credential
+	ID string `json:"id"`
+}
+
+// WebAuthnCreationOptions are the options to pass to navigator.credentials.create()
+// to register a new authenticator. Binary values are base64url encoded.
+type WebAuthnCreationOptions struct {
+	// The challenge for the registration ceremony
+	Challenge string `json:"challenge"`
+	// The relying party details
+	RP WebAuthnRelyingParty `json:"rp"`
+	// The user details
+	User WebAuthnUserEntity `json:"user"`
+	// The supported credential types
+	PubKeyCredParams []WebAuthnCredentialParameters `json:"pubKeyCredParams"`
+	// How long the client has to complete the ceremony, in milliseconds
+	Timeout int64 `json:"timeout"`
+	// Credentials that are already registered for the user
+	ExcludeCredentials []WebAuthnCredentialDescriptor `json:"excludeCredentials"`
+	// The attestation conveyance preference
+	Attestation string `json:"attestation"`
+}
+
+// WebAuthnRequestOptions are the options to pass to navigator.credentials.get()
+// to authorize a session with a registered authenticator. Binary values are base64url
+// encoded.
+type WebAuthnRequestOptions struct {
+	// The challenge for the assertion ceremony
+	Challenge string `json:"challenge"`
+	// How long the client has to complete the ceremony, in milliseconds
+	Timeout int64 `json:"timeout"`
+	// The relying party ID
+	RPID string `json:"rpId"`
+	// The credentials registered for the user
+	AllowCredentials []WebAuthnCredentialDescriptor `json:"allowCredentials"`
+	// The user verification requirement
+	UserVerification string `json:"userVerification"`
+}
+
+// WebAuthnAttestationResponse is the response from an authenticator to a registration
+// ceremony. Binary values are base64url encoded.
+type WebAuthnAttestationResponse struct {
+	// The client data passed to the authenticator
+	ClientDataJSON string `json:"clientDataJSON"`
+	// The attestation object produced by the authenticator
+	AttestationObject string `json:"attestationObject"`
+}
+
+// RegisterWebAuthnRequest completes the registration of a new WebAuthn credential.
+type RegisterWebAuthnRequest struct {
+	// A name to identify the credential. It must be unique for the user.
+	Name string `json:"name"`
+	// The base64url encoded ID of the new credential
+	ID string `json:"id"`
+	// The response from the authenticator
+	Response WebAuthnAttestationResponse `json:"response"`
+}
+
+// Validate the RegisterWebAuthnRequest
+func (r *RegisterWebAuthnRequest) Validate() error {
+	if r.Name == "" {
+		return errors.New("A name is required for the new credential")
+	}
+	if r.ID == "" || r.Response.ClientDataJSON == "" || r.Response.AttestationObject == "" {
+		return errors.New("'id', 'response.clientDataJSON', and 'response.attestationObject' must be provided in the request")
+	}
+	return nil
+}
+
+// WebAuthnAssertionResponse is the response from an authenticator to an assertion
+// ceremony. Binary values are base64url encoded.
+type WebAuthnAssertionResponse struct {
+	// The client data passed to the authenticator
+	ClientDataJSON string `json:"clientDataJSON"`
+	// The authenticator data
+	AuthenticatorData string `json:"authenticatorData"`
+	// The signature over the authenticator data and client data hash
+	Signature string `json:"signature"`
+	// The user handle stored with the credential, if any
+	UserHandle string `json:"userHandle,omitempty"`
+}
+
+// WebAuthnAssertion is a signed assertion from a registered authenticator.
+type WebAuthnAssertion struct {
+	// The base64url encoded ID of the credential used
+	ID string `json:"id"`
+	// The response from the authenticator
+	Response WebAuthnAssertionResponse `json:"response"`
+}
+
+// CreateAPITokenRequest represents a request for a new personal API token.
+type CreateAPITokenRequest struct {
+	// A name to identify the token. It must be unique for the user.
+	Name string `json:"name"`
+	// When provided, limits the token to these rules. They must be a subset of the
+	// rules granted by the user's roles. Otherwise, the token has all of the user's
+	// permissions.
+	Rules []rbacv1.Rule `json:"rules,omitempty"`
+	// How long the token is valid for (e.g. 720h). It cannot exceed the maximum configured
+	// for the cluster, which is also used when it is empty.
+	TTL string `json:"ttl,omitempty"`
+}
+
+// Validate the CreateAPITokenRequest
+func (r *CreateAPITokenRequest) Validate() error {
+	if r.Name == "" {
+		return errors.New("A name is required for the new token")
+	}
+	if r.TTL != "" {
+		ttl, err := time.ParseDuration(r.TTL)
+		if err != nil {
+			return fmt.Errorf("%s is an invalid ttl: %s", r.TTL, err.Error())
+		}
+		if ttl <= 0 {
+			return errors.New("The ttl for the token must be positive")
+		}
+	}
+	for _, rule := range r.Rules {
+		if err := validateRule(rule); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetTTL returns the lifetime of the requested token, or zero if none was given.
+func (r *CreateAPITokenRequest) GetTTL() time.Duration {
+	ttl, _ := time.ParseDuration(r.TTL)
+	return ttl
+}
+
+// APIToken contains the details of a personal API token. The token itself is only
+// returned when it is created.
+type APIToken struct {
+	// The ID of the token
+	ID string `json:"id"`
+	// The name of the token
+	Name string `json:"name"`
+	// The user the token belongs to
+	User string `json:"user"`
+	// The rules the token is limited to, if any.
+	Rules []rbacv1.Rule `json:"rules,omitempty"`
+	// When the token was created
+	CreatedAt time.Time `json:"createdAt"`
+	// When the token expires, if ever
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+}
+
+// UserLogin represents an active login for a user. A login lasts for as long as its
+// refresh token keeps being exchanged for new access tokens.
+type UserLogin struct {
+	// The ID of the login
+	ID string `json:"id"`
+	// The user the login belongs to
+	User string `json:"user"`
+	// When the user logged in
+	CreatedAt time.Time `json:"createdAt"`
+	// When the current refresh token was issued
+	IssuedAt time.Time `json:"issuedAt"`
+	// The address the current refresh token was issued to
+	SourceIP string `json:"sourceIP,omitempty"`
+	// The user agent the current refresh token was issued to
+	UserAgent string `json:"userAgent,omitempty"`
+}
+
+// CreateAPITokenResponse contains a newly created personal API token.
+type CreateAPITokenResponse struct {
+	// The details of the token
+	APIToken `json:",inline"`
+	// The token to pass in the X-Session-Token header. It cannot be retrieved again.
+	Token string `json:"token"`
 }
 
 // CreateRoleRequest represents a request for a new role.
@@ -168,11 +420,18 @@ type CreateRoleRequest struct {
 	Annotations map[string]string `json:"annotations"`
 	// Rules to apply to the new role.
 	Rules []rbacv1.Rule `json:"rules"`
+	// Bandwidth limits to apply to the new role.
+	BandwidthLimits *rbacv1.BandwidthLimits `json:"bandwidthLimits,omitempty"`
+	// Whether to forbid microphone capture for users bound to the role.
+	DenyAudioCapture bool `json:"denyAudioCapture,omitempty"`
 }
 
 // GetName returns the name of the new role
 func (r *CreateRoleRequest) GetName() string { return r.Name }
 
+// GetBandwidthLimits returns the bandwidth limits provided in the request
+func (r *CreateRoleRequest) GetBandwidthLimits() *rbacv1.BandwidthLimits { return r.BandwidthLimits }
+
 // GetAnnotations returns the annotations provided in the request
 func (r *CreateRoleRequest) GetAnnotations() map[string]string { return r.Annotations }
 
@@ -182,7 +441,7 @@ func (r *CreateRoleRequest) Validate() error {
 		return errors.New("A name is required for the new role")
 	}
 	for _, rule := range r.Rules {
-		if err := validatePatterns(rule.ResourcePatterns); err != nil {
+		if err := validateRule(rule); err != nil {
 			return err
 		}
 	}
@@ -210,11 +469,20 @@ type UpdateRoleRequest struct {
 	Annotations map[string]string `json:"annotations"`
 	// The new rules for the role.
 	Rules []rbacv1.Rule `json:"rules"`
+	// The new bandwidth limits for the role. The current limits are kept when this is
+	// not provided, and an empty object removes them.
+	BandwidthLimits *rbacv1.BandwidthLimits `json:"bandwidthLimits,omitempty"`
+	// Whether to forbid microphone capture for users bound to the role. The current
+	// setting is kept when this is not provided.
+	DenyAudioCapture *bool `json:"denyAudioCapture,omitempty"`
 }
 
 // GetAnnotations returns the annotations provided in the request
 func (r *UpdateRoleRequest) GetAnnotations() map[string]string { return r.Annotations }
 
+// GetBandwidthLimits returns the bandwidth limits provided in the request
+func (r *UpdateRoleRequest) GetBandwidthLimits() *rbacv1.BandwidthLimits { return r.BandwidthLimits }
+
 // GetRules returns the rules for an update role request, or a single-element slice with
 // a deny-all rule if none are provided.
 func (r *UpdateRoleRequest) GetRules() []rbacv1.Rule {
@@ -232,13 +500,29 @@ func (r *UpdateRoleRequest) GetRules() []rbacv1.Rule {
 // Validate the UpdateRoleRequest
 func (r *UpdateRoleRequest) Validate() error {
 	for _, rule := range r.Rules {
-		if err := validatePatterns(rule.ResourcePatterns); err != nil {
+		if err := validateRule(rule); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
+// validateRule returns an error if the effect, conditions or any of the resource patterns
+// of the given rule are invalid.
+func validateRule(rule rbacv1.Rule) error {
+	switch rule.Effect {
+	case "", rbacv1.EffectAllow, rbacv1.EffectDeny:
+	default:
+		return fmt.Errorf("%s is an invalid rule effect", rule.Effect)
+	}
+	if rule.Conditions != nil {
+		if err := rule.Conditions.Validate(); err != nil {
+			return err
+		}
+	}
+	return validatePatterns(rule.ResourcePatterns)
+}
+
 // validatePatterns takes a list of regexes and returns an error if any of them
 // are invalid.
 func validatePatterns(patterns []string) error {
@@ -250,6 +534,92 @@ func validatePatterns(patterns []string) error {
 	return nil
 }
 
+// AccessReviewRequest asks whether a user is allowed to perform the given actions.
+type AccessReviewRequest struct {
+	// The user to review access for. Defaults to the requesting user.
+	User string `json:"user,omitempty"`
+	// The actions to evaluate against the user's roles.
+	Actions []APIAction `json:"actions"`
+	// The time to evaluate rule conditions at in RFC3339 format. Defaults to the
+	// time of the request.
+	Time string `json:"time,omitempty"`
+	// The client address to evaluate rule conditions against. Defaults to the
+	// address of the requesting client.
+	SourceIP string `json:"sourceIP,omitempty"`
+}
+
+// Validate the AccessReviewRequest
+func (r *AccessReviewRequest) Validate() error {
+	if len(r.Actions) == 0 {
+		return errors.New("At least one action is required")
+	}
+	for _, action := range r.Actions {
+		if action.Verb == "" || action.ResourceType == "" {
+			return errors.New("A verb and resource type are required for every action")
+		}
+	}
+	if r.Time != "" {
+		if _, err := time.Parse(time.RFC3339, r.Time); err != nil {
+			return fmt.Errorf("%s is an invalid time: %s", r.Time, err.Error())
+		}
+	}
+	if r.SourceIP != "" && net.ParseIP(r.SourceIP) == nil {
+		return fmt.Errorf("%s is an invalid IP address", r.SourceIP)
+	}
+	return nil
+}
+
+// GetTime returns the time to evaluate rule conditions at, or the zero time if
+// it was not provided.
+func (r *AccessReviewRequest) GetTime() time.Time {
+	t, _ := time.Parse(time.RFC3339, r.Time)
+	return t
+}
+
+// GetSourceIP returns the client address to evaluate rule conditions against, or
+// nil if it was not provided.
+func (r *AccessReviewRequest) GetSourceIP() net.IP { return net.ParseIP(r.SourceIP) }
+
+// AccessReviewResponse contains the decisions for each action in an AccessReviewRequest.
+type AccessReviewResponse struct {
+	// The user the actions were evaluated for
+	User string `json:"user"`
+	// The results for each action, in the order they were requested
+	Results []*AccessReviewResult `json:"results"`
+}
+
+// AccessReviewResult is the decision for a single action in an AccessReviewRequest.
+type AccessReviewResult struct {
+	// The action that was evaluated
+	Action APIAction `json:"action"`
+	// Whether the action is allowed
+	Allowed bool `json:"allowed"`
+	// Why the action is not allowed
+	Reason string `json:"reason,omitempty"`
+	// The role with the rule that denied the action, if any
+	DeniedBy string `json:"deniedBy,omitempty"`
+	// The rules that match the action, including those whose conditions were not met
+	MatchedRules []*RuleMatch `json:"matchedRules,omitempty"`
+	// When the action is not allowed, a rule that would allow it if added to one
+	// of the user's roles
+	MissingRule *rbacv1.Rule `json:"missingRule,omitempty"`
+}
+
+// RuleMatch describes a role rule that matches an action.
+type RuleMatch struct {
+	// The name of the role containing the rule
+	Role string `json:"role"`
+	// The index of the rule in the role
+	Index int `json:"index"`
+	// The rule that matches the action
+	Rule rbacv1.Rule `json:"rule"`
+	// Whether the rule applied to the decision, which is false when its conditions
+	// were not met
+	Applied bool `json:"applied"`
+	// The condition of the rule that was not met, if any
+	FailedCondition string `json:"failedCondition,omitempty"`
+}
+
 // CreateSessionRequest requests a new desktop session with the givin parameters.
 type CreateSessionRequest struct {
 	// The template to create the session from.
@@ -21,7 +21,9 @@ package types
 
 import (
 	"fmt"
+	"net"
 	"strings"
+	"time"
 
 	"github.com/golang-jwt/jwt"
 
@@ -68,11 +70,15 @@ type AuthResult struct {
 	RedirectURL string
 	// The provider can supply additional data to encode into the generated JWT.
 	Data map[string]string
-	// In the case of OIDC, the refresh tokens cannot be used. Because when the user
-	// tries to use them, there is no way to query the provider for the user's information
-	// without initializing a new auth flow. For now, the provider can set this to false to
-	// signal to the server that a refresh is not possible.
+	// Providers backed by an external identity provider can only refresh a session when
+	// they can query it for the user's information without a new auth flow, e.g. OIDC with
+	// a stored refresh token. The provider sets this to true to signal to the server that a
+	// refresh is not possible.
 	RefreshNotSupported bool
+	// Providers that keep state for each login, such as the tokens issued to it by an
+	// external identity provider, set the ID the login is started with so the state can
+	// be found again when the login is refreshed or ended.
+	LoginID string
 }
 
 // JWTClaims represents the claims used when issuing JWT tokens.
@@ -85,10 +91,29 @@ type JWTClaims struct {
 	Renewable bool `json:"renewable"`
 	// Additional data that was provided by the authentication provider
 	Data map[string]string `json:"data"`
+	// The ID of the login the session belongs to, if a refresh token was issued with it.
+	LoginID string `json:"loginID,omitempty"`
+	// The ID of the personal API token the session was created from, if any.
+	APITokenID string `json:"apiTokenID,omitempty"`
+	// Whether the API token the session was created from is limited to a subset of
+	// the user's rules.
+	APITokenScoped bool `json:"apiTokenScoped,omitempty"`
+	// The name of the user acting as the session user with the Impersonate-User header.
+	// This is set per request and never signed into a token.
+	ImpersonatedBy string `json:"impersonatedBy,omitempty"`
 	// The standard JWT claims
 	jwt.StandardClaims
 }
 
+// GetRealUsername returns the name of the user who authenticated for the session. It
+// differs from the session user when the request impersonates another user.
+func (c *JWTClaims) GetRealUsername() string {
+	if c.ImpersonatedBy != "" {
+		return c.ImpersonatedBy
+	}
+	return c.User.GetName()
+}
+
 // VDIUser represents a user in kVDI. It is the auth providers responsibility
 // to take an authentication request and generate a JWT with claims defining
 // this object.
@@ -100,16 +125,37 @@ type VDIUser struct {
 	Roles []*VDIUserRole `json:"roles"`
 	// MFA status for the user
 	MFA *UserMFAStatus `json:"mfa"`
+	// When the lockout from too many failed logins expires, if the user is
+	// currently locked out
+	LockedUntil *time.Time `json:"lockedUntil,omitempty"`
+	// Whether the user's password has expired and must be changed before their
+	// session is authorized
+	PasswordChangeRequired bool `json:"passwordChangeRequired,omitempty"`
 	// Any active sessions for the user - new field that is only populated on a
 	// /api/whoami request.
 	Sessions []*DesktopSession `json:"sessions,omitempty"`
 }
 
+// MFA methods that can be enrolled for a user.
+const (
+	// MFAMethodTOTP is a time-based one-time password.
+	MFAMethodTOTP = "totp"
+	// MFAMethodWebAuthn is a WebAuthn authenticator.
+	MFAMethodWebAuthn = "webauthn"
+)
+
 // UserMFAStatus contains information about the MFA configurations
 // for the user.
 type UserMFAStatus struct {
 	Enabled  bool `json:"enabled"`
 	Verified bool `json:"verified"`
+	// The methods the user has enrolled and can authorize with.
+	Methods []string `json:"methods,omitempty"`
+	// The number of unused recovery codes the user has.
+	RecoveryCodesRemaining int `json:"recoveryCodesRemaining,omitempty"`
+	// Whether an administrator reset the user's MFA and they must enroll again
+	// before their next login is authorized.
+	EnrollmentRequired bool `json:"enrollmentRequired,omitempty"`
 }
 
 // GetName returns the name of a VDIUser.
@@ -139,6 +185,26 @@ type APIAction struct {
 	ResourceName string `json:"resourceName"`
 	// The namespace of the targeted resource
 	ResourceNamespace string `json:"resourceNamespace,omitempty"`
+	// The context of the request the action is evaluated for. It is used for rules
+	// with conditions and defaults to the current time and an unknown client address.
+	Context *ActionContext `json:"-"`
+}
+
+// ActionContext contains the details of a request that rule conditions are evaluated against.
+type ActionContext struct {
+	// The time the request was made
+	Time time.Time
+	// The address of the client, or nil if it is unknown
+	SourceIP net.IP
+}
+
+// GetContext returns the context of the action, or one for the current time and
+// an unknown client address if it is not set.
+func (a *APIAction) GetContext() *ActionContext {
+	if a.Context != nil {
+		return a.Context
+	}
+	return &ActionContext{Time: time.Now()}
 }
 
 // ResourceNameString returns a user friendly resource name string
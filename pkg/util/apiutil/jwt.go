@@ -26,25 +26,33 @@ import (
 	"github.com/golang-jwt/jwt"
 	"github.com/mitchellh/mapstructure"
 
+	"github.com/kvdi/kvdi/pkg/auth/signing"
 	"github.com/kvdi/kvdi/pkg/types"
 	"github.com/kvdi/kvdi/pkg/util/errors"
 )
 
 // GenerateJWT will create a new JWT with the given user object's fields
-// embedded in the claims.
-func GenerateJWT(secret []byte, authResult *types.AuthResult, authorized bool, sessionLength time.Duration) (types.JWTClaims, string, error) {
+// embedded in the claims, signed with the given key. The login ID is empty when no
+// refresh token was issued with the token.
+func GenerateJWT(key *signing.Key, authResult *types.AuthResult, authorized bool, loginID string, sessionLength time.Duration) (types.JWTClaims, string, error) {
 	claims := types.JWTClaims{
 		User:       authResult.User,
 		Data:       authResult.Data,
 		Authorized: authorized,
 		Renewable:  !authResult.RefreshNotSupported,
+		LoginID:    loginID,
 		StandardClaims: jwt.StandardClaims{
 			ExpiresAt: time.Now().Add(sessionLength).Unix(),
 			IssuedAt:  time.Now().Unix(),
 		},
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString(secret)
+	signer, err := key.Signer()
+	if err != nil {
+		return claims, "", err
+	}
+	token := jwt.NewWithClaims(key.SigningMethod(), claims)
+	token.Header["kid"] = key.ID
+	tokenString, err := token.SignedString(signer)
 	return claims, tokenString, err
 }
 
@@ -55,17 +63,25 @@ var errTokenExpiredError = errors.New("Token provided in the request has expired
 var errTokenSigInvalidError = errors.New("Token provided in the request has an invalid signature")
 
 // DecodeAndVerifyJWT will decode the provided JWT and verify the validity of its claims.
+// The key the token was signed with is looked up by its kid header with the given function.
 // If the claims are valid, they are returned, otherwise an error with the reason why
 // they are invalid.
-func DecodeAndVerifyJWT(secret []byte, authToken string) (*types.JWTClaims, error) {
+func DecodeAndVerifyJWT(keyFunc func(kid string) (*signing.Key, error), authToken string) (*types.JWTClaims, error) {
 	// parse the token
 	parser := &jwt.Parser{UseJSONNumber: true}
 	token, err := parser.Parse(authToken, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+		kid, ok := token.Header["kid"].(string)
+		if !ok {
+			return nil, errors.New("no key ID on token")
+		}
+		key, err := keyFunc(kid)
+		if err != nil {
+			return nil, err
+		}
+		if token.Method.Alg() != key.SigningMethod().Alg() {
 			return nil, errors.New("incorrect signing algorithm on token")
 		}
-		// use cache for the JWT secret, since we use it for every request
-		return secret, nil
+		return key.PublicKey()
 	})
 	// Check if token is nil and return error. The error will also be populated
 	// if the token was parsed successfully but is invalid.
@@ -105,7 +121,9 @@ func DecodeAndVerifyJWT(secret []byte, authToken string) (*types.JWTClaims, erro
 	session := &types.JWTClaims{}
 	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
 		TagName: "json",
-		Result:  session,
+		// the standard claims are embedded in the session
+		Squash: true,
+		Result: session,
 	})
 	if err != nil {
 		return nil, err
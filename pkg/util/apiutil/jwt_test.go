@@ -20,13 +20,35 @@ along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
 package apiutil
 
 import (
+	"errors"
 	"testing"
 	"time"
 
+	appv1 "github.com/kvdi/kvdi/apis/app/v1"
+	"github.com/kvdi/kvdi/pkg/auth/signing"
 	"github.com/kvdi/kvdi/pkg/types"
 )
 
-var secret = []byte("test-secret")
+var testKey = mustNewKey(appv1.TokenSigningRS256)
+
+func mustNewKey(alg appv1.TokenSigningAlgorithm) *signing.Key {
+	key, err := signing.NewKey(alg)
+	if err != nil {
+		panic(err)
+	}
+	return key
+}
+
+func keyFunc(keys ...*signing.Key) func(string) (*signing.Key, error) {
+	return func(kid string) (*signing.Key, error) {
+		for _, key := range keys {
+			if key.ID == kid {
+				return key, nil
+			}
+		}
+		return nil, errors.New("unknown key")
+	}
+}
 
 func TestGenerateJWT(t *testing.T) {
 	authResult := &types.AuthResult{
@@ -34,7 +56,7 @@ func TestGenerateJWT(t *testing.T) {
 			Name: "test-user",
 		},
 	}
-	claims, token, err := GenerateJWT(secret, authResult, true, time.Duration(30)*time.Second)
+	claims, token, err := GenerateJWT(testKey, authResult, true, "", time.Duration(30)*time.Second)
 	if err != nil {
 		t.Fatal("Expected no error generating JWT")
 	}
@@ -50,11 +72,11 @@ func TestGenerateJWT(t *testing.T) {
 
 func mustGenerateJWT(t *testing.T, authorized bool, duration time.Duration) string {
 	t.Helper()
-	_, token, err := GenerateJWT(secret, &types.AuthResult{
+	_, token, err := GenerateJWT(testKey, &types.AuthResult{
 		User: &types.VDIUser{
 			Name: "test-user",
 		},
-	}, authorized, duration)
+	}, authorized, "test-login", duration)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -63,7 +85,7 @@ func mustGenerateJWT(t *testing.T, authorized bool, duration time.Duration) stri
 
 func mustDecodeAndVerifyJWT(t *testing.T, token string) *types.JWTClaims {
 	t.Helper()
-	claims, err := DecodeAndVerifyJWT(secret, token)
+	claims, err := DecodeAndVerifyJWT(keyFunc(testKey), token)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -86,6 +108,12 @@ func TestDecodeAndVerifyJWT(t *testing.T) {
 	if claims.User.Name != "test-user" {
 		t.Error("Expected username to be 'test-user', got:", claims.User.Name)
 	}
+	if claims.LoginID != "test-login" {
+		t.Error("Expected login ID to be 'test-login', got:", claims.LoginID)
+	}
+	if claims.IssuedAt == 0 || claims.ExpiresAt <= claims.IssuedAt {
+		t.Error("Expected the standard claims to be decoded, got", claims.StandardClaims)
+	}
 
 	// non-authorized token
 	token = mustGenerateJWT(t, false, time.Duration(10)*time.Second)
@@ -97,14 +125,14 @@ func TestDecodeAndVerifyJWT(t *testing.T) {
 	// invalid token test cases
 
 	// something not even readable
-	_, err = DecodeAndVerifyJWT(secret, "fuckeduptoken")
+	_, err = DecodeAndVerifyJWT(keyFunc(testKey), "fuckeduptoken")
 	if err == nil {
 		t.Error("Expected error trying to parse a bad token, got nil")
 	}
 
 	// mess up the signature
 	token = mustGenerateJWT(t, true, time.Duration(10)*time.Second)
-	_, err = DecodeAndVerifyJWT(secret, token[:len(token)-5])
+	_, err = DecodeAndVerifyJWT(keyFunc(testKey), token[:len(token)-5])
 	if err == nil {
 		t.Error("Expected error from bad signature, got nil")
 	} else if err != errTokenSigInvalidError {
@@ -114,7 +142,7 @@ func TestDecodeAndVerifyJWT(t *testing.T) {
 	// expired token
 	token = mustGenerateJWT(t, true, time.Duration(1)*time.Second)
 	time.Sleep(2 * time.Second)
-	_, err = DecodeAndVerifyJWT(secret, token)
+	_, err = DecodeAndVerifyJWT(keyFunc(testKey), token)
 	if err == nil {
 		t.Error("Expected error from expired token, got nil")
 	} else if err != errTokenExpiredError {
@@ -123,10 +151,43 @@ func TestDecodeAndVerifyJWT(t *testing.T) {
 
 	// mess up the data
 	token = mustGenerateJWT(t, true, time.Duration(10)*time.Second)
-	_, err = DecodeAndVerifyJWT(secret, token[3:])
+	_, err = DecodeAndVerifyJWT(keyFunc(testKey), token[3:])
 	if err == nil {
 		t.Error("Expected error from malformed data, got nil")
 	} else if err != errTokenMalformedError {
 		t.Error("Expected malformed token error, got:", err)
 	}
 }
+
+func TestDecodeAndVerifyJWTKeys(t *testing.T) {
+	authResult := &types.AuthResult{User: &types.VDIUser{Name: "test-user"}}
+	edKey := mustNewKey(appv1.TokenSigningEdDSA)
+
+	// tokens verify with the key matching their kid
+	_, token, err := GenerateJWT(edKey, authResult, true, "", time.Duration(10)*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := DecodeAndVerifyJWT(keyFunc(testKey, edKey), token); err != nil {
+		t.Error("Expected EdDSA token to verify, got:", err)
+	}
+
+	// tokens signed with a key that is not known are rejected
+	if _, err := DecodeAndVerifyJWT(keyFunc(testKey), token); err == nil {
+		t.Error("Expected error from unknown signing key, got nil")
+	}
+
+	// a known kid with a different algorithm is rejected
+	otherKey := *testKey
+	otherKey.ID = edKey.ID
+	if _, err := DecodeAndVerifyJWT(keyFunc(&otherKey), token); err == nil {
+		t.Error("Expected error from mismatched signing algorithm, got nil")
+	}
+
+	// a known kid with a different key is rejected
+	rotatedKey := mustNewKey(appv1.TokenSigningEdDSA)
+	rotatedKey.ID = edKey.ID
+	if _, err := DecodeAndVerifyJWT(keyFunc(rotatedKey), token); err != errTokenSigInvalidError {
+		t.Error("Expected bad signature error, got:", err)
+	}
+}
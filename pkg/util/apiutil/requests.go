@@ -20,7 +20,10 @@ along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
 package apiutil
 
 import (
+	"net"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/gorilla/context"
 	"github.com/gorilla/mux"
@@ -45,6 +48,13 @@ func GetRequestUserSession(r *http.Request) *types.JWTClaims {
 	return context.Get(r, ContextUserKey).(*types.JWTClaims)
 }
 
+// LookupRequestUserSession retrieves the user session from the request context, if the
+// request was authenticated.
+func LookupRequestUserSession(r *http.Request) (*types.JWTClaims, bool) {
+	sess, ok := context.Get(r, ContextUserKey).(*types.JWTClaims)
+	return sess, ok
+}
+
 // SetRequestObject sets the given interface to the decoded request object in the context.
 func SetRequestObject(r *http.Request, obj interface{}) {
 	context.Set(r, ContextRequestObjectKey, obj)
@@ -92,6 +102,31 @@ func GetUserFromRequest(r *http.Request) string {
 	return vars["user"]
 }
 
+// GetAPITokenFromRequest will retrieve the API token ID variable from a request path.
+func GetAPITokenFromRequest(r *http.Request) string {
+	vars := mux.Vars(r)
+	return vars["token"]
+}
+
+// GetLoginFromRequest will retrieve the login ID variable from a request path.
+func GetLoginFromRequest(r *http.Request) string {
+	vars := mux.Vars(r)
+	return vars["login"]
+}
+
+// GetSCIMIDFromRequest will retrieve the SCIM resource ID variable from a request path.
+func GetSCIMIDFromRequest(r *http.Request) string {
+	vars := mux.Vars(r)
+	return vars["id"]
+}
+
+// GetWebAuthnCredentialFromRequest will retrieve the WebAuthn credential ID variable from a
+// request path.
+func GetWebAuthnCredentialFromRequest(r *http.Request) string {
+	vars := mux.Vars(r)
+	return vars["credential"]
+}
+
 // GetRoleFromRequest will retrieve the role variable from a request path.
 func GetRoleFromRequest(r *http.Request) string {
 	vars := mux.Vars(r)
@@ -104,6 +139,65 @@ func GetTemplateFromRequest(r *http.Request) string {
 	return vars["template"]
 }
 
+// ResolveClientAddr returns the IP address of the client that made the given request.
+// The X-Forwarded-For and X-Real-IP headers are only honored when the connected peer is
+// in one of the trusted proxy networks. X-Forwarded-For is walked from the right, and
+// the first address that is not a trusted proxy is returned.
+func ResolveClientAddr(r *http.Request, trustedProxies []*net.IPNet) string {
+	peer, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		peer = r.RemoteAddr
+	}
+	if !isTrustedProxy(net.ParseIP(peer), trustedProxies) {
+		return peer
+	}
+	if fwd := r.Header.Values("X-Forwarded-For"); len(fwd) > 0 {
+		addrs := strings.Split(strings.Join(fwd, ","), ",")
+		for i := len(addrs) - 1; i >= 0; i-- {
+			ip := net.ParseIP(strings.TrimSpace(addrs[i]))
+			if ip == nil {
+				// The chain is malformed beyond this point, so stop at the last hop
+				// that could be trusted
+				break
+			}
+			if !isTrustedProxy(ip, trustedProxies) {
+				return ip.String()
+			}
+		}
+		return peer
+	}
+	if ip := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP"))); ip != nil {
+		return ip.String()
+	}
+	return peer
+}
+
+func isTrustedProxy(ip net.IP, trustedProxies []*net.IPNet) bool {
+	if ip == nil {
+		return false
+	}
+	for _, ipnet := range trustedProxies {
+		if ipnet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// GetRequestActionContext returns the context that rule conditions are evaluated against
+// for the given request. The RemoteAddr is resolved from trusted proxy headers by the
+// API router before any handlers are called.
+func GetRequestActionContext(r *http.Request) *types.ActionContext {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return &types.ActionContext{
+		Time:     time.Now(),
+		SourceIP: net.ParseIP(host),
+	}
+}
+
 // GetGorillaPath will retrieve the URL path as it was configured in mux.
 func GetGorillaPath(r *http.Request) string {
 	rt := mux.CurrentRoute(r)
@@ -20,6 +20,7 @@ along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
 package apiutil
 
 import (
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -142,3 +143,35 @@ func TestGorillaHelpers(t *testing.T) {
 	rr = httptest.NewRecorder()
 	r.ServeHTTP(rr, req)
 }
+
+func TestResolveClientAddr(t *testing.T) {
+	_, proxies, _ := net.ParseCIDR("10.0.0.0/8")
+	trusted := []*net.IPNet{proxies}
+
+	tcs := []struct {
+		name    string
+		remote  string
+		headers map[string]string
+		trusted []*net.IPNet
+		expect  string
+	}{
+		{"no headers", "192.168.1.1:1234", nil, trusted, "192.168.1.1"},
+		{"no trusted proxies", "10.0.0.1:1234", map[string]string{"X-Forwarded-For": "1.2.3.4"}, nil, "10.0.0.1"},
+		{"untrusted peer", "192.168.1.1:1234", map[string]string{"X-Forwarded-For": "1.2.3.4"}, trusted, "192.168.1.1"},
+		{"trusted peer", "10.0.0.1:1234", map[string]string{"X-Forwarded-For": "1.2.3.4"}, trusted, "1.2.3.4"},
+		{"spoofed chain", "10.0.0.1:1234", map[string]string{"X-Forwarded-For": "1.2.3.4, 5.6.7.8, 10.0.0.2"}, trusted, "5.6.7.8"},
+		{"malformed chain", "10.0.0.1:1234", map[string]string{"X-Forwarded-For": "1.2.3.4, garbage"}, trusted, "10.0.0.1"},
+		{"real ip", "10.0.0.1:1234", map[string]string{"X-Real-IP": "1.2.3.4"}, trusted, "1.2.3.4"},
+		{"untrusted real ip", "192.168.1.1:1234", map[string]string{"X-Real-IP": "1.2.3.4"}, trusted, "192.168.1.1"},
+	}
+	for _, tc := range tcs {
+		req := mustNewRequest(t, "/")
+		req.RemoteAddr = tc.remote
+		for k, v := range tc.headers {
+			req.Header.Set(k, v)
+		}
+		if addr := ResolveClientAddr(req, tc.trusted); addr != tc.expect {
+			t.Errorf("%s: expected %s, got %s", tc.name, tc.expect, addr)
+		}
+	}
+}
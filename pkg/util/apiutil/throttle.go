@@ -0,0 +1,152 @@
+/*
+
+Copyright 2020,2021 Avi Zimmerman
+
+This file is part of kvdi.
+
+kvdi is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+kvdi is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package apiutil
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// ThrottledWriter implements an io.Writer that shapes the data written to it through
+// a set of token-bucket rate limiters. It is used by the kvdi API for enforcing
+// bandwidth limits on display/audio streams.
+type ThrottledWriter struct {
+	io.Writer
+
+	ctx        context.Context
+	limiters   []*rate.Limiter
+	priority   []*rate.Limiter
+	onThrottle func(time.Duration)
+}
+
+// NewThrottledWriter returns a new ThrottledWriter wrapping the given writer. Writes
+// waiting on a limiter are aborted when the given context is cancelled.
+func NewThrottledWriter(ctx context.Context, w io.Writer) *ThrottledWriter {
+	return &ThrottledWriter{Writer: w, ctx: ctx}
+}
+
+// WithLimiters adds limiters that writes must wait on before proceeding.
+func (t *ThrottledWriter) WithLimiters(limiters ...*rate.Limiter) *ThrottledWriter {
+	t.limiters = append(t.limiters, nonNilLimiters(limiters)...)
+	return t
+}
+
+// WithPriorityLimiters adds limiters that writes are charged against, but never wait on.
+// This lets a high priority stream borrow ahead from a bucket shared with other streams,
+// which in turn will wait longer for their own writes.
+func (t *ThrottledWriter) WithPriorityLimiters(limiters ...*rate.Limiter) *ThrottledWriter {
+	t.priority = append(t.priority, nonNilLimiters(limiters)...)
+	return t
+}
+
+// OnThrottle sets a function to be called with the delay whenever a write is held back
+// by a limiter.
+func (t *ThrottledWriter) OnThrottle(fn func(time.Duration)) *ThrottledWriter {
+	t.onThrottle = fn
+	return t
+}
+
+// Write implements io.Writer. Large writes are split into chunks no bigger than the
+// smallest bucket so that they can always be satisfied.
+func (t *ThrottledWriter) Write(b []byte) (int, error) {
+	if len(t.limiters) == 0 && len(t.priority) == 0 {
+		return t.Writer.Write(b)
+	}
+	chunkSize := t.chunkSize(len(b))
+	var written int
+	for written < len(b) {
+		end := written + chunkSize
+		if end > len(b) {
+			end = len(b)
+		}
+		if err := t.wait(end - written); err != nil {
+			return written, err
+		}
+		n, err := t.Writer.Write(b[written:end])
+		written += n
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// wait blocks until n bytes are available in all of the limiters.
+func (t *ThrottledWriter) wait(n int) error {
+	now := time.Now()
+	for _, l := range t.priority {
+		l.ReserveN(now, n)
+	}
+	var delay time.Duration
+	reservations := make([]*rate.Reservation, 0, len(t.limiters))
+	for _, l := range t.limiters {
+		r := l.ReserveN(now, n)
+		if !r.OK() {
+			// The bucket was resized below the chunk size since it was computed
+			continue
+		}
+		reservations = append(reservations, r)
+		if d := r.DelayFrom(now); d > delay {
+			delay = d
+		}
+	}
+	if delay <= 0 {
+		return nil
+	}
+	if t.onThrottle != nil {
+		t.onThrottle(delay)
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-t.ctx.Done():
+		for _, r := range reservations {
+			r.Cancel()
+		}
+		return t.ctx.Err()
+	}
+}
+
+// chunkSize returns the largest write that can be satisfied by all of the limiters.
+func (t *ThrottledWriter) chunkSize(size int) int {
+	for _, l := range append(t.limiters, t.priority...) {
+		if burst := l.Burst(); burst > 0 && burst < size {
+			size = burst
+		}
+	}
+	return size
+}
+
+func nonNilLimiters(limiters []*rate.Limiter) []*rate.Limiter {
+	out := make([]*rate.Limiter, 0, len(limiters))
+	for _, l := range limiters {
+		if l != nil {
+			out = append(out, l)
+		}
+	}
+	return out
+}
@@ -0,0 +1,93 @@
+/*
+
+Copyright 2020,2021 Avi Zimmerman
+
+This file is part of kvdi.
+
+kvdi is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+kvdi is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package apiutil
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+func TestThrottledWriter(t *testing.T) {
+	var buf bytes.Buffer
+
+	// no limiters should pass through directly
+	w := NewThrottledWriter(context.Background(), &buf)
+	if n, err := w.Write([]byte("hello world")); err != nil {
+		t.Fatal(err)
+	} else if n != 11 {
+		t.Error("Expected 11 bytes written, got", n)
+	}
+
+	// a limiter with a full bucket should not throttle
+	buf.Reset()
+	var throttled bool
+	w = NewThrottledWriter(context.Background(), &buf).
+		WithLimiters(rate.NewLimiter(rate.Limit(1000), 100), nil).
+		OnThrottle(func(time.Duration) { throttled = true })
+	if _, err := w.Write(make([]byte, 100)); err != nil {
+		t.Fatal(err)
+	}
+	if throttled {
+		t.Error("Expected first write within burst to not be throttled")
+	}
+
+	// the next write should exceed the bucket and be chunked
+	if n, err := w.Write(make([]byte, 150)); err != nil {
+		t.Fatal(err)
+	} else if n != 150 {
+		t.Error("Expected 150 bytes written, got", n)
+	}
+	if !throttled {
+		t.Error("Expected write exceeding the bucket to be throttled")
+	}
+	if buf.Len() != 250 {
+		t.Error("Expected 250 bytes in the buffer, got", buf.Len())
+	}
+}
+
+func TestThrottledWriterPriority(t *testing.T) {
+	shared := rate.NewLimiter(rate.Limit(100), 100)
+
+	// a priority writer drains the shared bucket without waiting
+	var throttled bool
+	priority := NewThrottledWriter(context.Background(), &bytes.Buffer{}).
+		WithPriorityLimiters(shared).
+		OnThrottle(func(time.Duration) { throttled = true })
+	if _, err := priority.Write(make([]byte, 200)); err != nil {
+		t.Fatal(err)
+	}
+	if throttled {
+		t.Error("Expected priority writes to never be throttled")
+	}
+
+	// a regular writer on the same bucket is now held back until cancelled
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	regular := NewThrottledWriter(ctx, &bytes.Buffer{}).WithLimiters(shared)
+	if _, err := regular.Write(make([]byte, 10)); err == nil {
+		t.Error("Expected write to be cancelled while waiting on the shared bucket")
+	}
+}
@@ -23,6 +23,9 @@ import (
 	"archive/tar"
 	"compress/gzip"
 	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
@@ -35,6 +38,7 @@ import (
 	"time"
 
 	"github.com/go-logr/logr"
+	"golang.org/x/crypto/argon2"
 	"golang.org/x/crypto/bcrypt"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
@@ -143,21 +147,113 @@ func GeneratePassword(length int) (string, error) {
 	return string(buf), nil
 }
 
-// hashCost is the cost to use for generating salts from passwords
-var hashCost = bcrypt.MinCost
+// argon2Params are the argon2id parameters used when hashing passwords. Hashes
+// created with different parameters are reported as needing a rehash.
+var argon2Params = struct {
+	Time    uint32
+	Memory  uint32
+	Threads uint8
+	KeyLen  uint32
+	SaltLen int
+}{
+	Time:    3,
+	Memory:  64 * 1024,
+	Threads: 4,
+	KeyLen:  32,
+	SaltLen: 16,
+}
+
+// argon2idPrefix is the prefix of password hashes in the PHC string format produced
+// by HashPassword.
+const argon2idPrefix = "$argon2id$"
 
-// HashPassword creates a salt from a password for storing in a database
+// HashPassword creates an argon2id hash from a password for storing in a database.
+// The hash is returned in the PHC string format.
 func HashPassword(passw string) (string, error) {
-	hash, err := bcrypt.GenerateFromPassword([]byte(passw), hashCost)
-	if err != nil {
+	if argon2Params.Time < 1 || argon2Params.Threads < 1 {
+		return "", fmt.Errorf("invalid argon2id parameters: t=%d p=%d", argon2Params.Time, argon2Params.Threads)
+	}
+	salt := make([]byte, argon2Params.SaltLen)
+	if _, err := rand.Read(salt); err != nil {
 		return "", err
 	}
-	return string(hash), nil
+	key := argon2.IDKey([]byte(passw), salt, argon2Params.Time, argon2Params.Memory, argon2Params.Threads, argon2Params.KeyLen)
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2idPrefix, argon2.Version,
+		argon2Params.Memory, argon2Params.Time, argon2Params.Threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
 }
 
-// PasswordMatchesHash returns true if the given password matches the given salt.
+// PasswordMatchesHash returns true if the given password matches the given hash. Both
+// argon2id and legacy bcrypt hashes are supported.
 func PasswordMatchesHash(passw, hash string) bool {
-	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(passw)) == nil
+	if !strings.HasPrefix(hash, argon2idPrefix) {
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(passw)) == nil
+	}
+	hashed, err := parseArgon2idHash(hash)
+	if err != nil {
+		return false
+	}
+	key := argon2.IDKey([]byte(passw), hashed.salt, hashed.time, hashed.memory, hashed.threads, uint32(len(hashed.key)))
+	return subtle.ConstantTimeCompare(key, hashed.key) == 1
+}
+
+// PasswordHashNeedsRehash returns true if the given hash was not created by HashPassword
+// with the current parameters, and should be replaced the next time the password is known.
+func PasswordHashNeedsRehash(hash string) bool {
+	if !strings.HasPrefix(hash, argon2idPrefix) {
+		return true
+	}
+	hashed, err := parseArgon2idHash(hash)
+	if err != nil {
+		return true
+	}
+	return hashed.time != argon2Params.Time ||
+		hashed.memory != argon2Params.Memory ||
+		hashed.threads != argon2Params.Threads ||
+		uint32(len(hashed.key)) != argon2Params.KeyLen
+}
+
+type argon2idHash struct {
+	time, memory uint32
+	threads      uint8
+	salt, key    []byte
+}
+
+// parseArgon2idHash parses an argon2id hash in the PHC string format.
+func parseArgon2idHash(hash string) (*argon2idHash, error) {
+	// "", "argon2id", "v=19", "m=65536,t=3,p=4", salt, key
+	fields := strings.Split(hash, "$")
+	if len(fields) != 6 || fields[1] != "argon2id" {
+		return nil, errors.New("malformed argon2id hash")
+	}
+	var version int
+	if _, err := fmt.Sscanf(fields[2], "v=%d", &version); err != nil {
+		return nil, err
+	}
+	if version != argon2.Version {
+		return nil, fmt.Errorf("unsupported argon2 version %d", version)
+	}
+	out := &argon2idHash{}
+	if _, err := fmt.Sscanf(fields[3], "m=%d,t=%d,p=%d", &out.memory, &out.time, &out.threads); err != nil {
+		return nil, err
+	}
+	if out.time < 1 || out.threads < 1 {
+		return nil, errors.New("malformed argon2id parameters")
+	}
+	var err error
+	if out.salt, err = base64.RawStdEncoding.DecodeString(fields[4]); err != nil {
+		return nil, err
+	}
+	if out.key, err = base64.RawStdEncoding.DecodeString(fields[5]); err != nil {
+		return nil, err
+	}
+	if len(out.key) == 0 {
+		return nil, errors.New("malformed argon2id hash")
+	}
+	return out, nil
 }
 
 // StopRetry is returned to tell the Retry function to stop retrying.
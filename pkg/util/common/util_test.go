@@ -24,6 +24,7 @@ import (
 	"reflect"
 	"testing"
 
+	"golang.org/x/crypto/bcrypt"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
 )
 
@@ -157,10 +158,48 @@ func TestPasswordFunctions(t *testing.T) {
 		t.Error("Expected hash to match password")
 	}
 
-	// override hash cost to force a hashing error
-	hashCost = 10000000
+	if PasswordMatchesHash("wrong-password", hash) {
+		t.Error("Expected hash to not match the wrong password")
+	}
+
+	if PasswordHashNeedsRehash(hash) {
+		t.Error("Expected a new hash to not need a rehash")
+	}
+
+	// legacy bcrypt hashes can still be verified, but should be replaced
+	legacy, err := bcrypt.GenerateFromPassword([]byte(passw), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !PasswordMatchesHash(passw, string(legacy)) {
+		t.Error("Expected legacy hash to match password")
+	}
+	if !PasswordHashNeedsRehash(string(legacy)) {
+		t.Error("Expected legacy hash to need a rehash")
+	}
+
+	// malformed hashes never match
+	for _, malformed := range []string{"", "$argon2id$", "$argon2id$v=19$m=65536,t=0,p=4$c2FsdA$a2V5", hash[:len(hash)-4] + "$"} {
+		if PasswordMatchesHash(passw, malformed) {
+			t.Errorf("Expected malformed hash %q to not match", malformed)
+		}
+	}
+
+	// hashes with outdated parameters should be replaced
+	argon2Params.Time++
+	if !PasswordHashNeedsRehash(hash) {
+		t.Error("Expected hash with old parameters to need a rehash")
+	}
+	if !PasswordMatchesHash(passw, hash) {
+		t.Error("Expected hash with old parameters to still match password")
+	}
+	argon2Params.Time--
+
+	// override the parameters to force a hashing error
+	argon2Params.Time = 0
+	defer func() { argon2Params.Time = 3 }()
 	if _, err = HashPassword(passw); err == nil {
-		t.Error("Expected error for using invalid cost")
+		t.Error("Expected error for using invalid parameters")
 	}
 }
 
@@ -27,6 +27,12 @@ import (
 const (
 	userNotFoundFormat = "User '%s' not found in the cluster"
 	roleNotFoundFormat = "Role '%s' not found in the cluster"
+
+	apiTokenNotFoundFormat = "API token '%s' not found"
+
+	webAuthnCredentialNotFoundFormat = "WebAuthn credential '%s' not found"
+
+	loginNotFoundFormat = "Login '%s' not found"
 )
 
 // UserNotFoundError is an error signaling that the requested user was not found.
@@ -78,3 +84,81 @@ func IsRoleNotFoundError(err error) bool {
 	}
 	return false
 }
+
+// APITokenNotFoundError is an error signaling that the requested API token was not found.
+type APITokenNotFoundError struct {
+	errMsg string
+}
+
+// Error implements the error interface.
+func (r *APITokenNotFoundError) Error() string {
+	return r.errMsg
+}
+
+// NewAPITokenNotFoundError returns a new APITokenNotFoundError for the provided token ID.
+func NewAPITokenNotFoundError(id string) error {
+	return &APITokenNotFoundError{
+		errMsg: fmt.Sprintf(apiTokenNotFoundFormat, id),
+	}
+}
+
+// IsAPITokenNotFoundError returns true if the given error interface is an APITokenNotFoundError.
+func IsAPITokenNotFoundError(err error) bool {
+	if _, ok := err.(*APITokenNotFoundError); ok {
+		return true
+	}
+	return false
+}
+
+// WebAuthnCredentialNotFoundError is an error signaling that the requested WebAuthn credential
+// was not found.
+type WebAuthnCredentialNotFoundError struct {
+	errMsg string
+}
+
+// Error implements the error interface.
+func (r *WebAuthnCredentialNotFoundError) Error() string {
+	return r.errMsg
+}
+
+// NewWebAuthnCredentialNotFoundError returns a new WebAuthnCredentialNotFoundError for the
+// provided credential ID.
+func NewWebAuthnCredentialNotFoundError(id string) error {
+	return &WebAuthnCredentialNotFoundError{
+		errMsg: fmt.Sprintf(webAuthnCredentialNotFoundFormat, id),
+	}
+}
+
+// IsWebAuthnCredentialNotFoundError returns true if the given error interface is a
+// WebAuthnCredentialNotFoundError.
+func IsWebAuthnCredentialNotFoundError(err error) bool {
+	if _, ok := err.(*WebAuthnCredentialNotFoundError); ok {
+		return true
+	}
+	return false
+}
+
+// LoginNotFoundError is an error signaling that the requested login was not found.
+type LoginNotFoundError struct {
+	errMsg string
+}
+
+// Error implements the error interface.
+func (r *LoginNotFoundError) Error() string {
+	return r.errMsg
+}
+
+// NewLoginNotFoundError returns a new LoginNotFoundError for the provided login ID.
+func NewLoginNotFoundError(id string) error {
+	return &LoginNotFoundError{
+		errMsg: fmt.Sprintf(loginNotFoundFormat, id),
+	}
+}
+
+// IsLoginNotFoundError returns true if the given error interface is a LoginNotFoundError.
+func IsLoginNotFoundError(err error) bool {
+	if _, ok := err.(*LoginNotFoundError); ok {
+		return true
+	}
+	return false
+}
@@ -54,4 +54,43 @@ func TestRethinkDBErrors(t *testing.T) {
 		t.Error("Generic error should not evaluate to RoleNotFoundError")
 	}
 
+	// APITokenNotFoundError
+
+	tokenNotFound := NewAPITokenNotFoundError("fakeToken")
+	if tokenNotFound.Error() != fmt.Sprintf(apiTokenNotFoundFormat, "fakeToken") {
+		t.Error("Error message for not found API token is malformed")
+	}
+	if !IsAPITokenNotFoundError(tokenNotFound) {
+		t.Error("Error should be valid APITokenNotFoundError")
+	}
+	if IsAPITokenNotFoundError(errors.New("fake error")) {
+		t.Error("Generic error should not evaluate to APITokenNotFoundError")
+	}
+
+	// WebAuthnCredentialNotFoundError
+
+	credNotFound := NewWebAuthnCredentialNotFoundError("fakeCredential")
+	if credNotFound.Error() != fmt.Sprintf(webAuthnCredentialNotFoundFormat, "fakeCredential") {
+		t.Error("Error message for not found WebAuthn credential is malformed")
+	}
+	if !IsWebAuthnCredentialNotFoundError(credNotFound) {
+		t.Error("Error should be valid WebAuthnCredentialNotFoundError")
+	}
+	if IsWebAuthnCredentialNotFoundError(errors.New("fake error")) {
+		t.Error("Generic error should not evaluate to WebAuthnCredentialNotFoundError")
+	}
+
+	// LoginNotFoundError
+
+	loginNotFound := NewLoginNotFoundError("fakeLogin")
+	if loginNotFound.Error() != fmt.Sprintf(loginNotFoundFormat, "fakeLogin") {
+		t.Error("Error message for not found login is malformed")
+	}
+	if !IsLoginNotFoundError(loginNotFound) {
+		t.Error("Error should be valid LoginNotFoundError")
+	}
+	if IsLoginNotFoundError(errors.New("fake error")) {
+		t.Error("Generic error should not evaluate to LoginNotFoundError")
+	}
+
 }
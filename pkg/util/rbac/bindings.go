@@ -0,0 +1,181 @@
+/*
+
+Copyright 2020,2021 Avi Zimmerman
+
+This file is part of kvdi.
+
+kvdi is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+kvdi is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package rbac
+
+import (
+	"strings"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	appv1 "github.com/kvdi/kvdi/apis/app/v1"
+	v1 "github.com/kvdi/kvdi/apis/meta/v1"
+	rbacv1 "github.com/kvdi/kvdi/apis/rbac/v1"
+	"github.com/kvdi/kvdi/pkg/types"
+	"github.com/kvdi/kvdi/pkg/util/common"
+)
+
+// ProviderSCIM is the provider of subjects for groups provisioned over SCIM.
+const ProviderSCIM = "scim"
+
+// groupAnnotations maps providers to the annotation on VDIRoles that binds their groups.
+// They predate VDIRoleBindings and are still honored.
+var groupAnnotations = map[string]string{
+	string(appv1.AuthMethodLDAP):       v1.LDAPGroupRoleAnnotation,
+	string(appv1.AuthMethodOIDC):       v1.OIDCGroupRoleAnnotation,
+	string(appv1.AuthMethodSAML):       v1.SAMLGroupRoleAnnotation,
+	string(appv1.AuthMethodWebmesh):    v1.WebmeshGroupRoleAnnotation,
+	string(appv1.AuthMethodClientCert): v1.ClientCertGroupRoleAnnotation,
+	string(appv1.AuthMethodKubernetes): v1.KubernetesSubjectRoleAnnotation,
+	ProviderSCIM:                       v1.SCIMGroupRoleAnnotation,
+}
+
+// RoleBindings resolves the VDIRoles bound to the users and groups of the authentication
+// providers, from both VDIRoleBindings and the group annotations on the roles.
+type RoleBindings struct {
+	primaryProvider string
+	roles           []*rbacv1.VDIRole
+	bindings        []*rbacv1.VDIRoleBinding
+}
+
+// NewRoleBindings returns a RoleBindings for the given roles and bindings. Subjects
+// of the bindings without a provider belong to the given primary provider.
+func NewRoleBindings(primaryProvider string, roles []*rbacv1.VDIRole, bindings []*rbacv1.VDIRoleBinding) *RoleBindings {
+	return &RoleBindings{primaryProvider: primaryProvider, roles: roles, bindings: bindings}
+}
+
+// GetRoleBindings retrieves the roles and bindings of the given cluster.
+func GetRoleBindings(c client.Client, cluster *appv1.VDICluster) (*RoleBindings, error) {
+	roles, err := cluster.GetRoles(c)
+	if err != nil {
+		return nil, err
+	}
+	bindings, err := cluster.GetRoleBindings(c)
+	if err != nil {
+		return nil, err
+	}
+	var primaryProvider string
+	if methods := cluster.GetAuthMethods(); len(methods) > 0 {
+		primaryProvider = string(methods[0])
+	}
+	return NewRoleBindings(primaryProvider, roles, bindings), nil
+}
+
+// Roles returns all the VDIRoles of the cluster.
+func (r *RoleBindings) Roles() []*rbacv1.VDIRole { return r.roles }
+
+// BoundRoleNames returns the names of the roles bound to the given user of the provider,
+// or to any of the given groups. An empty username only matches groups.
+func (r *RoleBindings) BoundRoleNames(provider, username string, groups []string) []string {
+	names := make([]string, 0)
+	for _, role := range r.roles {
+		if r.roleIsBound(role, provider, username, groups) {
+			names = append(names, role.GetName())
+		}
+	}
+	return names
+}
+
+// BoundRoles returns the roles bound to the given user of the provider, or to any of the
+// given groups, converted to VDIUserRoles.
+func (r *RoleBindings) BoundRoles(provider, username string, groups []string) []*types.VDIUserRole {
+	userRoles := make([]*types.VDIUserRole, 0)
+	for _, role := range r.roles {
+		if r.roleIsBound(role, provider, username, groups) {
+			userRoles = append(userRoles, VDIRoleToUserRole(role))
+		}
+	}
+	return userRoles
+}
+
+// BoundGroups returns the groups of the provider bound to the role with the given name.
+// Groups bound without a provider are included for the primary provider.
+func (r *RoleBindings) BoundGroups(provider, roleName string) []string {
+	groups := make([]string, 0)
+	for _, role := range r.roles {
+		if role.GetName() != roleName {
+			continue
+		}
+		for _, group := range annotatedSubjects(role, provider) {
+			groups = common.AppendStringIfMissing(groups, group)
+		}
+	}
+	for _, binding := range r.bindings {
+		if binding.GetRoleRef() != roleName {
+			continue
+		}
+		for _, subject := range binding.GetSubjects() {
+			if subject.Kind == rbacv1.SubjectGroup && subject.BelongsTo(provider, r.primaryProvider) {
+				groups = common.AppendStringIfMissing(groups, subject.Name)
+			}
+		}
+	}
+	return groups
+}
+
+func (r *RoleBindings) roleIsBound(role *rbacv1.VDIRole, provider, username string, groups []string) bool {
+	annotated := annotatedSubjects(role, provider)
+	for _, group := range groups {
+		if common.StringSliceContains(annotated, group) {
+			return true
+		}
+	}
+	// the kubernetes annotation lists users and groups alike
+	if provider == string(appv1.AuthMethodKubernetes) && username != "" && common.StringSliceContains(annotated, username) {
+		return true
+	}
+	for _, binding := range r.bindings {
+		if binding.GetRoleRef() != role.GetName() {
+			continue
+		}
+		for _, subject := range binding.GetSubjects() {
+			if username != "" && subject.Matches(rbacv1.SubjectUser, provider, r.primaryProvider, username) {
+				return true
+			}
+			for _, group := range groups {
+				if subject.Matches(rbacv1.SubjectGroup, provider, r.primaryProvider, group) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// annotatedSubjects returns the subjects bound to the role by the annotation of the
+// given provider.
+func annotatedSubjects(role *rbacv1.VDIRole, provider string) []string {
+	annotation, ok := groupAnnotations[provider]
+	if !ok {
+		return nil
+	}
+	value, ok := role.GetAnnotations()[annotation]
+	if !ok {
+		return nil
+	}
+	subjects := make([]string, 0)
+	for _, subject := range strings.Split(value, v1.AuthGroupSeparator) {
+		if subject != "" {
+			subjects = append(subjects, subject)
+		}
+	}
+	return subjects
+}
@@ -0,0 +1,115 @@
+/*
+
+Copyright 2020,2021 Avi Zimmerman
+
+This file is part of kvdi.
+
+kvdi is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+kvdi is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package rbac
+
+import (
+	"reflect"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	v1 "github.com/kvdi/kvdi/apis/meta/v1"
+	rbacv1 "github.com/kvdi/kvdi/apis/rbac/v1"
+)
+
+func newTestRoleBindings() *RoleBindings {
+	role := func(name string, annotations map[string]string) *rbacv1.VDIRole {
+		return &rbacv1.VDIRole{ObjectMeta: metav1.ObjectMeta{Name: name, Annotations: annotations}}
+	}
+	binding := func(roleRef string, subjects ...rbacv1.Subject) *rbacv1.VDIRoleBinding {
+		return &rbacv1.VDIRoleBinding{Spec: rbacv1.VDIRoleBindingSpec{RoleRef: roleRef, Subjects: subjects}}
+	}
+	return NewRoleBindings(
+		"ldap",
+		[]*rbacv1.VDIRole{
+			role("annotated", map[string]string{
+				v1.LDAPGroupRoleAnnotation:         "admins;;devs",
+				v1.KubernetesSubjectRoleAnnotation: "system:serviceaccount:default:builder",
+			}),
+			role("bound-group", nil),
+			role("bound-user", nil),
+			role("primary-provider", nil),
+			role("unbound", nil),
+		},
+		[]*rbacv1.VDIRoleBinding{
+			binding("bound-group", rbacv1.Subject{Kind: rbacv1.SubjectGroup, Name: "ops", Provider: "ldap"}),
+			binding("bound-user", rbacv1.Subject{Kind: rbacv1.SubjectUser, Name: "alice", Provider: "oidc"}),
+			binding("primary-provider",
+				rbacv1.Subject{Kind: rbacv1.SubjectGroup, Name: "everyone"},
+				rbacv1.Subject{Kind: rbacv1.SubjectUser, Name: "bob"},
+			),
+			binding("missing", rbacv1.Subject{Kind: rbacv1.SubjectGroup, Name: "devs"}),
+		},
+	)
+}
+
+func TestBoundRoleNames(t *testing.T) {
+	bindings := newTestRoleBindings()
+
+	for _, tc := range []struct {
+		name               string
+		provider, username string
+		groups             []string
+		expected           []string
+	}{
+		{"annotated group", "ldap", "carol", []string{"devs"}, []string{"annotated"}},
+		{"annotation of other provider", "oidc", "carol", []string{"devs"}, []string{}},
+		{"bound group", "ldap", "carol", []string{"ops", "admins"}, []string{"annotated", "bound-group"}},
+		{"bound group of other provider", "saml", "carol", []string{"ops"}, []string{}},
+		{"bound user", "oidc", "alice", nil, []string{"bound-user"}},
+		{"user is not a group", "oidc", "", []string{"alice"}, []string{}},
+		{"subjects of the primary provider", "ldap", "bob", []string{"everyone"}, []string{"primary-provider"}},
+		{"subjects without a provider of other providers", "webmesh", "bob", []string{"everyone"}, []string{}},
+		{"kubernetes user annotation", "kubernetes", "system:serviceaccount:default:builder", nil, []string{"annotated"}},
+		{"binding to missing role", "ldap", "", []string{"devs"}, []string{"annotated"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			names := bindings.BoundRoleNames(tc.provider, tc.username, tc.groups)
+			if !reflect.DeepEqual(names, tc.expected) {
+				t.Errorf("Expected roles %v, got %v", tc.expected, names)
+			}
+			if roles := bindings.BoundRoles(tc.provider, tc.username, tc.groups); len(roles) != len(tc.expected) {
+				t.Errorf("Expected %d user roles, got %d", len(tc.expected), len(roles))
+			}
+		})
+	}
+}
+
+func TestBoundGroups(t *testing.T) {
+	bindings := newTestRoleBindings()
+
+	if groups := bindings.BoundGroups("ldap", "annotated"); !reflect.DeepEqual(groups, []string{"admins", "devs"}) {
+		t.Errorf("Expected annotated groups, got %v", groups)
+	}
+	if groups := bindings.BoundGroups("ldap", "bound-group"); !reflect.DeepEqual(groups, []string{"ops"}) {
+		t.Errorf("Expected bound groups, got %v", groups)
+	}
+	if groups := bindings.BoundGroups("ldap", "primary-provider"); !reflect.DeepEqual(groups, []string{"everyone"}) {
+		t.Errorf("Expected groups of the primary provider, got %v", groups)
+	}
+	if groups := bindings.BoundGroups("oidc", "primary-provider"); len(groups) != 0 {
+		t.Errorf("Expected groups without a provider not to be listed for other providers, got %v", groups)
+	}
+	if groups := bindings.BoundGroups("oidc", "bound-user"); len(groups) != 0 {
+		t.Errorf("Expected users not to be listed as groups, got %v", groups)
+	}
+}
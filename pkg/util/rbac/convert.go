@@ -24,13 +24,49 @@ package rbac
 import (
 	rbacv1 "github.com/kvdi/kvdi/apis/rbac/v1"
 	"github.com/kvdi/kvdi/pkg/types"
+
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
 )
 
+var rbacLogger = logf.Log.WithName("rbac")
+
 // VDIRoleToUserRole converts the given VDIRole to the VDIUserRole format. The VDIUserRole is
 // a condensed representation meant to be stored in JWTs.
+//
+// Roles applied directly to the cluster do not have their conditions validated by the API.
+// Allow rules with invalid conditions are dropped, and deny rules with invalid conditions
+// apply unconditionally, so that a broken condition never grants more than intended.
 func VDIRoleToUserRole(v *rbacv1.VDIRole) *types.VDIUserRole {
 	return &types.VDIUserRole{
 		Name:  v.GetName(),
-		Rules: v.GetRules(),
+		Rules: validRules(v.GetName(), v.GetRules()),
+	}
+}
+
+// validRules returns the given rules with those that have invalid conditions either
+// removed or made unconditional, depending on their effect.
+func validRules(roleName string, rules []rbacv1.Rule) []rbacv1.Rule {
+	if rules == nil {
+		return nil
+	}
+	out := make([]rbacv1.Rule, 0, len(rules))
+	for idx, rule := range rules {
+		if rule.Conditions == nil {
+			out = append(out, rule)
+			continue
+		}
+		err := rule.Conditions.Validate()
+		if err == nil {
+			out = append(out, rule)
+			continue
+		}
+		if !rule.IsDeny() {
+			rbacLogger.Error(err, "Ignoring allow rule with invalid conditions", "Role", roleName, "Rule", idx)
+			continue
+		}
+		rbacLogger.Error(err, "Applying deny rule with invalid conditions unconditionally", "Role", roleName, "Rule", idx)
+		rule.Conditions = nil
+		out = append(out, rule)
 	}
+	return out
 }
@@ -22,47 +22,153 @@ along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
 package rbac
 
 import (
+	"fmt"
+	"strings"
+
 	rbacv1 "github.com/kvdi/kvdi/apis/rbac/v1"
 	"github.com/kvdi/kvdi/pkg/types"
 )
 
+// Decision is the result of evaluating an action against a user's roles.
+type Decision struct {
+	// Whether the action is allowed
+	Allowed bool
+	// The role with the rule that denied the action, if any
+	DeniedBy string
+	// The conditions that were not met by allow rules matching the action, prefixed
+	// with the role of each rule
+	FailedConditions []string
+	// The rules in the user's roles that match the action
+	MatchedRules []*types.RuleMatch
+}
+
+// Reason returns a user friendly explanation of why the action was not allowed.
+func (d *Decision) Reason() string {
+	switch {
+	case d.Allowed:
+		return ""
+	case d.DeniedBy != "":
+		return fmt.Sprintf("denied by a rule in role %s", d.DeniedBy)
+	case len(d.FailedConditions) > 0:
+		return fmt.Sprintf("conditions not met: %s", strings.Join(d.FailedConditions, "; "))
+	}
+	return "no rule allows it"
+}
+
 // EvaluateUser will iterate the user's roles and return true if any of them have
-// a rule that allows the given action.
+// a rule that allows the given action, and none of them have a rule that denies it.
 func EvaluateUser(u *types.VDIUser, action *types.APIAction) bool {
-	for _, role := range u.Roles {
-		if ok := EvaluateRole(role, action); ok {
-			return true
+	return DecideUser(u, action).Allowed
+}
+
+// DecideUser evaluates the given action against the user's roles like EvaluateUser, and
+// returns the details of the decision.
+func DecideUser(u *types.VDIUser, action *types.APIAction) *Decision {
+	return decide(u.Roles, action)
+}
+
+func decide(roles []*types.VDIUserRole, action *types.APIAction) *Decision {
+	decision := &Decision{}
+	ctx := action.GetContext()
+	for _, role := range roles {
+		for idx, rule := range role.Rules {
+			if !ruleMatchesAction(rule, action) {
+				continue
+			}
+			match := &types.RuleMatch{Role: role.Name, Index: idx, Rule: rule}
+			decision.MatchedRules = append(decision.MatchedRules, match)
+			if err := ruleConditionsError(rule, ctx); err != nil {
+				match.FailedCondition = err.Error()
+				if !rule.IsDeny() {
+					decision.FailedConditions = append(decision.FailedConditions, fmt.Sprintf("role %s: %s", role.Name, err.Error()))
+				}
+				continue
+			}
+			match.Applied = true
+			if rule.IsDeny() {
+				if decision.DeniedBy == "" {
+					decision.DeniedBy = role.Name
+				}
+				continue
+			}
+			decision.Allowed = true
 		}
 	}
-	return false
+	if decision.DeniedBy != "" {
+		decision.Allowed = false
+	}
+	if decision.Allowed || decision.DeniedBy != "" {
+		decision.FailedConditions = nil
+	}
+	return decision
+}
+
+// CanImpersonate returns true if the user is allowed to act as the user with the given
+// name in the given request context.
+func CanImpersonate(u *types.VDIUser, target string, ctx *types.ActionContext) bool {
+	return EvaluateUser(u, &types.APIAction{
+		Verb:         rbacv1.VerbImpersonate,
+		ResourceType: rbacv1.ResourceUsers,
+		ResourceName: target,
+		Context:      ctx,
+	})
 }
 
 // EvaluateRole iterates all the rules in the given role role and returns true if any of them
-// allow the provided action.
+// allow the provided action and none of them deny it.
 func EvaluateRole(r *types.VDIUserRole, action *types.APIAction) bool {
-	for _, rule := range r.Rules {
-		if ok := EvaluateRule(rule, action); ok {
-			return true
-		}
-	}
-	return false
+	return decide([]*types.VDIUserRole{r}, action).Allowed
 }
 
-// EvaluateRule checks if the given rule allows the given action. First the verb is matched,
-// then the resource type, and then optionally a name and namespace.
+// EvaluateRule checks if the given rule allows the given action. Deny rules never allow
+// an action.
 func EvaluateRule(r rbacv1.Rule, action *types.APIAction) bool {
-	if action.ResourceType == rbacv1.ResourceServiceAccounts && action.ResourceName == "default" {
-		// Treat default service accounts as just checking the ability to launch templates
-		// in the given namespace
-		action.ResourceName = ""
-		action.ResourceType = rbacv1.ResourceTemplates
+	return !r.IsDeny() && RuleMatches(r, action)
+}
+
+// RuleMatches checks if the given rule applies to the given action, regardless of its effect.
+// First the verb is matched, then the resource type, then optionally a name and namespace,
+// and finally any conditions on the rule.
+func RuleMatches(r rbacv1.Rule, action *types.APIAction) bool {
+	return ruleMatchesAction(r, action) && ruleConditionsError(r, action.GetContext()) == nil
+}
+
+// ruleConditionsError returns an error describing the first condition of the rule that
+// is not met in the given context.
+func ruleConditionsError(r rbacv1.Rule, ctx *types.ActionContext) error {
+	if r.Conditions == nil {
+		return nil
 	}
+	conditions := r.Conditions
+	if r.IsDeny() && ctx.SourceIP == nil && len(conditions.SourceCIDRs) > 0 {
+		// Deny rules apply when the client address is unknown
+		conditions = conditions.DeepCopy()
+		conditions.SourceCIDRs = nil
+	}
+	return conditions.Evaluate(ctx.Time, ctx.SourceIP)
+}
+
+// ruleMatchesAction checks if the verb, resource type, name and namespace of the given
+// action match the rule.
+func ruleMatchesAction(r rbacv1.Rule, action *types.APIAction) bool {
+	normalizeAction(action)
 	if !r.HasVerb(action.Verb) {
 		return false
 	}
 	if !r.HasResourceType(action.ResourceType) {
 		return false
 	}
+	if r.IsDeny() {
+		// Deny rules are narrowed by their patterns and namespaces, and apply to
+		// everything when they have none.
+		if len(r.ResourcePatterns) > 0 && !r.MatchesResourceName(action.ResourceName) {
+			return false
+		}
+		if len(r.Namespaces) > 0 && !r.HasNamespace(action.ResourceNamespace) {
+			return false
+		}
+		return true
+	}
 	if action.ResourceName != "" && !r.MatchesResourceName(action.ResourceName) {
 		return false
 	}
@@ -71,3 +177,13 @@ func EvaluateRule(r rbacv1.Rule, action *types.APIAction) bool {
 	}
 	return true
 }
+
+// normalizeAction rewrites actions that are evaluated as a different action.
+func normalizeAction(action *types.APIAction) {
+	if action.ResourceType == rbacv1.ResourceServiceAccounts && action.ResourceName == "default" {
+		// Treat default service accounts as just checking the ability to launch templates
+		// in the given namespace
+		action.ResourceName = ""
+		action.ResourceType = rbacv1.ResourceTemplates
+	}
+}
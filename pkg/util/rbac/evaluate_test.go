@@ -0,0 +1,323 @@
+/*
+
+Copyright 2020,2021 Avi Zimmerman
+
+This file is part of kvdi.
+
+kvdi is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+kvdi is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package rbac
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	rbacv1 "github.com/kvdi/kvdi/apis/rbac/v1"
+	"github.com/kvdi/kvdi/pkg/types"
+)
+
+func TestCanImpersonate(t *testing.T) {
+	user := &types.VDIUser{
+		Name: "support",
+		Roles: []*types.VDIUserRole{
+			{
+				Name: "support",
+				Rules: []rbacv1.Rule{
+					{
+						Verbs:            []rbacv1.Verb{rbacv1.VerbRead, rbacv1.VerbImpersonate},
+						Resources:        []rbacv1.Resource{rbacv1.ResourceUsers},
+						ResourcePatterns: []string{"^dev-.*$"},
+					},
+				},
+			},
+		},
+	}
+	if !CanImpersonate(user, "dev-alice", nil) {
+		t.Error("Expected to be able to impersonate a matching user")
+	}
+	if CanImpersonate(user, "admin", nil) {
+		t.Error("Expected not to be able to impersonate a user outside the patterns")
+	}
+
+	admin := &types.VDIUser{
+		Name: "admin",
+		Roles: []*types.VDIUserRole{
+			{
+				Name: "admin",
+				Rules: []rbacv1.Rule{
+					{
+						Verbs:            []rbacv1.Verb{rbacv1.VerbAll},
+						Resources:        []rbacv1.Resource{rbacv1.ResourceAll},
+						ResourcePatterns: []string{".*"},
+					},
+				},
+			},
+		},
+	}
+	if !CanImpersonate(admin, "anyone", nil) {
+		t.Error("Expected the all verb to include impersonation")
+	}
+
+	reader := &types.VDIUser{
+		Name: "reader",
+		Roles: []*types.VDIUserRole{
+			{
+				Name: "reader",
+				Rules: []rbacv1.Rule{
+					{
+						Verbs:            []rbacv1.Verb{rbacv1.VerbRead},
+						Resources:        []rbacv1.Resource{rbacv1.ResourceUsers},
+						ResourcePatterns: []string{".*"},
+					},
+				},
+			},
+		},
+	}
+	if CanImpersonate(reader, "anyone", nil) {
+		t.Error("Expected reading users not to allow impersonating them")
+	}
+}
+
+func TestEvaluateDenyRules(t *testing.T) {
+	user := &types.VDIUser{
+		Name: "dev",
+		Roles: []*types.VDIUserRole{
+			{
+				Name: "templates",
+				Rules: []rbacv1.Rule{
+					{
+						Verbs:            []rbacv1.Verb{rbacv1.VerbRead, rbacv1.VerbLaunch},
+						Resources:        []rbacv1.Resource{rbacv1.ResourceTemplates},
+						ResourcePatterns: []string{".*"},
+						Namespaces:       []string{rbacv1.NamespaceAll},
+					},
+				},
+			},
+			{
+				Name: "restrictions",
+				Rules: []rbacv1.Rule{
+					{
+						Effect:           rbacv1.EffectDeny,
+						Verbs:            []rbacv1.Verb{rbacv1.VerbLaunch},
+						Resources:        []rbacv1.Resource{rbacv1.ResourceTemplates},
+						ResourcePatterns: []string{"^privileged-.*$"},
+					},
+					{
+						Effect:     rbacv1.EffectDeny,
+						Verbs:      []rbacv1.Verb{rbacv1.VerbAll},
+						Resources:  []rbacv1.Resource{rbacv1.ResourceTemplates},
+						Namespaces: []string{"kube-system"},
+					},
+				},
+			},
+		},
+	}
+
+	tcs := []struct {
+		action  types.APIAction
+		allowed bool
+	}{
+		{types.APIAction{Verb: rbacv1.VerbLaunch, ResourceType: rbacv1.ResourceTemplates, ResourceName: "ubuntu"}, true},
+		{types.APIAction{Verb: rbacv1.VerbLaunch, ResourceType: rbacv1.ResourceTemplates, ResourceName: "privileged-ubuntu"}, false},
+		{types.APIAction{Verb: rbacv1.VerbRead, ResourceType: rbacv1.ResourceTemplates, ResourceName: "privileged-ubuntu"}, true},
+		// listing is not denied by a rule that only names some templates
+		{types.APIAction{Verb: rbacv1.VerbLaunch, ResourceType: rbacv1.ResourceTemplates}, true},
+		{types.APIAction{Verb: rbacv1.VerbLaunch, ResourceType: rbacv1.ResourceTemplates, ResourceNamespace: "default"}, true},
+		{types.APIAction{Verb: rbacv1.VerbLaunch, ResourceType: rbacv1.ResourceTemplates, ResourceNamespace: "kube-system"}, false},
+		{types.APIAction{Verb: rbacv1.VerbLaunch, ResourceType: rbacv1.ResourceTemplates, ResourceName: "ubuntu", ResourceNamespace: "kube-system"}, false},
+	}
+	for _, tc := range tcs {
+		action := tc.action
+		if allowed := EvaluateUser(user, &action); allowed != tc.allowed {
+			t.Errorf("Expected %+v to be allowed=%v, got %v", tc.action, tc.allowed, allowed)
+		}
+	}
+
+	// the deny rules alone do not allow anything
+	if EvaluateRole(user.Roles[1], &types.APIAction{Verb: rbacv1.VerbRead, ResourceType: rbacv1.ResourceUsers, ResourceName: "dev"}) {
+		t.Error("Expected a role with only deny rules to allow nothing")
+	}
+	if EvaluateRule(user.Roles[1].Rules[1], &types.APIAction{Verb: rbacv1.VerbRead, ResourceType: rbacv1.ResourceTemplates, ResourceNamespace: "kube-system"}) {
+		t.Error("Expected a deny rule to never allow an action")
+	}
+
+	// a deny rule with patterns matching an empty string also denies listing
+	user.Roles[1].Rules[0].ResourcePatterns = []string{".*"}
+	if EvaluateUser(user, &types.APIAction{Verb: rbacv1.VerbLaunch, ResourceType: rbacv1.ResourceTemplates}) {
+		t.Error("Expected listing to be denied")
+	}
+}
+
+func TestEvaluateConditions(t *testing.T) {
+	businessHours := &rbacv1.RuleConditions{
+		TimeWindows: []rbacv1.TimeWindow{{
+			Days:     []rbacv1.Weekday{rbacv1.Monday, rbacv1.Tuesday, rbacv1.Wednesday, rbacv1.Thursday, rbacv1.Friday},
+			Start:    "09:00",
+			End:      "17:00",
+			TimeZone: "Europe/Berlin",
+		}},
+		SourceCIDRs: []string{"10.8.0.0/16"},
+	}
+	user := &types.VDIUser{
+		Name: "contractor",
+		Roles: []*types.VDIUserRole{
+			{
+				Name: "contractors",
+				Rules: []rbacv1.Rule{
+					{
+						Verbs:            []rbacv1.Verb{rbacv1.VerbLaunch},
+						Resources:        []rbacv1.Resource{rbacv1.ResourceTemplates},
+						ResourcePatterns: []string{".*"},
+						Conditions:       businessHours,
+					},
+				},
+			},
+		},
+	}
+
+	// Monday 2023-06-05, Berlin is UTC+2
+	monday := time.Date(2023, time.June, 5, 8, 30, 0, 0, time.UTC)
+	saturday := time.Date(2023, time.June, 10, 8, 30, 0, 0, time.UTC)
+	office := net.ParseIP("10.8.1.20")
+	home := net.ParseIP("192.168.1.20")
+
+	tcs := []struct {
+		name    string
+		ctx     *types.ActionContext
+		allowed bool
+		reason  string
+	}{
+		{"in hours from the office", &types.ActionContext{Time: monday, SourceIP: office}, true, ""},
+		{"before hours", &types.ActionContext{Time: monday.Add(-2 * time.Hour), SourceIP: office}, false, "outside of the time windows"},
+		{"after hours", &types.ActionContext{Time: monday.Add(7 * time.Hour), SourceIP: office}, false, "outside of the time windows"},
+		{"on the weekend", &types.ActionContext{Time: saturday, SourceIP: office}, false, "outside of the time windows"},
+		{"from home", &types.ActionContext{Time: monday, SourceIP: home}, false, "192.168.1.20 is not in 10.8.0.0/16"},
+		{"from an unknown address", &types.ActionContext{Time: monday}, false, "client address is unknown"},
+	}
+	for _, tc := range tcs {
+		decision := DecideUser(user, &types.APIAction{
+			Verb:         rbacv1.VerbLaunch,
+			ResourceType: rbacv1.ResourceTemplates,
+			ResourceName: "ubuntu",
+			Context:      tc.ctx,
+		})
+		if decision.Allowed != tc.allowed {
+			t.Errorf("%s: expected allowed=%v, got %v", tc.name, tc.allowed, decision.Allowed)
+		}
+		if tc.allowed {
+			continue
+		}
+		if len(decision.FailedConditions) != 1 || !strings.Contains(decision.FailedConditions[0], tc.reason) {
+			t.Errorf("%s: expected a failed condition containing %q, got %v", tc.name, tc.reason, decision.FailedConditions)
+		}
+		if !strings.Contains(decision.Reason(), "role contractors") {
+			t.Errorf("%s: expected the reason to name the role, got %q", tc.name, decision.Reason())
+		}
+	}
+
+	// deny rules with a source condition apply when the address is unknown
+	user.Roles[0].Rules = append(user.Roles[0].Rules, rbacv1.Rule{
+		Effect:     rbacv1.EffectDeny,
+		Verbs:      []rbacv1.Verb{rbacv1.VerbLaunch},
+		Resources:  []rbacv1.Resource{rbacv1.ResourceTemplates},
+		Conditions: &rbacv1.RuleConditions{SourceCIDRs: []string{"10.8.99.0/24"}},
+	})
+	user.Roles[0].Rules[0].Conditions = nil
+	for ip, allowed := range map[string]bool{"10.8.1.20": true, "10.8.99.20": false, "": false} {
+		action := &types.APIAction{
+			Verb:         rbacv1.VerbLaunch,
+			ResourceType: rbacv1.ResourceTemplates,
+			ResourceName: "ubuntu",
+			Context:      &types.ActionContext{Time: monday, SourceIP: net.ParseIP(ip)},
+		}
+		if got := EvaluateUser(user, action); got != allowed {
+			t.Errorf("Expected launching from %q to be allowed=%v, got %v", ip, allowed, got)
+		}
+	}
+}
+
+func TestTimeWindowContains(t *testing.T) {
+	overnight := rbacv1.TimeWindow{Days: []rbacv1.Weekday{rbacv1.Friday}, Start: "22:00", End: "06:00"}
+	tcs := []struct {
+		time     time.Time
+		contains bool
+	}{
+		{time.Date(2023, time.June, 9, 23, 0, 0, 0, time.UTC), true},   // Friday night
+		{time.Date(2023, time.June, 10, 5, 59, 0, 0, time.UTC), true},  // Saturday morning
+		{time.Date(2023, time.June, 10, 6, 0, 0, 0, time.UTC), false},  // end is not included
+		{time.Date(2023, time.June, 10, 23, 0, 0, 0, time.UTC), false}, // Saturday night
+		{time.Date(2023, time.June, 9, 5, 0, 0, 0, time.UTC), false},   // Friday morning
+	}
+	for _, tc := range tcs {
+		if contains := overnight.Contains(tc.time); contains != tc.contains {
+			t.Errorf("Expected %s to be contained=%v, got %v", tc.time, tc.contains, contains)
+		}
+	}
+
+	allDay := rbacv1.TimeWindow{}
+	if !allDay.Contains(time.Date(2023, time.June, 9, 23, 59, 0, 0, time.UTC)) {
+		t.Error("Expected an empty window to contain every time")
+	}
+	invalid := rbacv1.TimeWindow{TimeZone: "Not/AZone"}
+	if invalid.Contains(time.Now()) || invalid.Validate() == nil {
+		t.Error("Expected a window with an invalid time zone to be invalid")
+	}
+}
+
+func TestVDIRoleToUserRoleInvalidConditions(t *testing.T) {
+	invalid := &rbacv1.RuleConditions{SourceCIDRs: []string{"not-a-cidr"}}
+	role := &rbacv1.VDIRole{
+		Rules: []rbacv1.Rule{
+			{
+				Verbs:      []rbacv1.Verb{rbacv1.VerbLaunch},
+				Resources:  []rbacv1.Resource{rbacv1.ResourceTemplates},
+				Conditions: invalid,
+			},
+			{
+				Verbs:     []rbacv1.Verb{rbacv1.VerbRead},
+				Resources: []rbacv1.Resource{rbacv1.ResourceTemplates},
+			},
+			{
+				Effect:     rbacv1.EffectDeny,
+				Verbs:      []rbacv1.Verb{rbacv1.VerbRead},
+				Resources:  []rbacv1.Resource{rbacv1.ResourceTemplates},
+				Conditions: &rbacv1.RuleConditions{TimeWindows: []rbacv1.TimeWindow{{TimeZone: "Not/AZone"}}},
+			},
+		},
+	}
+	role.SetName("broken")
+	userRole := VDIRoleToUserRole(role)
+	if len(userRole.Rules) != 2 {
+		t.Fatalf("Expected the allow rule with invalid conditions to be dropped, got %+v", userRole.Rules)
+	}
+	if !userRole.Rules[1].IsDeny() || userRole.Rules[1].Conditions != nil {
+		t.Errorf("Expected the deny rule to apply unconditionally, got %+v", userRole.Rules[1])
+	}
+	if len(role.Rules) != 3 || role.Rules[2].Conditions == nil {
+		t.Error("Expected the original role to be left unchanged")
+	}
+	action := &types.APIAction{
+		Verb:         rbacv1.VerbRead,
+		ResourceType: rbacv1.ResourceTemplates,
+		ResourceName: "ubuntu",
+		Context:      &types.ActionContext{Time: time.Now()},
+	}
+	if EvaluateRole(userRole, action) {
+		t.Error("Expected the deny rule with invalid conditions to deny reads")
+	}
+}
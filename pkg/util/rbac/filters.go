@@ -28,14 +28,15 @@ import (
 )
 
 // FilterTemplates will take a list of DesktopTemplates and filter them based
-// off which ones the user is allowed to use.
-func FilterTemplates(u *types.VDIUser, tmpls []*desktopsv1.Template) []*desktopsv1.Template {
+// off which ones the user is allowed to use in the given request context.
+func FilterTemplates(u *types.VDIUser, tmpls []*desktopsv1.Template, ctx *types.ActionContext) []*desktopsv1.Template {
 	filtered := make([]*desktopsv1.Template, 0)
 	for _, tmpl := range tmpls {
 		action := &types.APIAction{
 			Verb:         rbacv1.VerbLaunch,
 			ResourceType: rbacv1.ResourceTemplates,
 			ResourceName: tmpl.GetName(),
+			Context:      ctx,
 		}
 		if EvaluateUser(u, action) {
 			filtered = append(filtered, tmpl)
@@ -45,14 +46,15 @@ func FilterTemplates(u *types.VDIUser, tmpls []*desktopsv1.Template) []*desktops
 }
 
 // FilterUserNamespaces will take a list of namespaces and filter them based off
-// the ones this user can provision desktops in.
-func FilterUserNamespaces(u *types.VDIUser, nss []string) []string {
+// the ones this user can provision desktops in in the given request context.
+func FilterUserNamespaces(u *types.VDIUser, nss []string, ctx *types.ActionContext) []string {
 	filtered := make([]string, 0)
 	for _, ns := range nss {
 		action := &types.APIAction{
 			Verb:              rbacv1.VerbLaunch,
 			ResourceType:      rbacv1.ResourceTemplates,
 			ResourceNamespace: ns,
+			Context:           ctx,
 		}
 		if EvaluateUser(u, action) {
 			filtered = append(filtered, ns)
@@ -62,8 +64,9 @@ func FilterUserNamespaces(u *types.VDIUser, nss []string) []string {
 }
 
 // FilterUserServiceAccounts will take a list of service accounts and a given namespace,
-// and filter them based off the ones this user can assume with desktops.
-func FilterUserServiceAccounts(u *types.VDIUser, sas []string, ns string) []string {
+// and filter them based off the ones this user can assume with desktops in the given
+// request context.
+func FilterUserServiceAccounts(u *types.VDIUser, sas []string, ns string, ctx *types.ActionContext) []string {
 	filtered := make([]string, 0)
 	for _, sa := range sas {
 		action := &types.APIAction{
@@ -71,6 +74,7 @@ func FilterUserServiceAccounts(u *types.VDIUser, sas []string, ns string) []stri
 			ResourceType:      rbacv1.ResourceServiceAccounts,
 			ResourceName:      sa,
 			ResourceNamespace: ns,
+			Context:           ctx,
 		}
 		if EvaluateUser(u, action) {
 			filtered = append(filtered, sa)
@@ -78,3 +82,25 @@ func FilterUserServiceAccounts(u *types.VDIUser, sas []string, ns string) []stri
 	}
 	return filtered
 }
+
+// RestrictUserRoles returns the user's roles with their rules replaced by those of
+// the provided rules that each role includes. Role names are kept so that settings
+// tied to the roles still apply, and so are the deny rules of each role.
+func RestrictUserRoles(u *types.VDIUser, rules []rbacv1.Rule, resourceGetter types.ResourceGetter) []*types.VDIUserRole {
+	restricted := make([]*types.VDIUserRole, 0, len(u.Roles))
+	for _, role := range u.Roles {
+		roleRules := make([]rbacv1.Rule, 0)
+		for _, rule := range role.Rules {
+			if rule.IsDeny() {
+				roleRules = append(roleRules, rule)
+			}
+		}
+		for _, rule := range rules {
+			if RoleIncludesRule(role, rule, resourceGetter) {
+				roleRules = append(roleRules, rule)
+			}
+		}
+		restricted = append(restricted, &types.VDIUserRole{Name: role.Name, Rules: roleRules})
+	}
+	return restricted
+}
@@ -0,0 +1,66 @@
+/*
+
+Copyright 2020,2021 Avi Zimmerman
+
+This file is part of kvdi.
+
+kvdi is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+kvdi is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package rbac
+
+import (
+	"fmt"
+	"regexp"
+
+	rbacv1 "github.com/kvdi/kvdi/apis/rbac/v1"
+	"github.com/kvdi/kvdi/pkg/types"
+)
+
+// Review evaluates the given action against the user's roles and returns the decision
+// along with the rules that matched it. When the action is not allowed and no rule
+// denies it, the result contains a rule that would allow it.
+func Review(u *types.VDIUser, action types.APIAction) *types.AccessReviewResult {
+	// Evaluate a copy, since some actions are rewritten during evaluation
+	evaluated := action
+	decision := DecideUser(u, &evaluated)
+	result := &types.AccessReviewResult{
+		Action:       action,
+		Allowed:      decision.Allowed,
+		Reason:       decision.Reason(),
+		DeniedBy:     decision.DeniedBy,
+		MatchedRules: decision.MatchedRules,
+	}
+	if !decision.Allowed && decision.DeniedBy == "" {
+		result.MissingRule = MissingRule(&evaluated)
+	}
+	return result
+}
+
+// MissingRule returns the narrowest rule that allows the given action.
+func MissingRule(action *types.APIAction) *rbacv1.Rule {
+	normalizeAction(action)
+	rule := &rbacv1.Rule{
+		Verbs:     []rbacv1.Verb{action.Verb},
+		Resources: []rbacv1.Resource{action.ResourceType},
+	}
+	if action.ResourceName != "" {
+		rule.ResourcePatterns = []string{fmt.Sprintf("^%s$", regexp.QuoteMeta(action.ResourceName))}
+	}
+	if action.ResourceNamespace != "" {
+		rule.Namespaces = []string{action.ResourceNamespace}
+	}
+	return rule
+}
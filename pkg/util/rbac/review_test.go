@@ -0,0 +1,113 @@
+/*
+
+Copyright 2020,2021 Avi Zimmerman
+
+This file is part of kvdi.
+
+kvdi is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+kvdi is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package rbac
+
+import (
+	"testing"
+
+	rbacv1 "github.com/kvdi/kvdi/apis/rbac/v1"
+	"github.com/kvdi/kvdi/pkg/types"
+)
+
+func TestReview(t *testing.T) {
+	user := &types.VDIUser{
+		Name: "dev",
+		Roles: []*types.VDIUserRole{
+			{
+				Name: "developers",
+				Rules: []rbacv1.Rule{
+					{
+						Verbs:            []rbacv1.Verb{rbacv1.VerbRead, rbacv1.VerbLaunch},
+						Resources:        []rbacv1.Resource{rbacv1.ResourceTemplates},
+						ResourcePatterns: []string{".*"},
+						Namespaces:       []string{"dev"},
+					},
+				},
+			},
+			{
+				Name: "no-windows",
+				Rules: []rbacv1.Rule{
+					{
+						Effect:           rbacv1.EffectDeny,
+						Verbs:            []rbacv1.Verb{rbacv1.VerbLaunch},
+						Resources:        []rbacv1.Resource{rbacv1.ResourceTemplates},
+						ResourcePatterns: []string{"^windows-.*"},
+					},
+				},
+			},
+		},
+	}
+
+	// allowed by the developers role
+	result := Review(user, types.APIAction{
+		Verb:              rbacv1.VerbLaunch,
+		ResourceType:      rbacv1.ResourceTemplates,
+		ResourceName:      "ubuntu",
+		ResourceNamespace: "dev",
+	})
+	if !result.Allowed || result.MissingRule != nil {
+		t.Fatalf("expected the action to be allowed without a missing rule, got %+v", result)
+	}
+	if len(result.MatchedRules) != 1 || result.MatchedRules[0].Role != "developers" || !result.MatchedRules[0].Applied {
+		t.Errorf("expected the developers rule to be matched, got %+v", result.MatchedRules)
+	}
+
+	// denied by the no-windows role, which is reported along with the allow rule
+	result = Review(user, types.APIAction{
+		Verb:              rbacv1.VerbLaunch,
+		ResourceType:      rbacv1.ResourceTemplates,
+		ResourceName:      "windows-10",
+		ResourceNamespace: "dev",
+	})
+	if result.Allowed || result.DeniedBy != "no-windows" || result.MissingRule != nil {
+		t.Fatalf("expected the action to be denied by no-windows, got %+v", result)
+	}
+	if len(result.MatchedRules) != 2 {
+		t.Errorf("expected both rules to be matched, got %+v", result.MatchedRules)
+	}
+
+	// not allowed in another namespace, the missing rule allows exactly the action
+	action := types.APIAction{
+		Verb:              rbacv1.VerbLaunch,
+		ResourceType:      rbacv1.ResourceTemplates,
+		ResourceName:      "ubuntu.lts",
+		ResourceNamespace: "prod",
+	}
+	result = Review(user, action)
+	if result.Allowed || result.DeniedBy != "" || len(result.MatchedRules) != 0 {
+		t.Fatalf("expected the action to not match any rules, got %+v", result)
+	}
+	if result.MissingRule == nil {
+		t.Fatal("expected a missing rule")
+	}
+	if !EvaluateRule(*result.MissingRule, &action) {
+		t.Error("expected the missing rule to allow the action")
+	}
+	if EvaluateRule(*result.MissingRule, &types.APIAction{
+		Verb:              rbacv1.VerbLaunch,
+		ResourceType:      rbacv1.ResourceTemplates,
+		ResourceName:      "ubuntu-lts",
+		ResourceNamespace: "prod",
+	}) {
+		t.Error("expected the missing rule to only match the given resource name")
+	}
+}
@@ -22,13 +22,55 @@ along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
 package rbac
 
 import (
+	"reflect"
+
 	rbacv1 "github.com/kvdi/kvdi/apis/rbac/v1"
 	"github.com/kvdi/kvdi/pkg/types"
 )
 
+// UserIncludesRules returns true if granting the provided rules together would not give
+// any permissions the user does not have. On top of every allowed action being allowed by
+// the user's roles, an allow rule that overlaps a deny rule of the user must be granted
+// alongside a deny rule that covers it.
+func UserIncludesRules(u *types.VDIUser, rulesToCheck []rbacv1.Rule, resourceGetter types.ResourceGetter) bool {
+	for _, rule := range rulesToCheck {
+		if rule.IsDeny() {
+			continue
+		}
+		if !UserIncludesRule(u, rule, resourceGetter) {
+			return false
+		}
+		for _, role := range u.Roles {
+			for _, deny := range role.Rules {
+				if !deny.IsDeny() || !RulesOverlap(rule, deny, resourceGetter) {
+					continue
+				}
+				if !anyRuleDenies(rulesToCheck, deny, resourceGetter) {
+					return false
+				}
+			}
+		}
+	}
+	return true
+}
+
+func anyRuleDenies(rules []rbacv1.Rule, deny rbacv1.Rule, resourceGetter types.ResourceGetter) bool {
+	for _, rule := range rules {
+		if rule.IsDeny() && DenyRuleIncludes(rule, deny, resourceGetter) {
+			return true
+		}
+	}
+	return false
+}
+
 // UserIncludesRule returns true if the rules applied to this user are not elevated
-// by any of the permissions in the provided rule.
+// by any of the permissions in the provided rule. Deny rules never elevate permissions.
+// The user's own deny rules are not considered, see UserIncludesRules for granting rules
+// to other users or roles.
 func UserIncludesRule(u *types.VDIUser, ruleToCheck rbacv1.Rule, resourceGetter types.ResourceGetter) bool {
+	if ruleToCheck.IsDeny() {
+		return true
+	}
 	for _, role := range u.Roles {
 		if ok := RoleIncludesRule(role, ruleToCheck, resourceGetter); ok {
 			return true
@@ -38,8 +80,11 @@ func UserIncludesRule(u *types.VDIUser, ruleToCheck rbacv1.Rule, resourceGetter
 }
 
 // RoleIncludesRule returns true if the rules applied to this role are not elevated
-// by any of the permissions in the provided rule.
+// by any of the permissions in the provided rule. Deny rules never elevate permissions.
 func RoleIncludesRule(r *types.VDIUserRole, ruleToCheck rbacv1.Rule, resourceGetter types.ResourceGetter) bool {
+	if ruleToCheck.IsDeny() {
+		return true
+	}
 	for _, rule := range r.Rules {
 		if ok := RuleIncludes(rule, ruleToCheck, resourceGetter); ok {
 			return true
@@ -49,11 +94,22 @@ func RoleIncludesRule(r *types.VDIUserRole, ruleToCheck rbacv1.Rule, resourceGet
 }
 
 // RuleIncludes returns false if ruleToCheck matches any actions or resources that r does not.
+// A deny ruleToCheck is always included, while a deny r never includes an allow rule. If r has
+// conditions, ruleToCheck must have the same ones.
 func RuleIncludes(r, ruleToCheck rbacv1.Rule, resourceGetter types.ResourceGetter) bool {
 
+	if ruleToCheck.IsDeny() {
+		return true
+	}
+	if r.IsDeny() {
+		return false
+	}
 	if r.DeepEqual(ruleToCheck) {
 		return true
 	}
+	if r.Conditions != nil && !reflect.DeepEqual(r.Conditions, ruleToCheck.Conditions) {
+		return false
+	}
 
 	for _, verb := range ruleToCheck.Verbs {
 		if !r.HasVerb(verb) {
@@ -114,3 +170,185 @@ func RuleIncludes(r, ruleToCheck rbacv1.Rule, resourceGetter types.ResourceGette
 	}
 	return true
 }
+
+// DenyRuleIncludes returns true if the deny rule r denies every action that the deny rule
+// ruleToCheck does. If r has conditions, ruleToCheck must have the same ones.
+func DenyRuleIncludes(r, ruleToCheck rbacv1.Rule, resourceGetter types.ResourceGetter) bool {
+	if r.DeepEqual(ruleToCheck) {
+		return true
+	}
+	if r.Conditions != nil && !reflect.DeepEqual(r.Conditions, ruleToCheck.Conditions) {
+		return false
+	}
+	for _, verb := range ruleToCheck.Verbs {
+		if !r.HasVerb(verb) {
+			return false
+		}
+	}
+	for _, resource := range ruleToCheck.Resources {
+		if !r.HasResourceType(resource) {
+			return false
+		}
+	}
+	// Deny rules without namespaces or patterns apply to all of them
+	if len(r.Namespaces) > 0 {
+		if len(ruleToCheck.Namespaces) == 0 {
+			return false
+		}
+		for _, ns := range ruleToCheck.Namespaces {
+			if !r.HasNamespace(ns) {
+				return false
+			}
+		}
+	}
+	if len(r.ResourcePatterns) > 0 {
+		if len(ruleToCheck.ResourcePatterns) == 0 {
+			return false
+		}
+		if ruleToCheck.MatchesResourceName("") && !r.MatchesResourceName("") {
+			return false
+		}
+		for _, resource := range expandResources(ruleToCheck.Resources) {
+			if resource == rbacv1.ResourceServiceAccounts {
+				// Service accounts cannot be listed, so require the same patterns
+				if !containsAll(r.ResourcePatterns, ruleToCheck.ResourcePatterns) {
+					return false
+				}
+				continue
+			}
+			names, err := resourceNames(resource, resourceGetter)
+			if err != nil {
+				return false
+			}
+			for _, name := range names {
+				if ruleToCheck.MatchesResourceName(name) && !r.MatchesResourceName(name) {
+					return false
+				}
+			}
+		}
+	}
+	return true
+}
+
+// RulesOverlap returns true if the deny rule matches any of the actions allowed by the
+// allow rule. Like RuleIncludes, resource patterns are compared against the resources that
+// currently exist, and errors retrieving them are treated as an overlap. Conditions are
+// not compared, rules with conditions are assumed to overlap at some point.
+func RulesOverlap(allow, deny rbacv1.Rule, resourceGetter types.ResourceGetter) bool {
+	verbs := false
+	for _, verb := range allow.Verbs {
+		if (verb == rbacv1.VerbAll && len(deny.Verbs) > 0) || deny.HasVerb(verb) {
+			verbs = true
+			break
+		}
+	}
+	if !verbs {
+		return false
+	}
+
+	resources := make([]rbacv1.Resource, 0)
+	for _, resource := range expandResources(allow.Resources) {
+		if deny.HasResourceType(resource) {
+			resources = append(resources, resource)
+		}
+	}
+	if len(resources) == 0 {
+		return false
+	}
+
+	// The allow rule applies to actions without a namespace, which a deny rule with
+	// namespaces only matches if it includes all of them.
+	if len(deny.Namespaces) > 0 && !deny.HasNamespace(rbacv1.NamespaceAll) {
+		namespaces := false
+		for _, ns := range allow.Namespaces {
+			if ns == rbacv1.NamespaceAll || deny.HasNamespace(ns) {
+				namespaces = true
+				break
+			}
+		}
+		if !namespaces {
+			return false
+		}
+	}
+
+	// The allow rule applies to actions without a resource name regardless of its patterns.
+	if len(deny.ResourcePatterns) == 0 || deny.MatchesResourceName("") {
+		return true
+	}
+	if len(allow.ResourcePatterns) == 0 {
+		return false
+	}
+	for _, resource := range resources {
+		if resource == rbacv1.ResourceServiceAccounts {
+			// Service accounts cannot be listed, so assume they overlap
+			return true
+		}
+		names, err := resourceNames(resource, resourceGetter)
+		if err != nil {
+			return true
+		}
+		for _, name := range names {
+			if allow.MatchesResourceName(name) && deny.MatchesResourceName(name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// expandResources returns the given resources with ResourceAll replaced by every resource type.
+func expandResources(resources []rbacv1.Resource) []rbacv1.Resource {
+	for _, resource := range resources {
+		if resource == rbacv1.ResourceAll {
+			return []rbacv1.Resource{
+				rbacv1.ResourceUsers,
+				rbacv1.ResourceRoles,
+				rbacv1.ResourceTemplates,
+				rbacv1.ResourceServiceAccounts,
+			}
+		}
+	}
+	return resources
+}
+
+// resourceNames returns the names of the existing resources of the given type.
+func resourceNames(resource rbacv1.Resource, resourceGetter types.ResourceGetter) ([]string, error) {
+	names := make([]string, 0)
+	switch resource {
+	case rbacv1.ResourceRoles:
+		roles, err := resourceGetter.GetRoles()
+		if err != nil {
+			return nil, err
+		}
+		for _, role := range roles {
+			names = append(names, role.GetName())
+		}
+	case rbacv1.ResourceUsers:
+		users, err := resourceGetter.GetUsers()
+		if err != nil {
+			return nil, err
+		}
+		for _, user := range users {
+			names = append(names, user.GetName())
+		}
+	case rbacv1.ResourceTemplates:
+		return resourceGetter.GetTemplates()
+	}
+	return names, nil
+}
+
+func containsAll(ss, xx []string) bool {
+	for _, x := range xx {
+		found := false
+		for _, s := range ss {
+			if s == x {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,150 @@
+/*
+
+Copyright 2020,2021 Avi Zimmerman
+
+This file is part of kvdi.
+
+kvdi is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+kvdi is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package rbac
+
+import (
+	"testing"
+
+	rbacv1 "github.com/kvdi/kvdi/apis/rbac/v1"
+	"github.com/kvdi/kvdi/pkg/types"
+)
+
+type testResourceGetter struct{}
+
+func (testResourceGetter) GetTemplates() ([]string, error) {
+	return []string{"ubuntu", "privileged-ubuntu", "privileged-arch"}, nil
+}
+
+func (testResourceGetter) GetUsers() ([]types.VDIUser, error) {
+	return []types.VDIUser{{Name: "admin"}, {Name: "dev"}}, nil
+}
+
+func (testResourceGetter) GetRoles() ([]types.VDIUserRole, error) {
+	return []types.VDIUserRole{{Name: "kvdi-admin"}}, nil
+}
+
+var (
+	allowTemplates = rbacv1.Rule{
+		Verbs:            []rbacv1.Verb{rbacv1.VerbLaunch},
+		Resources:        []rbacv1.Resource{rbacv1.ResourceTemplates},
+		ResourcePatterns: []string{".*"},
+	}
+	allowUbuntu = rbacv1.Rule{
+		Verbs:            []rbacv1.Verb{rbacv1.VerbLaunch},
+		Resources:        []rbacv1.Resource{rbacv1.ResourceTemplates},
+		ResourcePatterns: []string{"^ubuntu$"},
+	}
+	allowUsers = rbacv1.Rule{
+		Verbs:            []rbacv1.Verb{rbacv1.VerbRead},
+		Resources:        []rbacv1.Resource{rbacv1.ResourceUsers},
+		ResourcePatterns: []string{".*"},
+	}
+	denyPrivileged = rbacv1.Rule{
+		Effect:           rbacv1.EffectDeny,
+		Verbs:            []rbacv1.Verb{rbacv1.VerbLaunch},
+		Resources:        []rbacv1.Resource{rbacv1.ResourceTemplates},
+		ResourcePatterns: []string{"^privileged-.*$"},
+	}
+	denyAllTemplates = rbacv1.Rule{
+		Effect:    rbacv1.EffectDeny,
+		Verbs:     []rbacv1.Verb{rbacv1.VerbAll},
+		Resources: []rbacv1.Resource{rbacv1.ResourceTemplates},
+	}
+	denyPrivilegedArch = rbacv1.Rule{
+		Effect:           rbacv1.EffectDeny,
+		Verbs:            []rbacv1.Verb{rbacv1.VerbLaunch},
+		Resources:        []rbacv1.Resource{rbacv1.ResourceTemplates},
+		ResourcePatterns: []string{"^privileged-arch$"},
+	}
+)
+
+func TestUserIncludesRules(t *testing.T) {
+	user := &types.VDIUser{
+		Name: "dev",
+		Roles: []*types.VDIUserRole{
+			{Name: "templates", Rules: []rbacv1.Rule{allowTemplates, allowUsers}},
+			{Name: "restrictions", Rules: []rbacv1.Rule{denyPrivileged}},
+		},
+	}
+
+	tcs := []struct {
+		name     string
+		rules    []rbacv1.Rule
+		included bool
+	}{
+		{"deny rules only", []rbacv1.Rule{denyAllTemplates}, true},
+		{"rule not overlapping a deny", []rbacv1.Rule{allowUbuntu, allowUsers}, true},
+		{"rule overlapping a deny", []rbacv1.Rule{allowTemplates}, false},
+		{"rule overlapping a covered deny", []rbacv1.Rule{allowTemplates, denyPrivileged}, true},
+		{"rule overlapping a broader deny", []rbacv1.Rule{allowTemplates, denyAllTemplates}, true},
+		{"rule overlapping a narrower deny", []rbacv1.Rule{allowTemplates, denyPrivilegedArch}, false},
+		{"rule the user does not have", []rbacv1.Rule{{
+			Verbs:            []rbacv1.Verb{rbacv1.VerbDelete},
+			Resources:        []rbacv1.Resource{rbacv1.ResourceUsers},
+			ResourcePatterns: []string{".*"},
+		}}, false},
+	}
+	for _, tc := range tcs {
+		if included := UserIncludesRules(user, tc.rules, testResourceGetter{}); included != tc.included {
+			t.Errorf("%s: expected included=%v, got %v", tc.name, tc.included, included)
+		}
+	}
+
+	// a deny rule never includes an allow rule
+	if RuleIncludes(denyAllTemplates, allowUbuntu, testResourceGetter{}) {
+		t.Error("Expected a deny rule not to include an allow rule")
+	}
+}
+
+func TestUserIncludesRulesWithConditions(t *testing.T) {
+	conditional := allowTemplates
+	conditional.Conditions = &rbacv1.RuleConditions{SourceCIDRs: []string{"10.8.0.0/16"}}
+	user := &types.VDIUser{
+		Name:  "contractor",
+		Roles: []*types.VDIUserRole{{Name: "contractors", Rules: []rbacv1.Rule{conditional}}},
+	}
+	if UserIncludesRules(user, []rbacv1.Rule{allowUbuntu}, testResourceGetter{}) {
+		t.Error("Expected a rule without the user's conditions not to be included")
+	}
+	allowUbuntuConditional := allowUbuntu
+	allowUbuntuConditional.Conditions = conditional.Conditions.DeepCopy()
+	if !UserIncludesRules(user, []rbacv1.Rule{allowUbuntuConditional}, testResourceGetter{}) {
+		t.Error("Expected a narrower rule with the same conditions to be included")
+	}
+}
+
+func TestRestrictUserRolesKeepsDenyRules(t *testing.T) {
+	user := &types.VDIUser{
+		Name: "dev",
+		Roles: []*types.VDIUserRole{
+			{Name: "templates", Rules: []rbacv1.Rule{allowTemplates, denyPrivileged}},
+		},
+	}
+	roles := RestrictUserRoles(user, []rbacv1.Rule{allowTemplates}, testResourceGetter{})
+	restricted := &types.VDIUser{Name: "dev", Roles: roles}
+	if EvaluateUser(restricted, &types.APIAction{Verb: rbacv1.VerbLaunch, ResourceType: rbacv1.ResourceTemplates, ResourceName: "privileged-ubuntu"}) {
+		t.Error("Expected the deny rule to still apply to the restricted roles")
+	}
+	if !EvaluateUser(restricted, &types.APIAction{Verb: rbacv1.VerbLaunch, ResourceType: rbacv1.ResourceTemplates, ResourceName: "ubuntu"}) {
+		t.Error("Expected the allowed template to still be allowed")
+	}
+}
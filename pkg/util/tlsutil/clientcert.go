@@ -0,0 +1,65 @@
+/*
+
+Copyright 2020,2021 Avi Zimmerman
+
+This file is part of kvdi.
+
+kvdi is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+kvdi is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package tlsutil
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+)
+
+// NewAppServerTLSConfig returns the TLS configuration for the app server listener.
+// When requestClientCerts is true, clients are asked for a certificate but are not
+// required to present one. Presented certificates are not verified by the listener,
+// it is up to the consumer to do so with VerifyClientCertificate.
+func NewAppServerTLSConfig(requestClientCerts bool) *tls.Config {
+	tlsConfig := &tls.Config{}
+	if requestClientCerts {
+		tlsConfig.ClientAuth = tls.RequestClientCert
+	}
+	return tlsConfig
+}
+
+// VerifyClientCertificate verifies the certificate chain presented by a client against
+// the given PEM encoded certificate authorities. Any certificates in the chain after the
+// first are used as intermediates. The verified client certificate is returned.
+func VerifyClientCertificate(chain []*x509.Certificate, caBundle []byte) (*x509.Certificate, error) {
+	if len(chain) == 0 {
+		return nil, errors.New("No client certificate presented")
+	}
+	roots := x509.NewCertPool()
+	if ok := roots.AppendCertsFromPEM(caBundle); !ok {
+		return nil, errors.New("Failed to create CA cert pool")
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range chain[1:] {
+		intermediates.AddCert(cert)
+	}
+	if _, err := chain[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}); err != nil {
+		return nil, err
+	}
+	return chain[0], nil
+}
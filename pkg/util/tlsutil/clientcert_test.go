@@ -0,0 +1,139 @@
+/*
+
+Copyright 2020,2021 Avi Zimmerman
+
+This file is part of kvdi.
+
+kvdi is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+kvdi is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+package tlsutil
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+)
+
+// newTestCertificate creates a certificate from the given template signed by the parent,
+// or self-signed when the parent is nil.
+func newTestCertificate(t *testing.T, tmpl *x509.Certificate, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl.SerialNumber = big.NewInt(time.Now().UnixNano())
+	tmpl.NotBefore = time.Now().Add(-time.Hour)
+	tmpl.NotAfter = time.Now().Add(time.Hour)
+	if parent == nil {
+		parent, parentKey = tmpl, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+func newTestCA(t *testing.T, name string) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	return newTestCertificate(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: name},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil, nil)
+}
+
+func toPEM(certs ...*x509.Certificate) []byte {
+	out := make([]byte, 0)
+	for _, cert := range certs {
+		out = append(out, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})...)
+	}
+	return out
+}
+
+func TestNewAppServerTLSConfig(t *testing.T) {
+	if config := NewAppServerTLSConfig(false); config.ClientAuth != tls.NoClientCert {
+		t.Error("Expected NoClientCert in TLS config, got:", config.ClientAuth)
+	}
+	if config := NewAppServerTLSConfig(true); config.ClientAuth != tls.RequestClientCert {
+		t.Error("Expected RequestClientCert in TLS config, got:", config.ClientAuth)
+	}
+}
+
+func TestVerifyClientCertificate(t *testing.T) {
+	ca, caKey := newTestCA(t, "root")
+	intermediate, intermediateKey := newTestCertificate(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "intermediate"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, ca, caKey)
+	client, _ := newTestCertificate(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "workstation"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, intermediate, intermediateKey)
+	server, _ := newTestCertificate(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "server"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, ca, caKey)
+	other, otherKey := newTestCA(t, "other")
+	untrusted, _ := newTestCertificate(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "untrusted"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, other, otherKey)
+
+	// the intermediate can be presented by the client
+	cert, err := VerifyClientCertificate([]*x509.Certificate{client, intermediate}, toPEM(ca))
+	if err != nil {
+		t.Fatal("Expected no error, got:", err)
+	}
+	if cert.Subject.CommonName != "workstation" {
+		t.Error("Expected the client certificate to be returned, got:", cert.Subject.CommonName)
+	}
+
+	// or included in the bundle
+	if _, err := VerifyClientCertificate([]*x509.Certificate{client}, toPEM(ca, intermediate)); err != nil {
+		t.Error("Expected no error, got:", err)
+	}
+
+	for name, chain := range map[string][]*x509.Certificate{
+		"no certificates":    {},
+		"missing issuer":     {client},
+		"server certificate": {server},
+		"untrusted issuer":   {untrusted},
+	} {
+		if _, err := VerifyClientCertificate(chain, toPEM(ca)); err == nil {
+			t.Errorf("Expected error for %s", name)
+		}
+	}
+
+	if _, err := VerifyClientCertificate([]*x509.Certificate{client, intermediate}, []byte("not a pem")); err == nil {
+		t.Error("Expected error for invalid CA bundle")
+	}
+}
@@ -0,0 +1,90 @@
+/*
+
+   Copyright 2020,2021 Avi Zimmerman
+
+   This file is part of kvdi.
+
+   kvdi is free software: you can redistribute it and/or modify
+   it under the terms of the GNU General Public License as published by
+   the Free Software Foundation, either version 3 of the License, or
+   (at your option) any later version.
+
+   kvdi is distributed in the hope that it will be useful,
+   but WITHOUT ANY WARRANTY; without even the implied warranty of
+   MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+   GNU General Public License for more details.
+
+   You should have received a copy of the GNU General Public License
+   along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
+
+*/
+
+// The kVDI API exchanges WebAuthn binary values as base64url strings. These
+// helpers convert between them and the ArrayBuffers used by the browser.
+
+function fromBase64URL (value) {
+  const base64 = value.replace(/-/g, '+').replace(/_/g, '/')
+  const padded = base64 + '='.repeat((4 - base64.length % 4) % 4)
+  return Uint8Array.from(atob(padded), c => c.charCodeAt(0)).buffer
+}
+
+function toBase64URL (buffer) {
+  const bytes = new Uint8Array(buffer)
+  let str = ''
+  bytes.forEach((b) => { str += String.fromCharCode(b) })
+  return btoa(str).replace(/\+/g, '-').replace(/\//g, '_').replace(/=+$/, '')
+}
+
+function decodeDescriptors (descriptors) {
+  return (descriptors || []).map(cred => ({ type: cred.type, id: fromBase64URL(cred.id) }))
+}
+
+// webauthnSupported returns true if the browser supports WebAuthn.
+export function webauthnSupported () {
+  return window.PublicKeyCredential !== undefined && navigator.credentials !== undefined
+}
+
+// createCredential runs a registration ceremony with the options returned by
+// POST /api/users/{user}/mfa/webauthn and returns the body for the matching PUT.
+export async function createCredential (options, name) {
+  const cred = await navigator.credentials.create({
+    publicKey: {
+      ...options,
+      challenge: fromBase64URL(options.challenge),
+      user: { ...options.user, id: fromBase64URL(options.user.id) },
+      excludeCredentials: decodeDescriptors(options.excludeCredentials)
+    }
+  })
+  return {
+    name: name,
+    id: toBase64URL(cred.rawId),
+    response: {
+      clientDataJSON: toBase64URL(cred.response.clientDataJSON),
+      attestationObject: toBase64URL(cred.response.attestationObject)
+    }
+  }
+}
+
+// getAssertion runs an assertion ceremony with the options returned by
+// POST /api/authorize/webauthn and returns the assertion for /api/authorize.
+export async function getAssertion (options) {
+  const cred = await navigator.credentials.get({
+    publicKey: {
+      ...options,
+      challenge: fromBase64URL(options.challenge),
+      allowCredentials: decodeDescriptors(options.allowCredentials)
+    }
+  })
+  const assertion = {
+    id: toBase64URL(cred.rawId),
+    response: {
+      clientDataJSON: toBase64URL(cred.response.clientDataJSON),
+      authenticatorData: toBase64URL(cred.response.authenticatorData),
+      signature: toBase64URL(cred.response.signature)
+    }
+  }
+  if (cred.response.userHandle) {
+    assertion.response.userHandle = toBase64URL(cred.response.userHandle)
+  }
+  return assertion
+}
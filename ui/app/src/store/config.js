@@ -55,17 +55,24 @@ export const ConfigStore = new Vuex.Store({
       }
       return false
     },
-    authMethod: state => {
+    authMethods: state => {
       if (state.serverConfig.auth !== undefined) {
+        if (state.serverConfig.auth.providers !== undefined && state.serverConfig.auth.providers.length > 0) {
+          return state.serverConfig.auth.providers
+        }
         if (state.serverConfig.auth.ldapAuth !== undefined && state.serverConfig.auth.ldapAuth.URL) {
-          return 'ldap'
+          return ['ldap']
         }
         if (state.serverConfig.auth.oidcAuth !== undefined && state.serverConfig.auth.oidcAuth.IssuerURL) {
-          return 'oidc'
+          return ['oidc']
+        }
+        if (state.serverConfig.auth.samlAuth !== undefined && state.serverConfig.auth.samlAuth.acsURL) {
+          return ['saml']
         }
       }
-      return 'local'
-    }
+      return ['local']
+    },
+    authMethod: (state, getters) => getters.authMethods[0]
   }
 
 })
@@ -20,6 +20,7 @@ along with kvdi.  If not, see <https://www.gnu.org/licenses/>.
 import Vue from 'vue'
 import Vuex from 'vuex'
 import axios from 'axios'
+import { getAssertion } from '../lib/webauthn'
 
 function uuidv4 () {
   return 'xxxxxxxx-xxxx-4xxx-yxxx-xxxxxxxxxxxx'.replace(/[xy]/g, function (c) {
@@ -37,6 +38,7 @@ export const UserStore = new Vuex.Store({
     token: localStorage.getItem('token') || '',
     renewable: localStorage.getItem('renewable') === 'true' || false,
     requiresMFA: false,
+    requiresPasswordChange: false,
     user: {},
     stateToken: ''
   },
@@ -67,13 +69,20 @@ export const UserStore = new Vuex.Store({
 
       state.stateToken = ''
       state.requiresMFA = false
+      state.requiresPasswordChange = false
       localStorage.removeItem('state')
+      localStorage.removeItem('authMethod')
     },
 
     auth_need_mfa (state) {
       state.requiresMFA = true
     },
 
+    auth_need_password_change (state) {
+      state.requiresMFA = false
+      state.requiresPasswordChange = true
+    },
+
     auth_error (state) {
       state.status = 'error'
       state.user = {}
@@ -82,6 +91,7 @@ export const UserStore = new Vuex.Store({
       state.renewable = false
       localStorage.removeItem('token')
       localStorage.removeItem('state')
+      localStorage.removeItem('authMethod')
       localStorage.removeItem('renewable')
     },
 
@@ -93,6 +103,7 @@ export const UserStore = new Vuex.Store({
       state.renewable = false
       localStorage.removeItem('token')
       localStorage.removeItem('state')
+      localStorage.removeItem('authMethod')
       localStorage.removeItem('renewable')
     }
 
@@ -164,6 +175,11 @@ export const UserStore = new Vuex.Store({
       try {
         await commit('auth_request')
         credentials.state = state.stateToken
+        if (credentials.method) {
+          localStorage.setItem('authMethod', credentials.method)
+        } else if (localStorage.getItem('authMethod')) {
+          credentials.method = localStorage.getItem('authMethod')
+        }
         const res = await axios({ url: '/api/login', data: credentials, method: 'POST' })
 
         const resState = res.data.state
@@ -189,6 +205,11 @@ export const UserStore = new Vuex.Store({
           commit('auth_success', { token, renewable })
           return
         }
+        // An expired password is only changed after any second factor is verified
+        if (user.passwordChangeRequired && !user.mfa) {
+          commit('auth_need_password_change')
+          return
+        }
         commit('auth_need_mfa')
       } catch (err) {
         commit('auth_error')
@@ -226,8 +247,12 @@ export const UserStore = new Vuex.Store({
       }
     },
 
+    // authorize takes either a one-time password or an object containing a
+    // webauthn assertion.
     async authorize ({ commit, state }, otp) {
-      const res = await axios({ url: '/api/authorize', data: { otp: otp, state: state.stateToken }, method: 'POST' })
+      const data = typeof otp === 'string' ? { otp: otp } : { ...otp }
+      data.state = state.stateToken
+      const res = await axios({ url: '/api/authorize', data: data, method: 'POST' })
       const resState = res.data.state
       if (state.stateToken !== resState) {
         console.log('State token was malformed during request flow!')
@@ -238,16 +263,33 @@ export const UserStore = new Vuex.Store({
       const authorized = res.data.authorized
       const renewable = res.data.renewable
       Vue.prototype.$axios.defaults.headers.common['X-Session-Token'] = token
+      commit('auth_got_user', res.data.user)
       if (authorized) {
         commit('auth_success', { token, renewable })
+        return
       }
+      if (res.data.user.passwordChangeRequired) {
+        commit('auth_need_password_change')
+      }
+    },
+
+    async changePassword ({ state }, { currentPassword, newPassword }) {
+      await Vue.prototype.$axios.put(`/api/users/${state.user.name}/password`, { currentPassword, newPassword })
+    },
+
+    async authorizeWebAuthn ({ dispatch }) {
+      const res = await axios({ url: '/api/authorize/webauthn', method: 'POST' })
+      const assertion = await getAssertion(res.data)
+      await dispatch('authorize', { webauthn: assertion })
     },
 
     async logout ({ commit }) {
       await Vue.prototype.$desktopSessions.dispatch('clearSessions')
       commit('logout')
+      let redirect
       try {
-        await Vue.prototype.$axios.post('/api/logout')
+        const res = await Vue.prototype.$axios.post('/api/logout')
+        redirect = res.headers['x-redirect']
       } catch (err) {
         console.log(err)
         let error
@@ -264,6 +306,11 @@ export const UserStore = new Vuex.Store({
         })
       }
       delete Vue.prototype.$axios.defaults.headers.common['X-Session-Token']
+      if (redirect) {
+        // end the session at the identity provider as well
+        window.location.href = redirect
+        return
+      }
       window.location.href = '/#/login'
     }
 
@@ -272,6 +319,7 @@ export const UserStore = new Vuex.Store({
   getters: {
     isLoggedIn: state => !!state.token,
     requiresMFA: state => state.requiresMFA,
+    requiresPasswordChange: state => state.requiresPasswordChange,
     authStatus: state => state.status,
     user: state => state.user,
     token: state => state.token,